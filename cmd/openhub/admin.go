@@ -1,97 +1,456 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
 	"github.com/jeremytregunna/openhub/internal/config"
+	"github.com/jeremytregunna/openhub/internal/discovery"
 	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/logstream"
+	"github.com/jeremytregunna/openhub/internal/quota"
+	"github.com/jeremytregunna/openhub/internal/server"
 	"github.com/jeremytregunna/openhub/internal/storage"
+	"github.com/jeremytregunna/openhub/internal/tlsconfig"
 )
 
-func runAdmin(args []string) {
-	if len(args) < 1 {
-		fmt.Println("usage: openhub admin <command> [args...]")
-		fmt.Println("commands:")
-		fmt.Println("  create-repo <owner/name>")
-		fmt.Println("  delete-repo <owner/name>")
-		fmt.Println("  list-repos [owner]")
-		fmt.Println("  get-metadata <owner/name>")
-		fmt.Println("  set-description <owner/name> <description>")
-		fmt.Println("  add-replica <owner/name> <url>")
-		fmt.Println("  remove-replica <owner/name> <instance-id>")
-		fmt.Println("  list-replicas <owner/name>")
-		fmt.Println("  recovery-bundle <owner/name>")
-		os.Exit(1)
-	}
-
-	cmd := args[0]
-
-	switch cmd {
-	case "create-repo":
-		if len(args) < 2 {
-			fmt.Println("usage: openhub admin create-repo <owner/name>")
-			os.Exit(1)
-		}
-		adminCreateRepo(args[1])
-	case "delete-repo":
-		if len(args) < 2 {
-			fmt.Println("usage: openhub admin delete-repo <owner/name>")
-			os.Exit(1)
-		}
-		adminDeleteRepo(args[1])
-	case "list-repos":
-		owner := ""
-		if len(args) >= 2 {
-			owner = args[1]
-		}
-		adminListRepos(owner)
-	case "get-metadata":
-		if len(args) < 2 {
-			fmt.Println("usage: openhub admin get-metadata <owner/name>")
-			os.Exit(1)
-		}
-		adminGetMetadata(args[1])
-	case "set-description":
-		if len(args) < 3 {
-			fmt.Println("usage: openhub admin set-description <owner/name> <description>")
-			os.Exit(1)
-		}
-		adminSetDescription(args[1], args[2])
-	case "add-replica":
-		if len(args) < 3 {
-			fmt.Println("usage: openhub admin add-replica <owner/name> <url>")
-			os.Exit(1)
-		}
-		adminAddReplica(args[1], args[2])
-	case "remove-replica":
-		if len(args) < 3 {
-			fmt.Println("usage: openhub admin remove-replica <owner/name> <instance-id>")
-			os.Exit(1)
-		}
-		adminRemoveReplica(args[1], args[2])
-	case "list-replicas":
-		if len(args) < 2 {
-			fmt.Println("usage: openhub admin list-replicas <owner/name>")
-			os.Exit(1)
-		}
-		adminListReplicas(args[1])
-	case "recovery-bundle":
-		if len(args) < 2 {
-			fmt.Println("usage: openhub admin recovery-bundle <owner/name>")
-			os.Exit(1)
-		}
-		adminRecoveryBundle(args[1])
-	default:
-		fmt.Printf("unknown admin command: %s\n", cmd)
-		os.Exit(1)
+// tokenUsage documents the --token flag of commands acting for a repository
+// owner.
+const tokenUsage = "API token of the repository owner (default: $OPENHUB_TOKEN or the profile's token)"
+
+func adminCommand() *command {
+	return &command{
+		name:    "admin",
+		summary: "Admin commands",
+		subcommands: []*command{
+			{
+				name: "create-repo", args: "<owner/name>", summary: "Create a new repository",
+				setup: noFlags(func(args []string) { adminCreateRepo(args[0]) }),
+			},
+			{
+				name: "delete-repo", args: "<owner/name>", summary: "Delete a repository",
+				help: `
+Shows what will be removed, including the copies replicas are told to
+delete and registrations left behind on other instances, and asks before
+deleting.`,
+				setup: func(fs *flag.FlagSet) func([]string) {
+					dryRun := fs.Bool("dry-run", false, "show what would be removed without removing it")
+					force := fs.Bool("force", false, "don't ask for confirmation")
+					return func(args []string) { adminDeleteRepo(args[0], *dryRun, *force) }
+				},
+			},
+			{
+				name: "list-repos", args: "[owner]", summary: "List repositories",
+				setup: noFlags(func(args []string) { adminListRepos(optionalArg(args, 0)) }),
+			},
+			{
+				name: "get-metadata", args: "<owner/name>", summary: "Get repository metadata",
+				setup: noFlags(func(args []string) { adminGetMetadata(args[0]) }),
+			},
+			{
+				name: "set-description", args: "<owner/name> <description>", summary: "Set repository description",
+				setup: noFlags(func(args []string) { adminSetDescription(args[0], args[1]) }),
+			},
+			{
+				name: "set-allowed-ips", args: "<owner/name> [ip-or-cidr,...]", summary: "Limit where a repository can be read or pushed from",
+				help: `
+Only clients at the listed addresses and CIDR ranges can clone, fetch, push
+or browse the repository; to everyone else it looks like it doesn't exist.
+Leaving the list out lifts the limit. Needs the token of the repository's
+owner or an admin.`,
+				setup: noFlags(func(args []string) { adminSetAllowedIPs(args[0], splitList(optionalArg(args, 1))) }),
+			},
+			{
+				name: "add-replica", args: "<owner/name> <url|domain>", summary: "Add replica (auto-registers with remote)",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					refs := fs.String("refs", "", "comma-separated refs or globs to replicate (default: all)")
+					allowChain := fs.Bool("allow-chain", false, "let the replica replicate the repo on to further instances")
+					invite := fs.Bool("invite", false, "hold the repo at the replica until its admin accepts it with the invitation key")
+					return func(args []string) {
+						adminAddReplica(args[0], args[1], parseRefList(*refs), *allowChain, *invite)
+					}
+				},
+			},
+			{
+				name: "allow-chain", args: "<owner/name> <replica-url>", summary: "Let a replica replicate on to its own replicas",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					revoke := fs.Bool("revoke", false, "withdraw consent instead of granting it")
+					return func(args []string) { adminAllowChain(args[0], args[1], !*revoke) }
+				},
+			},
+			{
+				name: "pause-replica", args: "[owner/name] [replica-url]", summary: "Stop pushing to a repo's replicas, or to all",
+				help: `
+Pauses the replica at replica-url, or all of the repository's replicas
+without one; they show as disabled until resumed. With --all, holds all replication on this instance, for a
+maintenance window: jobs queue up until resume-replica --all, and the pause
+outlasts restarts.`,
+				setup: func(fs *flag.FlagSet) func([]string) {
+					all := fs.Bool("all", false, "pause all replication on this instance")
+					return func(args []string) { adminPauseReplica(optionalArg(args, 0), optionalArg(args, 1), *all, true) }
+				},
+			},
+			{
+				name: "resume-replica", args: "[owner/name] [replica-url]", summary: "Resume pushing to paused replicas",
+				help: `
+Resumes the replica at replica-url, or all of the repository's replicas
+without one, and syncs them straight away. With --all, lifts a pause of all
+replication on this instance.`,
+				setup: func(fs *flag.FlagSet) func([]string) {
+					all := fs.Bool("all", false, "resume all replication on this instance")
+					return func(args []string) { adminPauseReplica(optionalArg(args, 0), optionalArg(args, 1), *all, false) }
+				},
+			},
+			{
+				name: "remove-replica", args: "<owner/name> <instance-id>", summary: "Remove a replica",
+				help: `
+Shows the registration that will be removed and what the replica keeps,
+and asks before removing it.`,
+				setup: func(fs *flag.FlagSet) func([]string) {
+					dryRun := fs.Bool("dry-run", false, "show what would be removed without removing it")
+					force := fs.Bool("force", false, "don't ask for confirmation")
+					return func(args []string) { adminRemoveReplica(args[0], args[1], *dryRun, *force) }
+				},
+			},
+			{
+				name: "unsubscribe-replication", args: "<owner/name>", summary: "Ask a replicated repo's origin to stop pushing here",
+				help: `
+Run on a replica: the origin disables this replica's entry, as if its admin
+had paused it, and shows why in its replica status. The repository stays
+here, and the origin's admin can resume pushes later.`,
+				setup: noFlags(func(args []string) { adminUnsubscribeReplication(args[0]) }),
+			},
+			{
+				name: "list-replicas", args: "<owner/name>", summary: "List configured replicas",
+				setup: noFlags(func(args []string) { adminListReplicas(args[0]) }),
+			},
+			{
+				name: "get-policy", args: "<owner/name>", summary: "Show repository push policy",
+				setup: noFlags(func(args []string) { adminGetPolicy(args[0]) }),
+			},
+			{
+				name: "set-policy", args: "<owner/name>", summary: "Set repository push policy",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					var p storage.Policy
+					fs.StringVar(&p.BranchPattern, "branch-pattern", "", "regex new branch names must match")
+					fs.BoolVar(&p.ConventionalCommits, "conventional-commits", false, "require conventional-commit subjects")
+					fs.IntVar(&p.MaxSubjectLength, "max-subject-length", 0, "max commit subject length (0 = unlimited)")
+					protected := fs.String("protected", "", "comma-separated branch refs or globs only the merge queue may update")
+					signed := fs.String("signed", "", "comma-separated branch refs or globs whose pushed commits must have verified signatures")
+					scanSecrets := fs.String("scan-secrets", "", "on or off to override the server's --scan-secrets")
+					fs.IntVar(&p.MaxBlobMB, "max-blob-mb", 0, "override the server's --max-blob-mb (-1 = unlimited)")
+					fs.Var((*reviewRuleFlag)(&p.ReviewRules), "review-rule", "branch:approvals[:reviewer,...] required before merging (repeatable)")
+					return func(args []string) {
+						p.ProtectedBranches = parseRefList(*protected)
+						p.SignedBranches = parseRefList(*signed)
+						switch *scanSecrets {
+						case "":
+						case "on", "off":
+							on := *scanSecrets == "on"
+							p.ScanSecrets = &on
+						default:
+							fmt.Println("error: --scan-secrets must be on or off")
+							os.Exit(1)
+						}
+						adminSetPolicy(args[0], p)
+					}
+				},
+			},
+			{
+				name: "recovery-bundle", args: "<owner/name>", summary: "Generate recovery bundle JSON",
+				setup: noFlags(func(args []string) { adminRecoveryBundle(args[0]) }),
+			},
+			{
+				name: "restore-from-recovery", args: "<bundle.json>", summary: "Rebuild a repo from its replicas",
+				setup: noFlags(func(args []string) { adminRestoreFromRecovery(args[0]) }),
+			},
+			{
+				name: "dns-records", args: "<domain> <api-url> [ssh-port]", summary: "Print DNS discovery records for this instance",
+				setup: noFlags(func(args []string) {
+					sshPort := 0
+					if len(args) > 2 {
+						p, err := strconv.Atoi(args[2])
+						if err != nil {
+							fmt.Printf("invalid ssh port: %s\n", args[2])
+							os.Exit(1)
+						}
+						sshPort = p
+					}
+					adminDNSRecords(args[0], args[1], sshPort)
+				}),
+			},
+			{
+				name: "doctor", summary: "Check the instance for problems and how to fix them",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					offline := fs.Bool("offline", false, "skip contacting replicas")
+					return func([]string) { adminDoctor(*offline) }
+				},
+			},
+			{
+				name: "stats", summary: "Summarize the instance: repos, users, disk, replication and pushes",
+				help: `
+Asks the server for the numbers an operator wants at a glance. Pushes are
+counted by the running server, so they only go back to when it started.`,
+				setup: noFlags(func([]string) { adminStats() }),
+			},
+			{
+				name: "verify", args: "[owner[/name]]", summary: "Run git fsck on repositories to find corruption",
+				help: `
+Checks every repository, an owner's, or one, and exits non-zero if any is
+corrupt. Runs against OPENHUB_STORAGE; the server can keep running.`,
+				setup: func(fs *flag.FlagSet) func([]string) {
+					workers := fs.Int("workers", 4, "repositories to check at once")
+					return func(args []string) {
+						if *workers < 1 {
+							fmt.Println("error: --workers must be at least 1")
+							os.Exit(1)
+						}
+						adminVerify(optionalArg(args, 0), *workers)
+					}
+				},
+			},
+			{
+				name: "backup", args: "<file>", summary: "Archive the whole instance to a file",
+				help: `
+Writes every repository as a git bundle, together with users, the instance
+identity and keys, peers, quotas and the other stored data, to one gzipped
+tar archive. It is safe to run while the server is up; a repository pushed
+to during the backup is archived as it was when its turn came.`,
+				setup: noFlags(func(args []string) { adminBackup(args[0]) }),
+			},
+			{
+				name: "restore", args: "<file>", summary: "Recreate an instance from a backup",
+				help: `
+Restores a backup made by 'openhub admin backup' into OPENHUB_STORAGE,
+which must not hold an instance yet. Run it before starting the server.
+The master key is not part of a backup: set OPENHUB_MASTER_KEY as on the
+old host for encrypted users to load.`,
+				setup: noFlags(func(args []string) { adminRestore(args[0]) }),
+			},
+			{
+				name: "migrate-from", summary: "Import an organization's repositories from GitHub or Gitea",
+				help: `
+Creates each repository of the organization (or user) under --owner, which
+defaults to the organization's name, and fetches its branches and tags,
+description and visibility. Repositories that already exist are skipped, so
+an interrupted migration can be rerun. With --users, each member gets an
+account, if they have none, and the SSH keys they publish on the forge.
+With --mirror, the repositories can't be pushed to; the server fetches them
+from the forge on a schedule instead. Private repositories and members need
+--token. Runs against
+OPENHUB_STORAGE.`,
+				setup: func(fs *flag.FlagSet) func([]string) {
+					source := fs.String("source", "", "forge to migrate from: github or gitea")
+					org := fs.String("org", "", "organization or user to migrate")
+					baseURL := fs.String("url", "", "forge web address; required for Gitea, selects GitHub Enterprise")
+					token := fs.String("token", os.Getenv("OPENHUB_MIGRATE_TOKEN"), "forge API token (default: $OPENHUB_MIGRATE_TOKEN)")
+					owner := fs.String("owner", "", "owner of the imported repositories (default: the organization)")
+					users := fs.Bool("users", false, "also import members and their SSH keys")
+					mirror := fs.Bool("mirror", false, "keep the repositories as read-only mirrors the server syncs")
+					return func([]string) {
+						if *source == "" || *org == "" {
+							fmt.Println("error: --source and --org are required")
+							os.Exit(1)
+						}
+						src, err := newMigrationSource(*source, *baseURL, *org, *token)
+						if err != nil {
+							fmt.Printf("error: %v\n", err)
+							os.Exit(1)
+						}
+						if *owner == "" {
+							*owner = *org
+						}
+						adminMigrateFrom(src, *owner, *users, *mirror)
+					}
+				},
+			},
+			{
+				name: "sync-upstream", args: "<owner/name>", summary: "Fetch a mirrored repository from its upstream now",
+				setup: noFlags(func(args []string) { adminSyncUpstream(args[0]) }),
+			},
+			{
+				name: "instance-info", summary: "Print this instance's ID and public key",
+				setup: noFlags(func([]string) { adminInstanceInfo() }),
+			},
+			{
+				name: "trust-peer", args: "<instance-id> <public-key>", summary: "Pin another instance's public key",
+				setup: noFlags(func(args []string) { adminTrustPeer(args[0], args[1]) }),
+			},
+			{
+				name: "handshake", args: "<url|domain>", summary: "Exchange keys and capabilities with another instance",
+				setup: noFlags(func(args []string) { adminHandshake(args[0]) }),
+			},
+			{
+				name: "list-peers", summary: "List trusted instances",
+				setup: noFlags(func([]string) { adminListPeers() }),
+			},
+			{
+				name: "replication-requests", summary: "List origins waiting to replicate repos here",
+				setup: noFlags(func([]string) { adminReplicationRequests() }),
+			},
+			{
+				name: "approve-replication", args: "<request-id>", summary: "Let an origin replicate a repo here",
+				setup: noFlags(func(args []string) { adminDecideReplication(args[0], true) }),
+			},
+			{
+				name: "reject-replication", args: "<request-id>", summary: "Turn down an origin's request to replicate a repo here",
+				setup: noFlags(func(args []string) { adminDecideReplication(args[0], false) }),
+			},
+			{
+				name: "accept-replication", args: "<invitation-key>", summary: "Review and accept an origin's invitation to replicate a repo here",
+				help: `
+Shows the repo an origin invited this instance to replicate with
+add-replica --invite: who the origin is, the refs it will push, its size
+and whether it may be replicated on, and asks before accepting. The repo
+is then created empty here and the origin pushes it straight away.`,
+				setup: func(fs *flag.FlagSet) func([]string) {
+					force := fs.Bool("force", false, "don't ask for confirmation")
+					return func(args []string) { adminAcceptReplication(args[0], *force) }
+				},
+			},
+			{
+				name: "federate-search", args: "[query...]", summary: "Search trusted peers for public repositories",
+				setup: noFlags(func(args []string) { adminFederateSearch(strings.Join(args, " ")) }),
+			},
+			{
+				name: "logs", summary: "Show or follow the server log",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					follow := fs.Bool("follow", false, "keep streaming new log lines")
+					lines := fs.Int("lines", 100, "recent lines to show first")
+					token := fs.String("token", "", "API token of an admin user (default: $OPENHUB_TOKEN or the profile's token)")
+					var filters listFlag
+					fs.Var(&filters, "filter", "repo=<owner/name>, peer=<url|instance-id> or text=<substring> (repeatable)")
+					return func([]string) { adminLogs(*token, *follow, *lines, filters) }
+				},
+			},
+			{
+				name: "audit", summary: "Query the audit log of security-relevant actions",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					actor := fs.String("actor", "", "only entries by this user")
+					action := fs.String("action", "", "only this action, or actions starting with a prefix ending in '.'")
+					target := fs.String("target", "", "only entries about this target")
+					since := fs.String("since", "", "only entries after this RFC 3339 time or duration ago")
+					until := fs.String("until", "", "only entries before this RFC 3339 time or duration ago")
+					limit := fs.Int("limit", 100, "newest entries to show (0 for all)")
+					return func([]string) {
+						adminAudit(audit.Filter{Actor: *actor, Action: *action, Target: *target}, *since, *until, *limit)
+					}
+				},
+			},
+			{
+				name: "quota", args: "[owner]", summary: "Show disk quota usage and grace periods",
+				setup: noFlags(func(args []string) { adminQuota(optionalArg(args, 0)) }),
+			},
+			{
+				name: "set-quota", args: "[owner]", summary: "Set the default or an owner's disk quota",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					var l quota.Limits
+					isDefault := fs.Bool("default", false, "set the default quota instead of an owner's")
+					fs.Int64Var(&l.MaxMB, "max-mb", 0, "disk quota in MB (0 inherits the default, -1 is unlimited)")
+					fs.IntVar(&l.WarnPercent, "warn-percent", 0, "usage percentage at which owners are warned")
+					fs.IntVar(&l.GraceDays, "grace-days", 0, "days over quota before pushes are rejected")
+					return func(args []string) {
+						owner := optionalArg(args, 0)
+						if (owner == "") != *isDefault {
+							fmt.Println("usage: openhub admin set-quota (<owner> | --default) [flags]")
+							os.Exit(1)
+						}
+						adminSetQuota(owner, l)
+					}
+				},
+			},
+			{
+				name: "seed", summary: "Generate synthetic users and repos for benchmarking",
+				setup: seedCommand,
+			},
+			{
+				name: "add-webhook", args: "<owner/name> <url>", summary: "Send a repository's events to a URL",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					secret := fs.String("secret", "", "shared secret for the X-OpenHub-Signature-256 HMAC")
+					events := fs.String("events", "push", "comma-separated events to send")
+					token := fs.String("token", "", tokenUsage)
+					return func(args []string) { adminAddWebhook(args[0], args[1], *secret, splitList(*events), *token) }
+				},
+			},
+			{
+				name: "list-webhooks", args: "<owner/name>", summary: "List a repository's webhooks",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					token := fs.String("token", "", tokenUsage)
+					return func(args []string) { adminListWebhooks(args[0], *token) }
+				},
+			},
+			{
+				name: "remove-webhook", args: "<owner/name> <id>", summary: "Remove a webhook",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					token := fs.String("token", "", tokenUsage)
+					return func(args []string) { adminRemoveWebhook(args[0], args[1], *token) }
+				},
+			},
+			{
+				name: "webhook-deliveries", args: "<owner/name>", summary: "List recent webhook delivery attempts",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					hook := fs.String("hook", "", "only show deliveries to this webhook")
+					token := fs.String("token", "", tokenUsage)
+					return func(args []string) { adminWebhookDeliveries(args[0], *hook, *token) }
+				},
+			},
+			{
+				name: "redeliver-webhook", args: "<owner/name> <delivery>", summary: "Send a webhook delivery again",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					token := fs.String("token", "", tokenUsage)
+					return func(args []string) { adminRedeliverWebhook(args[0], args[1], *token) }
+				},
+			},
+			{
+				name: "reset-access", args: "<username>", summary: "Revoke a user's credentials and issue an invite link",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					expires := fs.Duration("expires", 72*time.Hour, "how long the invite stays valid")
+					return func(args []string) { adminResetAccess(args[0], *expires) }
+				},
+			},
+			{
+				name: "create-invite", summary: "Issue a single-use registration invite code",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					expires := fs.Duration("expires", 7*24*time.Hour, "how long the invite stays valid")
+					note := fs.String("note", "", "who the invite is for")
+					return func([]string) { adminCreateInvite(*expires, *note) }
+				},
+			},
+			{
+				name: "gen-master-key", args: "<file>", summary: "Generate a key for encrypting user records at rest",
+				setup: noFlags(func(args []string) { adminGenMasterKey(args[0]) }),
+			},
+			{
+				name: "rekey-users", summary: "Encrypt, re-encrypt or decrypt user records",
+				setup: func(fs *flag.FlagSet) func([]string) {
+					newKey := fs.String("new-key", "", "key file to encrypt user records with from now on")
+					decrypt := fs.Bool("decrypt", false, "store user records in plaintext again")
+					return func([]string) {
+						if (*newKey == "") == !*decrypt {
+							fmt.Println("usage: openhub admin rekey-users (--new-key <file> | --decrypt)")
+							os.Exit(1)
+						}
+						adminRekeyUsers(*newKey)
+					}
+				},
+			},
+		},
 	}
 }
 
@@ -104,10 +463,7 @@ func adminCreateRepo(path string) {
 
 	owner, name := parts[0], parts[1]
 
-	apiURL := os.Getenv("OPENHUB_API_URL")
-	if apiURL == "" {
-		apiURL = "http://localhost:3000"
-	}
+	apiURL := cliAPIURL()
 
 	reqBody := map[string]string{
 		"owner": owner,
@@ -120,7 +476,7 @@ func adminCreateRepo(path string) {
 		os.Exit(1)
 	}
 
-	resp, err := http.Post(apiURL+"/api/repos/create", "application/json", bytes.NewBuffer(jsonData))
+	resp, err := apiPost(apiURL+"/api/v1/repos/create", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		fmt.Printf("request error: %v\n", err)
 		os.Exit(1)
@@ -140,6 +496,10 @@ func adminCreateRepo(path string) {
 	}
 
 	if success, ok := result["success"].(bool); ok && success {
+		if jsonOutput {
+			printRawJSON(body)
+			return
+		}
 		fmt.Printf("Repository created: %s/%s\n", owner, name)
 		if cloneURL, ok := result["clone_url"].(string); ok {
 			fmt.Printf("Clone URL: %s\n", cloneURL)
@@ -154,7 +514,7 @@ func adminCreateRepo(path string) {
 	}
 }
 
-func adminDeleteRepo(path string) {
+func adminDeleteRepo(path string, dryRun, force bool) {
 	parts := strings.Split(path, "/")
 	if len(parts) != 2 {
 		fmt.Println("invalid repo path, must be owner/name")
@@ -163,11 +523,14 @@ func adminDeleteRepo(path string) {
 
 	owner, name := parts[0], parts[1]
 
-	apiURL := os.Getenv("OPENHUB_API_URL")
-	if apiURL == "" {
-		apiURL = "http://localhost:3000"
+	if dryRun || !force {
+		if !confirmRemoval(deleteRepoRemoval(owner, name), "Delete "+path+"?", dryRun) {
+			return
+		}
 	}
 
+	apiURL := cliAPIURL()
+
 	reqBody := map[string]string{
 		"owner": owner,
 		"name":  name,
@@ -179,7 +542,7 @@ func adminDeleteRepo(path string) {
 		os.Exit(1)
 	}
 
-	resp, err := http.Post(apiURL+"/api/repos/delete", "application/json", bytes.NewBuffer(jsonData))
+	resp, err := apiPost(apiURL+"/api/v1/repos/delete", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		fmt.Printf("request error: %v\n", err)
 		os.Exit(1)
@@ -199,6 +562,10 @@ func adminDeleteRepo(path string) {
 	}
 
 	if success, ok := result["success"].(bool); ok && success {
+		if jsonOutput {
+			printRawJSON(body)
+			return
+		}
 		fmt.Printf("Repository deleted: %s/%s\n", owner, name)
 	} else {
 		if errMsg, ok := result["error"].(string); ok {
@@ -211,17 +578,14 @@ func adminDeleteRepo(path string) {
 }
 
 func adminListRepos(owner string) {
-	apiURL := os.Getenv("OPENHUB_API_URL")
-	if apiURL == "" {
-		apiURL = "http://localhost:3000"
-	}
+	apiURL := cliAPIURL()
 
-	url := apiURL + "/api/repos/list"
+	url := apiURL + "/api/v1/repos/list"
 	if owner != "" {
 		url += "?owner=" + owner
 	}
 
-	resp, err := http.Get(url)
+	resp, err := apiGet(url)
 	if err != nil {
 		fmt.Printf("request error: %v\n", err)
 		os.Exit(1)
@@ -241,6 +605,10 @@ func adminListRepos(owner string) {
 	}
 
 	if success, ok := result["success"].(bool); ok && success {
+		if jsonOutput {
+			printRawJSON(body)
+			return
+		}
 		if repos, ok := result["repos"].([]interface{}); ok {
 			if len(repos) == 0 {
 				fmt.Println("No repositories found")
@@ -273,14 +641,11 @@ func adminGetMetadata(path string) {
 
 	owner, name := parts[0], parts[1]
 
-	apiURL := os.Getenv("OPENHUB_API_URL")
-	if apiURL == "" {
-		apiURL = "http://localhost:3000"
-	}
+	apiURL := cliAPIURL()
 
-	url := fmt.Sprintf("%s/api/repos/metadata?owner=%s&name=%s", apiURL, owner, name)
+	url := fmt.Sprintf("%s/api/v1/repos/metadata?owner=%s&name=%s", apiURL, owner, name)
 
-	resp, err := http.Get(url)
+	resp, err := apiGet(url)
 	if err != nil {
 		fmt.Printf("request error: %v\n", err)
 		os.Exit(1)
@@ -300,6 +665,10 @@ func adminGetMetadata(path string) {
 	}
 
 	if success, ok := result["success"].(bool); ok && success {
+		if jsonOutput {
+			printRawJSON(body)
+			return
+		}
 		if metadata, ok := result["metadata"].(map[string]interface{}); ok {
 			fmt.Printf("Repository: %s/%s\n", owner, name)
 			if desc, ok := metadata["description"].(string); ok {
@@ -334,13 +703,10 @@ func adminSetDescription(path, description string) {
 
 	owner, name := parts[0], parts[1]
 
-	apiURL := os.Getenv("OPENHUB_API_URL")
-	if apiURL == "" {
-		apiURL = "http://localhost:3000"
-	}
+	apiURL := cliAPIURL()
 
-	getURL := fmt.Sprintf("%s/api/repos/metadata?owner=%s&name=%s", apiURL, owner, name)
-	resp, err := http.Get(getURL)
+	getURL := fmt.Sprintf("%s/api/v1/repos/metadata?owner=%s&name=%s", apiURL, owner, name)
+	resp, err := apiGet(getURL)
 	if err != nil {
 		fmt.Printf("request error: %v\n", err)
 		os.Exit(1)
@@ -374,8 +740,8 @@ func adminSetDescription(path, description string) {
 		os.Exit(1)
 	}
 
-	setURL := fmt.Sprintf("%s/api/repos/metadata?owner=%s&name=%s", apiURL, owner, name)
-	resp, err = http.Post(setURL, "application/json", bytes.NewBuffer(jsonData))
+	setURL := fmt.Sprintf("%s/api/v1/repos/metadata?owner=%s&name=%s", apiURL, owner, name)
+	resp, err = apiPost(setURL, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		fmt.Printf("request error: %v\n", err)
 		os.Exit(1)
@@ -395,6 +761,10 @@ func adminSetDescription(path, description string) {
 	}
 
 	if success, ok := result["success"].(bool); ok && success {
+		if jsonOutput {
+			printRawJSON(body)
+			return
+		}
 		fmt.Printf("Description updated for %s/%s\n", owner, name)
 	} else {
 		if errMsg, ok := result["error"].(string); ok {
@@ -406,21 +776,7 @@ func adminSetDescription(path, description string) {
 	}
 }
 
-func getStorage() *storage.Storage {
-	cfg := config.Default()
-	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
-		cfg.StoragePath = storagePath
-	}
-
-	store, err := storage.New(cfg.StoragePath)
-	if err != nil {
-		fmt.Printf("storage init: %v\n", err)
-		os.Exit(1)
-	}
-	return store
-}
-
-func adminAddReplica(path, url string) {
+func adminSetAllowedIPs(path string, allowed []string) {
 	parts := strings.Split(path, "/")
 	if len(parts) != 2 {
 		fmt.Println("invalid repo path, must be owner/name")
@@ -428,216 +784,1715 @@ func adminAddReplica(path, url string) {
 	}
 
 	owner, name := parts[0], parts[1]
-	cfg := config.Default()
-	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
-		cfg.StoragePath = storagePath
+
+	if allowed == nil {
+		allowed = []string{}
+	}
+	jsonData, err := json.Marshal(map[string]interface{}{"owner": owner, "name": name, "allowed_ips": allowed})
+	if err != nil {
+		fmt.Printf("json error: %v\n", err)
+		os.Exit(1)
 	}
 
-	store := getStorage()
-	inst, err := instance.LoadOrCreate(cfg.StoragePath)
+	resp, err := apiPost(cliAPIURL()+"/api/v1/repos/allowed-ips", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Printf("error loading instance: %v\n", err)
+		fmt.Printf("request error: %v\n", err)
 		os.Exit(1)
 	}
+	defer resp.Body.Close()
 
-	meta, err := store.GetMetadata(owner, name)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
+		fmt.Printf("read error: %v\n", err)
 		os.Exit(1)
 	}
 
-	if meta.ReplicaOf != nil {
-		fmt.Println("error: cannot add replica to a replica repository")
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Printf("json decode error: %v\n", err)
 		os.Exit(1)
 	}
 
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		fmt.Printf("error generating token: %v\n", err)
+	if success, ok := result["success"].(bool); ok && success {
+		if jsonOutput {
+			printRawJSON(body)
+			return
+		}
+		if len(allowed) == 0 {
+			fmt.Printf("%s/%s can be reached from any address\n", owner, name)
+			return
+		}
+		fmt.Printf("%s/%s can only be reached from %s\n", owner, name, strings.Join(allowed, ", "))
+	} else {
+		if errMsg, ok := result["error"].(string); ok {
+			fmt.Printf("error: %s\n", errMsg)
+		} else {
+			fmt.Println("error: unknown failure")
+		}
 		os.Exit(1)
 	}
-	token := hex.EncodeToString(tokenBytes)
+}
 
-	invitationKeyBytes := make([]byte, 32)
-	if _, err := rand.Read(invitationKeyBytes); err != nil {
-		fmt.Printf("error generating invitation key: %v\n", err)
+func adminGetPolicy(path string) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		fmt.Println("invalid repo path, must be owner/name")
 		os.Exit(1)
 	}
-	invitationKey := hex.EncodeToString(invitationKeyBytes)
 
-	fmt.Println("Registering with replica...")
+	owner, name := parts[0], parts[1]
 
-	reqBody := map[string]string{
-		"owner":              owner,
-		"repo":               name,
-		"replica_url":        url,
-		"token":              token,
-		"origin_instance_id": inst.ID,
+	apiURL := cliAPIURL()
+
+	resp, err := apiGet(fmt.Sprintf("%s/api/v1/repos/policy?owner=%s&name=%s", apiURL, owner, name))
+	if err != nil {
+		fmt.Printf("request error: %v\n", err)
+		os.Exit(1)
 	}
+	defer resp.Body.Close()
 
-	jsonData, err := json.Marshal(reqBody)
+	printPolicyResult(owner, name, resp)
+}
+
+func adminSetPolicy(path string, p storage.Policy) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		fmt.Println("invalid repo path, must be owner/name")
+		os.Exit(1)
+	}
+
+	owner, name := parts[0], parts[1]
+
+	apiURL := cliAPIURL()
+
+	jsonData, err := json.Marshal(p)
 	if err != nil {
 		fmt.Printf("json error: %v\n", err)
 		os.Exit(1)
 	}
 
-	registerURL := url + "/api/repos/register-replication"
-	resp, err := http.Post(registerURL, "application/json", bytes.NewBuffer(jsonData))
+	url := fmt.Sprintf("%s/api/v1/repos/policy?owner=%s&name=%s", apiURL, owner, name)
+	resp, err := apiPost(url, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Printf("error contacting replica: %v\n", err)
+		fmt.Printf("request error: %v\n", err)
 		os.Exit(1)
 	}
 	defer resp.Body.Close()
 
+	printPolicyResult(owner, name, resp)
+}
+
+// reviewRuleFlag parses repeated --review-rule values of the form
+// "refs/heads/main:2" or "refs/heads/main:1:alice,bob".
+type reviewRuleFlag []storage.ReviewRule
+
+func (f *reviewRuleFlag) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *reviewRuleFlag) Set(value string) error {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("expected branch:approvals[:reviewers]")
+	}
+
+	approvals, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid approval count: %s", parts[1])
+	}
+
+	rule := storage.ReviewRule{Branch: parts[0], RequiredApprovals: approvals}
+	if len(parts) == 3 {
+		rule.RequiredReviewers = splitList(parts[2])
+	}
+	*f = append(*f, rule)
+	return nil
+}
+
+func printPolicyResult(owner, name string, resp *http.Response) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("error reading response: %v\n", err)
+		fmt.Printf("read error: %v\n", err)
 		os.Exit(1)
 	}
 
-	var result map[string]interface{}
+	var result struct {
+		Success bool            `json:"success"`
+		Error   string          `json:"error"`
+		Policy  *storage.Policy `json:"policy"`
+	}
 	if err := json.Unmarshal(body, &result); err != nil {
 		fmt.Printf("json decode error: %v\n", err)
 		os.Exit(1)
 	}
 
-	if success, ok := result["success"].(bool); !ok || !success {
-		if errMsg, ok := result["error"].(string); ok {
-			fmt.Printf("replica registration failed: %s\n", errMsg)
-		} else {
-			fmt.Println("replica registration failed: unknown error")
-		}
+	if !result.Success {
+		fmt.Printf("error: %s\n", result.Error)
 		os.Exit(1)
 	}
+	if jsonOutput {
+		printRawJSON(body)
+		return
+	}
 
-	replica := storage.Replica{
-		InstanceID:    inst.ID,
-		URL:           url,
-		Token:         token,
-		InvitationKey: invitationKey,
-		Enabled:       true,
+	fmt.Printf("Policy for %s/%s:\n", owner, name)
+	if result.Policy == nil {
+		fmt.Println("  (none)")
+		return
+	}
+	if result.Policy.BranchPattern != "" {
+		fmt.Printf("  Branch pattern: %s\n", result.Policy.BranchPattern)
+	}
+	if result.Policy.ConventionalCommits {
+		fmt.Println("  Conventional commits: required")
+	}
+	if result.Policy.MaxSubjectLength > 0 {
+		fmt.Printf("  Max subject length: %d\n", result.Policy.MaxSubjectLength)
+	}
+	if len(result.Policy.ProtectedBranches) > 0 {
+		fmt.Printf("  Protected branches: %s\n", strings.Join(result.Policy.ProtectedBranches, ", "))
 	}
+	if len(result.Policy.SignedBranches) > 0 {
+		fmt.Printf("  Signed commits required on: %s\n", strings.Join(result.Policy.SignedBranches, ", "))
+	}
+	if p := result.Policy.ScanSecrets; p != nil {
+		if *p {
+			fmt.Println("  Secret scanning: on")
+		} else {
+			fmt.Println("  Secret scanning: off")
+		}
+	}
+	switch {
+	case result.Policy.MaxBlobMB > 0:
+		fmt.Printf("  Max file size: %d MB\n", result.Policy.MaxBlobMB)
+	case result.Policy.MaxBlobMB < 0:
+		fmt.Println("  Max file size: unlimited")
+	}
+	for _, rule := range result.Policy.ReviewRules {
+		fmt.Printf("  Reviews for %s: %d approvals", rule.Branch, rule.RequiredApprovals)
+		if len(rule.RequiredReviewers) > 0 {
+			fmt.Printf(", including %s", strings.Join(rule.RequiredReviewers, ", "))
+		}
+		fmt.Println()
+	}
+}
 
-	meta.Replicas = append(meta.Replicas, replica)
+func adminInstanceInfo() {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
 
-	if err := store.SetMetadata(owner, name, meta); err != nil {
-		fmt.Printf("error: %v\n", err)
+	inst, err := instance.LoadOrCreate(cfg.StoragePath)
+	if err != nil {
+		fmt.Printf("error loading instance: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("✓ Replica configured successfully\n")
-	fmt.Printf("URL: %s\n", url)
-	fmt.Printf("Invitation Key: %s\n", invitationKey)
-	fmt.Println("\nShare this invitation key with the replica administrator.")
-	fmt.Println("They need it to accept replication from this origin.")
-	fmt.Printf("Replica will receive updates on push\n")
+	if jsonOutput {
+		printJSON(map[string]string{"instance_id": inst.ID, "public_key": inst.PublicKey})
+		return
+	}
+	fmt.Printf("Instance ID: %s\n", inst.ID)
+	fmt.Printf("Public key:  %s\n", inst.PublicKey)
 }
 
-func adminRemoveReplica(path, instanceID string) {
-	parts := strings.Split(path, "/")
-	if len(parts) != 2 {
-		fmt.Println("invalid repo path, must be owner/name")
+// adminTrustPeer pins another instance's key ahead of time, which standbys
+// need before they accept a primary's user snapshots.
+func adminTrustPeer(instanceID, publicKey string) {
+	if !instance.ValidPublicKey(publicKey) {
+		fmt.Println("error: invalid public key")
 		os.Exit(1)
 	}
 
-	owner, name := parts[0], parts[1]
-	store := getStorage()
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
 
-	meta, err := store.GetMetadata(owner, name)
-	if err != nil {
+	if err := instance.NewPeerStore(cfg.StoragePath).Pin(instanceID, publicKey); err != nil {
 		fmt.Printf("error: %v\n", err)
 		os.Exit(1)
 	}
 
-	var newReplicas []storage.Replica
-	found := false
-	for _, r := range meta.Replicas {
-		if r.InstanceID == instanceID {
-			found = true
-			continue
-		}
-		newReplicas = append(newReplicas, r)
+	if jsonOutput {
+		printJSON(map[string]string{"instance_id": instanceID, "public_key": publicKey})
+		return
 	}
+	fmt.Printf("Trusted instance %s\n", instanceID)
+}
+
+// handshakeMaxSkew matches the server's tolerance for signed federation
+// messages.
+const handshakeMaxSkew = 10 * time.Minute
 
-	if !found {
-		fmt.Printf("replica with instance ID %s not found\n", instanceID)
+// handshake exchanges identities with the instance at url and records it in
+// this instance's peer registry. This instance advertises itself at
+// $OPENHUB_EXTERNAL_URL, if set.
+func handshake(inst *instance.Instance, storagePath, url string) instance.Hello {
+	hello, err := inst.Handshake(federationClient(), os.Getenv("OPENHUB_EXTERNAL_URL"), url, handshakeMaxSkew)
+	if err != nil {
+		fmt.Printf("handshake with %s failed: %v\n", url, err)
 		os.Exit(1)
 	}
 
-	meta.Replicas = newReplicas
-
-	if err := store.SetMetadata(owner, name, meta); err != nil {
+	if err := instance.NewPeerStore(storagePath).Record(hello.Peer()); err != nil {
 		fmt.Printf("error: %v\n", err)
 		os.Exit(1)
 	}
-
-	fmt.Printf("Replica removed from %s/%s\n", owner, name)
+	return hello
 }
 
-func adminListReplicas(path string) {
-	parts := strings.Split(path, "/")
-	if len(parts) != 2 {
-		fmt.Println("invalid repo path, must be owner/name")
+// adminHandshake trusts another instance without replicating anything to it
+// yet, so each side has the other's key pinned ahead of time.
+func adminHandshake(target string) {
+	url, err := discovery.ResolveURL(target)
+	if err != nil {
+		fmt.Printf("error resolving peer: %v\n", err)
 		os.Exit(1)
 	}
 
-	owner, name := parts[0], parts[1]
-	store := getStorage()
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
 
-	meta, err := store.GetMetadata(owner, name)
+	inst, err := instance.LoadOrCreate(cfg.StoragePath)
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
+		fmt.Printf("error loading instance: %v\n", err)
 		os.Exit(1)
 	}
 
-	if len(meta.Replicas) == 0 {
-		fmt.Println("No replicas configured")
+	hello := handshake(inst, cfg.StoragePath, url)
+	if jsonOutput {
+		printJSON(hello.Peer())
 		return
 	}
+	fmt.Printf("✓ Trusted instance %s\n", hello.InstanceID)
+	fmt.Printf("URL: %s\n", url)
+	fmt.Printf("Public key: %s\n", hello.PublicKey)
+	fmt.Printf("Capabilities: %s\n", strings.Join(hello.Capabilities, ", "))
+}
 
-	fmt.Printf("Replicas for %s/%s:\n", owner, name)
-	for i, r := range meta.Replicas {
-		status := "enabled"
-		if !r.Enabled {
-			status = "disabled"
+func adminReplicationRequests() {
+	var result struct {
+		Policy   string                        `json:"policy"`
+		Requests []instance.ReplicationRequest `json:"requests"`
+	}
+	adminAPI("GET", "/api/v1/admin/replication-requests", nil, &result)
+	if jsonOutput {
+		return
+	}
+	if len(result.Requests) == 0 {
+		fmt.Printf("No replication requests (origin policy: %s)\n", result.Policy)
+		return
+	}
+
+	for _, req := range result.Requests {
+		kind := ""
+		if req.InvitationKeyHash != "" {
+			kind = "  (invitation; accept with its key)"
 		}
-		fmt.Printf("%d. URL: %s\n", i+1, r.URL)
-		fmt.Printf("   Instance ID: %s\n", r.InstanceID)
-		fmt.Printf("   Invitation Key: %s\n", r.InvitationKey)
-		fmt.Printf("   Status: %s\n", status)
-		if !r.LastSynced.IsZero() {
-			fmt.Printf("   Last Synced: %s\n", r.LastSynced.Format("2006-01-02 15:04:05"))
+		fmt.Printf("%s  %s/%s%s\n", req.ID, req.Owner, req.Repo, kind)
+		printReplicationRequest(req)
+	}
+}
+
+// printReplicationRequest describes what an origin asks this instance to
+// host.
+func printReplicationRequest(req instance.ReplicationRequest) {
+	if req.OriginURL != "" {
+		fmt.Printf("  Origin: %s\n", req.OriginURL)
+	}
+	fmt.Printf("  Origin instance: %s\n", req.OriginInstanceID)
+	fmt.Printf("  Origin key: %s\n", req.OriginPublicKey)
+	if req.Description != "" {
+		fmt.Printf("  Description: %s\n", req.Description)
+	}
+	refs := "all"
+	if len(req.Refs) > 0 {
+		refs = strings.Join(req.Refs, ", ")
+	}
+	fmt.Printf("  Refs: %s\n", refs)
+	if req.Size > 0 {
+		fmt.Printf("  Size on origin: %s\n", formatSize(req.Size))
+	}
+	if req.AllowChain {
+		fmt.Println("  May be replicated on to further instances")
+	}
+	if req.Client != "" {
+		fmt.Printf("  From: %s\n", req.Client)
+	}
+	fmt.Printf("  Requested: %s\n", req.RequestedAt.Local().Format("2006-01-02 15:04:05"))
+}
+
+func adminDecideReplication(id string, approve bool) {
+	decideReplication(map[string]interface{}{
+		"id":      id,
+		"approve": approve,
+	}, fmt.Sprintf("Request %s approved", id))
+	if !approve && !jsonOutput {
+		fmt.Printf("Request %s rejected\n", id)
+	}
+}
+
+// adminAcceptReplication finds the invitation made with key, shows what
+// accepting it means hosting and accepts it once the admin agrees.
+func adminAcceptReplication(key string, force bool) {
+	var result struct {
+		Requests []instance.ReplicationRequest `json:"requests"`
+	}
+	adminAPI("GET", "/api/v1/admin/replication-requests", nil, &result)
+
+	var invitation *instance.ReplicationRequest
+	for i, req := range result.Requests {
+		if auth.SecretMatches(req.InvitationKeyHash, key) {
+			invitation = &result.Requests[i]
+			break
 		}
+	}
+	if invitation == nil {
+		fmt.Println("error: no invitation waiting here matches that key; check the origin's admin ran add-replica --invite for this instance")
+		os.Exit(1)
+	}
+
+	if !jsonOutput {
+		fmt.Printf("Invitation to replicate %s/%s\n", invitation.Owner, invitation.Repo)
+		printReplicationRequest(*invitation)
 		fmt.Println()
+		if !force && !confirm("Host this repository and accept pushes from its origin?") {
+			return
+		}
 	}
+
+	decideReplication(map[string]interface{}{
+		"id":             invitation.ID,
+		"approve":        true,
+		"invitation_key": key,
+	}, fmt.Sprintf("✓ Accepted %s/%s", invitation.Owner, invitation.Repo))
 }
 
-func adminRecoveryBundle(path string) {
-	parts := strings.Split(path, "/")
-	if len(parts) != 2 {
-		fmt.Println("invalid repo path, must be owner/name")
-		os.Exit(1)
+// decideReplication approves, accepts or rejects a replication request, and
+// for an approval reports whether the origin was told to start pushing.
+func decideReplication(body map[string]interface{}, approved string) {
+	var result struct {
+		OriginNotified bool   `json:"origin_notified"`
+		NotifyError    string `json:"notify_error"`
+	}
+	adminAPI("POST", "/api/v1/admin/replication-requests", body, &result)
+	if jsonOutput || body["approve"] != true {
+		return
 	}
 
-	owner, name := parts[0], parts[1]
-	store := getStorage()
+	switch {
+	case result.OriginNotified:
+		fmt.Printf("%s; the origin is pushing it now\n", approved)
+	case result.NotifyError != "":
+		fmt.Printf("%s, but the origin could not be told: %s\n", approved, result.NotifyError)
+		fmt.Println("Ask its admin to run 'openhub admin resume-replica' for this instance.")
+	default:
+		fmt.Printf("%s; the origin's pushes are accepted from its next sync\n", approved)
+	}
+}
+
+func adminListPeers() {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
 
-	meta, err := store.GetMetadata(owner, name)
+	peers, err := instance.NewPeerStore(cfg.StoragePath).List()
 	if err != nil {
 		fmt.Printf("error: %v\n", err)
 		os.Exit(1)
 	}
 
-	bundle := map[string]interface{}{
-		"repo":  fmt.Sprintf("%s/%s", owner, name),
-		"replicas": meta.Replicas,
+	if jsonOutput {
+		if peers == nil {
+			peers = []instance.Peer{}
+		}
+		printJSON(peers)
+		return
 	}
-
-	jsonData, err := json.MarshalIndent(bundle, "", "  ")
-	if err != nil {
-		fmt.Printf("json error: %v\n", err)
-		os.Exit(1)
+	if len(peers) == 0 {
+		fmt.Println("No trusted peers")
+		return
 	}
 
-	fmt.Println(string(jsonData))
+	for _, peer := range peers {
+		fmt.Printf("%s\n", peer.InstanceID)
+		fmt.Printf("  Public key: %s\n", peer.PublicKey)
+		if peer.URL != "" {
+			fmt.Printf("  URL: %s\n", peer.URL)
+		}
+		if len(peer.Capabilities) > 0 {
+			fmt.Printf("  Capabilities: %s\n", strings.Join(peer.Capabilities, ", "))
+		}
+		if !peer.LastHandshake.IsZero() {
+			fmt.Printf("  Last handshake: %s\n", peer.LastHandshake.Format(time.RFC3339))
+		} else {
+			fmt.Println("  Last handshake: never (pinned manually or by registration)")
+		}
+	}
+}
+
+// federateSearchTimeout bounds how long one peer may take to answer, so an
+// unreachable instance doesn't stall the whole search.
+const federateSearchTimeout = 15 * time.Second
+
+// federatedRepo is one repository found across the federation, with every
+// instance that serves a copy.
+type federatedRepo struct {
+	Owner          string   `json:"owner"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	OriginInstance string   `json:"origin_instance"`
+	CloneURLs      []string `json:"clone_urls"`
+}
+
+// adminFederateSearch asks every trusted peer that advertised discovery for
+// its public repositories matching query, merging copies of the same repo
+// served by its origin and its replicas.
+func adminFederateSearch(query string) {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	peers, err := instance.NewPeerStore(cfg.StoragePath).List()
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var targets []instance.Peer
+	for _, peer := range peers {
+		if peer.URL == "" || !peer.Supports("discovery") {
+			continue
+		}
+		targets = append(targets, peer)
+	}
+	if len(targets) == 0 {
+		fmt.Println("No trusted peers support discovery (run 'openhub admin handshake' first)")
+		if jsonOutput {
+			printJSON([]federatedRepo{})
+		}
+		return
+	}
+
+	client := federationClient()
+	client.Timeout = federateSearchTimeout
+
+	type answer struct {
+		peer  instance.Peer
+		repos []server.FederatedRepo
+		err   error
+	}
+	answers := make([]answer, len(targets))
+	var wg sync.WaitGroup
+	for i, peer := range targets {
+		wg.Add(1)
+		go func(i int, peer instance.Peer) {
+			defer wg.Done()
+			repos, err := fetchFederatedRepos(client, peer.URL, query)
+			answers[i] = answer{peer: peer, repos: repos, err: err}
+		}(i, peer)
+	}
+	wg.Wait()
+
+	found := make(map[string]*federatedRepo)
+	var order []string
+	for _, a := range answers {
+		if a.err != nil {
+			fmt.Printf("warning: %s (%s): %v\n", a.peer.InstanceID, a.peer.URL, a.err)
+			continue
+		}
+		for _, r := range a.repos {
+			key := r.OriginInstance + " " + r.Owner + "/" + r.Name
+			fr, ok := found[key]
+			if !ok {
+				fr = &federatedRepo{
+					Owner:          r.Owner,
+					Name:           r.Name,
+					OriginInstance: r.OriginInstance,
+				}
+				found[key] = fr
+				order = append(order, key)
+			}
+			// Prefer the origin's copy of the description, which replicas
+			// may lag behind.
+			if fr.Description == "" || !r.Replica {
+				fr.Description = r.Description
+			}
+			if r.Replica {
+				fr.CloneURLs = append(fr.CloneURLs, r.CloneURL)
+			} else {
+				fr.CloneURLs = append([]string{r.CloneURL}, fr.CloneURLs...)
+			}
+		}
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		a, b := found[order[i]], found[order[j]]
+		if a.Owner+"/"+a.Name != b.Owner+"/"+b.Name {
+			return a.Owner+"/"+a.Name < b.Owner+"/"+b.Name
+		}
+		return a.OriginInstance < b.OriginInstance
+	})
+
+	if jsonOutput {
+		repos := []*federatedRepo{}
+		for _, key := range order {
+			repos = append(repos, found[key])
+		}
+		printJSON(repos)
+		return
+	}
+	if len(order) == 0 {
+		fmt.Println("No repositories found")
+		return
+	}
+	for _, key := range order {
+		fr := found[key]
+		fmt.Printf("%s/%s\n", fr.Owner, fr.Name)
+		if fr.Description != "" {
+			fmt.Printf("  %s\n", fr.Description)
+		}
+		fmt.Printf("  Origin: %s\n", fr.OriginInstance)
+		for _, u := range fr.CloneURLs {
+			fmt.Printf("  Clone: %s\n", u)
+		}
+	}
+}
+
+// fetchFederatedRepos lists the public repositories the instance at baseURL
+// serves that match query.
+func fetchFederatedRepos(client *http.Client, baseURL, query string) ([]server.FederatedRepo, error) {
+	u := strings.TrimSuffix(baseURL, "/") + "/api/federation/repos"
+	if query != "" {
+		u += "?q=" + url.QueryEscape(query)
+	}
+
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool                   `json:"success"`
+		Error   string                 `json:"error"`
+		Repos   []server.FederatedRepo `json:"repos"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode response (status %d): %w", resp.StatusCode, err)
+	}
+	if !result.Success {
+		if result.Error == "" {
+			result.Error = resp.Status
+		}
+		return nil, fmt.Errorf("%s", result.Error)
+	}
+	return result.Repos, nil
+}
+
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// adminLogs prints the server's recent log lines and, with follow, streams
+// new ones until interrupted.
+func adminLogs(token string, follow bool, lines int, filters []string) {
+	token = cliToken(token)
+	if token == "" {
+		fmt.Println("error: an admin API token is required (--token, OPENHUB_TOKEN or a profile)")
+		os.Exit(1)
+	}
+
+	apiURL := cliAPIURL()
+
+	q := url.Values{}
+	q.Set("lines", strconv.Itoa(lines))
+	if follow {
+		q.Set("follow", "1")
+	}
+	for _, f := range filters {
+		q.Add("filter", f)
+	}
+
+	req, err := http.NewRequest("GET", apiURL+"/api/v1/admin/logs?"+q.Encode(), nil)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := apiDo(req)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		fmt.Printf("error: %s\n", result.Error)
+		os.Exit(1)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e logstream.Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			continue
+		}
+		if jsonOutput {
+			fmt.Fprintln(stdout, data)
+			continue
+		}
+		fmt.Printf("%s %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Message)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	if follow {
+		fmt.Println("error: log stream closed by server")
+		os.Exit(1)
+	}
+}
+
+// adminQuota shows one owner's quota status, or every owner's along with the
+// configured limits.
+func adminQuota(owner string) {
+	q := getQuotas()
+
+	if owner != "" {
+		st, err := q.Status(owner)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		if jsonOutput {
+			printJSON(st)
+			return
+		}
+		printQuotaStatus(st)
+		return
+	}
+
+	settings, err := q.Settings()
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	def := settings.For("")
+	if !jsonOutput {
+		if def.MaxMB > 0 {
+			fmt.Printf("Default: %d MB, warn at %d%%, %d day grace period\n", def.MaxMB, def.WarnPercent, def.GraceDays)
+		} else {
+			fmt.Println("Default: unlimited")
+		}
+	}
+
+	repos, err := getStorage().ListRepos()
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	owners := []quota.Status{}
+	seen := make(map[string]bool)
+	for _, repo := range repos {
+		if seen[repo.Owner] {
+			continue
+		}
+		seen[repo.Owner] = true
+		st, err := q.Status(repo.Owner)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		if jsonOutput {
+			owners = append(owners, st)
+			continue
+		}
+		fmt.Println()
+		printQuotaStatus(st)
+	}
+
+	if jsonOutput {
+		printJSON(map[string]interface{}{"default": def, "owners": owners})
+	}
+}
+
+func printQuotaStatus(st quota.Status) {
+	fmt.Printf("%s: %s\n", st.Owner, st.Level)
+	if st.LimitBytes > 0 {
+		fmt.Printf("  Usage: %.1f of %d MB\n", float64(st.UsageBytes)/(1<<20), st.LimitBytes>>20)
+	} else {
+		fmt.Printf("  Usage: %.1f MB (unlimited)\n", float64(st.UsageBytes)/(1<<20))
+	}
+	if st.GraceEnds != nil {
+		fmt.Printf("  Over since: %s\n", st.ExceededAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("  Grace ends: %s\n", st.GraceEnds.Format("2006-01-02 15:04:05"))
+	}
+}
+
+func adminSetQuota(owner string, l quota.Limits) {
+	if l.WarnPercent < 0 || l.WarnPercent > 100 {
+		fmt.Println("error: --warn-percent must be between 0 and 100")
+		os.Exit(1)
+	}
+	if l.GraceDays < 0 {
+		fmt.Println("error: --grace-days must not be negative")
+		os.Exit(1)
+	}
+
+	if err := getQuotas().SetLimits(owner, l); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(map[string]interface{}{"owner": owner, "limits": l})
+		return
+	}
+
+	switch {
+	case owner == "":
+		fmt.Println("Default quota updated")
+	case l == quota.Limits{}:
+		fmt.Printf("Quota override removed for %s\n", owner)
+	default:
+		fmt.Printf("Quota updated for %s\n", owner)
+	}
+}
+
+// adminResetAccess is for a user who has lost every key and token and their
+// recovery codes: it revokes whatever credentials they had and prints an
+// invite link for them to sign back in with.
+func adminResetAccess(username string, expires time.Duration) {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	authStore, err := openAuthStore(cfg.StoragePath, os.Getenv("OPENHUB_MASTER_KEY"))
+	if err != nil {
+		fmt.Printf("auth store init: %v\n", err)
+		os.Exit(1)
+	}
+
+	code, err := authStore.ResetAccess(username, expires)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	err = audit.New(cfg.StoragePath).Record(audit.Entry{
+		Actor:  "cli",
+		Action: "user.reset-access",
+		Target: username,
+		Detail: fmt.Sprintf("revoked all keys, tokens and recovery codes; invite valid for %s", expires),
+	})
+	if err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+
+	baseURL := os.Getenv("OPENHUB_EXTERNAL_URL")
+	if baseURL == "" {
+		baseURL = cliAPIURL()
+	}
+	link := fmt.Sprintf("%s/api/v1/users/recover?%s", strings.TrimSuffix(baseURL, "/"), url.Values{"user": {username}, "code": {code}}.Encode())
+
+	if jsonOutput {
+		printJSON(map[string]interface{}{
+			"username":   username,
+			"code":       code,
+			"link":       link,
+			"expires_at": time.Now().Add(expires).UTC(),
+		})
+		return
+	}
+
+	fmt.Printf("✓ Revoked all SSH keys, API tokens and recovery codes of %s\n", username)
+	fmt.Println("")
+	fmt.Printf("Invite link (valid for %s, works once):\n", expires)
+	fmt.Printf("  %s\n", link)
+	fmt.Println("")
+	fmt.Println("Send it to the user over a channel you trust. They sign back in with:")
+	fmt.Printf("  curl -X POST %s/api/v1/users/recover \\\n", strings.TrimSuffix(baseURL, "/"))
+	fmt.Printf("    -d '{\"username\": \"%s\", \"code\": \"%s\", \"ssh_key\": \"<public key>\"}'\n", username, code)
+}
+
+// adminAudit prints audit log entries, oldest first, straight from the
+// instance's storage.
+func adminAudit(f audit.Filter, since, until string, limit int) {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	var err error
+	now := time.Now()
+	if f.Since, err = audit.ParseTime(since, now); err != nil {
+		fmt.Printf("error: --since: %v\n", err)
+		os.Exit(1)
+	}
+	if f.Until, err = audit.ParseTime(until, now); err != nil {
+		fmt.Printf("error: --until: %v\n", err)
+		os.Exit(1)
+	}
+
+	entries, err := audit.New(cfg.StoragePath).Query(f, limit)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		if entries == nil {
+			entries = []audit.Entry{}
+		}
+		printJSON(entries)
+		return
+	}
+
+	for _, e := range entries {
+		actor := e.Actor
+		if actor == "" {
+			actor = "-"
+		}
+		line := fmt.Sprintf("%s %-12s %-20s %s", e.Time.Local().Format("2006-01-02 15:04:05"), actor, e.Action, e.Target)
+		if e.Detail != "" {
+			line += " (" + e.Detail + ")"
+		}
+		if e.Client != "" {
+			line += " from " + e.Client
+		}
+		fmt.Println(line)
+	}
+}
+
+func adminGenMasterKey(path string) {
+	if err := auth.GenerateMasterKey(path); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	if jsonOutput {
+		printJSON(map[string]string{"path": path})
+		return
+	}
+	fmt.Printf("✓ Master key written to %s\n", path)
+	fmt.Println("Keep it outside the storage directory and its backups. To encrypt")
+	fmt.Println("existing users, stop the server and run:")
+	fmt.Printf("  openhub admin rekey-users --new-key %s\n", path)
+}
+
+// adminRekeyUsers re-encrypts every user record under the key in newKeyFile,
+// or decrypts them when it is empty. Records are read with the current key
+// from $OPENHUB_MASTER_KEY.
+func adminRekeyUsers(newKeyFile string) {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	authStore, err := openAuthStore(cfg.StoragePath, os.Getenv("OPENHUB_MASTER_KEY"))
+	if err != nil {
+		fmt.Printf("auth store init: %v\n", err)
+		os.Exit(1)
+	}
+
+	var next auth.Cipher
+	if newKeyFile != "" {
+		next, err = auth.LoadMasterKey(newKeyFile)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	n, err := authStore.Rekey(next)
+	if err != nil {
+		fmt.Printf("rekey failed after %d users: %v\n", n, err)
+		fmt.Println("Fix the problem and run the same command again; rekeyed users are skipped.")
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(map[string]interface{}{"users": n, "encrypted": next != nil})
+		return
+	}
+	if next == nil {
+		fmt.Printf("✓ Decrypted %d users\n", n)
+		fmt.Println("Start the server without --master-key.")
+		return
+	}
+	fmt.Printf("✓ Encrypted %d users\n", n)
+	fmt.Printf("Start the server with --master-key %s and set OPENHUB_MASTER_KEY=%s for CLI commands.\n", newKeyFile, newKeyFile)
+}
+
+func getStorage() *storage.Storage {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	store, err := storage.New(cfg.StoragePath)
+	if err != nil {
+		fmt.Printf("storage init: %v\n", err)
+		os.Exit(1)
+	}
+	return store
+}
+
+// cliAudit records an action taken by a local admin command.
+// adminCreateInvite issues a registration invite code for when the server
+// runs with --registration invite.
+func adminCreateInvite(expires time.Duration, note string) {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	authStore, err := openAuthStore(cfg.StoragePath, os.Getenv("OPENHUB_MASTER_KEY"))
+	if err != nil {
+		fmt.Printf("auth store init: %v\n", err)
+		os.Exit(1)
+	}
+
+	invite, code, err := authStore.CreateInviteCode("cli", note, expires)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	cliAudit("invite.create", invite.ID, note)
+
+	if jsonOutput {
+		printJSON(map[string]interface{}{
+			"id":         invite.ID,
+			"code":       code,
+			"expires_at": invite.ExpiresAt,
+		})
+		return
+	}
+
+	baseURL := os.Getenv("OPENHUB_EXTERNAL_URL")
+	if baseURL == "" {
+		baseURL = cliAPIURL()
+	}
+
+	fmt.Printf("Invite code (valid for %s, works once):\n", expires)
+	fmt.Printf("  %s\n", code)
+	fmt.Println("")
+	fmt.Println("The new user registers with:")
+	fmt.Printf("  curl -X POST %s/api/v1/users/register \\\n", strings.TrimSuffix(baseURL, "/"))
+	fmt.Printf("    -d '{\"username\": \"<name>\", \"invite\": \"%s\", \"ssh_key\": \"<public key>\"}'\n", code)
+}
+
+func cliAudit(action, target, detail string) {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	err := audit.New(cfg.StoragePath).Record(audit.Entry{Actor: "cli", Action: action, Target: target, Detail: detail})
+	if err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+}
+
+func getQuotas() *quota.Store {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+	return quota.New(cfg.StoragePath, getStorage())
+}
+
+func federationClient() *http.Client {
+	tlsCfg, err := tlsconfig.Client(
+		os.Getenv("OPENHUB_FEDERATION_CA"),
+		os.Getenv("OPENHUB_FEDERATION_CERT"),
+		os.Getenv("OPENHUB_FEDERATION_KEY"),
+	)
+	if err != nil {
+		fmt.Printf("federation TLS: %v\n", err)
+		os.Exit(1)
+	}
+
+	client := &http.Client{}
+	if tlsCfg != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsCfg}
+	}
+	return client
+}
+
+func parseRefList(list string) []string {
+	var refs []string
+	for _, ref := range strings.Split(list, ",") {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+		if !storage.ValidRefPattern(ref) {
+			fmt.Printf("invalid ref pattern: %s\n", ref)
+			os.Exit(1)
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// adminAPI sends a JSON request to an admin endpoint and decodes the reply
+// into out, exiting with the server's error if it refused. With --json the
+// reply is also printed, and callers print nothing more.
+func adminAPI(method, path string, body, out interface{}) {
+	data := apiCall(method, path, body, out)
+	if jsonOutput {
+		printRawJSON(data)
+	}
+}
+
+// apiCall is adminAPI without the --json output, for requests made along
+// the way, and returns the reply.
+func apiCall(method, path string, body, out interface{}) []byte {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			fmt.Printf("json error: %v\n", err)
+			os.Exit(1)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, cliAPIURL()+path, reader)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := apiDo(req)
+	if err != nil {
+		fmt.Printf("request error: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("read error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var result struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		fmt.Printf("json decode error (status %d): %v\n", resp.StatusCode, err)
+		os.Exit(1)
+	}
+	if !result.Success {
+		if result.Error == "" {
+			result.Error = resp.Status
+		}
+		fmt.Printf("error: %s\n", result.Error)
+		os.Exit(1)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			fmt.Printf("json decode error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	return data
+}
+
+// adminAddReplica has the server handshake with the replica and register
+// the repo there, so it works from any machine with an admin token.
+func adminAddReplica(path, target string, refs []string, allowChain, invite bool) {
+	owner, name := splitRepoPath(path)
+
+	fmt.Println("Registering with replica...")
+	var result struct {
+		Replica storage.Replica `json:"replica"`
+		Pending bool            `json:"pending"`
+	}
+	adminAPI("POST", "/api/v1/admin/replicas", map[string]interface{}{
+		"owner":       owner,
+		"name":        name,
+		"url":         target,
+		"refs":        refs,
+		"allow_chain": allowChain,
+		"invite":      invite,
+	}, &result)
+	if jsonOutput {
+		return
+	}
+
+	fmt.Printf("✓ Replica configured successfully\n")
+	fmt.Printf("URL: %s\n", result.Replica.URL)
+	if invite {
+		if len(refs) > 0 {
+			fmt.Printf("Refs: %s\n", strings.Join(refs, ", "))
+		}
+		fmt.Printf("Invitation Key: %s\n", result.Replica.InvitationKey)
+		fmt.Println("\nShare this invitation key with the replica administrator. Nothing is")
+		fmt.Println("pushed until they review the repo and accept it there with:")
+		fmt.Printf("  openhub admin accept-replication %s\n", result.Replica.InvitationKey)
+		return
+	}
+	if result.Pending {
+		fmt.Println("The replica only takes repos from origins its admin trusts. Pushes are")
+		fmt.Println("refused until they run 'openhub admin approve-replication' there.")
+	}
+	if len(refs) > 0 {
+		fmt.Printf("Refs: %s\n", strings.Join(refs, ", "))
+	}
+	fmt.Printf("Invitation Key: %s\n", result.Replica.InvitationKey)
+	fmt.Println("\nShare this invitation key with the replica administrator.")
+	fmt.Println("They need it to accept replication from this origin.")
+	fmt.Printf("Replica will receive updates on push\n")
+}
+
+// adminAllowChain grants or withdraws a replica's consent to replicate the
+// repo on to instances of its own. The replica learns of the change with the
+// next sync.
+func adminAllowChain(path, replicaURL string, allow bool) {
+	owner, name := splitRepoPath(path)
+
+	adminAPI("POST", "/api/v1/admin/replicas/chain", map[string]interface{}{
+		"owner": owner,
+		"name":  name,
+		"url":   replicaURL,
+		"allow": allow,
+	}, nil)
+	if jsonOutput {
+		return
+	}
+
+	if allow {
+		fmt.Printf("%s may now chain %s/%s to further replicas\n", replicaURL, owner, name)
+	} else {
+		fmt.Printf("%s may no longer chain %s/%s\n", replicaURL, owner, name)
+	}
+	fmt.Println("The replica picks this up on its next sync.")
+}
+
+// adminPauseReplica pauses or resumes the replica at replicaURL, all of a
+// repo's replicas, or with all, replication on the whole instance.
+func adminPauseReplica(path, replicaURL string, all, pause bool) {
+	verb := "resume"
+	if pause {
+		verb = "pause"
+	}
+	if all == (path != "") {
+		fmt.Printf("error: give <owner/name>, or --all to %s all replication\n", verb)
+		os.Exit(1)
+	}
+
+	body := map[string]interface{}{"paused": pause}
+	if !all {
+		owner, name := splitRepoPath(path)
+		body["owner"] = owner
+		body["name"] = name
+		body["url"] = replicaURL
+	}
+
+	var result struct {
+		Replicas []string `json:"replicas"`
+	}
+	adminAPI("POST", "/api/v1/admin/replicas/pause", body, &result)
+	if jsonOutput {
+		return
+	}
+
+	switch {
+	case all && pause:
+		fmt.Println("Replication paused on this instance")
+		fmt.Println("Jobs queue up until 'openhub admin resume-replica --all'.")
+	case all:
+		fmt.Println("Replication resumed on this instance")
+	default:
+		for _, u := range result.Replicas {
+			fmt.Printf("%s %sd for %s\n", u, verb, path)
+		}
+		if !pause {
+			fmt.Println("A sync is queued to bring them up to date.")
+		}
+	}
+}
+
+func adminUnsubscribeReplication(path string) {
+	owner, name := splitRepoPath(path)
+
+	var result struct {
+		Origin string `json:"origin"`
+	}
+	adminAPI("POST", "/api/v1/admin/replicas/unsubscribe", map[string]string{"owner": owner, "name": name}, &result)
+	if jsonOutput {
+		return
+	}
+
+	fmt.Printf("%s stopped pushing %s/%s here\n", result.Origin, owner, name)
+}
+
+func adminRemoveReplica(path, instanceID string, dryRun, force bool) {
+	owner, name := splitRepoPath(path)
+
+	if dryRun || !force {
+		if !confirmRemoval(removeReplicaRemoval(owner, name, instanceID), "Remove this replica?", dryRun) {
+			return
+		}
+	}
+
+	q := url.Values{"owner": {owner}, "name": {name}, "instance_id": {instanceID}}
+	adminAPI("DELETE", "/api/v1/admin/replicas?"+q.Encode(), nil, nil)
+	if jsonOutput {
+		return
+	}
+
+	fmt.Printf("Replica removed from %s/%s\n", owner, name)
+}
+
+// deleteRepoRemoval describes what deleting owner/name removes here and on
+// its replicas, and what it leaves registered elsewhere.
+func deleteRepoRemoval(owner, name string) removal {
+	var result struct {
+		Metadata storage.Metadata `json:"metadata"`
+	}
+	q := url.Values{"owner": {owner}, "name": {name}}
+	apiCall("GET", "/api/v1/repos/metadata?"+q.Encode(), nil, &result)
+	meta := result.Metadata
+
+	r := removal{
+		Command:    "delete-repo",
+		Target:     owner + "/" + name,
+		Removes:    []string{fmt.Sprintf("the repository %s/%s with its issues, pull requests, releases and commit statuses", owner, name)},
+		LeftBehind: []string{},
+	}
+	if len(meta.Webhooks) > 0 {
+		r.Removes = append(r.Removes, fmt.Sprintf("%d webhook(s)", len(meta.Webhooks)))
+	}
+	for _, rep := range meta.Replicas {
+		if !rep.Enabled {
+			r.LeftBehind = append(r.LeftBehind, fmt.Sprintf("the copy on disabled replica %s (instance %s), which isn't told of the delete; its admin can run admin delete-repo there", rep.URL, rep.InstanceID))
+			continue
+		}
+		item := fmt.Sprintf("the copy on replica %s (instance %s), which is told to delete it", rep.URL, rep.InstanceID)
+		if rep.AllowChain {
+			item += " along with its own replicas"
+		}
+		r.Removes = append(r.Removes, item)
+	}
+	if len(meta.Replicas) > 0 {
+		r.LeftBehind = append(r.LeftBehind, "the copy on any replica that can't be reached right now, as the delete isn't retried")
+	}
+	if meta.ReplicaOf != nil {
+		r.LeftBehind = append(r.LeftBehind, fmt.Sprintf("this instance's registration as a replica on the origin (instance %s), which keeps pushing here until its admin runs admin remove-replica", meta.ReplicaOf.InstanceID))
+	}
+	return r
+}
+
+// removeReplicaRemoval describes what unregistering the replica instanceID
+// of owner/name removes and what the replica keeps.
+func removeReplicaRemoval(owner, name, instanceID string) removal {
+	var result struct {
+		Replicas []storage.Replica `json:"replicas"`
+	}
+	q := url.Values{"owner": {owner}, "name": {name}}
+	apiCall("GET", "/api/v1/admin/replicas?"+q.Encode(), nil, &result)
+
+	for _, rep := range result.Replicas {
+		if rep.InstanceID != instanceID {
+			continue
+		}
+		r := removal{
+			Command: "remove-replica",
+			Target:  owner + "/" + name,
+			Removes: []string{fmt.Sprintf("the registration of replica %s (instance %s), which gets no further updates", rep.URL, rep.InstanceID)},
+			LeftBehind: []string{
+				fmt.Sprintf("the replica's copy of %s/%s and the account this instance pushed with; its admin can run admin delete-repo there, then admin doctor to find the account", owner, name),
+			},
+		}
+		if rep.AllowChain {
+			r.LeftBehind = append(r.LeftBehind, "the replicas it chained the repository to, which stop getting updates too")
+		}
+		return r
+	}
+
+	fmt.Printf("error: no replica with instance ID %s\n", instanceID)
+	os.Exit(1)
+	return removal{}
+}
+
+// fetchReplicas returns the repo's replicas, tokens and invitation keys
+// included.
+func fetchReplicas(owner, name string) []storage.Replica {
+	var result struct {
+		Replicas []storage.Replica `json:"replicas"`
+	}
+	q := url.Values{"owner": {owner}, "name": {name}}
+	adminAPI("GET", "/api/v1/admin/replicas?"+q.Encode(), nil, &result)
+	return result.Replicas
+}
+
+func adminListReplicas(path string) {
+	owner, name := splitRepoPath(path)
+
+	replicas := fetchReplicas(owner, name)
+	if jsonOutput {
+		return
+	}
+	if len(replicas) == 0 {
+		fmt.Println("No replicas configured")
+		return
+	}
+
+	fmt.Printf("Replicas for %s/%s:\n", owner, name)
+	for i, r := range replicas {
+		status := "enabled"
+		if r.Pending {
+			status = "pending (waiting for the replica's admin to accept)"
+		} else if !r.Enabled {
+			status = "disabled"
+		}
+		fmt.Printf("%d. URL: %s\n", i+1, r.URL)
+		fmt.Printf("   Instance ID: %s\n", r.InstanceID)
+		fmt.Printf("   Invitation Key: %s\n", r.InvitationKey)
+		fmt.Printf("   Status: %s\n", status)
+		if len(r.Refs) > 0 {
+			fmt.Printf("   Refs: %s\n", strings.Join(r.Refs, ", "))
+		}
+		if !r.LastSynced.IsZero() {
+			fmt.Printf("   Last Synced: %s\n", r.LastSynced.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Println()
+	}
+}
+
+func adminRecoveryBundle(path string) {
+	owner, name := splitRepoPath(path)
+
+	replicas := fetchReplicas(owner, name)
+	if jsonOutput {
+		return
+	}
+
+	bundle := map[string]interface{}{
+		"repo":     fmt.Sprintf("%s/%s", owner, name),
+		"replicas": replicas,
+	}
+
+	jsonData, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		fmt.Printf("json error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(string(jsonData))
+}
+
+// adminRestoreFromRecovery rebuilds a lost origin repo from the replicas
+// listed in its recovery bundle. Each replica is first moved over to this
+// instance, which may have a new ID and key, and the repo content and
+// metadata are then pulled back from the first replica that can serve them.
+func adminRestoreFromRecovery(bundleFile string) {
+	data, err := os.ReadFile(bundleFile)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var bundle struct {
+		Repo     string            `json:"repo"`
+		Replicas []storage.Replica `json:"replicas"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		fmt.Printf("invalid recovery bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	parts := strings.Split(bundle.Repo, "/")
+	if len(parts) != 2 {
+		fmt.Println("invalid recovery bundle: repo must be owner/name")
+		os.Exit(1)
+	}
+	owner, name := parts[0], parts[1]
+
+	if len(bundle.Replicas) == 0 {
+		fmt.Println("recovery bundle lists no replicas")
+		os.Exit(1)
+	}
+
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	store := getStorage()
+	if store.RepoExists(owner, name) {
+		fmt.Printf("error: %s/%s already exists; delete it before restoring\n", owner, name)
+		os.Exit(1)
+	}
+
+	inst, err := instance.LoadOrCreate(cfg.StoragePath)
+	if err != nil {
+		fmt.Printf("error loading instance: %v\n", err)
+		os.Exit(1)
+	}
+
+	client := federationClient()
+
+	fmt.Println("Re-registering with replicas...")
+	var registered []storage.Replica
+	for _, r := range bundle.Replicas {
+		req := map[string]string{
+			"owner":              owner,
+			"repo":               name,
+			"instance_id":        r.InstanceID,
+			"invitation_key":     r.InvitationKey,
+			"origin_instance_id": inst.ID,
+			"origin_public_key":  inst.PublicKey,
+		}
+		resp, err := postRecovery(client, r, "/api/repos/reregister-replication", req)
+		if err != nil {
+			fmt.Printf("  ✗ %s: %v\n", r.URL, err)
+			continue
+		}
+		resp.Body.Close()
+		fmt.Printf("  ✓ %s\n", r.URL)
+		registered = append(registered, r)
+	}
+
+	if len(registered) == 0 {
+		fmt.Println("error: no replica accepted re-registration")
+		os.Exit(1)
+	}
+
+	var meta storage.Metadata
+	var repoBundle string
+	fetched := false
+	for _, r := range registered {
+		fmt.Printf("Fetching %s/%s from %s...\n", owner, name, r.URL)
+		meta, repoBundle, err = fetchRecoveryExport(client, r, owner, name, inst.ID)
+		if err != nil {
+			fmt.Printf("  ✗ %v\n", err)
+			continue
+		}
+		fetched = true
+		break
+	}
+
+	if !fetched {
+		fmt.Println("error: no replica could serve the repository")
+		os.Exit(1)
+	}
+	if repoBundle != "" {
+		defer os.Remove(repoBundle)
+	}
+
+	if err := store.CreateRepo(owner, name); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	refCount := 0
+	if repoBundle != "" {
+		result, err := store.ApplyBundle(owner, name, repoBundle, nil, true)
+		if err != nil {
+			store.DeleteRepo(owner, name)
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		refCount = len(result.Updated) + len(result.Forced)
+	}
+
+	// Replicas keep their tokens and invitation keys but now belong to this
+	// instance; sync state starts over.
+	meta.ReplicaOf = nil
+	meta.Replicas = nil
+	meta.Mirrors = nil
+	for _, r := range bundle.Replicas {
+		meta.Replicas = append(meta.Replicas, storage.Replica{
+			InstanceID:    inst.ID,
+			URL:           r.URL,
+			Token:         r.Token,
+			InvitationKey: r.InvitationKey,
+			Enabled:       r.Enabled,
+			Refs:          r.Refs,
+			AllowChain:    r.AllowChain,
+			PeerID:        r.PeerID,
+		})
+	}
+
+	if err := store.SetMetadata(owner, name, meta); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		urls := []string{}
+		for _, r := range registered {
+			urls = append(urls, r.URL)
+		}
+		printJSON(map[string]interface{}{
+			"repo":       owner + "/" + name,
+			"refs":       refCount,
+			"registered": urls,
+		})
+		return
+	}
+	fmt.Printf("✓ Restored %s/%s (%d refs)\n", owner, name, refCount)
+	if len(registered) < len(bundle.Replicas) {
+		fmt.Println("Some replicas did not accept re-registration; remove and re-add them with add-replica.")
+	}
+}
+
+// postRecovery sends a recovery request to a replica, authenticated with the
+// replication token from the recovery bundle. Non-2xx responses are returned
+// as errors.
+func postRecovery(client *http.Client, r storage.Replica, path string, body map[string]string) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", r.URL+path, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+r.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var result struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&result) == nil && result.Error != "" {
+			return nil, fmt.Errorf("%s", result.Error)
+		}
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// fetchRecoveryExport downloads a replica's copy of the repo. It returns the
+// replicated metadata and the path of a temporary bundle file, which is empty
+// if the replica has no refs.
+func fetchRecoveryExport(client *http.Client, r storage.Replica, owner, name, instanceID string) (storage.Metadata, string, error) {
+	var meta storage.Metadata
+
+	resp, err := postRecovery(client, r, "/api/repos/recovery-export", map[string]string{
+		"owner":          owner,
+		"repo":           name,
+		"instance_id":    instanceID,
+		"invitation_key": r.InvitationKey,
+	})
+	if err != nil {
+		return meta, "", err
+	}
+	defer resp.Body.Close()
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return meta, "", fmt.Errorf("unexpected response type %q", resp.Header.Get("Content-Type"))
+	}
+
+	gotMeta := false
+	bundlePath := ""
+	fail := func(err error) (storage.Metadata, string, error) {
+		if bundlePath != "" {
+			os.Remove(bundlePath)
+		}
+		return meta, "", err
+	}
+
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("read response: %w", err))
+		}
+
+		switch part.FormName() {
+		case "metadata":
+			if err := json.NewDecoder(part).Decode(&meta); err != nil {
+				return fail(fmt.Errorf("invalid metadata: %w", err))
+			}
+			gotMeta = true
+		case "bundle":
+			f, err := os.CreateTemp("", "openhub-recovery-*.bundle")
+			if err != nil {
+				return meta, "", err
+			}
+			bundlePath = f.Name()
+			_, err = io.Copy(f, part)
+			f.Close()
+			if err != nil {
+				return fail(fmt.Errorf("download bundle: %w", err))
+			}
+		}
+	}
+
+	if !gotMeta {
+		return fail(fmt.Errorf("response is missing metadata"))
+	}
+
+	return meta, bundlePath, nil
+}
+
+func adminDNSRecords(domain, apiURL string, sshPort int) {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	instanceID := ""
+	if inst, err := instance.LoadOrCreate(cfg.StoragePath); err == nil {
+		instanceID = inst.ID
+	}
+
+	records, err := discovery.Records(domain, apiURL, sshPort, instanceID)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(records)
+		return
+	}
+	for _, r := range records {
+		fmt.Println(r)
+	}
 }
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/gitbench"
+)
+
+// benchResult is one transport, operation and repo size, timed over several
+// runs. Bytes is the source repository's size on disk.
+type benchResult struct {
+	Transport string  `json:"transport"`
+	Op        string  `json:"op"`
+	Commits   int     `json:"commits"`
+	Bytes     int64   `json:"bytes"`
+	Runs      int     `json:"runs"`
+	MeanMS    float64 `json:"mean_ms"`
+	MinMS     float64 `json:"min_ms"`
+	MaxMS     float64 `json:"max_ms"`
+	MBPerSec  float64 `json:"mb_per_sec"`
+}
+
+func (r benchResult) key() string {
+	return fmt.Sprintf("%s/%s/%d", r.Transport, r.Op, r.Commits)
+}
+
+// benchOptions are the flags of the bench command.
+type benchOptions struct {
+	sizes         string
+	files         int
+	runs          int
+	transports    string
+	output        string
+	baseline      string
+	maxRegression float64
+	keep          bool
+	verbose       bool
+}
+
+func benchCommand(fs *flag.FlagSet) func([]string) {
+	var o benchOptions
+	var sizes []string
+	for _, size := range gitbench.Sizes {
+		sizes = append(sizes, strconv.Itoa(size.Commits))
+	}
+	fs.StringVar(&o.sizes, "sizes", strings.Join(sizes, ","), "comma-separated commit counts of the repositories to benchmark")
+	fs.IntVar(&o.files, "files", gitbench.Files, "files per generated repository")
+	fs.IntVar(&o.runs, "runs", 3, "timed runs per transport, operation and size")
+	fs.StringVar(&o.transports, "transports", strings.Join(gitbench.Transports, ","), "comma-separated transports to benchmark")
+	fs.StringVar(&o.output, "output", "", "write results as JSON to this file")
+	fs.StringVar(&o.baseline, "baseline", "", "compare against results saved with --output")
+	fs.Float64Var(&o.maxRegression, "max-regression", 20, "fail when a mean latency exceeds the baseline by more than this percentage")
+	fs.BoolVar(&o.keep, "keep", false, "keep the temporary storage directory")
+	fs.BoolVar(&o.verbose, "verbose", false, "show server logs")
+	return func([]string) { runBench(o) }
+}
+
+// runBench measures clone and push latency and throughput over the git HTTP
+// and SSH servers, running in this process against generated repositories.
+// Results can be saved and compared against an earlier run, failing when a
+// transport got slower than the allowed margin.
+func runBench(o benchOptions) {
+	var commitCounts []int
+	for _, s := range strings.Split(o.sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			fmt.Printf("error: invalid size %q\n", s)
+			os.Exit(1)
+		}
+		commitCounts = append(commitCounts, n)
+	}
+	var transportList []string
+	for _, t := range strings.Split(o.transports, ",") {
+		t = strings.TrimSpace(t)
+		if t != "http" && t != "ssh" {
+			fmt.Printf("error: unknown transport %q; use http or ssh\n", t)
+			os.Exit(1)
+		}
+		transportList = append(transportList, t)
+	}
+	if o.runs < 1 || o.files < 1 {
+		fmt.Println("error: --runs and --files must be at least 1")
+		os.Exit(1)
+	}
+
+	if !o.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	env, err := newBenchEnv()
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	defer env.close(o.keep)
+	if o.keep {
+		fmt.Printf("Storage: %s\n", env.dir)
+	}
+
+	var results []benchResult
+	for i, commits := range commitCounts {
+		name := fmt.Sprintf("size-%d", commits)
+		rng := mathrand.New(mathrand.NewSource(int64(i + 1)))
+		size, err := env.Seed(name, commits, o.files, rng)
+		if err != nil {
+			fmt.Printf("error generating %s: %v\n", name, err)
+			env.close(o.keep)
+			os.Exit(1)
+		}
+
+		for _, transport := range transportList {
+			for _, op := range []string{"clone", "push"} {
+				r, err := env.measure(transport, op, name, o.runs)
+				if err != nil {
+					fmt.Printf("error: %s %s of %d commits: %v\n", transport, op, commits, err)
+					env.close(o.keep)
+					os.Exit(1)
+				}
+				r.Commits = commits
+				r.Bytes = size
+				if r.MeanMS > 0 {
+					r.MBPerSec = float64(size) / (1 << 20) / (r.MeanMS / 1000)
+				}
+				results = append(results, r)
+			}
+		}
+	}
+
+	var base map[string]benchResult
+	if o.baseline != "" {
+		base, err = loadBaseline(o.baseline)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			env.close(o.keep)
+			os.Exit(1)
+		}
+	}
+
+	regressions := printBench(results, base, o.maxRegression)
+
+	if o.output != "" {
+		data, _ := json.MarshalIndent(results, "", "  ")
+		if err := os.WriteFile(o.output, data, 0644); err != nil {
+			fmt.Printf("error: write %s: %v\n", o.output, err)
+			env.close(o.keep)
+			os.Exit(1)
+		}
+		fmt.Printf("\nWrote %s\n", o.output)
+	}
+
+	if regressions > 0 {
+		fmt.Printf("\n✗ %d result(s) regressed by more than %.0f%%\n", regressions, o.maxRegression)
+		env.close(o.keep)
+		os.Exit(1)
+	}
+}
+
+func printBench(results []benchResult, base map[string]benchResult, maxRegression float64) int {
+	regressions := 0
+	fmt.Printf("%-9s %-6s %8s %10s %11s %11s %11s %9s", "TRANSPORT", "OP", "COMMITS", "SIZE", "MEAN", "MIN", "MAX", "MB/S")
+	if base != nil {
+		fmt.Printf(" %9s", "VS BASE")
+	}
+	fmt.Println()
+
+	for _, r := range results {
+		fmt.Printf("%-9s %-6s %8d %10s %9.1fms %9.1fms %9.1fms %9.2f",
+			r.Transport, r.Op, r.Commits, formatSize(r.Bytes), r.MeanMS, r.MinMS, r.MaxMS, r.MBPerSec)
+		if base != nil {
+			if b, ok := base[r.key()]; ok && b.MeanMS > 0 {
+				change := (r.MeanMS - b.MeanMS) / b.MeanMS * 100
+				mark := ""
+				if change > maxRegression {
+					mark = " ✗"
+					regressions++
+				}
+				fmt.Printf(" %+8.1f%%%s", change, mark)
+			} else {
+				fmt.Printf(" %9s", "-")
+			}
+		}
+		fmt.Println()
+	}
+	return regressions
+}
+
+func loadBaseline(path string) (map[string]benchResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read baseline: %w", err)
+	}
+	var results []benchResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("parse baseline: %w", err)
+	}
+	base := make(map[string]benchResult, len(results))
+	for _, r := range results {
+		base[r.key()] = r
+	}
+	return base, nil
+}
+
+// benchEnv is a gitbench instance in a temporary directory.
+type benchEnv struct {
+	*gitbench.Env
+	dir string
+}
+
+func newBenchEnv() (*benchEnv, error) {
+	dir, err := os.MkdirTemp("", "openhub-bench-")
+	if err != nil {
+		return nil, err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	env, err := gitbench.NewEnv(dir, executable)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &benchEnv{Env: env, dir: dir}, nil
+}
+
+func (e *benchEnv) close(keep bool) {
+	e.Close()
+	if !keep {
+		os.RemoveAll(e.dir)
+	}
+}
+
+// measure times runs of op against the repository name. A clone fetches
+// everything into a fresh bare repository; a push sends all of a local copy
+// into a fresh empty repository on the server.
+func (e *benchEnv) measure(transport, op, name string, runs int) (benchResult, error) {
+	r := benchResult{Transport: transport, Op: op, Runs: runs}
+	work := filepath.Join(e.dir, "work")
+
+	var total time.Duration
+	for i := 0; i < runs; i++ {
+		if err := os.RemoveAll(work); err != nil {
+			return r, err
+		}
+		if err := os.MkdirAll(work, 0755); err != nil {
+			return r, err
+		}
+
+		var elapsed time.Duration
+		switch op {
+		case "clone":
+			start := time.Now()
+			if err := e.Clone(transport, name, filepath.Join(work, "clone.git")); err != nil {
+				return r, err
+			}
+			elapsed = time.Since(start)
+		case "push":
+			source := filepath.Join(work, "source.git")
+			target, err := e.PreparePush(name, source)
+			if err != nil {
+				return r, err
+			}
+			start := time.Now()
+			if err := e.Push(transport, source, target); err != nil {
+				return r, err
+			}
+			elapsed = time.Since(start)
+			e.Remove(target)
+		}
+
+		ms := float64(elapsed) / float64(time.Millisecond)
+		if i == 0 || ms < r.MinMS {
+			r.MinMS = ms
+		}
+		if ms > r.MaxMS {
+			r.MaxMS = ms
+		}
+		total += elapsed
+	}
+	r.MeanMS = float64(total) / float64(time.Millisecond) / float64(runs)
+	return r, nil
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	default:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+}
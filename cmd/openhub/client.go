@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jeremytregunna/openhub/internal/config"
+)
+
+const defaultAPIURL = "http://localhost:3000"
+
+// profileFlag is the profile chosen with the global --profile flag.
+var profileFlag string
+
+var (
+	clientOnce  sync.Once
+	clientURL   string
+	clientToken string
+)
+
+// loadClient works out which server the CLI talks to and the token it
+// sends there. OPENHUB_API_URL and OPENHUB_TOKEN override the profile,
+// chosen by --profile, $OPENHUB_PROFILE or the config file's default. The
+// profile's token is only sent to the profile's own server.
+func loadClient() {
+	clientOnce.Do(func() {
+		path, err := config.CLIPath()
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg, err := config.LoadCLI(path)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+
+		name := profileFlag
+		if name == "" {
+			name = os.Getenv("OPENHUB_PROFILE")
+		}
+		if name == "" {
+			name = cfg.Default
+		}
+		var profile config.Profile
+		if name != "" {
+			var ok bool
+			if profile, ok = cfg.Profiles[name]; !ok {
+				fmt.Printf("error: no profile %s in %s\n", name, path)
+				os.Exit(1)
+			}
+			if profile.Token != "" {
+				if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o077 != 0 {
+					fmt.Fprintf(os.Stderr, "warning: %s holds API tokens but can be read by other users; chmod 600 it\n", path)
+				}
+			}
+		}
+
+		clientURL = profile.URL
+		clientToken = profile.Token
+		if u := strings.TrimSuffix(os.Getenv("OPENHUB_API_URL"), "/"); u != "" {
+			if u != profile.URL {
+				clientToken = ""
+			}
+			clientURL = u
+		}
+		if clientURL == "" {
+			clientURL = defaultAPIURL
+		}
+		if t := os.Getenv("OPENHUB_TOKEN"); t != "" {
+			clientToken = t
+		}
+	})
+}
+
+// cliAPIURL returns the base URL of the server the CLI talks to.
+func cliAPIURL() string {
+	loadClient()
+	return clientURL
+}
+
+// cliToken returns the API token given with --token, or else the one from
+// OPENHUB_TOKEN or the profile.
+func cliToken(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	loadClient()
+	return clientToken
+}
+
+// apiDo sends a request to the API with the CLI's token, unless it already
+// carries one.
+func apiDo(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" {
+		if token := cliToken(""); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func apiGet(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return apiDo(req)
+}
+
+func apiPost(url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return apiDo(req)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a node of the CLI's command tree. A group holds subcommands; a
+// leaf has setup, which defines the command's flags and returns the function
+// that runs it with the positional arguments.
+type command struct {
+	name    string
+	summary string
+	// args describes the positional arguments: <required> ones, [optional]
+	// ones, and a trailing "..." for any number more.
+	args string
+	// help is shown by --help after the summary.
+	help        string
+	setup       func(fs *flag.FlagSet) func(args []string)
+	subcommands []*command
+}
+
+func (c *command) find(name string) *command {
+	for _, sub := range c.subcommands {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// run dispatches args to the subcommand they name, or parses them and runs
+// the command if it is a leaf. path is how the command was invoked, such as
+// "openhub admin".
+func (c *command) run(path string, args []string) {
+	if c.setup == nil {
+		if len(args) == 0 {
+			c.printHelp(os.Stdout, path, nil)
+			os.Exit(1)
+		}
+		switch args[0] {
+		case "-h", "-help", "--help", "help":
+			c.printHelp(os.Stdout, path, nil)
+			return
+		}
+		sub := c.find(args[0])
+		if sub == nil {
+			fmt.Printf("unknown command: %s %s\n", path, args[0])
+			fmt.Printf("Run '%s --help' for a list.\n", path)
+			os.Exit(1)
+		}
+		sub.run(path+" "+sub.name, args[1:])
+		return
+	}
+
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	run := c.setup(fs)
+
+	positional, err := parseArgs(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		c.printHelp(os.Stdout, path, fs)
+		return
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		fmt.Printf("Run '%s --help' for usage.\n", path)
+		os.Exit(2)
+	}
+
+	min, max := c.argCount()
+	if len(positional) < min || max >= 0 && len(positional) > max {
+		fmt.Printf("usage: %s\n", c.usageLine(path, fs))
+		os.Exit(1)
+	}
+	run(positional)
+}
+
+// parseArgs parses the flags in args, which may come before, after or
+// between the positional arguments, and returns the positional ones.
+// Everything after "--" is positional.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// argCount returns how many positional arguments the command takes, with a
+// max of -1 for no limit.
+func (c *command) argCount() (min, max int) {
+	for _, a := range strings.Fields(c.args) {
+		switch {
+		case strings.HasSuffix(a, "..."):
+			return min, -1
+		case strings.HasPrefix(a, "<"):
+			min++
+			max++
+		case strings.HasPrefix(a, "["):
+			max++
+		}
+	}
+	return min, max
+}
+
+func (c *command) usageLine(path string, fs *flag.FlagSet) string {
+	line := path
+	if c.setup == nil {
+		return line + " <command>"
+	}
+	if c.args != "" {
+		line += " " + c.args
+	}
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		line += " [flags]"
+	}
+	return line
+}
+
+func (c *command) printHelp(w io.Writer, path string, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "usage: %s\n", c.usageLine(path, fs))
+	if c.summary != "" {
+		fmt.Fprintf(w, "\n%s\n", c.summary)
+	}
+	if c.help != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(c.help))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if c.setup == nil {
+		fmt.Fprintln(w, "\nCommands:")
+		for _, sub := range c.subcommands {
+			fmt.Fprintf(tw, "  %s\t%s\n", sub.name, sub.summary)
+		}
+		tw.Flush()
+		fmt.Fprintf(w, "\nRun '%s <command> --help' for a command's arguments and flags.\n", path)
+		return
+	}
+
+	first := true
+	fs.VisitAll(func(f *flag.Flag) {
+		if first {
+			fmt.Fprintln(w, "\nFlags:")
+			first = false
+		}
+		arg, usage := flag.UnquoteUsage(f)
+		name := "--" + f.Name
+		if arg != "" {
+			name += " <" + arg + ">"
+		}
+		if !isZeroDefault(f.DefValue) && !strings.Contains(usage, "(default") {
+			usage += fmt.Sprintf(" (default: %s)", f.DefValue)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", name, usage)
+	})
+	tw.Flush()
+}
+
+func isZeroDefault(v string) bool {
+	switch v {
+	case "", "0", "false", "0s":
+		return true
+	}
+	return false
+}
+
+// isBoolFlag reports whether f is a flag given without a value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// noFlags is the setup of a command that takes no flags.
+func noFlags(run func(args []string)) func(*flag.FlagSet) func([]string) {
+	return func(*flag.FlagSet) func([]string) { return run }
+}
+
+// optionalArg returns args[i], or "" if the optional argument wasn't given.
+func optionalArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
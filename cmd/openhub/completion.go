@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// globalFlags are given before the command, and handled by main.
+var globalFlags = []struct{ name, arg, usage string }{
+	{"profile", "name", "CLI profile to use"},
+	{"json", "", "print results as JSON"},
+}
+
+// walk calls visit for c and every command below it, with the names leading
+// to each from the root and, for leaves, the flags they take.
+func (c *command) walk(path []string, visit func(path []string, c *command, flags []*flag.Flag)) {
+	if c.setup != nil {
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.setup(fs)
+		var flags []*flag.Flag
+		fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+		visit(path, c, flags)
+		return
+	}
+	visit(path, c, nil)
+	for _, sub := range c.subcommands {
+		sub.walk(append(path[:len(path):len(path)], sub.name), visit)
+	}
+}
+
+// writeCompletion writes a completion script for shell covering root's
+// commands and flags.
+func writeCompletion(w io.Writer, root *command, shell string) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, root)
+	case "zsh":
+		writeZshCompletion(w, root)
+	case "fish":
+		writeFishCompletion(w, root)
+	default:
+		return fmt.Errorf("unsupported shell %q; use bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+// The bash and zsh scripts find the command being completed by walking the
+// words typed so far, descending into each one that names a command below
+// the current one. Anything else, such as a flag's value or a positional
+// argument, is skipped.
+
+func writeBashCompletion(w io.Writer, root *command) {
+	fmt.Fprintln(w, "# bash completion for openhub. Load it with")
+	fmt.Fprintln(w, "#   source <(openhub completion bash)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_openhub_words() {")
+	fmt.Fprintln(w, "\tcase $1 in")
+	root.walk(nil, func(path []string, c *command, flags []*flag.Flag) {
+		var words []string
+		for _, sub := range c.subcommands {
+			words = append(words, sub.name)
+		}
+		if path == nil {
+			for _, g := range globalFlags {
+				words = append(words, "--"+g.name)
+			}
+		}
+		for _, f := range flags {
+			words = append(words, "--"+f.Name)
+		}
+		fmt.Fprintf(w, "\t%q) echo %q ;;\n", strings.Join(path, " "), strings.Join(words, " "))
+	})
+	fmt.Fprintln(w, "\t*) return 1 ;;")
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprint(w, `
+_openhub() {
+	local cur=${COMP_WORDS[COMP_CWORD]} cmdpath="" next words i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		--profile) ((i++)) ;;
+		-*) ;;
+		*)
+			next="${cmdpath:+$cmdpath }${COMP_WORDS[i]}"
+			_openhub_words "$next" >/dev/null && cmdpath=$next ;;
+		esac
+	done
+	words=$(_openhub_words "$cmdpath")
+	# A command's positional arguments complete as file names.
+	if [[ $cur != -* && ( -z $words || $words == --* ) && -n $cmdpath ]]; then
+		return
+	fi
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+
+complete -o default -F _openhub openhub
+`)
+}
+
+func writeZshCompletion(w io.Writer, root *command) {
+	fmt.Fprintln(w, "#compdef openhub")
+	fmt.Fprintln(w, "# zsh completion for openhub. Save it as _openhub in a directory in $fpath,")
+	fmt.Fprintln(w, "# or load it with")
+	fmt.Fprintln(w, "#   source <(openhub completion zsh)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_openhub_items() {")
+	fmt.Fprintln(w, "\tleaf=0")
+	fmt.Fprintln(w, "\tcase $1 in")
+	root.walk(nil, func(path []string, c *command, flags []*flag.Flag) {
+		var items []string
+		for _, sub := range c.subcommands {
+			items = append(items, shellQuote(sub.name+":"+sub.summary))
+		}
+		if path == nil {
+			for _, g := range globalFlags {
+				items = append(items, shellQuote("--"+g.name+":"+g.usage))
+			}
+		}
+		for _, f := range flags {
+			_, usage := flag.UnquoteUsage(f)
+			items = append(items, shellQuote("--"+f.Name+":"+usage))
+		}
+		leaf := ""
+		if c.setup != nil {
+			leaf = "leaf=1; "
+		}
+		fmt.Fprintf(w, "\t%q) %sitems=(%s) ;;\n", strings.Join(path, " "), leaf, strings.Join(items, " "))
+	})
+	fmt.Fprintln(w, "\t*) return 1 ;;")
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprint(w, `
+_openhub() {
+	local cmdpath="" next leaf i
+	local -a items
+	for ((i = 2; i < CURRENT; i++)); do
+		case ${words[i]} in
+		--profile) ((i++)) ;;
+		-*) ;;
+		*)
+			next="${cmdpath:+$cmdpath }${words[i]}"
+			_openhub_items "$next" && cmdpath=$next ;;
+		esac
+	done
+	_openhub_items "$cmdpath"
+	if ((leaf)) && [[ ${words[CURRENT]} != -* ]]; then
+		_files
+	else
+		_describe -t commands openhub items
+	fi
+}
+
+if [[ $funcstack[1] == _openhub ]]; then
+	_openhub "$@"
+else
+	compdef _openhub openhub
+fi
+`)
+}
+
+func writeFishCompletion(w io.Writer, root *command) {
+	fmt.Fprintln(w, "# fish completion for openhub. Save it as ~/.config/fish/completions/openhub.fish,")
+	fmt.Fprintln(w, "# or load it with")
+	fmt.Fprintln(w, "#   openhub completion fish | source")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "complete -c openhub -f")
+	for _, g := range globalFlags {
+		line := "complete -c openhub -n __fish_use_subcommand -l " + g.name
+		if g.arg != "" {
+			line += " -r"
+		}
+		fmt.Fprintf(w, "%s -d %s\n", line, shellQuote(g.usage))
+	}
+	root.walk(nil, func(path []string, c *command, flags []*flag.Flag) {
+		var seen []string
+		for _, name := range path {
+			seen = append(seen, "__fish_seen_subcommand_from "+name)
+		}
+		cond := strings.Join(seen, "; and ")
+
+		if c.setup != nil {
+			for _, f := range flags {
+				_, usage := flag.UnquoteUsage(f)
+				line := fmt.Sprintf("complete -c openhub -n %s -l %s", shellQuote(cond), f.Name)
+				if !isBoolFlag(f) {
+					line += " -r"
+				}
+				fmt.Fprintf(w, "%s -d %s\n", line, shellQuote(usage))
+			}
+			if c.args != "" {
+				fmt.Fprintf(w, "complete -c openhub -n %s -F\n", shellQuote(cond))
+			}
+			return
+		}
+
+		var names []string
+		for _, sub := range c.subcommands {
+			names = append(names, sub.name)
+		}
+		subCond := "__fish_use_subcommand"
+		if path != nil {
+			subCond = cond + "; and not __fish_seen_subcommand_from " + strings.Join(names, " ")
+		}
+		for _, sub := range c.subcommands {
+			fmt.Fprintf(w, "complete -c openhub -n %s -a %s -d %s\n", shellQuote(subCond), sub.name, shellQuote(sub.summary))
+		}
+	})
+}
+
+// shellQuote single-quotes s for bash, zsh and fish.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// removal is what a destructive command is about to do, shown before it
+// does it.
+type removal struct {
+	Command string `json:"command"`
+	Target  string `json:"target"`
+	// Removes lists what goes away.
+	Removes []string `json:"removes"`
+	// LeftBehind lists what stays but no longer belongs to anything, such
+	// as registrations on other instances, with how to clean it up.
+	LeftBehind []string `json:"left_behind"`
+	DryRun     bool     `json:"dry_run"`
+}
+
+// confirmRemoval shows r and reports whether to go ahead with it: never for
+// a dry run, and otherwise once the user agrees. Declining exits.
+func confirmRemoval(r removal, question string, dryRun bool) bool {
+	if dryRun && jsonOutput {
+		r.DryRun = true
+		printJSON(r)
+		return false
+	}
+
+	fmt.Printf("%s %s removes:\n", r.Command, r.Target)
+	for _, item := range r.Removes {
+		fmt.Printf("  - %s\n", item)
+	}
+	if len(r.LeftBehind) > 0 {
+		fmt.Println("and leaves behind:")
+		for _, item := range r.LeftBehind {
+			fmt.Printf("  - %s\n", item)
+		}
+	}
+	fmt.Println()
+
+	if dryRun {
+		fmt.Println("Dry run: nothing was changed.")
+		return false
+	}
+	return confirm(question)
+}
+
+// confirm asks question and reports whether the user agreed. Declining, or
+// giving no answer, exits.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err == io.EOF && line == "" {
+		fmt.Println()
+		fmt.Println("error: no answer; rerun with --force to skip confirmation")
+		os.Exit(1)
+	}
+	if err != nil && err != io.EOF {
+		fmt.Printf("\nerror: %v\n", err)
+		os.Exit(1)
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	fmt.Println("Aborted.")
+	os.Exit(1)
+	return false
+}
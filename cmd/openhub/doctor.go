@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/config"
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/server"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// minGitVersion is the oldest git with everything the server runs, merge-tree
+// --write-tree being the newest.
+var minGitVersion = [2]int{2, 38}
+
+// doctorTimeout bounds each replica admin doctor contacts.
+const doctorTimeout = 10 * time.Second
+
+// finding is a problem admin doctor found, and what to do about it.
+type finding struct {
+	Check   string `json:"check"`
+	Problem string `json:"problem"`
+	Fix     string `json:"fix"`
+}
+
+// doctor runs checks against an instance's storage directory, collecting
+// what they find.
+type doctor struct {
+	storagePath string
+	store       *storage.Storage
+	repos       []storage.Repo
+	// instanceOK is set once the instance's identity checks out, so it can
+	// be loaded without LoadOrCreate replacing anything.
+	instanceOK bool
+	findings   []finding
+}
+
+type doctorCheck struct {
+	name string
+	run  func()
+}
+
+func (d *doctor) report(check, fix, format string, args ...interface{}) {
+	d.findings = append(d.findings, finding{Check: check, Problem: fmt.Sprintf(format, args...), Fix: fix})
+}
+
+// adminDoctor checks the instance in $OPENHUB_STORAGE for problems that
+// keep it from serving or replicating: a damaged storage layout, a missing
+// or outdated git, broken keys, leftover metadata and replication accounts,
+// and replicas it can't reach. It exits non-zero when it finds any.
+func adminDoctor(offline bool) {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	d := &doctor{storagePath: cfg.StoragePath}
+	checks := []doctorCheck{
+		{"storage", d.checkStorage},
+		{"git", d.checkGit},
+		{"keys", d.checkKeys},
+		{"metadata", d.checkMetadata},
+		{"replication users", d.checkReplicationUsers},
+	}
+	if !offline {
+		checks = append(checks, doctorCheck{"replicas", d.checkReplicas})
+	}
+
+	if !jsonOutput {
+		fmt.Printf("Checking %s\n\n", cfg.StoragePath)
+	}
+	for _, check := range checks {
+		before := len(d.findings)
+		check.run()
+		if !jsonOutput {
+			if len(d.findings) == before {
+				fmt.Printf("✓ %s\n", check.name)
+			} else {
+				fmt.Printf("✗ %s\n", check.name)
+			}
+			for _, f := range d.findings[before:] {
+				fmt.Printf("    %s\n", f.Problem)
+				fmt.Printf("      fix: %s\n", f.Fix)
+			}
+		}
+		// Without storage, the remaining checks have nothing to look at.
+		if d.store == nil {
+			break
+		}
+	}
+
+	if jsonOutput {
+		findings := d.findings
+		if findings == nil {
+			findings = []finding{}
+		}
+		printJSON(map[string]interface{}{"storage": cfg.StoragePath, "findings": findings})
+	} else if len(d.findings) == 0 {
+		fmt.Println("\nNo problems found")
+	} else {
+		fmt.Printf("\n%d problem(s) found\n", len(d.findings))
+	}
+	if len(d.findings) > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkStorage checks that the storage directory is one a server has run in
+// and can write to, and that every repository directory holds a bare git
+// repository.
+func (d *doctor) checkStorage() {
+	const check = "storage"
+	info, err := os.Stat(d.storagePath)
+	if err != nil || !info.IsDir() {
+		d.report(check, "set OPENHUB_STORAGE to the server's --storage directory",
+			"%s is not a directory", d.storagePath)
+		return
+	}
+
+	probe, err := os.CreateTemp(d.storagePath, ".doctor-*")
+	if err != nil {
+		d.report(check, "run as the user the server runs as, or fix the directory's ownership",
+			"%s is not writable: %v", d.storagePath, err)
+	} else {
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+
+	if _, err := os.Stat(filepath.Join(d.storagePath, "instance.json")); os.IsNotExist(err) {
+		d.report(check, "set OPENHUB_STORAGE to the server's --storage directory, or start the server once to initialize it",
+			"%s has no instance.json; no server has run here", d.storagePath)
+	}
+
+	if d.store, err = storage.New(d.storagePath); err != nil {
+		d.report(check, "fix the storage directory's permissions", "%v", err)
+		return
+	}
+	if d.repos, err = d.store.ListRepos(); err != nil {
+		d.report(check, "fix the storage directory's permissions", "%v", err)
+		return
+	}
+
+	var repos []storage.Repo
+	for _, repo := range d.repos {
+		path := d.store.RepoPath(repo.Owner, repo.Name)
+		if isBareRepo(path) {
+			repos = append(repos, repo)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(path, "openhub.json")); err == nil {
+			d.report(check, fmt.Sprintf("restore the repository with admin restore-from-recovery, or remove %s", path),
+				"%s/%s has metadata but no git repository", repo.Owner, repo.Name)
+		} else {
+			d.report(check, fmt.Sprintf("remove %s if it isn't needed", path),
+				"%s is not a git repository", path)
+		}
+	}
+	// Later checks only look at intact repositories.
+	d.repos = repos
+}
+
+func isBareRepo(path string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkGit checks that git is installed and new enough.
+func (d *doctor) checkGit() {
+	const check = "git"
+	fix := fmt.Sprintf("install git %d.%d or later in the server's $PATH", minGitVersion[0], minGitVersion[1])
+	if _, err := exec.LookPath("git"); err != nil {
+		d.report(check, fix, "git not found in $PATH")
+		return
+	}
+	out, err := exec.Command("git", "--version").Output()
+	if err != nil {
+		d.report(check, fix, "git --version failed: %v", err)
+		return
+	}
+
+	version := strings.TrimSpace(strings.TrimPrefix(string(out), "git version "))
+	fields := strings.SplitN(version, ".", 3)
+	if len(fields) < 2 {
+		d.report(check, fix, "can't parse git version %q", version)
+		return
+	}
+	major, _ := strconv.Atoi(fields[0])
+	minor, _ := strconv.Atoi(fields[1])
+	if major < minGitVersion[0] || major == minGitVersion[0] && minor < minGitVersion[1] {
+		d.report(check, fix, "git %s is too old; merges and conflict checks need %d.%d", version, minGitVersion[0], minGitVersion[1])
+	}
+}
+
+// checkKeys checks the SSH host key and the instance key peers pin. Either
+// one changing makes clients or peers refuse to talk to the server.
+func (d *doctor) checkKeys() {
+	const check = "keys"
+	hostKey := filepath.Join(d.storagePath, "ssh_host_key")
+	if info, err := os.Stat(hostKey); os.IsNotExist(err) {
+		if len(d.repos) > 0 {
+			d.report(check, "restore it from a backup; otherwise the server generates a new one and SSH clients warn that the host key changed",
+				"%s is missing", hostKey)
+		}
+	} else if err != nil {
+		d.report(check, "fix the file's permissions", "%v", err)
+	} else {
+		if _, err := loadHostKey(hostKey); err != nil {
+			d.report(check, "restore it from a backup, or remove it to have the server generate a new one; SSH clients will warn that the host key changed",
+				"%s is damaged: %v", hostKey, err)
+		}
+		if info.Mode().Perm()&0o077 != 0 {
+			d.report(check, fmt.Sprintf("chmod 600 %s", hostKey), "%s can be read by other users", hostKey)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(d.storagePath, "instance.json")); os.IsNotExist(err) {
+		// Reported by the storage check.
+		return
+	}
+	if err := instance.Check(d.storagePath); err != nil {
+		d.report(check, "restore instance.json and instance_ed25519 from a backup; peers that pinned the old key reject a new one",
+			"%v", err)
+		return
+	}
+	d.instanceOK = true
+}
+
+// checkMetadata checks that every repository's metadata can be read.
+func (d *doctor) checkMetadata() {
+	for _, repo := range d.repos {
+		if _, err := d.store.GetMetadata(repo.Owner, repo.Name); err != nil {
+			path := filepath.Join(d.store.RepoPath(repo.Owner, repo.Name), "openhub.json")
+			d.report("metadata", fmt.Sprintf("repair %s, or remove it to reset the repository's settings", path),
+				"%s/%s: %v", repo.Owner, repo.Name, err)
+		}
+	}
+}
+
+// checkReplicationUsers matches the accounts origins push with against the
+// repositories replicated here: an account without a repository is left
+// over from a removed replica, and a replica without its account can't be
+// pushed to.
+func (d *doctor) checkReplicationUsers() {
+	const check = "replication users"
+	authStore, err := openAuthStore(d.storagePath, os.Getenv("OPENHUB_MASTER_KEY"))
+	if err != nil {
+		d.report(check, "set OPENHUB_MASTER_KEY to the server's --master-key", "open user store: %v", err)
+		return
+	}
+	if authStore.Encrypted() && os.Getenv("OPENHUB_MASTER_KEY") == "" {
+		d.report(check, "set OPENHUB_MASTER_KEY to the server's --master-key", "user records are encrypted")
+		return
+	}
+	users, err := authStore.ListUsers()
+	if err != nil {
+		d.report(check, "fix the users directory's permissions", "%v", err)
+		return
+	}
+
+	want := map[string]string{}
+	// unknown are the account prefixes of repositories whose metadata can't
+	// be read, which the metadata check reports.
+	var unknown []string
+	for _, repo := range d.repos {
+		meta, err := d.store.GetMetadata(repo.Owner, repo.Name)
+		if err != nil {
+			unknown = append(unknown, server.ReplicationUsername(repo.Owner, repo.Name, ""))
+			continue
+		}
+		if meta.ReplicaOf == nil {
+			continue
+		}
+		want[server.ReplicationUsername(repo.Owner, repo.Name, meta.ReplicaOf.InstanceID)] = repo.Owner + "/" + repo.Name
+	}
+
+	have := map[string]bool{}
+	for _, u := range users {
+		if !strings.HasPrefix(u.Username, "replication-") {
+			continue
+		}
+		have[u.Username] = true
+		if _, ok := want[u.Username]; !ok && !hasAnyPrefix(u.Username, unknown) {
+			d.report(check, fmt.Sprintf("remove %s", filepath.Join(d.storagePath, "users", u.Username+".json")),
+				"%s belongs to no replicated repository", u.Username)
+		}
+	}
+	for username, repo := range want {
+		if !have[username] {
+			d.report(check, "remove the replica on the origin with admin remove-replica and add it again",
+				"%s is a replica but its origin has no account to push with", repo)
+		}
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReplicas handshakes with every enabled replica of the repositories
+// here, checking that each answers as the instance it was added as.
+func (d *doctor) checkReplicas() {
+	const check = "replicas"
+	type target struct {
+		peerID string
+		repos  []string
+	}
+	targets := map[string]*target{}
+	for _, repo := range d.repos {
+		meta, err := d.store.GetMetadata(repo.Owner, repo.Name)
+		if err != nil {
+			continue
+		}
+		for _, r := range meta.Replicas {
+			if !r.Enabled {
+				continue
+			}
+			t := targets[r.URL]
+			if t == nil {
+				t = &target{}
+				targets[r.URL] = t
+			}
+			if r.PeerID != "" {
+				t.peerID = r.PeerID
+			}
+			t.repos = append(t.repos, repo.Owner+"/"+repo.Name)
+		}
+	}
+	if len(targets) == 0 || !d.instanceOK {
+		return
+	}
+
+	inst, err := instance.LoadOrCreate(d.storagePath)
+	if err != nil {
+		d.report(check, "see the keys check", "load instance: %v", err)
+		return
+	}
+	client := federationClient()
+	client.Timeout = doctorTimeout
+
+	urls := make([]string, 0, len(targets))
+	for url := range targets {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		t := targets[url]
+		hello, err := inst.Handshake(client, os.Getenv("OPENHUB_EXTERNAL_URL"), url, handshakeMaxSkew)
+		if err != nil {
+			d.report(check, "check the replica is up and reachable from this host, or remove it with admin remove-replica",
+				"%s, replica of %s: %v", url, strings.Join(t.repos, ", "), err)
+			continue
+		}
+		if t.peerID != "" && hello.InstanceID != t.peerID {
+			d.report(check, "if the replica was rebuilt, remove it with admin remove-replica and add it again",
+				"%s answers as instance %s, not %s", url, hello.InstanceID, t.peerID)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/activity"
+	"github.com/jeremytregunna/openhub/internal/activitypub"
+	"github.com/jeremytregunna/openhub/internal/config"
+	"github.com/jeremytregunna/openhub/internal/events"
+	"github.com/jeremytregunna/openhub/internal/policy"
+	"github.com/jeremytregunna/openhub/internal/quota"
+	"github.com/jeremytregunna/openhub/internal/signing"
+	"github.com/jeremytregunna/openhub/internal/storage"
+	"github.com/jeremytregunna/openhub/internal/webhooks"
+)
+
+// runHook is invoked by the scripts in the shared hooks directory, with the
+// repository passed through the environment by the git servers.
+func runHook(hook string) {
+	parts := strings.Split(os.Getenv("OPENHUB_HOOK_REPO"), "/")
+	if len(parts) != 2 {
+		fmt.Fprintln(os.Stderr, "hook: OPENHUB_HOOK_REPO not set")
+		os.Exit(1)
+	}
+	owner, name := parts[0], parts[1]
+
+	switch hook {
+	case "pre-receive":
+		hookPreReceive(owner, name)
+	case "post-receive":
+		hookPostReceive(owner, name, os.Getenv("OPENHUB_HOOK_USER"))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown hook: %s\n", hook)
+		os.Exit(1)
+	}
+}
+
+func hookPreReceive(owner, name string) {
+	store := getStorage()
+
+	meta, err := store.GetMetadata(owner, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+		os.Exit(1)
+	}
+
+	updates, err := policy.ParseUpdates(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+		os.Exit(1)
+	}
+
+	checkQuota(owner, updates)
+	checkContents(store.RepoPath(owner, name), meta.Policy, updates)
+
+	if meta.Policy == nil {
+		return
+	}
+
+	violations, err := policy.Check(store.RepoPath(owner, name), *meta.Policy, updates)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(meta.Policy.SignedBranches) > 0 {
+		unsigned, err := checkSignatures(store.RepoPath(owner, name), *meta.Policy, updates)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+			os.Exit(1)
+		}
+		violations = append(violations, unsigned...)
+	}
+
+	if len(violations) > 0 {
+		fmt.Fprintln(os.Stderr, "push rejected by repository policy:")
+		for _, v := range violations {
+			fmt.Fprintf(os.Stderr, "  %s\n", v)
+		}
+		os.Exit(1)
+	}
+}
+
+// checkContents rejects the push if it adds files that look like they hold
+// credentials or are too big, as the server's settings, passed through the
+// environment, and the repository's policy say.
+func checkContents(repoPath string, p *storage.Policy, updates []policy.RefUpdate) {
+	maxBlobMB, _ := strconv.Atoi(os.Getenv("OPENHUB_MAX_BLOB_MB"))
+	secrets, maxBlob := policy.ScanLimits(p, os.Getenv("OPENHUB_SCAN_SECRETS") != "", maxBlobMB)
+
+	problems, err := policy.Scan(repoPath, updates, secrets, maxBlob)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+		os.Exit(1)
+	}
+	if len(problems) == 0 {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "push rejected: it adds files that look like secrets or are too big:")
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "  %s\n", problem)
+	}
+	fmt.Fprintln(os.Stderr, "Rewrite the commits that add them, e.g. with git rebase -i, and push again.")
+	fmt.Fprintln(os.Stderr, "Treat any real credential as leaked and revoke it. If these are false positives,")
+	fmt.Fprintln(os.Stderr, "an admin can change the repository's policy with 'openhub admin set-policy'.")
+	os.Exit(1)
+}
+
+// checkSignatures verifies the commits pushed to signed branches against
+// every user's signing keys. The server passes its master key on to hooks,
+// so encrypted user records can be read.
+func checkSignatures(repoPath string, p storage.Policy, updates []policy.RefUpdate) ([]string, error) {
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+	authStore, err := openAuthStore(cfg.StoragePath, os.Getenv("OPENHUB_MASTER_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	users, err := authStore.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	return policy.CheckSignatures(repoPath, p, updates, signing.NewVerifier(users))
+}
+
+// hookPostReceive journals the accepted push for activity stats and queues
+// it for the event stream, the repository's webhooks and, when ActivityPub is
+// on, for publishing. The push has already happened by now, so failures are only
+// reported.
+func hookPostReceive(owner, name, user string) {
+	store := getStorage()
+	repoPath := store.RepoPath(owner, name)
+
+	updates, err := policy.ParseUpdates(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+		return
+	}
+
+	entries, err := activity.PushEntries(repoPath, user, updates)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+		return
+	}
+
+	if err := activity.Append(repoPath, entries...); err != nil {
+		fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+	}
+
+	cfg := config.Default()
+	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+
+	for _, e := range entries {
+		err := events.Enqueue(cfg.StoragePath, events.Event{
+			Kind:    events.KindPush,
+			Owner:   owner,
+			Repo:    name,
+			User:    user,
+			Ref:     e.Ref,
+			Old:     e.Old,
+			New:     e.New,
+			Commits: e.Commits,
+			Time:    e.Time,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+			break
+		}
+	}
+
+	if meta, err := store.GetMetadata(owner, name); err != nil {
+		fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+	} else {
+		for _, e := range entries {
+			err := webhooks.Enqueue(cfg.StoragePath, meta, webhooks.Event{
+				Kind:    webhooks.EventPush,
+				Owner:   owner,
+				Repo:    name,
+				User:    user,
+				Ref:     e.Ref,
+				Old:     e.Old,
+				New:     e.New,
+				Commits: e.Commits,
+				Time:    e.Time,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+				break
+			}
+		}
+	}
+
+	for _, e := range entries {
+		if e.New == "" || strings.Trim(e.New, "0") == "" {
+			continue
+		}
+		err := activitypub.Enqueue(cfg.StoragePath, activitypub.Event{
+			Kind:    activitypub.EventPush,
+			Owner:   owner,
+			Repo:    name,
+			User:    user,
+			Ref:     e.Ref,
+			Old:     e.Old,
+			New:     e.New,
+			Commits: e.Commits,
+			Time:    e.Time,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+			return
+		}
+	}
+}
+
+// checkQuota warns about an owner nearing or over their quota and rejects
+// the push once the grace period has ended. Pushes that only delete refs are
+// let through, since they're how an owner gets back under.
+func checkQuota(owner string, updates []policy.RefUpdate) {
+	st, err := getQuotas().Status(owner)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hook: %v\n", err)
+		os.Exit(1)
+	}
+	if st.Level == quota.LevelOK {
+		return
+	}
+
+	if st.Level == quota.LevelEnforced {
+		deletesOnly := true
+		for _, u := range updates {
+			if !u.IsDelete() {
+				deletesOnly = false
+			}
+		}
+		if !deletesOnly {
+			fmt.Fprintf(os.Stderr, "push rejected: %s\n", st.Message)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "warning: %s\n", st.Message)
+}
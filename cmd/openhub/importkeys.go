@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxKeysResponse bounds a forge's key list; real ones are a few KB.
+const maxKeysResponse = 1 << 20
+
+// keySource is a forge that publishes its users' SSH public keys at
+// <base>/<user>.keys, one per line.
+type keySource struct {
+	forge string // used in key names, e.g. "github"
+	label string // used in messages, e.g. "GitHub"
+	base  string
+	user  string
+}
+
+func (k keySource) keysURL() string {
+	return k.base + "/" + url.PathEscape(k.user) + ".keys"
+}
+
+// importedKey is a key userImportKeys added.
+type importedKey struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// userImportKeys registers the SSH keys a user has published on another
+// forge, skipping ones they already have.
+func userImportKeys(authStore *auth.AuthStore, auditLog *audit.Log, username string, src keySource) {
+	if _, err := authStore.GetUser(username); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	keys, err := fetchPublishedKeys(src)
+	if err == nil && len(keys) == 0 {
+		err = fmt.Errorf("%s user %s has no SSH keys", src.label, src.user)
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	added, skipped, err := addSSHKeys(authStore, auditLog, username, src, keys)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printJSON(map[string]interface{}{"username": username, "source": src.keysURL(), "added": added, "already_present": skipped})
+		return
+	}
+	for _, k := range added {
+		fmt.Printf("Added %s (%s)\n", k.Name, k.Fingerprint)
+	}
+	fmt.Printf("%d key(s) imported for %s from %s user %s", len(added), username, src.label, src.user)
+	if skipped > 0 {
+		fmt.Printf(", %d already present", skipped)
+	}
+	fmt.Println()
+}
+
+// addSSHKeys adds the keys fetched from src that username doesn't have
+// yet, naming them <forge>-<user>-<n>, and returns those added and how
+// many were already present.
+func addSSHKeys(authStore *auth.AuthStore, auditLog *audit.Log, username string, src keySource, keys []ssh.PublicKey) ([]importedKey, int, error) {
+	user, err := authStore.GetUser(username)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	have := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, k := range user.SSHKeys {
+		names[k.Name] = true
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.Key)); err == nil {
+			have[ssh.FingerprintSHA256(pub)] = true
+		}
+	}
+
+	added := []importedKey{}
+	skipped := 0
+	for _, pub := range keys {
+		fp := ssh.FingerprintSHA256(pub)
+		if have[fp] {
+			skipped++
+			continue
+		}
+		have[fp] = true
+
+		name := ""
+		for n := 1; name == "" || names[name]; n++ {
+			name = fmt.Sprintf("%s-%s-%d", src.forge, src.user, n)
+		}
+		names[name] = true
+
+		key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+		if err := authStore.AddSSHKey(username, name, key); err != nil {
+			return added, skipped, err
+		}
+		if err := auditLog.Record(audit.Entry{Actor: "cli", Action: "key.add", Target: username, Detail: name}); err != nil {
+			fmt.Printf("warning: %v\n", err)
+		}
+		added = append(added, importedKey{Name: name, Fingerprint: fp})
+	}
+	return added, skipped, nil
+}
+
+// fetchPublishedKeys downloads and parses the keys src publishes, which
+// may be none. Lines that aren't SSH public keys are an error, as they mean
+// the forge didn't answer with a key list.
+func fetchPublishedKeys(src keySource) ([]ssh.PublicKey, error) {
+	keysURL := src.keysURL()
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(keysURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("no %s user %s", src.label, src.user)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("fetch keys: %s returned %s", keysURL, resp.Status)
+	}
+
+	var keys []ssh.PublicKey
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxKeysResponse))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a list of SSH keys", keysURL)
+		}
+		keys = append(keys, pub)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read keys: %w", err)
+	}
+	return keys, nil
+}
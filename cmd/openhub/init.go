@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/config"
+	"github.com/jeremytregunna/openhub/internal/events"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// prompter asks questions on stdout and reads answers from a line reader, so
+// the wizard can also be scripted by piping answers in.
+type prompter struct {
+	in *bufio.Reader
+}
+
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Printf("\nerror: %v\n", err)
+		os.Exit(1)
+	}
+	if err == io.EOF && line == "" {
+		fmt.Println()
+		fmt.Println("error: input ended before setup finished")
+		os.Exit(1)
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def
+	}
+	return line
+}
+
+func (p *prompter) askPort(question string, def int) int {
+	for {
+		answer := p.ask(question, strconv.Itoa(def))
+		port, err := strconv.Atoi(answer)
+		if err == nil && port > 0 && port < 65536 {
+			return port
+		}
+		fmt.Println("  enter a port between 1 and 65535")
+	}
+}
+
+func (p *prompter) confirm(question string, def bool) bool {
+	d := "y/N"
+	if def {
+		d = "Y/n"
+	}
+	for {
+		switch strings.ToLower(p.ask(question+" ["+d+"]", "")) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Println("  answer yes or no")
+	}
+}
+
+// initSettings are the wizard's answers.
+type initSettings struct {
+	cfg         *config.Config
+	serviceUser string
+	adminUser   string
+	adminKey    string
+}
+
+func initCommand(fs *flag.FlagSet) func([]string) {
+	configDir := fs.String("config-dir", "/etc/openhub", "directory to write openhub.toml and openhub.env to")
+	unitPath := fs.String("unit", "/etc/systemd/system/openhub.service", "path to write the systemd unit to (empty to skip)")
+	return func([]string) { runInit(*configDir, *unitPath) }
+}
+
+// runInit walks a first-time operator through setting up an instance. It
+// creates the storage directory and an admin account, then writes the
+// server's config file, an environment file pointing at it and a systemd
+// unit that runs the server with them.
+func runInit(configDir, unitPath string) {
+
+	p := &prompter{in: bufio.NewReader(os.Stdin)}
+	s := initSettings{cfg: config.Default()}
+	cfg := s.cfg
+
+	fmt.Println("openhub setup")
+	fmt.Println()
+
+	cfg.StoragePath = absPath(p.ask("Storage directory for repositories", cfg.StoragePath))
+	cfg.SSHPort = p.askPort("SSH port", cfg.SSHPort)
+	cfg.HTTPPort = p.askPort("HTTP port", cfg.HTTPPort)
+	cfg.ExternalURL = p.ask("External URL users reach this instance at (blank to derive from requests)", "")
+
+	if p.confirm("Serve HTTPS with your own certificate?", false) {
+		cfg.HTTPSPort = p.askPort("HTTPS port", cfg.HTTPSPort)
+		for cfg.TLSCertFile == "" {
+			cfg.TLSCertFile = absPath(p.ask("TLS certificate file", ""))
+		}
+		for cfg.TLSKeyFile == "" {
+			cfg.TLSKeyFile = absPath(p.ask("TLS private key file", ""))
+		}
+		cfg.HTTPSRedirect = p.confirm("Redirect HTTP to HTTPS?", true)
+	}
+
+	fmt.Println()
+	s.adminUser = p.ask("Admin username", "admin")
+	for !isValidUsername(s.adminUser) {
+		fmt.Println("  usernames may contain letters, digits, '-', '_' and '.', and can't start or end with '.'")
+		s.adminUser = p.ask("Admin username", "admin")
+	}
+	s.adminKey = p.ask("Admin SSH public key, or a path to one (blank to add later)", defaultPublicKey())
+	if data, err := os.ReadFile(s.adminKey); err == nil {
+		s.adminKey = strings.TrimSpace(string(data))
+	}
+
+	if unitPath != "" {
+		s.serviceUser = p.ask("System user the service runs as", "openhub")
+	}
+
+	configPath := absPath(filepath.Join(configDir, "openhub.toml"))
+	envPath := filepath.Join(configDir, "openhub.env")
+	fmt.Println()
+	fmt.Printf("This will create %s, write %s, %s", cfg.StoragePath, configPath, envPath)
+	if unitPath != "" {
+		fmt.Printf(" and %s", unitPath)
+	}
+	fmt.Println(".")
+	if !p.confirm("Continue?", true) {
+		os.Exit(1)
+	}
+	fmt.Println()
+
+	token, codes := initAdmin(s)
+
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		fmt.Printf("error: create config dir: %v\n", err)
+		os.Exit(1)
+	}
+	if err := writeNew(p, configPath, []byte(serverConfig(cfg)), 0640); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := writeNew(p, envPath, []byte(serverEnv(cfg, configPath)), 0640); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if unitPath != "" {
+		executable, err := os.Executable()
+		if err != nil {
+			fmt.Printf("error: locate executable: %v\n", err)
+			os.Exit(1)
+		}
+		if err := writeNew(p, unitPath, []byte(systemdUnit(executable, envPath, s.serviceUser, cfg.StoragePath)), 0644); err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Setup complete.")
+	fmt.Println()
+	fmt.Printf("Admin API token for %s (shown once):\n%s\n", s.adminUser, token)
+	if len(codes) > 0 {
+		fmt.Println()
+		fmt.Printf("Recovery codes for %s (shown once, each works once):\n", s.adminUser)
+		for _, c := range codes {
+			fmt.Printf("  %s\n", c)
+		}
+	}
+	fmt.Println()
+	fmt.Println("Next steps:")
+	step := 1
+	if unitPath != "" {
+		fmt.Printf("  %d. sudo useradd --system --home %s %s   (if the user doesn't exist)\n", step, cfg.StoragePath, s.serviceUser)
+		step++
+		fmt.Printf("  %d. sudo chown -R %s %s\n", step, s.serviceUser, cfg.StoragePath)
+		step++
+		fmt.Printf("  %d. sudo systemctl daemon-reload && sudo systemctl enable --now %s\n", step, filepath.Base(unitPath))
+		step++
+	} else {
+		fmt.Printf("  %d. set -a; . %s; set +a; openhub server $OPENHUB_SERVER_FLAGS\n", step, envPath)
+		step++
+	}
+	if s.adminKey == "" {
+		fmt.Printf("  %d. openhub user add-key %s <key-name> \"<ssh-public-key>\"\n", step, s.adminUser)
+	}
+}
+
+// initAdmin creates the storage directory and the admin account, returning
+// the account's API token and, for a new account, its recovery codes.
+func initAdmin(s initSettings) (string, []string) {
+	if _, err := storage.New(s.cfg.StoragePath); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	authStore, err := openAuthStore(s.cfg.StoragePath, os.Getenv("OPENHUB_MASTER_KEY"))
+	if err != nil {
+		fmt.Printf("auth store init: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Re-running init against existing storage reuses the account.
+	var codes []string
+	if _, err := authStore.GetUser(s.adminUser); err == nil {
+		fmt.Printf("User %s already exists\n", s.adminUser)
+	} else {
+		if err := authStore.CreateUser(s.adminUser); err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Created user %s\n", s.adminUser)
+		if err := events.Enqueue(s.cfg.StoragePath, events.Event{Kind: events.KindUserCreated, Owner: s.adminUser, User: s.adminUser}); err != nil {
+			fmt.Printf("warning: %v\n", err)
+		}
+
+		codes, err = authStore.GenerateRecoveryCodes(s.adminUser)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if s.adminKey != "" {
+		if err := authStore.AddSSHKey(s.adminUser, "init", s.adminKey); err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added SSH key for %s\n", s.adminUser)
+	}
+
+	token, err := authStore.GenerateAPIToken(s.adminUser, "init")
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	return token, codes
+}
+
+// serverConfig renders the config file the server reads: every setting
+// that differs from its default. The storage path is left to the environment
+// file, where admin commands find it too.
+func serverConfig(cfg *config.Config) string {
+	def := config.Default()
+
+	var b strings.Builder
+	b.WriteString("# Written by openhub init. Keys are `openhub server` flag names;\n")
+	b.WriteString("# see `openhub` for the available flags.\n")
+	if cfg.SSHPort != def.SSHPort {
+		fmt.Fprintf(&b, "ssh-port = %d\n", cfg.SSHPort)
+	}
+	if cfg.HTTPPort != def.HTTPPort {
+		fmt.Fprintf(&b, "http-port = %d\n", cfg.HTTPPort)
+	}
+	if cfg.ExternalURL != "" {
+		fmt.Fprintf(&b, "external-url = %s\n", strconv.Quote(cfg.ExternalURL))
+	}
+	if cfg.TLSCertFile != "" {
+		if cfg.HTTPSPort != def.HTTPSPort {
+			fmt.Fprintf(&b, "https-port = %d\n", cfg.HTTPSPort)
+		}
+		fmt.Fprintf(&b, "tls-cert = %s\n", strconv.Quote(cfg.TLSCertFile))
+		fmt.Fprintf(&b, "tls-key = %s\n", strconv.Quote(cfg.TLSKeyFile))
+		if cfg.HTTPSRedirect {
+			b.WriteString("https-redirect = true\n")
+		}
+	}
+	return b.String()
+}
+
+// serverEnv renders the environment file the systemd unit loads: the
+// storage path, the config file, and OPENHUB_SERVER_FLAGS for any flags
+// passed to `openhub server` on top of it.
+func serverEnv(cfg *config.Config, configPath string) string {
+	var b strings.Builder
+	b.WriteString("# Written by openhub init. Server settings are in OPENHUB_CONFIG;\n")
+	b.WriteString("# OPENHUB_SERVER_FLAGS is passed to `openhub server` as well.\n")
+	fmt.Fprintf(&b, "OPENHUB_STORAGE=%s\n", cfg.StoragePath)
+	fmt.Fprintf(&b, "OPENHUB_CONFIG=%s\n", configPath)
+	b.WriteString("OPENHUB_SERVER_FLAGS=\"\"\n")
+	return b.String()
+}
+
+func systemdUnit(executable, envPath, user, storagePath string) string {
+	return fmt.Sprintf(`[Unit]
+Description=openhub federated git server
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+User=%s
+EnvironmentFile=%s
+ExecStart=%s server $OPENHUB_SERVER_FLAGS
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+ReadWritePaths=%s
+NoNewPrivileges=true
+ProtectSystem=strict
+ProtectHome=true
+PrivateTmp=true
+
+[Install]
+WantedBy=multi-user.target
+`, user, envPath, executable, storagePath)
+}
+
+// absPath resolves a path against the current directory, since the service
+// won't run from it. Empty paths stay empty.
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// writeNew writes a file, asking before replacing one that already exists.
+func writeNew(p *prompter, path string, data []byte, perm os.FileMode) error {
+	if _, err := os.Stat(path); err == nil {
+		if !p.confirm(fmt.Sprintf("%s exists. Overwrite?", path), false) {
+			fmt.Printf("Kept existing %s\n", path)
+			return nil
+		}
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	fmt.Printf("Wrote %s\n", path)
+	return nil
+}
+
+// defaultPublicKey suggests the invoking user's own SSH key, if they have one.
+func defaultPublicKey() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	for _, name := range []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"} {
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// isValidUsername applies the server's rule for owner names, since the admin
+// will own repositories under this name.
+func isValidUsername(name string) bool {
+	if name == "" || len(name) > 100 {
+		return false
+	}
+	for _, c := range name {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".")
+}
@@ -3,55 +3,79 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		usage()
-		os.Exit(1)
+	args := os.Args[1:]
+	// Global flags come before the command.
+	for len(args) > 0 {
+		if name, ok := strings.CutPrefix(args[0], "--profile="); ok {
+			profileFlag, args = name, args[1:]
+		} else if args[0] == "--profile" && len(args) > 1 {
+			profileFlag, args = args[1], args[2:]
+		} else if args[0] == "--json" {
+			enableJSONOutput()
+			args = args[1:]
+		} else {
+			break
+		}
 	}
 
-	command := os.Args[1]
-
-	switch command {
-	case "server":
-		runServer(os.Args[2:])
-	case "admin":
-		runAdmin(os.Args[2:])
-	case "user":
-		runUser(os.Args[2:])
-	default:
-		fmt.Printf("unknown command: %s\n", command)
-		usage()
-		os.Exit(1)
-	}
+	rootCommand().run("openhub", args)
 }
 
-func usage() {
-	fmt.Println("openhub - Federated Git Hosting")
-	fmt.Println("")
-	fmt.Println("Commands:")
-	fmt.Println("  server            Start the git server")
-	fmt.Println("  admin             Admin commands")
-	fmt.Println("  user              User management")
-	fmt.Println("")
-	fmt.Println("Server flags:")
-	fmt.Println("  --ssh-port        SSH server port (default: 2222)")
-	fmt.Println("  --http-port       HTTP server port (default: 3000)")
-	fmt.Println("")
-	fmt.Println("Admin subcommands:")
-	fmt.Println("  create-repo       Create a new repository")
-	fmt.Println("  delete-repo       Delete a repository")
-	fmt.Println("  list-repos        List repositories")
-	fmt.Println("  get-metadata      Get repository metadata")
-	fmt.Println("  set-description   Set repository description")
-	fmt.Println("  add-replica       Add replica (auto-registers with remote)")
-	fmt.Println("  remove-replica    Remove a replica")
-	fmt.Println("  list-replicas     List configured replicas")
-	fmt.Println("  recovery-bundle   Generate recovery bundle JSON")
-	fmt.Println("")
-	fmt.Println("User subcommands:")
-	fmt.Println("  create            Create a new user")
-	fmt.Println("  add-key           Add SSH key to user")
-	fmt.Println("  generate-token    Generate API token for user")
+func rootCommand() *command {
+	root := &command{
+		name:    "openhub",
+		summary: "openhub - Federated Git Hosting",
+		help: `
+Global flags, given before the command:
+  --profile <name>  CLI profile to use
+  --json            print results as JSON
+
+Commands that call the API talk to the server and send the token of a profile in
+~/.config/openhub/config.toml, chosen with --profile, $OPENHUB_PROFILE or the
+file's default. OPENHUB_API_URL and OPENHUB_TOKEN override it.
+
+With --json, admin, user and replica commands print their result to stdout as
+JSON, and any other output to stderr.`,
+		subcommands: []*command{
+			{
+				name: "init", summary: "Set up a new instance interactively",
+				setup: initCommand,
+			},
+			{
+				name: "server", summary: "Start the git server",
+				help: `
+Any flag can also be set as OPENHUB_<FLAG>, e.g. OPENHUB_HTTP_PORT, or in the
+--config file. Flags win over the environment, which wins over the file.
+SIGHUP reloads --anon-rate, --anon-burst, --auth-rate, --auth-burst,
+--anon-clones, --anon-clone-kbps, --replica-kbps, --replica-peer-kbps,
+--sync-interval and --webhook-timeout without a restart.`,
+				setup: serverCommand,
+			},
+			adminCommand(),
+			userCommand(),
+			replicaCommand(),
+			{
+				name: "hook", args: "<pre-receive|post-receive>", summary: "Run a git hook (invoked by git, not by hand)",
+				setup: noFlags(func(args []string) { runHook(args[0]) }),
+			},
+			{
+				name: "bench", summary: "Benchmark clone and push over the HTTP and SSH servers",
+				setup: benchCommand,
+			},
+		},
+	}
+	root.subcommands = append(root.subcommands, &command{
+		name: "completion", args: "<bash|zsh|fish>", summary: "Print a shell completion script",
+		setup: noFlags(func(args []string) {
+			if err := writeCompletion(stdout, root, args[0]); err != nil {
+				fmt.Printf("error: %v\n", err)
+				os.Exit(1)
+			}
+		}),
+	})
+	return root
 }
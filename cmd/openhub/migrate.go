@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// maxForgeResponse bounds one page of a forge API listing.
+const maxForgeResponse = 16 << 20
+
+// forgeRepo is a repository as GitHub's and Gitea's APIs list it.
+type forgeRepo struct {
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	Private       bool   `json:"private"`
+	CloneURL      string `json:"clone_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// migrationSource is the organization, or user, on another forge whose
+// repositories are migrated.
+type migrationSource struct {
+	forge string // "github" or "gitea"
+	label string // used in messages
+	api   string // API base URL
+	web   string // web base URL, which serves <user>.keys
+	org   string
+	token string
+}
+
+// newMigrationSource describes org on a forge. baseURL is the forge's web
+// address; it is required for Gitea and selects GitHub Enterprise for
+// GitHub.
+func newMigrationSource(forge, baseURL, org, token string) (migrationSource, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	src := migrationSource{forge: forge, org: org, token: token}
+	switch forge {
+	case "github":
+		src.label = "GitHub"
+		src.api, src.web = "https://api.github.com", "https://github.com"
+		if baseURL != "" {
+			src.api, src.web = baseURL+"/api/v3", baseURL
+		}
+	case "gitea":
+		if baseURL == "" {
+			return src, fmt.Errorf("--url is required for Gitea")
+		}
+		src.label = "Gitea"
+		src.api, src.web = baseURL+"/api/v1", baseURL
+	default:
+		return src, fmt.Errorf("unknown source %q (want github or gitea)", forge)
+	}
+	return src, nil
+}
+
+// get decodes the JSON at path under the forge's API into out, returning
+// false if the forge has nothing there.
+func (m migrationSource) get(path string, out interface{}) (bool, error) {
+	req, err := http.NewRequest("GET", m.api+path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if m.token != "" {
+		req.Header.Set("Authorization", "token "+m.token)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%s API: %w", m.label, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("%s API: %s returned %s: %s", m.label, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxForgeResponse)).Decode(out); err != nil {
+		return false, fmt.Errorf("%s API: decode %s: %w", m.label, path, err)
+	}
+	return true, nil
+}
+
+// pages fetches path page by page until an empty page, passing each
+// page's items to add. It reports false if path doesn't exist.
+func (m migrationSource) pages(path string, add func(data json.RawMessage) error) (bool, error) {
+	perPage := "per_page=100"
+	if m.forge == "gitea" {
+		perPage = "limit=50"
+	}
+	for page := 1; ; page++ {
+		var items []json.RawMessage
+		found, err := m.get(fmt.Sprintf("%s?%s&page=%d", path, perPage, page), &items)
+		if err != nil || !found {
+			return found, err
+		}
+		if len(items) == 0 {
+			return true, nil
+		}
+		for _, item := range items {
+			if err := add(item); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+// repos lists the organization's repositories, or the user's if org names
+// a user.
+func (m migrationSource) repos() ([]forgeRepo, error) {
+	var repos []forgeRepo
+	add := func(data json.RawMessage) error {
+		var r forgeRepo
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("%s API: decode repository: %w", m.label, err)
+		}
+		repos = append(repos, r)
+		return nil
+	}
+
+	org := url.PathEscape(m.org)
+	found, err := m.pages("/orgs/"+org+"/repos", add)
+	if err == nil && !found {
+		found, err = m.pages("/users/"+org+"/repos", add)
+	}
+	if err == nil && !found {
+		err = fmt.Errorf("no %s organization or user %s", m.label, m.org)
+	}
+	return repos, err
+}
+
+// members lists the logins of the organization's members, or just the
+// user if org names a user.
+func (m migrationSource) members() ([]string, error) {
+	var logins []string
+	found, err := m.pages("/orgs/"+url.PathEscape(m.org)+"/members", func(data json.RawMessage) error {
+		var u struct {
+			Login string `json:"login"`
+		}
+		if err := json.Unmarshal(data, &u); err != nil {
+			return fmt.Errorf("%s API: decode member: %w", m.label, err)
+		}
+		logins = append(logins, u.Login)
+		return nil
+	})
+	if err == nil && !found {
+		logins = []string{m.org}
+	}
+	return logins, err
+}
+
+// adminMigrateFrom copies an organization's repositories from another
+// forge into owner's, with their branches, tags, descriptions and
+// visibility. Repositories owner already has are skipped, so an interrupted
+// migration can be rerun. With withUsers, the organization's members get
+// accounts and the SSH keys they publish on the forge. With mirror, the
+// repositories stay read-only mirrors the server keeps fetching.
+func adminMigrateFrom(src migrationSource, owner string, withUsers, mirror bool) {
+	store := getStorage()
+
+	repos, err := src.repos()
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	type userResult struct {
+		Username string        `json:"username"`
+		Created  bool          `json:"created"`
+		Keys     []importedKey `json:"keys_added"`
+		Error    string        `json:"error,omitempty"`
+	}
+	users := []userResult{}
+	if withUsers {
+		logins, err := src.members()
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		authStore, storagePath := userAuthStore()
+		auditLog := audit.New(storagePath)
+		for _, login := range logins {
+			u := userResult{Username: login}
+			if _, err := authStore.GetUser(login); err != nil {
+				if err := authStore.CreateUser(login); err != nil {
+					fmt.Printf("error: %v\n", err)
+					os.Exit(1)
+				}
+				u.Created = true
+				cliAudit("user.create", login, "migrated from "+src.web)
+			}
+			ks := keySource{forge: src.forge, label: src.label, base: src.web, user: login}
+			keys, err := fetchPublishedKeys(ks)
+			if err == nil {
+				u.Keys, _, err = addSSHKeys(authStore, auditLog, login, ks, keys)
+			}
+			if err != nil {
+				u.Error = err.Error()
+			}
+			if !jsonOutput {
+				switch {
+				case u.Error != "":
+					fmt.Printf("User %s: %s\n", login, u.Error)
+				case u.Created:
+					fmt.Printf("Created user %s with %d key(s)\n", login, len(u.Keys))
+				default:
+					fmt.Printf("User %s exists, added %d key(s)\n", login, len(u.Keys))
+				}
+			}
+			users = append(users, u)
+		}
+	}
+
+	type repoResult struct {
+		Repo   string `json:"repo"`
+		Status string `json:"status"` // "migrated", "exists" or "failed"
+		Error  string `json:"error,omitempty"`
+	}
+	results := []repoResult{}
+	failed := 0
+	for _, r := range repos {
+		res := repoResult{Repo: owner + "/" + r.Name, Status: "migrated"}
+		switch {
+		case store.RepoExists(owner, r.Name):
+			res.Status = "exists"
+		default:
+			if err := migrateRepo(store, src, owner, r, mirror); err != nil {
+				res.Status, res.Error = "failed", err.Error()
+				failed++
+			} else {
+				cliAudit("repo.create", res.Repo, "migrated from "+r.CloneURL)
+			}
+		}
+		if !jsonOutput {
+			switch res.Status {
+			case "exists":
+				fmt.Printf("Skipped %s: already exists\n", res.Repo)
+			case "failed":
+				fmt.Printf("Failed %s: %s\n", res.Repo, res.Error)
+			default:
+				fmt.Printf("Migrated %s\n", res.Repo)
+			}
+		}
+		results = append(results, res)
+	}
+
+	if jsonOutput {
+		printJSON(map[string]interface{}{"source": src.web + "/" + src.org, "owner": owner, "repos": results, "users": users})
+	} else {
+		fmt.Printf("\n%d of %d repositories migrated from %s %s", len(repos)-failed, len(repos), src.label, src.org)
+		if withUsers {
+			fmt.Printf(", %d users", len(users))
+		}
+		fmt.Println()
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// migrateRepo creates owner/r.Name and fetches r's branches and tags into
+// it, recording r as its upstream if mirror is set. A repository that can't
+// be fetched is removed again, so a rerun retries it.
+func migrateRepo(store *storage.Storage, src migrationSource, owner string, r forgeRepo, mirror bool) error {
+	if r.Name == "" || strings.ContainsAny(r.Name, "/\\") || strings.HasPrefix(r.Name, ".") {
+		return fmt.Errorf("invalid repository name %q", r.Name)
+	}
+	if err := store.CreateRepo(owner, r.Name); err != nil {
+		return err
+	}
+
+	upstream := storage.Upstream{URL: r.CloneURL, Token: src.token}
+	if err := store.FetchUpstream(owner, r.Name, upstream); err != nil {
+		store.DeleteRepo(owner, r.Name)
+		return err
+	}
+	upstream.LastSynced = time.Now()
+	upstream.LastAttempt = upstream.LastSynced
+
+	branch := r.DefaultBranch
+	if branch == "" {
+		branch = "main"
+	}
+	path := store.RepoPath(owner, r.Name)
+	if out, err := exec.Command("git", "-C", path, "symbolic-ref", "HEAD", "refs/heads/"+branch).CombinedOutput(); err != nil {
+		store.DeleteRepo(owner, r.Name)
+		return fmt.Errorf("set HEAD: %s", strings.TrimSpace(string(out)))
+	}
+
+	return store.UpdateMetadata(owner, r.Name, func(meta *storage.Metadata) error {
+		meta.Description = r.Description
+		meta.Private = r.Private
+		meta.DefaultBranch = branch
+		if mirror {
+			meta.Upstream = &upstream
+		}
+		return nil
+	})
+}
+
+// adminSyncUpstream has the server fetch a mirror from its upstream now.
+func adminSyncUpstream(path string) {
+	owner, name := splitRepoPath(path)
+
+	var result struct {
+		Upstream storage.Upstream `json:"upstream"`
+	}
+	adminAPI("POST", "/api/v1/repos/upstream/sync", map[string]string{"owner": owner, "name": name}, &result)
+	if jsonOutput {
+		return
+	}
+	fmt.Printf("✓ Synced %s from %s\n", path, result.Upstream.URL)
+}
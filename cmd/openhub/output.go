@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// jsonOutput is set by the global --json flag. Commands then print their
+// result to stdout as one JSON value for scripts to read, and the text they
+// would otherwise print, progress and errors included, goes to stderr.
+var jsonOutput bool
+
+// stdout is the real standard output, which os.Stdout stops being once
+// --json has sent human-readable text to stderr.
+var stdout io.Writer = os.Stdout
+
+func enableJSONOutput() {
+	jsonOutput = true
+	stdout = os.Stdout
+	os.Stdout = os.Stderr
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Printf("json error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// printRawJSON writes a JSON document received from the API to stdout,
+// indented like printJSON's.
+func printRawJSON(data []byte) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, bytes.TrimSpace(data), "", "  "); err != nil {
+		fmt.Printf("json error: %v\n", err)
+		os.Exit(1)
+	}
+	buf.WriteByte('\n')
+	stdout.Write(buf.Bytes())
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/replication"
+)
+
+func replicaCommand() *command {
+	return &command{
+		name:    "replica",
+		summary: "Replication status",
+		subcommands: []*command{
+			{
+				name: "status", args: "[owner[/name]]", summary: "Show per-replica sync state and lag",
+				setup: noFlags(func(args []string) { replicaStatus(optionalArg(args, 0)) }),
+			},
+			{
+				name: "force-sync", args: "<owner/name>", summary: "Overwrite diverged refs on a repo's replicas",
+				setup: noFlags(func(args []string) { replicaForceSync(args[0]) }),
+			},
+			{
+				name: "log", args: "<owner/name> [replica-url]", summary: "List recent pushes to a repo's replicas",
+				setup: noFlags(func(args []string) { replicaLog(args[0], optionalArg(args, 1)) }),
+			},
+		},
+	}
+}
+
+type replicaStatusEntry struct {
+	InstanceID  string    `json:"instance_id"`
+	URL         string    `json:"url"`
+	Enabled     bool      `json:"enabled"`
+	Pending     bool      `json:"pending"`
+	LastSynced  time.Time `json:"last_synced"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastError   string    `json:"last_error"`
+	LagCommits  *int      `json:"lag_commits"`
+}
+
+func replicaStatus(target string) {
+	apiURL := cliAPIURL()
+
+	query := url.Values{}
+	if target != "" {
+		owner, name, _ := strings.Cut(target, "/")
+		query.Set("owner", owner)
+		if name != "" {
+			query.Set("name", name)
+		}
+	}
+
+	resp, err := apiGet(apiURL + "/api/v1/repos/replication-status?" + query.Encode())
+	if err != nil {
+		fmt.Printf("request error: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("read error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var result struct {
+		Success     bool      `json:"success"`
+		Error       string    `json:"error"`
+		PausedSince time.Time `json:"paused_since"`
+		Repos       []struct {
+			Owner    string               `json:"owner"`
+			Name     string               `json:"name"`
+			Replicas []replicaStatusEntry `json:"replicas"`
+		} `json:"repos"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Printf("json decode error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !result.Success {
+		if result.Error != "" {
+			fmt.Printf("error: %s\n", result.Error)
+		} else {
+			fmt.Println("error: unknown failure")
+		}
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printRawJSON(body)
+		return
+	}
+	if !result.PausedSince.IsZero() {
+		fmt.Printf("Replication paused %s\n\n", formatAge(time.Since(result.PausedSince)))
+	}
+	if len(result.Repos) == 0 {
+		fmt.Println("No replicated repositories")
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tREPLICA\tSTATUS\tLAST SYNCED\tLAG\tLAST ERROR")
+	for _, repo := range result.Repos {
+		for _, r := range repo.Replicas {
+			status := "enabled"
+			if r.Pending {
+				status = "pending"
+			} else if !r.Enabled {
+				status = "disabled"
+			}
+
+			lastSynced := "never"
+			if !r.LastSynced.IsZero() {
+				lastSynced = formatAge(time.Since(r.LastSynced))
+			}
+
+			lag := "-"
+			if r.LagCommits != nil {
+				lag = fmt.Sprintf("%d", *r.LagCommits)
+			}
+
+			lastError := r.LastError
+			if len(lastError) > 60 {
+				lastError = lastError[:57] + "..."
+			}
+
+			fmt.Fprintf(tw, "%s/%s\t%s\t%s\t%s\t%s\t%s\n", repo.Owner, repo.Name, r.URL, status, lastSynced, lag, lastError)
+		}
+	}
+	tw.Flush()
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
+
+func replicaForceSync(path string) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		fmt.Println("invalid repo path, must be owner/name")
+		os.Exit(1)
+	}
+
+	apiURL := cliAPIURL()
+
+	jsonData, err := json.Marshal(map[string]string{"owner": parts[0], "name": parts[1]})
+	if err != nil {
+		fmt.Printf("json error: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := apiPost(apiURL+"/api/v1/repos/force-sync", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		fmt.Printf("request error: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("read error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Printf("json decode error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if success, ok := result["success"].(bool); ok && success {
+		if jsonOutput {
+			printRawJSON(body)
+			return
+		}
+		fmt.Printf("Force sync queued for %s\n", path)
+		fmt.Println("Diverged refs on replicas will be overwritten with the origin's.")
+	} else {
+		fmt.Printf("error: %v\n", result["error"])
+		os.Exit(1)
+	}
+}
+
+func replicaLog(path, replicaURL string) {
+	owner, name := splitRepoPath(path)
+
+	query := url.Values{"owner": {owner}, "name": {name}}
+	if replicaURL != "" {
+		query.Set("replica", replicaURL)
+	}
+
+	resp, err := apiGet(cliAPIURL() + "/api/v1/repos/replication-log?" + query.Encode())
+	if err != nil {
+		fmt.Printf("request error: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("read error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var result struct {
+		Success bool                   `json:"success"`
+		Error   string                 `json:"error"`
+		Entries []replication.LogEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		fmt.Printf("json decode error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !result.Success {
+		if result.Error != "" {
+			fmt.Printf("error: %s\n", result.Error)
+		} else {
+			fmt.Println("error: unknown failure")
+		}
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		printRawJSON(body)
+		return
+	}
+	if len(result.Entries) == 0 {
+		fmt.Printf("No replication attempts logged for %s/%s\n", owner, name)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tREPLICA\tREFS\tBYTES\tDURATION\tRESULT")
+	for _, e := range result.Entries {
+		refs := make([]string, len(e.Refs))
+		for i, c := range e.Refs {
+			refs[i] = strings.TrimPrefix(strings.TrimPrefix(c.Ref, "refs/heads/"), "refs/tags/")
+		}
+		shipped := strings.Join(refs, ",")
+		if shipped == "" {
+			shipped = "-"
+		} else if len(shipped) > 40 {
+			shipped = shipped[:37] + "..."
+		}
+
+		outcome := "ok"
+		if e.Forced {
+			outcome = "ok (forced)"
+		}
+		if e.Error != "" {
+			outcome = e.Error
+			if len(outcome) > 60 {
+				outcome = outcome[:57] + "..."
+			}
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%dms\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), e.Replica, shipped, e.Bytes, e.DurationMS, outcome)
+	}
+	tw.Flush()
+}
@@ -1,40 +1,224 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/jeremytregunna/openhub/internal/activation"
+	"github.com/jeremytregunna/openhub/internal/activity"
+	"github.com/jeremytregunna/openhub/internal/activitypub"
+	"github.com/jeremytregunna/openhub/internal/archive"
+	"github.com/jeremytregunna/openhub/internal/audit"
 	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/clientip"
 	"github.com/jeremytregunna/openhub/internal/config"
+	"github.com/jeremytregunna/openhub/internal/discovery"
+	"github.com/jeremytregunna/openhub/internal/events"
 	"github.com/jeremytregunna/openhub/internal/git"
+	"github.com/jeremytregunna/openhub/internal/hooks"
+	"github.com/jeremytregunna/openhub/internal/httpcompress"
 	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/issues"
+	"github.com/jeremytregunna/openhub/internal/logstream"
+	"github.com/jeremytregunna/openhub/internal/mail"
+	"github.com/jeremytregunna/openhub/internal/metrics"
+	"github.com/jeremytregunna/openhub/internal/notifications"
+	"github.com/jeremytregunna/openhub/internal/pulls"
+	"github.com/jeremytregunna/openhub/internal/quota"
+	"github.com/jeremytregunna/openhub/internal/ratelimit"
+	"github.com/jeremytregunna/openhub/internal/releases"
 	"github.com/jeremytregunna/openhub/internal/replication"
 	"github.com/jeremytregunna/openhub/internal/server"
+	"github.com/jeremytregunna/openhub/internal/statuses"
 	"github.com/jeremytregunna/openhub/internal/storage"
+	"github.com/jeremytregunna/openhub/internal/tarpit"
+	"github.com/jeremytregunna/openhub/internal/tlsconfig"
+	"github.com/jeremytregunna/openhub/internal/uploads"
+	"github.com/jeremytregunna/openhub/internal/web"
+	"github.com/jeremytregunna/openhub/internal/webhooks"
 	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
 )
 
-func runServer(args []string) {
-	fs := flag.NewFlagSet("server", flag.ExitOnError)
-	sshPort := fs.Int("ssh-port", 2222, "SSH server port")
-	httpPort := fs.Int("http-port", 3000, "HTTP server port")
-	fs.Parse(args)
+// reloadable are the settings SIGHUP re-reads.
+var reloadable = []string{"anon-rate", "anon-burst", "auth-rate", "auth-burst", "anon-clones", "anon-clone-kbps", "replica-kbps", "replica-peer-kbps", "sync-interval", "webhook-timeout"}
 
+// reloadConfig re-reads the reloadable settings into fs from the
+// environment and the config file at path, with the same precedence as at
+// startup: those given on the command line keep their values. A setting
+// that was removed from the file goes back to its default.
+func reloadConfig(fs *flag.FlagSet, cmdline map[string]bool, path string) error {
+	// Read everything as strings first, so an unknown key is reported
+	// before any setting changes.
+	fresh := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.VisitAll(func(f *flag.Flag) { fresh.String(f.Name, f.DefValue, f.Usage) })
+	for name := range cmdline {
+		fresh.Set(name, fs.Lookup(name).Value.String())
+	}
+	if err := applyConfig(fresh, path); err != nil {
+		return err
+	}
+
+	for _, name := range reloadable {
+		if err := fs.Set(name, fresh.Lookup(name).Value.String()); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func serverCommand(fs *flag.FlagSet) func([]string) {
 	cfg := config.Default()
-	cfg.SSHPort = *sshPort
-	cfg.HTTPPort = *httpPort
+	configFile := fs.String("config", os.Getenv("OPENHUB_CONFIG"), "config file setting any of these flags (default: $OPENHUB_CONFIG)")
+	fs.StringVar(&cfg.StoragePath, "storage", cfg.StoragePath, "directory repositories and instance data are stored in")
+	fs.IntVar(&cfg.SSHPort, "ssh-port", 2222, "SSH server port")
+	fs.IntVar(&cfg.HTTPPort, "http-port", 3000, "HTTP server port")
+	fs.IntVar(&cfg.HTTPSPort, "https-port", 3443, "HTTPS server port")
+	fs.StringVar(&cfg.SSHBind, "ssh-bind", "", "address the SSH server listens on (default: all interfaces)")
+	fs.StringVar(&cfg.HTTPBind, "http-bind", "", "address the HTTP and HTTPS servers listen on (default: all interfaces)")
+	fs.StringVar(&cfg.HTTPSocket, "http-socket", "", "serve HTTP on this unix socket instead of --http-port")
+	fs.DurationVar(&cfg.HTTPReadHeaderTimeout, "http-read-header-timeout", 10*time.Second, "time a client gets to send a request's headers")
+	fs.DurationVar(&cfg.HTTPReadTimeout, "http-read-timeout", 0, "time a client gets to send a whole request, body included (0 disables)")
+	fs.DurationVar(&cfg.HTTPWriteTimeout, "http-write-timeout", 0, "time a response, such as a clone, gets to be written (0 disables)")
+	fs.DurationVar(&cfg.HTTPIdleTimeout, "http-idle-timeout", 2*time.Minute, "how long an idle keep-alive connection is kept open")
+	fs.IntVar(&cfg.MaxBodyMB, "max-body-mb", 10, "maximum size of an API request body (0 disables)")
+	fs.IntVar(&cfg.MaxBundleMB, "max-bundle-mb", 10240, "maximum size of a bundle replicated to this instance (0 disables)")
+	fs.StringVar(&cfg.ExternalURL, "external-url", "", "canonical base URL, or a domain with _openhub SRV records")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", "", "TLS certificate for the HTTPS server")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", "", "TLS private key for the HTTPS server")
+	fs.BoolVar(&cfg.HTTPSRedirect, "https-redirect", false, "redirect HTTP requests to the HTTPS server")
+	fs.Var((*commaListFlag)(&cfg.TrustedProxies), "trusted-proxies", "comma-separated IPs or CIDR ranges of reverse proxies whose X-Forwarded-For and X-Forwarded-Proto headers are trusted")
+	fs.StringVar(&cfg.TLSClientCAFile, "tls-client-ca", "", "CA bundle for verifying instance client certificates")
+	fs.IntVar(&cfg.GRPCPort, "grpc-port", 0, "serve the gRPC admin service on this port, over mutual TLS (0 disables)")
+	fs.StringVar(&cfg.GRPCClientCAFile, "grpc-client-ca", "", "CA bundle for verifying gRPC admin client certificates")
+	fs.StringVar(&cfg.FederationCAFile, "federation-ca", "", "CA bundle trusted for outbound instance connections")
+	fs.StringVar(&cfg.FederationCertFile, "federation-cert", "", "client certificate for outbound instance connections")
+	fs.StringVar(&cfg.FederationKeyFile, "federation-key", "", "client key for outbound instance connections")
+	fs.BoolVar(&cfg.ReadThrough, "read-through", false, "on replicas, pass clones and fetches on to the origin while behind it")
+	fs.Var((*commaListFlag)(&cfg.CloneRegions), "clone-regions", "comma-separated <ip-or-cidr>=<replica-url> pairs: clients in each range clone public repos from that replica")
+	fs.IntVar(&cfg.CloneRedirectBusy, "clone-redirect-busy", 0, "send clones of public repos to their replicas once this many are running here (0 disables)")
+	fs.BoolVar(&cfg.TarpitEnabled, "tarpit", false, "slow-respond and shadow-ban clients probing for repos and exploit paths")
+	fs.Float64Var(&cfg.AnonRateLimit, "anon-rate", 30, "expensive requests per minute per anonymous client (0 disables)")
+	fs.IntVar(&cfg.AnonBurst, "anon-burst", 10, "burst allowance for anonymous clients")
+	fs.Float64Var(&cfg.AuthRateLimit, "auth-rate", 300, "expensive requests per minute per authenticated user (0 disables)")
+	fs.IntVar(&cfg.AuthBurst, "auth-burst", 60, "burst allowance for authenticated users")
+	fs.IntVar(&cfg.AnonClones, "anon-clones", 4, "clones and fetches each anonymous client IP may have in progress at once (0 disables)")
+	fs.IntVar(&cfg.AnonCloneKBps, "anon-clone-kbps", 0, "KB/s shared by an anonymous client IP's clones and fetches (0 disables)")
+	fs.IntVar(&cfg.ArchiveCacheMB, "archive-cache-mb", 512, "disk budget for cached tar.gz/zip archives")
+	fs.DurationVar(&cfg.InfoRefsCacheTTL, "info-refs-cache-ttl", 10*time.Second, "longest a public repo's ref advertisement is served from cache (0 disables)")
+	fs.BoolVar(&cfg.Compress, "compress", true, "gzip or deflate JSON API responses and ref advertisements for clients that accept it")
+	fs.IntVar(&cfg.ReleaseAssetMaxMB, "release-asset-max-mb", 2048, "maximum size of a single release asset")
+	fs.IntVar(&cfg.ReleaseMaxMB, "release-max-mb", 10240, "maximum total size of a release's assets")
+	fs.BoolVar(&cfg.ScanSecrets, "scan-secrets", false, "reject pushes that add likely credentials, such as AWS or private keys")
+	fs.IntVar(&cfg.MaxBlobMB, "max-blob-mb", 0, "reject pushes that add a file bigger than this (0 disables)")
+	fs.IntVar(&cfg.ReplicaConcurrency, "replica-concurrency", 4, "replicas a single sync pushes to in parallel")
+	fs.IntVar(&cfg.ReplicaWorkers, "replica-workers", 3, "replication jobs run in parallel")
+	fs.IntVar(&cfg.ReplicaQueueDepth, "replica-queue", 100, "replication jobs that can wait for a worker")
+	fs.DurationVar(&cfg.ReplicaTimeout, "replica-timeout", 30*time.Second, "timeout for each request to a replica, or for a bundle upload to stall")
+	fs.DurationVar(&cfg.ReplicaConnectTimeout, "replica-connect-timeout", 10*time.Second, "timeout for connecting to a replica, TLS handshake included")
+	fs.IntVar(&cfg.ReplicaIdleConns, "replica-idle-conns", 4, "idle connections kept open to each replica (0 closes each after its request)")
+	fs.DurationVar(&cfg.ReplicaIdleTimeout, "replica-idle-timeout", 90*time.Second, "how long an idle connection to a replica is kept open")
+	fs.StringVar(&cfg.ReplicaProxy, "replica-proxy", "", "proxy URL for requests to replicas (default from HTTPS_PROXY/HTTP_PROXY)")
+	fs.BoolVar(&cfg.ReplicaHTTP2, "replica-http2", false, "use HTTP/2 for requests to replicas served over TLS")
+	fs.IntVar(&cfg.ReplicaKBps, "replica-kbps", 0, "KB/s shared by all bundle uploads to replicas (0 disables)")
+	fs.Var((*commaListFlag)(&cfg.ReplicaPeerKBps), "replica-peer-kbps", "KB/s cap for each replica's bundle uploads, and comma-separated <replica-url>=<KB/s> overrides")
+	fs.DurationVar(&cfg.SyncInterval, "sync-interval", 5*time.Minute, "how often every repo is re-synced to its replicas (0 disables)")
+	fs.Var((*commaListFlag)(&cfg.StandbyURLs), "standby", "comma-separated URLs of standby instances to mirror users and keys to")
+	fs.Var((*commaListFlag)(&cfg.StandbyOf), "standby-of", "comma-separated instance IDs allowed to mirror their users to this instance")
+	fs.StringVar(&cfg.OriginPolicy, "origin-policy", "open", "which origins may register repos to replicate here: open, allowlist or approval")
+	fs.Var((*commaListFlag)(&cfg.TrustedOrigins), "trusted-origins", "comma-separated instance IDs or public keys of origins accepted without approval")
+	fs.Var((*commaListFlag)(&cfg.AdminUsers), "admin-users", "comma-separated users allowed to use token-authenticated admin endpoints")
+	fs.IntVar(&cfg.LogLines, "log-lines", 1000, "recent log lines kept for 'admin logs'")
+	fs.DurationVar(&cfg.AuditRetention, "audit-retention", 0, "how long audit log entries are kept (0 keeps them forever)")
+	fs.DurationVar(&cfg.WebhookTimeout, "webhook-timeout", 15*time.Second, "how long a webhook receiver gets to answer a delivery")
+	fs.DurationVar(&cfg.QuotaCheckInterval, "quota-interval", 10*time.Minute, "how often owners' disk usage is checked against their quotas")
+	fs.DurationVar(&cfg.UpstreamInterval, "upstream-interval", time.Hour, "how often repos mirrored from other forges are fetched (0 disables)")
+	fs.BoolVar(&cfg.ActivityPub, "activitypub", false, "publish ForgeFed activities for public repositories and accept follows")
+	fs.StringVar(&cfg.MasterKeyFile, "master-key", os.Getenv("OPENHUB_MASTER_KEY"), "key file for encrypting user records at rest (default: $OPENHUB_MASTER_KEY)")
+	fs.StringVar(&cfg.Registration, "registration", "closed", "who may create their own account: open, invite or closed")
+	fs.StringVar(&cfg.SMTPAddr, "smtp", "", "host:port of the SMTP server notifications are emailed through")
+	fs.StringVar(&cfg.SMTPUsername, "smtp-user", "", "SMTP username")
+	fs.StringVar(&cfg.SMTPPassword, "smtp-password", os.Getenv("OPENHUB_SMTP_PASSWORD"), "SMTP password (default: $OPENHUB_SMTP_PASSWORD)")
+	fs.StringVar(&cfg.SMTPFrom, "smtp-from", "", "From address of notification emails")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "how long in-flight pushes, clones and replication get to finish on shutdown")
+	return func([]string) { runServer(fs, cfg, *configFile) }
+}
+
+// runServer starts the servers with cfg, as set by fs's flags. The
+// environment and configFile fill in flags not given on the command line.
+func runServer(fs *flag.FlagSet, cfg *config.Config, configFile string) {
+	cmdline := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+	if err := applyConfig(fs, configFile); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	logs := logstream.New(cfg.LogLines)
+	log.SetOutput(io.MultiWriter(os.Stderr, logs))
 
-	if storagePath := os.Getenv("OPENHUB_STORAGE"); storagePath != "" {
-		cfg.StoragePath = storagePath
+	if cfg.ReplicaWorkers < 1 {
+		log.Fatalf("--replica-workers must be at least 1")
+	}
+	if cfg.QuotaCheckInterval <= 0 {
+		log.Fatalf("--quota-interval must be positive")
+	}
+	if cfg.WebhookTimeout <= 0 {
+		log.Fatalf("--webhook-timeout must be positive")
+	}
+	if cfg.AuditRetention < 0 {
+		log.Fatalf("--audit-retention must not be negative")
+	}
+	if cfg.InfoRefsCacheTTL < 0 {
+		log.Fatalf("--info-refs-cache-ttl must not be negative")
+	}
+	if cfg.ReplicaConnectTimeout <= 0 {
+		log.Fatalf("--replica-connect-timeout must be positive")
+	}
+	if cfg.ReplicaIdleConns < 0 || cfg.ReplicaIdleTimeout < 0 {
+		log.Fatalf("--replica-idle-conns and --replica-idle-timeout must not be negative")
+	}
+	var replicaProxy *url.URL
+	if cfg.ReplicaProxy != "" {
+		u, err := url.Parse(cfg.ReplicaProxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			log.Fatalf("--replica-proxy must be an http, https or socks5 URL")
+		}
+		replicaProxy = u
+	}
+	switch cfg.Registration {
+	case auth.RegistrationOpen, auth.RegistrationInvite, auth.RegistrationClosed:
+	default:
+		log.Fatalf("--registration must be open, invite or closed")
+	}
+	switch cfg.OriginPolicy {
+	case server.OriginsOpen, server.OriginsAllowlist, server.OriginsApproval:
+	default:
+		log.Fatalf("--origin-policy must be open, allowlist or approval")
+	}
+	if cfg.HTTPSRedirect && cfg.TLSCertFile == "" {
+		log.Fatalf("--https-redirect requires --tls-cert and --tls-key")
+	}
+	if cfg.SMTPAddr != "" && cfg.SMTPFrom == "" {
+		log.Fatalf("--smtp-from is required with --smtp")
 	}
 
 	store, err := storage.New(cfg.StoragePath)
@@ -42,10 +226,13 @@ func runServer(args []string) {
 		log.Fatalf("storage init: %v", err)
 	}
 
-	authStore, err := auth.NewAuthStore(cfg.StoragePath)
+	authStore, err := openAuthStore(cfg.StoragePath, cfg.MasterKeyFile)
 	if err != nil {
 		log.Fatalf("auth store init: %v", err)
 	}
+	if authStore.Encrypted() && cfg.MasterKeyFile == "" {
+		log.Fatalf("user records are encrypted; pass --master-key")
+	}
 
 	inst, err := instance.LoadOrCreate(cfg.StoragePath)
 	if err != nil {
@@ -53,53 +240,543 @@ func runServer(args []string) {
 	}
 	log.Printf("instance ID: %s", inst.ID)
 
-	replManager := replication.NewManager(store, inst.ID)
-	replManager.Start(3)
-	log.Printf("started replication workers")
-	replManager.StartPeriodicSync(5 * time.Minute)
-	log.Printf("started periodic sync (every 5 minutes)")
+	fedTLS, err := tlsconfig.Client(cfg.FederationCAFile, cfg.FederationCertFile, cfg.FederationKeyFile)
+	if err != nil {
+		log.Fatalf("federation TLS: %v", err)
+	}
+
+	replManager := replication.NewManager(store, inst, fedTLS)
+	replManager.SetPushConcurrency(cfg.ReplicaConcurrency)
+	replManager.SetQueueDepth(cfg.ReplicaQueueDepth)
+	replManager.SetHTTPTimeout(cfg.ReplicaTimeout)
+	replManager.SetTransport(replication.TransportOptions{
+		ConnectTimeout: cfg.ReplicaConnectTimeout,
+		IdleConns:      cfg.ReplicaIdleConns,
+		IdleTimeout:    cfg.ReplicaIdleTimeout,
+		Proxy:          replicaProxy,
+		HTTP2:          cfg.ReplicaHTTP2,
+	})
+	if err := replManager.SetPauseFile(filepath.Join(cfg.StoragePath, "replication-paused")); err != nil {
+		log.Fatalf("replication: %v", err)
+	}
+	peerKBps, peerRates, err := replication.ParsePeerRates(cfg.ReplicaPeerKBps)
+	if err != nil {
+		log.Fatalf("--replica-peer-kbps: %v", err)
+	}
+	replManager.SetBandwidth(cfg.ReplicaKBps, peerKBps, peerRates)
+	replManager.RegisterMetrics()
+	if len(cfg.StandbyURLs) > 0 {
+		replManager.SetStandbys(authStore, cfg.StandbyURLs)
+		log.Printf("mirroring users to standbys: %s", strings.Join(cfg.StandbyURLs, ", "))
+	}
+	bus := events.New(cfg.StoragePath, 1000)
+	bus.Start(time.Second)
+	replManager.SetEvents(bus)
+	issueStore := issues.NewStore(store)
+	replManager.SetIssues(issueStore)
+	peerStore := instance.NewPeerStore(cfg.StoragePath)
+	replManager.SetPeers(peerStore)
+	replManager.Start(cfg.ReplicaWorkers)
+	log.Printf("started %d replication workers", cfg.ReplicaWorkers)
+	replManager.QueueUsers()
+	replManager.StartPeriodicSync(cfg.SyncInterval)
+	if cfg.SyncInterval > 0 {
+		log.Printf("started periodic sync (every %s)", cfg.SyncInterval)
+	}
+
+	// Sockets passed in by systemd take the place of the ports and
+	// addresses configured for the same server.
+	activated, err := activation.Listeners()
+	if err != nil {
+		log.Fatalf("socket activation: %v", err)
+	}
+	if activated["https"] != nil && cfg.TLSCertFile == "" {
+		log.Fatalf("socket activation: an https socket requires --tls-cert and --tls-key")
+	}
 
 	hostKey, err := loadOrGenerateHostKey(cfg.StoragePath)
 	if err != nil {
 		log.Fatalf("load host key: %v", err)
 	}
 
-	sshServer := git.NewSSHServer(cfg.SSHPort, store, authStore, hostKey, replManager)
+	archives, err := archive.NewCache(filepath.Join(cfg.StoragePath, ".cache", "archives"), int64(cfg.ArchiveCacheMB)<<20)
+	if err != nil {
+		log.Fatalf("archive cache init: %v", err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("locate executable: %v", err)
+	}
+	gitHooks, err := hooks.Install(filepath.Join(cfg.StoragePath, ".hooks"), executable, cfg.StoragePath)
+	if err != nil {
+		log.Fatalf("hooks init: %v", err)
+	}
+	if cfg.MasterKeyFile != "" {
+		if err := gitHooks.SetMasterKey(cfg.MasterKeyFile); err != nil {
+			log.Fatalf("hooks init: %v", err)
+		}
+	}
+	gitHooks.SetScan(cfg.ScanSecrets, cfg.MaxBlobMB)
+
+	var adverts *git.AdvertCache
+	if cfg.InfoRefsCacheTTL > 0 {
+		adverts = git.NewAdvertCache(cfg.InfoRefsCacheTTL)
+	}
+
+	sshServer := git.NewSSHServer(cfg.SSHPort, store, authStore, hostKey, replManager, archives, gitHooks)
+	sshServer.SetBindAddress(cfg.SSHBind)
+	if adverts != nil {
+		sshServer.SetAdvertCache(adverts)
+	}
 	go func() {
-		log.Printf("starting SSH server on port %d", cfg.SSHPort)
-		if err := sshServer.Start(); err != nil {
+		var err error
+		if l := activated["ssh"]; l != nil {
+			log.Printf("starting SSH server on socket-activated %s", l.Addr())
+			err = sshServer.Serve(l)
+		} else {
+			log.Printf("starting SSH server on port %d", cfg.SSHPort)
+			err = sshServer.Start()
+		}
+		if err != nil && !errors.Is(err, git.ErrServerClosed) {
 			log.Fatalf("SSH server: %v", err)
 		}
 	}()
 
-	apiServer := server.New(store, authStore)
-	gitHTTPServer := git.NewHTTPServer(store, authStore)
+	if err := clientip.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("--trusted-proxies: %v", err)
+	}
+
+	inferURL := cfg.ExternalURL == ""
+	if inferURL {
+		cfg.ExternalURL = fmt.Sprintf("http://localhost:%d", cfg.HTTPPort)
+	}
+	externalBase, err := discovery.ResolveURL(cfg.ExternalURL)
+	if err != nil {
+		log.Fatalf("resolve external URL: %v", err)
+	}
+
+	uploadManager, err := uploads.NewManager(
+		filepath.Join(cfg.StoragePath, ".uploads"),
+		int64(cfg.ReleaseAssetMaxMB)<<20,
+		int64(cfg.ReleaseMaxMB)<<20,
+	)
+	if err != nil {
+		log.Fatalf("uploads init: %v", err)
+	}
+	uploadManager.StartGC(time.Hour, 24*time.Hour)
+
+	// Bundles an origin uploads in chunks wait here until it replicates them.
+	bundleUploads, err := uploads.NewManager(filepath.Join(cfg.StoragePath, ".bundle-uploads"), int64(cfg.MaxBundleMB)<<20, 0)
+	if err != nil {
+		log.Fatalf("bundle uploads init: %v", err)
+	}
+	bundleUploads.StartGC(time.Hour, 24*time.Hour)
+
+	pullStore := pulls.NewStore(store)
+	mergeQueue := pulls.NewMergeQueue(pullStore, store, func(owner, repo string) {
+		archives.Invalidate(owner, repo)
+		if adverts != nil {
+			adverts.Invalidate(owner, repo)
+		}
+		replManager.Queue(owner, repo)
+	})
+	if err := mergeQueue.Restore(); err != nil {
+		log.Fatalf("merge queue restore: %v", err)
+	}
+	mergeQueue.Start()
+
+	apiServer := server.New(store, authStore, replManager, uploadManager, peerStore, pullStore, mergeQueue, activity.NewTracker(store))
+	apiServer.SetExternalURL(externalBase)
+	apiServer.SetSSHPort(cfg.SSHPort)
+	if inferURL {
+		apiServer.InferExternalURL()
+	}
+	apiServer.SetInstance(inst)
+	apiServer.SetFederationTLS(fedTLS)
+	apiServer.SetIssues(issueStore)
+	apiServer.SetReleases(releases.NewStore(store))
+	apiServer.SetStatuses(statuses.NewStore(store))
+
+	quotas := quota.New(cfg.StoragePath, store)
+	quotas.StartMonitor(cfg.QuotaCheckInterval)
+	apiServer.SetQuotas(quotas)
+	if cfg.UpstreamInterval > 0 {
+		apiServer.StartUpstreamSync(cfg.UpstreamInterval)
+	}
+	apiServer.SetLogs(logs)
+	apiServer.SetAdmins(cfg.AdminUsers)
+	apiServer.SetRegistration(cfg.Registration)
+	apiServer.SetBodyLimits(int64(cfg.MaxBodyMB)<<20, int64(cfg.MaxBundleMB)<<20)
+	apiServer.SetBundleUploads(bundleUploads)
+	auditLog := audit.New(cfg.StoragePath)
+	auditLog.StartRetention(cfg.AuditRetention, time.Hour)
+	apiServer.SetAudit(auditLog)
+	apiServer.SetOriginPolicy(cfg.OriginPolicy, cfg.TrustedOrigins)
+	apiServer.SetReplicationRequests(instance.NewRequestStore(cfg.StoragePath))
+	if len(cfg.StandbyOf) > 0 {
+		apiServer.SetStandbyOf(cfg.StandbyOf)
+		for _, id := range cfg.StandbyOf {
+			if _, ok, _ := peerStore.Get(id); !ok {
+				log.Printf("warning: no key pinned for primary %s; run 'openhub admin trust-peer' before it can sync users", id)
+			}
+		}
+	}
+	if cfg.TLSClientCAFile != "" {
+		apiServer.RequireClientCert()
+	}
+	gitHTTPServer := git.NewHTTPServer(store, authStore, archives, gitHooks)
+	gitHTTPServer.SetAudit(auditLog)
+	anonClones := ratelimit.NewClones(cfg.AnonClones, cfg.AnonCloneKBps)
+	gitHTTPServer.SetAnonymousClones(anonClones)
+	if adverts != nil {
+		gitHTTPServer.SetAdvertCache(adverts)
+	}
+	if cfg.ReadThrough {
+		gitHTTPServer.SetReadThrough(peerStore)
+	}
+	if len(cfg.CloneRegions) > 0 || cfg.CloneRedirectBusy > 0 {
+		regions, err := git.ParseCloneRegions(cfg.CloneRegions)
+		if err != nil {
+			log.Fatalf("--clone-regions: %v", err)
+		}
+		gitHTTPServer.SetCloneRedirect(regions, cfg.CloneRedirectBusy)
+	}
 
 	mux := http.NewServeMux()
 	mux.Handle("/api/", apiServer)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/debug/pprof/", apiServer.DebugHandler())
+	mux.Handle("/ui/", web.Handler())
+	mux.Handle("/{$}", http.RedirectHandler("/ui/", http.StatusFound))
+	if cfg.ActivityPub {
+		fed, err := activitypub.New(cfg.StoragePath, externalBase, store)
+		if err != nil {
+			log.Fatalf("activitypub init: %v", err)
+		}
+		fed.Start(10 * time.Second)
+		apiServer.SetEvents(fed)
+		mux.Handle("/api/activitypub/", fed)
+		mux.Handle("/.well-known/webfinger", fed)
+		log.Printf("publishing ActivityPub actors under %s/api/activitypub/", externalBase)
+	} else if err := activitypub.Disable(cfg.StoragePath); err != nil {
+		log.Printf("warning: disable activitypub: %v", err)
+	}
+	hookService, err := webhooks.New(cfg.StoragePath, externalBase, store)
+	if err != nil {
+		log.Fatalf("webhooks init: %v", err)
+	}
+	hookService.SetTimeout(cfg.WebhookTimeout)
+	hookService.Start(5 * time.Second)
+	apiServer.SetWebhooks(hookService)
+
+	apiServer.AddDiagnostics("replication", func() (interface{}, error) { return replManager.QueueStatus(), nil })
+	apiServer.AddDiagnostics("webhooks", func() (interface{}, error) { return hookService.QueueStatus() })
+	apiServer.AddDiagnostics("git_processes", func() (interface{}, error) { return git.Processes(), nil })
+	apiServer.SetEventBus(bus)
+	notes := notifications.New(cfg.StoragePath, store)
+	if cfg.SMTPAddr != "" {
+		mailer := mail.New(mail.Config{
+			Addr:     cfg.SMTPAddr,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+		}, authStore)
+		mailer.Start()
+		notes.SetEmailer(mailer)
+		log.Printf("emailing notifications through %s", cfg.SMTPAddr)
+	}
+	notes.Start(bus)
+	apiServer.SetNotifications(notes)
 	mux.Handle("/", gitHTTPServer)
 
-	addr := fmt.Sprintf(":%d", cfg.HTTPPort)
-	log.Printf("starting HTTP server on port %d", cfg.HTTPPort)
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatalf("HTTP server: %v", err)
+	anonLimiter := ratelimit.New(cfg.AnonRateLimit, cfg.AnonBurst)
+	authLimiter := ratelimit.New(cfg.AuthRateLimit, cfg.AuthBurst)
+	var handler http.Handler = mux
+	if cfg.Compress {
+		handler = httpcompress.Middleware(handler)
+	}
+	handler = ratelimit.Middleware(authStore, anonLimiter, authLimiter)(handler)
+	if cfg.TarpitEnabled {
+		handler = tarpit.New(tarpit.DefaultConfig()).Middleware(handler)
+		log.Printf("tarpit mode enabled")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var httpsServer *http.Server
+	var rpcServer *grpc.Server
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		serverTLS, err := tlsconfig.Server(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("HTTPS TLS: %v", err)
+		}
+		if cfg.TLSClientCAFile != "" {
+			if err := tlsconfig.VerifyClients(serverTLS, cfg.TLSClientCAFile); err != nil {
+				log.Fatalf("client CA: %v", err)
+			}
+		}
+
+		if cfg.GRPCPort != 0 {
+			if cfg.GRPCClientCAFile == "" {
+				log.Fatalf("--grpc-port requires --grpc-client-ca")
+			}
+			rpcTLS, err := tlsconfig.Server(cfg.TLSCertFile, cfg.TLSKeyFile)
+			if err != nil {
+				log.Fatalf("gRPC TLS: %v", err)
+			}
+			if err := tlsconfig.VerifyClients(rpcTLS, cfg.GRPCClientCAFile); err != nil {
+				log.Fatalf("gRPC client CA: %v", err)
+			}
+			l, err := net.Listen("tcp", net.JoinHostPort(cfg.HTTPBind, strconv.Itoa(cfg.GRPCPort)))
+			if err != nil {
+				log.Fatalf("gRPC admin server: %v", err)
+			}
+			rpcServer = apiServer.AdminRPC(rpcTLS)
+			go func() {
+				log.Printf("starting gRPC admin server on %s", l.Addr())
+				if err := rpcServer.Serve(l); err != nil {
+					log.Fatalf("gRPC admin server: %v", err)
+				}
+			}()
+		}
+
+		httpsServer = newHTTPServer(cfg, net.JoinHostPort(cfg.HTTPBind, strconv.Itoa(cfg.HTTPSPort)), handler)
+		httpsServer.TLSConfig = serverTLS
+		go func() {
+			var err error
+			if l := activated["https"]; l != nil {
+				log.Printf("starting HTTPS server on socket-activated %s", l.Addr())
+				err = httpsServer.ServeTLS(l, "", "")
+			} else {
+				log.Printf("starting HTTPS server on %s", httpsServer.Addr)
+				err = httpsServer.ListenAndServeTLS("", "")
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("HTTPS server: %v", err)
+			}
+		}()
+	} else if cfg.TLSClientCAFile != "" {
+		log.Fatalf("--tls-client-ca requires --tls-cert and --tls-key")
+	} else if cfg.GRPCPort != 0 {
+		log.Fatalf("--grpc-port requires --tls-cert, --tls-key and --grpc-client-ca")
+	}
+
+	httpHandler := handler
+	if cfg.HTTPSRedirect {
+		httpHandler = redirectToHTTPS(cfg.HTTPSPort)
+	}
+
+	httpServer := newHTTPServer(cfg, net.JoinHostPort(cfg.HTTPBind, strconv.Itoa(cfg.HTTPPort)), httpHandler)
+	go func() {
+		var err error
+		if l := activated["http"]; l != nil {
+			log.Printf("starting HTTP server on socket-activated %s", l.Addr())
+			err = httpServer.Serve(l)
+		} else if cfg.HTTPSocket != "" {
+			log.Printf("starting HTTP server on %s", cfg.HTTPSocket)
+			err = serveUnix(httpServer, cfg.HTTPSocket)
+		} else {
+			log.Printf("starting HTTP server on %s", httpServer.Addr)
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server: %v", err)
+		}
+	}()
+
+	// SIGHUP re-reads the settings that can change without a restart, so
+	// SSH sessions and transfers in progress carry on.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			err := reloadConfig(fs, cmdline, configFile)
+			if err == nil && cfg.WebhookTimeout <= 0 {
+				err = fmt.Errorf("webhook-timeout must be positive")
+			}
+			var peerKBps int
+			var peerRates map[string]int
+			if err == nil {
+				peerKBps, peerRates, err = replication.ParsePeerRates(cfg.ReplicaPeerKBps)
+			}
+			if err != nil {
+				log.Printf("reload: %v; keeping the current settings", err)
+				continue
+			}
+			anonLimiter.SetRate(cfg.AnonRateLimit, cfg.AnonBurst)
+			authLimiter.SetRate(cfg.AuthRateLimit, cfg.AuthBurst)
+			anonClones.SetLimits(cfg.AnonClones, cfg.AnonCloneKBps)
+			replManager.SetBandwidth(cfg.ReplicaKBps, peerKBps, peerRates)
+			replManager.SetSyncInterval(cfg.SyncInterval)
+			hookService.SetTimeout(cfg.WebhookTimeout)
+			log.Printf("reloaded settings: anon-rate=%g anon-burst=%d auth-rate=%g auth-burst=%d anon-clones=%d anon-clone-kbps=%d replica-kbps=%d replica-peer-kbps=%s sync-interval=%s webhook-timeout=%s",
+				cfg.AnonRateLimit, cfg.AnonBurst, cfg.AuthRateLimit, cfg.AuthBurst, cfg.AnonClones, cfg.AnonCloneKBps,
+				cfg.ReplicaKBps, strings.Join(cfg.ReplicaPeerKBps, ","), cfg.SyncInterval, cfg.WebhookTimeout)
+		}
+	}()
+
+	<-ctx.Done()
+	// A second signal kills the process outright.
+	stop()
+	log.Printf("shutting down, waiting up to %s for pushes, clones and replication to finish", cfg.ShutdownTimeout)
+	shutdown(cfg.ShutdownTimeout, apiServer, sshServer, rpcServer, replManager, httpServer, httpsServer)
+}
+
+// newHTTPServer returns a server for addr with the configured timeouts.
+func newHTTPServer(cfg *config.Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+	}
+}
+
+// shutdown stops the servers accepting connections, gives the requests and
+// git commands in progress until timeout to finish, then runs the
+// replication jobs they queued in whatever time is left.
+func shutdown(timeout time.Duration, api *server.Server, sshServer *git.SSHServer, rpcServer *grpc.Server, repl *replication.Manager, httpServers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	api.CloseStreams()
+
+	var wg sync.WaitGroup
+	for _, srv := range httpServers {
+		if srv == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("HTTP server on %s: %v; closing remaining connections", srv.Addr, err)
+				srv.Close()
+			}
+		}()
+	}
+	if rpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				rpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				log.Printf("gRPC admin server: %v; cancelling calls still running", ctx.Err())
+				rpcServer.Stop()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := sshServer.Shutdown(ctx); err != nil {
+			log.Printf("SSH server: %v; closed connections with git commands still running", err)
+		}
+	}()
+	wg.Wait()
+
+	if err := repl.Shutdown(ctx); err != nil {
+		log.Printf("replication: %v", err)
+	}
+	log.Printf("shutdown complete")
+}
+
+// applyConfig sets the server flags not given on the command line from
+// OPENHUB_<FLAG> environment variables, such as OPENHUB_HTTP_PORT, and then
+// from the config file at path, if any.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	given := map[string]bool{"config": true}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var file map[string]string
+	if path != "" {
+		var err error
+		if file, err = config.LoadFile(path); err != nil {
+			return err
+		}
+		for key := range file {
+			if fs.Lookup(key) == nil || key == "config" {
+				return fmt.Errorf("%s: unknown setting %q", path, key)
+			}
+		}
 	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		env := "OPENHUB_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		source, value := "$"+env, os.Getenv(env)
+		if value == "" {
+			v, ok := file[f.Name]
+			if !ok {
+				return
+			}
+			source, value = path, v
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %s: %v", source, f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// serveUnix serves srv on a unix socket at path, for a reverse proxy on the
+// same host. A socket left behind by an earlier run is replaced. The socket
+// is made group-writable so the proxy can be given access through a group.
+func serveUnix(srv *http.Server, path string) error {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return fmt.Errorf("chmod socket: %w", err)
+	}
+	return srv.Serve(listener)
+}
+
+// redirectToHTTPS sends every request to the same URL on the HTTPS server.
+// A 308 keeps the method and body, so pushes and API calls follow it too.
+func redirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
 }
 
 func loadOrGenerateHostKey(storagePath string) (ssh.Signer, error) {
 	keyPath := filepath.Join(storagePath, "ssh_host_key")
 
 	// Try to load existing key
-	if keyData, err := os.ReadFile(keyPath); err == nil {
-		block, _ := pem.Decode(keyData)
-		if block == nil {
-			return nil, fmt.Errorf("invalid PEM data in host key")
-		}
-		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-		if err != nil {
-			return nil, fmt.Errorf("parse host key: %w", err)
-		}
-		return ssh.NewSignerFromKey(key)
+	if _, err := os.Stat(keyPath); err == nil {
+		return loadHostKey(keyPath)
 	}
 
 	// Generate new key
@@ -120,3 +797,39 @@ func loadOrGenerateHostKey(storagePath string) (ssh.Signer, error) {
 	log.Printf("generated new SSH host key at %s", keyPath)
 	return ssh.NewSignerFromKey(key)
 }
+
+func loadHostKey(keyPath string) (ssh.Signer, error) {
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read host key: %w", err)
+	}
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM data in host key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// commaListFlag is a flag taking a comma-separated list.
+type commaListFlag []string
+
+func (l *commaListFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *commaListFlag) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
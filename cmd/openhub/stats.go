@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// adminStats prints the instance summary from the server.
+func adminStats() {
+	var resp struct {
+		Repos struct {
+			Total    int `json:"total"`
+			Private  int `json:"private"`
+			Replicas int `json:"replicas"`
+		} `json:"repos"`
+		Users       int   `json:"users"`
+		DiskBytes   int64 `json:"disk_bytes"`
+		Replication struct {
+			ReplicatedRepos int `json:"replicated_repos"`
+			Replicas        int `json:"replicas"`
+			Failing         int `json:"failing"`
+			NeverSynced     int `json:"never_synced"`
+			Queued          int `json:"queued"`
+			Running         int `json:"running"`
+		} `json:"replication"`
+		Pushes *struct {
+			LastHour int       `json:"last_hour"`
+			LastDay  int       `json:"last_day"`
+			Since    time.Time `json:"since"`
+		} `json:"pushes"`
+		UptimeSeconds int64 `json:"uptime_seconds"`
+	}
+	adminAPI("GET", "/api/v1/admin/stats", nil, &resp)
+	if jsonOutput {
+		return
+	}
+
+	fmt.Printf("Repositories: %d (%d private, %d replicas of other instances)\n", resp.Repos.Total, resp.Repos.Private, resp.Repos.Replicas)
+	fmt.Printf("Users:        %d\n", resp.Users)
+	fmt.Printf("Disk usage:   %s\n", formatSize(resp.DiskBytes))
+	r := resp.Replication
+	fmt.Printf("Replication:  %d queued, %d running; %d replicas of %d repos", r.Queued, r.Running, r.Replicas, r.ReplicatedRepos)
+	if r.Failing > 0 || r.NeverSynced > 0 {
+		fmt.Printf(", %d failing, %d never synced", r.Failing, r.NeverSynced)
+	}
+	fmt.Println()
+	if p := resp.Pushes; p != nil {
+		fmt.Printf("Refs pushed:  %d in the last hour, %d in the last day", p.LastHour, p.LastDay)
+		if time.Since(p.Since) < 24*time.Hour {
+			fmt.Printf(" (counting since %s)", p.Since.Local().Format("2006-01-02 15:04:05"))
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Uptime:       %s\n", (time.Duration(resp.UptimeSeconds) * time.Second).String())
+}
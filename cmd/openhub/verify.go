@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+func adminVerify(target string, workers int) {
+	store := getStorage()
+
+	var repos []storage.Repo
+	var err error
+	switch {
+	case strings.Contains(target, "/"):
+		owner, name := splitRepoPath(target)
+		if !store.RepoExists(owner, name) {
+			fmt.Printf("error: repository not found: %s\n", target)
+			os.Exit(1)
+		}
+		repos = []storage.Repo{{Owner: owner, Name: name}}
+	case target != "":
+		repos, err = store.ListReposByOwner(target)
+	default:
+		repos, err = store.ListRepos()
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	results := store.FsckRepos(repos, workers, func(r storage.FsckResult) {
+		if r.OK() {
+			fmt.Printf("✓ %s/%s\n", r.Owner, r.Name)
+			return
+		}
+		fmt.Printf("✗ %s/%s\n", r.Owner, r.Name)
+		if r.Error != "" {
+			fmt.Printf("    %s\n", r.Error)
+		}
+		for _, p := range r.Problems {
+			fmt.Printf("    %s\n", p)
+		}
+	})
+
+	failed := []storage.FsckResult{}
+	for _, r := range results {
+		if !r.OK() {
+			failed = append(failed, r)
+		}
+	}
+
+	if jsonOutput {
+		printJSON(map[string]interface{}{"checked": len(results), "ok": len(failed) == 0, "failed": failed})
+	} else if len(failed) == 0 {
+		fmt.Printf("\n%d repositories checked, no corruption found\n", len(results))
+	} else {
+		fmt.Printf("\n%d of %d repositories have problems\n", len(failed), len(results))
+		fmt.Println("Restore them from a replica or a backup before replication spreads the damage.")
+	}
+	if len(failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+	"github.com/jeremytregunna/openhub/internal/webhooks"
+)
+
+// webhookRequest calls the webhooks API at path, under /api/v1, as the
+// repository owner and decodes the response into result, exiting on any
+// error. With --json the response is also printed, and callers print
+// nothing more.
+func webhookRequest(method, path, token string, query url.Values, body interface{}, result interface{}) {
+	token = cliToken(token)
+	if token == "" {
+		fmt.Println("error: the repository owner's API token is required (--token, OPENHUB_TOKEN or a profile)")
+		os.Exit(1)
+	}
+
+	apiURL := cliAPIURL()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			fmt.Printf("json error: %v\n", err)
+			os.Exit(1)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := apiURL + "/api/v1" + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := apiDo(req)
+	if err != nil {
+		fmt.Printf("request error: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("read error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var status struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		fmt.Printf("json decode error: %v\n", err)
+		os.Exit(1)
+	}
+	if !status.Success {
+		fmt.Printf("error: %s\n", status.Error)
+		os.Exit(1)
+	}
+	if result != nil {
+		if err := json.Unmarshal(data, result); err != nil {
+			fmt.Printf("json decode error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if jsonOutput {
+		printRawJSON(data)
+	}
+}
+
+func splitRepoPath(path string) (string, string) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		fmt.Println("invalid repo path, must be owner/name")
+		os.Exit(1)
+	}
+	return parts[0], parts[1]
+}
+
+func adminAddWebhook(path, hookURL, secret string, events []string, token string) {
+	owner, name := splitRepoPath(path)
+
+	var result struct {
+		Webhook storage.Webhook `json:"webhook"`
+	}
+	webhookRequest("POST", "/repos/webhooks", token, nil, map[string]interface{}{
+		"owner":  owner,
+		"name":   name,
+		"url":    hookURL,
+		"secret": secret,
+		"events": events,
+	}, &result)
+	if jsonOutput {
+		return
+	}
+
+	fmt.Printf("✓ Webhook %s added to %s/%s\n", result.Webhook.ID, owner, name)
+	fmt.Printf("URL: %s\n", result.Webhook.URL)
+	fmt.Printf("Events: %s\n", strings.Join(result.Webhook.Events, ", "))
+	fmt.Println("A ping event is on its way to it.")
+}
+
+func adminListWebhooks(path, token string) {
+	owner, name := splitRepoPath(path)
+
+	var result struct {
+		Webhooks []storage.Webhook `json:"webhooks"`
+	}
+	webhookRequest("GET", "/repos/webhooks", token, url.Values{"owner": {owner}, "name": {name}}, nil, &result)
+	if jsonOutput {
+		return
+	}
+
+	if len(result.Webhooks) == 0 {
+		fmt.Printf("No webhooks for %s/%s\n", owner, name)
+		return
+	}
+
+	fmt.Printf("Webhooks for %s/%s:\n", owner, name)
+	for _, h := range result.Webhooks {
+		fmt.Printf("  %s\n", h.ID)
+		fmt.Printf("    URL: %s\n", h.URL)
+		fmt.Printf("    Events: %s\n", strings.Join(h.Events, ", "))
+		if h.Secret != "" {
+			fmt.Println("    Signed: yes")
+		} else {
+			fmt.Println("    Signed: no")
+		}
+		if !h.Active {
+			fmt.Println("    Inactive")
+		}
+	}
+}
+
+func adminRemoveWebhook(path, id, token string) {
+	owner, name := splitRepoPath(path)
+
+	webhookRequest("DELETE", "/repos/webhooks", token, url.Values{"owner": {owner}, "name": {name}, "id": {id}}, nil, nil)
+	if jsonOutput {
+		return
+	}
+	fmt.Printf("✓ Webhook %s removed from %s/%s\n", id, owner, name)
+}
+
+func adminWebhookDeliveries(path, hook, token string) {
+	owner, name := splitRepoPath(path)
+
+	var result struct {
+		Deliveries []webhooks.LogEntry `json:"deliveries"`
+	}
+	query := url.Values{"owner": {owner}, "name": {name}}
+	if hook != "" {
+		query.Set("hook", hook)
+	}
+	webhookRequest("GET", "/repos/webhooks/deliveries", token, query, nil, &result)
+	if jsonOutput {
+		return
+	}
+
+	if len(result.Deliveries) == 0 {
+		fmt.Printf("No webhook deliveries for %s/%s\n", owner, name)
+		return
+	}
+
+	fmt.Printf("Webhook deliveries for %s/%s, newest first:\n", owner, name)
+	for _, d := range result.Deliveries {
+		outcome := fmt.Sprintf("%d", d.Status)
+		if d.Error != "" {
+			outcome = d.Error
+		}
+		fmt.Printf("  %s  %s  %s to %s (attempt %d, %dms): %s\n",
+			d.Time.Local().Format("2006-01-02 15:04:05"), d.Delivery, d.Event, d.Hook, d.Attempt, d.LatencyMS, outcome)
+		if d.RedeliveryOf != "" {
+			fmt.Printf("    Redelivery of %s\n", d.RedeliveryOf)
+		}
+	}
+}
+
+func adminRedeliverWebhook(path, delivery, token string) {
+	owner, name := splitRepoPath(path)
+
+	var result struct {
+		Delivery string `json:"delivery"`
+	}
+	webhookRequest("POST", "/repos/webhooks/redeliver", token, nil, map[string]interface{}{
+		"owner":    owner,
+		"name":     name,
+		"delivery": delivery,
+	}, &result)
+	if jsonOutput {
+		return
+	}
+	fmt.Printf("✓ Delivery %s of %s/%s queued again as %s\n", delivery, owner, name, result.Delivery)
+}
@@ -0,0 +1,69 @@
+// Package activation picks up listening sockets passed in by systemd socket
+// activation, so the server can be started on demand and bound to
+// privileged ports without running as root.
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// firstFD is the first file descriptor systemd passes sockets on.
+const firstFD = 3
+
+// Names are the FileDescriptorName= values the server accepts sockets under.
+var Names = []string{"ssh", "http", "https"}
+
+// Listeners returns the sockets systemd passed to this process, by their
+// FileDescriptorName=. It returns an empty map when the process wasn't
+// socket-activated. The LISTEN_* variables are removed from the
+// environment so git and hook subprocesses don't see them.
+func Listeners() (map[string]net.Listener, error) {
+	listeners := map[string]net.Listener{}
+
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	names := os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid == "" || fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return listeners, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	if n == 0 {
+		return listeners, nil
+	}
+
+	fdNames := strings.Split(names, ":")
+	if names == "" || len(fdNames) != n {
+		return nil, fmt.Errorf("%d sockets passed without names; set FileDescriptorName= to one of %s", n, strings.Join(Names, ", "))
+	}
+
+	for i, name := range fdNames {
+		if !slices.Contains(Names, name) {
+			return nil, fmt.Errorf("socket %q: FileDescriptorName= must be one of %s", name, strings.Join(Names, ", "))
+		}
+		if _, dup := listeners[name]; dup {
+			return nil, fmt.Errorf("more than one socket named %q", name)
+		}
+
+		// FileListener duplicates the descriptor, close-on-exec, so the
+		// inherited one is closed once it's been taken.
+		f := os.NewFile(uintptr(firstFD+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %q: %w", name, err)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
@@ -0,0 +1,248 @@
+package activity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/policy"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	KindPush   = "push"
+	KindReview = "review"
+
+	zeroSHA = "0000000000000000000000000000000000000000"
+)
+
+// Entry is one line of a repository's activity journal. Pushes are written by
+// the post-receive hook, one entry per updated ref; reviews by the server.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	User    string    `json:"user"`
+	Ref     string    `json:"ref,omitempty"`
+	Old     string    `json:"old,omitempty"`
+	New     string    `json:"new,omitempty"`
+	Commits int       `json:"commits,omitempty"`
+}
+
+type Counts struct {
+	Commits int `json:"commits"`
+	Reviews int `json:"reviews"`
+}
+
+// Index is the journal aggregated into daily buckets. Offset is how far into
+// the journal it has read, so each update only reads what was appended since.
+type Index struct {
+	Offset int64 `json:"offset"`
+	// Days maps a UTC date ("2006-01-02") to counts per user.
+	Days map[string]map[string]*Counts `json:"days"`
+}
+
+func dir(repoPath string) string {
+	return filepath.Join(repoPath, "activity")
+}
+
+func journalPath(repoPath string) string {
+	return filepath.Join(dir(repoPath), "journal.jsonl")
+}
+
+func indexPath(repoPath string) string {
+	return filepath.Join(dir(repoPath), "index.json")
+}
+
+// Append adds entries to a repository's journal. Each entry is a single
+// small append, so the hook process and the server can write concurrently.
+func Append(repoPath string, entries ...Entry) error {
+	if err := os.MkdirAll(dir(repoPath), 0755); err != nil {
+		return fmt.Errorf("create activity dir: %w", err)
+	}
+
+	f, err := os.OpenFile(journalPath(repoPath), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("marshal entry: %w", err)
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("write journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// PushEntries builds journal entries for the ref updates of a push that has
+// just been accepted. Each entry counts the commits the push brought into the
+// repository through that ref; commits reachable from another ref, or already
+// counted for an earlier ref in the same push, aren't counted again.
+func PushEntries(repoPath, user string, updates []policy.RefUpdate) ([]Entry, error) {
+	now := time.Now()
+
+	// The refs are already updated, so the push's own refs are excluded and
+	// their old values stand in for them.
+	var excludes, olds []string
+	for _, u := range updates {
+		excludes = append(excludes, "--exclude="+u.Ref)
+		if u.Old != zeroSHA {
+			olds = append(olds, u.Old)
+		}
+	}
+
+	var entries []Entry
+	var counted []string
+	for _, u := range updates {
+		e := Entry{Time: now, Kind: KindPush, User: user, Ref: u.Ref, Old: u.Old, New: u.New}
+
+		if u.New != zeroSHA {
+			args := []string{"rev-list", "--count", u.New, "--not"}
+			args = append(args, olds...)
+			args = append(args, counted...)
+			args = append(args, excludes...)
+			args = append(args, "--all")
+
+			cmd := exec.Command("git", args...)
+			cmd.Dir = repoPath
+			out, err := cmd.Output()
+			if err != nil {
+				return nil, fmt.Errorf("git rev-list: %w", err)
+			}
+			e.Commits, _ = strconv.Atoi(strings.TrimSpace(string(out)))
+			counted = append(counted, u.New)
+		}
+
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Tracker maintains the activity indexes of the repositories in a store.
+type Tracker struct {
+	storage *storage.Storage
+	mu      sync.Mutex
+}
+
+func NewTracker(store *storage.Storage) *Tracker {
+	return &Tracker{storage: store}
+}
+
+// Record appends an entry to a repository's journal.
+func (t *Tracker) Record(owner, repo string, e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	return Append(t.storage.RepoPath(owner, repo), e)
+}
+
+// Repo returns a repository's index, first folding in any journal entries
+// appended since it was last read.
+func (t *Tracker) Repo(owner, repo string) (*Index, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	repoPath := t.storage.RepoPath(owner, repo)
+
+	idx := &Index{Days: make(map[string]map[string]*Counts)}
+	if data, err := os.ReadFile(indexPath(repoPath)); err == nil {
+		if err := json.Unmarshal(data, idx); err != nil {
+			return nil, fmt.Errorf("unmarshal activity index: %w", err)
+		}
+		if idx.Days == nil {
+			idx.Days = make(map[string]map[string]*Counts)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read activity index: %w", err)
+	}
+
+	f, err := os.Open(journalPath(repoPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat journal: %w", err)
+	}
+	if info.Size() < idx.Offset {
+		// The journal was replaced; start over.
+		idx = &Index{Days: make(map[string]map[string]*Counts)}
+	}
+	if info.Size() == idx.Offset {
+		return idx, nil
+	}
+
+	if _, err := f.Seek(idx.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek journal: %w", err)
+	}
+
+	// Only whole lines are consumed; a line still being written is picked
+	// up next time.
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		idx.Offset += int64(len(line))
+
+		var e Entry
+		if json.Unmarshal(bytes.TrimSpace(line), &e) != nil || e.User == "" {
+			continue
+		}
+		idx.add(e)
+	}
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return nil, fmt.Errorf("marshal activity index: %w", err)
+	}
+	tmp := indexPath(repoPath) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, fmt.Errorf("write activity index: %w", err)
+	}
+	if err := os.Rename(tmp, indexPath(repoPath)); err != nil {
+		return nil, fmt.Errorf("write activity index: %w", err)
+	}
+
+	return idx, nil
+}
+
+func (idx *Index) add(e Entry) {
+	day := e.Time.UTC().Format("2006-01-02")
+	users, ok := idx.Days[day]
+	if !ok {
+		users = make(map[string]*Counts)
+		idx.Days[day] = users
+	}
+	c, ok := users[e.User]
+	if !ok {
+		c = &Counts{}
+		users[e.User] = c
+	}
+
+	switch e.Kind {
+	case KindPush:
+		c.Commits += e.Commits
+	case KindReview:
+		c.Reviews++
+	}
+}
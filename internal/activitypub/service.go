@@ -0,0 +1,576 @@
+package activitypub
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	contentType = "application/activity+json"
+	publicTo    = "https://www.w3.org/ns/activitystreams#Public"
+	maxBodySize = 1 << 20
+
+	// instanceActor names the instance's own actor; repository actors are
+	// named "owner/name".
+	instanceActor = "instance"
+
+	// maxDeliveryAttempts bounds retries to an unreachable inbox, backing off
+	// quadratically to roughly a day in total.
+	maxDeliveryAttempts = 8
+	outboxPageSize      = 20
+)
+
+var contexts = []string{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+	"https://forgefed.org/ns",
+}
+
+type Storage interface {
+	RepoExists(owner, name string) bool
+	GetMetadata(owner, name string) (storage.Metadata, error)
+	RepoPath(owner, name string) string
+}
+
+// Service publishes ForgeFed activities for the instance and each public
+// repository, and accepts follows from other ActivityPub servers. Actors live
+// under /api/activitypub/; the instance actor is also discoverable through
+// WebFinger as instance@<host>.
+type Service struct {
+	dir       string
+	baseURL   string
+	store     Storage
+	key       *rsa.PrivateKey
+	publicPEM string
+	client    *http.Client
+	mu        sync.Mutex
+}
+
+// New enables ActivityPub on storagePath, with actor IDs rooted at baseURL,
+// the instance's public URL.
+func New(storagePath, baseURL string, store Storage) (*Service, error) {
+	dir := baseDir(storagePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create activitypub dir: %w", err)
+	}
+
+	key, err := loadOrCreateKey(filepath.Join(storagePath, "activitypub_rsa.pem"))
+	if err != nil {
+		return nil, err
+	}
+	publicPEM, err := publicKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(enabledPath(storagePath), nil, 0644); err != nil {
+		return nil, fmt.Errorf("enable activitypub: %w", err)
+	}
+
+	return &Service{
+		dir:       dir,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		store:     store,
+		key:       key,
+		publicPEM: publicPEM,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Publish queues an event from the server process.
+func (s *Service) Publish(ev Event) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if err := writeSpool(filepath.Join(s.dir, "events"), ev); err != nil {
+		return fmt.Errorf("spool activitypub event: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) actorURL(actor string) string {
+	if actor == instanceActor {
+		return s.baseURL + "/api/activitypub/instance"
+	}
+	return s.baseURL + "/api/activitypub/repos/" + actor
+}
+
+func (s *Service) keyID(actor string) string {
+	return s.actorURL(actor) + "#main-key"
+}
+
+// published reports whether a repository has an actor: it must exist, be
+// public, and not be a replica, whose origin already speaks for it.
+func (s *Service) published(owner, name string) (storage.Metadata, bool) {
+	if !validName(owner) || !validName(name) || !s.store.RepoExists(owner, name) {
+		return storage.Metadata{}, false
+	}
+	meta, err := s.store.GetMetadata(owner, name)
+	if err != nil || meta.Private || meta.ReplicaOf != nil {
+		return storage.Metadata{}, false
+	}
+	return meta, true
+}
+
+func validName(name string) bool {
+	if name == "" || len(name) > 100 || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") {
+		return false
+	}
+	for _, c := range name {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Service) actorDocument(actor string, meta storage.Metadata) map[string]interface{} {
+	id := s.actorURL(actor)
+	doc := map[string]interface{}{
+		"@context":  contexts,
+		"id":        id,
+		"inbox":     id + "/inbox",
+		"outbox":    id + "/outbox",
+		"followers": id + "/followers",
+		"publicKey": map[string]string{
+			"id":           s.keyID(actor),
+			"owner":        id,
+			"publicKeyPem": s.publicPEM,
+		},
+	}
+
+	if actor == instanceActor {
+		doc["type"] = "Application"
+		doc["preferredUsername"] = instanceActor
+		doc["name"] = "openhub"
+		doc["url"] = s.baseURL
+		return doc
+	}
+
+	owner, name, _ := strings.Cut(actor, "/")
+	doc["type"] = "Repository"
+	doc["preferredUsername"] = name
+	doc["name"] = actor
+	doc["summary"] = meta.Description
+	doc["url"] = s.baseURL + "/" + actor
+	doc["cloneUri"] = fmt.Sprintf("%s/%s/%s.git", s.baseURL, owner, name)
+	doc["attributedTo"] = owner
+	if !meta.CreatedAt.IsZero() {
+		doc["published"] = meta.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	return doc
+}
+
+// Start processes spooled events and deliveries every interval.
+func (s *Service) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			s.processEvents()
+			s.processDeliveries()
+		}
+	}()
+}
+
+func (s *Service) processEvents() {
+	files, err := spooled(filepath.Join(s.dir, "events"))
+	if err != nil {
+		log.Printf("activitypub: list events failed: %v", err)
+		return
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("activitypub: read event failed: %v", err)
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			log.Printf("activitypub: dropping malformed event %s: %v", filepath.Base(file), err)
+		} else if err := s.handleEvent(ev); err != nil {
+			log.Printf("activitypub: %s event for %s/%s failed: %v", ev.Kind, ev.Owner, ev.Repo, err)
+		}
+		os.Remove(file)
+	}
+}
+
+// handleEvent turns an event into an activity, adds it to the acting actor's
+// outbox and queues it for that actor's followers.
+func (s *Service) handleEvent(ev Event) error {
+	meta, ok := s.published(ev.Owner, ev.Repo)
+	if !ok {
+		return nil
+	}
+	repoActor := ev.Owner + "/" + ev.Repo
+
+	var actor string
+	var activity map[string]interface{}
+	switch ev.Kind {
+	case EventCreate:
+		actor = instanceActor
+		activity = map[string]interface{}{
+			"type":   "Create",
+			"object": s.actorDocument(repoActor, meta),
+		}
+	case EventPush:
+		actor = repoActor
+		activity = s.pushActivity(ev)
+	default:
+		return fmt.Errorf("unknown event kind %q", ev.Kind)
+	}
+
+	id := s.actorURL(actor)
+	activity["@context"] = contexts
+	activity["id"] = id + "/activities/" + randomID()
+	activity["actor"] = id
+	activity["published"] = ev.Time.UTC().Format(time.RFC3339)
+	activity["to"] = []string{publicTo}
+	activity["cc"] = []string{id + "/followers"}
+
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	if err := s.appendOutbox(actor, data); err != nil {
+		return err
+	}
+	return s.queueForFollowers(actor, data)
+}
+
+// pushActivity describes a ref update as a ForgeFed Push, listing up to
+// outboxPageSize of the commits it brought in.
+func (s *Service) pushActivity(ev Event) map[string]interface{} {
+	var commits []map[string]string
+	args := []string{"log", "--format=%H%x09%s", fmt.Sprintf("-%d", outboxPageSize), ev.New}
+	if ev.Old != "" && strings.Trim(ev.Old, "0") != "" {
+		args = append(args, "^"+ev.Old)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.store.RepoPath(ev.Owner, ev.Repo)
+	if out, err := cmd.Output(); err == nil {
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			hash, subject, ok := strings.Cut(line, "\t")
+			if !ok {
+				continue
+			}
+			commits = append(commits, map[string]string{"type": "Commit", "hash": hash, "summary": subject})
+		}
+	}
+
+	noun := "commits"
+	if ev.Commits == 1 {
+		noun = "commit"
+	}
+	return map[string]interface{}{
+		"type":         "Push",
+		"attributedTo": ev.User,
+		"context":      s.actorURL(ev.Owner + "/" + ev.Repo),
+		"target":       ev.Ref,
+		"summary":      fmt.Sprintf("%s pushed %d %s to %s", ev.User, ev.Commits, noun, ev.Ref),
+		"object": map[string]interface{}{
+			"type":         "OrderedCollection",
+			"totalItems":   ev.Commits,
+			"orderedItems": commits,
+		},
+	}
+}
+
+func (s *Service) queueForFollowers(actor string, activity []byte) error {
+	s.mu.Lock()
+	followers, err := s.followers(actor)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Followers on the same server often share an inbox.
+	inboxes := make(map[string]bool)
+	for _, f := range followers {
+		inboxes[f.Inbox] = true
+	}
+	for inbox := range inboxes {
+		if err := s.queueDelivery(actor, inbox, activity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) queueDelivery(actor, inbox string, activity []byte) error {
+	return writeSpool(filepath.Join(s.dir, "deliveries"), delivery{
+		Actor:    actor,
+		Inbox:    inbox,
+		Activity: activity,
+	})
+}
+
+func (s *Service) processDeliveries() {
+	files, err := spooled(filepath.Join(s.dir, "deliveries"))
+	if err != nil {
+		log.Printf("activitypub: list deliveries failed: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var d delivery
+		if err := json.Unmarshal(data, &d); err != nil {
+			os.Remove(file)
+			continue
+		}
+		if now.Before(d.NextAttempt) {
+			continue
+		}
+
+		err = s.post(d.Inbox, d.Actor, d.Activity)
+		if err == nil {
+			os.Remove(file)
+			continue
+		}
+
+		d.Attempts++
+		if d.Attempts >= maxDeliveryAttempts {
+			log.Printf("activitypub: giving up delivering to %s after %d attempts: %v", d.Inbox, d.Attempts, err)
+			os.Remove(file)
+			continue
+		}
+		log.Printf("activitypub: delivery to %s failed (attempt %d): %v", d.Inbox, d.Attempts, err)
+		d.NextAttempt = now.Add(time.Duration(d.Attempts*d.Attempts) * 5 * time.Minute)
+		if data, err := json.Marshal(d); err == nil {
+			os.WriteFile(file, data, 0644)
+		}
+	}
+}
+
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/.well-known/webfinger" {
+		s.handleWebFinger(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/activitypub/"), "/"), "/")
+	var actor string
+	var rest []string
+	switch {
+	case parts[0] == instanceActor:
+		actor, rest = instanceActor, parts[1:]
+	case parts[0] == "repos" && len(parts) >= 3:
+		actor, rest = parts[1]+"/"+parts[2], parts[3:]
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	var meta storage.Metadata
+	if actor != instanceActor {
+		var ok bool
+		if meta, ok = s.published(parts[1], parts[2]); !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	switch {
+	case len(rest) == 0:
+		s.writeJSON(w, http.StatusOK, s.actorDocument(actor, meta))
+	case len(rest) == 1 && rest[0] == "inbox":
+		s.handleInbox(w, r, actor)
+	case len(rest) == 1 && rest[0] == "outbox":
+		s.handleOutbox(w, r, actor)
+	case len(rest) == 1 && rest[0] == "followers":
+		s.handleFollowers(w, r, actor)
+	case len(rest) == 2 && rest[0] == "activities":
+		s.handleActivity(w, r, actor, s.actorURL(actor)+"/activities/"+rest[1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Service) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleWebFinger resolves acct:instance@<host> to the instance actor.
+// Repository actors are found by their URL.
+//
+//	GET /.well-known/webfinger?resource=acct:instance@<host>
+func (s *Service) handleWebFinger(w http.ResponseWriter, r *http.Request) {
+	host := s.baseURL
+	if u, err := url.Parse(s.baseURL); err == nil {
+		host = u.Host
+	}
+
+	resource := r.URL.Query().Get("resource")
+	if resource != "acct:"+instanceActor+"@"+host && resource != s.actorURL(instanceActor) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jrd+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"subject": "acct:" + instanceActor + "@" + host,
+		"links": []map[string]string{{
+			"rel":  "self",
+			"type": contentType,
+			"href": s.actorURL(instanceActor),
+		}},
+	})
+}
+
+// handleInbox accepts Follow and Undo Follow activities addressed to actor.
+// Every other activity is acknowledged and ignored.
+func (s *Service) handleInbox(w http.ResponseWriter, r *http.Request, actor string) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sender, err := s.verify(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var activity struct {
+		Type   string          `json:"type"`
+		Actor  string          `json:"actor"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal(body, &activity); err != nil {
+		http.Error(w, "invalid activity", http.StatusBadRequest)
+		return
+	}
+	if activity.Actor != sender.ID {
+		http.Error(w, "activity actor does not match signature", http.StatusForbidden)
+		return
+	}
+
+	switch activity.Type {
+	case "Follow":
+		var object string
+		if json.Unmarshal(activity.Object, &object) != nil || object != s.actorURL(actor) {
+			http.Error(w, "follow object is not this actor", http.StatusBadRequest)
+			return
+		}
+		err := s.updateFollowers(actor, func(f map[string]Follower) {
+			f[sender.ID] = Follower{Inbox: sender.Inbox, Since: time.Now()}
+		})
+		if err != nil {
+			log.Printf("activitypub: record follower failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		accept, _ := json.Marshal(map[string]interface{}{
+			"@context": contexts,
+			"id":       s.actorURL(actor) + "/activities/" + randomID(),
+			"type":     "Accept",
+			"actor":    s.actorURL(actor),
+			"object":   json.RawMessage(body),
+		})
+		if err := s.queueDelivery(actor, sender.Inbox, accept); err != nil {
+			log.Printf("activitypub: queue accept failed: %v", err)
+		}
+		log.Printf("activitypub: %s now follows %s", sender.ID, actor)
+
+	case "Undo":
+		var undone struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(activity.Object, &undone) == nil && undone.Type == "Follow" {
+			err := s.updateFollowers(actor, func(f map[string]Follower) {
+				delete(f, sender.ID)
+			})
+			if err != nil {
+				log.Printf("activitypub: remove follower failed: %v", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("activitypub: %s unfollowed %s", sender.ID, actor)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Service) handleOutbox(w http.ResponseWriter, r *http.Request, actor string) {
+	items, err := s.outbox(actor)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	total := len(items)
+	if len(items) > outboxPageSize {
+		items = items[:outboxPageSize]
+	}
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"@context":     contexts,
+		"id":           s.actorURL(actor) + "/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   total,
+		"orderedItems": items,
+	})
+}
+
+// handleFollowers reports how many followers an actor has, without listing
+// them.
+func (s *Service) handleFollowers(w http.ResponseWriter, r *http.Request, actor string) {
+	s.mu.Lock()
+	followers, err := s.followers(actor)
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"@context":   contexts,
+		"id":         s.actorURL(actor) + "/followers",
+		"type":       "OrderedCollection",
+		"totalItems": len(followers),
+	})
+}
+
+func (s *Service) handleActivity(w http.ResponseWriter, r *http.Request, actor, id string) {
+	items, err := s.outbox(actor)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	for _, item := range items {
+		var a struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(item, &a) == nil && a.ID == id {
+			s.writeJSON(w, http.StatusOK, item)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,250 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// signatureMaxSkew bounds the Date of a signed request. It is looser than
+// openhub's own federation because fediverse servers retry deliveries.
+const signatureMaxSkew = time.Hour
+
+// loadOrCreateKey returns the RSA key every actor on this instance signs
+// with. ActivityPub servers overwhelmingly only verify RSA signatures, so the
+// instance's ed25519 key can't be reused.
+func loadOrCreateKey(path string) (*rsa.PrivateKey, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid key in %s", path)
+		}
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse key in %s: %w", path, err)
+		}
+		return key, nil
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("write key: %w", err)
+	}
+	return key, nil
+}
+
+func publicKeyPEM(key *rsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func signingString(r *http.Request, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines[i] = h + ": " + strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			host := r.Host
+			if host == "" {
+				host = r.URL.Host
+			}
+			lines[i] = "host: " + host
+		default:
+			lines[i] = h + ": " + r.Header.Get(h)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sign adds an HTTP Signature (draft-cavage, rsa-sha256) to r, covering the
+// request target, host, date and, when there is a body, its digest.
+func sign(r *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		r.Header.Set("Digest", digest(body))
+		headers = append(headers, "digest")
+	}
+
+	hash := sha256.Sum256([]byte(signingString(r, headers)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return fmt.Errorf("sign request: %w", err)
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+func parseSignature(header string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		params[key] = strings.Trim(value, `"`)
+	}
+	return params
+}
+
+// remoteActor is the part of another server's actor document needed to talk
+// to it.
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	PublicKey struct {
+		ID           string `json:"id"`
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+// fetchActor dereferences an actor, signing the request as the instance actor
+// for servers that refuse anonymous fetches.
+func (s *Service) fetchActor(id string) (*remoteActor, error) {
+	req, err := http.NewRequest("GET", id, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", contentType)
+	if err := sign(req, nil, s.keyID(instanceActor), s.key); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch actor: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch actor: %s", resp.Status)
+	}
+
+	var actor remoteActor
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&actor); err != nil {
+		return nil, fmt.Errorf("decode actor: %w", err)
+	}
+	if actor.ID != id || actor.Inbox == "" {
+		return nil, fmt.Errorf("actor document for %s is incomplete", id)
+	}
+	return &actor, nil
+}
+
+// verify checks the HTTP Signature on an incoming request and returns the
+// actor whose key made it.
+func (s *Service) verify(r *http.Request, body []byte) (*remoteActor, error) {
+	params := parseSignature(r.Header.Get("Signature"))
+	keyID, encoded := params["keyId"], params["signature"]
+	if keyID == "" || encoded == "" {
+		return nil, fmt.Errorf("missing signature")
+	}
+	if alg := params["algorithm"]; alg != "" && alg != "rsa-sha256" && alg != "hs2019" {
+		return nil, fmt.Errorf("unsupported signature algorithm %s", alg)
+	}
+
+	headers := strings.Fields(params["headers"])
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+	covered := make(map[string]bool)
+	for _, h := range headers {
+		covered[h] = true
+	}
+	if !covered["(request-target)"] || !covered["date"] || !covered["digest"] {
+		return nil, fmt.Errorf("signature must cover (request-target), date and digest")
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid date")
+	}
+	if skew := time.Since(date); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		return nil, fmt.Errorf("date out of range")
+	}
+	if r.Header.Get("Digest") != digest(body) {
+		return nil, fmt.Errorf("digest mismatch")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding")
+	}
+
+	actorID, _, _ := strings.Cut(keyID, "#")
+	actor, err := s.fetchActor(actorID)
+	if err != nil {
+		return nil, err
+	}
+	if actor.PublicKey.ID != keyID || (actor.PublicKey.Owner != "" && actor.PublicKey.Owner != actor.ID) {
+		return nil, fmt.Errorf("key %s does not belong to %s", keyID, actor.ID)
+	}
+
+	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+	if block == nil {
+		return nil, fmt.Errorf("actor has no usable public key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse actor key: %w", err)
+	}
+	pub, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("actor key is not RSA")
+	}
+
+	hash := sha256.Sum256([]byte(signingString(r, headers)))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return actor, nil
+}
+
+// post delivers an activity to an inbox, signed as the sending actor.
+func (s *Service) post(inbox string, actor string, activity []byte) error {
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(activity))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := sign(req, activity, s.keyID(actor), s.key); err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodySize))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("inbox returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package activitypub
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	EventPush   = "push"
+	EventCreate = "create"
+)
+
+// Event is something that happened to a repository, queued for the server to
+// turn into activities. Events are files in a spool directory so the push
+// hook, which runs in its own process and doesn't know the instance's public
+// URL, can record pushes as well.
+type Event struct {
+	Kind    string    `json:"kind"`
+	Owner   string    `json:"owner"`
+	Repo    string    `json:"repo"`
+	User    string    `json:"user,omitempty"`
+	Ref     string    `json:"ref,omitempty"`
+	Old     string    `json:"old,omitempty"`
+	New     string    `json:"new,omitempty"`
+	Commits int       `json:"commits,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func baseDir(storagePath string) string {
+	return filepath.Join(storagePath, ".activitypub")
+}
+
+// enabledPath marks that a server publishing activities runs on this
+// storage, so the hook doesn't spool events nobody will read.
+func enabledPath(storagePath string) string {
+	return filepath.Join(baseDir(storagePath), "enabled")
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeSpool writes v as a new file in dir. The file only appears once
+// complete, so a reader never sees a partial one.
+func writeSpool(dir string, v interface{}) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), randomID())
+	tmp := filepath.Join(dir, "."+name)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+// spooled lists the complete files in a spool directory, oldest first.
+func spooled(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Enqueue records an event for the server to publish. It does nothing unless
+// the server on this storage has ActivityPub enabled.
+func Enqueue(storagePath string, ev Event) error {
+	if _, err := os.Stat(enabledPath(storagePath)); err != nil {
+		return nil
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if err := writeSpool(filepath.Join(baseDir(storagePath), "events"), ev); err != nil {
+		return fmt.Errorf("spool activitypub event: %w", err)
+	}
+	return nil
+}
+
+// Disable stops the hook spooling events, for a server started without
+// ActivityPub. Followers and outboxes are kept for when it's turned back on.
+func Disable(storagePath string) error {
+	if err := os.Remove(enabledPath(storagePath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(baseDir(storagePath), "events"))
+}
+
+// Follower is a remote actor following one of ours, with the inbox
+// activities are delivered to.
+type Follower struct {
+	Inbox string    `json:"inbox"`
+	Since time.Time `json:"since"`
+}
+
+// delivery is an activity waiting to be posted to one inbox.
+type delivery struct {
+	Actor       string          `json:"actor"`
+	Inbox       string          `json:"inbox"`
+	Activity    json.RawMessage `json:"activity"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+}
+
+// actorDir holds an actor's followers and outbox.
+func (s *Service) actorDir(actor string) string {
+	if actor == instanceActor {
+		return filepath.Join(s.dir, "actors", "instance")
+	}
+	return filepath.Join(s.dir, "actors", "repos", filepath.FromSlash(actor))
+}
+
+func (s *Service) followers(actor string) (map[string]Follower, error) {
+	followers := make(map[string]Follower)
+	data, err := os.ReadFile(filepath.Join(s.actorDir(actor), "followers.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return followers, nil
+		}
+		return nil, fmt.Errorf("read followers: %w", err)
+	}
+	if err := json.Unmarshal(data, &followers); err != nil {
+		return nil, fmt.Errorf("unmarshal followers: %w", err)
+	}
+	return followers, nil
+}
+
+// updateFollowers applies fn to an actor's followers, keyed by actor ID.
+func (s *Service) updateFollowers(actor string, fn func(map[string]Follower)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	followers, err := s.followers(actor)
+	if err != nil {
+		return err
+	}
+	fn(followers)
+
+	dir := s.actorDir(actor)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create actor dir: %w", err)
+	}
+	data, err := json.MarshalIndent(followers, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal followers: %w", err)
+	}
+	tmp := filepath.Join(dir, "followers.json.tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write followers: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, "followers.json"))
+}
+
+func (s *Service) appendOutbox(actor string, activity []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.actorDir(actor)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create actor dir: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "outbox.jsonl"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("open outbox: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(activity, '\n')); err != nil {
+		return fmt.Errorf("write outbox: %w", err)
+	}
+	return nil
+}
+
+// outbox returns an actor's published activities, newest first.
+func (s *Service) outbox(actor string) ([]json.RawMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(filepath.Join(s.actorDir(actor), "outbox.jsonl"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open outbox: %w", err)
+	}
+	defer f.Close()
+
+	var items []json.RawMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxBodySize)
+	for scanner.Scan() {
+		items = append(items, json.RawMessage(append([]byte(nil), scanner.Bytes()...)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items, nil
+}
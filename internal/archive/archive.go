@@ -0,0 +1,242 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/metrics"
+)
+
+var (
+	cacheHits   = metrics.NewCounter("openhub_archive_cache_hits_total", "Archive downloads served from cache.")
+	cacheMisses = metrics.NewCounter("openhub_archive_cache_misses_total", "Archive downloads that had to be generated.")
+	cacheBytes  = metrics.NewGauge("openhub_archive_cache_bytes", "Bytes currently held in the archive cache.")
+)
+
+var formats = map[string]string{
+	"tar.gz": "tar.gz",
+	"zip":    "zip",
+}
+
+type entry struct {
+	path       string
+	size       int64
+	lastAccess time.Time
+}
+
+// Cache stores generated archives under dir/<owner>/<repo>/, keyed by ref and
+// resolved commit, evicting least recently used files beyond maxBytes.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	entries  map[string]*entry
+	total    int64
+	inflight map[string]chan struct{}
+}
+
+func NewCache(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create archive cache dir: %w", err)
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*entry),
+		inflight: make(map[string]chan struct{}),
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), "tmp-") {
+			os.Remove(path)
+			return nil
+		}
+		c.entries[path] = &entry{path: path, size: info.Size(), lastAccess: info.ModTime()}
+		c.total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan archive cache: %w", err)
+	}
+	cacheBytes.Set(c.total)
+
+	return c, nil
+}
+
+func ValidFormat(format string) bool {
+	_, ok := formats[format]
+	return ok
+}
+
+// Get returns an open cached archive for commit, generating it from repoPath
+// first if needed. prefix is the top-level directory inside the archive. The
+// file is opened under the cache lock so eviction can't race the caller.
+func (c *Cache) Get(repoPath, owner, repo, ref, commit, format, prefix string) (*os.File, error) {
+	if !ValidFormat(format) {
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+
+	refHash := sha256.Sum256([]byte(ref))
+	path := filepath.Join(c.dir, owner, repo, fmt.Sprintf("%s-%s.%s", hex.EncodeToString(refHash[:8]), commit, format))
+
+	for {
+		c.mu.Lock()
+		if e, ok := c.entries[path]; ok {
+			f, err := os.Open(path)
+			if err == nil {
+				e.lastAccess = time.Now()
+				c.mu.Unlock()
+				cacheHits.Inc()
+				return f, nil
+			}
+			c.total -= e.size
+			delete(c.entries, path)
+		}
+		wait, busy := c.inflight[path]
+		if !busy {
+			c.inflight[path] = make(chan struct{})
+			c.mu.Unlock()
+			break
+		}
+		c.mu.Unlock()
+		<-wait
+	}
+
+	cacheMisses.Inc()
+	size, err := generate(repoPath, path, commit, format, prefix)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	close(c.inflight[path])
+	delete(c.inflight, path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+
+	c.entries[path] = &entry{path: path, size: size, lastAccess: time.Now()}
+	c.total += size
+	c.evictLocked(path)
+	cacheBytes.Set(c.total)
+
+	return f, nil
+}
+
+// Invalidate drops every cached archive for a repository, e.g. after a push.
+func (c *Cache) Invalidate(owner, repo string) {
+	if c == nil {
+		return
+	}
+
+	repoDir := filepath.Join(c.dir, owner, repo) + string(filepath.Separator)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for path, e := range c.entries {
+		if strings.HasPrefix(path, repoDir) {
+			os.Remove(path)
+			c.total -= e.size
+			delete(c.entries, path)
+		}
+	}
+	cacheBytes.Set(c.total)
+}
+
+func (c *Cache) evictLocked(keep string) {
+	if c.maxBytes <= 0 || c.total <= c.maxBytes {
+		return
+	}
+
+	ordered := make([]*entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		ordered = append(ordered, e)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].lastAccess.Before(ordered[j].lastAccess)
+	})
+
+	for _, e := range ordered {
+		if c.total <= c.maxBytes {
+			break
+		}
+		if e.path == keep {
+			continue
+		}
+		os.Remove(e.path)
+		c.total -= e.size
+		delete(c.entries, e.path)
+	}
+}
+
+func generate(repoPath, dest, commit, format, prefix string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, fmt.Errorf("create cache dir: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "tmp-*")
+	if err != nil {
+		return 0, fmt.Errorf("create temp archive: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	cmd := exec.Command("git", "archive", "--format="+formats[format], "--prefix="+prefix+"/", commit)
+	cmd.Dir = repoPath
+	cmd.Stdout = tmp
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	closeErr := tmp.Close()
+	if runErr != nil {
+		return 0, fmt.Errorf("git archive: %w: %s", runErr, strings.TrimSpace(stderr.String()))
+	}
+	if closeErr != nil {
+		return 0, fmt.Errorf("write archive: %w", closeErr)
+	}
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(tmpPath, dest); err != nil {
+		return 0, fmt.Errorf("store archive: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+// ResolveCommit turns a user-supplied ref into a full commit id.
+func ResolveCommit(repoPath, ref string) (string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") || strings.Contains(ref, "..") {
+		return "", fmt.Errorf("invalid ref")
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	cmd.Dir = repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("unknown ref: %s", ref)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
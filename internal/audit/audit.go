@@ -0,0 +1,255 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is one security-relevant action.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Actor is the user who acted, "cli" for the local admin commands, or
+	// empty for an unauthenticated request.
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Client string `json:"client,omitempty"`
+}
+
+// Filter selects entries. Action matches an exact action or, ending in ".",
+// every action with that prefix ("repo." for all repository actions). Zero
+// fields match everything.
+type Filter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f Filter) Match(e Entry) bool {
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			if !strings.HasPrefix(e.Action, f.Action) {
+				return false
+			}
+		} else if e.Action != f.Action {
+			return false
+		}
+	}
+	if f.Target != "" && e.Target != f.Target {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Log is the instance's append-only audit log, one JSON entry per line in
+// <storage>/audit.log. The server and the CLI write to it concurrently;
+// appends of a single line don't interleave.
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+func New(storagePath string) *Log {
+	return &Log{path: filepath.Join(storagePath, "audit.log")}
+}
+
+// Record appends e, stamping it with the current time if it has none.
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return nil
+}
+
+// Query returns the entries matching f, oldest first. With limit > 0 only
+// the newest limit entries are returned.
+func (l *Log) Query(f Filter, limit int) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !f.Match(e) {
+			continue
+		}
+		entries = append(entries, e)
+		if limit > 0 && len(entries) > 2*limit {
+			entries = append(entries[:0], entries[len(entries)-limit:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
+
+// Prune drops entries older than before and returns how many it dropped.
+// The log is rewritten to a temporary file and renamed over the original;
+// lines the CLI appends meanwhile are carried over before the rename.
+func (l *Log) Prune(before time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("read audit log: %w", err)
+	}
+
+	var kept bytes.Buffer
+	dropped := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		// Lines that don't parse are kept rather than silently lost.
+		if json.Unmarshal(line, &e) == nil && e.Time.Before(before) {
+			dropped++
+			continue
+		}
+		kept.Write(line)
+	}
+	if dropped == 0 {
+		return 0, nil
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), 0600); err != nil {
+		return 0, fmt.Errorf("write audit log: %w", err)
+	}
+
+	if err := appendTail(l.path, tmp, int64(len(data))); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	if err := os.Rename(tmp, l.path); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("replace audit log: %w", err)
+	}
+	return dropped, nil
+}
+
+// appendTail copies whatever was appended to path past offset onto dst.
+func appendTail(path, dst string, offset int64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer src.Close()
+
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek audit log: %w", err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, src); err != nil {
+		return fmt.Errorf("copy audit log: %w", err)
+	}
+	return nil
+}
+
+// StartRetention prunes entries older than maxAge now and every interval.
+// A maxAge of 0 keeps entries forever.
+func (l *Log) StartRetention(maxAge, interval time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	prune := func() {
+		n, err := l.Prune(time.Now().Add(-maxAge))
+		if err != nil {
+			log.Printf("audit: prune: %v", err)
+			return
+		}
+		if n > 0 {
+			log.Printf("audit: pruned %d entries older than %s", n, maxAge)
+		}
+	}
+	prune()
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			prune()
+		}
+	}()
+}
+
+// ParseTime reads a query bound as an RFC 3339 time or as a duration before
+// now. An empty string is the zero time, which leaves the bound open.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want an RFC 3339 time or a duration, got %q", s)
+	}
+	return t, nil
+}
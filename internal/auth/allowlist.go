@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jeremytregunna/openhub/internal/clientip"
+)
+
+// ErrAddressNotAllowed is returned for a valid token or key used from an
+// address its allow list doesn't include.
+var ErrAddressNotAllowed = errors.New("not allowed from this address")
+
+// SetTokenAllowedIPs limits where the user's API token called name may be
+// used from to the given IPs and CIDR ranges; none lifts the limit.
+func (a *AuthStore) SetTokenAllowedIPs(username, name string, allowed []string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	allowed, err := clientip.ParseAllowList(allowed)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+	for i := range user.APITokens {
+		if user.APITokens[i].Name == name {
+			user.APITokens[i].AllowedIPs = allowed
+			return a.saveUser(user)
+		}
+	}
+	return fmt.Errorf("api token not found: %s", name)
+}
+
+// SetKeyAllowedIPs limits where the user's SSH key called name may be used
+// from to the given IPs and CIDR ranges; none lifts the limit.
+func (a *AuthStore) SetKeyAllowedIPs(username, name string, allowed []string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	allowed, err := clientip.ParseAllowList(allowed)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+	for i := range user.SSHKeys {
+		if user.SSHKeys[i].Name == name {
+			user.SSHKeys[i].AllowedIPs = allowed
+			return a.saveUser(user)
+		}
+	}
+	return fmt.Errorf("ssh key not found: %s", name)
+}
@@ -8,30 +8,67 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/jeremytregunna/openhub/internal/clientip"
 )
 
 type User struct {
 	Username  string    `json:"username"`
 	SSHKeys   []SSHKey  `json:"ssh_keys"`
+	// SigningKeys verify the commits and tags the user signs.
+	SigningKeys []SigningKey `json:"signing_keys,omitempty"`
 	APITokens []APIToken `json:"api_tokens"`
 	CreatedAt time.Time `json:"created_at"`
+	// RecoveryCodes holds the hashes of the user's unused recovery codes.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	Invite        *Invite  `json:"invite,omitempty"`
+	// Email receives the user's notifications unless they opted out.
+	Email       string `json:"email,omitempty"`
+	EmailOptOut bool   `json:"email_opt_out,omitempty"`
+	// DisplayName, Bio and Avatar make up the public profile.
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	Avatar      string `json:"avatar,omitempty"`
+
+	// legacyTokens is set when the record on disk still holds plain text
+	// tokens.
+	legacyTokens bool
 }
 
 type SSHKey struct {
 	Name      string    `json:"name"`
 	Key       string    `json:"key"`
 	AddedAt   time.Time `json:"added_at"`
+	// AllowedIPs are the addresses the key may be used from; empty
+	// allows any.
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
 }
 
 type APIToken struct {
-	Name      string    `json:"name"`
-	Token     string    `json:"token"`
+	Name string `json:"name"`
+	// Hash is the hash of the token, which is only shown when it's made.
+	Hash string `json:"hash,omitempty"`
+	// Token is the token itself in records written before tokens were
+	// hashed; GetUser hashes it.
+	Token     string    `json:"token,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	// AllowedIPs are the addresses the token may be used from; empty
+	// allows any.
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
 }
 
 type AuthStore struct {
 	basePath string
+	cipher   Cipher
+	// recoverMu keeps two requests from using the same recovery code.
+	recoverMu sync.Mutex
+	// inviteMu keeps two registrations from using the same invite code.
+	inviteMu sync.Mutex
+	// usersMu is held while a user record is read, changed and written
+	// back, so two changes to one user can't lose either.
+	usersMu sync.Mutex
 }
 
 func NewAuthStore(basePath string) (*AuthStore, error) {
@@ -56,15 +93,24 @@ func (a *AuthStore) GetUser(username string) (*User, error) {
 		return nil, fmt.Errorf("read user: %w", err)
 	}
 
+	data, err = decode(a.cipher, username, data)
+	if err != nil {
+		return nil, fmt.Errorf("read user: %w", err)
+	}
+
 	var user User
 	if err := json.Unmarshal(data, &user); err != nil {
 		return nil, fmt.Errorf("unmarshal user: %w", err)
 	}
+	user.legacyTokens = hashLegacyTokens(&user)
 
 	return &user, nil
 }
 
 func (a *AuthStore) CreateUser(username string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
 	if _, err := a.GetUser(username); err == nil {
 		return fmt.Errorf("user already exists: %s", username)
 	}
@@ -80,6 +126,9 @@ func (a *AuthStore) CreateUser(username string) error {
 }
 
 func (a *AuthStore) CreateUserWithToken(username, tokenName, token string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
 	if _, err := a.GetUser(username); err == nil {
 		return fmt.Errorf("user already exists: %s", username)
 	}
@@ -90,7 +139,7 @@ func (a *AuthStore) CreateUserWithToken(username, tokenName, token string) error
 		APITokens: []APIToken{
 			{
 				Name:      tokenName,
-				Token:     token,
+				Hash:      HashSecret(token),
 				CreatedAt: time.Now(),
 			},
 		},
@@ -107,14 +156,69 @@ func (a *AuthStore) saveUser(user *User) error {
 		return fmt.Errorf("marshal user: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0600); err != nil {
+	if a.cipher == nil && a.Encrypted() {
+		return fmt.Errorf("write user: users are encrypted and no master key is configured")
+	}
+	data, err = encode(a.cipher, user.Username, data)
+	if err != nil {
+		return fmt.Errorf("encrypt user: %w", err)
+	}
+
+	if err := writeFile(path, data); err != nil {
 		return fmt.Errorf("write user: %w", err)
 	}
 
 	return nil
 }
 
+// PutUser creates or replaces a user record as-is, for restoring or mirroring
+// accounts from another instance.
+func (a *AuthStore) PutUser(user *User) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	return a.saveUser(user)
+}
+
+func (a *AuthStore) ListUsers() ([]User, error) {
+	entries, err := os.ReadDir(filepath.Join(a.basePath, "users"))
+	if err != nil {
+		return nil, fmt.Errorf("read users dir: %w", err)
+	}
+
+	var users []User
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		user, err := a.GetUser(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		users = append(users, *user)
+	}
+
+	return users, nil
+}
+
+func (a *AuthStore) DeleteUser(username string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	if err := os.Remove(a.userPath(username)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if err := os.Remove(a.avatarPath(username)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("delete avatar: %w", err)
+	}
+	return nil
+}
+
 func (a *AuthStore) AddSSHKey(username, name, key string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
 	user, err := a.GetUser(username)
 	if err != nil {
 		return err
@@ -130,6 +234,9 @@ func (a *AuthStore) AddSSHKey(username, name, key string) error {
 }
 
 func (a *AuthStore) GenerateAPIToken(username, name string) (string, error) {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
 	user, err := a.GetUser(username)
 	if err != nil {
 		return "", err
@@ -143,7 +250,7 @@ func (a *AuthStore) GenerateAPIToken(username, name string) (string, error) {
 
 	user.APITokens = append(user.APITokens, APIToken{
 		Name:      name,
-		Token:     token,
+		Hash:      HashSecret(token),
 		CreatedAt: time.Now(),
 	})
 
@@ -154,7 +261,57 @@ func (a *AuthStore) GenerateAPIToken(username, name string) (string, error) {
 	return token, nil
 }
 
-func (a *AuthStore) ValidateAPIToken(token string) (string, error) {
+// RemoveSSHKey removes the user's SSH key called name.
+func (a *AuthStore) RemoveSSHKey(username, name string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+
+	keys := []SSHKey{}
+	for _, k := range user.SSHKeys {
+		if k.Name != name {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == len(user.SSHKeys) {
+		return fmt.Errorf("ssh key not found: %s", name)
+	}
+	user.SSHKeys = keys
+
+	return a.saveUser(user)
+}
+
+// RevokeAPIToken removes the user's API token called name.
+func (a *AuthStore) RevokeAPIToken(username, name string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+
+	tokens := []APIToken{}
+	for _, t := range user.APITokens {
+		if t.Name != name {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == len(user.APITokens) {
+		return fmt.Errorf("api token not found: %s", name)
+	}
+	user.APITokens = tokens
+
+	return a.saveUser(user)
+}
+
+// ValidateAPIToken returns the user a token belongs to, if it may be used
+// from the client address ip.
+func (a *AuthStore) ValidateAPIToken(token, ip string) (string, error) {
 	entries, err := os.ReadDir(filepath.Join(a.basePath, "users"))
 	if err != nil {
 		return "", fmt.Errorf("read users dir: %w", err)
@@ -172,7 +329,15 @@ func (a *AuthStore) ValidateAPIToken(token string) (string, error) {
 		}
 
 		for _, t := range user.APITokens {
-			if t.Token == token {
+			if SecretMatches(t.Hash, token) {
+				if user.legacyTokens {
+					// Rewrite the record so the plain text tokens leave
+					// the disk.
+					a.rehashTokens(user.Username)
+				}
+				if !clientip.Allowed(t.AllowedIPs, ip) {
+					return "", ErrAddressNotAllowed
+				}
 				return user.Username, nil
 			}
 		}
@@ -181,7 +346,9 @@ func (a *AuthStore) ValidateAPIToken(token string) (string, error) {
 	return "", fmt.Errorf("invalid token")
 }
 
-func (a *AuthStore) ValidateSSHKey(key string) (string, error) {
+// ValidateSSHKey returns the user an SSH key belongs to, if it may be used
+// from the client address ip.
+func (a *AuthStore) ValidateSSHKey(key, ip string) (string, error) {
 	entries, err := os.ReadDir(filepath.Join(a.basePath, "users"))
 	if err != nil {
 		return "", fmt.Errorf("read users dir: %w", err)
@@ -202,6 +369,9 @@ func (a *AuthStore) ValidateSSHKey(key string) (string, error) {
 
 		for _, k := range user.SSHKeys {
 			if normalizeSSHKey(k.Key) == normalizedKey {
+				if !clientip.Allowed(k.AllowedIPs, ip) {
+					return "", ErrAddressNotAllowed
+				}
 				return user.Username, nil
 			}
 		}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"fmt"
+	"net/mail"
+)
+
+// SetEmail sets the address a user's notifications are emailed to. An
+// empty address removes it.
+func (a *AuthStore) SetEmail(username, email string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Address != email {
+			return fmt.Errorf("invalid email address: %s", email)
+		}
+	}
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+	user.Email = email
+	return a.saveUser(user)
+}
+
+// SetEmailOptOut stops or resumes email notifications for a user.
+func (a *AuthStore) SetEmailOptOut(username string, optOut bool) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+	user.EmailOptOut = optOut
+	return a.saveUser(user)
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Registration modes: whether anyone may create an account, only holders
+// of an invite code, or nobody but admins.
+const (
+	RegistrationClosed = "closed"
+	RegistrationInvite = "invite"
+	RegistrationOpen   = "open"
+)
+
+// ErrInviteDenied is returned for a wrong, used or expired invite code.
+var ErrInviteDenied = errors.New("invalid or expired invite code")
+
+// InviteCode lets one person register an account. Only its hash is kept.
+type InviteCode struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (a *AuthStore) invitesPath() string {
+	return filepath.Join(a.basePath, "invites.json")
+}
+
+// CreateInviteCode issues a single-use invite code valid for ttl. The code
+// is returned once.
+func (a *AuthStore) CreateInviteCode(createdBy, note string, ttl time.Duration) (InviteCode, string, error) {
+	a.inviteMu.Lock()
+	defer a.inviteMu.Unlock()
+
+	invites, err := a.readInvites()
+	if err != nil {
+		return InviteCode{}, "", err
+	}
+
+	code, hash, err := newRecoveryCode()
+	if err != nil {
+		return InviteCode{}, "", err
+	}
+	now := time.Now()
+	invite := InviteCode{
+		ID:        hash[:12],
+		Hash:      hash,
+		Note:      note,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	if err := a.writeInvites(append(invites, invite)); err != nil {
+		return InviteCode{}, "", err
+	}
+	return invite, code, nil
+}
+
+// ListInviteCodes returns the unused, unexpired invite codes.
+func (a *AuthStore) ListInviteCodes() ([]InviteCode, error) {
+	a.inviteMu.Lock()
+	defer a.inviteMu.Unlock()
+	return a.readInvites()
+}
+
+// RevokeInviteCode withdraws an unused invite code by its ID.
+func (a *AuthStore) RevokeInviteCode(id string) error {
+	a.inviteMu.Lock()
+	defer a.inviteMu.Unlock()
+
+	invites, err := a.readInvites()
+	if err != nil {
+		return err
+	}
+
+	kept := []InviteCode{}
+	for _, inv := range invites {
+		if inv.ID != id {
+			kept = append(kept, inv)
+		}
+	}
+	if len(kept) == len(invites) {
+		return fmt.Errorf("invite not found: %s", id)
+	}
+	return a.writeInvites(kept)
+}
+
+// Register creates an account for username, using up invite if one is
+// required. It returns the ID of the invite used, if any.
+func (a *AuthStore) Register(username, invite string, requireInvite bool) (string, error) {
+	a.inviteMu.Lock()
+	defer a.inviteMu.Unlock()
+
+	if _, err := a.GetUser(username); err == nil {
+		return "", fmt.Errorf("user already exists: %s", username)
+	}
+
+	var used string
+	if requireInvite {
+		invites, err := a.readInvites()
+		if err != nil {
+			return "", err
+		}
+
+		hash := []byte(hashCode(invite))
+		kept := []InviteCode{}
+		for _, inv := range invites {
+			if used == "" && subtle.ConstantTimeCompare([]byte(inv.Hash), hash) == 1 {
+				used = inv.ID
+				continue
+			}
+			kept = append(kept, inv)
+		}
+		if used == "" {
+			return "", ErrInviteDenied
+		}
+		if err := a.writeInvites(kept); err != nil {
+			return "", err
+		}
+	}
+
+	if err := a.CreateUser(username); err != nil {
+		return "", err
+	}
+	return used, nil
+}
+
+// readInvites returns the stored invite codes, leaving out expired ones.
+func (a *AuthStore) readInvites() ([]InviteCode, error) {
+	data, err := os.ReadFile(a.invitesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []InviteCode{}, nil
+		}
+		return nil, fmt.Errorf("read invites: %w", err)
+	}
+
+	var invites []InviteCode
+	if err := json.Unmarshal(data, &invites); err != nil {
+		return nil, fmt.Errorf("unmarshal invites: %w", err)
+	}
+
+	now := time.Now()
+	live := []InviteCode{}
+	for _, inv := range invites {
+		if now.Before(inv.ExpiresAt) {
+			live = append(live, inv)
+		}
+	}
+	return live, nil
+}
+
+func (a *AuthStore) writeInvites(invites []InviteCode) error {
+	data, err := json.MarshalIndent(invites, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal invites: %w", err)
+	}
+	if err := writeFile(a.invitesPath(), data); err != nil {
+		return fmt.Errorf("write invites: %w", err)
+	}
+	return nil
+}
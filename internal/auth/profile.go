@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// A user's Avatar is one of these, or empty for none.
+const (
+	AvatarUpload   = "upload"
+	AvatarGravatar = "gravatar"
+)
+
+const (
+	// MaxAvatarBytes is the largest avatar image that can be uploaded.
+	MaxAvatarBytes = 1 << 20
+
+	maxDisplayName = 100
+	maxBio         = 1000
+)
+
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+func (a *AuthStore) avatarPath(username string) string {
+	return filepath.Join(a.basePath, "avatars", username)
+}
+
+// UpdateProfile sets whichever of a user's display name, bio and avatar
+// are given. The avatar can be switched to AvatarGravatar or removed; an
+// uploaded one is set with SetAvatarImage.
+func (a *AuthStore) UpdateProfile(username string, displayName, bio, avatar *string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	if displayName != nil && utf8.RuneCountInString(*displayName) > maxDisplayName {
+		return fmt.Errorf("display name is longer than %d characters", maxDisplayName)
+	}
+	if bio != nil && utf8.RuneCountInString(*bio) > maxBio {
+		return fmt.Errorf("bio is longer than %d characters", maxBio)
+	}
+	if avatar != nil && *avatar != "" && *avatar != AvatarGravatar {
+		return fmt.Errorf("avatar must be %q or empty", AvatarGravatar)
+	}
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if displayName != nil {
+		user.DisplayName = *displayName
+	}
+	if bio != nil {
+		user.Bio = *bio
+	}
+	if avatar != nil {
+		if err := os.Remove(a.avatarPath(username)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("delete avatar: %w", err)
+		}
+		user.Avatar = *avatar
+	}
+	return a.saveUser(user)
+}
+
+// SetAvatarImage stores an uploaded PNG, JPEG, GIF or WebP image as the
+// user's avatar.
+func (a *AuthStore) SetAvatarImage(username string, data []byte) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	if len(data) > MaxAvatarBytes {
+		return fmt.Errorf("avatar is larger than %d bytes", MaxAvatarBytes)
+	}
+	if ct := http.DetectContentType(data); !avatarTypes[ct] {
+		return fmt.Errorf("avatar must be a PNG, JPEG, GIF or WebP image, not %s", ct)
+	}
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+
+	path := a.avatarPath(username)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create avatars dir: %w", err)
+	}
+	if err := writeFile(path, data); err != nil {
+		return fmt.Errorf("write avatar: %w", err)
+	}
+
+	user.Avatar = AvatarUpload
+	return a.saveUser(user)
+}
+
+// AvatarImage returns a user's uploaded avatar.
+func (a *AuthStore) AvatarImage(username string) ([]byte, error) {
+	data, err := os.ReadFile(a.avatarPath(username))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no avatar uploaded for %s", username)
+		}
+		return nil, fmt.Errorf("read avatar: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RecoveryCodeCount is how many recovery codes an account is given.
+const RecoveryCodeCount = 8
+
+const (
+	RecoverWithCode   = "recovery-code"
+	RecoverWithInvite = "invite"
+)
+
+// ErrRecoveryDenied is returned for a wrong, used or expired code. It
+// deliberately doesn't say which.
+var ErrRecoveryDenied = errors.New("invalid or expired recovery code")
+
+// Invite is a one-time code an admin issues after resetting an account, so
+// its owner can regain access.
+type Invite struct {
+	Hash      string    `json:"hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var codeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns a code such as "k3f9a-p2xqe-8hm4b", and the hash
+// it is stored as.
+func newRecoveryCode() (string, string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generate recovery code: %w", err)
+	}
+	s := strings.ToLower(codeEncoding.EncodeToString(b))
+	code := s[0:5] + "-" + s[5:10] + "-" + s[10:15]
+	return code, hashCode(code), nil
+}
+
+// hashCode normalises a code as a user might type it and hashes it.
+func hashCode(code string) string {
+	return HashSecret(strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code)))
+}
+
+// GenerateRecoveryCodes gives a user a fresh set of single-use recovery
+// codes, replacing any they had. The codes are returned once; only their
+// hashes are kept.
+func (a *AuthStore) GenerateRecoveryCodes(username string) ([]string, error) {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		codes[i], hashes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	user.RecoveryCodes = hashes
+
+	if err := a.saveUser(user); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ResetAccess revokes all of a user's SSH keys, API tokens and recovery
+// codes, and issues an invite valid for ttl with which they can sign back
+// in. The invite is returned once; only its hash is kept.
+func (a *AuthStore) ResetAccess(username string, ttl time.Duration) (string, error) {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate invite: %w", err)
+	}
+	code := hex.EncodeToString(b)
+
+	user.SSHKeys = []SSHKey{}
+	user.APITokens = []APIToken{}
+	user.RecoveryCodes = nil
+	user.Invite = &Invite{Hash: hashCode(code), ExpiresAt: time.Now().Add(ttl)}
+
+	if err := a.saveUser(user); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// CheckInvite reports whether code is the user's current, unexpired invite,
+// without using it up.
+func (a *AuthStore) CheckInvite(username, code string) (time.Time, error) {
+	user, err := a.GetUser(username)
+	if err != nil || user.Invite == nil || time.Now().After(user.Invite.ExpiresAt) {
+		return time.Time{}, ErrRecoveryDenied
+	}
+	if subtle.ConstantTimeCompare([]byte(user.Invite.Hash), []byte(hashCode(code))) != 1 {
+		return time.Time{}, ErrRecoveryDenied
+	}
+	return user.Invite.ExpiresAt, nil
+}
+
+// Recover uses up code, either one of the user's recovery codes or their
+// invite, and returns which it was. With revoke, the user's existing SSH
+// keys and API tokens are dropped too, for a lost or stolen device.
+func (a *AuthStore) Recover(username, code string, revoke bool) (string, error) {
+	a.recoverMu.Lock()
+	defer a.recoverMu.Unlock()
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return "", ErrRecoveryDenied
+	}
+	hash := []byte(hashCode(code))
+
+	method := ""
+	if user.Invite != nil && time.Now().Before(user.Invite.ExpiresAt) &&
+		subtle.ConstantTimeCompare([]byte(user.Invite.Hash), hash) == 1 {
+		method = RecoverWithInvite
+		user.Invite = nil
+	} else {
+		for i, h := range user.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(h), hash) == 1 {
+				method = RecoverWithCode
+				user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+				break
+			}
+		}
+	}
+	if method == "" {
+		return "", ErrRecoveryDenied
+	}
+
+	if revoke {
+		user.SSHKeys = []SSHKey{}
+		user.APITokens = []APIToken{}
+	}
+	if err := a.saveUser(user); err != nil {
+		return "", err
+	}
+	return method, nil
+}
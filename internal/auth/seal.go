@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Cipher encrypts user records at rest. The additional data passed to Seal
+// must be given to Open unchanged; the store uses the username, so a record
+// can't be moved onto another account.
+type Cipher interface {
+	Seal(plaintext, additionalData []byte) ([]byte, error)
+	Open(ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// sealedMagic starts every encrypted user file. Plain files are JSON, so
+// the two can't be confused.
+var sealedMagic = []byte("openhub-sealed-v1\n")
+
+// sealedMarker is present in the users directory while its records are
+// encrypted, so a process without the master key refuses to write plaintext
+// alongside them.
+const sealedMarker = ".sealed"
+
+// MasterKeySize is the length of a master key in bytes.
+const MasterKeySize = 32
+
+type aeadCipher struct {
+	aead cipher.AEAD
+}
+
+// NewAEAD returns a Cipher using AES-256-GCM under key, with a random nonce
+// per record.
+func NewAEAD(key []byte) (Cipher, error) {
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", MasterKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+	return &aeadCipher{aead: aead}, nil
+}
+
+func (c *aeadCipher) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *aeadCipher) Open(ciphertext, additionalData []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	plaintext, err := c.aead.Open(nil, ciphertext[:n], ciphertext[n:], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (wrong master key?)")
+	}
+	return plaintext, nil
+}
+
+// GenerateMasterKey writes a new random master key to path, hex encoded and
+// readable only by its owner. An existing file is never overwritten.
+func GenerateMasterKey(path string) error {
+	key := make([]byte, MasterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("generate master key: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("create master key: %w", err)
+	}
+	if _, err := f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("write master key: %w", err)
+	}
+	return f.Close()
+}
+
+// LoadMasterKey reads a key written by GenerateMasterKey and returns a
+// Cipher using it.
+func LoadMasterKey(path string) (Cipher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read master key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode master key: %w", err)
+	}
+	return NewAEAD(key)
+}
+
+// SetCipher encrypts user records written from now on with c, and lets
+// records already encrypted with it be read. Plain records stay readable
+// until they are next written or the store is rekeyed.
+func (a *AuthStore) SetCipher(c Cipher) {
+	a.cipher = c
+}
+
+// Encrypted reports whether the store's records have been encrypted, and so
+// need a master key to read.
+func (a *AuthStore) Encrypted() bool {
+	_, err := os.Stat(filepath.Join(a.basePath, "users", sealedMarker))
+	return err == nil
+}
+
+// decode turns a user file's contents into JSON, decrypting it with c if it
+// is sealed.
+func decode(c Cipher, username string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, sealedMagic) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf("user %s is encrypted and no master key is configured", username)
+	}
+	return c.Open(data[len(sealedMagic):], []byte(username))
+}
+
+// encode is the inverse of decode; a nil c leaves data in plaintext.
+func encode(c Cipher, username string, data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	sealed, err := c.Seal(data, []byte(username))
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), sealedMagic...), sealed...), nil
+}
+
+// writeFile replaces path atomically, so an interrupted rekey never leaves
+// a user record half written.
+func writeFile(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Rekey rewrites every user record under next, which may be nil to store
+// them in plaintext again, and then uses next from here on. Records that
+// already open under next are left alone, so an interrupted rekey can be run
+// again with the same keys. It returns how many records were rewritten.
+//
+// Other processes holding the old key will fail to read rekeyed records;
+// stop the server first.
+func (a *AuthStore) Rekey(next Cipher) (int, error) {
+	dir := filepath.Join(a.basePath, "users")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("read users dir: %w", err)
+	}
+
+	// Mark the directory sealed before the first encrypted record appears,
+	// and only unmark it once none are left.
+	if next != nil {
+		if err := os.WriteFile(filepath.Join(dir, sealedMarker), nil, 0600); err != nil {
+			return 0, fmt.Errorf("mark users sealed: %w", err)
+		}
+	}
+
+	rewritten := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		username := strings.TrimSuffix(name, ".json")
+		path := filepath.Join(dir, name)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return rewritten, fmt.Errorf("read user %s: %w", username, err)
+		}
+
+		plain, err := decode(a.cipher, username, data)
+		if err != nil {
+			if bytes.HasPrefix(data, sealedMagic) && next != nil {
+				if _, nextErr := decode(next, username, data); nextErr == nil {
+					continue
+				}
+			}
+			return rewritten, fmt.Errorf("user %s: %w", username, err)
+		}
+		if next == nil && !bytes.HasPrefix(data, sealedMagic) {
+			continue
+		}
+
+		out, err := encode(next, username, plain)
+		if err != nil {
+			return rewritten, fmt.Errorf("encrypt user %s: %w", username, err)
+		}
+		if err := writeFile(path, out); err != nil {
+			return rewritten, fmt.Errorf("write user %s: %w", username, err)
+		}
+		rewritten++
+	}
+
+	if next == nil {
+		if err := os.Remove(filepath.Join(dir, sealedMarker)); err != nil && !os.IsNotExist(err) {
+			return rewritten, fmt.Errorf("unmark users sealed: %w", err)
+		}
+	}
+
+	a.cipher = next
+	return rewritten, nil
+}
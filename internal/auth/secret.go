@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// HashSecret hashes a random secret, such as an API token or invitation
+// key, for storing in its place. Secrets are random, so an unsalted hash is
+// enough to keep them out of the record.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// SecretMatches reports whether secret is the one hash was made from,
+// taking the same time whichever byte differs.
+func SecretMatches(hash, secret string) bool {
+	if hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(HashSecret(secret))) == 1
+}
+
+// EqualSecrets compares two secrets held in plain text, taking the same
+// time whichever byte differs.
+func EqualSecrets(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// hashLegacyTokens replaces the plain text tokens of a record written
+// before tokens were hashed with their hashes, reporting whether there
+// were any.
+func hashLegacyTokens(user *User) bool {
+	changed := false
+	for i, t := range user.APITokens {
+		if t.Token != "" {
+			user.APITokens[i].Hash = HashSecret(t.Token)
+			user.APITokens[i].Token = ""
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rehashTokens saves username's record with its plain text tokens hashed.
+// It reads the record again under usersMu, so a change made since the
+// caller read it isn't undone.
+func (a *AuthStore) rehashTokens(username string) {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	user, err := a.GetUser(username)
+	if err != nil || !user.legacyTokens {
+		return
+	}
+	a.saveUser(user)
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+)
+
+// SigningKey is a public key a user signs commits and tags with: an SSH key
+// or an ASCII-armored OpenPGP key.
+type SigningKey struct {
+	Name string `json:"name"`
+	// Kind is "ssh" or "gpg".
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+// AddSigningKey adds a signing key to the user. The key is expected to have
+// been checked already; names must be unique per user.
+func (a *AuthStore) AddSigningKey(username string, key SigningKey) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range user.SigningKeys {
+		if k.Name == key.Name {
+			return fmt.Errorf("signing key already exists: %s", key.Name)
+		}
+	}
+	key.AddedAt = time.Now()
+	user.SigningKeys = append(user.SigningKeys, key)
+
+	return a.saveUser(user)
+}
+
+// RemoveSigningKey removes the user's signing key called name.
+func (a *AuthStore) RemoveSigningKey(username, name string) error {
+	a.usersMu.Lock()
+	defer a.usersMu.Unlock()
+
+	user, err := a.GetUser(username)
+	if err != nil {
+		return err
+	}
+
+	keys := []SigningKey{}
+	for _, k := range user.SigningKeys {
+		if k.Name != name {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == len(user.SigningKeys) {
+		return fmt.Errorf("signing key not found: %s", name)
+	}
+	user.SigningKeys = keys
+
+	return a.saveUser(user)
+}
@@ -0,0 +1,364 @@
+// Package backup archives a whole instance, for disaster recovery and for
+// moving an instance to another host. An archive is a gzipped tar holding a
+// manifest, every repository as a git bundle beside the openhub data kept
+// in its directory, and the rest of the storage directory as it is: users,
+// the instance identity and keys, peers, quotas and the other stores.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// Version is the archive format Write produces and Restore accepts.
+const Version = 1
+
+const manifestName = "manifest.json"
+
+// Manifest describes an archive. It is the archive's first entry.
+type Manifest struct {
+	Version    int       `json:"version"`
+	InstanceID string    `json:"instance_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Repos      []Repo    `json:"repos"`
+}
+
+// Repo is an archived repository.
+type Repo struct {
+	Owner string `json:"owner"`
+	Name  string `json:"name"`
+	// Empty repositories have no refs, and so no bundle.
+	Empty bool `json:"empty,omitempty"`
+}
+
+// skipped are the top-level storage entries left out of an archive: caches
+// and unfinished uploads, and the hooks the server installs on start.
+var skipped = map[string]bool{".cache": true, ".uploads": true, ".bundle-uploads": true, ".hooks": true}
+
+// gitData are the entries of a repository directory its bundle stands in
+// for. Everything else there, from HEAD and config to openhub.json, issues
+// and release assets, is archived as is.
+var gitData = map[string]bool{
+	"objects": true, "refs": true, "packed-refs": true, "logs": true,
+	"hooks": true, "FETCH_HEAD": true, "ORIG_HEAD": true, "shallow": true,
+}
+
+// Write archives the instance in storagePath to w, calling progress, if
+// set, as each repository is archived. A repository pushed to while it runs
+// is archived as it was when its bundle was made.
+func Write(w io.Writer, store *storage.Storage, storagePath string, progress func(Repo)) (Manifest, error) {
+	manifest := Manifest{Version: Version, CreatedAt: time.Now().UTC()}
+
+	data, err := os.ReadFile(filepath.Join(storagePath, "instance.json"))
+	if err != nil {
+		return manifest, fmt.Errorf("read instance: %w", err)
+	}
+	var inst instance.Instance
+	if err := json.Unmarshal(data, &inst); err != nil {
+		return manifest, fmt.Errorf("unmarshal instance: %w", err)
+	}
+	manifest.InstanceID = inst.ID
+
+	repos, err := store.ListRepos()
+	if err != nil {
+		return manifest, err
+	}
+	owners := map[string]bool{}
+	for _, r := range repos {
+		refs, err := store.ListRefs(r.Owner, r.Name)
+		if err != nil {
+			return manifest, fmt.Errorf("%s/%s: %w", r.Owner, r.Name, err)
+		}
+		manifest.Repos = append(manifest.Repos, Repo{Owner: r.Owner, Name: r.Name, Empty: len(refs) == 0})
+		owners[r.Owner] = true
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, fmt.Errorf("marshal manifest: %w", err)
+	}
+	err = tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0600, Size: int64(len(data)), ModTime: manifest.CreatedAt})
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+	if err != nil {
+		return manifest, fmt.Errorf("write manifest: %w", err)
+	}
+
+	entries, err := os.ReadDir(storagePath)
+	if err != nil {
+		return manifest, fmt.Errorf("read storage dir: %w", err)
+	}
+	for _, entry := range entries {
+		if skipped[entry.Name()] || owners[entry.Name()] && entry.IsDir() {
+			continue
+		}
+		if err := addTree(tw, filepath.Join(storagePath, entry.Name()), "instance/"+entry.Name(), nil); err != nil {
+			return manifest, err
+		}
+	}
+
+	for _, r := range manifest.Repos {
+		dir := store.RepoPath(r.Owner, r.Name)
+		prefix := "repos/" + r.Owner + "/" + r.Name
+		if err := addTree(tw, dir, prefix+".git", gitData); err != nil {
+			return manifest, err
+		}
+		if !r.Empty {
+			if err := addBundle(tw, store, r, prefix+".bundle"); err != nil {
+				return manifest, err
+			}
+		}
+		if progress != nil {
+			progress(r)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return manifest, fmt.Errorf("write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return manifest, fmt.Errorf("write archive: %w", err)
+	}
+	return manifest, nil
+}
+
+// addTree archives the file or directory at src as name, leaving out the
+// top-level entries of a directory in skip.
+func addTree(tw *tar.Writer, src, name string, skip map[string]bool) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("archive %s: %w", p, err)
+		}
+		rel, _ := filepath.Rel(src, p)
+		if skip[rel] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("archive %s: %w", p, err)
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("archive %s: %w", p, err)
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("archive %s: %w", p, err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("archive %s: %w", p, err)
+		}
+		defer f.Close()
+		// A file growing while it's archived, such as the audit log, is
+		// cut off at the size it had when the header was written.
+		if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+			return fmt.Errorf("archive %s: %w", p, err)
+		}
+		return nil
+	})
+}
+
+// addBundle archives a bundle of every ref in r as name. The bundle goes
+// through a temporary file, as a tar header needs its size up front.
+func addBundle(tw *tar.Writer, store *storage.Storage, r Repo, name string) error {
+	tmp, err := os.CreateTemp("", "openhub-backup-*.bundle")
+	if err != nil {
+		return fmt.Errorf("create temp bundle: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := store.WriteBundle(r.Owner, r.Name, tmp); err != nil {
+		return fmt.Errorf("%s/%s: %w", r.Owner, r.Name, err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("%s/%s: %w", r.Owner, r.Name, err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("%s/%s: %w", r.Owner, r.Name, err)
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now()}); err != nil {
+		return fmt.Errorf("%s/%s: %w", r.Owner, r.Name, err)
+	}
+	if _, err := io.Copy(tw, tmp); err != nil {
+		return fmt.Errorf("%s/%s: %w", r.Owner, r.Name, err)
+	}
+	return nil
+}
+
+// Restore recreates the instance archived in r in storagePath, which must
+// not hold an instance already, calling progress, if set, as each
+// repository is restored. The archive is unpacked next to storagePath's
+// contents first, so a damaged archive is found before anything is put in
+// place.
+func Restore(r io.Reader, storagePath string, progress func(Repo)) (Manifest, error) {
+	var manifest Manifest
+	if _, err := os.Stat(filepath.Join(storagePath, "instance.json")); err == nil {
+		return manifest, fmt.Errorf("%s already holds an instance", storagePath)
+	}
+	store, err := storage.New(storagePath)
+	if err != nil {
+		return manifest, err
+	}
+
+	staging, err := os.MkdirTemp(storagePath, ".restore-")
+	if err != nil {
+		return manifest, fmt.Errorf("create staging dir: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if manifest, err = unpack(r, staging); err != nil {
+		return manifest, err
+	}
+
+	instanceDir := filepath.Join(staging, "instance")
+	entries, err := os.ReadDir(instanceDir)
+	if err != nil {
+		return manifest, fmt.Errorf("archive holds no instance: %w", err)
+	}
+	for _, entry := range entries {
+		dst := filepath.Join(storagePath, entry.Name())
+		if _, err := os.Lstat(dst); err == nil {
+			return manifest, fmt.Errorf("%s already exists", dst)
+		}
+		if err := os.Rename(filepath.Join(instanceDir, entry.Name()), dst); err != nil {
+			return manifest, fmt.Errorf("restore %s: %w", entry.Name(), err)
+		}
+	}
+
+	for _, repo := range manifest.Repos {
+		if err := restoreRepo(store, staging, repo); err != nil {
+			return manifest, fmt.Errorf("%s/%s: %w", repo.Owner, repo.Name, err)
+		}
+		if progress != nil {
+			progress(repo)
+		}
+	}
+	return manifest, nil
+}
+
+// unpack extracts the archive in r into dir and returns its manifest.
+func unpack(r io.Reader, dir string) (Manifest, error) {
+	var manifest Manifest
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, fmt.Errorf("read archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return manifest, errors.New("not an openhub backup: no manifest")
+	}
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("read manifest: %w", err)
+	}
+	if manifest.Version != Version {
+		return manifest, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("read archive: %w", err)
+		}
+
+		name := path.Clean(hdr.Name)
+		if !fs.ValidPath(name) || !strings.HasPrefix(name, "instance/") && !strings.HasPrefix(name, "repos/") {
+			return manifest, fmt.Errorf("archive entry %q is outside the instance", hdr.Name)
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, mode|0700); err != nil {
+				return manifest, fmt.Errorf("unpack %s: %w", name, err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return manifest, fmt.Errorf("unpack %s: %w", name, err)
+			}
+			f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+			if err != nil {
+				return manifest, fmt.Errorf("unpack %s: %w", name, err)
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return manifest, fmt.Errorf("unpack %s: %w", name, err)
+			}
+		default:
+			return manifest, fmt.Errorf("archive entry %q is not a file or directory", hdr.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// restoreRepo moves a repository's unpacked directory into place, fills in
+// the git data git init creates and imports its bundle.
+func restoreRepo(store *storage.Storage, staging string, repo Repo) error {
+	prefix := filepath.Join(staging, "repos", repo.Owner, repo.Name)
+	dst := store.RepoPath(repo.Owner, repo.Name)
+	if store.RepoExists(repo.Owner, repo.Name) {
+		return errors.New("repository already exists")
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("create owner dir: %w", err)
+	}
+	if err := os.Rename(prefix+".git", dst); err != nil {
+		return fmt.Errorf("restore repository: %w", err)
+	}
+
+	// Reinitializing keeps the archived HEAD and config.
+	if out, err := exec.Command("git", "init", "--quiet", "--bare", dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("git init: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	if repo.Empty {
+		return nil
+	}
+	if _, err := store.ApplyBundle(repo.Owner, repo.Name, prefix+".bundle", nil, true); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package clientip
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseAllowList checks a list of IPs and CIDR ranges, such as a token's or
+// a repository's allowed addresses, and returns it in canonical form.
+func ParseAllowList(list []string) ([]string, error) {
+	var allowed []string
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR range %q", entry)
+		}
+		if prefix.IsSingleIP() {
+			allowed = append(allowed, prefix.Addr().String())
+		} else {
+			allowed = append(allowed, prefix.String())
+		}
+	}
+	return allowed, nil
+}
+
+// Allowed reports whether ip is in an allow list. An empty list allows
+// every address; an address that doesn't parse, such as a unix socket
+// peer's, is only allowed by an empty one.
+func Allowed(list []string, ip string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, entry := range list {
+		if prefix, err := parsePrefix(entry); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
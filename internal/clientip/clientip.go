@@ -0,0 +1,119 @@
+// Package clientip works out who a request came from, looking through the
+// X-Forwarded-For and X-Forwarded-Proto headers set by trusted reverse
+// proxies.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trusted are the proxies whose forwarding headers are believed. It is set
+// once at startup, before the servers start.
+var trusted []netip.Prefix
+
+// SetTrustedProxies trusts the forwarding headers of requests from the
+// given addresses, each an IP or a CIDR range.
+func SetTrustedProxies(proxies []string) error {
+	var prefixes []netip.Prefix
+	for _, p := range proxies {
+		prefix, err := parsePrefix(p)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q", p)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	trusted = prefixes
+	return nil
+}
+
+// parsePrefix parses an IP, as a range of one, or a CIDR range.
+func parsePrefix(p string) (netip.Prefix, error) {
+	if strings.Contains(p, "/") {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(p)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// FromRequest returns the IP of the client that made r. Behind trusted
+// proxies it is the nearest address in X-Forwarded-For that isn't one of
+// them, so a client can't choose its own IP by sending the header itself.
+func FromRequest(r *http.Request) string {
+	host := peer(r)
+	if !isTrusted(host) {
+		return host
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		host = hop
+		if !isTrusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// Scheme returns "https" if the client reached the server over TLS, either
+// directly or through a trusted proxy that says so in X-Forwarded-Proto,
+// and "http" otherwise.
+func Scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if isTrusted(peer(r)) {
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if strings.EqualFold(strings.TrimSpace(proto), "https") {
+			return "https"
+		}
+	}
+	return "http"
+}
+
+// peer returns the address of the other end of r's connection.
+func peer(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isTrusted reports whether ip is one of the trusted proxies. Requests
+// over a unix socket, which have no IP, come from a proxy on the same
+// host and are trusted whenever any proxy is.
+func isTrusted(ip string) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip == "" || ip == "@"
+	}
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CLI is the command-line client's configuration: the servers it talks to.
+type CLI struct {
+	// Default names the profile used when none is chosen.
+	Default  string
+	Profiles map[string]Profile
+}
+
+// Profile is a server the CLI talks to, and the API token it uses there.
+type Profile struct {
+	URL   string
+	Token string
+}
+
+// CLIPath is where the CLI's configuration is read from:
+// $XDG_CONFIG_HOME/openhub/config.toml, or ~/.config/openhub/config.toml.
+func CLIPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "openhub", "config.toml"), nil
+}
+
+// LoadCLI reads the CLI's configuration file, which has a table per
+// profile:
+//
+//	default = "work"
+//
+//	[profiles.work]
+//	url = "https://git.example.com"
+//	token = "..."
+//
+//	[profiles.local]
+//	url = "http://localhost:3000"
+//
+// A missing file is an empty configuration.
+func LoadCLI(path string) (CLI, error) {
+	cfg := CLI{Profiles: map[string]Profile{}}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("open config: %w", err)
+	}
+	defer f.Close()
+
+	profile := ""
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			table := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+			name, ok := strings.CutPrefix(table, "profiles.")
+			if !strings.HasSuffix(line, "]") || !ok || name == "" {
+				return cfg, fmt.Errorf("%s:%d: expected [profiles.<name>]", path, n)
+			}
+			name = strings.Trim(name, `"`)
+			if _, dup := cfg.Profiles[name]; dup {
+				return cfg, fmt.Errorf("%s:%d: profile %s is defined twice", path, n, name)
+			}
+			cfg.Profiles[name] = Profile{}
+			profile = name
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return cfg, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		if seen[profile+"."+key] {
+			return cfg, fmt.Errorf("%s:%d: %s is set twice", path, n, key)
+		}
+		seen[profile+"."+key] = true
+
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return cfg, fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+		}
+
+		if profile == "" {
+			if key != "default" {
+				return cfg, fmt.Errorf("%s:%d: unknown key %s", path, n, key)
+			}
+			cfg.Default = value
+			continue
+		}
+		p := cfg.Profiles[profile]
+		switch key {
+		case "url":
+			p.URL = strings.TrimSuffix(value, "/")
+		case "token":
+			p.Token = value
+		default:
+			return cfg, fmt.Errorf("%s:%d: unknown key %s", path, n, key)
+		}
+		cfg.Profiles[profile] = p
+	}
+	if err := scanner.Err(); err != nil {
+		return cfg, fmt.Errorf("read config: %w", err)
+	}
+
+	if cfg.Default != "" {
+		if _, ok := cfg.Profiles[cfg.Default]; !ok {
+			return cfg, fmt.Errorf("%s: default profile %s isn't defined", path, cfg.Default)
+		}
+	}
+	return cfg, nil
+}
@@ -1,10 +1,162 @@
 package config
 
+import "time"
+
 type Config struct {
 	StoragePath string
 	SSHPort     int
 	HTTPPort    int
 	HTTPSPort   int
+	ExternalURL string
+
+	// SSHBind and HTTPBind are the addresses the SSH and HTTP(S) servers
+	// listen on; empty means every interface. HTTPSocket, if set, is a
+	// unix socket HTTP is served on instead of HTTPPort.
+	SSHBind    string
+	HTTPBind   string
+	HTTPSocket string
+
+	// HTTP server timeouts. ReadHeaderTimeout and IdleTimeout stop clients
+	// holding connections open; ReadTimeout and WriteTimeout bound whole
+	// requests, long clones and pushes included, and are off when zero.
+	HTTPReadHeaderTimeout time.Duration
+	HTTPReadTimeout       time.Duration
+	HTTPWriteTimeout      time.Duration
+	HTTPIdleTimeout       time.Duration
+
+	// MaxBodyMB caps API request bodies and MaxBundleMB the bundles
+	// replicas accept from their origin. Zero removes a cap.
+	MaxBodyMB   int
+	MaxBundleMB int
+
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	// HTTPSRedirect answers plain HTTP requests with a redirect to HTTPS.
+	HTTPSRedirect bool
+	// GRPCPort, if set, serves the gRPC admin service there, to clients
+	// with a certificate verified against GRPCClientCAFile. It is kept
+	// apart from TLSClientCAFile so that an instance certificate can't be
+	// used to administer this one.
+	GRPCPort         int
+	GRPCClientCAFile string
+
+	// TrustedProxies are the IPs and CIDR ranges of reverse proxies whose
+	// X-Forwarded-For and X-Forwarded-Proto headers are believed.
+	TrustedProxies []string
+
+	FederationCAFile   string
+	FederationCertFile string
+	FederationKeyFile  string
+
+	TarpitEnabled bool
+
+	AnonRateLimit float64
+	AnonBurst     int
+	AuthRateLimit float64
+	AuthBurst     int
+
+	// AnonClones is how many clones and fetches each anonymous client IP
+	// may have in progress at once, and AnonCloneKBps how fast in KB/s
+	// they are sent; zero disables either limit.
+	AnonClones    int
+	AnonCloneKBps int
+
+	ArchiveCacheMB int
+	// InfoRefsCacheTTL is the longest public repositories' ref
+	// advertisements are served from cache; zero disables the cache.
+	InfoRefsCacheTTL time.Duration
+	// Compress gzips JSON API responses and ref advertisements for clients
+	// that accept it.
+	Compress bool
+
+	ReleaseAssetMaxMB int
+	ReleaseMaxMB      int
+
+	// ScanSecrets rejects pushes that add likely credentials, and
+	// MaxBlobMB ones that add files bigger than it; zero disables it.
+	// Repositories' push policies can override both.
+	ScanSecrets bool
+	MaxBlobMB   int
+
+	// StandbyURLs receive this instance's users and SSH keys; StandbyOf
+	// lists the instance IDs this instance accepts them from.
+	StandbyURLs []string
+	StandbyOf   []string
+
+	// OriginPolicy is which instances may register repositories to
+	// replicate here: "open", "allowlist" (only TrustedOrigins) or
+	// "approval" (others once an admin approves). TrustedOrigins holds
+	// instance IDs or public keys.
+	OriginPolicy   string
+	TrustedOrigins []string
+
+	ReplicaConcurrency int
+	ReplicaWorkers     int
+	ReplicaQueueDepth  int
+	ReplicaTimeout     time.Duration
+	// ReplicaConnectTimeout, ReplicaIdleConns and ReplicaIdleTimeout tune
+	// the pool of connections to replicas. ReplicaProxy, if set, is the
+	// proxy they go through instead of the one from the environment.
+	ReplicaConnectTimeout time.Duration
+	ReplicaIdleConns      int
+	ReplicaIdleTimeout    time.Duration
+	ReplicaProxy          string
+	ReplicaHTTP2          bool
+	// ReplicaKBps caps bundle uploads to all replicas together, and
+	// ReplicaPeerKBps each replica: a bare number for every replica, or
+	// "<replica-url>=<KB/s>" for one. Zero leaves a cap off.
+	ReplicaKBps     int
+	ReplicaPeerKBps []string
+	SyncInterval    time.Duration
+	// ReadThrough has replicas that are behind their origin pass clones
+	// and fetches on to it.
+	ReadThrough bool
+	// CloneRegions send clients in an IP range, "<cidr>=<url>", to the
+	// replica at url, and CloneRedirectBusy, if set, sends clients to
+	// replicas once that many clones are running here.
+	CloneRegions      []string
+	CloneRedirectBusy int
+
+	QuotaCheckInterval time.Duration
+
+	// UpstreamInterval is how often repositories mirrored from another
+	// forge are fetched; zero disables it.
+	UpstreamInterval time.Duration
+
+	// WebhookTimeout is how long a webhook receiver gets to answer.
+	WebhookTimeout time.Duration
+
+	// AdminUsers may use the token-authenticated admin endpoints.
+	AdminUsers []string
+	// LogLines is how many recent log lines are kept for the admin log
+	// stream.
+	LogLines int
+	// AuditRetention is how long audit log entries are kept. Zero keeps
+	// them forever.
+	AuditRetention time.Duration
+
+	// ActivityPub publishes repository events to fediverse followers.
+	ActivityPub bool
+
+	// MasterKeyFile holds the key user records are encrypted with at rest.
+	// Empty leaves them in plaintext.
+	MasterKeyFile string
+
+	// Registration is who may create their own account: "open", "invite"
+	// (with a code from an admin) or "closed".
+	Registration string
+
+	// SMTPAddr is the host:port notifications are emailed through. Empty
+	// disables email.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// ShutdownTimeout is how long in-flight requests, git commands and
+	// queued replication get to finish when the server is stopped.
+	ShutdownTimeout time.Duration
 }
 
 func Default() *Config {
@@ -13,5 +165,47 @@ func Default() *Config {
 		SSHPort:     2222,
 		HTTPPort:    3000,
 		HTTPSPort:   3443,
+
+		HTTPReadHeaderTimeout: 10 * time.Second,
+		HTTPIdleTimeout:       2 * time.Minute,
+
+		MaxBodyMB:   10,
+		MaxBundleMB: 10240,
+
+		AnonRateLimit: 30,
+		AnonBurst:     10,
+		AuthRateLimit: 300,
+		AuthBurst:     60,
+
+		AnonClones: 4,
+
+		ArchiveCacheMB:   512,
+		InfoRefsCacheTTL: 10 * time.Second,
+		Compress:         true,
+
+		Registration: "closed",
+		OriginPolicy: "open",
+
+		ReleaseAssetMaxMB: 2048,
+		ReleaseMaxMB:      10240,
+
+		ReplicaConcurrency: 4,
+		ReplicaWorkers:     3,
+		ReplicaQueueDepth:  100,
+		ReplicaTimeout:     30 * time.Second,
+		SyncInterval:       5 * time.Minute,
+
+		ReplicaConnectTimeout: 10 * time.Second,
+		ReplicaIdleConns:      4,
+		ReplicaIdleTimeout:    90 * time.Second,
+
+		QuotaCheckInterval: 10 * time.Minute,
+		UpstreamInterval:   time.Hour,
+
+		WebhookTimeout: 15 * time.Second,
+
+		LogLines: 1000,
+
+		ShutdownTimeout: 30 * time.Second,
 	}
 }
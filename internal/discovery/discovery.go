@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	apiService = "openhub"
+	sshService = "openhub-ssh"
+	txtPrefix  = "_openhub."
+	txtVersion = "v=openhub1"
+)
+
+type Endpoints struct {
+	Domain     string
+	APIURL     string
+	SSHHost    string
+	SSHPort    int
+	InstanceID string
+}
+
+// Resolve looks up _openhub._tcp (API), _openhub-ssh._tcp (SSH) and the
+// _openhub TXT record for domain. Only the API record is mandatory.
+func Resolve(domain string) (*Endpoints, error) {
+	domain = strings.TrimSuffix(domain, ".")
+
+	_, apiAddrs, err := net.LookupSRV(apiService, "tcp", domain)
+	if err != nil {
+		return nil, fmt.Errorf("lookup _%s._tcp.%s: %w", apiService, domain, err)
+	}
+	if len(apiAddrs) == 0 {
+		return nil, fmt.Errorf("no _%s._tcp records for %s", apiService, domain)
+	}
+
+	ep := &Endpoints{Domain: domain}
+	attrs := lookupAttributes(domain)
+	ep.InstanceID = attrs["id"]
+
+	api := apiAddrs[0]
+	apiHost := strings.TrimSuffix(api.Target, ".")
+	scheme := attrs["scheme"]
+	if scheme == "" {
+		scheme = "http"
+		if api.Port == 443 {
+			scheme = "https"
+		}
+	}
+	ep.APIURL = formatURL(scheme, apiHost, int(api.Port))
+
+	if _, sshAddrs, err := net.LookupSRV(sshService, "tcp", domain); err == nil && len(sshAddrs) > 0 {
+		ep.SSHHost = strings.TrimSuffix(sshAddrs[0].Target, ".")
+		ep.SSHPort = int(sshAddrs[0].Port)
+	}
+
+	return ep, nil
+}
+
+// ResolveURL accepts either a full URL or a bare domain name and returns the
+// instance API base URL.
+func ResolveURL(target string) (string, error) {
+	if strings.Contains(target, "://") {
+		return strings.TrimSuffix(target, "/"), nil
+	}
+
+	ep, err := Resolve(target)
+	if err != nil {
+		return "", err
+	}
+	return ep.APIURL, nil
+}
+
+// Records renders the zone file entries an operator should publish for domain.
+func Records(domain, apiURL string, sshPort int, instanceID string) ([]string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid API URL: %s", apiURL)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	host := u.Hostname()
+	records := []string{
+		fmt.Sprintf("_%s._tcp.%s. 3600 IN SRV 0 0 %s %s.", apiService, domain, port, host),
+	}
+	if sshPort > 0 {
+		records = append(records, fmt.Sprintf("_%s._tcp.%s. 3600 IN SRV 0 0 %d %s.", sshService, domain, sshPort, host))
+	}
+
+	txt := fmt.Sprintf("%s scheme=%s", txtVersion, u.Scheme)
+	if instanceID != "" {
+		txt += " id=" + instanceID
+	}
+	records = append(records, fmt.Sprintf("%s%s. 3600 IN TXT \"%s\"", txtPrefix, domain, txt))
+
+	return records, nil
+}
+
+func lookupAttributes(domain string) map[string]string {
+	attrs := make(map[string]string)
+
+	txts, err := net.LookupTXT(txtPrefix + domain)
+	if err != nil {
+		return attrs
+	}
+
+	for _, txt := range txts {
+		fields := strings.Fields(txt)
+		if len(fields) == 0 || fields[0] != txtVersion {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if k, v, ok := strings.Cut(f, "="); ok {
+				attrs[k] = v
+			}
+		}
+		break
+	}
+
+	return attrs
+}
+
+func formatURL(scheme, host string, port int) string {
+	if (scheme == "https" && port == 443) || (scheme == "http" && port == 80) {
+		return fmt.Sprintf("%s://%s", scheme, host)
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+}
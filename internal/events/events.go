@@ -0,0 +1,253 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KindRepoCreated = "repo.created"
+	// KindPush is published for every ref a push updates, creates or deletes.
+	KindPush = "push"
+	// KindReplicationCompleted is published for each replica a sync reached
+	// or failed to reach; Error says which.
+	KindReplicationCompleted = "replication.completed"
+	KindUserCreated          = "user.created"
+	KindIssueOpened          = "issue.opened"
+)
+
+// Kinds are the event kinds subscribers can filter on.
+var Kinds = []string{KindRepoCreated, KindPush, KindReplicationCompleted, KindUserCreated, KindIssueOpened}
+
+// Event is something that happened on the instance. Seq orders events and
+// is what a reconnecting subscriber passes back to resume.
+type Event struct {
+	Seq     uint64    `json:"seq"`
+	Kind    string    `json:"kind"`
+	Time    time.Time `json:"time"`
+	Owner   string    `json:"owner,omitempty"`
+	Repo    string    `json:"repo,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Ref     string    `json:"ref,omitempty"`
+	Old     string    `json:"old,omitempty"`
+	New     string    `json:"new,omitempty"`
+	Commits int       `json:"commits,omitempty"`
+	Replica string    `json:"replica,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	// Number and Title identify an issue.
+	Number int    `json:"number,omitempty"`
+	Title  string `json:"title,omitempty"`
+}
+
+// Filter selects events. Kinds lists the kinds wanted, Repo an "owner/name"
+// and Owner the owner of the repository or user the event is about. Empty
+// fields match everything.
+type Filter struct {
+	Kinds []string
+	Repo  string
+	Owner string
+}
+
+func (f Filter) Match(e Event) bool {
+	if len(f.Kinds) > 0 {
+		found := false
+		for _, k := range f.Kinds {
+			if k == e.Kind {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Repo != "" && f.Repo != e.Owner+"/"+e.Repo {
+		return false
+	}
+	if f.Owner != "" && f.Owner != e.Owner {
+		return false
+	}
+	return true
+}
+
+// Known reports whether kind is an event kind.
+func Known(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Bus keeps the most recent events and fans new ones out to subscribers.
+// Subscribers that fall behind miss events rather than block publishers.
+// Events from other processes, such as the push hook and the CLI, reach it
+// through a spool directory that Start drains.
+type Bus struct {
+	mu     sync.Mutex
+	dir    string
+	recent []Event
+	size   int
+	seq    uint64
+	subs   map[chan Event]Filter
+}
+
+// New returns a bus retaining the last size events, reading spooled events
+// from storagePath.
+func New(storagePath string, size int) *Bus {
+	return &Bus{
+		dir:  spoolDir(storagePath),
+		size: size,
+		subs: make(map[chan Event]Filter),
+	}
+}
+
+// Publish numbers e and delivers it to matching subscribers.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e.Seq = b.seq
+
+	b.recent = append(b.recent, e)
+	if len(b.recent) > b.size {
+		b.recent = b.recent[len(b.recent)-b.size:]
+	}
+
+	for ch, f := range b.subs {
+		if !f.Match(e) {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the retained events after seq matching f and a channel
+// of new ones; there are no gaps or repeats between the two. The returned
+// function ends the subscription and closes the channel.
+func (b *Bus) Subscribe(f Filter, after uint64) ([]Event, <-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []Event
+	for _, e := range b.recent {
+		if e.Seq > after && f.Match(e) {
+			backlog = append(backlog, e)
+		}
+	}
+
+	ch := make(chan Event, 256)
+	b.subs[ch] = f
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel
+}
+
+// Start publishes spooled events every interval.
+func (b *Bus) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			b.drain()
+		}
+	}()
+}
+
+func (b *Bus) drain() {
+	files, err := spooled(b.dir)
+	if err != nil {
+		log.Printf("events: list spool failed: %v", err)
+		return
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("events: read event failed: %v", err)
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			log.Printf("events: dropping malformed event %s: %v", filepath.Base(file), err)
+		} else {
+			b.Publish(e)
+		}
+		os.Remove(file)
+	}
+}
+
+func spoolDir(storagePath string) string {
+	return filepath.Join(storagePath, ".events")
+}
+
+// Enqueue records an event from outside the server process for the bus to
+// publish. Events spooled while no server is running are published when
+// one starts.
+func Enqueue(storagePath string, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	dir := spoolDir(storagePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("spool event: %w", err)
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("spool event: %w", err)
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), hex.EncodeToString(id))
+	tmp := filepath.Join(dir, "."+name)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("spool event: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("spool event: %w", err)
+	}
+	return nil
+}
+
+// spooled lists the complete files in the spool directory, oldest first.
+func spooled(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
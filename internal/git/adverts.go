@@ -0,0 +1,158 @@
+package git
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/metrics"
+)
+
+var (
+	advertHits   = metrics.NewCounter("openhub_info_refs_cache_hits_total", "Ref advertisements served from cache.")
+	advertMisses = metrics.NewCounter("openhub_info_refs_cache_misses_total", "Ref advertisements that had to be generated.")
+)
+
+// maxAdvertBytes bounds the memory cached advertisements take; the oldest
+// are dropped beyond it.
+const maxAdvertBytes = 64 << 20
+
+type advert struct {
+	data        []byte
+	fingerprint string
+	created     time.Time
+}
+
+// AdvertCache keeps the ref advertisements git-upload-pack sends at the
+// start of a clone over HTTP, so a burst of clones of a popular repository
+// runs git once rather than once per clone. An entry is used only while
+// the repository's refs look unchanged since it was made, and for at most
+// the TTL, which bounds how stale it can be should a change slip past that
+// check.
+type AdvertCache struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	entries  map[string]*advert
+	total    int
+	inflight map[string]chan struct{}
+}
+
+// NewAdvertCache returns a cache keeping advertisements for up to ttl.
+func NewAdvertCache(ttl time.Duration) *AdvertCache {
+	return &AdvertCache{
+		ttl:      ttl,
+		entries:  make(map[string]*advert),
+		inflight: make(map[string]chan struct{}),
+	}
+}
+
+// Get returns the upload-pack advertisement of the repository at repoPath,
+// generating it if there is none cached or the refs have moved. Concurrent
+// misses for one repository wait for a single git.
+func (c *AdvertCache) Get(owner, repo, repoPath string) ([]byte, error) {
+	key := owner + "/" + repo
+	for {
+		fingerprint := refsFingerprint(repoPath)
+
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok && e.fingerprint == fingerprint && time.Since(e.created) < c.ttl {
+			c.mu.Unlock()
+			advertHits.Inc()
+			return e.data, nil
+		}
+		if wait, ok := c.inflight[key]; ok {
+			c.mu.Unlock()
+			<-wait
+			continue
+		}
+		done := make(chan struct{})
+		c.inflight[key] = done
+		c.mu.Unlock()
+
+		advertMisses.Inc()
+		data, err := advertise(repoPath)
+
+		c.mu.Lock()
+		delete(c.inflight, key)
+		close(done)
+		if err == nil {
+			c.put(key, &advert{data: data, fingerprint: fingerprint, created: time.Now()})
+		}
+		c.mu.Unlock()
+		return data, err
+	}
+}
+
+// put stores e under key, dropping the oldest entries once they would take
+// more than maxAdvertBytes. c.mu must be held.
+func (c *AdvertCache) put(key string, e *advert) {
+	if len(e.data) > maxAdvertBytes {
+		return
+	}
+	c.drop(key)
+	for c.total+len(e.data) > maxAdvertBytes {
+		oldest := ""
+		for k, v := range c.entries {
+			if oldest == "" || v.created.Before(c.entries[oldest].created) {
+				oldest = k
+			}
+		}
+		c.drop(oldest)
+	}
+	c.entries[key] = e
+	c.total += len(e.data)
+}
+
+func (c *AdvertCache) drop(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.total -= len(e.data)
+		delete(c.entries, key)
+	}
+}
+
+// Invalidate drops the advertisement cached for owner/repo, after a push.
+func (c *AdvertCache) Invalidate(owner, repo string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drop(owner + "/" + repo)
+}
+
+func advertise(repoPath string) ([]byte, error) {
+	cmd := exec.Command("git-upload-pack", "--stateless-rpc", "--advertise-refs", repoPath)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git-upload-pack: %w", err)
+	}
+	return out, nil
+}
+
+// refsFingerprint summarises what the advertisement depends on, HEAD and
+// every ref, from file sizes and modification times alone. git replaces a
+// ref's file whenever it moves, so any update changes it.
+func refsFingerprint(repoPath string) string {
+	h := fnv.New64a()
+	add := func(path string, info fs.FileInfo) {
+		fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	for _, name := range []string{"HEAD", "packed-refs"} {
+		if info, err := os.Stat(filepath.Join(repoPath, name)); err == nil {
+			add(name, info)
+		}
+	}
+	filepath.WalkDir(filepath.Join(repoPath, "refs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			add(path, info)
+		}
+		return nil
+	})
+	return fmt.Sprintf("%x", h.Sum64())
+}
@@ -0,0 +1,84 @@
+package git_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeremytregunna/openhub/internal/gitbench"
+)
+
+// The benchmarks time what openhub bench does, over the same servers and
+// repositories, though without the pre-receive hook, which needs the
+// openhub binary. -short leaves out the large repository.
+
+func BenchmarkClone(b *testing.B) {
+	benchmark(b, func(b *testing.B, env *gitbench.Env, transport, name, dir string) {
+		if err := env.Clone(transport, name, dir); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkPush(b *testing.B) {
+	benchmark(b, func(b *testing.B, env *gitbench.Env, transport, name, dir string) {
+		b.StopTimer()
+		target, err := env.PreparePush(name, dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		if err := env.Push(transport, dir, target); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		env.Remove(target)
+		b.StartTimer()
+	})
+}
+
+// benchmark runs op once per iteration for each transport and repository
+// size, with a new directory to work in each time.
+func benchmark(b *testing.B, op func(b *testing.B, env *gitbench.Env, transport, name, dir string)) {
+	for _, tool := range []string{"git", "ssh", "ssh-keygen"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			b.Skipf("%s not installed", tool)
+		}
+	}
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	env, err := gitbench.NewEnv(b.TempDir(), "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(env.Close)
+
+	for i, size := range gitbench.Sizes {
+		if testing.Short() && size.Name == "large" {
+			continue
+		}
+		name := "size-" + size.Name
+		bytes, err := env.Seed(name, size.Commits, gitbench.Files, rand.New(rand.NewSource(int64(i+1))))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for _, transport := range gitbench.Transports {
+			b.Run(transport+"/"+size.Name, func(b *testing.B) {
+				b.SetBytes(bytes)
+				work := b.TempDir()
+				for n := 0; n < b.N; n++ {
+					op(b, env, transport, name, filepath.Join(work, fmt.Sprint(n)))
+				}
+			})
+		}
+	}
+}
@@ -2,29 +2,63 @@ package git
 
 import (
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
 	"path"
 	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/archive"
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/storage"
+	"github.com/jeremytregunna/openhub/internal/tarpit"
 )
 
 type TokenValidator interface {
-	ValidateAPIToken(token string) (string, error)
+	ValidateAPIToken(token, ip string) (string, error)
+}
+
+type ArchiveCache interface {
+	Get(repoPath, owner, repo, ref, commit, format, prefix string) (*os.File, error)
+	Invalidate(owner, repo string)
+}
+
+type AuditLog interface {
+	Record(e audit.Entry) error
+}
+
+// CloneLimiter throttles anonymous clones and fetches by client IP.
+type CloneLimiter interface {
+	Acquire(ip string) (func(), bool)
+	Writer(ip string, w io.Writer) io.Writer
 }
 
 type HTTPServer struct {
 	storage   RepoStorage
 	validator TokenValidator
+	archives  ArchiveCache
+	hooks     HookEnv
+	auditLog  AuditLog
+	clones    CloneLimiter
+	adverts   *AdvertCache
 	mux       *http.ServeMux
+
+	readThrough *readThrough
+	redirect    *cloneRedirect
 }
 
-func NewHTTPServer(storage RepoStorage, validator TokenValidator) *HTTPServer {
+func NewHTTPServer(storage RepoStorage, validator TokenValidator, archives ArchiveCache, hooks HookEnv) *HTTPServer {
 	s := &HTTPServer{
 		storage:   storage,
 		validator: validator,
+		archives:  archives,
+		hooks:     hooks,
 		mux:       http.NewServeMux(),
 	}
 
@@ -33,6 +67,23 @@ func NewHTTPServer(storage RepoStorage, validator TokenValidator) *HTTPServer {
 	return s
 }
 
+// SetAudit records requests with bad credentials in log.
+func (s *HTTPServer) SetAudit(log AuditLog) {
+	s.auditLog = log
+}
+
+// SetAnonymousClones limits the clones and fetches of public repositories
+// made without credentials with limiter.
+func (s *HTTPServer) SetAnonymousClones(limiter CloneLimiter) {
+	s.clones = limiter
+}
+
+// SetAdvertCache serves the ref advertisements of public repositories from
+// cache.
+func (s *HTTPServer) SetAdvertCache(cache *AdvertCache) {
+	s.adverts = cache
+}
+
 func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
@@ -53,6 +104,16 @@ func (s *HTTPServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if strings.Contains(r.URL.Path, "/releases/download/") {
+		s.handleReleaseDownload(w, r)
+		return
+	}
+
+	if strings.Contains(r.URL.Path, "/archive/") {
+		s.handleArchive(w, r)
+		return
+	}
+
 	http.NotFound(w, r)
 }
 
@@ -62,18 +123,88 @@ func (s *HTTPServer) getAuthenticatedUser(r *http.Request) string {
 		return ""
 	}
 
-	validatedUser, err := s.validator.ValidateAPIToken(password)
-	if err != nil {
+	validatedUser, err := s.validator.ValidateAPIToken(password, clientip.FromRequest(r))
+	if errors.Is(err, auth.ErrAddressNotAllowed) {
+		s.auditFailure(r, username, "git HTTP token used from an address it isn't allowed from")
 		return ""
 	}
-
-	if validatedUser != username {
+	if err != nil || validatedUser != username {
+		s.auditFailure(r, username, "invalid git HTTP credentials")
 		return ""
 	}
 
 	return username
 }
 
+// authorize checks that the request's user may read owner/repo, or push to
+// it if write is set, answering the request itself if not. A private
+// repository the user can't read gets the same answer as one that doesn't
+// exist, so private repository names can't be discovered by probing:
+// anonymous clients are asked for credentials, which git needs before it
+// sends any, and signed-in users are told it wasn't found. A repository
+// with an address allow list that doesn't include the client's is treated
+// the same way.
+func (s *HTTPServer) authorize(w http.ResponseWriter, r *http.Request, owner, repo string, write bool) (string, bool) {
+	username := s.getAuthenticatedUser(r)
+
+	exists := s.storage.RepoExists(owner, repo)
+	var meta storage.Metadata
+	if exists {
+		var err error
+		meta, err = s.storage.GetMetadata(owner, repo)
+		if err != nil {
+			http.Error(w, "error getting metadata", http.StatusInternalServerError)
+			return "", false
+		}
+	}
+
+	if !exists || (meta.Private && username != owner) || !clientip.Allowed(meta.AllowedIPs, clientip.FromRequest(r)) {
+		if !exists {
+			tarpit.Miss(r)
+		}
+		if username == "" {
+			w.Header().Set("WWW-Authenticate", "Basic realm=\"Git\"")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		} else {
+			http.NotFound(w, r)
+		}
+		return "", false
+	}
+
+	if write {
+		if meta.ReplicaOf != nil {
+			http.Error(w, "cannot push: repository is a read-only replica", http.StatusForbidden)
+			return "", false
+		}
+		if meta.Upstream != nil {
+			http.Error(w, "cannot push: repository is a read-only mirror", http.StatusForbidden)
+			return "", false
+		}
+		if username != owner {
+			w.Header().Set("WWW-Authenticate", "Basic realm=\"Git\"")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return "", false
+		}
+	}
+	return username, true
+}
+
+func (s *HTTPServer) auditFailure(r *http.Request, username, detail string) {
+	if s.auditLog == nil {
+		return
+	}
+	err := s.auditLog.Record(audit.Entry{
+		Actor:  username,
+		Action: "auth.failed",
+		Target: r.URL.Path,
+		Detail: detail,
+		Client: clientip.FromRequest(r),
+	})
+	if err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
 func (s *HTTPServer) handleInfoRefs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -92,42 +223,33 @@ func (s *HTTPServer) handleInfoRefs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.storage.RepoExists(owner, repo) {
-		http.NotFound(w, r)
-		return
-	}
-
-	meta, err := s.storage.GetMetadata(owner, repo)
-	if err != nil {
-		http.Error(w, "error getting metadata", http.StatusInternalServerError)
+	if _, ok := s.authorize(w, r, owner, repo, service == "git-receive-pack"); !ok {
 		return
 	}
-
-	username := s.getAuthenticatedUser(r)
-
-	needsWrite := service == "git-receive-pack"
-	if needsWrite {
-		if meta.ReplicaOf != nil {
-			http.Error(w, "cannot push: repository is a read-only replica", http.StatusForbidden)
-			return
+	public := false
+	if service == "git-upload-pack" {
+		if meta, err := s.storage.GetMetadata(owner, repo); err == nil {
+			if base := s.redirectFor(r, meta); base != "" {
+				redirectClone(w, r, base, owner, repo)
+				return
+			}
+			public = !meta.Private
 		}
-		if username != owner {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"Git\"")
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-	} else {
-		if meta.Private && username != owner {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"Git\"")
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		if originURL := s.originFor(r, owner, repo); originURL != "" {
+			s.proxy(w, w, r, originURL, "/info/refs")
 			return
 		}
 	}
 
 	repoPath := s.storage.RepoPath(owner, repo)
 
-	cmd := exec.Command(service, "--stateless-rpc", "--advertise-refs", repoPath)
-	out, err := cmd.Output()
+	var out []byte
+	var err error
+	if public && s.adverts != nil {
+		out, err = s.adverts.Get(owner, repo, repoPath)
+	} else {
+		out, err = exec.Command(service, "--stateless-rpc", "--advertise-refs", repoPath).Output()
+	}
 	if err != nil {
 		log.Printf("git command error: %v", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
@@ -156,34 +278,30 @@ func (s *HTTPServer) handleGitCommand(w http.ResponseWriter, r *http.Request, se
 		return
 	}
 
-	if !s.storage.RepoExists(owner, repo) {
-		http.NotFound(w, r)
-		return
-	}
-
-	meta, err := s.storage.GetMetadata(owner, repo)
-	if err != nil {
-		http.Error(w, "error getting metadata", http.StatusInternalServerError)
+	needsWrite := service == "git-receive-pack"
+	username, ok := s.authorize(w, r, owner, repo, needsWrite)
+	if !ok {
 		return
 	}
 
-	username := s.getAuthenticatedUser(r)
-
-	needsWrite := service == "git-receive-pack"
-	if needsWrite {
-		if meta.ReplicaOf != nil {
-			http.Error(w, "cannot push: repository is a read-only replica", http.StatusForbidden)
+	// Anonymous clients get a few clones at a time each, at a limited
+	// rate, so one crawler can't take the whole instance's bandwidth.
+	var out io.Writer = w
+	if username == "" && !needsWrite && s.clones != nil {
+		ip := clientip.FromRequest(r)
+		release, ok := s.clones.Acquire(ip)
+		if !ok {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "too many clones in progress from your address, try again shortly", http.StatusTooManyRequests)
 			return
 		}
-		if username != owner {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"Git\"")
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-	} else {
-		if meta.Private && username != owner {
-			w.Header().Set("WWW-Authenticate", "Basic realm=\"Git\"")
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		defer release()
+		out = s.clones.Writer(ip, w)
+	}
+
+	if !needsWrite {
+		if originURL := s.originFor(r, owner, repo); originURL != "" {
+			s.proxy(w, out, r, originURL, "/git-upload-pack")
 			return
 		}
 	}
@@ -202,6 +320,9 @@ func (s *HTTPServer) handleGitCommand(w http.ResponseWriter, r *http.Request, se
 	}
 
 	cmd := exec.Command(service, "--stateless-rpc", repoPath)
+	if needsWrite {
+		cmd.Env = append(os.Environ(), s.hooks.Env(owner, repo, username)...)
+	}
 	cmd.Stdin = body
 
 	stdout, err := cmd.StdoutPipe()
@@ -210,16 +331,158 @@ func (s *HTTPServer) handleGitCommand(w http.ResponseWriter, r *http.Request, se
 		return
 	}
 
-	if err := cmd.Start(); err != nil {
+	done, err := startProcess(cmd, Process{Command: service, Repo: owner + "/" + repo, User: username, Transport: "http"})
+	if err != nil {
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	defer done()
 
 	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
 	w.Header().Set("Cache-Control", "no-cache")
 
-	io.Copy(w, stdout)
-	cmd.Wait()
+	io.Copy(out, stdout)
+	if err := cmd.Wait(); err == nil && needsWrite {
+		s.archives.Invalidate(owner, repo)
+		if s.adverts != nil {
+			s.adverts.Invalidate(owner, repo)
+		}
+	}
+}
+
+func (s *HTTPServer) handleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, repo, ref, format := parseArchivePath(r.URL.Path)
+	if owner == "" || repo == "" || ref == "" || !archive.ValidFormat(format) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if _, ok := s.authorize(w, r, owner, repo, false); !ok {
+		return
+	}
+
+	repoPath := s.storage.RepoPath(owner, repo)
+
+	commit, err := archive.ResolveCommit(repoPath, ref)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s", repo, strings.ReplaceAll(ref, "/", "-"))
+	f, err := s.archives.Get(repoPath, owner, repo, ref, commit, format, name)
+	if err != nil {
+		log.Printf("archive %s/%s@%s: %v", owner, repo, ref, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	contentType := "application/gzip"
+	if format == "zip" {
+		contentType = "application/zip"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	w.Header().Set("ETag", fmt.Sprintf("\"%s.%s\"", commit, format))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// handleReleaseDownload serves a release asset at a stable URL:
+//
+//	GET /{owner}/{repo}/releases/download/{tag}/{asset}
+func (s *HTTPServer) handleReleaseDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, repo, tag, asset := parseReleasePath(r.URL.Path)
+	if owner == "" || repo == "" || tag == "" || asset == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if _, ok := s.authorize(w, r, owner, repo, false); !ok {
+		return
+	}
+
+	f, err := os.Open(s.storage.ReleaseAssetPath(owner, repo, tag, asset))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", asset))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// parseReleasePath splits a release download path. Tags and asset names are
+// single path segments, as the upload API requires.
+func parseReleasePath(urlPath string) (owner, repo, tag, asset string) {
+	urlPath = strings.TrimPrefix(path.Clean(urlPath), "/")
+
+	repoPart, file, ok := strings.Cut(urlPath, "/releases/download/")
+	if !ok {
+		return "", "", "", ""
+	}
+
+	tag, asset, ok = strings.Cut(file, "/")
+	if !ok || strings.Contains(asset, "/") || strings.HasPrefix(tag, ".") || strings.HasPrefix(asset, ".") {
+		return "", "", "", ""
+	}
+
+	parts := strings.Split(strings.TrimSuffix(repoPart, ".git"), "/")
+	if len(parts) != 2 {
+		return "", "", "", ""
+	}
+
+	return parts[0], parts[1], tag, asset
+}
+
+func parseArchivePath(urlPath string) (owner, repo, ref, format string) {
+	urlPath = strings.TrimPrefix(path.Clean(urlPath), "/")
+
+	repoPart, file, ok := strings.Cut(urlPath, "/archive/")
+	if !ok {
+		return "", "", "", ""
+	}
+
+	switch {
+	case strings.HasSuffix(file, ".tar.gz"):
+		ref, format = strings.TrimSuffix(file, ".tar.gz"), "tar.gz"
+	case strings.HasSuffix(file, ".zip"):
+		ref, format = strings.TrimSuffix(file, ".zip"), "zip"
+	default:
+		return "", "", "", ""
+	}
+
+	parts := strings.Split(strings.TrimSuffix(repoPart, ".git"), "/")
+	if len(parts) != 2 {
+		return "", "", "", ""
+	}
+
+	return parts[0], parts[1], ref, format
 }
 
 func (s *HTTPServer) parseRepoPath(urlPath string) (owner, repo string) {
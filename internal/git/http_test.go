@@ -0,0 +1,173 @@
+package git
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// testStorage holds alice's public and private repositories, and one only
+// reachable from 10.0.0.0/8; alice/missing doesn't exist.
+type testStorage struct {
+	dir  string
+	meta map[string]storage.Metadata
+}
+
+func newTestStorage(t *testing.T) *testStorage {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	s := &testStorage{
+		dir: t.TempDir(),
+		meta: map[string]storage.Metadata{
+			"alice/public":     {},
+			"alice/private":    {Private: true},
+			"alice/restricted": {AllowedIPs: []string{"10.0.0.0/8"}},
+		},
+	}
+	for repo := range s.meta {
+		out, err := exec.Command("git", "init", "--bare", "-q", filepath.Join(s.dir, repo+".git")).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git init %s: %v: %s", repo, err, out)
+		}
+	}
+	return s
+}
+
+func (s *testStorage) RepoPath(owner, name string) string {
+	return filepath.Join(s.dir, owner, name+".git")
+}
+
+func (s *testStorage) RepoExists(owner, name string) bool {
+	_, ok := s.meta[owner+"/"+name]
+	return ok
+}
+
+func (s *testStorage) GetMetadata(owner, name string) (storage.Metadata, error) {
+	meta, ok := s.meta[owner+"/"+name]
+	if !ok {
+		return storage.Metadata{}, errors.New("repository not found")
+	}
+	return meta, nil
+}
+
+func (s *testStorage) ReleaseAssetPath(owner, name, tag, asset string) string {
+	return ""
+}
+
+// testTokens accepts "alice-token" and "bob-token".
+type testTokens struct{}
+
+func (testTokens) ValidateAPIToken(token, ip string) (string, error) {
+	switch token {
+	case "alice-token":
+		return "alice", nil
+	case "bob-token":
+		return "bob", nil
+	}
+	return "", errors.New("invalid token")
+}
+
+type testArchives struct{}
+
+func (testArchives) Get(repoPath, owner, repo, ref, commit, format, prefix string) (*os.File, error) {
+	return nil, errors.New("no archives")
+}
+
+func (testArchives) Invalidate(owner, repo string) {}
+
+type testHooks struct{}
+
+func (testHooks) Env(owner, repo, user string) []string { return nil }
+
+func infoRefs(t *testing.T, srv *HTTPServer, user, repo, service string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/alice/"+repo+".git/info/refs?service="+service, nil)
+	if user != "" {
+		r.SetBasicAuth(user, user+"-token")
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPAuthorize(t *testing.T) {
+	srv := NewHTTPServer(newTestStorage(t), testTokens{}, testArchives{}, testHooks{})
+
+	tests := []struct {
+		user  string
+		repo  string
+		read  int
+		write int
+	}{
+		{"", "public", http.StatusOK, http.StatusUnauthorized},
+		{"bob", "public", http.StatusOK, http.StatusUnauthorized},
+		{"alice", "public", http.StatusOK, http.StatusOK},
+
+		{"", "private", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"bob", "private", http.StatusNotFound, http.StatusNotFound},
+		{"alice", "private", http.StatusOK, http.StatusOK},
+
+		{"", "missing", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"bob", "missing", http.StatusNotFound, http.StatusNotFound},
+		{"alice", "missing", http.StatusNotFound, http.StatusNotFound},
+
+		// httptest requests come from 192.0.2.1.
+		{"", "restricted", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"bob", "restricted", http.StatusNotFound, http.StatusNotFound},
+		{"alice", "restricted", http.StatusNotFound, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := infoRefs(t, srv, tt.user, tt.repo, "git-upload-pack"); w.Code != tt.read {
+			t.Errorf("read of alice/%s as %q: got %d, want %d", tt.repo, tt.user, w.Code, tt.read)
+		}
+		if w := infoRefs(t, srv, tt.user, tt.repo, "git-receive-pack"); w.Code != tt.write {
+			t.Errorf("push to alice/%s as %q: got %d, want %d", tt.repo, tt.user, w.Code, tt.write)
+		}
+	}
+}
+
+// A private repository someone can't read must be indistinguishable from
+// one that doesn't exist.
+func TestHTTPPrivateLooksMissing(t *testing.T) {
+	srv := NewHTTPServer(newTestStorage(t), testTokens{}, testArchives{}, testHooks{})
+
+	for _, user := range []string{"", "bob"} {
+		for _, service := range []string{"git-upload-pack", "git-receive-pack"} {
+			private := infoRefs(t, srv, user, "private", service)
+			missing := infoRefs(t, srv, user, "missing", service)
+
+			if private.Code != missing.Code {
+				t.Errorf("%s as %q: private repo got %d, missing repo %d", service, user, private.Code, missing.Code)
+			}
+			for _, h := range []string{"WWW-Authenticate", "Content-Type"} {
+				if private.Header().Get(h) != missing.Header().Get(h) {
+					t.Errorf("%s as %q: private repo's %s is %q, missing repo's %q", service, user, h, private.Header().Get(h), missing.Header().Get(h))
+				}
+			}
+			if private.Body.String() != missing.Body.String() {
+				t.Errorf("%s as %q: private repo's body is %q, missing repo's %q", service, user, private.Body.String(), missing.Body.String())
+			}
+		}
+	}
+}
+
+func TestHTTPBadToken(t *testing.T) {
+	srv := NewHTTPServer(newTestStorage(t), testTokens{}, testArchives{}, testHooks{})
+
+	r := httptest.NewRequest("GET", "/alice/private.git/info/refs?service=git-upload-pack", nil)
+	r.SetBasicAuth("alice", "bob-token")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("alice's name with bob's token: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,57 @@
+package git
+
+import (
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Process is a git process serving a clone, fetch or push.
+type Process struct {
+	PID       int       `json:"pid"`
+	Command   string    `json:"command"`
+	Repo      string    `json:"repo"`
+	User      string    `json:"user,omitempty"`
+	Transport string    `json:"transport"`
+	Started   time.Time `json:"started"`
+}
+
+var (
+	processMu sync.Mutex
+	processes = map[int]Process{}
+)
+
+// startProcess starts cmd and records it as p until the returned function
+// is called, once cmd has been waited for.
+func startProcess(cmd *exec.Cmd, p Process) (func(), error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p.PID = cmd.Process.Pid
+	p.Started = time.Now()
+
+	processMu.Lock()
+	processes[p.PID] = p
+	processMu.Unlock()
+
+	return func() {
+		processMu.Lock()
+		delete(processes, p.PID)
+		processMu.Unlock()
+	}, nil
+}
+
+// Processes returns the git processes serving clients over SSH and HTTP,
+// longest-running first.
+func Processes() []Process {
+	processMu.Lock()
+	list := make([]Process, 0, len(processes))
+	for _, p := range processes {
+		list = append(list, p)
+	}
+	processMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
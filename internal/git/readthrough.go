@@ -0,0 +1,243 @@
+package git
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/metrics"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+var readThroughRequests = metrics.NewCounter("openhub_replica_read_through_total", "Clone and fetch requests a replica passed on to the origin because it was behind.")
+
+const (
+	// originCheckTimeout bounds listing the origin's refs, so a replica
+	// whose origin is down still answers quickly.
+	originCheckTimeout = 5 * time.Second
+	// originRefsTTL is how long a listing of the origin's refs is reused,
+	// so the requests of one clone are all answered from the same place.
+	originRefsTTL = 5 * time.Second
+	// originDownTTL is how long a replica serves its own copy without
+	// asking again after the origin couldn't be reached.
+	originDownTTL = 30 * time.Second
+)
+
+// PeerLookup finds instances by ID, such as a replica's origin.
+type PeerLookup interface {
+	Get(instanceID string) (instance.Peer, bool, error)
+}
+
+type originRefs struct {
+	refs    map[string]string
+	err     error
+	checked time.Time
+}
+
+// readThrough passes a replica's clones and fetches on to its origin when
+// the replica hasn't caught up with it yet.
+type readThrough struct {
+	peers  PeerLookup
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]originRefs
+}
+
+// SetReadThrough makes replicas that are behind their origin pass clone and
+// fetch requests on to it, so clients always get current data. The origin
+// is found through peers, from its handshake.
+func (s *HTTPServer) SetReadThrough(peers PeerLookup) {
+	s.readThrough = &readThrough{
+		peers:  peers,
+		client: &http.Client{},
+		cache:  make(map[string]originRefs),
+	}
+}
+
+// originFor returns the URL of owner/repo on its origin if this instance is
+// a replica that has fallen behind it, or "" if the request should be
+// answered here, including when the origin can't be reached.
+func (s *HTTPServer) originFor(r *http.Request, owner, repo string) string {
+	if s.readThrough == nil {
+		return ""
+	}
+	meta, err := s.storage.GetMetadata(owner, repo)
+	if err != nil || meta.ReplicaOf == nil {
+		return ""
+	}
+	peer, ok, err := s.readThrough.peers.Get(meta.ReplicaOf.InstanceID)
+	if err != nil || !ok || peer.URL == "" {
+		return ""
+	}
+	originURL := fmt.Sprintf("%s/%s/%s.git", strings.TrimSuffix(peer.URL, "/"), owner, repo)
+
+	remote, err := s.readThrough.originRefs(originURL, r.Header.Get("Authorization"))
+	if err != nil {
+		return ""
+	}
+	local, err := localRefs(s.storage.RepoPath(owner, repo))
+	if err != nil {
+		log.Printf("read-through %s/%s: %v", owner, repo, err)
+		return ""
+	}
+
+	replicated := func(ref string) bool {
+		if !strings.HasPrefix(ref, "refs/heads/") && !strings.HasPrefix(ref, "refs/tags/") {
+			return false
+		}
+		if len(meta.ReplicaOf.Refs) == 0 {
+			return true
+		}
+		for _, pattern := range meta.ReplicaOf.Refs {
+			if storage.MatchRef(pattern, ref) {
+				return true
+			}
+		}
+		return false
+	}
+	for ref, sha := range remote {
+		if replicated(ref) && local[ref] != sha {
+			return originURL
+		}
+	}
+	for ref := range local {
+		if _, ok := remote[ref]; !ok && replicated(ref) {
+			return originURL
+		}
+	}
+	return ""
+}
+
+// originRefs lists the refs of the repository at url, with the client's
+// credentials, reusing a recent listing.
+func (rt *readThrough) originRefs(url, authorization string) (map[string]string, error) {
+	sum := sha256.Sum256([]byte(authorization))
+	key := url + " " + hex.EncodeToString(sum[:])
+
+	rt.mu.Lock()
+	cached, ok := rt.cache[key]
+	rt.mu.Unlock()
+	if ok {
+		ttl := originRefsTTL
+		if cached.err != nil {
+			ttl = originDownTTL
+		}
+		if time.Since(cached.checked) < ttl {
+			return cached.refs, cached.err
+		}
+	}
+
+	refs, err := lsRemote(url, authorization)
+	if err != nil {
+		log.Printf("read-through: origin unreachable, serving local copy: %v", err)
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	now := time.Now()
+	for k, c := range rt.cache {
+		if now.Sub(c.checked) > originDownTTL {
+			delete(rt.cache, k)
+		}
+	}
+	rt.cache[key] = originRefs{refs: refs, err: err, checked: now}
+	return refs, err
+}
+
+// lsRemote lists the refs of the repository at url. The credentials are
+// passed to git in its environment rather than on the command line, where
+// other users could read them.
+func lsRemote(url, authorization string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), originCheckTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", "--tags", url)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if authorization != "" {
+		cmd.Env = append(cmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: "+authorization,
+		)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ls-remote %s: %w", url, err)
+	}
+	return parseRefs(string(out), "\t"), nil
+}
+
+// localRefs lists the branches and tags of the repository at repoPath.
+func localRefs(repoPath string) (map[string]string, error) {
+	cmd := exec.Command("git", "for-each-ref", "--format=%(objectname) %(refname)", "refs/heads", "refs/tags")
+	cmd.Dir = repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("list refs: %w", err)
+	}
+	return parseRefs(string(out), " "), nil
+}
+
+// parseRefs reads "<sha><sep><ref>" lines, skipping peeled tags.
+func parseRefs(out, sep string) map[string]string {
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		sha, ref, ok := strings.Cut(line, sep)
+		if !ok || strings.HasSuffix(ref, "^{}") {
+			continue
+		}
+		refs[ref] = sha
+	}
+	return refs
+}
+
+// proxy passes the request on to the same path under originURL, a
+// repository's URL on its origin, and copies the answer back, its body
+// through out.
+func (s *HTTPServer) proxy(w http.ResponseWriter, out io.Writer, r *http.Request, originURL, suffix string) {
+	readThroughRequests.Inc()
+
+	target := originURL + suffix
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, r.Body)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	// Git-Protocol isn't passed on: the origin has to answer in the same
+	// protocol version this instance would have.
+	for _, h := range []string{"Authorization", "Content-Type", "Content-Encoding", "Accept", "User-Agent"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	resp, err := s.readThrough.client.Do(req)
+	if err != nil {
+		log.Printf("read-through %s: %v", target, err)
+		http.Error(w, "origin unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range []string{"Content-Type", "Cache-Control", "WWW-Authenticate", "Retry-After"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(out, resp.Body)
+}
@@ -0,0 +1,112 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/metrics"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+var cloneRedirects = metrics.NewCounter("openhub_clone_redirects_total", "Clones and fetches sent to a replica instead of being served here.")
+
+// CloneRegion is a network whose clients are sent to the instance at URL,
+// when it holds a healthy copy of the repository they clone.
+type CloneRegion struct {
+	Network string
+	URL     string
+}
+
+// ParseCloneRegions reads "<ip-or-cidr>=<instance-url>" entries.
+func ParseCloneRegions(list []string) ([]CloneRegion, error) {
+	var regions []CloneRegion
+	for _, entry := range list {
+		network, url, ok := strings.Cut(entry, "=")
+		if !ok || url == "" {
+			return nil, fmt.Errorf("invalid clone region %q, want <ip-or-cidr>=<url>", entry)
+		}
+		parsed, err := clientip.ParseAllowList([]string{network})
+		if err != nil || len(parsed) != 1 {
+			return nil, fmt.Errorf("invalid clone region %q: bad IP or CIDR range", entry)
+		}
+		regions = append(regions, CloneRegion{Network: parsed[0], URL: strings.TrimSuffix(url, "/")})
+	}
+	return regions, nil
+}
+
+// cloneRedirect decides which clones an origin sends to its replicas.
+type cloneRedirect struct {
+	regions []CloneRegion
+	busy    int
+	next    atomic.Uint64
+}
+
+// SetCloneRedirect has the origin of a public repository send clones and
+// fetches to a healthy replica holding all of it: clients in one of regions
+// to the replica serving that region, and, once busy clones are running
+// here, other clients to the replicas in turn. A zero busy only redirects
+// by region.
+func (s *HTTPServer) SetCloneRedirect(regions []CloneRegion, busy int) {
+	s.redirect = &cloneRedirect{regions: regions, busy: busy}
+}
+
+// redirectFor returns the base URL of the replica the request should be
+// sent to, or "" to serve it here. Replicas never redirect, so a clone
+// can't bounce between instances.
+func (s *HTTPServer) redirectFor(r *http.Request, meta storage.Metadata) string {
+	if s.redirect == nil || meta.Private || meta.ReplicaOf != nil {
+		return ""
+	}
+
+	var healthy []string
+	for _, m := range meta.KnownMirrors() {
+		if m.Healthy {
+			healthy = append(healthy, strings.TrimSuffix(m.URL, "/"))
+		}
+	}
+	if len(healthy) == 0 {
+		return ""
+	}
+
+	ip := clientip.FromRequest(r)
+	for _, region := range s.redirect.regions {
+		if !clientip.Allowed([]string{region.Network}, ip) {
+			continue
+		}
+		for _, url := range healthy {
+			if url == region.URL {
+				return url
+			}
+		}
+	}
+
+	if s.redirect.busy > 0 && runningClones() >= s.redirect.busy {
+		return healthy[s.redirect.next.Add(1)%uint64(len(healthy))]
+	}
+	return ""
+}
+
+// runningClones counts the clones and fetches in progress on this instance.
+func runningClones() int {
+	n := 0
+	for _, p := range Processes() {
+		if p.Command == "git-upload-pack" {
+			n++
+		}
+	}
+	return n
+}
+
+// redirectClone sends the client to the same repository on the instance
+// at base. Git follows it for the rest of the clone.
+func redirectClone(w http.ResponseWriter, r *http.Request, base, owner, repo string) {
+	cloneRedirects.Inc()
+	target := fmt.Sprintf("%s/%s/%s.git/info/refs", base, owner, repo)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
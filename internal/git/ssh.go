@@ -1,44 +1,74 @@
 package git
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/jeremytregunna/openhub/internal/clientip"
 	"github.com/jeremytregunna/openhub/internal/storage"
 	"golang.org/x/crypto/ssh"
 )
 
 type SSHServer struct {
-	config     *ssh.ServerConfig
-	storage    RepoStorage
-	authStore  AuthStore
-	replQueue  ReplicationQueue
-	port       int
-	userConns  map[*ssh.ServerConn]string
+	config    *ssh.ServerConfig
+	storage   RepoStorage
+	authStore AuthStore
+	replQueue ReplicationQueue
+	archives  ArchiveCache
+	adverts   *AdvertCache
+	hooks     HookEnv
+	port      int
+	bind      string
+	userConns map[*ssh.ServerConn]string
+
+	// mu guards listener, userConns and closing. running counts the git
+	// commands in progress, which Shutdown waits for.
+	mu       sync.Mutex
+	listener net.Listener
+	closing  bool
+	running  sync.WaitGroup
 }
 
+// ErrServerClosed is returned by Start and Serve after Shutdown.
+var ErrServerClosed = errors.New("git: SSH server closed")
+
 type RepoStorage interface {
 	RepoPath(owner, name string) string
 	RepoExists(owner, name string) bool
 	GetMetadata(owner, name string) (storage.Metadata, error)
+	ReleaseAssetPath(owner, name, tag, asset string) string
 }
 
 type AuthStore interface {
-	ValidateSSHKey(key string) (string, error)
+	ValidateSSHKey(key, ip string) (string, error)
 }
 
 type ReplicationQueue interface {
 	Queue(owner, repo string)
 }
 
-func NewSSHServer(port int, storage RepoStorage, authStore AuthStore, hostKey ssh.Signer, replQueue ReplicationQueue) *SSHServer {
+// HookEnv supplies the environment that makes receive-pack run openhub's
+// server-side hooks.
+type HookEnv interface {
+	Env(owner, repo, user string) []string
+}
+
+func NewSSHServer(port int, storage RepoStorage, authStore AuthStore, hostKey ssh.Signer, replQueue ReplicationQueue, archives ArchiveCache, hooks HookEnv) *SSHServer {
 	s := &SSHServer{
 		storage:   storage,
 		authStore: authStore,
 		replQueue: replQueue,
+		archives:  archives,
+		hooks:     hooks,
 		port:      port,
 		userConns: make(map[*ssh.ServerConn]string),
 	}
@@ -54,7 +84,7 @@ func NewSSHServer(port int, storage RepoStorage, authStore AuthStore, hostKey ss
 
 func (s *SSHServer) publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 	keyStr := string(ssh.MarshalAuthorizedKey(key))
-	username, err := s.authStore.ValidateSSHKey(strings.TrimSpace(keyStr))
+	username, err := s.authStore.ValidateSSHKey(strings.TrimSpace(keyStr), remoteIP(conn.RemoteAddr()))
 	if err != nil {
 		return nil, fmt.Errorf("invalid key")
 	}
@@ -66,17 +96,60 @@ func (s *SSHServer) publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey)
 	}, nil
 }
 
+// remoteIP returns the IP address of addr, without its port.
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// SetBindAddress limits Start to listening on one address, such as
+// 127.0.0.1, instead of every interface.
+func (s *SSHServer) SetBindAddress(host string) {
+	s.bind = host
+}
+
+// SetAdvertCache drops the HTTP server's cached ref advertisement of a
+// repository when it is pushed to over SSH.
+func (s *SSHServer) SetAdvertCache(cache *AdvertCache) {
+	s.adverts = cache
+}
+
 func (s *SSHServer) Start() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	addr := net.JoinHostPort(s.bind, strconv.Itoa(s.port))
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("listen: %w", err)
 	}
 
-	log.Printf("SSH server listening on port %d", s.port)
+	log.Printf("SSH server listening on %s", addr)
+	return s.Serve(listener)
+}
+
+// Serve accepts SSH connections on listener until it is closed.
+func (s *SSHServer) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				if s.closing {
+					return ErrServerClosed
+				}
+				return err
+			}
 			log.Printf("accept error: %v", err)
 			continue
 		}
@@ -100,6 +173,19 @@ func (s *SSHServer) handleConnection(conn net.Conn) {
 		username = sshConn.Permissions.Extensions["user"]
 	}
 
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return
+	}
+	s.userConns[sshConn] = username
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.userConns, sshConn)
+		s.mu.Unlock()
+	}()
+
 	go ssh.DiscardRequests(reqs)
 
 	for newChannel := range chans {
@@ -114,11 +200,11 @@ func (s *SSHServer) handleConnection(conn net.Conn) {
 			continue
 		}
 
-		go s.handleSession(channel, requests, username)
+		go s.handleSession(channel, requests, username, remoteIP(conn.RemoteAddr()))
 	}
 }
 
-func (s *SSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request, username string) {
+func (s *SSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request, username, ip string) {
 	defer channel.Close()
 
 	for req := range requests {
@@ -126,7 +212,7 @@ func (s *SSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Requ
 		case "exec":
 			command := string(req.Payload[4:])
 			req.Reply(true, nil)
-			s.handleGitCommand(channel, command, username)
+			s.handleGitCommand(channel, command, username, ip)
 			return
 		case "shell":
 			req.Reply(false, nil)
@@ -138,7 +224,7 @@ func (s *SSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Requ
 	}
 }
 
-func (s *SSHServer) handleGitCommand(channel ssh.Channel, command, username string) {
+func (s *SSHServer) handleGitCommand(channel ssh.Channel, command, username, ip string) {
 	parts := strings.Fields(command)
 	if len(parts) < 2 {
 		fmt.Fprintf(channel.Stderr(), "invalid command\n")
@@ -162,15 +248,22 @@ func (s *SSHServer) handleGitCommand(channel ssh.Channel, command, username stri
 		return
 	}
 
-	if !s.storage.RepoExists(owner, repo) {
-		fmt.Fprintf(channel.Stderr(), "repository not found\n")
-		channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1})
-		return
+	// A private repository the user can't read, or one that can't be reached
+	// from where they are, looks the same as one that doesn't exist, so
+	// private repository names can't be probed for.
+	var meta storage.Metadata
+	exists := s.storage.RepoExists(owner, repo)
+	if exists {
+		var err error
+		meta, err = s.storage.GetMetadata(owner, repo)
+		if err != nil {
+			fmt.Fprintf(channel.Stderr(), "error getting metadata\n")
+			channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1})
+			return
+		}
 	}
-
-	meta, err := s.storage.GetMetadata(owner, repo)
-	if err != nil {
-		fmt.Fprintf(channel.Stderr(), "error getting metadata\n")
+	if !exists || (meta.Private && username != owner) || !clientip.Allowed(meta.AllowedIPs, ip) {
+		fmt.Fprintf(channel.Stderr(), "repository not found\n")
 		channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1})
 		return
 	}
@@ -183,38 +276,117 @@ func (s *SSHServer) handleGitCommand(channel ssh.Channel, command, username stri
 			channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1})
 			return
 		}
-		if username != owner {
-			fmt.Fprintf(channel.Stderr(), "permission denied: only owner can push\n")
+		if meta.Upstream != nil {
+			fmt.Fprintf(channel.Stderr(), "permission denied: repository is a read-only mirror\n")
 			channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1})
 			return
 		}
-	} else {
-		if meta.Private && username != owner {
-			fmt.Fprintf(channel.Stderr(), "permission denied: private repository\n")
+		if username != owner {
+			fmt.Fprintf(channel.Stderr(), "permission denied: only owner can push\n")
 			channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1})
 			return
 		}
 	}
 
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		fmt.Fprintf(channel.Stderr(), "server is shutting down, try again shortly\n")
+		channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1})
+		return
+	}
+	s.running.Add(1)
+	s.mu.Unlock()
+	defer s.running.Done()
+
 	fullPath := s.storage.RepoPath(owner, repo)
 	cmd := exec.Command(gitCmd, fullPath)
+	if needsWrite {
+		cmd.Env = append(os.Environ(), s.hooks.Env(owner, repo, username)...)
+	}
 	cmd.Stdin = channel
 	cmd.Stdout = channel
 	cmd.Stderr = channel.Stderr()
 
-	if err := cmd.Run(); err != nil {
+	done, err := startProcess(cmd, Process{Command: gitCmd, Repo: owner + "/" + repo, User: username, Transport: "ssh"})
+	if err == nil {
+		err = cmd.Wait()
+		done()
+	}
+	if err != nil {
 		fmt.Fprintf(channel.Stderr(), "command error: %v\n", err)
 		channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1})
 		return
 	}
 
-	if needsWrite && s.replQueue != nil {
-		s.replQueue.Queue(owner, repo)
+	if needsWrite {
+		s.archives.Invalidate(owner, repo)
+		if s.adverts != nil {
+			s.adverts.Invalidate(owner, repo)
+		}
+		if s.replQueue != nil {
+			s.replQueue.Queue(owner, repo)
+		}
 	}
 
 	channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
 }
 
+// Shutdown stops accepting connections and waits for the git commands in
+// progress to finish, refusing new ones, then closes every connection. If
+// ctx ends first, the remaining connections are closed anyway, cutting off
+// their commands, and ctx's error is returned.
+func (s *SSHServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+		// Clients hang up once their command's exit status arrives; give
+		// them a moment to before closing their connections.
+		grace := time.NewTimer(time.Second)
+		defer grace.Stop()
+		tick := time.NewTicker(20 * time.Millisecond)
+		defer tick.Stop()
+	wait:
+		for {
+			s.mu.Lock()
+			idle := len(s.userConns) == 0
+			s.mu.Unlock()
+			if idle {
+				break
+			}
+			select {
+			case <-tick.C:
+			case <-grace.C:
+				break wait
+			case <-ctx.Done():
+				break wait
+			}
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	for conn := range s.userConns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
 func parseRepoPath(path string) (owner, repo string) {
 	path = strings.TrimPrefix(path, "/")
 	path = strings.TrimSuffix(path, ".git")
@@ -0,0 +1,183 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testKeys maps authorized_keys lines to their users.
+type testKeys map[string]string
+
+func (k testKeys) ValidateSSHKey(key, ip string) (string, error) {
+	username, ok := k[key]
+	if !ok {
+		return "", errors.New("unknown key")
+	}
+	return username, nil
+}
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func authorizedKey(signer ssh.Signer) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+}
+
+type sshTest struct {
+	addr  string
+	alice ssh.Signer
+	bob   ssh.Signer
+}
+
+// startSSH serves newTestStorage's repositories over SSH on a loopback
+// port, to alice and bob.
+func startSSH(t *testing.T) *sshTest {
+	t.Helper()
+	st := &sshTest{alice: newSigner(t), bob: newSigner(t)}
+	keys := testKeys{authorizedKey(st.alice): "alice", authorizedKey(st.bob): "bob"}
+	srv := NewSSHServer(0, newTestStorage(t), keys, newSigner(t), nil, testArchives{}, testHooks{})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.addr = l.Addr().String()
+	go srv.Serve(l)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	return st
+}
+
+func (st *sshTest) dial(signer ssh.Signer) (*ssh.Client, error) {
+	return ssh.Dial("tcp", st.addr, &ssh.ClientConfig{
+		User:            "git",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+// run runs a git command for repo as signer's user, ending the exchange
+// with a flush packet as soon as the refs are advertised, and returns the
+// command's exit status and what it wrote to stderr.
+func (st *sshTest) run(t *testing.T, signer ssh.Signer, command, repo string) (int, string) {
+	t.Helper()
+	client, err := st.dial(signer)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = strings.NewReader("0000")
+	session.Stderr = &stderr
+	err = session.Run(command + " 'alice/" + repo + ".git'")
+
+	var exit *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, stderr.String()
+	case errors.As(err, &exit):
+		return exit.ExitStatus(), stderr.String()
+	default:
+		t.Fatalf("%s alice/%s: %v", command, repo, err)
+		return 0, ""
+	}
+}
+
+func TestSSHAuthorize(t *testing.T) {
+	st := startSSH(t)
+
+	const notFound = "repository not found\n"
+	tests := []struct {
+		user    string
+		repo    string
+		readErr string
+		pushErr string
+	}{
+		{"bob", "public", "", "permission denied: only owner can push\n"},
+		{"alice", "public", "", ""},
+
+		{"bob", "private", notFound, notFound},
+		{"alice", "private", "", ""},
+
+		{"bob", "missing", notFound, notFound},
+		{"alice", "missing", notFound, notFound},
+
+		// The test client connects from 127.0.0.1.
+		{"bob", "restricted", notFound, notFound},
+		{"alice", "restricted", notFound, notFound},
+	}
+	for _, tt := range tests {
+		signer := st.bob
+		if tt.user == "alice" {
+			signer = st.alice
+		}
+		for _, c := range []struct{ command, want string }{
+			{"git-upload-pack", tt.readErr},
+			{"git-receive-pack", tt.pushErr},
+		} {
+			status, stderr := st.run(t, signer, c.command, tt.repo)
+			if c.want == "" && status != 0 {
+				t.Errorf("%s alice/%s as %s: exit status %d, stderr %q", c.command, tt.repo, tt.user, status, stderr)
+			}
+			if c.want != "" && (status != 1 || stderr != c.want) {
+				t.Errorf("%s alice/%s as %s: exit status %d, stderr %q; want 1, %q", c.command, tt.repo, tt.user, status, stderr, c.want)
+			}
+		}
+	}
+}
+
+// A private repository someone can't read must be indistinguishable from
+// one that doesn't exist.
+func TestSSHPrivateLooksMissing(t *testing.T) {
+	st := startSSH(t)
+
+	for _, command := range []string{"git-upload-pack", "git-receive-pack"} {
+		privateStatus, privateErr := st.run(t, st.bob, command, "private")
+		missingStatus, missingErr := st.run(t, st.bob, command, "missing")
+		if privateStatus != missingStatus || privateErr != missingErr {
+			t.Errorf("%s: private repo got %d %q, missing repo %d %q", command, privateStatus, privateErr, missingStatus, missingErr)
+		}
+	}
+}
+
+// There is no anonymous SSH access: a key that belongs to no one can't
+// connect at all, so it learns nothing about any repository.
+func TestSSHUnknownKey(t *testing.T) {
+	st := startSSH(t)
+
+	client, err := st.dial(newSigner(t))
+	if err == nil {
+		client.Close()
+		t.Fatal("connected with a key that belongs to no one")
+	}
+}
@@ -0,0 +1,234 @@
+// Package gitbench times clones and pushes over the git HTTP and SSH
+// servers, running in-process on loopback against generated repositories.
+// openhub bench and the benchmarks in internal/git both run on it, so they
+// measure the same thing.
+package gitbench
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/archive"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/git"
+	"github.com/jeremytregunna/openhub/internal/hooks"
+	"github.com/jeremytregunna/openhub/internal/seed"
+	"github.com/jeremytregunna/openhub/internal/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// User owns the benchmarked repositories.
+const User = "bench"
+
+// Files is how many files a generated repository has unless asked for
+// another number.
+const Files = 50
+
+// Size is a generated repository benchmarked by default.
+type Size struct {
+	Name    string
+	Commits int
+}
+
+// Sizes are the small, medium and large repositories benchmarked by default.
+var Sizes = []Size{
+	{"small", 10},
+	{"medium", 200},
+	{"large", 2000},
+}
+
+// Transports are the git transports that can be benchmarked.
+var Transports = []string{"http", "ssh"}
+
+// Env is a throwaway instance: storage, a user with an SSH key and a token,
+// and both git servers listening on loopback.
+type Env struct {
+	Store *storage.Storage
+
+	dir       string
+	httpURL   string
+	sshURL    string
+	gitEnv    []string
+	listeners []io.Closer
+	pushes    int
+}
+
+// noHooks runs no server-side hooks.
+type noHooks struct{}
+
+func (noHooks) Env(owner, repo, user string) []string { return nil }
+
+// NewEnv starts an instance with its storage in dir. With executable set,
+// pushes run it as the pre-receive hook, as they do on a real instance;
+// otherwise they run no hooks.
+func NewEnv(dir, executable string) (*Env, error) {
+	env := &Env{dir: dir}
+	fail := func(err error) (*Env, error) {
+		env.Close()
+		return nil, err
+	}
+
+	storagePath := filepath.Join(dir, "storage")
+	var err error
+	env.Store, err = storage.New(storagePath)
+	if err != nil {
+		return fail(err)
+	}
+	authStore, err := auth.NewAuthStore(storagePath)
+	if err != nil {
+		return fail(err)
+	}
+	if err := authStore.CreateUser(User); err != nil {
+		return fail(err)
+	}
+	token, err := authStore.GenerateAPIToken(User, "bench")
+	if err != nil {
+		return fail(err)
+	}
+
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", keyPath).CombinedOutput(); err != nil {
+		return fail(fmt.Errorf("ssh-keygen: %s", strings.TrimSpace(string(out))))
+	}
+	pub, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return fail(err)
+	}
+	if err := authStore.AddSSHKey(User, "bench", strings.TrimSpace(string(pub))); err != nil {
+		return fail(err)
+	}
+
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fail(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return fail(err)
+	}
+
+	archives, err := archive.NewCache(filepath.Join(storagePath, ".cache", "archives"), 64<<20)
+	if err != nil {
+		return fail(err)
+	}
+	var gitHooks git.HookEnv = noHooks{}
+	if executable != "" {
+		gitHooks, err = hooks.Install(filepath.Join(storagePath, ".hooks"), executable, storagePath)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fail(err)
+	}
+	env.listeners = append(env.listeners, httpListener)
+	go http.Serve(httpListener, git.NewHTTPServer(env.Store, authStore, archives, gitHooks))
+	env.httpURL = fmt.Sprintf("http://%s:%s@%s", User, token, httpListener.Addr())
+
+	sshListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fail(err)
+	}
+	env.listeners = append(env.listeners, sshListener)
+	sshServer := git.NewSSHServer(0, env.Store, authStore, signer, nil, archives, gitHooks)
+	go sshServer.Serve(sshListener)
+	env.sshURL = fmt.Sprintf("ssh://%s@%s", User, sshListener.Addr())
+
+	env.gitEnv = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"HOME="+dir,
+		fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o LogLevel=ERROR", keyPath),
+	)
+	return env, nil
+}
+
+// Close stops the servers. The storage directory is the caller's.
+func (e *Env) Close() {
+	for _, l := range e.listeners {
+		l.Close()
+	}
+	e.listeners = nil
+}
+
+// Seed generates the repository name with commits commits over files files,
+// and returns its size on disk.
+func (e *Env) Seed(name string, commits, files int, rng *mathrand.Rand) (int64, error) {
+	if err := seed.Repo(e.Store, User, name, rng, commits, files); err != nil {
+		return 0, err
+	}
+	return dirSize(e.Store.RepoPath(User, name)), nil
+}
+
+func (e *Env) repoURL(transport, name string) string {
+	if transport == "ssh" {
+		return fmt.Sprintf("%s/%s/%s.git", e.sshURL, User, name)
+	}
+	return fmt.Sprintf("%s/%s/%s.git", e.httpURL, User, name)
+}
+
+func (e *Env) git(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = e.gitEnv
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Clone fetches everything in the repository name over transport into a
+// new bare repository at dir.
+func (e *Env) Clone(transport, name, dir string) error {
+	return e.git(filepath.Dir(dir), "clone", "--bare", "-q", e.repoURL(transport, name), filepath.Base(dir))
+}
+
+// PreparePush copies the repository name to a local bare repository at dir,
+// and creates an empty repository on the server to push it to, whose name
+// it returns. Remove the target once pushed.
+func (e *Env) PreparePush(name, dir string) (string, error) {
+	if err := e.git(filepath.Dir(dir), "clone", "--bare", "-q", e.Store.RepoPath(User, name), filepath.Base(dir)); err != nil {
+		return "", err
+	}
+	e.pushes++
+	target := fmt.Sprintf("%s-push-%d", name, e.pushes)
+	if err := e.Store.CreateRepo(User, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// Push sends every branch of the local repository at source over transport
+// to the repository target.
+func (e *Env) Push(transport, source, target string) error {
+	return e.git(source, "push", "-q", e.repoURL(transport, target), "refs/heads/*:refs/heads/*")
+}
+
+// Remove deletes a push target.
+func (e *Env) Remove(target string) {
+	e.Store.DeleteRepo(User, target)
+}
+
+func dirSize(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,94 @@
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Hooks is a shared hooks directory handed to git-receive-pack through
+// core.hooksPath, so repositories need no per-repo hook installation. The
+// scripts re-invoke the openhub binary, which does the actual work.
+type Hooks struct {
+	dir         string
+	storagePath string
+	masterKey   string
+	scanSecrets bool
+	maxBlobMB   int
+}
+
+var hookNames = []string{"pre-receive", "post-receive"}
+
+func Install(dir, executable, storagePath string) (*Hooks, error) {
+	// Hooks run with the repository as their working directory, so relative
+	// paths would resolve against the wrong place.
+	storagePath, err := filepath.Abs(storagePath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve storage path: %w", err)
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolve hooks dir: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create hooks dir: %w", err)
+	}
+
+	quoted := "'" + strings.ReplaceAll(executable, "'", `'\''`) + "'"
+	for _, name := range hookNames {
+		script := fmt.Sprintf("#!/bin/sh\nexec %s hook %s\n", quoted, name)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			return nil, fmt.Errorf("write %s hook: %w", name, err)
+		}
+	}
+
+	return &Hooks{dir: dir, storagePath: storagePath}, nil
+}
+
+// SetMasterKey passes the key file user records are encrypted with on to
+// the hooks, which read users' signing keys.
+func (h *Hooks) SetMasterKey(file string) error {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return fmt.Errorf("resolve master key path: %w", err)
+	}
+	h.masterKey = file
+	return nil
+}
+
+// SetScan has pre-receive reject pushes adding likely secrets, or files
+// over maxBlobMB if it isn't zero, unless a repository's policy says
+// otherwise.
+func (h *Hooks) SetScan(secrets bool, maxBlobMB int) {
+	h.scanSecrets = secrets
+	h.maxBlobMB = maxBlobMB
+}
+
+// Env returns the environment additions for a receive-pack process serving a
+// push to owner/repo by user.
+func (h *Hooks) Env(owner, repo, user string) []string {
+	if h == nil {
+		return nil
+	}
+	env := []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=core.hooksPath",
+		"GIT_CONFIG_VALUE_0=" + h.dir,
+		"OPENHUB_STORAGE=" + h.storagePath,
+		"OPENHUB_HOOK_REPO=" + owner + "/" + repo,
+		"OPENHUB_HOOK_USER=" + user,
+	}
+	if h.masterKey != "" {
+		env = append(env, "OPENHUB_MASTER_KEY="+h.masterKey)
+	}
+	if h.scanSecrets {
+		env = append(env, "OPENHUB_SCAN_SECRETS=1")
+	}
+	if h.maxBlobMB > 0 {
+		env = append(env, "OPENHUB_MAX_BLOB_MB="+strconv.Itoa(h.maxBlobMB))
+	}
+	return env
+}
@@ -0,0 +1,144 @@
+package instance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/zstd"
+)
+
+// Capabilities are the federation features this build of openhub supports,
+// advertised to peers during a handshake.
+var Capabilities = []string{
+	"replicate",
+	"replicate-metadata",
+	"replicate-delete",
+	"replicate-issues",
+	"chain",
+	"recovery",
+	"sync-users",
+	"discovery",
+	"bundle-upload",
+	"unsubscribe",
+	"invitations",
+}
+
+func init() {
+	// Replicas with zstd installed take compressed bundles.
+	if zstd.Available() {
+		Capabilities = append(Capabilities, "bundle-zstd")
+	}
+}
+
+// Hello is one side of a federation handshake: who an instance is, where it
+// can be reached and what it supports, signed with its own key.
+type Hello struct {
+	InstanceID   string   `json:"instance_id"`
+	PublicKey    string   `json:"public_key"`
+	URL          string   `json:"url,omitempty"`
+	Capabilities []string `json:"capabilities"`
+	Timestamp    int64    `json:"timestamp"`
+	Signature    string   `json:"signature"`
+}
+
+func (h Hello) message() []byte {
+	return SignatureMessage("handshake", h.Timestamp, h.InstanceID, h.PublicKey, h.URL, strings.Join(h.Capabilities, ","))
+}
+
+// Hello introduces this instance as reachable at url.
+func (i *Instance) Hello(url string) Hello {
+	h := Hello{
+		InstanceID:   i.ID,
+		PublicKey:    i.PublicKey,
+		URL:          url,
+		Capabilities: Capabilities,
+		Timestamp:    time.Now().Unix(),
+	}
+	h.Signature = i.Sign(h.message())
+	return h
+}
+
+// Check verifies that the hello is signed by the key it presents and was made
+// within maxSkew of now. It proves the sender holds the key, not that the key
+// belongs to whoever the operator meant to federate with; that is settled by
+// pinning.
+func (h Hello) Check(maxSkew time.Duration) error {
+	if h.InstanceID == "" {
+		return fmt.Errorf("missing instance_id")
+	}
+	if !ValidPublicKey(h.PublicKey) {
+		return fmt.Errorf("invalid public_key")
+	}
+	skew := time.Since(time.Unix(h.Timestamp, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("handshake timestamp out of range")
+	}
+	if !Verify(h.PublicKey, h.message(), h.Signature) {
+		return fmt.Errorf("invalid handshake signature")
+	}
+	return nil
+}
+
+// Supports reports whether the peer advertised capability.
+func (h Hello) Supports(capability string) bool {
+	for _, c := range h.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Peer returns the registry entry the hello describes.
+func (h Hello) Peer() Peer {
+	return Peer{
+		InstanceID:    h.InstanceID,
+		PublicKey:     h.PublicKey,
+		URL:           h.URL,
+		Capabilities:  h.Capabilities,
+		LastHandshake: time.Now(),
+	}
+}
+
+// Handshake sends this instance's hello to the peer at peerURL and returns
+// the peer's verified reply. selfURL is how the peer should reach this
+// instance and may be empty.
+func (i *Instance) Handshake(client *http.Client, selfURL, peerURL string, maxSkew time.Duration) (Hello, error) {
+	data, err := json.Marshal(i.Hello(selfURL))
+	if err != nil {
+		return Hello{}, fmt.Errorf("marshal hello: %w", err)
+	}
+
+	resp, err := client.Post(strings.TrimSuffix(peerURL, "/")+"/api/federation/handshake", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return Hello{}, fmt.Errorf("contact peer: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+		Hello   Hello  `json:"hello"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Hello{}, fmt.Errorf("decode handshake response (status %d): %w", resp.StatusCode, err)
+	}
+	if !result.Success {
+		if result.Error == "" {
+			result.Error = resp.Status
+		}
+		return Hello{}, fmt.Errorf("peer refused handshake: %s", result.Error)
+	}
+
+	if err := result.Hello.Check(maxSkew); err != nil {
+		return Hello{}, fmt.Errorf("peer hello: %w", err)
+	}
+	if result.Hello.InstanceID == i.ID {
+		return Hello{}, fmt.Errorf("peer is this instance")
+	}
+	return result.Hello, nil
+}
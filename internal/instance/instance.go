@@ -1,10 +1,17 @@
 package instance
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,6 +21,9 @@ type Instance struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
 	CreatedAt string `json:"created_at"`
+	PublicKey string `json:"public_key,omitempty"`
+
+	privateKey ed25519.PrivateKey
 }
 
 func LoadOrCreate(storagePath string) (*Instance, error) {
@@ -24,6 +34,15 @@ func LoadOrCreate(storagePath string) (*Instance, error) {
 		if err := json.Unmarshal(data, &inst); err != nil {
 			return nil, fmt.Errorf("unmarshal instance: %w", err)
 		}
+		stored := inst.PublicKey
+		if err := inst.loadOrCreateKey(storagePath); err != nil {
+			return nil, err
+		}
+		if inst.PublicKey != stored {
+			if err := inst.save(instancePath); err != nil {
+				return nil, err
+			}
+		}
 		return &inst, nil
 	}
 
@@ -33,14 +52,115 @@ func LoadOrCreate(storagePath string) (*Instance, error) {
 		CreatedAt: time.Now().Format(time.RFC3339),
 	}
 
-	data, err := json.MarshalIndent(inst, "", "  ")
+	if err := inst.loadOrCreateKey(storagePath); err != nil {
+		return nil, err
+	}
+
+	if err := inst.save(instancePath); err != nil {
+		return nil, err
+	}
+
+	return inst, nil
+}
+
+// Check reports a problem with the identity stored in storagePath: an
+// instance.json that can't be read, or an instance key missing, corrupt or
+// no longer matching the public key peers have pinned. Unlike LoadOrCreate it
+// changes nothing.
+func Check(storagePath string) error {
+	instancePath := filepath.Join(storagePath, "instance.json")
+	data, err := os.ReadFile(instancePath)
+	if err != nil {
+		return fmt.Errorf("read instance: %w", err)
+	}
+	var inst Instance
+	if err := json.Unmarshal(data, &inst); err != nil {
+		return fmt.Errorf("unmarshal instance: %w", err)
+	}
+
+	keyPath := filepath.Join(storagePath, "instance_ed25519")
+	data, err = os.ReadFile(keyPath)
 	if err != nil {
-		return nil, fmt.Errorf("marshal instance: %w", err)
+		return fmt.Errorf("read instance key: %w", err)
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("invalid instance key in %s", keyPath)
+	}
+	public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	if inst.PublicKey != "" && inst.PublicKey != base64.StdEncoding.EncodeToString(public) {
+		return fmt.Errorf("%s does not match the public key in %s", keyPath, instancePath)
+	}
+	return nil
+}
+
+func (i *Instance) save(instancePath string) error {
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal instance: %w", err)
 	}
 
 	if err := os.WriteFile(instancePath, data, 0600); err != nil {
-		return nil, fmt.Errorf("write instance: %w", err)
+		return fmt.Errorf("write instance: %w", err)
 	}
 
-	return inst, nil
+	return nil
+}
+
+func (i *Instance) loadOrCreateKey(storagePath string) error {
+	keyPath := filepath.Join(storagePath, "instance_ed25519")
+
+	if data, err := os.ReadFile(keyPath); err == nil {
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return fmt.Errorf("invalid instance key in %s", keyPath)
+		}
+		i.privateKey = ed25519.NewKeyFromSeed(seed)
+	} else {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("generate instance key: %w", err)
+		}
+		encoded := base64.StdEncoding.EncodeToString(priv.Seed())
+		if err := os.WriteFile(keyPath, []byte(encoded+"\n"), 0600); err != nil {
+			return fmt.Errorf("write instance key: %w", err)
+		}
+		i.privateKey = priv
+	}
+
+	i.PublicKey = base64.StdEncoding.EncodeToString(i.privateKey.Public().(ed25519.PublicKey))
+	return nil
+}
+
+// SignatureMessage builds the byte string covered by a signature: the kind of
+// message, a unix timestamp, and the message-specific parts, newline-joined.
+func SignatureMessage(kind string, timestamp int64, parts ...string) []byte {
+	fields := append([]string{kind, strconv.FormatInt(timestamp, 10)}, parts...)
+	return []byte(strings.Join(fields, "\n"))
+}
+
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (i *Instance) Sign(msg []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(i.privateKey, msg))
+}
+
+func Verify(publicKey string, msg []byte, signature string) bool {
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(pub), msg, sig)
+}
+
+func ValidPublicKey(publicKey string) bool {
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	return err == nil && len(pub) == ed25519.PublicKeySize
 }
@@ -0,0 +1,172 @@
+package instance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Peer struct {
+	InstanceID string    `json:"instance_id"`
+	PublicKey  string    `json:"public_key"`
+	AddedAt    time.Time `json:"added_at"`
+	// URL, Capabilities and LastHandshake are learned from the peer's most
+	// recent handshake; peers pinned by a bare registration have none.
+	URL           string    `json:"url,omitempty"`
+	Capabilities  []string  `json:"capabilities,omitempty"`
+	LastHandshake time.Time `json:"last_handshake,omitempty"`
+}
+
+// Supports reports whether the peer advertised capability at its last
+// handshake.
+func (p Peer) Supports(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerStore is the instance's registry of trusted peers, keyed by instance
+// ID. Keys are pinned on first contact.
+type PeerStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewPeerStore(storagePath string) *PeerStore {
+	return &PeerStore{path: filepath.Join(storagePath, "peers.json")}
+}
+
+func (p *PeerStore) load() (map[string]Peer, error) {
+	peers := make(map[string]Peer)
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return peers, nil
+		}
+		return nil, fmt.Errorf("read peers: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("unmarshal peers: %w", err)
+	}
+	return peers, nil
+}
+
+func (p *PeerStore) save(peers map[string]Peer) error {
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal peers: %w", err)
+	}
+	if err := os.WriteFile(p.path, data, 0600); err != nil {
+		return fmt.Errorf("write peers: %w", err)
+	}
+	return nil
+}
+
+func (p *PeerStore) Get(instanceID string) (Peer, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers, err := p.load()
+	if err != nil {
+		return Peer{}, false, err
+	}
+	peer, ok := peers[instanceID]
+	return peer, ok, nil
+}
+
+// Pin stores the key for instanceID, failing if a different key is already
+// pinned.
+func (p *PeerStore) Pin(instanceID, publicKey string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers, err := p.load()
+	if err != nil {
+		return err
+	}
+
+	if existing, ok := peers[instanceID]; ok {
+		if existing.PublicKey != publicKey {
+			return fmt.Errorf("instance %s is pinned to a different key", instanceID)
+		}
+		return nil
+	}
+
+	peers[instanceID] = Peer{
+		InstanceID: instanceID,
+		PublicKey:  publicKey,
+		AddedAt:    time.Now(),
+	}
+	return p.save(peers)
+}
+
+// Record stores a peer learned through a handshake. Like Pin it refuses a key
+// that differs from the pinned one; otherwise it refreshes the peer's URL and
+// capabilities.
+func (p *PeerStore) Record(peer Peer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers, err := p.load()
+	if err != nil {
+		return err
+	}
+
+	if existing, ok := peers[peer.InstanceID]; ok {
+		if existing.PublicKey != peer.PublicKey {
+			return fmt.Errorf("instance %s is pinned to a different key", peer.InstanceID)
+		}
+		peer.AddedAt = existing.AddedAt
+	} else {
+		peer.AddedAt = time.Now()
+	}
+
+	peers[peer.InstanceID] = peer
+	return p.save(peers)
+}
+
+// List returns every trusted peer, sorted by instance ID.
+func (p *PeerStore) List() ([]Peer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Peer, 0, len(peers))
+	for _, peer := range peers {
+		result = append(result, peer)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].InstanceID < result[j].InstanceID })
+	return result, nil
+}
+
+// Replace pins publicKey for instanceID even if another key was pinned, for
+// an instance that lost its key and proved ownership some other way.
+func (p *PeerStore) Replace(instanceID, publicKey string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers, err := p.load()
+	if err != nil {
+		return err
+	}
+
+	peers[instanceID] = Peer{
+		InstanceID: instanceID,
+		PublicKey:  publicKey,
+		AddedAt:    time.Now(),
+	}
+	return p.save(peers)
+}
@@ -0,0 +1,161 @@
+package instance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxReplicationRequests bounds how many registrations can wait for an
+// admin, so unknown origins can't grow the queue without limit.
+const maxReplicationRequests = 100
+
+var (
+	ErrUnknownRequest  = errors.New("no such replication request")
+	ErrTooManyRequests = errors.New("too many replication requests waiting for approval")
+)
+
+// ReplicationRequest is an origin's registration of a repository to
+// replicate here, held until an admin approves it. Only the hashes of the
+// replication token and invitation key are kept.
+type ReplicationRequest struct {
+	ID               string `json:"id"`
+	Owner            string `json:"owner"`
+	Repo             string `json:"repo"`
+	OriginInstanceID string `json:"origin_instance_id"`
+	OriginPublicKey  string `json:"origin_public_key"`
+	OriginURL        string `json:"origin_url,omitempty"`
+	TokenHash        string `json:"token_hash,omitempty"`
+	// InvitationKeyHash is set when the origin's admin sent an invitation:
+	// it is accepted with the key they pass on, whatever the origin policy.
+	InvitationKeyHash string `json:"invitation_key_hash,omitempty"`
+	// Refs, AllowChain, Description and Size describe what the origin will
+	// push, for the admin to review.
+	Refs        []string  `json:"refs,omitempty"`
+	AllowChain  bool      `json:"allow_chain,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	Client      string    `json:"client,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// RequestStore holds the replication requests waiting for approval.
+type RequestStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewRequestStore(storagePath string) *RequestStore {
+	return &RequestStore{path: filepath.Join(storagePath, "replication-requests.json")}
+}
+
+func (s *RequestStore) load() ([]ReplicationRequest, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read replication requests: %w", err)
+	}
+
+	var requests []ReplicationRequest
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("unmarshal replication requests: %w", err)
+	}
+	return requests, nil
+}
+
+func (s *RequestStore) save(requests []ReplicationRequest) error {
+	data, err := json.MarshalIndent(requests, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal replication requests: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("write replication requests: %w", err)
+	}
+	return nil
+}
+
+// Add queues req, replacing an earlier request from the same origin for the
+// same repository, and returns it with its ID set.
+func (s *RequestStore) Add(req ReplicationRequest) (ReplicationRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests, err := s.load()
+	if err != nil {
+		return req, err
+	}
+
+	kept := requests[:0]
+	for _, r := range requests {
+		if r.Owner != req.Owner || r.Repo != req.Repo || r.OriginInstanceID != req.OriginInstanceID {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) >= maxReplicationRequests {
+		return req, ErrTooManyRequests
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return req, err
+	}
+	req.ID = hex.EncodeToString(b)
+	req.RequestedAt = time.Now()
+	return req, s.save(append(kept, req))
+}
+
+// List returns the waiting requests, oldest first.
+func (s *RequestStore) List() ([]ReplicationRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].RequestedAt.Before(requests[j].RequestedAt) })
+	return requests, nil
+}
+
+// Get returns the request id.
+func (s *RequestStore) Get(id string) (ReplicationRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests, err := s.load()
+	if err != nil {
+		return ReplicationRequest{}, err
+	}
+	for _, r := range requests {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return ReplicationRequest{}, ErrUnknownRequest
+}
+
+// Remove drops the request id from the queue.
+func (s *RequestStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i, r := range requests {
+		if r.ID == id {
+			return s.save(append(requests[:i], requests[i+1:]...))
+		}
+	}
+	return ErrUnknownRequest
+}
@@ -0,0 +1,250 @@
+package issues
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	StateOpen   = "open"
+	StateClosed = "closed"
+)
+
+const (
+	MaxTitleLength = 256
+	// MaxBodyLength bounds issue and comment bodies, which keeps a repo's
+	// issues small enough to replicate in one message.
+	MaxBodyLength = 64 << 10
+)
+
+var ErrNotFound = errors.New("issue not found")
+
+type Issue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body,omitempty"`
+	Author string `json:"author"`
+	State  string `json:"state"`
+
+	Comments []Comment `json:"comments,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+	ClosedBy  string     `json:"closed_by,omitempty"`
+}
+
+type Comment struct {
+	ID        int       `json:"id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store keeps issues as one JSON file each under <repo>.git/issues.
+type Store struct {
+	storage *storage.Storage
+	mu      sync.Mutex
+}
+
+func NewStore(store *storage.Storage) *Store {
+	return &Store{storage: store}
+}
+
+func (s *Store) dir(owner, repo string) string {
+	return filepath.Join(s.storage.RepoPath(owner, repo), "issues")
+}
+
+func (s *Store) path(owner, repo string, number int) string {
+	return filepath.Join(s.dir(owner, repo), fmt.Sprintf("%d.json", number))
+}
+
+func (s *Store) Create(owner, repo string, issue Issue) (Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir(owner, repo), 0755); err != nil {
+		return issue, fmt.Errorf("create issues dir: %w", err)
+	}
+
+	numbers, err := s.numbers(owner, repo)
+	if err != nil {
+		return issue, err
+	}
+
+	issue.Number = 1
+	if len(numbers) > 0 {
+		issue.Number = numbers[len(numbers)-1] + 1
+	}
+	issue.State = StateOpen
+	issue.CreatedAt = time.Now()
+	issue.UpdatedAt = issue.CreatedAt
+
+	if err := s.write(owner, repo, issue); err != nil {
+		return issue, err
+	}
+	return issue, nil
+}
+
+func (s *Store) Get(owner, repo string, number int) (Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(owner, repo, number)
+}
+
+// List returns the repo's issues in number order, optionally restricted to
+// one state.
+func (s *Store) List(owner, repo, state string) ([]Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	numbers, err := s.numbers(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []Issue
+	for _, n := range numbers {
+		issue, err := s.read(owner, repo, n)
+		if err != nil {
+			return nil, err
+		}
+		if state == "" || issue.State == state {
+			issues = append(issues, issue)
+		}
+	}
+	return issues, nil
+}
+
+// Update applies fn to the stored issue and saves the result, unless fn
+// returns an error.
+func (s *Store) Update(owner, repo string, number int, fn func(*Issue) error) (Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	issue, err := s.read(owner, repo, number)
+	if err != nil {
+		return issue, err
+	}
+
+	if err := fn(&issue); err != nil {
+		return issue, err
+	}
+	issue.UpdatedAt = time.Now()
+
+	if err := s.write(owner, repo, issue); err != nil {
+		return issue, err
+	}
+	return issue, nil
+}
+
+// AddComment appends a comment to an issue, numbering it after the last.
+func (s *Store) AddComment(owner, repo string, number int, comment Comment) (Comment, error) {
+	_, err := s.Update(owner, repo, number, func(issue *Issue) error {
+		comment.ID = len(issue.Comments) + 1
+		comment.CreatedAt = time.Now()
+		issue.Comments = append(issue.Comments, comment)
+		return nil
+	})
+	return comment, err
+}
+
+// Replace makes the repo's issues exactly issues, as sent by the origin to
+// a replica.
+func (s *Store) Replace(owner, repo string, issues []Issue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir(owner, repo), 0755); err != nil {
+		return fmt.Errorf("create issues dir: %w", err)
+	}
+
+	keep := make(map[int]bool, len(issues))
+	for _, issue := range issues {
+		if issue.Number < 1 {
+			return fmt.Errorf("invalid issue number %d", issue.Number)
+		}
+		if err := s.write(owner, repo, issue); err != nil {
+			return err
+		}
+		keep[issue.Number] = true
+	}
+
+	numbers, err := s.numbers(owner, repo)
+	if err != nil {
+		return err
+	}
+	for _, n := range numbers {
+		if !keep[n] {
+			if err := os.Remove(s.path(owner, repo, n)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove issue: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) numbers(owner, repo string) ([]int, error) {
+	entries, err := os.ReadDir(s.dir(owner, repo))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read issues dir: %w", err)
+	}
+
+	var numbers []int
+	for _, e := range entries {
+		n, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (s *Store) read(owner, repo string, number int) (Issue, error) {
+	var issue Issue
+
+	data, err := os.ReadFile(s.path(owner, repo, number))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return issue, ErrNotFound
+		}
+		return issue, fmt.Errorf("read issue: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &issue); err != nil {
+		return issue, fmt.Errorf("unmarshal issue: %w", err)
+	}
+	return issue, nil
+}
+
+func (s *Store) write(owner, repo string, issue Issue) error {
+	data, err := json.MarshalIndent(issue, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal issue: %w", err)
+	}
+
+	path := s.path(owner, repo, issue.Number)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write issue: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write issue: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package logstream
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stdPrefix is the timestamp the standard logger puts before each line.
+const stdPrefix = "2006/01/02 15:04:05 "
+
+type Entry struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Hub is an io.Writer for the standard logger that keeps the most recent
+// lines and fans new ones out to subscribers. Subscribers that fall behind
+// miss lines rather than block logging.
+type Hub struct {
+	mu      sync.Mutex
+	recent  []Entry
+	size    int
+	seq     uint64
+	partial []byte
+	subs    map[chan Entry]Filter
+}
+
+func New(size int) *Hub {
+	return &Hub{size: size, subs: make(map[chan Entry]Filter)}
+}
+
+func (h *Hub) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data := append(h.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		h.add(string(data[:i]))
+		data = data[i+1:]
+	}
+	h.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+func (h *Hub) add(line string) {
+	now := time.Now()
+	if len(line) >= len(stdPrefix) {
+		if t, err := time.ParseInLocation(stdPrefix, line[:len(stdPrefix)], time.Local); err == nil {
+			now = t
+			line = line[len(stdPrefix):]
+		}
+	}
+
+	h.seq++
+	e := Entry{Seq: h.seq, Time: now, Message: line}
+
+	h.recent = append(h.recent, e)
+	if len(h.recent) > h.size {
+		h.recent = h.recent[len(h.recent)-h.size:]
+	}
+
+	for ch, f := range h.subs {
+		if !f.Match(e) {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the retained entries matching f and a channel of new
+// ones; there are no gaps or repeats between the two. The returned function
+// ends the subscription and closes the channel.
+func (h *Hub) Subscribe(f Filter) ([]Entry, <-chan Entry, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var backlog []Entry
+	for _, e := range h.recent {
+		if f.Match(e) {
+			backlog = append(backlog, e)
+		}
+	}
+
+	ch := make(chan Entry, 256)
+	h.subs[ch] = f
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel
+}
+
+// Filter selects log lines. Repo matches lines mentioning that repository
+// ("owner/name"), Peer those mentioning a replication peer's URL or instance
+// ID, and Text any substring. Empty fields match everything.
+type Filter struct {
+	Repo string
+	Peer string
+	Text string
+}
+
+func (f Filter) Match(e Entry) bool {
+	if f.Repo != "" && !mentions(e.Message, f.Repo) {
+		return false
+	}
+	if f.Peer != "" && !strings.Contains(e.Message, f.Peer) {
+		return false
+	}
+	if f.Text != "" && !strings.Contains(e.Message, f.Text) {
+		return false
+	}
+	return true
+}
+
+// mentions reports whether s contains token not run together with a longer
+// name, so "alice/proj" doesn't match "alice/project".
+func mentions(s, token string) bool {
+	for i := 0; ; {
+		j := strings.Index(s[i:], token)
+		if j < 0 {
+			return false
+		}
+		start := i + j
+		end := start + len(token)
+		if strings.HasPrefix(s[end:], ".git") {
+			end += len(".git")
+		}
+		if (start == 0 || !nameChar(s[start-1])) && (end == len(s) || !nameChar(s[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func nameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '/'
+}
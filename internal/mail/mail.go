@@ -0,0 +1,104 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/auth"
+)
+
+// queueSize is how many emails can wait to be sent before new ones are
+// dropped.
+const queueSize = 256
+
+// Config is the SMTP server notifications are sent through.
+type Config struct {
+	// Addr is the server's host:port. STARTTLS is used when it offers it.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+type Users interface {
+	GetUser(username string) (*auth.User, error)
+}
+
+type message struct {
+	to      string
+	subject string
+	body    string
+}
+
+// Notifier emails users at the address on their account, unless they have
+// opted out.
+type Notifier struct {
+	cfg   Config
+	users Users
+	queue chan message
+}
+
+func New(cfg Config, users Users) *Notifier {
+	return &Notifier{cfg: cfg, users: users, queue: make(chan message, queueSize)}
+}
+
+// Notify queues an email to user. Users without an address, or who opted
+// out, are skipped.
+func (n *Notifier) Notify(username, subject, body string) {
+	user, err := n.users.GetUser(username)
+	if err != nil || user.Email == "" || user.EmailOptOut {
+		return
+	}
+
+	select {
+	case n.queue <- message{to: user.Email, subject: subject, body: body}:
+	default:
+		log.Printf("mail: queue full, dropping email to %s", username)
+	}
+}
+
+// Start sends queued emails one at a time.
+func (n *Notifier) Start() {
+	go func() {
+		for m := range n.queue {
+			if err := n.send(m); err != nil {
+				log.Printf("mail: send to %s failed: %v", m.to, err)
+			}
+		}
+	}()
+}
+
+func (n *Notifier) send(m message) error {
+	var a smtp.Auth
+	if n.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(n.cfg.Addr)
+		if err != nil {
+			return fmt.Errorf("smtp address: %w", err)
+		}
+		a = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", m.to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(m.subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Auto-Submitted: auto-generated\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(m.body, "\n", "\r\n"))
+
+	return smtp.SendMail(n.cfg.Addr, a, n.cfg.From, []string{m.to}, []byte(msg.String()))
+}
+
+// headerValue keeps text taken from pushes and repositories from starting
+// new header lines.
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,349 @@
+package markup
+
+import (
+	"html"
+	"path"
+	"strings"
+)
+
+// syntax is what the highlighter knows of a language: enough to pick out
+// its comments, strings, numbers and keywords.
+type syntax struct {
+	keywords  map[string]bool
+	constants map[string]bool
+	// line starts a comment that runs to the end of the line. A # only
+	// does so at the start of a line or after a space, so that shell's $#
+	// and the like aren't comments.
+	line []string
+	// block are the start and end of comments that can span lines.
+	block [][2]string
+	// quotes are the characters that delimit strings, and raw those of
+	// them whose strings have no backslash escapes and can span lines.
+	quotes, raw string
+	// triple is set for languages with """ and ''' strings.
+	triple bool
+}
+
+func words(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cComments  = [][2]string{{"/*", "*/"}}
+	cConstants = words("true false NULL nullptr")
+
+	languages = map[string]*syntax{
+		"go": {
+			keywords:  words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var"),
+			constants: words("true false nil iota any error bool byte rune string int int8 int16 int32 int64 uint uint8 uint16 uint32 uint64 uintptr float32 float64 complex64 complex128 append cap close copy delete len make new panic print println recover"),
+			line:      []string{"//"}, block: cComments, quotes: "\"'`", raw: "`",
+		},
+		"javascript": {
+			keywords:  words("async await break case catch class const continue debugger default delete do else export extends finally for from function if import in instanceof let new of return static super switch this throw try typeof var void while with yield"),
+			constants: words("true false null undefined NaN Infinity"),
+			line:      []string{"//"}, block: cComments, quotes: "\"'`", raw: "`",
+		},
+		"typescript": {
+			keywords:  words("abstract as async await break case catch class const continue debugger declare default delete do else enum export extends finally for from function if implements import in instanceof interface keyof let namespace new of private protected public readonly return static super switch this throw try type typeof var void while yield"),
+			constants: words("true false null undefined NaN Infinity any boolean never number object string symbol unknown"),
+			line:      []string{"//"}, block: cComments, quotes: "\"'`", raw: "`",
+		},
+		"python": {
+			keywords:  words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda match nonlocal not or pass raise return try while with yield"),
+			constants: words("True False None self"),
+			line:      []string{"#"}, quotes: "\"'", triple: true,
+		},
+		"ruby": {
+			keywords:  words("alias and begin break case class def defined? do else elsif end ensure for if in module next not or redo rescue retry return self super then undef unless until when while yield require"),
+			constants: words("true false nil"),
+			line:      []string{"#"}, quotes: "\"'",
+		},
+		"rust": {
+			keywords:  words("as async await break const continue crate dyn else enum extern fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait type unsafe use where while"),
+			constants: words("true false None Some Ok Err bool char str String i8 i16 i32 i64 i128 isize u8 u16 u32 u64 u128 usize f32 f64 Vec Option Result Box"),
+			line:      []string{"//"}, block: cComments, quotes: "\"",
+		},
+		"c": {
+			keywords:  words("auto break case char const continue default do double else enum extern float for goto if inline int long register restrict return short signed sizeof static struct switch typedef union unsigned void volatile while"),
+			constants: cConstants,
+			line:      []string{"//"}, block: cComments, quotes: "\"'",
+		},
+		"cpp": {
+			keywords:  words("alignas auto bool break case catch char class const constexpr continue decltype default delete do double else enum explicit export extern float for friend goto if inline int long mutable namespace new noexcept operator private protected public return short signed sizeof static struct switch template this throw try typedef typename union unsigned using virtual void volatile while"),
+			constants: cConstants,
+			line:      []string{"//"}, block: cComments, quotes: "\"'",
+		},
+		"java": {
+			keywords:  words("abstract assert boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long native new package private protected public return short static super switch synchronized this throw throws try var void volatile while"),
+			constants: words("true false null"),
+			line:      []string{"//"}, block: cComments, quotes: "\"'",
+		},
+		"shell": {
+			keywords:  words("case do done elif else esac export fi for function if in local readonly return select then until while"),
+			constants: words("true false echo cd exit set unset shift source"),
+			line:      []string{"#"}, quotes: "\"'", raw: "'",
+		},
+		"sql": {
+			keywords:  words("select from where insert into values update set delete create table index view drop alter add primary key foreign references join left right inner outer on group by order having limit offset as and or not null is in like between distinct union all case when then else end default unique SELECT FROM WHERE INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE INDEX VIEW DROP ALTER ADD PRIMARY KEY FOREIGN REFERENCES JOIN LEFT RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT OFFSET AS AND OR NOT NULL IS IN LIKE BETWEEN DISTINCT UNION ALL CASE WHEN THEN ELSE END DEFAULT UNIQUE"),
+			constants: words("true false TRUE FALSE"),
+			line:      []string{"--"}, block: cComments, quotes: "'\"",
+		},
+		"lua": {
+			keywords:  words("and break do else elseif end for function goto if in local not or repeat return then until while"),
+			constants: words("true false nil"),
+			line:      []string{"--"}, quotes: "\"'",
+		},
+		"yaml": {
+			constants: words("true false null yes no on off"),
+			line:      []string{"#"}, quotes: "\"'", raw: "",
+		},
+		"toml": {
+			constants: words("true false"),
+			line:      []string{"#"}, quotes: "\"'", triple: true,
+		},
+		"json": {
+			constants: words("true false null"),
+			quotes:    "\"",
+		},
+		"css": {
+			keywords: words("important media import keyframes font-face supports"),
+			block:    cComments, quotes: "\"'",
+		},
+		"html": {
+			block:  [][2]string{{"<!--", "-->"}},
+			quotes: "\"",
+		},
+		"make": {
+			keywords: words("ifeq ifneq ifdef ifndef else endif include define endef export override"),
+			line:     []string{"#"}, quotes: "\"'",
+		},
+		"dockerfile": {
+			keywords: words("FROM RUN CMD LABEL EXPOSE ENV ADD COPY ENTRYPOINT VOLUME USER WORKDIR ARG ONBUILD STOPSIGNAL HEALTHCHECK SHELL AS"),
+			line:     []string{"#"}, quotes: "\"'",
+		},
+	}
+
+	// aliases are the other names languages go by, in file extensions and
+	// the info strings of fenced code blocks.
+	aliases = map[string]string{
+		"golang":   "go",
+		"js":       "javascript",
+		"mjs":      "javascript",
+		"cjs":      "javascript",
+		"jsx":      "javascript",
+		"node":     "javascript",
+		"ts":       "typescript",
+		"tsx":      "typescript",
+		"py":       "python",
+		"python3":  "python",
+		"rb":       "ruby",
+		"rs":       "rust",
+		"h":        "c",
+		"cc":       "cpp",
+		"cxx":      "cpp",
+		"hpp":      "cpp",
+		"hh":       "cpp",
+		"c++":      "cpp",
+		"sh":       "shell",
+		"bash":     "shell",
+		"zsh":      "shell",
+		"console":  "shell",
+		"yml":      "yaml",
+		"htm":      "html",
+		"xml":      "html",
+		"svg":      "html",
+		"makefile": "make",
+		"mk":       "make",
+	}
+)
+
+// lookup returns the name of the language called name, or "" if the
+// highlighter doesn't know it.
+func lookup(name string) string {
+	name = strings.ToLower(name)
+	if a, ok := aliases[name]; ok {
+		name = a
+	}
+	if _, ok := languages[name]; ok {
+		return name
+	}
+	return ""
+}
+
+// Language returns the language of the file at p, going by its name, or ""
+// if the highlighter doesn't know it.
+func Language(p string) string {
+	base := strings.ToLower(path.Base(p))
+	switch base {
+	case "makefile", "gnumakefile":
+		return "make"
+	case "dockerfile", "containerfile":
+		return "dockerfile"
+	}
+	return lookup(strings.TrimPrefix(path.Ext(base), "."))
+}
+
+// IsMarkdown reports whether the file at p is Markdown, going by its name.
+func IsMarkdown(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return true
+	}
+	return false
+}
+
+// Highlight returns src as HTML, a string per line, with comments, strings,
+// numbers, keywords and builtins in spans of the classes hl-c, hl-s, hl-n,
+// hl-k and hl-b. Code in a language the highlighter doesn't know is only
+// escaped.
+func Highlight(lang, src string) []string {
+	h := &highlighter{}
+	lang = lookup(lang)
+	syn := languages[lang]
+	if syn == nil {
+		h.emit("", src)
+		return h.finish()
+	}
+
+	for i := 0; i < len(src); {
+		rest := src[i:]
+		if n := syn.comment(src, i); n > 0 {
+			h.emit("hl-c", rest[:n])
+			i += n
+			continue
+		}
+		if n := syn.str(rest); n > 0 {
+			h.emit("hl-s", rest[:n])
+			i += n
+			continue
+		}
+
+		c := src[i]
+		prevWord := i > 0 && isIdentByte(src[i-1])
+		switch {
+		case c >= '0' && c <= '9' && !prevWord:
+			n := 1
+			for n < len(rest) && (isIdentByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			h.emit("hl-n", rest[:n])
+			i += n
+		case isIdentByte(c) && !prevWord:
+			n := 1
+			for n < len(rest) && (isIdentByte(rest[n]) || rest[n] == '?' && lang == "ruby") {
+				n++
+			}
+			word := rest[:n]
+			switch {
+			case syn.keywords[word]:
+				h.emit("hl-k", word)
+			case syn.constants[word]:
+				h.emit("hl-b", word)
+			default:
+				h.emit("", word)
+			}
+			i += n
+		default:
+			h.emit("", rest[:1])
+			i++
+		}
+	}
+	return h.finish()
+}
+
+// comment returns the length of the comment at src[i], or 0.
+func (syn *syntax) comment(src string, i int) int {
+	rest := src[i:]
+	for _, bc := range syn.block {
+		if strings.HasPrefix(rest, bc[0]) {
+			end := strings.Index(rest[len(bc[0]):], bc[1])
+			if end < 0 {
+				return len(rest)
+			}
+			return len(bc[0]) + end + len(bc[1])
+		}
+	}
+	for _, lc := range syn.line {
+		if !strings.HasPrefix(rest, lc) {
+			continue
+		}
+		if lc == "#" && i > 0 && src[i-1] != ' ' && src[i-1] != '\t' && src[i-1] != '\n' {
+			continue
+		}
+		if end := strings.IndexByte(rest, '\n'); end >= 0 {
+			return end
+		}
+		return len(rest)
+	}
+	return 0
+}
+
+// str returns the length of the string at the start of rest, or 0.
+func (syn *syntax) str(rest string) int {
+	if syn.triple {
+		for _, q := range []string{`"""`, `'''`} {
+			if strings.HasPrefix(rest, q) {
+				end := strings.Index(rest[3:], q)
+				if end < 0 {
+					return len(rest)
+				}
+				return 3 + end + 3
+			}
+		}
+	}
+
+	q := rest[0]
+	if strings.IndexByte(syn.quotes, q) < 0 {
+		return 0
+	}
+	raw := strings.IndexByte(syn.raw, q) >= 0
+	for n := 1; n < len(rest); n++ {
+		switch {
+		case rest[n] == '\\' && !raw:
+			n++
+		case rest[n] == q:
+			return n + 1
+		case rest[n] == '\n' && !raw:
+			return n
+		}
+	}
+	return len(rest)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// highlighter collects highlighted text into lines, closing and reopening
+// spans across line breaks so each line stands alone.
+type highlighter struct {
+	lines []string
+	cur   strings.Builder
+}
+
+func (h *highlighter) emit(class, text string) {
+	for {
+		line, rest, more := strings.Cut(text, "\n")
+		if line != "" {
+			if class != "" {
+				h.cur.WriteString(`<span class="` + class + `">` + html.EscapeString(line) + `</span>`)
+			} else {
+				h.cur.WriteString(html.EscapeString(line))
+			}
+		}
+		if !more {
+			return
+		}
+		h.lines = append(h.lines, h.cur.String())
+		h.cur.Reset()
+		text = rest
+	}
+}
+
+func (h *highlighter) finish() []string {
+	return append(h.lines, h.cur.String())
+}
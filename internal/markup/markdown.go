@@ -0,0 +1,954 @@
+// Package markup renders repository files for the web interface: Markdown
+// to HTML, and source code to syntax-highlighted HTML. Everything it emits
+// is escaped text inside a fixed set of tags and attributes, so HTML in a
+// file is shown rather than interpreted and the output is safe to put in a
+// page as is.
+package markup
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LinkFunc rewrites the relative destination of a link, or of an image when
+// image is set, to where it should point from the rendered page. Returning
+// false drops the link, keeping its text, or the image, keeping its
+// description.
+type LinkFunc func(dest string, image bool) (string, bool)
+
+// Markdown renders the commonly used parts of GitHub-flavoured Markdown:
+// headings, paragraphs, emphasis, code, links and images, lists, task
+// lists, block quotes, rules and tables. Links and images to anything but
+// http, https and mailto URLs are dropped; relative ones are passed through
+// link, if it isn't nil.
+func Markdown(src []byte, link LinkFunc) string {
+	r := &renderer{link: link, refs: map[string]linkRef{}}
+	lines := r.collectRefs(splitLines(string(src)))
+
+	var b strings.Builder
+	r.blocks(&b, lines, false)
+	return b.String()
+}
+
+type linkRef struct {
+	dest, title string
+}
+
+type renderer struct {
+	link LinkFunc
+	refs map[string]linkRef
+	// inLink is set while rendering a link's text, which can't contain
+	// another link.
+	inLink bool
+}
+
+var (
+	atxHeading = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	refDef     = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*<?([^\s>]+)>?(?:[ \t]+(?:"([^"]*)"|'([^']*)'|\(([^)]*)\)))?[ \t]*$`)
+	tableSep   = regexp.MustCompile(`^ *:?-+:? *$`)
+	autolink   = regexp.MustCompile(`^<((?:https?://|mailto:)[^\s<>]+)>`)
+)
+
+// splitLines splits src into lines with tabs expanded, which is all the
+// indentation rules need.
+func splitLines(src string) []string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.TrimSuffix(src, "\n")
+	lines := strings.Split(src, "\n")
+	for i, l := range lines {
+		if strings.Contains(l, "\t") {
+			lines[i] = expandTabs(l)
+		}
+	}
+	return lines
+}
+
+func expandTabs(l string) string {
+	var b strings.Builder
+	col := 0
+	for _, c := range l {
+		if c == '\t' {
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(c)
+		col++
+	}
+	return b.String()
+}
+
+// collectRefs takes the link reference definitions out of lines, outside
+// of code blocks, for reference-style links to use.
+func (r *renderer) collectRefs(lines []string) []string {
+	var out []string
+	fence := ""
+	for _, l := range lines {
+		t := strings.TrimSpace(l)
+		if fence != "" {
+			if strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+				fence = ""
+			}
+			out = append(out, l)
+			continue
+		}
+		if f, _, ok := openFence(l); ok {
+			fence = f
+			out = append(out, l)
+			continue
+		}
+		if m := refDef.FindStringSubmatch(l); m != nil {
+			label := normalizeLabel(m[1])
+			if _, ok := r.refs[label]; !ok {
+				r.refs[label] = linkRef{dest: m[2], title: m[3] + m[4] + m[5]}
+			}
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+func normalizeLabel(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func indentOf(l string) int {
+	return len(l) - len(strings.TrimLeft(l, " "))
+}
+
+func isBlank(l string) bool {
+	return strings.TrimSpace(l) == ""
+}
+
+// dedent removes up to n leading spaces from l.
+func dedent(l string, n int) string {
+	if i := indentOf(l); i < n {
+		n = i
+	}
+	return l[n:]
+}
+
+// openFence reports whether l opens a fenced code block, returning the
+// fence and the block's language.
+func openFence(l string) (fence, lang string, ok bool) {
+	if indentOf(l) > 3 {
+		return "", "", false
+	}
+	t := strings.TrimSpace(l)
+	for _, c := range []string{"`", "~"} {
+		n := len(t) - len(strings.TrimLeft(t, c))
+		if n < 3 {
+			continue
+		}
+		info := strings.TrimSpace(t[n:])
+		if c == "`" && strings.Contains(info, "`") {
+			return "", "", false
+		}
+		if f := strings.Fields(info); len(f) > 0 {
+			lang = f[0]
+		}
+		return t[:n], lang, true
+	}
+	return "", "", false
+}
+
+func isRule(l string) bool {
+	if indentOf(l) > 3 {
+		return false
+	}
+	t := strings.ReplaceAll(strings.TrimSpace(l), " ", "")
+	if len(t) < 3 {
+		return false
+	}
+	return strings.Trim(t, "*") == "" || strings.Trim(t, "-") == "" || strings.Trim(t, "_") == ""
+}
+
+// listMarker is the bullet or number that starts a list item.
+type listMarker struct {
+	ordered bool
+	bullet  byte
+	start   int
+	// content is the column the item's content starts at, which its
+	// continuation lines are indented to.
+	content int
+}
+
+func parseMarker(l string) (listMarker, string, bool) {
+	lead := indentOf(l)
+	if lead > 3 {
+		return listMarker{}, "", false
+	}
+	rest := l[lead:]
+
+	var m listMarker
+	width := 0
+	switch {
+	case rest != "" && strings.IndexByte("-*+", rest[0]) >= 0:
+		m.bullet = rest[0]
+		width = 1
+	default:
+		n := 0
+		for n < len(rest) && n < 9 && rest[n] >= '0' && rest[n] <= '9' {
+			n++
+		}
+		if n == 0 || n >= len(rest) || (rest[n] != '.' && rest[n] != ')') {
+			return listMarker{}, "", false
+		}
+		m.ordered = true
+		m.bullet = rest[n]
+		m.start, _ = strconv.Atoi(rest[:n])
+		width = n + 1
+	}
+
+	after := rest[width:]
+	if after != "" && after[0] != ' ' {
+		return listMarker{}, "", false
+	}
+	spaces := indentOf(after)
+	if spaces > 4 || strings.TrimSpace(after) == "" {
+		spaces = 1
+	}
+	m.content = lead + width + spaces
+	if len(l) < m.content {
+		return m, "", true
+	}
+	return m, l[m.content:], true
+}
+
+// startsBlock reports whether l interrupts a paragraph.
+func startsBlock(l string) bool {
+	if _, _, ok := openFence(l); ok {
+		return true
+	}
+	if atxHeading.MatchString(l) || isRule(l) || strings.HasPrefix(strings.TrimSpace(l), ">") {
+		return true
+	}
+	m, content, ok := parseMarker(l)
+	return ok && strings.TrimSpace(content) != "" && (!m.ordered || m.start == 1)
+}
+
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") {
+		return false
+	}
+	sep := splitRow(lines[i+1])
+	if len(sep) == 0 || len(sep) != len(splitRow(lines[i])) {
+		return false
+	}
+	for _, c := range sep {
+		if !tableSep.MatchString(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitRow splits a table row into its cells.
+func splitRow(l string) []string {
+	t := strings.TrimSpace(l)
+	t = strings.TrimPrefix(t, "|")
+	if strings.HasSuffix(t, "|") && !strings.HasSuffix(t, `\|`) {
+		t = t[:len(t)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(t); i++ {
+		switch {
+		case t[i] == '\\' && i+1 < len(t) && t[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case t[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(t[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// blocks renders lines as a sequence of blocks. In a tight list item,
+// paragraphs are written without <p> tags.
+func (r *renderer) blocks(b *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		l := lines[i]
+		switch {
+		case isBlank(l):
+			i++
+
+		case indentOf(l) >= 4:
+			var code []string
+			for i < len(lines) && (isBlank(lines[i]) || indentOf(lines[i]) >= 4) {
+				code = append(code, dedent(lines[i], 4))
+				i++
+			}
+			for len(code) > 0 && isBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+			writeCode(b, "", code)
+
+		case atxHeading.MatchString(l):
+			m := atxHeading.FindStringSubmatch(l)
+			r.heading(b, len(m[1]), m[2])
+			i++
+
+		case isRule(l):
+			b.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(strings.TrimSpace(l), ">"):
+			var quote []string
+			for i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">") {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(q, " "))
+				i++
+			}
+			b.WriteString("<blockquote>\n")
+			r.blocks(b, quote, false)
+			b.WriteString("</blockquote>\n")
+
+		default:
+			if fence, lang, ok := openFence(l); ok {
+				indent := indentOf(l)
+				var code []string
+				for i++; i < len(lines); i++ {
+					t := strings.TrimSpace(lines[i])
+					if strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+						i++
+						break
+					}
+					code = append(code, dedent(lines[i], indent))
+				}
+				writeCode(b, lang, code)
+				continue
+			}
+			if _, _, ok := parseMarker(l); ok {
+				i = r.list(b, lines, i)
+				continue
+			}
+			if isTableStart(lines, i) {
+				i = r.table(b, lines, i)
+				continue
+			}
+			i = r.paragraph(b, lines, i, tight)
+		}
+	}
+}
+
+func (r *renderer) heading(b *strings.Builder, level int, text string) {
+	tag := "h" + strconv.Itoa(level)
+	b.WriteString("<" + tag + ">")
+	r.inline(b, strings.TrimSpace(text))
+	b.WriteString("</" + tag + ">\n")
+}
+
+func (r *renderer) paragraph(b *strings.Builder, lines []string, i int, tight bool) int {
+	var para []string
+	for ; i < len(lines); i++ {
+		l := lines[i]
+		if isBlank(l) {
+			break
+		}
+		if len(para) > 0 {
+			// A line of = or - under a paragraph makes it a heading.
+			t := strings.TrimSpace(l)
+			if indentOf(l) < 4 && (strings.Trim(t, "=") == "" || strings.Trim(t, "-") == "") {
+				level := 1
+				if t[0] == '-' {
+					level = 2
+				}
+				r.heading(b, level, strings.Join(para, "\n"))
+				return i + 1
+			}
+			if startsBlock(l) || isTableStart(lines, i) {
+				break
+			}
+		}
+		para = append(para, strings.TrimLeft(l, " "))
+	}
+
+	text := strings.TrimRight(strings.Join(para, "\n"), " ")
+	if tight {
+		r.inline(b, text)
+		b.WriteString("\n")
+		return i
+	}
+	b.WriteString("<p>")
+	r.inline(b, text)
+	b.WriteString("</p>\n")
+	return i
+}
+
+// list renders the list starting at lines[i] and returns the index of the
+// line after it.
+func (r *renderer) list(b *strings.Builder, lines []string, i int) int {
+	first, _, _ := parseMarker(lines[i])
+
+	var items [][]string
+	loose := false
+	for i < len(lines) {
+		m, content, ok := parseMarker(lines[i])
+		if !ok || m.ordered != first.ordered || m.bullet != first.bullet {
+			break
+		}
+
+		item := []string{content}
+		for i++; i < len(lines); i++ {
+			l := lines[i]
+			if isBlank(l) {
+				j := i
+				for j < len(lines) && isBlank(lines[j]) {
+					j++
+				}
+				if j == len(lines) || indentOf(lines[j]) < m.content {
+					break
+				}
+				item = append(item, "")
+				continue
+			}
+			if indentOf(l) >= m.content {
+				item = append(item, l[m.content:])
+				continue
+			}
+			if _, _, ok := parseMarker(l); ok {
+				break
+			}
+			// A lazy continuation of the item's paragraph.
+			if isBlank(item[len(item)-1]) || startsBlock(l) {
+				break
+			}
+			item = append(item, strings.TrimLeft(l, " "))
+		}
+		for len(item) > 1 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+		}
+		for _, l := range item {
+			if isBlank(l) {
+				loose = true
+			}
+		}
+		items = append(items, item)
+
+		// Blank lines between items make the list loose.
+		j := i
+		for j < len(lines) && isBlank(lines[j]) {
+			j++
+		}
+		if j > i {
+			if j == len(lines) {
+				break
+			}
+			next, _, ok := parseMarker(lines[j])
+			if !ok || next.ordered != first.ordered || next.bullet != first.bullet {
+				break
+			}
+			loose = true
+			i = j
+		}
+	}
+
+	tag := "ul"
+	if first.ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if first.ordered && first.start != 1 {
+		b.WriteString(` start="` + strconv.Itoa(first.start) + `"`)
+	}
+	b.WriteString(">\n")
+	for _, item := range items {
+		b.WriteString("<li>")
+		if task, checked, rest := taskItem(item[0]); task {
+			b.WriteString(`<input type="checkbox" disabled`)
+			if checked {
+				b.WriteString(" checked")
+			}
+			b.WriteString("> ")
+			item[0] = rest
+		}
+		r.blocks(b, item, !loose)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// taskItem reports whether a list item starts with a task list checkbox.
+func taskItem(l string) (task, checked bool, rest string) {
+	if len(l) < 4 || l[0] != '[' || l[2] != ']' || l[3] != ' ' {
+		return false, false, l
+	}
+	switch l[1] {
+	case ' ':
+		return true, false, l[4:]
+	case 'x', 'X':
+		return true, true, l[4:]
+	}
+	return false, false, l
+}
+
+func (r *renderer) table(b *strings.Builder, lines []string, i int) int {
+	header := splitRow(lines[i])
+	var align []string
+	for _, c := range splitRow(lines[i+1]) {
+		left, right := strings.HasPrefix(c, ":"), strings.HasSuffix(c, ":")
+		switch {
+		case left && right:
+			align = append(align, "center")
+		case right:
+			align = append(align, "right")
+		case left:
+			align = append(align, "left")
+		default:
+			align = append(align, "")
+		}
+	}
+
+	row := func(cells []string, tag string) {
+		b.WriteString("<tr>")
+		for n := range header {
+			b.WriteString("<" + tag)
+			if align[n] != "" {
+				b.WriteString(` align="` + align[n] + `"`)
+			}
+			b.WriteString(">")
+			if n < len(cells) {
+				r.inline(b, cells[n])
+			}
+			b.WriteString("</" + tag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+
+	b.WriteString("<table>\n<thead>\n")
+	row(header, "th")
+	b.WriteString("</thead>\n<tbody>\n")
+	for i += 2; i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|") && !startsBlock(lines[i]); i++ {
+		row(splitRow(lines[i]), "td")
+	}
+	b.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+func writeCode(b *strings.Builder, lang string, code []string) {
+	b.WriteString("<pre><code")
+	if name := lookup(lang); name != "" {
+		b.WriteString(` class="language-` + name + `"`)
+	}
+	b.WriteString(">")
+	b.WriteString(strings.Join(Highlight(lang, strings.Join(code, "\n")), "\n"))
+	b.WriteString("</code></pre>\n")
+}
+
+// inline renders the text of a paragraph, heading or table cell.
+func (r *renderer) inline(b *strings.Builder, s string) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+
+		case c == '`':
+			n := runLength(s, i, '`')
+			end := findCodeEnd(s, i+n, n)
+			if end < 0 {
+				b.WriteString(s[i : i+n])
+				i += n
+				continue
+			}
+			code := strings.ReplaceAll(s[i+n:end], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+				code = code[1 : len(code)-1]
+			}
+			b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			i = end + n
+
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if end, ok := r.parseLink(b, s, i+1, true); ok {
+				i = end
+				continue
+			}
+			b.WriteString("!")
+			i++
+
+		case c == '[' && !r.inLink:
+			if end, ok := r.parseLink(b, s, i, false); ok {
+				i = end
+				continue
+			}
+			b.WriteString("[")
+			i++
+
+		case c == '<':
+			if m := autolink.FindStringSubmatch(s[i:]); m != nil && !r.inLink {
+				r.writeURL(b, m[1], m[1])
+				i += len(m[0])
+				continue
+			}
+			b.WriteString("&lt;")
+			i++
+
+		case c == '*' || c == '_' || c == '~':
+			if end, ok := r.emphasis(b, s, i); ok {
+				i = end
+				continue
+			}
+			n := runLength(s, i, c)
+			b.WriteString(s[i : i+n])
+			i += n
+
+		case c == ' ':
+			n := runLength(s, i, ' ')
+			switch {
+			case i+n < len(s) && s[i+n] == '\n' && n >= 2:
+				b.WriteString("<br>\n")
+				i += n + 1
+			case i+n < len(s) && s[i+n] == '\n':
+				i += n
+			default:
+				b.WriteString(s[i : i+n])
+				i += n
+			}
+
+		case (c == 'h' || c == 'w') && !r.inLink && (i == 0 || strings.IndexByte(" \n(*_~", s[i-1]) >= 0):
+			if end, ok := r.bareURL(b, s, i); ok {
+				i = end
+				continue
+			}
+			b.WriteByte(c)
+			i++
+
+		default:
+			b.WriteString(html.EscapeString(s[i : i+1]))
+			i++
+		}
+	}
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// findCodeEnd returns the index of the run of exactly n backticks that
+// closes a code span, searching from i, or -1.
+func findCodeEnd(s string, i, n int) int {
+	for i < len(s) {
+		j := strings.IndexByte(s[i:], '`')
+		if j < 0 {
+			return -1
+		}
+		j += i
+		m := runLength(s, j, '`')
+		if m == n {
+			return j
+		}
+		i = j + m
+	}
+	return -1
+}
+
+// skipSpan returns the index after the code span or escape at s[i], or i
+// if there is none, so that delimiters inside them aren't matched.
+func skipSpan(s string, i int) int {
+	switch s[i] {
+	case '\\':
+		if i+1 < len(s) {
+			return i + 2
+		}
+	case '`':
+		n := runLength(s, i, '`')
+		if end := findCodeEnd(s, i+n, n); end >= 0 {
+			return end + n
+		}
+		return i + n
+	}
+	return i
+}
+
+// emphasis renders the emphasis, strong emphasis or strikethrough opened at
+// s[i], returning the index after it.
+func (r *renderer) emphasis(b *strings.Builder, s string, i int) (int, bool) {
+	c := s[i]
+	run := runLength(s, i, c)
+	if i+run >= len(s) || s[i+run] == ' ' || s[i+run] == '\n' {
+		return 0, false
+	}
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return 0, false
+	}
+
+	type style struct {
+		n          int
+		open, shut string
+	}
+	styles := []style{{3, "<em><strong>", "</strong></em>"}, {2, "<strong>", "</strong>"}, {1, "<em>", "</em>"}}
+	if c == '~' {
+		styles = []style{{2, "<del>", "</del>"}}
+	}
+	for _, st := range styles {
+		if st.n > run {
+			continue
+		}
+		delim := strings.Repeat(string(c), st.n)
+		for j := i + st.n; j < len(s); {
+			if k := skipSpan(s, j); k != j {
+				j = k
+				continue
+			}
+			if strings.HasPrefix(s[j:], delim) && s[j-1] != ' ' && s[j-1] != '\n' && j > i+st.n {
+				after := j + st.n
+				if c == '_' && after < len(s) && isWordByte(s[after]) {
+					j++
+					continue
+				}
+				// A single * doesn't close on a ** that is strong emphasis
+				// nested inside.
+				if n := runLength(s, j, c); st.n == 1 && n > 1 {
+					j += n
+					continue
+				}
+				b.WriteString(st.open)
+				r.inline(b, s[i+st.n:j])
+				b.WriteString(st.shut)
+				return after, true
+			}
+			j++
+		}
+	}
+	return 0, false
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// parseLink renders the link, or image, whose text starts with the [ at s[i],
+// returning the index after it.
+func (r *renderer) parseLink(b *strings.Builder, s string, i int, image bool) (int, bool) {
+	// Find the ] that closes the text, allowing nested brackets.
+	depth, j := 0, i
+	for j < len(s) {
+		if k := skipSpan(s, j); k != j {
+			j = k
+			continue
+		}
+		if s[j] == '[' {
+			depth++
+		} else if s[j] == ']' {
+			depth--
+			if depth == 0 {
+				break
+			}
+		}
+		j++
+	}
+	if j >= len(s) {
+		return 0, false
+	}
+	text := s[i+1 : j]
+	end := j + 1
+
+	var dest, title string
+	switch {
+	case end < len(s) && s[end] == '(':
+		d, t, e, ok := parseInlineDest(s, end+1)
+		if !ok {
+			return 0, false
+		}
+		dest, title, end = d, t, e
+	case end < len(s) && s[end] == '[':
+		k := strings.IndexByte(s[end:], ']')
+		if k < 0 {
+			return 0, false
+		}
+		label := s[end+1 : end+k]
+		if label == "" {
+			label = text
+		}
+		ref, ok := r.refs[normalizeLabel(label)]
+		if !ok {
+			return 0, false
+		}
+		dest, title, end = ref.dest, ref.title, end+k+1
+	default:
+		ref, ok := r.refs[normalizeLabel(text)]
+		if !ok {
+			return 0, false
+		}
+		dest, title = ref.dest, ref.title
+	}
+
+	if image {
+		r.writeImage(b, dest, title, text)
+	} else {
+		r.writeLink(b, dest, title, text)
+	}
+	return end, true
+}
+
+// parseInlineDest parses the "(dest "title")" of an inline link, starting
+// after the (.
+func parseInlineDest(s string, i int) (dest, title string, end int, ok bool) {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	if i < len(s) && s[i] == '<' {
+		k := strings.IndexByte(s[i:], '>')
+		if k < 0 {
+			return "", "", 0, false
+		}
+		dest, i = s[i+1:i+k], i+k+1
+	} else {
+		start, depth := i, 0
+		for ; i < len(s) && s[i] != ' ' && s[i] != '\n'; i++ {
+			if s[i] == '(' {
+				depth++
+			} else if s[i] == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+		}
+		dest = s[start:i]
+	}
+
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+		k := strings.IndexByte(s[i+1:], s[i])
+		if k < 0 {
+			return "", "", 0, false
+		}
+		title, i = s[i+1:i+1+k], i+k+2
+		for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+			i++
+		}
+	}
+	if i >= len(s) || s[i] != ')' {
+		return "", "", 0, false
+	}
+	return dest, title, i + 1, true
+}
+
+// bareURL links the http or https URL, or www. address, at s[i].
+func (r *renderer) bareURL(b *strings.Builder, s string, i int) (int, bool) {
+	rest := s[i:]
+	if !strings.HasPrefix(rest, "http://") && !strings.HasPrefix(rest, "https://") && !strings.HasPrefix(rest, "www.") {
+		return 0, false
+	}
+	n := strings.IndexAny(rest, " \n<")
+	if n < 0 {
+		n = len(rest)
+	}
+	u := strings.TrimRight(rest[:n], ".,:;!?*_~'\"")
+	if strings.HasSuffix(u, ")") && strings.Count(u, "(") < strings.Count(u, ")") {
+		u = u[:len(u)-1]
+	}
+	if len(u) <= len("https://") {
+		return 0, false
+	}
+	href := u
+	if strings.HasPrefix(u, "www.") {
+		href = "http://" + u
+	}
+	r.writeURL(b, href, u)
+	return i + len(u), true
+}
+
+// resolve checks and rewrites a link or image destination.
+func (r *renderer) resolve(dest string, image bool) (string, bool) {
+	dest = strings.TrimSpace(dest)
+	if dest == "" {
+		return "", false
+	}
+	if scheme, ok := urlScheme(dest); ok {
+		switch strings.ToLower(scheme) {
+		case "http", "https":
+			return dest, true
+		case "mailto":
+			return dest, !image
+		}
+		return "", false
+	}
+	if r.link == nil {
+		return dest, true
+	}
+	return r.link(dest, image)
+}
+
+// urlScheme returns the scheme of u, if it has one.
+func urlScheme(u string) (string, bool) {
+	i := strings.IndexAny(u, ":/?#")
+	if i <= 0 || u[i] != ':' {
+		return "", false
+	}
+	return u[:i], true
+}
+
+// writeLink writes a link whose text is Markdown.
+func (r *renderer) writeLink(b *strings.Builder, dest, title, text string) {
+	href, ok := r.resolve(dest, false)
+	if !ok {
+		r.inline(b, text)
+		return
+	}
+	openLink(b, href, title)
+	r.inLink = true
+	r.inline(b, text)
+	r.inLink = false
+	b.WriteString("</a>")
+}
+
+// writeURL writes a link whose text is the URL itself.
+func (r *renderer) writeURL(b *strings.Builder, dest, text string) {
+	href, ok := r.resolve(dest, false)
+	if !ok {
+		b.WriteString(html.EscapeString(text))
+		return
+	}
+	openLink(b, href, "")
+	b.WriteString(html.EscapeString(text) + "</a>")
+}
+
+func openLink(b *strings.Builder, href, title string) {
+	b.WriteString(`<a href="` + html.EscapeString(href) + `"`)
+	if title != "" {
+		b.WriteString(` title="` + html.EscapeString(title) + `"`)
+	}
+	if _, abs := urlScheme(href); abs {
+		b.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+	b.WriteString(">")
+}
+
+func (r *renderer) writeImage(b *strings.Builder, dest, title, alt string) {
+	src, ok := r.resolve(dest, true)
+	if !ok {
+		b.WriteString(html.EscapeString(alt))
+		return
+	}
+	b.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(alt) + `"`)
+	if title != "" {
+		b.WriteString(` title="` + html.EscapeString(title) + `"`)
+	}
+	b.WriteString(">")
+}
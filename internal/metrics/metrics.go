@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]metric)
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[m.name()]; exists {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	registry[m.name()] = m
+}
+
+type Counter struct {
+	n    string
+	help string
+	v    atomic.Int64
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{n: name, help: help}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc()        { c.v.Add(1) }
+func (c *Counter) Add(n int64) { c.v.Add(n) }
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+func (c *Counter) name() string { return c.n }
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.n, c.help, c.n, c.n, c.v.Load())
+}
+
+type Gauge struct {
+	n    string
+	help string
+	v    atomic.Int64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{n: name, help: help}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(n int64) { g.v.Store(n) }
+func (g *Gauge) Inc()        { g.v.Add(1) }
+func (g *Gauge) Dec()        { g.v.Add(-1) }
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+func (g *Gauge) name() string { return g.n }
+
+func (g *Gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.n, g.help, g.n, g.n, g.v.Load())
+}
+
+// Handler serves every registered metric in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryMu.Lock()
+		names := make([]string, 0, len(registry))
+		for name := range registry {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		metrics := make([]metric, 0, len(names))
+		for _, name := range names {
+			metrics = append(metrics, registry[name])
+		}
+		registryMu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, m := range metrics {
+			m.write(w)
+		}
+	})
+}
+
+// Sample is one labelled value reported by a Collector.
+type Sample struct {
+	Labels [][2]string
+	Value  float64
+}
+
+// Collector reports a family of labelled samples computed at scrape time,
+// for values that live in another package's state rather than in a single
+// counter or gauge.
+type Collector struct {
+	n       string
+	help    string
+	typ     string
+	collect func() []Sample
+}
+
+// NewCollector registers a metric family of the given Prometheus type
+// ("counter" or "gauge") whose samples come from collect.
+func NewCollector(name, help, typ string, collect func() []Sample) *Collector {
+	c := &Collector{n: name, help: help, typ: typ, collect: collect}
+	register(c)
+	return c
+}
+
+func (c *Collector) name() string { return c.n }
+
+func (c *Collector) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.n, c.help, c.n, c.typ)
+	for _, s := range c.collect() {
+		fmt.Fprint(w, c.n)
+		if len(s.Labels) > 0 {
+			fmt.Fprint(w, "{")
+			for i, l := range s.Labels {
+				if i > 0 {
+					fmt.Fprint(w, ",")
+				}
+				fmt.Fprintf(w, "%s=\"%s\"", l[0], labelEscaper.Replace(l[1]))
+			}
+			fmt.Fprint(w, "}")
+		}
+		fmt.Fprintf(w, " %s\n", strconv.FormatFloat(s.Value, 'f', -1, 64))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,394 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/events"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// MaxInbox is how many notifications a user keeps; older ones are dropped.
+const MaxInbox = 1000
+
+// Social is who has starred and who watches a repository.
+type Social struct {
+	Stars    []string `json:"stars"`
+	Watchers []string `json:"watchers"`
+}
+
+// Notification is an entry in a user's inbox, made from an event on a
+// repository they watch or own.
+type Notification struct {
+	ID      int       `json:"id"`
+	Kind    string    `json:"kind"`
+	Repo    string    `json:"repo"`
+	Subject string    `json:"subject"`
+	Time    time.Time `json:"time"`
+	Read    bool      `json:"read"`
+}
+
+type inbox struct {
+	NextID        int            `json:"next_id"`
+	Notifications []Notification `json:"notifications"`
+}
+
+type Subscriber interface {
+	Subscribe(f events.Filter, after uint64) ([]events.Event, <-chan events.Event, func())
+}
+
+// Emailer sends a notification to a user's email address, if they have one
+// and want it.
+type Emailer interface {
+	Notify(username, subject, body string)
+}
+
+// Store keeps stars and watchers in <repo>.git/social.json and each user's
+// inbox under <storage>/.notifications.
+type Store struct {
+	storage *storage.Storage
+	dir     string
+	emailer Emailer
+	mu      sync.Mutex
+}
+
+func New(storagePath string, store *storage.Storage) *Store {
+	return &Store{storage: store, dir: filepath.Join(storagePath, ".notifications")}
+}
+
+// SetEmailer also emails new push and replication failure notifications.
+func (s *Store) SetEmailer(e Emailer) {
+	s.emailer = e
+}
+
+func (s *Store) socialPath(owner, repo string) string {
+	return filepath.Join(s.storage.RepoPath(owner, repo), "social.json")
+}
+
+func (s *Store) inboxPath(user string) string {
+	return filepath.Join(s.dir, user+".json")
+}
+
+// Social returns who has starred and who watches a repository.
+func (s *Store) Social(owner, repo string) (Social, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readSocial(owner, repo)
+}
+
+// Star stars or unstars a repository for user.
+func (s *Store) Star(owner, repo, user string, on bool) (Social, error) {
+	return s.update(owner, repo, func(soc *Social) {
+		soc.Stars = toggle(soc.Stars, user, on)
+	})
+}
+
+// Watch subscribes user to, or unsubscribes them from, a repository's
+// notifications.
+func (s *Store) Watch(owner, repo, user string, on bool) (Social, error) {
+	return s.update(owner, repo, func(soc *Social) {
+		soc.Watchers = toggle(soc.Watchers, user, on)
+	})
+}
+
+// Starred lists the "owner/name" of every repository user has starred.
+func (s *Store) Starred(user string) ([]string, error) {
+	repos, err := s.storage.ListRepos()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var starred []string
+	for _, repo := range repos {
+		soc, err := s.readSocial(repo.Owner, repo.Name)
+		if err != nil {
+			return nil, err
+		}
+		if contains(soc.Stars, user) {
+			starred = append(starred, repo.Owner+"/"+repo.Name)
+		}
+	}
+	return starred, nil
+}
+
+func (s *Store) update(owner, repo string, fn func(*Social)) (Social, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	soc, err := s.readSocial(owner, repo)
+	if err != nil {
+		return soc, err
+	}
+	fn(&soc)
+	if err := writeJSON(s.socialPath(owner, repo), soc); err != nil {
+		return soc, fmt.Errorf("write social: %w", err)
+	}
+	return soc, nil
+}
+
+func (s *Store) readSocial(owner, repo string) (Social, error) {
+	soc := Social{Stars: []string{}, Watchers: []string{}}
+
+	data, err := os.ReadFile(s.socialPath(owner, repo))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return soc, nil
+		}
+		return soc, fmt.Errorf("read social: %w", err)
+	}
+	if err := json.Unmarshal(data, &soc); err != nil {
+		return soc, fmt.Errorf("unmarshal social: %w", err)
+	}
+	return soc, nil
+}
+
+// List returns user's notifications, newest first, optionally only the
+// unread ones.
+func (s *Store) List(user string, unreadOnly bool) ([]Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	in, err := s.readInbox(user)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Notification{}
+	for i := len(in.Notifications) - 1; i >= 0; i-- {
+		n := in.Notifications[i]
+		if unreadOnly && n.Read {
+			continue
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// MarkRead marks the given notifications read, or all of them when ids is
+// empty, and returns how many changed.
+func (s *Store) MarkRead(user string, ids []int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	in, err := s.readInbox(user)
+	if err != nil {
+		return 0, err
+	}
+
+	want := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+
+	changed := 0
+	for i := range in.Notifications {
+		n := &in.Notifications[i]
+		if !n.Read && (len(ids) == 0 || want[n.ID]) {
+			n.Read = true
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	if err := writeJSON(s.inboxPath(user), in); err != nil {
+		return 0, fmt.Errorf("write inbox: %w", err)
+	}
+	return changed, nil
+}
+
+// deliver adds n to user's inbox and reports whether it is new.
+func (s *Store) deliver(user string, n Notification) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	in, err := s.readInbox(user)
+	if err != nil {
+		return false, err
+	}
+
+	// A repeat of an unread notification, like a replica failing every
+	// sync, refreshes it rather than filling the inbox.
+	for i := range in.Notifications {
+		old := &in.Notifications[i]
+		if !old.Read && old.Kind == n.Kind && old.Repo == n.Repo && old.Subject == n.Subject {
+			old.Time = n.Time
+			return false, writeJSON(s.inboxPath(user), in)
+		}
+	}
+
+	in.NextID++
+	n.ID = in.NextID
+	in.Notifications = append(in.Notifications, n)
+	if len(in.Notifications) > MaxInbox {
+		in.Notifications = in.Notifications[len(in.Notifications)-MaxInbox:]
+	}
+
+	if err := writeJSON(s.inboxPath(user), in); err != nil {
+		return false, fmt.Errorf("write inbox: %w", err)
+	}
+	return true, nil
+}
+
+func (s *Store) readInbox(user string) (inbox, error) {
+	var in inbox
+
+	data, err := os.ReadFile(s.inboxPath(user))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return in, nil
+		}
+		return in, fmt.Errorf("read inbox: %w", err)
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in, fmt.Errorf("unmarshal inbox: %w", err)
+	}
+	return in, nil
+}
+
+// Start fills inboxes from the event bus: pushes and new issues go to a
+// repository's owner and watchers, except whoever caused them, and failed
+// replication to the owner.
+func (s *Store) Start(bus Subscriber) {
+	f := events.Filter{Kinds: []string{events.KindPush, events.KindIssueOpened, events.KindReplicationCompleted}}
+	backlog, stream, _ := bus.Subscribe(f, 0)
+
+	go func() {
+		for _, e := range backlog {
+			s.handle(e)
+		}
+		for e := range stream {
+			s.handle(e)
+		}
+	}()
+}
+
+func (s *Store) handle(e events.Event) {
+	if e.Owner == "" || e.Repo == "" || !s.storage.RepoExists(e.Owner, e.Repo) {
+		return
+	}
+
+	n := Notification{Kind: e.Kind, Repo: e.Owner + "/" + e.Repo, Time: e.Time}
+	recipients := []string{e.Owner}
+
+	switch e.Kind {
+	case events.KindPush:
+		n.Subject = pushSubject(e)
+	case events.KindIssueOpened:
+		n.Subject = fmt.Sprintf("%s opened issue #%d: %s", e.User, e.Number, e.Title)
+	case events.KindReplicationCompleted:
+		if e.Error == "" {
+			return
+		}
+		n.Subject = fmt.Sprintf("replication to %s failed: %s", e.Replica, e.Error)
+	}
+
+	if e.Kind != events.KindReplicationCompleted {
+		meta, err := s.storage.GetMetadata(e.Owner, e.Repo)
+		if err != nil {
+			log.Printf("notifications: %s: %v", n.Repo, err)
+			return
+		}
+		// A repository made private since someone started watching it
+		// only notifies its owner.
+		if !meta.Private {
+			soc, err := s.Social(e.Owner, e.Repo)
+			if err != nil {
+				log.Printf("notifications: %s: %v", n.Repo, err)
+				return
+			}
+			recipients = append(recipients, soc.Watchers...)
+		}
+	}
+
+	seen := map[string]bool{e.User: true}
+	for _, user := range recipients {
+		if seen[user] {
+			continue
+		}
+		seen[user] = true
+		added, err := s.deliver(user, n)
+		if err != nil {
+			log.Printf("notifications: deliver to %s: %v", user, err)
+			continue
+		}
+		// Repeats aren't emailed again until the first is read.
+		if added && s.emailer != nil && n.Kind != events.KindIssueOpened {
+			s.emailer.Notify(user, fmt.Sprintf("[%s] %s", n.Repo, n.Subject), emailBody(n, user == e.Owner))
+		}
+	}
+}
+
+func emailBody(n Notification, owner bool) string {
+	reason := "you watch " + n.Repo
+	if owner {
+		reason = "you own " + n.Repo
+	}
+	return fmt.Sprintf("%s\n\n-- \nYou are receiving this because %s. To stop these emails, send\n"+
+		"POST /api/v1/users/email {\"notify\": false} to your openhub instance.\n", n.Subject, reason)
+}
+
+func pushSubject(e events.Event) string {
+	ref := strings.TrimPrefix(e.Ref, "refs/heads/")
+	switch {
+	case e.New == "" || e.New == zeroSHA:
+		return fmt.Sprintf("%s deleted %s", e.User, ref)
+	case e.Old == "" || e.Old == zeroSHA:
+		return fmt.Sprintf("%s created %s", e.User, ref)
+	case e.Commits == 1:
+		return fmt.Sprintf("%s pushed 1 commit to %s", e.User, ref)
+	default:
+		return fmt.Sprintf("%s pushed %d commits to %s", e.User, e.Commits, ref)
+	}
+}
+
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+func toggle(list []string, user string, on bool) []string {
+	out := []string{}
+	for _, u := range list {
+		if u != user {
+			out = append(out, u)
+		}
+	}
+	if on {
+		out = append(out, user)
+		sort.Strings(out)
+	}
+	return out
+}
+
+func contains(list []string, user string) bool {
+	for _, u := range list {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,190 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+var conventionalSubject = regexp.MustCompile(`^(feat|fix|docs|style|refactor|perf|test|build|ci|chore|revert)(\([\w./-]+\))?!?: \S`)
+
+type RefUpdate struct {
+	Old string
+	New string
+	Ref string
+}
+
+// IsDelete reports whether the update removes the ref.
+func (u RefUpdate) IsDelete() bool {
+	return u.New == zeroSHA
+}
+
+// ParseUpdates reads the "<old> <new> <ref>" lines git feeds to pre-receive.
+func ParseUpdates(r io.Reader) ([]RefUpdate, error) {
+	var updates []RefUpdate
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed ref update: %q", scanner.Text())
+		}
+		updates = append(updates, RefUpdate{Old: fields[0], New: fields[1], Ref: fields[2]})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read ref updates: %w", err)
+	}
+	return updates, nil
+}
+
+func IsEmpty(p storage.Policy) bool {
+	return p.BranchPattern == "" && !p.ConventionalCommits && p.MaxSubjectLength == 0 && len(p.ProtectedBranches) == 0 && len(p.SignedBranches) == 0 && len(p.ReviewRules) == 0 &&
+		p.ScanSecrets == nil && p.MaxBlobMB == 0
+}
+
+// IsProtected reports whether ref may only be moved by the merge queue.
+func IsProtected(p storage.Policy, ref string) bool {
+	for _, pattern := range p.ProtectedBranches {
+		if storage.MatchRef(pattern, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+func Validate(p storage.Policy) error {
+	for _, pattern := range p.ProtectedBranches {
+		if !strings.HasPrefix(pattern, "refs/heads/") || !storage.ValidRefPattern(pattern) {
+			return fmt.Errorf("invalid protected branch pattern: %s", pattern)
+		}
+	}
+	for _, pattern := range p.SignedBranches {
+		if !strings.HasPrefix(pattern, "refs/heads/") || !storage.ValidRefPattern(pattern) {
+			return fmt.Errorf("invalid signed branch pattern: %s", pattern)
+		}
+	}
+	if p.BranchPattern != "" {
+		if _, err := regexp.Compile(p.BranchPattern); err != nil {
+			return fmt.Errorf("invalid branch_pattern: %w", err)
+		}
+	}
+	if p.MaxSubjectLength < 0 {
+		return fmt.Errorf("max_subject_length must not be negative")
+	}
+	for _, rule := range p.ReviewRules {
+		if !strings.HasPrefix(rule.Branch, "refs/heads/") || !storage.ValidRefPattern(rule.Branch) {
+			return fmt.Errorf("invalid review rule branch: %s", rule.Branch)
+		}
+		if rule.RequiredApprovals < 0 {
+			return fmt.Errorf("required_approvals must not be negative")
+		}
+		if rule.RequiredApprovals == 0 && len(rule.RequiredReviewers) == 0 {
+			return fmt.Errorf("review rule for %s requires neither approvals nor reviewers", rule.Branch)
+		}
+		for _, reviewer := range rule.RequiredReviewers {
+			if reviewer == "" {
+				return fmt.Errorf("empty required reviewer for %s", rule.Branch)
+			}
+		}
+	}
+	return nil
+}
+
+// Check returns a human-readable violation for every rule the pushed ref
+// updates break. Branch names are only checked when a branch is created, so
+// adopting a pattern doesn't lock out existing branches; commit rules apply
+// to every non-merge commit the push introduces. Protected branches may be
+// created by a push but not updated or deleted.
+func Check(repoPath string, p storage.Policy, updates []RefUpdate) ([]string, error) {
+	var violations []string
+
+	var branchRe *regexp.Regexp
+	if p.BranchPattern != "" {
+		re, err := regexp.Compile(p.BranchPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid branch_pattern: %w", err)
+		}
+		branchRe = re
+	}
+
+	checkCommits := p.ConventionalCommits || p.MaxSubjectLength > 0
+	seen := make(map[string]bool)
+
+	for _, u := range updates {
+		if u.Old != zeroSHA && IsProtected(p, u.Ref) {
+			violations = append(violations, fmt.Sprintf("%s is protected; merge into it through the merge queue", u.Ref))
+			continue
+		}
+
+		if u.New == zeroSHA {
+			continue
+		}
+
+		if branchRe != nil && u.Old == zeroSHA && strings.HasPrefix(u.Ref, "refs/heads/") {
+			branch := strings.TrimPrefix(u.Ref, "refs/heads/")
+			if !branchRe.MatchString(branch) {
+				violations = append(violations, fmt.Sprintf("branch %q does not match pattern %s", branch, p.BranchPattern))
+			}
+		}
+
+		if !checkCommits {
+			continue
+		}
+
+		commits, err := newCommits(repoPath, u.New)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range commits {
+			if seen[c.sha] {
+				continue
+			}
+			seen[c.sha] = true
+
+			short := c.sha[:7]
+			if p.ConventionalCommits && !conventionalSubject.MatchString(c.subject) {
+				violations = append(violations, fmt.Sprintf("commit %s: subject is not a conventional commit: %q", short, c.subject))
+			}
+			if p.MaxSubjectLength > 0 && len([]rune(c.subject)) > p.MaxSubjectLength {
+				violations = append(violations, fmt.Sprintf("commit %s: subject is %d characters, max is %d", short, len([]rune(c.subject)), p.MaxSubjectLength))
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+type commit struct {
+	sha     string
+	subject string
+}
+
+// newCommits lists the non-merge commits reachable from tip that no existing
+// ref already contains, i.e. the ones this push introduces.
+func newCommits(repoPath, tip string) ([]commit, error) {
+	cmd := exec.Command("git", "log", "--no-merges", "--format=%H%x09%s", tip, "--not", "--all")
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("list new commits: %w", err)
+	}
+
+	var commits []commit
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+		sha, subject, _ := strings.Cut(line, "\t")
+		commits = append(commits, commit{sha: sha, subject: subject})
+	}
+	return commits, nil
+}
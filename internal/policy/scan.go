@@ -0,0 +1,191 @@
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// maxScanSize is the largest file searched for secrets; credentials live in
+// source and config files, not in anything this big.
+const maxScanSize = 1 << 20
+
+// secretPatterns are credentials that are distinctive enough to reject a
+// push over.
+var secretPatterns = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"AWS access key ID", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+]{40}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|ENCRYPTED|PGP) )?PRIVATE KEY( BLOCK)?-----`)},
+	{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[0-9A-Za-z-]{10,}`)},
+}
+
+// ScanLimits works out what a push to a repository with policy p is scanned
+// for, from the instance's settings and the policy's overrides. A zero
+// maxBlob means files of any size are allowed.
+func ScanLimits(p *storage.Policy, secrets bool, maxBlobMB int) (scanSecrets bool, maxBlob int64) {
+	if p != nil {
+		if p.ScanSecrets != nil {
+			secrets = *p.ScanSecrets
+		}
+		if p.MaxBlobMB != 0 {
+			maxBlobMB = p.MaxBlobMB
+		}
+	}
+	if maxBlobMB < 0 {
+		maxBlobMB = 0
+	}
+	return secrets, int64(maxBlobMB) << 20
+}
+
+// blob is a file a push adds, at one of the paths it appears under.
+type blob struct {
+	sha  string
+	size int64
+	path string
+}
+
+// Scan returns a problem for every file the pushed ref updates add that is
+// bigger than maxBlob, if it isn't zero, or, with secrets, that looks like
+// it holds a credential. Files already in the repository aren't looked at
+// again, so existing history doesn't block new pushes.
+func Scan(repoPath string, updates []RefUpdate, secrets bool, maxBlob int64) ([]string, error) {
+	if !secrets && maxBlob == 0 {
+		return nil, nil
+	}
+
+	var tips []string
+	for _, u := range updates {
+		if !u.IsDelete() {
+			tips = append(tips, u.New)
+		}
+	}
+	if len(tips) == 0 {
+		return nil, nil
+	}
+
+	blobs, err := newBlobs(repoPath, tips)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	var candidates []blob
+	for _, b := range blobs {
+		if maxBlob > 0 && b.size > maxBlob {
+			problems = append(problems, fmt.Sprintf("%s (%s): %.1f MB, over the %d MB limit", b.path, b.sha[:7], float64(b.size)/(1<<20), maxBlob>>20))
+			continue
+		}
+		if secrets && b.size <= maxScanSize {
+			candidates = append(candidates, b)
+		}
+	}
+
+	if len(candidates) > 0 {
+		found, err := findSecrets(repoPath, candidates)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, found...)
+	}
+	return problems, nil
+}
+
+// newBlobs lists the blobs reachable from tips that no existing ref
+// already has.
+func newBlobs(repoPath string, tips []string) ([]blob, error) {
+	args := append([]string{"rev-list", "--objects"}, tips...)
+	list := exec.Command("git", append(args, "--not", "--all")...)
+	list.Dir = repoPath
+	objects, err := list.Output()
+	if err != nil {
+		return nil, fmt.Errorf("list new objects: %w", err)
+	}
+
+	check := exec.Command("git", "cat-file", "--batch-check=%(objecttype) %(objectname) %(objectsize) %(rest)")
+	check.Dir = repoPath
+	check.Stdin = bytes.NewReader(objects)
+	out, err := check.Output()
+	if err != nil {
+		return nil, fmt.Errorf("inspect new objects: %w", err)
+	}
+
+	var blobs []blob
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) < 3 || fields[0] != "blob" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("inspect new objects: unexpected output %q", line)
+		}
+		b := blob{sha: fields[1], size: size}
+		if len(fields) == 4 {
+			b.path = fields[3]
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, nil
+}
+
+// findSecrets searches the contents of blobs for credentials, skipping
+// binary files, and returns where it found any. The secrets themselves are
+// left out, as the message ends up in the pusher's terminal and logs.
+func findSecrets(repoPath string, blobs []blob) ([]string, error) {
+	var input strings.Builder
+	for _, b := range blobs {
+		input.WriteString(b.sha + "\n")
+	}
+
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(input.String())
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("read new files: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("read new files: %w", err)
+	}
+
+	var found []string
+	r := bufio.NewReader(stdout)
+	for _, b := range blobs {
+		if _, err := r.ReadString('\n'); err != nil {
+			cmd.Wait()
+			return nil, fmt.Errorf("read new files: %w", err)
+		}
+		content := make([]byte, b.size+1) // and the newline after it
+		if _, err := io.ReadFull(r, content); err != nil {
+			cmd.Wait()
+			return nil, fmt.Errorf("read new files: %w", err)
+		}
+		content = content[:b.size]
+
+		if bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0 {
+			continue
+		}
+		for _, p := range secretPatterns {
+			if loc := p.re.FindIndex(content); loc != nil {
+				line := bytes.Count(content[:loc[0]], []byte("\n")) + 1
+				found = append(found, fmt.Sprintf("%s:%d (%s): possible %s", b.path, line, b.sha[:7], p.name))
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("read new files: %w", err)
+	}
+	return found, nil
+}
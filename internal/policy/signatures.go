@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/signing"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// IsSigned reports whether every commit pushed to ref must be signed.
+func IsSigned(p storage.Policy, ref string) bool {
+	for _, pattern := range p.SignedBranches {
+		if storage.MatchRef(pattern, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSignatures returns a violation for every commit, merges included, a
+// push to a signed branch introduces whose signature v can't verify.
+func CheckSignatures(repoPath string, p storage.Policy, updates []RefUpdate, v *signing.Verifier) ([]string, error) {
+	var violations []string
+	seen := make(map[string]bool)
+
+	for _, u := range updates {
+		if u.IsDelete() || !IsSigned(p, u.Ref) {
+			continue
+		}
+
+		cmd := exec.Command("git", "rev-list", u.New, "--not", "--all")
+		cmd.Dir = repoPath
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("list new commits: %w", err)
+		}
+		var shas []string
+		for _, sha := range strings.Fields(string(out)) {
+			if !seen[sha] {
+				seen[sha] = true
+				shas = append(shas, sha)
+			}
+		}
+
+		results, err := v.VerifyObjects(repoPath, shas)
+		if err != nil {
+			return nil, err
+		}
+		for i, result := range results {
+			if !result.Verified {
+				violations = append(violations, fmt.Sprintf("commit %s: %s requires verified signatures (%s)", shas[i][:7], u.Ref, result.Reason))
+			}
+		}
+	}
+
+	return violations, nil
+}
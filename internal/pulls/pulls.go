@@ -0,0 +1,313 @@
+package pulls
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	StateOpen   = "open"
+	StateClosed = "closed"
+	StateMerged = "merged"
+)
+
+var (
+	ErrNotFound  = errors.New("pull request not found")
+	ErrNotClosed = errors.New("pull request is not closed")
+)
+
+type PullRequest struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body,omitempty"`
+	Author string `json:"author"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	State  string `json:"state"`
+
+	// SourceRepo is the "owner/name" of the fork Source is a branch of, or
+	// empty when it is a branch of this repository. A fork's branch is
+	// fetched into HeadRef before it is reviewed or merged.
+	SourceRepo string `json:"source_repo,omitempty"`
+
+	// Draft pull requests can't be queued until they are marked ready.
+	Draft bool `json:"draft,omitempty"`
+	// BasePull is the open pull request whose source branch this one
+	// targets, for stacked changes. It is cleared when the base merges and
+	// this pull request is retargeted onto the base's target.
+	BasePull int `json:"base_pull,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	QueuedAt   *time.Time `json:"queued_at,omitempty"`
+	QueuedBy   string     `json:"queued_by,omitempty"`
+	QueueError string     `json:"queue_error,omitempty"`
+
+	MergeCommit string     `json:"merge_commit,omitempty"`
+	MergedAt    *time.Time `json:"merged_at,omitempty"`
+	MergedBy    string     `json:"merged_by,omitempty"`
+
+	ClosedAt *time.Time `json:"closed_at,omitempty"`
+	ClosedBy string     `json:"closed_by,omitempty"`
+
+	Reviews []Review `json:"reviews,omitempty"`
+}
+
+// HeadRef is the ref in the target repository holding the pull request's
+// head: its source branch, or for a pull request from a fork, a copy of the
+// fork's branch under refs/pull.
+func (pr PullRequest) HeadRef() string {
+	if pr.SourceRepo != "" {
+		return fmt.Sprintf("refs/pull/%d/head", pr.Number)
+	}
+	return "refs/heads/" + pr.Source
+}
+
+// SourceLocation returns the repository the source branch lives in, given
+// the pull request's own repository.
+func (pr PullRequest) SourceLocation(owner, repo string) (string, string) {
+	if forkOwner, forkRepo, ok := strings.Cut(pr.SourceRepo, "/"); ok {
+		return forkOwner, forkRepo
+	}
+	return owner, repo
+}
+
+// Store keeps pull requests as one JSON file each under <repo>.git/pulls.
+type Store struct {
+	storage *storage.Storage
+	mu      sync.Mutex
+}
+
+func NewStore(store *storage.Storage) *Store {
+	return &Store{storage: store}
+}
+
+func (s *Store) dir(owner, repo string) string {
+	return filepath.Join(s.storage.RepoPath(owner, repo), "pulls")
+}
+
+func (s *Store) path(owner, repo string, number int) string {
+	return filepath.Join(s.dir(owner, repo), fmt.Sprintf("%d.json", number))
+}
+
+func (s *Store) Create(owner, repo string, pr PullRequest) (PullRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir(owner, repo), 0755); err != nil {
+		return pr, fmt.Errorf("create pulls dir: %w", err)
+	}
+
+	numbers, err := s.numbers(owner, repo)
+	if err != nil {
+		return pr, err
+	}
+
+	pr.Number = 1
+	if len(numbers) > 0 {
+		pr.Number = numbers[len(numbers)-1] + 1
+	}
+	pr.State = StateOpen
+	pr.CreatedAt = time.Now()
+	pr.UpdatedAt = pr.CreatedAt
+
+	if err := s.write(owner, repo, pr); err != nil {
+		return pr, err
+	}
+	return pr, nil
+}
+
+func (s *Store) Get(owner, repo string, number int) (PullRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(owner, repo, number)
+}
+
+// List returns the repo's pull requests in number order, optionally
+// restricted to one state.
+func (s *Store) List(owner, repo, state string) ([]PullRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	numbers, err := s.numbers(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var prs []PullRequest
+	for _, n := range numbers {
+		pr, err := s.read(owner, repo, n)
+		if err != nil {
+			return nil, err
+		}
+		if state == "" || pr.State == state {
+			prs = append(prs, pr)
+		}
+	}
+	return prs, nil
+}
+
+// Update applies fn to the stored pull request and saves the result, unless
+// fn returns an error.
+func (s *Store) Update(owner, repo string, number int, fn func(*PullRequest) error) (PullRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pr, err := s.read(owner, repo, number)
+	if err != nil {
+		return pr, err
+	}
+
+	if err := fn(&pr); err != nil {
+		return pr, err
+	}
+	pr.UpdatedAt = time.Now()
+
+	if err := s.write(owner, repo, pr); err != nil {
+		return pr, err
+	}
+	return pr, nil
+}
+
+// Head resolves the pull request's head commit in its repository, first
+// fetching the source branch from the fork if it comes from one.
+func (s *Store) Head(owner, repo string, pr PullRequest) (string, error) {
+	var head string
+	var err error
+	if pr.SourceRepo != "" {
+		forkOwner, forkRepo := pr.SourceLocation(owner, repo)
+		head, err = s.storage.FetchRef(owner, repo, forkOwner, forkRepo, "refs/heads/"+pr.Source, pr.HeadRef())
+	} else {
+		head, err = s.storage.ResolveCommit(owner, repo, pr.HeadRef())
+	}
+	if err != nil {
+		return "", fmt.Errorf("source branch %s: %w", pr.Source, err)
+	}
+	return head, nil
+}
+
+// Conflicts returns the files that would conflict if the pull request were
+// merged into the current tip of its target. An empty result means it
+// merges cleanly.
+func (s *Store) Conflicts(owner, repo string, pr PullRequest) ([]string, error) {
+	head, err := s.Head(owner, repo, pr)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := s.storage.ResolveCommit(owner, repo, "refs/heads/"+pr.Target)
+	if err != nil {
+		return nil, fmt.Errorf("target branch %s: %w", pr.Target, err)
+	}
+	if s.storage.IsAncestor(owner, repo, head, tip) {
+		return nil, nil
+	}
+	return s.storage.MergeConflicts(owner, repo, tip, head)
+}
+
+// StackedOn returns the open pull request whose source branch is branch, if
+// any, so that a new pull request targeting branch can be stacked on it.
+// Pull requests from forks are never stacked on.
+func (s *Store) StackedOn(owner, repo, branch string) (int, error) {
+	prs, err := s.List(owner, repo, StateOpen)
+	if err != nil {
+		return 0, err
+	}
+	for _, pr := range prs {
+		if pr.SourceRepo == "" && pr.Source == branch {
+			return pr.Number, nil
+		}
+	}
+	return 0, nil
+}
+
+// Retarget moves the open pull requests stacked on base onto base's own
+// target, once base has merged or been closed. It returns the numbers it retargeted.
+func (s *Store) Retarget(owner, repo string, base PullRequest) ([]int, error) {
+	prs, err := s.List(owner, repo, StateOpen)
+	if err != nil {
+		return nil, err
+	}
+
+	var retargeted []int
+	for _, pr := range prs {
+		if pr.BasePull != base.Number {
+			continue
+		}
+		_, err := s.Update(owner, repo, pr.Number, func(pr *PullRequest) error {
+			pr.Target = base.Target
+			pr.BasePull = base.BasePull
+			return nil
+		})
+		if err != nil {
+			return retargeted, err
+		}
+		retargeted = append(retargeted, pr.Number)
+	}
+	return retargeted, nil
+}
+
+func (s *Store) numbers(owner, repo string) ([]int, error) {
+	entries, err := os.ReadDir(s.dir(owner, repo))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read pulls dir: %w", err)
+	}
+
+	var numbers []int
+	for _, e := range entries {
+		n, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (s *Store) read(owner, repo string, number int) (PullRequest, error) {
+	var pr PullRequest
+
+	data, err := os.ReadFile(s.path(owner, repo, number))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pr, ErrNotFound
+		}
+		return pr, fmt.Errorf("read pull request: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &pr); err != nil {
+		return pr, fmt.Errorf("unmarshal pull request: %w", err)
+	}
+	return pr, nil
+}
+
+func (s *Store) write(owner, repo string, pr PullRequest) error {
+	data, err := json.MarshalIndent(pr, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal pull request: %w", err)
+	}
+
+	path := s.path(owner, repo, pr.Number)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write pull request: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write pull request: %w", err)
+	}
+	return nil
+}
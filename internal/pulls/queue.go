@@ -0,0 +1,408 @@
+package pulls
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+var (
+	ErrNotOpen       = errors.New("pull request is not open")
+	ErrAlreadyQueued = errors.New("pull request is already queued")
+	ErrNotQueued     = errors.New("pull request is not queued")
+	ErrDraft         = errors.New("pull request is a draft")
+	ErrStacked       = errors.New("pull request is stacked on an unmerged pull request")
+)
+
+type queueKey struct {
+	owner  string
+	repo   string
+	target string
+}
+
+// MergeQueue serializes merges per target branch. Each entry is merged
+// against the branch tip at the moment it reaches the head of the queue, so a
+// merge is never computed against a base that has since moved. The queued
+// state lives on the pull requests themselves, which lets Restore rebuild the
+// queue after a restart.
+type MergeQueue struct {
+	pulls   *Store
+	storage *storage.Storage
+	onMerge func(owner, repo string)
+
+	mu     sync.Mutex
+	queues map[queueKey][]int
+	wake   chan struct{}
+}
+
+func NewMergeQueue(pulls *Store, store *storage.Storage, onMerge func(owner, repo string)) *MergeQueue {
+	return &MergeQueue{
+		pulls:   pulls,
+		storage: store,
+		onMerge: onMerge,
+		queues:  make(map[queueKey][]int),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Restore re-queues every open pull request that was queued when the server
+// last stopped, in the order they were queued.
+func (q *MergeQueue) Restore() error {
+	repos, err := q.storage.ListRepos()
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		key      queueKey
+		number   int
+		queuedAt time.Time
+	}
+	var entries []entry
+
+	for _, repo := range repos {
+		prs, err := q.pulls.List(repo.Owner, repo.Name, StateOpen)
+		if err != nil {
+			return err
+		}
+		for _, pr := range prs {
+			if pr.QueuedAt != nil {
+				entries = append(entries, entry{queueKey{repo.Owner, repo.Name, pr.Target}, pr.Number, *pr.QueuedAt})
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].queuedAt.Before(entries[j].queuedAt) })
+
+	q.mu.Lock()
+	for _, e := range entries {
+		q.queues[e.key] = append(q.queues[e.key], e.number)
+	}
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+func (q *MergeQueue) Start() {
+	go q.run()
+}
+
+// Enqueue adds an open pull request to its target branch's queue and returns
+// its 1-based position.
+func (q *MergeQueue) Enqueue(owner, repo string, number int, user string) (int, error) {
+	rules, err := q.reviewRules(owner, repo)
+	if err != nil {
+		return 0, err
+	}
+
+	pr, err := q.pulls.Update(owner, repo, number, func(pr *PullRequest) error {
+		if pr.State != StateOpen {
+			return ErrNotOpen
+		}
+		if pr.QueuedAt != nil {
+			return ErrAlreadyQueued
+		}
+		if pr.Draft {
+			return ErrDraft
+		}
+		if pr.BasePull != 0 {
+			return ErrStacked
+		}
+		if err := CheckReviews(*pr, rules); err != nil {
+			return err
+		}
+		now := time.Now()
+		pr.QueuedAt = &now
+		pr.QueuedBy = user
+		pr.QueueError = ""
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	key := queueKey{owner, repo, pr.Target}
+
+	q.mu.Lock()
+	q.queues[key] = append(q.queues[key], number)
+	position := len(q.queues[key])
+	q.mu.Unlock()
+
+	q.signal()
+	return position, nil
+}
+
+func (q *MergeQueue) Dequeue(owner, repo string, number int) error {
+	pr, err := q.pulls.Update(owner, repo, number, func(pr *PullRequest) error {
+		if pr.QueuedAt == nil {
+			return ErrNotQueued
+		}
+		pr.QueuedAt = nil
+		pr.QueuedBy = ""
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	q.remove(queueKey{owner, repo, pr.Target}, number)
+	return nil
+}
+
+// Position returns the 1-based queue position of a pull request, or 0 if it
+// is not queued.
+func (q *MergeQueue) Position(owner, repo, target string, number int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, n := range q.queues[queueKey{owner, repo, target}] {
+		if n == number {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Entries returns the queued pull request numbers for a target branch, head
+// first.
+func (q *MergeQueue) Entries(owner, repo, target string) []int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]int(nil), q.queues[queueKey{owner, repo, target}]...)
+}
+
+func (q *MergeQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *MergeQueue) remove(key queueKey, number int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := q.queues[key]
+	for i, n := range entries {
+		if n == number {
+			q.queues[key] = append(entries[:i:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(q.queues[key]) == 0 {
+		delete(q.queues, key)
+	}
+}
+
+func (q *MergeQueue) head() (queueKey, int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for key, entries := range q.queues {
+		if len(entries) > 0 {
+			return key, entries[0], true
+		}
+	}
+	return queueKey{}, 0, false
+}
+
+func (q *MergeQueue) run() {
+	for range q.wake {
+		for {
+			key, number, ok := q.head()
+			if !ok {
+				break
+			}
+			if err := q.merge(key, number); err != nil {
+				log.Printf("merge queue: %s/%s #%d: %v", key.owner, key.repo, number, err)
+				q.fail(key, number, err)
+			}
+			q.remove(key, number)
+		}
+	}
+}
+
+// merge merges the pull request at the head of a queue, if it is still open
+// and queued.
+func (q *MergeQueue) merge(key queueKey, number int) error {
+	pr, err := q.pulls.Get(key.owner, key.repo, number)
+	if err != nil {
+		return err
+	}
+	if pr.State != StateOpen || pr.QueuedAt == nil {
+		return nil
+	}
+
+	// Reviews can change while the pull request waits in the queue.
+	rules, err := q.reviewRules(key.owner, key.repo)
+	if err != nil {
+		return err
+	}
+	if err := CheckReviews(pr, rules); err != nil {
+		return err
+	}
+
+	_, err = q.mergeInto(key.owner, key.repo, pr, pr.QueuedBy)
+	return err
+}
+
+// Merge merges an open pull request immediately, without queueing it. It
+// is held to the same rules as Enqueue, and a queued pull request must be
+// dequeued first so the queue's order is kept.
+func (q *MergeQueue) Merge(owner, repo string, number int, user string) (PullRequest, error) {
+	pr, err := q.pulls.Get(owner, repo, number)
+	if err != nil {
+		return pr, err
+	}
+
+	switch {
+	case pr.State != StateOpen:
+		return pr, ErrNotOpen
+	case pr.QueuedAt != nil:
+		return pr, ErrAlreadyQueued
+	case pr.Draft:
+		return pr, ErrDraft
+	case pr.BasePull != 0:
+		return pr, ErrStacked
+	}
+
+	rules, err := q.reviewRules(owner, repo)
+	if err != nil {
+		return pr, err
+	}
+	if err := CheckReviews(pr, rules); err != nil {
+		return pr, err
+	}
+
+	return q.mergeInto(owner, repo, pr, user)
+}
+
+// mergeInto merges the pull request into the current tip of its target as
+// user. If the target moves between computing the merge and publishing it,
+// the merge is recomputed against the new tip.
+func (q *MergeQueue) mergeInto(owner, repo string, pr PullRequest, user string) (PullRequest, error) {
+	targetRef := "refs/heads/" + pr.Target
+
+	for attempt := 0; attempt < 3; attempt++ {
+		tip, err := q.storage.ResolveCommit(owner, repo, targetRef)
+		if err != nil {
+			return pr, fmt.Errorf("target branch %s: %w", pr.Target, err)
+		}
+		head, err := q.pulls.Head(owner, repo, pr)
+		if err != nil {
+			return pr, err
+		}
+
+		commit := tip
+		if !q.storage.IsAncestor(owner, repo, head, tip) {
+			from := pr.Source
+			if pr.SourceRepo != "" {
+				from = pr.SourceRepo + ":" + pr.Source
+			}
+			message := fmt.Sprintf("Merge pull request #%d from %s\n\n%s", pr.Number, from, pr.Title)
+			commit, err = q.storage.MergeCommit(owner, repo, tip, head, message, user)
+			if err != nil {
+				return pr, err
+			}
+
+			if err := q.storage.UpdateRef(owner, repo, targetRef, commit, tip); err != nil {
+				log.Printf("pulls: %s moved while merging #%d, retrying", targetRef, pr.Number)
+				continue
+			}
+		}
+
+		merged, err := q.pulls.Update(owner, repo, pr.Number, func(pr *PullRequest) error {
+			if pr.State != StateOpen {
+				return ErrNotOpen
+			}
+			now := time.Now()
+			pr.State = StateMerged
+			pr.MergeCommit = commit
+			pr.MergedAt = &now
+			pr.MergedBy = user
+			pr.QueuedAt = nil
+			pr.QueuedBy = ""
+			return nil
+		})
+		if err != nil {
+			return pr, err
+		}
+
+		log.Printf("pulls: merged %s/%s #%d into %s", owner, repo, pr.Number, pr.Target)
+
+		q.retarget(owner, repo, pr)
+		if q.onMerge != nil {
+			q.onMerge(owner, repo)
+		}
+		return merged, nil
+	}
+
+	return pr, fmt.Errorf("%s kept moving; giving up", targetRef)
+}
+
+// Close closes an open pull request, taking it out of the merge queue and
+// moving pull requests stacked on it onto its target.
+func (q *MergeQueue) Close(owner, repo string, number int, user string) (PullRequest, error) {
+	wasQueued := false
+	pr, err := q.pulls.Update(owner, repo, number, func(pr *PullRequest) error {
+		if pr.State != StateOpen {
+			return ErrNotOpen
+		}
+		wasQueued = pr.QueuedAt != nil
+		now := time.Now()
+		pr.State = StateClosed
+		pr.ClosedAt = &now
+		pr.ClosedBy = user
+		pr.QueuedAt = nil
+		pr.QueuedBy = ""
+		return nil
+	})
+	if err != nil {
+		return pr, err
+	}
+
+	if wasQueued {
+		q.remove(queueKey{owner, repo, pr.Target}, number)
+	}
+	q.retarget(owner, repo, pr)
+	return pr, nil
+}
+
+func (q *MergeQueue) retarget(owner, repo string, base PullRequest) {
+	retargeted, err := q.pulls.Retarget(owner, repo, base)
+	if err != nil {
+		log.Printf("pulls: retarget pull requests stacked on #%d: %v", base.Number, err)
+	}
+	for _, n := range retargeted {
+		log.Printf("pulls: retargeted %s/%s #%d onto %s", owner, repo, n, base.Target)
+	}
+}
+
+func (q *MergeQueue) reviewRules(owner, repo string) ([]storage.ReviewRule, error) {
+	meta, err := q.storage.GetMetadata(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Policy == nil {
+		return nil, nil
+	}
+	return meta.Policy.ReviewRules, nil
+}
+
+func (q *MergeQueue) fail(key queueKey, number int, cause error) {
+	_, err := q.pulls.Update(key.owner, key.repo, number, func(pr *PullRequest) error {
+		pr.QueuedAt = nil
+		pr.QueuedBy = ""
+		pr.QueueError = cause.Error()
+		return nil
+	})
+	if err != nil {
+		log.Printf("merge queue: record failure for #%d: %v", number, err)
+	}
+}
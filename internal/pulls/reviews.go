@@ -0,0 +1,203 @@
+package pulls
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	ReviewApproved         = "approved"
+	ReviewChangesRequested = "changes_requested"
+	ReviewCommented        = "commented"
+)
+
+var (
+	ErrOwnReview      = errors.New("authors can't approve or request changes on their own pull request")
+	ErrReviewRequired = errors.New("required reviews missing")
+)
+
+type Review struct {
+	ID     int    `json:"id"`
+	Author string `json:"author"`
+	State  string `json:"state"`
+	Body   string `json:"body,omitempty"`
+	// Commit is the source branch head the review was made against.
+	Commit    string          `json:"commit"`
+	Comments  []ReviewComment `json:"comments,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ReviewComment is an inline comment anchored to a line of a file's diff.
+// Position counts lines from the first hunk header of that file's diff, so
+// line 1 is the first line below it; later hunk headers count as lines too.
+//
+// A comment may carry a suggestion: replacement text for the line it is
+// anchored to, which the pull request's author or the repository owner can
+// apply as a commit. Line and Original record the new-file line number and
+// its content when the suggestion was made.
+type ReviewComment struct {
+	Path     string `json:"path"`
+	Position int    `json:"position"`
+	Body     string `json:"body"`
+
+	Suggestion    *string `json:"suggestion,omitempty"`
+	Line          int     `json:"line,omitempty"`
+	Original      string  `json:"original,omitempty"`
+	AppliedCommit string  `json:"applied_commit,omitempty"`
+}
+
+func ValidReviewState(state string) bool {
+	return state == ReviewApproved || state == ReviewChangesRequested || state == ReviewCommented
+}
+
+// AddReview records a review on an open pull request.
+func (s *Store) AddReview(owner, repo string, number int, review Review) (Review, error) {
+	_, err := s.Update(owner, repo, number, func(pr *PullRequest) error {
+		if pr.State != StateOpen {
+			return ErrNotOpen
+		}
+		if review.Author == pr.Author && review.State != ReviewCommented {
+			return ErrOwnReview
+		}
+		review.ID = len(pr.Reviews) + 1
+		review.CreatedAt = time.Now()
+		pr.Reviews = append(pr.Reviews, review)
+		return nil
+	})
+	return review, err
+}
+
+// DiffPositions returns the number of commentable positions in a single
+// file's unified diff.
+func DiffPositions(diff string) int {
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "@@") {
+			return len(lines) - i - 1
+		}
+	}
+	return 0
+}
+
+// DiffLine maps a diff position to the line number it has in the new version
+// of the file. It returns false for removed lines and hunk headers, which
+// have no line there.
+func DiffLine(diff string, position int) (int, bool) {
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+
+	pos := 0
+	line := 0
+	inHunk := false
+	for _, l := range lines {
+		if strings.HasPrefix(l, "@@") {
+			var oldStart, newStart int
+			if _, err := fmt.Sscanf(hunkRange(l), "-%d +%d", &oldStart, &newStart); err != nil {
+				return 0, false
+			}
+			line = newStart
+			if inHunk {
+				pos++
+				if pos == position {
+					return 0, false
+				}
+			}
+			inHunk = true
+			continue
+		}
+		if !inHunk {
+			continue
+		}
+
+		pos++
+		switch {
+		case strings.HasPrefix(l, "+"), strings.HasPrefix(l, " "):
+			if pos == position {
+				return line, true
+			}
+			line++
+		case pos == position:
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// hunkRange strips the counts from a hunk header, turning
+// "@@ -3,4 +3,5 @@ func" into "-3 +3".
+func hunkRange(header string) string {
+	fields := strings.Fields(header)
+	if len(fields) < 3 {
+		return ""
+	}
+	oldStart, _, _ := strings.Cut(fields[1], ",")
+	newStart, _, _ := strings.Cut(fields[2], ",")
+	return oldStart + " " + newStart
+}
+
+// ReviewStates returns each reviewer's latest approving or change-requesting
+// review state. Comment-only reviews don't change a reviewer's state.
+func ReviewStates(pr PullRequest) map[string]string {
+	states := make(map[string]string)
+	for _, review := range pr.Reviews {
+		if review.State != ReviewCommented {
+			states[review.Author] = review.State
+		}
+	}
+	return states
+}
+
+// CheckReviews returns an error wrapping ErrReviewRequired if the pull request
+// doesn't satisfy every rule matching its target branch. Where any rule
+// applies, an outstanding change request also blocks the merge.
+func CheckReviews(pr PullRequest, rules []storage.ReviewRule) error {
+	ref := "refs/heads/" + pr.Target
+	states := ReviewStates(pr)
+
+	var problems []string
+	applied := false
+	for _, rule := range rules {
+		if !storage.MatchRef(rule.Branch, ref) {
+			continue
+		}
+		applied = true
+
+		approvals := 0
+		for _, state := range states {
+			if state == ReviewApproved {
+				approvals++
+			}
+		}
+		if approvals < rule.RequiredApprovals {
+			problems = append(problems, fmt.Sprintf("%d of %d required approvals", approvals, rule.RequiredApprovals))
+		}
+
+		for _, reviewer := range rule.RequiredReviewers {
+			if states[reviewer] != ReviewApproved {
+				problems = append(problems, fmt.Sprintf("approval from %s", reviewer))
+			}
+		}
+	}
+
+	if applied {
+		var requesters []string
+		for reviewer, state := range states {
+			if state == ReviewChangesRequested {
+				requesters = append(requesters, reviewer)
+			}
+		}
+		sort.Strings(requesters)
+		for _, reviewer := range requesters {
+			problems = append(problems, fmt.Sprintf("changes requested by %s", reviewer))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrReviewRequired, strings.Join(problems, ", "))
+	}
+	return nil
+}
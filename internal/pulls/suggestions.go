@@ -0,0 +1,125 @@
+package pulls
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/policy"
+)
+
+var (
+	ErrCommentNotFound    = errors.New("review comment not found")
+	ErrNoSuggestion       = errors.New("review comment has no suggestion")
+	ErrSuggestionApplied  = errors.New("suggestion has already been applied")
+	ErrSuggestionOutdated = errors.New("the suggested line has changed since the review")
+	ErrPolicyViolation    = errors.New("push policy violation")
+)
+
+// ApplySuggestion commits a review comment's suggestion to the pull request's
+// source branch as user and returns the new commit. The commit is subject to
+// the push policy of the repository holding the branch, which for a pull
+// request from a fork is the fork, like any push to that branch.
+func (s *Store) ApplySuggestion(owner, repo string, number, reviewID, commentIndex int, user, message string) (string, error) {
+	pr, err := s.Get(owner, repo, number)
+	if err != nil {
+		return "", err
+	}
+	if pr.State != StateOpen {
+		return "", ErrNotOpen
+	}
+
+	comment, err := findComment(pr, reviewID, commentIndex)
+	if err != nil {
+		return "", err
+	}
+	if comment.Suggestion == nil {
+		return "", ErrNoSuggestion
+	}
+	if comment.AppliedCommit != "" {
+		return "", ErrSuggestionApplied
+	}
+
+	srcOwner, srcRepo := pr.SourceLocation(owner, repo)
+	ref := "refs/heads/" + pr.Source
+	head, err := s.storage.ResolveCommit(srcOwner, srcRepo, ref)
+	if err != nil {
+		return "", fmt.Errorf("source branch %s: %w", pr.Source, err)
+	}
+
+	content, err := s.storage.ReadFile(srcOwner, srcRepo, head, comment.Path)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.SplitAfter(string(content), "\n")
+	i := comment.Line - 1
+	if i < 0 || i >= len(lines) || strings.TrimRight(lines[i], "\r\n") != comment.Original {
+		return "", ErrSuggestionOutdated
+	}
+
+	ending := lines[i][len(strings.TrimRight(lines[i], "\r\n")):]
+	replacement := ""
+	if *comment.Suggestion != "" {
+		replacement = strings.TrimSuffix(*comment.Suggestion, "\n") + ending
+	}
+	lines[i] = replacement
+
+	if message == "" {
+		message = fmt.Sprintf("Apply suggestion to %s from review %d on #%d", comment.Path, reviewID, number)
+	}
+
+	commit, err := s.storage.CommitFiles(srcOwner, srcRepo, head,
+		map[string][]byte{comment.Path: []byte(strings.Join(lines, ""))}, message, user)
+	if err != nil {
+		return "", err
+	}
+
+	meta, err := s.storage.GetMetadata(srcOwner, srcRepo)
+	if err != nil {
+		return "", err
+	}
+	if meta.Policy != nil {
+		violations, err := policy.Check(s.storage.RepoPath(srcOwner, srcRepo), *meta.Policy,
+			[]policy.RefUpdate{{Old: head, New: commit, Ref: ref}})
+		if err != nil {
+			return "", err
+		}
+		if len(violations) > 0 {
+			return "", fmt.Errorf("%w: %s", ErrPolicyViolation, strings.Join(violations, "; "))
+		}
+	}
+
+	if err := s.storage.UpdateRef(srcOwner, srcRepo, ref, commit, head); err != nil {
+		return "", fmt.Errorf("%s moved while applying the suggestion: %w", pr.Source, err)
+	}
+
+	_, err = s.Update(owner, repo, number, func(pr *PullRequest) error {
+		c, err := findComment(*pr, reviewID, commentIndex)
+		if err != nil {
+			return err
+		}
+		c.AppliedCommit = commit
+		return nil
+	})
+	if err != nil {
+		return commit, err
+	}
+
+	return commit, nil
+}
+
+// findComment returns a pointer into pr's reviews, so callers holding a
+// pointer to the pull request can edit the comment in place.
+func findComment(pr PullRequest, reviewID, index int) (*ReviewComment, error) {
+	for i := range pr.Reviews {
+		if pr.Reviews[i].ID != reviewID {
+			continue
+		}
+		if index < 0 || index >= len(pr.Reviews[i].Comments) {
+			break
+		}
+		return &pr.Reviews[i].Comments[index], nil
+	}
+	return nil, ErrCommentNotFound
+}
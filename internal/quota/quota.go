@@ -0,0 +1,320 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// Defaults for limits that leave a threshold unset.
+const (
+	DefaultWarnPercent = 90
+	DefaultGraceDays   = 7
+)
+
+const (
+	LevelOK       = "ok"
+	LevelWarning  = "warning"
+	LevelGrace    = "grace"
+	LevelEnforced = "enforced"
+)
+
+// Limits are an owner's disk thresholds. Zero fields inherit the instance
+// default; a negative MaxMB makes the owner unlimited.
+type Limits struct {
+	MaxMB       int64 `json:"max_mb,omitempty"`
+	WarnPercent int   `json:"warn_percent,omitempty"`
+	GraceDays   int   `json:"grace_days,omitempty"`
+}
+
+// Settings are the instance default and any per-owner overrides. They are
+// written by the admin CLI and read by the server and the push hooks.
+type Settings struct {
+	Default Limits            `json:"default"`
+	Owners  map[string]Limits `json:"owners,omitempty"`
+}
+
+// For returns the effective limits for owner.
+func (s Settings) For(owner string) Limits {
+	l := s.Default
+	if o, ok := s.Owners[owner]; ok {
+		if o.MaxMB != 0 {
+			l.MaxMB = o.MaxMB
+		}
+		if o.WarnPercent != 0 {
+			l.WarnPercent = o.WarnPercent
+		}
+		if o.GraceDays != 0 {
+			l.GraceDays = o.GraceDays
+		}
+	}
+	if l.WarnPercent == 0 {
+		l.WarnPercent = DefaultWarnPercent
+	}
+	if l.GraceDays == 0 {
+		l.GraceDays = DefaultGraceDays
+	}
+	return l
+}
+
+type Status struct {
+	Owner      string     `json:"owner"`
+	UsageBytes int64      `json:"usage_bytes"`
+	LimitBytes int64      `json:"limit_bytes,omitempty"`
+	Limits     Limits     `json:"limits"`
+	Level      string     `json:"level"`
+	ExceededAt *time.Time `json:"exceeded_at,omitempty"`
+	GraceEnds  *time.Time `json:"grace_ends,omitempty"`
+	// Message is a one-line notice suitable for a banner or push output.
+	Message string `json:"message,omitempty"`
+}
+
+// Store keeps quota settings and grace-period state next to the repositories.
+// Settings and state are separate files because different processes write
+// them: the admin CLI the former, the server's monitor the latter.
+type Store struct {
+	storage      *storage.Storage
+	settingsPath string
+	statePath    string
+	mu           sync.Mutex
+}
+
+func New(storagePath string, store *storage.Storage) *Store {
+	return &Store{
+		storage:      store,
+		settingsPath: filepath.Join(storagePath, "quotas.json"),
+		statePath:    filepath.Join(storagePath, "quota-state.json"),
+	}
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (q *Store) Settings() (Settings, error) {
+	var s Settings
+	if err := readJSON(q.settingsPath, &s); err != nil {
+		return Settings{}, fmt.Errorf("read quota settings: %w", err)
+	}
+	return s, nil
+}
+
+// SetLimits replaces the limits for owner, or the instance default when owner
+// is empty. Setting an owner's limits to the zero value removes its override.
+func (q *Store) SetLimits(owner string, l Limits) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s, err := q.Settings()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case owner == "":
+		s.Default = l
+	case l == Limits{}:
+		delete(s.Owners, owner)
+	default:
+		if s.Owners == nil {
+			s.Owners = make(map[string]Limits)
+		}
+		s.Owners[owner] = l
+	}
+
+	if err := writeJSON(q.settingsPath, s); err != nil {
+		return fmt.Errorf("write quota settings: %w", err)
+	}
+	return nil
+}
+
+// state maps an owner to when they first went over their limit.
+func (q *Store) state() (map[string]time.Time, error) {
+	state := make(map[string]time.Time)
+	if err := readJSON(q.statePath, &state); err != nil {
+		return nil, fmt.Errorf("read quota state: %w", err)
+	}
+	return state, nil
+}
+
+// Status measures owner's usage and reports where it stands, without
+// changing any state. An owner over their limit whose grace period the
+// monitor hasn't started yet is reported as entering it now.
+func (q *Store) Status(owner string) (Status, error) {
+	settings, err := q.Settings()
+	if err != nil {
+		return Status{}, err
+	}
+	state, err := q.state()
+	if err != nil {
+		return Status{}, err
+	}
+	usage, err := q.storage.OwnerSize(owner)
+	if err != nil {
+		return Status{}, err
+	}
+	return evaluate(owner, usage, settings.For(owner), state[owner], time.Now()), nil
+}
+
+func evaluate(owner string, usage int64, l Limits, exceededAt, now time.Time) Status {
+	st := Status{Owner: owner, UsageBytes: usage, Limits: l, Level: LevelOK}
+	if l.MaxMB <= 0 {
+		return st
+	}
+	st.LimitBytes = l.MaxMB << 20
+
+	if usage <= st.LimitBytes {
+		if usage*100 >= st.LimitBytes*int64(l.WarnPercent) {
+			st.Level = LevelWarning
+			st.Message = fmt.Sprintf("%s is using %s of %s (%d%%)", owner,
+				formatBytes(usage), formatBytes(st.LimitBytes), usage*100/st.LimitBytes)
+		}
+		return st
+	}
+
+	if exceededAt.IsZero() {
+		exceededAt = now
+	}
+	graceEnds := exceededAt.AddDate(0, 0, l.GraceDays)
+	st.ExceededAt = &exceededAt
+	st.GraceEnds = &graceEnds
+
+	if now.Before(graceEnds) {
+		st.Level = LevelGrace
+		st.Message = fmt.Sprintf("%s is over its %s quota (%s used); pushes will be rejected after %s unless usage drops",
+			owner, formatBytes(st.LimitBytes), formatBytes(usage), graceEnds.UTC().Format("2006-01-02 15:04 MST"))
+	} else {
+		st.Level = LevelEnforced
+		st.Message = fmt.Sprintf("%s is over its %s quota (%s used) and the grace period has ended; pushes are rejected until usage drops",
+			owner, formatBytes(st.LimitBytes), formatBytes(usage))
+	}
+	return st
+}
+
+// Check measures owner's usage and starts or clears their grace period, so
+// it runs from the time the monitor first saw them over the limit.
+func (q *Store) Check(owner string) (Status, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	st, err := q.Status(owner)
+	if err != nil {
+		return Status{}, err
+	}
+
+	state, err := q.state()
+	if err != nil {
+		return Status{}, err
+	}
+
+	over := st.ExceededAt != nil
+	recorded, ok := state[owner]
+	switch {
+	case over && !ok:
+		state[owner] = *st.ExceededAt
+	case !over && ok:
+		delete(state, owner)
+	default:
+		return st, nil
+	}
+
+	if over {
+		log.Printf("quota: %s", st.Message)
+	} else {
+		log.Printf("quota: %s is back under its limit (over since %s)", owner, recorded.Format(time.RFC3339))
+	}
+
+	if err := writeJSON(q.statePath, state); err != nil {
+		return Status{}, fmt.Errorf("write quota state: %w", err)
+	}
+	return st, nil
+}
+
+// CheckAll checks every owner with repositories or a running grace period.
+func (q *Store) CheckAll() ([]Status, error) {
+	repos, err := q.storage.ListRepos()
+	if err != nil {
+		return nil, err
+	}
+	state, err := q.state()
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string]bool)
+	for _, repo := range repos {
+		owners[repo.Owner] = true
+	}
+	for owner := range state {
+		owners[owner] = true
+	}
+
+	names := make([]string, 0, len(owners))
+	for owner := range owners {
+		names = append(names, owner)
+	}
+	sort.Strings(names)
+
+	var result []Status
+	for _, owner := range names {
+		st, err := q.Check(owner)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, st)
+	}
+	return result, nil
+}
+
+// StartMonitor re-checks every owner at interval, logging owners that go over
+// or drop back under their limit.
+func (q *Store) StartMonitor(interval time.Duration) {
+	go func() {
+		if _, err := q.CheckAll(); err != nil {
+			log.Printf("quota check failed: %v", err)
+		}
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			if _, err := q.CheckAll(); err != nil {
+				log.Printf("quota check failed: %v", err)
+			}
+		}
+	}()
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	default:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+}
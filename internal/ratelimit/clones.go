@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/metrics"
+)
+
+var clonesRejected = metrics.NewCounter("openhub_ratelimit_anonymous_clones_rejected_total", "Anonymous clones and fetches rejected for having too many in progress from one IP.")
+
+// cloneState is one client IP's clones in progress and its share of the
+// bandwidth limit.
+type cloneState struct {
+	active int
+	tokens float64
+	last   time.Time
+}
+
+// Clones limits how many clones and fetches each anonymous client IP can
+// have in progress at once, and how fast the packs they share are sent.
+// Zero for either disables that limit.
+type Clones struct {
+	mu          sync.Mutex
+	perIP       int
+	bytesPerSec float64
+	ips         map[string]*cloneState
+}
+
+func NewClones(perIP, kbPerSec int) *Clones {
+	c := &Clones{ips: make(map[string]*cloneState)}
+	c.SetLimits(perIP, kbPerSec)
+	return c
+}
+
+// SetLimits changes the limits; clones in progress keep going, and are
+// sent at the new rate.
+func (c *Clones) SetLimits(perIP, kbPerSec int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.perIP = perIP
+	c.bytesPerSec = float64(kbPerSec) * 1024
+}
+
+// Acquire starts a clone from ip, returning the function that ends it, or
+// false if ip already has as many in progress as it's allowed.
+func (c *Clones) Acquire(ip string) (func(), bool) {
+	if c == nil {
+		return func() {}, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.ips[ip]
+	if !ok {
+		st = &cloneState{tokens: c.bytesPerSec, last: time.Now()}
+		c.ips[ip] = st
+	}
+	if c.perIP > 0 && st.active >= c.perIP {
+		clonesRejected.Inc()
+		return nil, false
+	}
+	st.active++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if st.active--; st.active == 0 {
+				delete(c.ips, ip)
+			}
+		})
+	}, true
+}
+
+// Writer returns w slowed so everything written through it and the other
+// writers for ip's clones in progress goes no faster than the bandwidth
+// limit. It must only be used between Acquire and the release it returns.
+func (c *Clones) Writer(ip string, w io.Writer) io.Writer {
+	if c == nil {
+		return w
+	}
+	return &cloneWriter{clones: c, ip: ip, w: w}
+}
+
+type cloneWriter struct {
+	clones *Clones
+	ip     string
+	w      io.Writer
+}
+
+func (cw *cloneWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := cw.clones.wait(cw.ip, len(p))
+		m, err := cw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait takes up to n bytes of ip's allowance, no more than a second's
+// worth, sleeping until the allowance covers them, and returns how many it
+// took.
+func (c *Clones) wait(ip string, n int) int {
+	c.mu.Lock()
+	st := c.ips[ip]
+	if c.bytesPerSec <= 0 || st == nil {
+		c.mu.Unlock()
+		return n
+	}
+
+	n = min(n, int(math.Max(c.bytesPerSec, 1)))
+	now := time.Now()
+	st.tokens = math.Min(c.bytesPerSec, st.tokens+now.Sub(st.last).Seconds()*c.bytesPerSec)
+	st.last = now
+	st.tokens -= float64(n)
+	var delay time.Duration
+	if st.tokens < 0 {
+		delay = time.Duration(-st.tokens / c.bytesPerSec * float64(time.Second))
+	}
+	c.mu.Unlock()
+
+	time.Sleep(delay)
+	return n
+}
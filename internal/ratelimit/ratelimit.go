@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/metrics"
+)
+
+var (
+	anonRejected = metrics.NewCounter("openhub_ratelimit_anonymous_rejected_total", "Expensive anonymous requests rejected by quota.")
+	authRejected = metrics.NewCounter("openhub_ratelimit_authenticated_rejected_total", "Expensive authenticated requests rejected by quota.")
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a per-key token bucket: rate tokens are added per minute, up to
+// burst. A zero rate disables limiting.
+type Limiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func New(perMinute float64, burst int) *Limiter {
+	l := &Limiter{buckets: make(map[string]*bucket)}
+	l.SetRate(perMinute, burst)
+	return l
+}
+
+// SetRate changes the limiter's rate and burst. Buckets keep the tokens
+// they have, up to the new burst.
+func (l *Limiter) SetRate(perMinute float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = perMinute / 60
+	l.burst = float64(burst)
+}
+
+// Allow takes a token for key, or reports how long until one is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type TokenValidator interface {
+	ValidateAPIToken(token, ip string) (string, error)
+}
+
+// ExpensivePaths lists API endpoints that are quota-limited in addition to
+// git fetches. Matching is by prefix, after /api/v1 or else after /api, so
+// "/v3" is the whole GitHub-compatible API.
+var ExpensivePaths = []string{
+	"/repos/list",
+	"/repos/replication-status",
+	// Each of these runs git against the repository.
+	"/repos/blob",
+	"/repos/tree",
+	"/repos/commits",
+	"/v3",
+	// Limits guessing at recovery codes and invites.
+	"/users/recover",
+	"/users/register",
+}
+
+func IsExpensive(r *http.Request) bool {
+	if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/git-upload-pack") {
+		return true
+	}
+	if strings.Contains(r.URL.Path, "/archive/") {
+		return true
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v1")
+	if !ok {
+		path, ok = strings.CutPrefix(r.URL.Path, "/api")
+	}
+	if !ok {
+		return false
+	}
+	for _, p := range ExpensivePaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware applies the anonymous quota per client IP and the authenticated
+// quota per user to expensive requests. Requests with credentials that don't
+// validate are treated as anonymous; the handler still rejects them.
+func Middleware(validator TokenValidator, anon, authed *Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IsExpensive(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if username := requestUser(validator, r); username != "" {
+				if ok, wait := authed.Allow("user:" + username); !ok {
+					authRejected.Inc()
+					tooManyRequests(w, wait)
+					return
+				}
+			} else {
+				if ok, wait := anon.Allow("ip:" + clientip.FromRequest(r)); !ok {
+					anonRejected.Inc()
+					tooManyRequests(w, wait)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func requestUser(validator TokenValidator, r *http.Request) string {
+	token := ""
+	if user, pass, ok := r.BasicAuth(); ok && user != "" {
+		token = pass
+	} else if parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(parts) == 2 && parts[0] == "Bearer" {
+		token = parts[1]
+	}
+	if token == "" {
+		return ""
+	}
+
+	username, err := validator.ValidateAPIToken(token, clientip.FromRequest(r))
+	if err != nil {
+		return ""
+	}
+	return username
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testTokens struct{}
+
+func (testTokens) ValidateAPIToken(token, ip string) (string, error) {
+	if token == "alice-token" {
+		return "alice", nil
+	}
+	return "", errors.New("invalid token")
+}
+
+func TestIsExpensive(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{"GET", "/api/v1/repos/list", true},
+		{"GET", "/api/repos/list", true},
+		{"GET", "/api/v1/repos/blob?owner=alice&name=r&path=README", true},
+		{"GET", "/api/v1/repos/tree?owner=alice&name=r", true},
+		{"GET", "/api/v1/repos/commits?owner=alice&name=r", true},
+		{"GET", "/api/repos/commits?owner=alice&name=r", true},
+		{"GET", "/api/v3/repos/alice/r", true},
+		{"GET", "/api/v3/user/repos", true},
+		{"POST", "/alice/r.git/git-upload-pack", true},
+		{"GET", "/alice/r/archive/main.tar.gz", true},
+
+		{"GET", "/api/v1/repos/metadata?owner=alice&name=r", false},
+		{"GET", "/alice/r.git/info/refs", false},
+		{"GET", "/v3/repos/alice/r", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := IsExpensive(r); got != tt.want {
+			t.Errorf("%s %s: expensive %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+// get makes a GET request for path through handler, with alice's token if
+// authed, and returns the response's status.
+func get(t *testing.T, handler http.Handler, path string, authed bool) int {
+	t.Helper()
+	r := httptest.NewRequest("GET", path, nil)
+	if authed {
+		r.Header.Set("Authorization", "Bearer alice-token")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+		t.Errorf("GET %s: 429 without Retry-After", path)
+	}
+	return w.Code
+}
+
+// Browsing a repository's files and history and the GitHub-compatible API run
+// git, so anonymous clients and users get only their burst before a 429.
+func TestMiddlewareQuota(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, path := range []string{
+		"/api/v1/repos/blob?owner=alice&name=r&path=README",
+		"/api/v1/repos/tree?owner=alice&name=r",
+		"/api/v1/repos/commits?owner=alice&name=r",
+		"/api/v3/repos/alice/r",
+	} {
+		handler := Middleware(testTokens{}, New(1, 2), New(1, 3))(ok)
+
+		for i, tt := range []struct {
+			authed bool
+			want   int
+		}{
+			{false, http.StatusOK},
+			{false, http.StatusOK},
+			{false, http.StatusTooManyRequests},
+			{true, http.StatusOK},
+			{true, http.StatusOK},
+			{true, http.StatusOK},
+			{true, http.StatusTooManyRequests},
+		} {
+			if code := get(t, handler, path, tt.authed); code != tt.want {
+				t.Errorf("GET %s, request %d (authenticated %v): got %d, want %d", path, i+1, tt.authed, code, tt.want)
+			}
+		}
+
+		if code := get(t, handler, "/api/v1/repos/metadata?owner=alice&name=r", false); code != http.StatusOK {
+			t.Errorf("GET metadata after %s used the quota: got %d, want %d", path, code, http.StatusOK)
+		}
+	}
+}
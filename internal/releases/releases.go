@@ -0,0 +1,217 @@
+package releases
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	MaxTitleLength = 256
+	MaxBodyLength  = 64 << 10
+)
+
+var (
+	ErrNotFound      = errors.New("release not found")
+	ErrAlreadyExists = errors.New("release already exists")
+	ErrAssetNotFound = errors.New("asset not found")
+)
+
+// Release publishes a tag, with notes and any uploaded assets. Assets are not
+// recorded in the release; they are whatever files have been uploaded for its
+// tag.
+type Release struct {
+	Tag    string `json:"tag"`
+	Title  string `json:"title"`
+	Body   string `json:"body,omitempty"`
+	Commit string `json:"commit"`
+	Author string `json:"author"`
+
+	Prerelease bool `json:"prerelease,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	Assets []Asset `json:"assets"`
+}
+
+type Asset struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store keeps each release as release.json in <repo>.git/releases/<tag>,
+// next to the assets directory uploads are published into.
+type Store struct {
+	storage *storage.Storage
+	mu      sync.Mutex
+}
+
+func NewStore(store *storage.Storage) *Store {
+	return &Store{storage: store}
+}
+
+func (s *Store) dir(owner, repo string) string {
+	return filepath.Join(s.storage.RepoPath(owner, repo), "releases")
+}
+
+func (s *Store) path(owner, repo, tag string) string {
+	return filepath.Join(s.dir(owner, repo), tag, "release.json")
+}
+
+// Create records a release for an existing tag, pinned to the commit the tag
+// points at now.
+func (s *Store) Create(owner, repo string, release Release) (Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.path(owner, repo, release.Tag)); err == nil {
+		return release, ErrAlreadyExists
+	}
+
+	commit, err := s.storage.ResolveCommit(owner, repo, "refs/tags/"+release.Tag)
+	if err != nil {
+		return release, fmt.Errorf("tag %s: %w", release.Tag, err)
+	}
+	release.Commit = commit
+	release.CreatedAt = time.Now()
+	release.Assets = nil
+
+	if err := os.MkdirAll(filepath.Dir(s.path(owner, repo, release.Tag)), 0755); err != nil {
+		return release, fmt.Errorf("create release dir: %w", err)
+	}
+
+	data, err := json.MarshalIndent(release, "", "  ")
+	if err != nil {
+		return release, fmt.Errorf("marshal release: %w", err)
+	}
+
+	path := s.path(owner, repo, release.Tag)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return release, fmt.Errorf("write release: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return release, fmt.Errorf("write release: %w", err)
+	}
+
+	return s.withAssets(owner, repo, release)
+}
+
+func (s *Store) Get(owner, repo, tag string) (Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(owner, repo, tag)
+}
+
+// List returns the repo's releases, newest first.
+func (s *Store) List(owner, repo string) ([]Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir(owner, repo))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read releases dir: %w", err)
+	}
+
+	var releases []Release
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		release, err := s.read(owner, repo, e.Name())
+		if errors.Is(err, ErrNotFound) {
+			// Assets uploaded before releases were recorded.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+
+	sort.Slice(releases, func(i, j int) bool { return releases[i].CreatedAt.After(releases[j].CreatedAt) })
+	return releases, nil
+}
+
+// Delete removes a release along with its assets. The tag is left alone.
+func (s *Store) Delete(owner, repo, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.path(owner, repo, tag)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("stat release: %w", err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(s.dir(owner, repo), tag)); err != nil {
+		return fmt.Errorf("remove release: %w", err)
+	}
+	return nil
+}
+
+// DeleteAsset removes one uploaded asset from a release.
+func (s *Store) DeleteAsset(owner, repo, tag, asset string) error {
+	err := os.Remove(s.storage.ReleaseAssetPath(owner, repo, tag, asset))
+	if os.IsNotExist(err) {
+		return ErrAssetNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("remove asset: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) read(owner, repo, tag string) (Release, error) {
+	var release Release
+
+	data, err := os.ReadFile(s.path(owner, repo, tag))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return release, ErrNotFound
+		}
+		return release, fmt.Errorf("read release: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &release); err != nil {
+		return release, fmt.Errorf("unmarshal release: %w", err)
+	}
+	return s.withAssets(owner, repo, release)
+}
+
+func (s *Store) withAssets(owner, repo string, release Release) (Release, error) {
+	release.Assets = []Asset{}
+
+	entries, err := os.ReadDir(s.storage.ReleaseAssetsDir(owner, repo, release.Tag))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return release, nil
+		}
+		return release, fmt.Errorf("read release assets: %w", err)
+	}
+
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		release.Assets = append(release.Assets, Asset{
+			Name:      e.Name(),
+			Size:      info.Size(),
+			UpdatedAt: info.ModTime(),
+		})
+	}
+	return release, nil
+}
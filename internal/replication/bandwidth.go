@@ -0,0 +1,167 @@
+package replication
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucket is a byte-rate token bucket holding at most a second's worth. A
+// zero rate is unlimited.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) setRate(kbPerSec int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = float64(kbPerSec) * 1024
+	b.tokens = math.Min(b.tokens, b.rate)
+}
+
+func (b *bucket) limited() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate > 0
+}
+
+// chunk returns how many bytes can usefully be sent before waiting, at most
+// n.
+func (b *bucket) chunk(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return n
+	}
+	return min(n, int(math.Max(b.rate, 1)))
+}
+
+// wait takes n bytes from the bucket, sleeping until it has them.
+func (b *bucket) wait(n int) {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// bandwidth caps how fast bundles are uploaded to replicas, in total and
+// to each replica, so replication doesn't take a small link's capacity
+// away from clones.
+type bandwidth struct {
+	mu      sync.Mutex
+	total   bucket
+	perPeer int
+	rates   map[string]int
+	peers   map[string]*bucket
+}
+
+// limited reports whether any cap is set.
+func (bw *bandwidth) limited() bool {
+	if bw.total.limited() {
+		return true
+	}
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return bw.perPeer > 0 || len(bw.rates) > 0
+}
+
+// peer returns the bucket for the replica at url.
+func (bw *bandwidth) peer(url string) *bucket {
+	url = strings.TrimSuffix(url, "/")
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	b, ok := bw.peers[url]
+	if !ok {
+		b = &bucket{}
+		bw.peers[url] = b
+	}
+	rate, ok := bw.rates[url]
+	if !ok {
+		rate = bw.perPeer
+	}
+	b.setRate(rate)
+	return b
+}
+
+// reader returns r slowed to the caps for the replica at url. progress is
+// called after each chunk is sent.
+func (bw *bandwidth) reader(url string, r io.Reader, progress func()) io.Reader {
+	return &throttledReader{total: &bw.total, peer: bw.peer(url), r: r, progress: progress}
+}
+
+type throttledReader struct {
+	total, peer *bucket
+	r           io.Reader
+	progress    func()
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n := t.peer.chunk(t.total.chunk(len(p)))
+	n, err := t.r.Read(p[:n])
+	if n > 0 {
+		t.total.wait(n)
+		t.peer.wait(n)
+		t.progress()
+	}
+	return n, err
+}
+
+// SetBandwidth caps bundle uploads at totalKBps across all replicas, and
+// each replica at peerKBps, or its entry in peerRates, keyed by replica
+// URL. Zero leaves a cap off. Uploads in progress switch to the new caps.
+func (m *Manager) SetBandwidth(totalKBps, peerKBps int, peerRates map[string]int) {
+	m.bandwidth.total.setRate(totalKBps)
+	m.bandwidth.mu.Lock()
+	defer m.bandwidth.mu.Unlock()
+	m.bandwidth.perPeer = peerKBps
+	m.bandwidth.rates = peerRates
+	for url, b := range m.bandwidth.peers {
+		rate, ok := peerRates[url]
+		if !ok {
+			rate = peerKBps
+		}
+		b.setRate(rate)
+	}
+}
+
+// ParsePeerRates reads per-replica caps: a bare number of KB/s applies to
+// every replica, and "<replica-url>=<KB/s>" to one.
+func ParsePeerRates(list []string) (int, map[string]int, error) {
+	perPeer := 0
+	rates := make(map[string]int)
+	for _, entry := range list {
+		url, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			url, value = "", entry
+		}
+		kbps, err := strconv.Atoi(value)
+		if err != nil || kbps < 0 {
+			return 0, nil, fmt.Errorf("invalid replica rate %q, want <KB/s> or <replica-url>=<KB/s>", entry)
+		}
+		if url == "" {
+			perPeer = kbps
+		} else {
+			rates[strings.TrimSuffix(url, "/")] = kbps
+		}
+	}
+	return perPeer, rates, nil
+}
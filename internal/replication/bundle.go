@@ -0,0 +1,123 @@
+package replication
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/jeremytregunna/openhub/internal/zstd"
+)
+
+// bundleFile is a bundle spooled to disk, so a repository bigger than
+// memory can still be replicated. The signature covers its hash, which has
+// to be known before a push starts, so it can't be piped straight into the
+// request. Each push opens it afresh.
+type bundleFile struct {
+	path string
+	size int64
+	sha  string
+}
+
+// spoolBundle writes r to a new file in dir, hashing it on the way.
+func spoolBundle(dir, pattern string, r io.Reader) (*bundleFile, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &bundleFile{path: f.Name(), size: n, sha: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func (b *bundleFile) open() (*os.File, error) {
+	return os.Open(b.path)
+}
+
+func (b *bundleFile) remove() {
+	os.Remove(b.path)
+}
+
+// compress spools a zstd-compressed copy of b next to it.
+func (b *bundleFile) compress() (*bundleFile, error) {
+	f, err := b.open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	compressed, err := zstd.Compress(f)
+	if err != nil {
+		return nil, err
+	}
+	defer compressed.Close()
+	return spoolBundle(filepath.Dir(b.path), "spool-*.bundle.zst", compressed)
+}
+
+// createBundle spools a bundle of refs, or of every ref with all, into the
+// repository's directory, which unlike the temp directory may well be on a
+// disk big enough for it. The caller removes it.
+func (m *Manager) createBundle(owner, repo string, refs map[string]string, all bool) (*bundleFile, error) {
+	repoPath := m.store.RepoPath(owner, repo)
+
+	args := []string{"bundle", "create", "-"}
+	if all {
+		args = append(args, "--all")
+	} else {
+		for ref := range refs {
+			args = append(args, ref)
+		}
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("git bundle: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("git bundle: %w", err)
+	}
+
+	bundle, err := spoolBundle(repoPath, "spool-*.bundle", out)
+	if err != nil {
+		// Stop git blocking on a pipe no one reads any more.
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("spool bundle: %w", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		bundle.remove()
+		return nil, fmt.Errorf("git bundle: %w", err)
+	}
+	return bundle, nil
+}
+
+// removeSpooledBundles deletes the bundles left behind by pushes cut short
+// when the instance last stopped.
+func (m *Manager) removeSpooledBundles() {
+	repos, err := m.store.ListRepos()
+	if err != nil {
+		log.Printf("remove spooled bundles: %v", err)
+		return
+	}
+	for _, repo := range repos {
+		paths, _ := filepath.Glob(filepath.Join(m.store.RepoPath(repo.Owner, repo.Name), "spool-*.bundle*"))
+		for _, path := range paths {
+			os.Remove(path)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// maxLogEntries is how many push attempts are kept per repository.
+const maxLogEntries = 200
+
+// LogEntry records one attempt to push a repository to a replica.
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Replica string    `json:"replica"`
+	// Refs are the refs shipped that had moved since the replica's last
+	// sync.
+	Refs       []storage.RefChange `json:"refs,omitempty"`
+	Bytes      int                 `json:"bytes"`
+	DurationMS int64               `json:"duration_ms"`
+	Forced     bool                `json:"forced,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// changedRefs lists the refs in shipped that synced doesn't already have.
+func changedRefs(synced, shipped map[string]string) []storage.RefChange {
+	var changes []storage.RefChange
+	for ref, sha := range shipped {
+		if synced[ref] != sha {
+			changes = append(changes, storage.RefChange{Ref: ref, Old: synced[ref], New: sha})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Ref < changes[j].Ref })
+	return changes
+}
+
+func (m *Manager) logPath(owner, repo string) string {
+	return filepath.Join(m.store.RepoPath(owner, repo), "replication.jsonl")
+}
+
+// appendLog records a push attempt, trimming the repository's log to its
+// most recent maxLogEntries once it grows to twice that.
+func (m *Manager) appendLog(owner, repo string, entry LogEntry) error {
+	m.logMu.Lock()
+	defer m.logMu.Unlock()
+
+	path := m.logPath(owner, repo)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("open replication log: %w", err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("write replication log: %w", err)
+	}
+
+	entries, err := readLog(path)
+	if err != nil || len(entries) < 2*maxLogEntries {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, e := range entries[len(entries)-maxLogEntries:] {
+		line, _ := json.Marshal(e)
+		buf.Write(append(line, '\n'))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("trim replication log: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func readLog(path string) ([]LogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open replication log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 4<<20)
+	for scanner.Scan() {
+		var e LogEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read replication log: %w", err)
+	}
+	return entries, nil
+}
+
+// Log returns a repository's logged push attempts, newest first,
+// optionally only those to the replica at replicaURL.
+func (m *Manager) Log(owner, repo, replicaURL string) ([]LogEntry, error) {
+	m.logMu.Lock()
+	entries, err := readLog(m.logPath(owner, repo))
+	m.logMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []LogEntry{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if replicaURL == "" || entries[i].Replica == replicaURL {
+			out = append(out, entries[i])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package replication
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// SetPauseFile keeps the instance-wide pause in path, so a pause for a
+// maintenance window outlasts restarts. If path exists, replication starts
+// paused. It must be called before Start.
+func (m *Manager) SetPauseFile(path string) error {
+	m.pauseFile = path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read pause file: %w", err)
+	}
+	since, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		since = time.Now()
+	}
+	m.pauseSince = since
+	m.resumed = make(chan struct{})
+	log.Printf("replication paused since %s", since.Format(time.RFC3339))
+	return nil
+}
+
+// Pause holds all replication until Resume. Jobs keep queuing, and
+// coalescing, while paused; pushes already under way finish.
+func (m *Manager) Pause() error {
+	m.pauseMu.Lock()
+	defer m.pauseMu.Unlock()
+	if m.resumed != nil {
+		return nil
+	}
+	since := time.Now()
+	if m.pauseFile != "" {
+		if err := os.WriteFile(m.pauseFile, []byte(since.UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+			return fmt.Errorf("write pause file: %w", err)
+		}
+	}
+	m.pauseSince = since
+	m.resumed = make(chan struct{})
+	log.Println("replication paused")
+	return nil
+}
+
+// Resume lifts a pause, runs the jobs that queued during it and syncs every
+// repository to catch up on those dropped.
+func (m *Manager) Resume() error {
+	m.pauseMu.Lock()
+	if m.resumed == nil {
+		m.pauseMu.Unlock()
+		return nil
+	}
+	if m.pauseFile != "" {
+		if err := os.Remove(m.pauseFile); err != nil && !os.IsNotExist(err) {
+			m.pauseMu.Unlock()
+			return fmt.Errorf("remove pause file: %w", err)
+		}
+	}
+	close(m.resumed)
+	m.resumed = nil
+	m.pauseSince = time.Time{}
+	m.pauseMu.Unlock()
+
+	log.Println("replication resumed")
+	go m.SyncAll()
+	return nil
+}
+
+// Paused returns when replication was paused, or the zero time if it isn't.
+func (m *Manager) Paused() time.Time {
+	m.pauseMu.Lock()
+	defer m.pauseMu.Unlock()
+	return m.pauseSince
+}
+
+// waitResumed blocks while replication is paused. It returns false if the
+// manager shuts down first.
+func (m *Manager) waitResumed() bool {
+	m.pauseMu.Lock()
+	resumed := m.resumed
+	m.pauseMu.Unlock()
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-m.done:
+		return false
+	}
+}
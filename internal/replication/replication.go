@@ -2,138 +2,838 @@ package replication
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
-	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jeremytregunna/openhub/internal/events"
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/issues"
 	"github.com/jeremytregunna/openhub/internal/storage"
+	"github.com/jeremytregunna/openhub/internal/zstd"
+)
+
+type JobKind int
+
+const (
+	JobSync JobKind = iota
+	JobMetadata
+	JobDelete
+	JobUsers
+	JobIssues
 )
 
 type Job struct {
 	Owner string
 	Repo  string
+	Kind  JobKind
+
+	// Force overwrites refs that have diverged on the replicas (JobSync only).
+	Force bool
+
+	// Replicas is only set for JobDelete, since the repo's metadata is gone
+	// by the time the job runs.
+	Replicas []storage.Replica
 }
 
 type Manager struct {
-	store      *storage.Storage
-	instanceID string
-	queue      chan Job
-	wg         sync.WaitGroup
+	store     *storage.Storage
+	instance  *instance.Instance
+	tlsConfig *tls.Config
+	queue     chan Job
+	wg        sync.WaitGroup
+
+	// stopMu guards stopped, which is set once Shutdown closes the queue
+	// and done.
+	stopMu  sync.RWMutex
+	stopped bool
+	done    chan struct{}
+
+	// resumed is open while replication is paused, from pauseSince. The
+	// pause is kept in pauseFile, if set.
+	pauseMu    sync.Mutex
+	resumed    chan struct{}
+	pauseSince time.Time
+	pauseFile  string
+
+	// pushConcurrency bounds how many replicas one job talks to at once.
+	pushConcurrency int
+
+	httpTimeout time.Duration
+	transport   *http.Transport
+
+	bandwidth bandwidth
+
+	// pending holds the sync, metadata and user jobs waiting in the queue,
+	// so a burst of pushes to one repo collapses into a single job. Jobs
+	// read the repo's state when they run, so the coalesced job still ships
+	// the latest refs.
+	pendingMu sync.Mutex
+	pending   map[jobKey]*Job
+
+	users    UserLister
+	standbys []string
+
+	events EventPublisher
+	issues IssueSource
+	peers  PeerLookup
+
+	// uploads are the chunked bundle uploads open on replicas, by replica
+	// URL and repo.
+	uploadsMu sync.Mutex
+	uploads   map[string]bundleUpload
+
+	statsMu sync.Mutex
+	stats   map[statsKey]*ReplicaStats
+
+	// logMu guards the repositories' replication logs.
+	logMu sync.Mutex
+
+	// running is the job each busy worker is on, by worker number.
+	runningMu sync.Mutex
+	running   map[int]RunningJob
+
+	// syncTicker drives the periodic sync; it is stopped while the
+	// interval is zero.
+	syncTicker *time.Ticker
+}
+
+func NewManager(store *storage.Storage, inst *instance.Instance, tlsConfig *tls.Config) *Manager {
+	m := &Manager{
+		store:     store,
+		instance:  inst,
+		tlsConfig: tlsConfig,
+		queue:     make(chan Job, 100),
+		done:      make(chan struct{}),
+		pending:   make(map[jobKey]*Job),
+		stats:     make(map[statsKey]*ReplicaStats),
+		running:   make(map[int]RunningJob),
+		uploads:   make(map[string]bundleUpload),
+		bandwidth: bandwidth{peers: make(map[string]*bucket)},
+
+		pushConcurrency: 4,
+		httpTimeout:     30 * time.Second,
+	}
+	m.transport = m.newTransport(DefaultTransportOptions)
+	return m
 }
 
-func NewManager(store *storage.Storage, instanceID string) *Manager {
-	return &Manager{
-		store:      store,
-		instanceID: instanceID,
-		queue:      make(chan Job, 100),
+// SetQueueDepth sets how many jobs can wait for a worker before further
+// jobs are dropped. It must be called before Start.
+func (m *Manager) SetQueueDepth(n int) {
+	if n < 1 {
+		n = 1
 	}
+	m.queue = make(chan Job, n)
+}
+
+// SetHTTPTimeout bounds each request to a replica. Bundle uploads may take
+// longer, so long as they never stall for that long.
+func (m *Manager) SetHTTPTimeout(d time.Duration) {
+	m.httpTimeout = d
+}
+
+// SetPushConcurrency sets how many replicas a single job pushes to in
+// parallel.
+func (m *Manager) SetPushConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	m.pushConcurrency = n
+}
+
+// EventPublisher receives an event for each replica a sync finishes with.
+type EventPublisher interface {
+	Publish(e events.Event)
+}
+
+func (m *Manager) SetEvents(p EventPublisher) {
+	m.events = p
+}
+
+// IssueSource lists a repository's issues for replication.
+type IssueSource interface {
+	List(owner, repo, state string) ([]issues.Issue, error)
+}
+
+// SetIssues makes the manager replicate issue trackers along with the
+// repositories.
+func (m *Manager) SetIssues(src IssueSource) {
+	m.issues = src
+}
+
+// PeerLookup finds instances by ID, with what they advertised in their last
+// handshake.
+type PeerLookup interface {
+	Get(instanceID string) (instance.Peer, bool, error)
+}
+
+// SetPeers lets the manager compress bundles for the replicas that said in
+// their handshake they can unpack them.
+func (m *Manager) SetPeers(peers PeerLookup) {
+	m.peers = peers
 }
 
 func (m *Manager) Start(workers int) {
+	m.removeSpooledBundles()
 	for i := 0; i < workers; i++ {
 		m.wg.Add(1)
-		go m.worker()
+		go m.worker(i)
 	}
 }
 
-func (m *Manager) Stop() {
-	close(m.queue)
-	m.wg.Wait()
+// Shutdown stops taking jobs and waits for the workers to finish those
+// already queued, or for ctx to end. Jobs it doesn't get to are picked up by
+// the first periodic sync after the next start, as are all queued jobs
+// while replication is paused.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.stopMu.Lock()
+	if !m.stopped {
+		m.stopped = true
+		close(m.queue)
+		close(m.done)
+	}
+	m.stopMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d queued jobs not run: %w", len(m.queue), ctx.Err())
+	}
 }
 
 func (m *Manager) Queue(owner, repo string) {
+	m.enqueue(Job{Owner: owner, Repo: repo, Kind: JobSync})
+}
+
+// QueueForce syncs the repo, overwriting any refs that have diverged on its
+// replicas.
+func (m *Manager) QueueForce(owner, repo string) {
+	m.enqueue(Job{Owner: owner, Repo: repo, Kind: JobSync, Force: true})
+}
+
+func (m *Manager) QueueMetadata(owner, repo string) {
+	m.enqueue(Job{Owner: owner, Repo: repo, Kind: JobMetadata})
+}
+
+// QueueIssues sends the repo's issues to its replicas.
+func (m *Manager) QueueIssues(owner, repo string) {
+	if m.issues == nil {
+		return
+	}
+	m.enqueue(Job{Owner: owner, Repo: repo, Kind: JobIssues})
+}
+
+func (m *Manager) QueueDelete(owner, repo string, replicas []storage.Replica) {
+	m.enqueue(Job{Owner: owner, Repo: repo, Kind: JobDelete, Replicas: replicas})
+}
+
+type jobKey struct {
+	owner string
+	repo  string
+	kind  JobKind
+}
+
+func (m *Manager) enqueue(job Job) {
+	// Deletes carry their replica list and always run.
+	if job.Kind == JobDelete {
+		m.send(job)
+		return
+	}
+
+	key := jobKey{job.Owner, job.Repo, job.Kind}
+
+	m.pendingMu.Lock()
+	if queued, ok := m.pending[key]; ok {
+		queued.Force = queued.Force || job.Force
+		m.pendingMu.Unlock()
+		return
+	}
+	m.pending[key] = &job
+	m.pendingMu.Unlock()
+
+	if !m.send(job) {
+		m.pendingMu.Lock()
+		delete(m.pending, key)
+		m.pendingMu.Unlock()
+	}
+}
+
+func (m *Manager) send(job Job) bool {
+	m.stopMu.RLock()
+	defer m.stopMu.RUnlock()
+
+	if m.stopped {
+		log.Printf("replication stopped, dropping job for %s/%s", job.Owner, job.Repo)
+		return false
+	}
 	select {
-	case m.queue <- Job{Owner: owner, Repo: repo}:
+	case m.queue <- job:
+		return true
 	default:
-		log.Printf("replication queue full, dropping job for %s/%s", owner, repo)
+		log.Printf("replication queue full, dropping job for %s/%s", job.Owner, job.Repo)
+		return false
+	}
+}
+
+// take removes a job from the pending set as a worker starts it, returning it
+// with any flags merged in from jobs coalesced into it.
+func (m *Manager) take(job Job) Job {
+	if job.Kind == JobDelete {
+		return job
 	}
+
+	key := jobKey{job.Owner, job.Repo, job.Kind}
+
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+
+	if queued, ok := m.pending[key]; ok {
+		job = *queued
+		delete(m.pending, key)
+	}
+	return job
 }
 
-func (m *Manager) worker() {
+func (m *Manager) worker(id int) {
 	defer m.wg.Done()
 
 	for job := range m.queue {
-		if err := m.replicate(job.Owner, job.Repo); err != nil {
+		if !m.waitResumed() {
+			return
+		}
+		job = m.take(job)
+		m.setRunning(id, &job)
+
+		var err error
+		switch job.Kind {
+		case JobMetadata:
+			err = m.replicateMetadata(job.Owner, job.Repo)
+		case JobDelete:
+			err = m.replicateDelete(job.Owner, job.Repo, job.Replicas)
+		case JobUsers:
+			err = m.replicateUsers()
+		case JobIssues:
+			err = m.replicateIssues(job.Owner, job.Repo)
+		default:
+			err = m.replicate(job.Owner, job.Repo, job.Force)
+		}
+		if err != nil {
 			log.Printf("replication failed for %s/%s: %v", job.Owner, job.Repo, err)
 		}
+		m.setRunning(id, nil)
 	}
 }
 
-func (m *Manager) replicate(owner, repo string) error {
+func (m *Manager) replicate(owner, repo string, force bool) error {
 	meta, err := m.store.GetMetadata(owner, repo)
 	if err != nil {
 		return fmt.Errorf("get metadata: %w", err)
 	}
 
+	var urls []string
+	for _, replica := range meta.Replicas {
+		if replica.Enabled {
+			urls = append(urls, replica.URL)
+		}
+	}
+	m.pruneStats(owner, repo, urls)
+
 	if len(meta.Replicas) == 0 {
 		return nil
 	}
 
-	bundle, err := m.createBundle(owner, repo)
+	if !meta.ChainAllowed() {
+		for i := range meta.Replicas {
+			meta.Replicas[i].LastAttempt = time.Now()
+			meta.Replicas[i].LastError = "origin has not allowed this replica to chain"
+		}
+		return m.recordStatus(owner, repo, meta.Replicas)
+	}
+
+	refs, err := m.store.ListRefs(owner, repo)
 	if err != nil {
-		return fmt.Errorf("create bundle: %w", err)
+		return fmt.Errorf("list refs: %w", err)
 	}
 
+	// Replicas with the same ref filter share one bundle. Bundles are built
+	// up front so the pushes below only do network I/O.
+	bundles := make(map[string]*bundleFile)
+	defer func() {
+		for _, bundle := range bundles {
+			bundle.remove()
+		}
+	}()
+	shipped := make([]map[string]string, len(meta.Replicas))
+	var targets []int
+
 	for i, replica := range meta.Replicas {
 		if !replica.Enabled {
 			continue
 		}
 
-		log.Printf("pushing to replica %s", replica.URL)
-		if err := m.pushToReplica(owner, repo, replica, bundle); err != nil {
-			log.Printf("push to replica %s failed: %v", replica.URL, err)
+		meta.Replicas[i].LastAttempt = time.Now()
+
+		shipped[i] = storage.FilterRefs(refs, replica.Refs)
+		if len(shipped[i]) == 0 {
+			meta.Replicas[i].LastError = "no refs match replica filter"
 			continue
 		}
 
-		log.Printf("successfully replicated to %s", replica.URL)
+		key := strings.Join(replica.Refs, "\n")
+		if _, ok := bundles[key]; !ok {
+			bundle, err := m.createBundle(owner, repo, shipped[i], len(replica.Refs) == 0)
+			if err != nil {
+				return fmt.Errorf("create bundle: %w", err)
+			}
+			bundles[key] = bundle
+		}
+		targets = append(targets, i)
+	}
+
+	// Each push only touches its own replica's entry, so a slow mirror
+	// doesn't hold up the others.
+	var firstSync atomic.Bool
+	failed := m.fanOut(targets, func(i int) error {
+		replica := meta.Replicas[i]
+		bundle := bundles[strings.Join(replica.Refs, "\n")]
+
+		log.Printf("pushing %s/%s to replica %s", owner, repo, replica.URL)
+		start := time.Now()
+		err := m.pushToReplica(owner, repo, replica, bundle, force)
+		m.recordPush(owner, repo, replica.URL, int(bundle.size), err)
+		m.publishCompleted(owner, repo, replica.URL, err)
+
+		entry := LogEntry{
+			Time:       start,
+			Replica:    replica.URL,
+			Refs:       changedRefs(replica.SyncedRefs, shipped[i]),
+			Bytes:      int(bundle.size),
+			DurationMS: time.Since(start).Milliseconds(),
+			Forced:     force,
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if lerr := m.appendLog(owner, repo, entry); lerr != nil {
+			log.Printf("replication log for %s/%s: %v", owner, repo, lerr)
+		}
+		if err != nil {
+			log.Printf("push of %s/%s to replica %s failed: %v", owner, repo, replica.URL, err)
+			meta.Replicas[i].LastError = err.Error()
+			return err
+		}
+
+		log.Printf("successfully replicated %s/%s to %s", owner, repo, replica.URL)
+		if meta.Replicas[i].LastSynced.IsZero() {
+			firstSync.Store(true)
+		}
 		meta.Replicas[i].LastSynced = time.Now()
+		meta.Replicas[i].LastError = ""
+		meta.Replicas[i].SyncedRefs = shipped[i]
+		return nil
+	})
+
+	if err := m.recordStatus(owner, repo, meta.Replicas); err != nil {
+		return err
+	}
+	// A new replica has the repository now but not its issues.
+	if firstSync.Load() {
+		m.QueueIssues(owner, repo)
 	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replica(s) failed", failed, len(targets))
+	}
+	return nil
+}
 
-	if err := m.store.SetMetadata(owner, repo, meta); err != nil {
-		return fmt.Errorf("update metadata: %w", err)
+func (m *Manager) publishCompleted(owner, repo, replicaURL string, err error) {
+	if m.events == nil {
+		return
+	}
+	e := events.Event{Kind: events.KindReplicationCompleted, Owner: owner, Repo: repo, Replica: replicaURL}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	m.events.Publish(e)
+}
+
+// fanOut runs fn for each index with at most pushConcurrency calls in flight
+// and returns how many failed.
+func (m *Manager) fanOut(indexes []int, fn func(i int) error) int {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+	)
+	sem := make(chan struct{}, m.pushConcurrency)
+
+	for _, i := range indexes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(i); err != nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	return failed
+}
+
+func (m *Manager) pushToReplica(owner, repo string, replica storage.Replica, bundle *bundleFile, force bool) error {
+	// Replicas may run a release from before /api/v1, so instance-to-instance
+	// calls stay on the unversioned paths every release serves.
+	url := fmt.Sprintf("%s/api/repos/replicate", replica.URL)
+
+	meta, err := m.store.GetMetadata(owner, repo)
+	if err != nil {
+		return fmt.Errorf("get metadata: %w", err)
+	}
+
+	metaBytes, err := json.Marshal(metadataFor(meta, replica, meta.KnownMirrors()))
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+
+	var refsBytes []byte
+	if len(replica.Refs) > 0 {
+		refsBytes, err = json.Marshal(replica.Refs)
+		if err != nil {
+			return fmt.Errorf("marshal refs: %w", err)
+		}
+	}
+
+	// The signature covers every field and the bundle hash, so the replica
+	// can reject payloads that weren't produced by this instance even if the
+	// bearer token has leaked.
+	timestamp := time.Now().Unix()
+	bundleSHA := bundle.sha
+	signature := m.instance.Sign(instance.SignatureMessage("replicate", timestamp,
+		owner, repo, m.instance.ID, replica.InvitationKey,
+		instance.Digest(metaBytes), instance.Digest(refsBytes), bundleSHA, strconv.FormatBool(force)))
+
+	fields := [][2]string{
+		{"owner", owner},
+		{"repo", repo},
+		{"instance_id", m.instance.ID},
+		{"invitation_key", replica.InvitationKey},
+		{"metadata", string(metaBytes)},
+	}
+	if refsBytes != nil {
+		fields = append(fields, [2]string{"refs", string(refsBytes)})
+	}
+	fields = append(fields,
+		[2]string{"force", strconv.FormatBool(force)},
+		[2]string{"timestamp", fmt.Sprint(timestamp)},
+		[2]string{"bundle_sha256", bundleSHA},
+		[2]string{"signature", signature},
+	)
+
+	// bundle_sha256 is of the bundle itself, so however it is compressed
+	// the replica still checks what it unpacks against the signature.
+	var body io.Reader
+	chunked := bundle.size >= chunkedBundleSize && m.peerSupports(replica, "bundle-upload")
+	if chunked {
+		id, encoding, err := m.uploadBundle(owner, repo, replica, bundle)
+		if err != nil {
+			return fmt.Errorf("upload bundle: %w", err)
+		}
+		if encoding != "" {
+			fields = append(fields, [2]string{"content_encoding", encoding})
+		}
+		fields = append(fields, [2]string{"upload_id", id})
+	} else {
+		f, err := bundle.open()
+		if err != nil {
+			return fmt.Errorf("open bundle: %w", err)
+		}
+		defer f.Close()
+		body = f
+		if m.compresses(replica) {
+			compressed, err := zstd.Compress(body)
+			if err != nil {
+				return fmt.Errorf("compress bundle: %w", err)
+			}
+			defer compressed.Close()
+			body = compressed
+			fields = append(fields, [2]string{"content_encoding", "zstd"})
+		}
+	}
+
+	// The bundle is streamed as the last multipart part so the request goes
+	// out with chunked transfer encoding instead of being buffered whole.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeReplicatePayload(mw, fields, body))
+	}()
+
+	resp, err := m.transfer(replica.URL, pr, func(ctx context.Context, body io.Reader) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+replica.Token)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer drainClose(resp.Body)
+	if chunked {
+		// The replica has used up the upload or won't take it, so the next
+		// push starts a new one. One it left behind is removed in a day.
+		m.forgetUpload(owner, repo, replica)
+	}
+
+	if resp.StatusCode == http.StatusConflict {
+		var result struct {
+			Error    string              `json:"error"`
+			Diverged []storage.RefChange `json:"diverged"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && len(result.Diverged) > 0 {
+			refs := make([]string, len(result.Diverged))
+			for i, c := range result.Diverged {
+				refs[i] = c.Ref
+			}
+			return fmt.Errorf("replica diverged on %s; force-sync to overwrite", strings.Join(refs, ", "))
+		}
+		return fmt.Errorf("replica returned %d: %s", resp.StatusCode, result.Error)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("replica returned %d: %s", resp.StatusCode, body)
 	}
 
 	return nil
 }
 
-func (m *Manager) createBundle(owner, repo string) ([]byte, error) {
-	repoPath := m.store.RepoPath(owner, repo)
+// compresses reports whether bundles for replica are sent compressed: only
+// when it advertised "bundle-zstd" at its handshake, as releases before it
+// would try to apply the compressed bundle as is.
+func (m *Manager) compresses(replica storage.Replica) bool {
+	return zstd.Available() && m.peerSupports(replica, "bundle-zstd")
+}
+
+// peerSupports reports whether replica advertised capability at its last
+// handshake. Replicas added without one support nothing optional.
+func (m *Manager) peerSupports(replica storage.Replica, capability string) bool {
+	if m.peers == nil || replica.PeerID == "" {
+		return false
+	}
+	peer, ok, err := m.peers.Get(replica.PeerID)
+	return err == nil && ok && peer.Supports(capability)
+}
+
+// metadataFor returns the metadata sent to replica: without this instance's
+// own replicas, webhooks or upstream, whose token is a secret and whose
+// fetches would fight the bundles, and with ReplicaOf carrying only whether
+// the replica may chain. The replica fills in the rest of ReplicaOf itself.
+// mirrors, from KnownMirrors, lets it list the repository's other copies.
+func metadataFor(meta storage.Metadata, replica storage.Replica, mirrors []storage.Mirror) storage.Metadata {
+	meta.Mirrors = mirrors
+	meta.Replicas = nil
+	meta.Webhooks = nil
+	meta.Upstream = nil
+	meta.ReplicaOf = nil
+	if replica.AllowChain {
+		meta.ReplicaOf = &storage.ReplicaSource{AllowChain: true}
+	}
+	return meta
+}
 
-	cmd := exec.Command("git", "bundle", "create", "-", "--all")
-	cmd.Dir = repoPath
+func writeReplicatePayload(mw *multipart.Writer, fields [][2]string, bundle io.Reader) error {
+	for _, f := range fields {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return fmt.Errorf("write field %s: %w", f[0], err)
+		}
+	}
 
-	output, err := cmd.Output()
+	if bundle == nil {
+		return mw.Close()
+	}
+
+	part, err := mw.CreateFormFile("bundle", "repo.bundle")
 	if err != nil {
-		return nil, fmt.Errorf("git bundle: %w", err)
+		return fmt.Errorf("create bundle part: %w", err)
 	}
 
-	return output, nil
+	if _, err := io.Copy(part, bundle); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+
+	return mw.Close()
 }
 
-func (m *Manager) pushToReplica(owner, repo string, replica storage.Replica, bundle []byte) error {
-	url := fmt.Sprintf("%s/api/repos/replicate", replica.URL)
+func (m *Manager) replicateMetadata(owner, repo string) error {
+	meta, err := m.store.GetMetadata(owner, repo)
+	if err != nil {
+		return fmt.Errorf("get metadata: %w", err)
+	}
+
+	if !meta.ChainAllowed() {
+		return nil
+	}
+
+	var targets []int
+	for i, replica := range meta.Replicas {
+		if replica.Enabled {
+			meta.Replicas[i].LastAttempt = time.Now()
+			targets = append(targets, i)
+		}
+	}
 
+	// Workers update meta.Replicas as they finish, so the mirror list is
+	// taken before they start.
+	mirrors := meta.KnownMirrors()
+	m.fanOut(targets, func(i int) error {
+		replica := meta.Replicas[i]
+		metaCopy := metadataFor(meta, replica, mirrors)
+		if err := m.sendMessage(replica, "replicate-metadata", owner, repo, map[string]interface{}{"metadata": metaCopy}); err != nil {
+			log.Printf("metadata update of %s/%s to replica %s failed: %v", owner, repo, replica.URL, err)
+			m.recordFailure(owner, repo, replica.URL)
+			meta.Replicas[i].LastError = err.Error()
+			return err
+		}
+		meta.Replicas[i].LastError = ""
+		return nil
+	})
+
+	return m.recordStatus(owner, repo, meta.Replicas)
+}
+
+// recordStatus writes back per-replica sync state. Only the status fields are
+// merged into the current metadata, so edits made while a sync was in flight
+// (policy, description, replicas added or removed) aren't overwritten.
+// replicateIssues sends the repo's issues to its replicas, replacing theirs.
+// Repos without issues are skipped, as there's nothing to send.
+func (m *Manager) replicateIssues(owner, repo string) error {
 	meta, err := m.store.GetMetadata(owner, repo)
 	if err != nil {
 		return fmt.Errorf("get metadata: %w", err)
 	}
 
-	metaCopy := meta
-	metaCopy.Replicas = nil
+	if !meta.ChainAllowed() {
+		return nil
+	}
+
+	list, err := m.issues.List(owner, repo, "")
+	if err != nil {
+		return fmt.Errorf("list issues: %w", err)
+	}
+	if len(list) == 0 {
+		return nil
+	}
+
+	var targets []int
+	for i, replica := range meta.Replicas {
+		if replica.Enabled {
+			targets = append(targets, i)
+		}
+	}
+
+	failed := m.fanOut(targets, func(i int) error {
+		replica := meta.Replicas[i]
+		if err := m.sendMessage(replica, "replicate-issues", owner, repo, map[string]interface{}{"issues": list}); err != nil {
+			log.Printf("issue sync of %s/%s to replica %s failed: %v", owner, repo, replica.URL, err)
+			return err
+		}
+		return nil
+	})
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replica(s) failed", failed, len(targets))
+	}
+	return nil
+}
+
+func (m *Manager) recordStatus(owner, repo string, replicas []storage.Replica) error {
+	byURL := make(map[string]storage.Replica, len(replicas))
+	for _, r := range replicas {
+		byURL[r.URL] = r
+	}
+
+	err := m.store.UpdateMetadata(owner, repo, func(meta *storage.Metadata) error {
+		for i, r := range meta.Replicas {
+			synced, ok := byURL[r.URL]
+			if !ok {
+				continue
+			}
+			meta.Replicas[i].LastSynced = synced.LastSynced
+			meta.Replicas[i].LastAttempt = synced.LastAttempt
+			meta.Replicas[i].LastError = synced.LastError
+			meta.Replicas[i].SyncedRefs = synced.SyncedRefs
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("update metadata: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) replicateDelete(owner, repo string, replicas []storage.Replica) error {
+	var targets []int
+	for i, replica := range replicas {
+		if replica.Enabled {
+			targets = append(targets, i)
+		}
+	}
+
+	m.pruneStats(owner, repo, nil)
 
+	failed := m.fanOut(targets, func(i int) error {
+		replica := replicas[i]
+		if err := m.sendMessage(replica, "replicate-delete", owner, repo, nil); err != nil {
+			log.Printf("delete of %s/%s on replica %s failed: %v", owner, repo, replica.URL, err)
+			return err
+		}
+		log.Printf("deleted %s/%s on replica %s", owner, repo, replica.URL)
+		return nil
+	})
+
+	if failed > 0 {
+		return fmt.Errorf("%d replica(s) did not confirm deletion", failed)
+	}
+	return nil
+}
+
+// sendMessage posts a signed replication message about owner/repo to
+// replica, with fields such as "metadata" added to the payload.
+func (m *Manager) sendMessage(replica storage.Replica, endpoint, owner, repo string, fields map[string]interface{}) error {
 	payload := map[string]interface{}{
 		"owner":          owner,
 		"repo":           repo,
-		"instance_id":    m.instanceID,
+		"instance_id":    m.instance.ID,
 		"invitation_key": replica.InvitationKey,
-		"bundle":         base64.StdEncoding.EncodeToString(bundle),
-		"metadata":       metaCopy,
+	}
+	for k, v := range fields {
+		payload[k] = v
 	}
 
 	payloadBytes, err := json.Marshal(payload)
@@ -141,20 +841,24 @@ func (m *Manager) pushToReplica(owner, repo string, replica storage.Replica, bun
 		return fmt.Errorf("marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payloadBytes))
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/repos/%s", replica.URL, endpoint), bytes.NewReader(payloadBytes))
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
 
+	timestamp := time.Now().Unix()
+	signature := m.instance.Sign(instance.SignatureMessage(endpoint, timestamp, instance.Digest(payloadBytes)))
+
 	req.Header.Set("Authorization", "Bearer "+replica.Token)
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-OpenHub-Timestamp", fmt.Sprint(timestamp))
+	req.Header.Set("X-OpenHub-Signature", signature)
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := m.httpClient().Do(req)
 	if err != nil {
 		return fmt.Errorf("send request: %w", err)
 	}
-	defer resp.Body.Close()
+	defer drainClose(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
@@ -165,6 +869,11 @@ func (m *Manager) pushToReplica(owner, repo string, replica storage.Replica, bun
 }
 
 func (m *Manager) SyncAll() {
+	if !m.Paused().IsZero() {
+		log.Println("replication paused, skipping sync of all repositories")
+		return
+	}
+
 	repos, err := m.store.ListRepos()
 	if err != nil {
 		log.Printf("sync all: list repos failed: %v", err)
@@ -173,11 +882,19 @@ func (m *Manager) SyncAll() {
 
 	for _, repo := range repos {
 		m.Queue(repo.Owner, repo.Name)
+		m.QueueIssues(repo.Owner, repo.Name)
 	}
+
+	m.QueueUsers()
 }
 
+// StartPeriodicSync re-syncs every repository to its replicas every
+// interval.
 func (m *Manager) StartPeriodicSync(interval time.Duration) {
-	ticker := time.NewTicker(interval)
+	ticker := time.NewTicker(time.Hour)
+	ticker.Stop()
+	m.syncTicker = ticker
+	m.SetSyncInterval(interval)
 	go func() {
 		for range ticker.C {
 			log.Println("starting periodic replication sync")
@@ -185,3 +902,15 @@ func (m *Manager) StartPeriodicSync(interval time.Duration) {
 		}
 	}()
 }
+
+// SetSyncInterval changes how often the periodic sync runs. Zero pauses it.
+func (m *Manager) SetSyncInterval(interval time.Duration) {
+	if m.syncTicker == nil {
+		return
+	}
+	if interval > 0 {
+		m.syncTicker.Reset(interval)
+	} else {
+		m.syncTicker.Stop()
+	}
+}
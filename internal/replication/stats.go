@@ -0,0 +1,237 @@
+package replication
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/metrics"
+)
+
+type statsKey struct {
+	repo    string
+	replica string
+}
+
+// ReplicaStats counts one replica's pushes for one repository since the
+// server started. LastSynced is seeded from the repository's metadata so a
+// restart doesn't make a stale mirror look fresh.
+type ReplicaStats struct {
+	Repo        string
+	Replica     string
+	LastSynced  time.Time
+	BytesPushed int64
+	Pushes      int64
+	Failures    int64
+}
+
+func (m *Manager) stat(owner, repo, replica string) *ReplicaStats {
+	key := statsKey{owner + "/" + repo, replica}
+	st, ok := m.stats[key]
+	if !ok {
+		st = &ReplicaStats{Repo: key.repo, Replica: replica}
+		m.stats[key] = st
+	}
+	return st
+}
+
+// recordPush counts a bundle push to a replica. size is only added to the
+// bytes shipped when the push succeeded.
+func (m *Manager) recordPush(owner, repo, replica string, size int, err error) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+
+	st := m.stat(owner, repo, replica)
+	if err != nil {
+		st.Failures++
+		return
+	}
+	st.Pushes++
+	st.BytesPushed += int64(size)
+	st.LastSynced = time.Now()
+}
+
+func (m *Manager) recordFailure(owner, repo, replica string) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	m.stat(owner, repo, replica).Failures++
+}
+
+// pruneStats forgets a repository's replicas that aren't in keep, once they
+// have been removed or the repository deleted.
+func (m *Manager) pruneStats(owner, repo string, keep []string) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+
+	kept := make(map[string]bool, len(keep))
+	for _, url := range keep {
+		kept[url] = true
+	}
+	for key := range m.stats {
+		if key.repo == owner+"/"+repo && !kept[key.replica] {
+			delete(m.stats, key)
+		}
+	}
+}
+
+// Stats returns a snapshot of every replica's counters, sorted by repository
+// and replica URL.
+func (m *Manager) Stats() []ReplicaStats {
+	m.statsMu.Lock()
+	result := make([]ReplicaStats, 0, len(m.stats))
+	for _, st := range m.stats {
+		result = append(result, *st)
+	}
+	m.statsMu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Repo != result[j].Repo {
+			return result[i].Repo < result[j].Repo
+		}
+		return result[i].Replica < result[j].Replica
+	})
+	return result
+}
+
+// RegisterMetrics exposes the manager's replica counters and queue length on
+// the metrics endpoint. It seeds each enabled replica's last sync time from
+// the stored metadata, and must be called at most once per process.
+func (m *Manager) RegisterMetrics() {
+	repos, err := m.store.ListRepos()
+	if err != nil {
+		log.Printf("replication metrics: list repos failed: %v", err)
+	}
+	m.statsMu.Lock()
+	for _, repo := range repos {
+		meta, err := m.store.GetMetadata(repo.Owner, repo.Name)
+		if err != nil {
+			continue
+		}
+		for _, replica := range meta.Replicas {
+			if replica.Enabled {
+				m.stat(repo.Owner, repo.Name, replica.URL).LastSynced = replica.LastSynced
+			}
+		}
+	}
+	m.statsMu.Unlock()
+
+	perReplica := func(value func(st ReplicaStats) (float64, bool)) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, st := range m.Stats() {
+				v, ok := value(st)
+				if !ok {
+					continue
+				}
+				samples = append(samples, metrics.Sample{
+					Labels: [][2]string{{"repo", st.Repo}, {"replica", st.Replica}},
+					Value:  v,
+				})
+			}
+			return samples
+		}
+	}
+
+	metrics.NewCollector("openhub_replication_last_sync_timestamp_seconds",
+		"Unix time of the last successful push to a replica.", "gauge",
+		perReplica(func(st ReplicaStats) (float64, bool) {
+			return float64(st.LastSynced.Unix()), !st.LastSynced.IsZero()
+		}))
+	metrics.NewCollector("openhub_replication_last_sync_age_seconds",
+		"Seconds since the last successful push to a replica.", "gauge",
+		perReplica(func(st ReplicaStats) (float64, bool) {
+			return time.Since(st.LastSynced).Seconds(), !st.LastSynced.IsZero()
+		}))
+	metrics.NewCollector("openhub_replication_bytes_pushed_total",
+		"Bundle bytes successfully pushed to a replica.", "counter",
+		perReplica(func(st ReplicaStats) (float64, bool) {
+			return float64(st.BytesPushed), true
+		}))
+	metrics.NewCollector("openhub_replication_pushes_total",
+		"Successful bundle pushes to a replica.", "counter",
+		perReplica(func(st ReplicaStats) (float64, bool) {
+			return float64(st.Pushes), true
+		}))
+	metrics.NewCollector("openhub_replication_failures_total",
+		"Failed pushes and metadata updates to a replica.", "counter",
+		perReplica(func(st ReplicaStats) (float64, bool) {
+			return float64(st.Failures), true
+		}))
+	metrics.NewCollector("openhub_replication_queue_length",
+		"Replication jobs waiting for a worker.", "gauge",
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(len(m.queue))}}
+		})
+}
+
+// String names a job kind for logs and diagnostics.
+func (k JobKind) String() string {
+	switch k {
+	case JobSync:
+		return "sync"
+	case JobMetadata:
+		return "metadata"
+	case JobDelete:
+		return "delete"
+	case JobUsers:
+		return "users"
+	case JobIssues:
+		return "issues"
+	}
+	return fmt.Sprintf("JobKind(%d)", int(k))
+}
+
+// RunningJob is a job a worker is running.
+type RunningJob struct {
+	Kind    string    `json:"kind"`
+	Repo    string    `json:"repo,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+// QueueStatus is a snapshot of the replication queue.
+type QueueStatus struct {
+	Queued   int          `json:"queued"`
+	Capacity int          `json:"capacity"`
+	Running  []RunningJob `json:"running"`
+}
+
+func (m *Manager) setRunning(worker int, job *Job) {
+	m.runningMu.Lock()
+	defer m.runningMu.Unlock()
+	if job == nil {
+		delete(m.running, worker)
+		return
+	}
+	rj := RunningJob{Kind: job.Kind.String(), Started: time.Now()}
+	if job.Owner != "" {
+		rj.Repo = job.Owner + "/" + job.Repo
+	}
+	m.running[worker] = rj
+}
+
+// QueueStatus reports how many jobs are waiting and what the workers are
+// running, longest-running first.
+func (m *Manager) QueueStatus() QueueStatus {
+	m.runningMu.Lock()
+	running := make([]RunningJob, 0, len(m.running))
+	for _, rj := range m.running {
+		running = append(running, rj)
+	}
+	m.runningMu.Unlock()
+	sort.Slice(running, func(i, j int) bool { return running[i].Started.Before(running[j].Started) })
+
+	return QueueStatus{
+		Queued:   len(m.queue),
+		Capacity: cap(m.queue),
+		Running:  running,
+	}
+}
+
+// Backlog returns how many jobs are waiting and how many are running.
+func (m *Manager) Backlog() (queued, running int) {
+	m.runningMu.Lock()
+	running = len(m.running)
+	m.runningMu.Unlock()
+	return len(m.queue), running
+}
@@ -0,0 +1,76 @@
+package replication
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TransportOptions tune the connections kept open to replicas. Every
+// request to a replica goes through one pool of them, so replicating many
+// repositories to the same mirror doesn't pay for a new TCP and TLS
+// handshake each time.
+type TransportOptions struct {
+	// ConnectTimeout bounds dialling a replica and the TLS handshake.
+	ConnectTimeout time.Duration
+	// IdleConns is how many idle connections are kept to each replica, and
+	// IdleTimeout how long one is kept before it is closed. Zero IdleConns
+	// closes each connection after its request.
+	IdleConns   int
+	IdleTimeout time.Duration
+	// Proxy is the proxy requests to replicas go through. Nil uses
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY from the environment.
+	Proxy *url.URL
+	// HTTP2 lets requests to replicas served over TLS use HTTP/2, which
+	// sends them all over one connection per replica.
+	HTTP2 bool
+}
+
+// DefaultTransportOptions are the options a new manager starts with.
+var DefaultTransportOptions = TransportOptions{
+	ConnectTimeout: 10 * time.Second,
+	IdleConns:      4,
+	IdleTimeout:    90 * time.Second,
+}
+
+// SetTransport replaces the pool of connections to replicas with one built
+// from opts. It must be called before Start.
+func (m *Manager) SetTransport(opts TransportOptions) {
+	m.transport = m.newTransport(opts)
+}
+
+func (m *Manager) newTransport(opts TransportOptions) *http.Transport {
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != nil {
+		proxy = http.ProxyURL(opts.Proxy)
+	}
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       m.tlsConfig,
+		TLSHandshakeTimeout:   opts.ConnectTimeout,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   opts.IdleConns,
+		IdleConnTimeout:       opts.IdleTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableKeepAlives:     opts.IdleConns == 0,
+		ForceAttemptHTTP2:     opts.HTTP2,
+	}
+}
+
+// httpClient returns a client for one request to a replica. Clients are
+// cheap and callers may change their timeout; the connections underneath
+// are shared.
+func (m *Manager) httpClient() *http.Client {
+	return &http.Client{Timeout: m.httpTimeout, Transport: m.transport}
+}
+
+// drainClose reads what is left of a small response body before closing
+// it, so its connection goes back to the pool instead of being dropped.
+func drainClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	body.Close()
+}
@@ -0,0 +1,262 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	// chunkedBundleSize is the size from which bundles are uploaded in
+	// chunks the replica keeps, for replicas that support it, so a dropped
+	// connection costs the chunk in flight rather than the whole bundle.
+	chunkedBundleSize = 64 << 20
+	bundleChunkSize   = 16 << 20
+	// bundleUploadAttempts bounds how many times one push resumes an
+	// upload before giving up until the next sync.
+	bundleUploadAttempts = 5
+)
+
+// bundleUpload is an upload session open on a replica, kept so the next
+// push of the same bundle carries on from where this one stopped.
+type bundleUpload struct {
+	id  string
+	sha string
+}
+
+// uploadBundle sends bundle to replica in chunks, resuming after dropped
+// connections, and returns the upload ID to replicate it with and how it
+// was compressed, if at all.
+func (m *Manager) uploadBundle(owner, repo string, replica storage.Replica, bundle *bundleFile) (string, string, error) {
+	payload, encoding := bundle, ""
+	if m.compresses(replica) {
+		compressed, err := bundle.compress()
+		if err != nil {
+			return "", "", fmt.Errorf("compress bundle: %w", err)
+		}
+		defer compressed.remove()
+		payload, encoding = compressed, "zstd"
+	}
+	sha, size := payload.sha, payload.size
+
+	f, err := payload.open()
+	if err != nil {
+		return "", "", fmt.Errorf("open bundle: %w", err)
+	}
+	defer f.Close()
+
+	key := replica.URL + " " + owner + "/" + repo
+	m.uploadsMu.Lock()
+	prev, ok := m.uploads[key]
+	m.uploadsMu.Unlock()
+
+	var id string
+	var offset int64
+	if ok && prev.sha == sha {
+		if n, err := m.uploadOffset(replica, prev.id); err == nil {
+			id, offset = prev.id, n
+			log.Printf("resuming upload of %s/%s to %s at %d of %d bytes", owner, repo, replica.URL, offset, size)
+		}
+	}
+	if id == "" {
+		var err error
+		id, err = m.createUpload(owner, repo, replica, size, sha)
+		if err != nil {
+			return "", "", err
+		}
+		m.uploadsMu.Lock()
+		m.uploads[key] = bundleUpload{id: id, sha: sha}
+		m.uploadsMu.Unlock()
+	}
+
+	for attempt := 0; offset < size; {
+		end := min(offset+bundleChunkSize, size)
+		next, err := m.appendChunk(replica, id, offset, io.NewSectionReader(f, offset, end-offset))
+		if err == nil {
+			offset = next
+			continue
+		}
+		if attempt++; attempt == bundleUploadAttempts {
+			return "", "", err
+		}
+		log.Printf("upload of %s/%s to %s interrupted at %d of %d bytes, resuming: %v", owner, repo, replica.URL, offset, size, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+		if offset, err = m.uploadOffset(replica, id); err != nil {
+			return "", "", err
+		}
+	}
+	return id, encoding, nil
+}
+
+// forgetUpload drops the upload session kept for owner/repo on replica,
+// once the bundle has been replicated.
+func (m *Manager) forgetUpload(owner, repo string, replica storage.Replica) {
+	m.uploadsMu.Lock()
+	defer m.uploadsMu.Unlock()
+	delete(m.uploads, replica.URL+" "+owner+"/"+repo)
+}
+
+// createUpload opens an upload session on replica for a bundle of size
+// bytes with the given checksum.
+func (m *Manager) createUpload(owner, repo string, replica storage.Replica, size int64, sha string) (string, error) {
+	payloadBytes, err := json.Marshal(map[string]interface{}{
+		"owner":       owner,
+		"repo":        repo,
+		"instance_id": m.instance.ID,
+		"size":        size,
+		"sha256":      sha,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/repos/replicate/uploads", replica.URL), bytes.NewReader(payloadBytes))
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+replica.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("start upload: %w", err)
+	}
+	defer drainClose(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("start upload: replica returned %d: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Upload struct {
+			ID string `json:"id"`
+		} `json:"upload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Upload.ID == "" {
+		return "", fmt.Errorf("start upload: invalid response from replica")
+	}
+	return result.Upload.ID, nil
+}
+
+// uploadOffset asks replica how much of upload id it has.
+func (m *Manager) uploadOffset(replica storage.Replica, id string) (int64, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/repos/replicate/uploads/%s", replica.URL, id), nil)
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+replica.Token)
+
+	resp, err := m.httpClient().Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("get upload offset: %w", err)
+	}
+	defer drainClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("get upload offset: replica returned %d: %s", resp.StatusCode, body)
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// appendChunk sends chunk to upload id at offset and returns the offset the
+// replica has reached.
+func (m *Manager) appendChunk(replica storage.Replica, id string, offset int64, chunk *io.SectionReader) (int64, error) {
+	url := fmt.Sprintf("%s/api/repos/replicate/uploads/%s", replica.URL, id)
+	resp, err := m.transfer(replica.URL, chunk, func(ctx context.Context, body io.Reader) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "PATCH", url, body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = chunk.Size()
+		req.Header.Set("Authorization", "Bearer "+replica.Token)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		return req, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer drainClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("replica returned %d: %s", resp.StatusCode, body)
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// transfer sends the request newRequest builds around body, slowed to the
+// bandwidth caps for the replica at replicaURL. An upload of a big bundle
+// can take far longer than the timeout, so the timeout only bounds how long
+// the upload may stall, and then how long the replica takes to answer once
+// it has all of it. Closing the response body releases the request.
+func (m *Manager) transfer(replicaURL string, body io.Reader, newRequest func(context.Context, io.Reader) (*http.Request, error)) (*http.Response, error) {
+	client := m.httpClient()
+	client.Timeout = 0
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stalled := time.AfterFunc(m.httpTimeout, func() {
+		cancel(fmt.Errorf("no progress for %s", m.httpTimeout))
+	})
+	release := func() {
+		stalled.Stop()
+		cancel(nil)
+	}
+	progress := func() { stalled.Reset(m.httpTimeout) }
+	if m.bandwidth.limited() {
+		body = m.bandwidth.reader(replicaURL, body, progress)
+	} else {
+		body = &progressReader{r: body, progress: progress}
+	}
+
+	req, err := newRequest(ctx, body)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			err = cause
+		}
+		release()
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// progressReader calls progress whenever a read from r returns data.
+type progressReader struct {
+	r        io.Reader
+	progress func()
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress()
+	}
+	return n, err
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,100 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/instance"
+)
+
+type UserLister interface {
+	ListUsers() ([]auth.User, error)
+}
+
+// SetStandbys makes the manager mirror users, SSH keys and API tokens to the
+// given standby instances on every periodic sync.
+func (m *Manager) SetStandbys(users UserLister, urls []string) {
+	m.users = users
+	m.standbys = urls
+}
+
+func (m *Manager) QueueUsers() {
+	if len(m.standbys) == 0 {
+		return
+	}
+	m.enqueue(Job{Kind: JobUsers})
+}
+
+// replicateUsers sends the full account snapshot to each standby. The
+// per-repo replication accounts are left out: they authenticate this
+// instance's peers and are meaningless anywhere else.
+func (m *Manager) replicateUsers() error {
+	all, err := m.users.ListUsers()
+	if err != nil {
+		return fmt.Errorf("list users: %w", err)
+	}
+
+	users := make([]auth.User, 0, len(all))
+	for _, u := range all {
+		if !strings.HasPrefix(u.Username, "replication-") {
+			users = append(users, u)
+		}
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"instance_id": m.instance.ID,
+		"users":       users,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal users: %w", err)
+	}
+
+	var failed int
+	for _, url := range m.standbys {
+		if err := m.sendUsers(url, payload); err != nil {
+			log.Printf("user sync to standby %s failed: %v", url, err)
+			failed++
+			continue
+		}
+		log.Printf("synced %d users to standby %s", len(users), url)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d standby(s) did not accept the user snapshot", failed)
+	}
+	return nil
+}
+
+func (m *Manager) sendUsers(url string, payload []byte) error {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(url, "/")+"/api/instance/users", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	timestamp := time.Now().Unix()
+	signature := m.instance.Sign(instance.SignatureMessage("sync-users", timestamp, instance.Digest(payload)))
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-OpenHub-Timestamp", fmt.Sprint(timestamp))
+	req.Header.Set("X-OpenHub-Signature", signature)
+
+	resp, err := m.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer drainClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("standby returned %d: %s", resp.StatusCode, body)
+	}
+
+	return nil
+}
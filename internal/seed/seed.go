@@ -0,0 +1,109 @@
+// Package seed generates repositories with synthetic histories, for
+// benchmarking against a realistic amount of data.
+package seed
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// epoch is the timestamp of every seeded repo's first commit. Fixed dates
+// and a seeded random source make runs with the same flags produce the same
+// commit hashes.
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+var words = []string{
+	"alpha", "bravo", "cache", "delta", "index", "fetch", "merge", "queue",
+	"refs", "bundle", "replica", "origin", "branch", "commit", "search", "list",
+	"token", "hook", "policy", "mirror", "quota", "sync", "review", "patch",
+}
+
+// Repo creates owner/name in store with a generated history of commits
+// commits on main, over files files. The same rng state produces the same
+// commit hashes.
+func Repo(store *storage.Storage, owner, name string, rng *rand.Rand, commits, files int) error {
+	if err := store.CreateRepo(owner, name); err != nil {
+		return err
+	}
+
+	err := store.UpdateMetadata(owner, name, func(meta *storage.Metadata) error {
+		meta.Description = "Synthetic " + phrase(rng, 4) + " repository"
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	path := store.RepoPath(owner, name)
+	if out, err := exec.Command("git", "-C", path, "symbolic-ref", "HEAD", "refs/heads/main").CombinedOutput(); err != nil {
+		return fmt.Errorf("set HEAD: %s", strings.TrimSpace(string(out)))
+	}
+
+	cmd := exec.Command("git", "fast-import", "--quiet")
+	cmd.Dir = path
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start fast-import: %w", err)
+	}
+
+	w := bufio.NewWriter(stdin)
+	author := fmt.Sprintf("%s <%s@example.invalid>", owner, owner)
+	for c := 1; c <= commits; c++ {
+		when := epoch + int64(c-1)*3600
+		msg := phrase(rng, 2+rng.Intn(5))
+
+		fmt.Fprintf(w, "commit refs/heads/main\nmark :%d\n", c)
+		fmt.Fprintf(w, "author %s %d +0000\ncommitter %s %d +0000\n", author, when, author, when)
+		fmt.Fprintf(w, "data %d\n%s\n", len(msg), msg)
+		if c > 1 {
+			fmt.Fprintf(w, "from :%d\n", c-1)
+		}
+
+		// The first commit adds every file; later ones rewrite a few.
+		changed := 1 + rng.Intn(3)
+		if c == 1 {
+			changed = files
+		}
+		for f := 0; f < changed; f++ {
+			file := f
+			if c > 1 {
+				file = rng.Intn(files)
+			}
+			var content strings.Builder
+			for line := 0; line < 5+rng.Intn(40); line++ {
+				content.WriteString(phrase(rng, 3+rng.Intn(8)))
+				content.WriteByte('\n')
+			}
+			fmt.Fprintf(w, "M 100644 inline src/file%03d.txt\n", file)
+			fmt.Fprintf(w, "data %d\n%s\n", content.Len(), content.String())
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write fast-import stream: %w", err)
+	}
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("fast-import: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func phrase(rng *rand.Rand, n int) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = words[rng.Intn(len(words))]
+	}
+	return strings.Join(parts, " ")
+}
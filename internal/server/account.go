@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"golang.org/x/crypto/ssh"
+)
+
+// handleRecoverAccount lets a user who lost their SSH keys or API tokens
+// back in with one of their recovery codes, or with the invite an admin
+// issued by resetting their access. Each code works once. A successful
+// recovery returns a new API token and optionally registers an SSH key;
+// revoke drops the user's other keys and tokens first, for a stolen device.
+// Recovering with an invite also issues fresh recovery codes, since the
+// reset removed the old ones.
+//
+//	GET  /api/v1/users/recover?user=..&code=..
+//	POST /api/v1/users/recover {"username", "code", "ssh_key", "token_name", "revoke"}
+//
+// GET checks an invite without using it.
+func (s *Server) handleRecoverAccount(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		username := r.URL.Query().Get("user")
+		code := r.URL.Query().Get("code")
+		expires, err := s.authStore.CheckInvite(username, code)
+		if err != nil {
+			s.jsonError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"username":   username,
+			"expires_at": expires,
+			"usage":      fmt.Sprintf(`POST %s/api/v1/users/recover {"username": %q, "code": "<code>", "ssh_key": "<public key>"}`, s.baseURL(r), username),
+		})
+
+	case "POST":
+		var req struct {
+			Username  string `json:"username"`
+			Code      string `json:"code"`
+			SSHKey    string `json:"ssh_key"`
+			TokenName string `json:"token_name"`
+			Revoke    bool   `json:"revoke"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Username == "" || req.Code == "" {
+			s.jsonError(w, "username and code required", http.StatusBadRequest)
+			return
+		}
+
+		if req.SSHKey != "" {
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.SSHKey)); err != nil {
+				s.jsonError(w, "invalid ssh_key", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.TokenName == "" {
+			req.TokenName = "recovered"
+		}
+
+		method, err := s.authStore.Recover(req.Username, req.Code, req.Revoke)
+		if err != nil {
+			s.audit(r, audit.Entry{Action: "user.recover-failed", Target: req.Username})
+			if errors.Is(err, auth.ErrRecoveryDenied) {
+				s.jsonError(w, err.Error(), http.StatusForbidden)
+			} else {
+				s.jsonError(w, fmt.Sprintf("recover failed: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		detail := "with " + method
+		if req.Revoke {
+			detail += ", revoking existing keys and tokens"
+		}
+		s.audit(r, audit.Entry{Actor: req.Username, Action: "user.recover", Target: req.Username, Detail: detail})
+
+		if req.SSHKey != "" {
+			if err := s.authStore.AddSSHKey(req.Username, "recovered", strings.TrimSpace(req.SSHKey)); err != nil {
+				s.jsonError(w, fmt.Sprintf("add ssh key failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		token, err := s.authStore.GenerateAPIToken(req.Username, req.TokenName)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("generate token failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		resp := map[string]interface{}{
+			"success": true,
+			"method":  method,
+			"token":   token,
+		}
+		if method == auth.RecoverWithInvite {
+			codes, err := s.authStore.GenerateRecoveryCodes(req.Username)
+			if err != nil {
+				s.jsonError(w, fmt.Sprintf("generate recovery codes failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			resp["recovery_codes"] = codes
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/activity"
+	"github.com/jeremytregunna/openhub/internal/activitypub"
+)
+
+// EventPublisher announces repository events to other forges.
+type EventPublisher interface {
+	Publish(ev activitypub.Event) error
+}
+
+// SetEvents publishes repository creation through events.
+func (s *Server) SetEvents(events EventPublisher) {
+	s.events = events
+}
+
+type ActivityTracker interface {
+	Record(owner, repo string, e activity.Entry) error
+	Repo(owner, repo string) (*activity.Index, error)
+}
+
+// defaultActivityDays is the window returned when the request doesn't give
+// one: a year, as a contribution graph shows.
+const defaultActivityDays = 365
+
+type activityDay struct {
+	Date    string                     `json:"date"`
+	Commits int                        `json:"commits"`
+	Reviews int                        `json:"reviews"`
+	Users   map[string]activity.Counts `json:"users,omitempty"`
+	Repos   map[string]activity.Counts `json:"repos,omitempty"`
+}
+
+// activityWindow returns the first date included by the request's "days"
+// parameter.
+func (s *Server) activityWindow(w http.ResponseWriter, r *http.Request) (string, bool) {
+	days := defaultActivityDays
+	if d := r.URL.Query().Get("days"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 1 || n > 3660 {
+			s.jsonError(w, "days must be between 1 and 3660", http.StatusBadRequest)
+			return "", false
+		}
+		days = n
+	}
+	return time.Now().UTC().AddDate(0, 0, -(days - 1)).Format("2006-01-02"), true
+}
+
+func sortedDays(byDate map[string]*activityDay) []*activityDay {
+	result := make([]*activityDay, 0, len(byDate))
+	for _, day := range byDate {
+		result = append(result, day)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Date < result[j].Date })
+	return result
+}
+
+// handleRepoActivity returns a repository's commits and reviews per day, with
+// a per-user breakdown. Days without activity are omitted.
+//
+//	GET /api/v1/repos/activity?owner=..&name=..[&days=N]
+func (s *Server) handleRepoActivity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+	if owner == "" || name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	since, ok := s.activityWindow(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.checkRepoRead(w, r, owner, name) {
+		return
+	}
+
+	idx, err := s.activity.Repo(owner, name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("load activity failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	byDate := make(map[string]*activityDay)
+	for date, users := range idx.Days {
+		if date < since {
+			continue
+		}
+		day := &activityDay{Date: date, Users: make(map[string]activity.Counts)}
+		for user, c := range users {
+			day.Commits += c.Commits
+			day.Reviews += c.Reviews
+			day.Users[user] = *c
+		}
+		byDate[date] = day
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"since":   since,
+		"days":    sortedDays(byDate),
+	})
+}
+
+// handleUserActivity returns a user's contribution graph: their commits and
+// reviews per day across every repository, with a per-repository breakdown.
+// Private repositories only count when the user asks for their own graph.
+//
+//	GET /api/v1/users/activity?username=..[&days=N]
+func (s *Server) handleUserActivity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		s.jsonError(w, "username required", http.StatusBadRequest)
+		return
+	}
+
+	since, ok := s.activityWindow(w, r)
+	if !ok {
+		return
+	}
+
+	requester := ""
+	if r.Header.Get("Authorization") != "" {
+		user, ok := s.bearerUser(w, r)
+		if !ok {
+			return
+		}
+		requester = user
+	}
+
+	repos, err := s.storage.ListRepos()
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list repos failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	byDate := make(map[string]*activityDay)
+	for _, repo := range repos {
+		meta, err := s.storage.GetMetadata(repo.Owner, repo.Name)
+		if err != nil || meta.ReplicaOf != nil {
+			continue
+		}
+		if meta.Private && requester != repo.Owner {
+			continue
+		}
+
+		idx, err := s.activity.Repo(repo.Owner, repo.Name)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("load activity failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		for date, users := range idx.Days {
+			c, ok := users[username]
+			if !ok || date < since {
+				continue
+			}
+			day, ok := byDate[date]
+			if !ok {
+				day = &activityDay{Date: date, Repos: make(map[string]activity.Counts)}
+				byDate[date] = day
+			}
+			day.Commits += c.Commits
+			day.Reviews += c.Reviews
+			day.Repos[repo.Owner+"/"+repo.Name] = *c
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"username": username,
+		"since":    since,
+		"days":     sortedDays(byDate),
+	})
+}
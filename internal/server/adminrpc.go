@@ -0,0 +1,408 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/adminpb"
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// rpcActorKey holds, in a call's context, the admin a gRPC admin call was
+// authenticated as by their client certificate.
+type rpcActorKey struct{}
+
+// rpcActor returns the admin a gRPC admin call is made by.
+func rpcActor(ctx context.Context) actor {
+	a, _ := ctx.Value(rpcActorKey{}).(actor)
+	return a
+}
+
+// AdminRPC returns the gRPC admin service described by
+// proto/openhub/admin/v1/admin.proto, serving over tlsConfig. Clients must
+// present a certificate verified against its client CAs whose common name is
+// an admin's username.
+//
+// Changes go through the same operations as the matching /api/v1 requests,
+// so they are checked, applied and audited exactly as they are over HTTP.
+func (s *Server) AdminRPC(tlsConfig *tls.Config) *grpc.Server {
+	cfg := tlsConfig.Clone()
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	srv := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(cfg)),
+		grpc.UnaryInterceptor(s.rpcAuthenticate),
+	)
+	adminpb.RegisterAdminServiceServer(srv, &adminService{s: s})
+	return srv
+}
+
+// rpcAuthenticate admits calls whose client certificate names an admin.
+func (s *Server) rpcAuthenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no client certificate")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "no client certificate")
+	}
+
+	a := actor{user: tlsInfo.State.VerifiedChains[0][0].Subject.CommonName, client: p.Addr.String()}
+	if host, _, err := net.SplitHostPort(a.client); err == nil {
+		a.client = host
+	}
+	if !s.admins[a.user] {
+		s.auditBy(a, audit.Entry{Action: "auth.denied", Target: info.FullMethod, Detail: "admin access required"})
+		return nil, status.Error(codes.PermissionDenied, "admin access required")
+	}
+	return handler(context.WithValue(ctx, rpcActorKey{}, a), req)
+}
+
+type adminService struct {
+	adminpb.UnimplementedAdminServiceServer
+	s *Server
+}
+
+// rpcError is the gRPC status for an operation's error.
+func rpcError(err error) error {
+	var e *opError
+	if errors.As(err, &e) {
+		return status.Error(rpcCode(e.status), e.msg)
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// rpcCode is the gRPC status code for an HTTP error status.
+func rpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusBadGateway:
+		return codes.Unavailable
+	case http.StatusRequestEntityTooLarge, http.StatusInsufficientStorage:
+		return codes.ResourceExhausted
+	default:
+		return codes.Internal
+	}
+}
+
+func repoRef(ref *adminpb.RepoRef) (string, string, error) {
+	if ref.GetOwner() == "" || ref.GetName() == "" {
+		return "", "", status.Error(codes.InvalidArgument, "owner and name required")
+	}
+	return ref.GetOwner(), ref.GetName(), nil
+}
+
+func (a *adminService) CreateRepo(ctx context.Context, req *adminpb.CreateRepoRequest) (*adminpb.Repo, error) {
+	caller := rpcActor(ctx)
+	owner, name := req.GetOwner(), req.GetName()
+	if err := a.s.createRepo(caller, owner, name); err != nil {
+		return nil, rpcError(err)
+	}
+	if req.GetDescription() != "" {
+		if err := a.setMetadata(caller, owner, name, map[string]string{"description": req.GetDescription()}); err != nil {
+			return nil, err
+		}
+	}
+	if req.GetPrivate() {
+		if _, err := a.s.setVisibility(caller, owner, name, true, ""); err != nil {
+			return nil, rpcError(err)
+		}
+	}
+	return a.repo(owner, name)
+}
+
+// setMetadata sets the metadata fields given.
+func (a *adminService) setMetadata(caller actor, owner, name string, fields map[string]string) error {
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return status.Errorf(codes.Internal, "encode metadata: %v", err)
+	}
+	if err := a.s.setMetadata(caller, owner, name, body); err != nil {
+		return rpcError(err)
+	}
+	return nil
+}
+
+func (a *adminService) DeleteRepo(ctx context.Context, ref *adminpb.RepoRef) (*adminpb.DeleteRepoResponse, error) {
+	owner, name, err := repoRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.s.deleteRepo(rpcActor(ctx), owner, name); err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.DeleteRepoResponse{}, nil
+}
+
+func (a *adminService) ListRepos(ctx context.Context, req *adminpb.ListReposRequest) (*adminpb.ListReposResponse, error) {
+	var repos []storage.Repo
+	var err error
+	if req.GetOwner() != "" {
+		repos, err = a.s.storage.ListReposByOwner(req.GetOwner())
+	} else {
+		repos, err = a.s.storage.ListRepos()
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "list failed: %v", err)
+	}
+
+	resp := &adminpb.ListReposResponse{}
+	for _, r := range repos {
+		repo, err := a.repo(r.Owner, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		resp.Repos = append(resp.Repos, repo)
+	}
+	return resp, nil
+}
+
+func (a *adminService) GetRepo(ctx context.Context, ref *adminpb.RepoRef) (*adminpb.Repo, error) {
+	owner, name, err := repoRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	return a.repo(owner, name)
+}
+
+func (a *adminService) UpdateRepo(ctx context.Context, req *adminpb.UpdateRepoRequest) (*adminpb.Repo, error) {
+	owner, name, err := repoRef(req.GetRepo())
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetUpdateMask()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "update_mask required")
+	}
+
+	fields := map[string]string{}
+	setPrivate := false
+	for _, field := range req.GetUpdateMask() {
+		switch field {
+		case "description":
+			fields["description"] = req.GetDescription()
+		case "default_branch":
+			fields["default_branch"] = req.GetDefaultBranch()
+		case "private":
+			setPrivate = true
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown field in update_mask: %s", field)
+		}
+	}
+
+	caller := rpcActor(ctx)
+	if len(fields) > 0 {
+		if err := a.setMetadata(caller, owner, name, fields); err != nil {
+			return nil, err
+		}
+	}
+	if setPrivate {
+		if _, err := a.s.setVisibility(caller, owner, name, req.GetPrivate(), owner+"/"+name); err != nil {
+			return nil, rpcError(err)
+		}
+	}
+	return a.repo(owner, name)
+}
+
+// repo describes a repository for the admin service.
+func (a *adminService) repo(owner, name string) (*adminpb.Repo, error) {
+	if !a.s.storage.RepoExists(owner, name) {
+		return nil, status.Error(codes.NotFound, "repository not found")
+	}
+	meta, err := a.s.storage.GetMetadata(owner, name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "get metadata failed: %v", err)
+	}
+
+	repo := &adminpb.Repo{
+		Owner:         owner,
+		Name:          name,
+		Description:   meta.Description,
+		Private:       meta.Private,
+		DefaultBranch: meta.DefaultBranch,
+		CloneUrl:      fmt.Sprintf("%s/%s/%s.git", a.s.externalURL, owner, name),
+		CreatedAt:     timestamp(meta.CreatedAt),
+		ForkOf:        meta.ForkOf,
+	}
+	if meta.ReplicaOf != nil {
+		repo.ReplicaOf = meta.ReplicaOf.InstanceID
+	}
+	return repo, nil
+}
+
+func (a *adminService) CreateUser(ctx context.Context, req *adminpb.CreateUserRequest) (*adminpb.CreateUserResponse, error) {
+	recoveryCodes, err := a.s.createUser(rpcActor(ctx), req.GetUsername(), "")
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	user, err := a.user(req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+	return &adminpb.CreateUserResponse{User: user, RecoveryCodes: recoveryCodes}, nil
+}
+
+func (a *adminService) ListUsers(ctx context.Context, req *adminpb.ListUsersRequest) (*adminpb.ListUsersResponse, error) {
+	users, err := a.s.authStore.ListUsers()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "list users failed: %v", err)
+	}
+
+	resp := &adminpb.ListUsersResponse{}
+	for i := range users {
+		resp.Users = append(resp.Users, userMessage(&users[i]))
+	}
+	return resp, nil
+}
+
+func (a *adminService) AddSSHKey(ctx context.Context, req *adminpb.AddSSHKeyRequest) (*adminpb.User, error) {
+	caller := rpcActor(ctx)
+	username, err := a.account(caller, req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+	if err := a.s.addSSHKey(caller, username, req.GetName(), req.GetPublicKey(), nil); err != nil {
+		return nil, rpcError(err)
+	}
+	return a.user(username)
+}
+
+func (a *adminService) GenerateToken(ctx context.Context, req *adminpb.GenerateTokenRequest) (*adminpb.GenerateTokenResponse, error) {
+	caller := rpcActor(ctx)
+	username, err := a.account(caller, req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+	token, err := a.s.generateToken(caller, username, req.GetName(), nil)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.GenerateTokenResponse{Token: token}, nil
+}
+
+// account returns the user a key or token call acts on, as accountUser
+// does for HTTP: the caller, unless username names another user.
+func (a *adminService) account(caller actor, username string) (string, error) {
+	if username == "" {
+		return caller.user, nil
+	}
+	if _, err := a.s.authStore.GetUser(username); err != nil {
+		return "", status.Error(codes.NotFound, "user not found")
+	}
+	return username, nil
+}
+
+func (a *adminService) user(username string) (*adminpb.User, error) {
+	user, err := a.s.authStore.GetUser(username)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "get user failed: %v", err)
+	}
+	return userMessage(user), nil
+}
+
+func userMessage(user *auth.User) *adminpb.User {
+	msg := &adminpb.User{Username: user.Username, CreatedAt: timestamp(user.CreatedAt)}
+	for _, k := range user.SSHKeys {
+		msg.SshKeys = append(msg.SshKeys, &adminpb.SSHKey{Name: k.Name, PublicKey: k.Key, AddedAt: timestamp(k.AddedAt)})
+	}
+	return msg
+}
+
+func (a *adminService) AddReplica(ctx context.Context, req *adminpb.AddReplicaRequest) (*adminpb.Replica, error) {
+	owner, name, err := repoRef(req.GetRepo())
+	if err != nil {
+		return nil, err
+	}
+	replica, _, err := a.s.addReplica(rpcActor(ctx), addReplicaRequest{Owner: owner, Name: name, URL: req.GetUrl(), Refs: req.GetRefs(), AllowChain: req.GetAllowChain()})
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return replicaMessage(replica), nil
+}
+
+func (a *adminService) RemoveReplica(ctx context.Context, req *adminpb.RemoveReplicaRequest) (*adminpb.RemoveReplicaResponse, error) {
+	owner, name, err := repoRef(req.GetRepo())
+	if err != nil {
+		return nil, err
+	}
+	if err := a.s.removeReplica(rpcActor(ctx), owner, name, req.GetInstanceId()); err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.RemoveReplicaResponse{}, nil
+}
+
+func (a *adminService) ListReplicas(ctx context.Context, ref *adminpb.RepoRef) (*adminpb.ListReplicasResponse, error) {
+	owner, name, err := repoRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !a.s.storage.RepoExists(owner, name) {
+		return nil, status.Error(codes.NotFound, "repository not found")
+	}
+	meta, err := a.s.storage.GetMetadata(owner, name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "get metadata failed: %v", err)
+	}
+
+	resp := &adminpb.ListReplicasResponse{}
+	for _, rep := range meta.Replicas {
+		resp.Replicas = append(resp.Replicas, replicaMessage(rep))
+	}
+	return resp, nil
+}
+
+// replicaMessage describes a replica, leaving out its token and invitation
+// key.
+func replicaMessage(rep storage.Replica) *adminpb.Replica {
+	return &adminpb.Replica{
+		InstanceId: rep.InstanceID,
+		Url:        rep.URL,
+		Enabled:    rep.Enabled,
+		Refs:       rep.Refs,
+		AllowChain: rep.AllowChain,
+		LastSynced: timestamp(rep.LastSynced),
+		LastError:  rep.LastError,
+	}
+}
+
+func (a *adminService) ForceSync(ctx context.Context, ref *adminpb.RepoRef) (*adminpb.ForceSyncResponse, error) {
+	owner, name, err := repoRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.s.forceSync(rpcActor(ctx), owner, name); err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.ForceSyncResponse{}, nil
+}
+
+// timestamp converts t, leaving the zero time unset.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
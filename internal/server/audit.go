@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/clientip"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 10000
+)
+
+// AuditLog records security-relevant actions.
+type AuditLog interface {
+	Record(e audit.Entry) error
+	Query(f audit.Filter, limit int) ([]audit.Entry, error)
+}
+
+// SetAudit records repository, replica, account and failed authentication
+// events in log, and serves it to admins at /api/v1/admin/audit.
+func (s *Server) SetAudit(log AuditLog) {
+	s.auditLog = log
+}
+
+func (s *Server) audit(r *http.Request, e audit.Entry) {
+	e.Client = clientip.FromRequest(r)
+	s.record(e)
+}
+
+// actor is who an operation is done for: a user, and the address their
+// request came from, for the audit log.
+type actor struct {
+	user   string
+	client string
+}
+
+// requestActor is user, making the request r.
+func requestActor(r *http.Request, user string) actor {
+	return actor{user: user, client: clientip.FromRequest(r)}
+}
+
+// auditBy adds e to the audit log as done by a.
+func (s *Server) auditBy(a actor, e audit.Entry) {
+	e.Actor = a.user
+	e.Client = a.client
+	s.record(e)
+}
+
+func (s *Server) record(e audit.Entry) {
+	if s.auditLog == nil {
+		return
+	}
+	if err := s.auditLog.Record(e); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+// requestUser returns the user a request's bearer token belongs to, or ""
+// for an anonymous request or a bad token. It is for attributing actions on
+// endpoints that don't require authentication.
+func (s *Server) requestUser(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	username, err := s.authStore.ValidateAPIToken(token, clientip.FromRequest(r))
+	if err != nil {
+		return ""
+	}
+	return username
+}
+
+// handleAuditLog returns audit log entries, oldest first. Action may end in
+// "." to match every action with that prefix; since and until are RFC 3339
+// times or durations before now ("24h").
+//
+//	GET /api/v1/admin/audit[?actor=..][&action=..][&target=..][&since=..][&until=..][&limit=N]
+func (s *Server) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+
+	if s.auditLog == nil {
+		s.jsonError(w, "audit log is not enabled", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	f := audit.Filter{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+	}
+
+	var err error
+	if f.Since, err = audit.ParseTime(q.Get("since"), time.Now()); err != nil {
+		s.jsonError(w, fmt.Sprintf("since: %v", err), http.StatusBadRequest)
+		return
+	}
+	if f.Until, err = audit.ParseTime(q.Get("until"), time.Now()); err != nil {
+		s.jsonError(w, fmt.Sprintf("until: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultAuditLimit
+	if l := q.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			s.jsonError(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := s.auditLog.Query(f, limit)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("query audit log failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"entries": entries,
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/clientip"
 )
 
 type contextKey string
@@ -11,7 +13,7 @@ type contextKey string
 const userContextKey contextKey = "user"
 
 type TokenValidator interface {
-	ValidateAPIToken(token string) (string, error)
+	ValidateAPIToken(token, ip string) (string, error)
 }
 
 func AuthMiddleware(validator TokenValidator) func(http.Handler) http.Handler {
@@ -30,7 +32,7 @@ func AuthMiddleware(validator TokenValidator) func(http.Handler) http.Handler {
 			}
 
 			token := parts[1]
-			username, err := validator.ValidateAPIToken(token)
+			username, err := validator.ValidateAPIToken(token, clientip.FromRequest(r))
 			if err != nil {
 				http.Error(w, "invalid token", http.StatusUnauthorized)
 				return
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/quota"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	// maxBatchOps caps how many operations one batch request may carry.
+	maxBatchOps = 500
+	// maxTopics caps how many topics a repository may have.
+	maxTopics = 20
+)
+
+// batchOp is one operation in a batch: "create" a repository, optionally
+// private and with a description, "set-visibility" of one, or "add-topic"
+// to one.
+type batchOp struct {
+	Op          string `json:"op"`
+	Owner       string `json:"owner"`
+	Name        string `json:"name"`
+	Private     *bool  `json:"private,omitempty"`
+	Description string `json:"description,omitempty"`
+	Topic       string `json:"topic,omitempty"`
+}
+
+type batchResult struct {
+	Op    string `json:"op"`
+	Repo  string `json:"repo"`
+	Error string `json:"error,omitempty"`
+}
+
+// validTopic reports whether t is a lowercase topic of letters, digits and
+// inner hyphens, at most 35 characters long.
+func validTopic(t string) bool {
+	if t == "" || len(t) > 35 || strings.HasPrefix(t, "-") || strings.HasSuffix(t, "-") {
+		return false
+	}
+	for _, c := range t {
+		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// handleBatch runs a list of repository operations for an admin migrating
+// many repositories at once. Every operation is checked before any is
+// applied, and nothing is applied if one fails the check. If applying one
+// fails, repositories the batch created are deleted and the metadata it
+// changed is put back; events, audit entries and replication only follow a
+// batch that applied completely.
+//
+//	POST /api/v1/repos/batch {"operations": [{"op", "owner", "name", ...}]}
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	admin := s.requestUser(r)
+
+	var req struct {
+		Operations []batchOp `json:"operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) == 0 {
+		s.jsonError(w, "operations required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) > maxBatchOps {
+		s.jsonError(w, fmt.Sprintf("at most %d operations per batch", maxBatchOps), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]batchResult, len(req.Operations))
+	if !s.checkBatch(req.Operations, results) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "batch rejected, nothing was applied",
+			"results": results,
+		})
+		return
+	}
+
+	// Snapshots of the metadata of existing repositories the batch changes,
+	// and the repositories it creates, for rolling back.
+	saved := map[string]storage.Metadata{}
+	var created []string
+	failed := -1
+	for i, o := range req.Operations {
+		full := o.Owner + "/" + o.Name
+		if _, ok := saved[full]; !ok && o.Op != "create" && !slices.Contains(created, full) {
+			meta, err := s.storage.GetMetadata(o.Owner, o.Name)
+			if err != nil {
+				results[i].Error = err.Error()
+				failed = i
+				break
+			}
+			saved[full] = meta
+		}
+
+		if err := s.applyBatchOp(o); err != nil {
+			results[i].Error = err.Error()
+			failed = i
+			break
+		}
+		if o.Op == "create" {
+			created = append(created, full)
+		}
+	}
+
+	if failed >= 0 {
+		for _, full := range created {
+			owner, name, _ := strings.Cut(full, "/")
+			if err := s.storage.DeleteRepo(owner, name); err != nil {
+				log.Printf("batch: roll back create of %s failed: %v", full, err)
+			}
+		}
+		for full, meta := range saved {
+			owner, name, _ := strings.Cut(full, "/")
+			err := s.storage.UpdateMetadata(owner, name, func(m *storage.Metadata) error {
+				*m = meta
+				return nil
+			})
+			if err != nil {
+				log.Printf("batch: roll back metadata of %s failed: %v", full, err)
+			}
+		}
+		for i := failed + 1; i < len(results); i++ {
+			results[i].Error = "not run"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("operation %d failed, batch rolled back", failed),
+			"results": results,
+		})
+		return
+	}
+
+	changed := map[string]bool{}
+	for _, o := range req.Operations {
+		full := o.Owner + "/" + o.Name
+		switch o.Op {
+		case "create":
+			s.repoCreated(requestActor(r, admin), o.Owner, o.Name)
+		case "set-visibility":
+			s.audit(r, audit.Entry{Actor: admin, Action: "repo.visibility", Target: full, Detail: fmt.Sprintf("private=%t", *o.Private)})
+			changed[full] = true
+		case "add-topic":
+			changed[full] = true
+		}
+	}
+	for full := range changed {
+		owner, name, _ := strings.Cut(full, "/")
+		if meta, err := s.storage.GetMetadata(owner, name); err == nil && len(meta.Replicas) > 0 && s.replQueue != nil {
+			s.replQueue.QueueMetadata(owner, name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"results": results,
+	})
+}
+
+// checkBatch validates every operation, as if those before it had been
+// applied, recording why each invalid one would fail.
+func (s *Server) checkBatch(ops []batchOp, results []batchResult) bool {
+	creating := map[string]bool{}
+	ok := true
+	for i, o := range ops {
+		full := o.Owner + "/" + o.Name
+		results[i] = batchResult{Op: o.Op, Repo: full}
+
+		fail := func(msg string) {
+			results[i].Error = msg
+			ok = false
+		}
+
+		if !isValidName(o.Owner) || !isValidName(o.Name) {
+			fail("invalid owner or name")
+			continue
+		}
+		exists := creating[full] || s.storage.RepoExists(o.Owner, o.Name)
+
+		switch o.Op {
+		case "create":
+			if exists {
+				fail("repository already exists")
+				continue
+			}
+			if s.quotas != nil {
+				st, err := s.quotas.Status(o.Owner)
+				if err != nil {
+					fail(fmt.Sprintf("check quota failed: %v", err))
+					continue
+				}
+				if st.Level == quota.LevelEnforced {
+					fail(st.Message)
+					continue
+				}
+			}
+			creating[full] = true
+
+		case "set-visibility", "add-topic":
+			if !exists {
+				fail("repository not found")
+				continue
+			}
+			if !creating[full] {
+				meta, err := s.storage.GetMetadata(o.Owner, o.Name)
+				if err != nil {
+					fail(fmt.Sprintf("get metadata failed: %v", err))
+					continue
+				}
+				if meta.ReplicaOf != nil {
+					fail("repository is a read-only replica")
+					continue
+				}
+			}
+			if o.Op == "set-visibility" && o.Private == nil {
+				fail("private required")
+			}
+			if o.Op == "add-topic" && !validTopic(o.Topic) {
+				fail("topics are up to 35 lowercase letters, digits and hyphens")
+			}
+
+		default:
+			fail(fmt.Sprintf("unknown op %q", o.Op))
+		}
+	}
+	return ok
+}
+
+func (s *Server) applyBatchOp(o batchOp) error {
+	switch o.Op {
+	case "create":
+		if err := s.storage.CreateRepo(o.Owner, o.Name); err != nil {
+			return fmt.Errorf("create failed: %w", err)
+		}
+		if o.Private == nil && o.Description == "" {
+			return nil
+		}
+		return s.storage.UpdateMetadata(o.Owner, o.Name, func(m *storage.Metadata) error {
+			m.Description = o.Description
+			if o.Private != nil {
+				m.Private = *o.Private
+			}
+			return nil
+		})
+
+	case "set-visibility":
+		return s.storage.UpdateMetadata(o.Owner, o.Name, func(m *storage.Metadata) error {
+			m.Private = *o.Private
+			return nil
+		})
+
+	case "add-topic":
+		return s.storage.UpdateMetadata(o.Owner, o.Name, func(m *storage.Metadata) error {
+			if slices.Contains(m.Topics, o.Topic) {
+				return nil
+			}
+			if len(m.Topics) >= maxTopics {
+				return fmt.Errorf("a repository can have at most %d topics", maxTopics)
+			}
+			m.Topics = append(m.Topics, o.Topic)
+			return nil
+		})
+	}
+	return fmt.Errorf("unknown op %q", o.Op)
+}
@@ -0,0 +1,534 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	pathpkg "path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jeremytregunna/openhub/internal/markup"
+	"github.com/jeremytregunna/openhub/internal/signing"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	// maxBlobSize is the largest file the blob endpoint returns the
+	// contents of; larger files are described without them.
+	maxBlobSize = 1 << 20
+
+	defaultLogLimit = 30
+	maxLogLimit     = 100
+)
+
+// SetSSHPort sets the port the SSH server listens on, for the SSH clone
+// URLs shown when browsing a repository. Zero leaves them out.
+func (s *Server) SetSSHPort(port int) {
+	s.sshPort = port
+}
+
+// sshCloneURL returns the SSH URL of owner/name on the host r was sent to,
+// or "" if the SSH port isn't known. The SSH server tells users apart by
+// their key, so the user in the URL is only a placeholder.
+func (s *Server) sshCloneURL(r *http.Request, owner, name string) string {
+	if s.sshPort == 0 {
+		return ""
+	}
+	u, err := url.Parse(s.baseURL(r))
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	host := u.Hostname()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return fmt.Sprintf("ssh://git@%s:%d/%s/%s.git", host, s.sshPort, owner, name)
+}
+
+// handleRepoRefs describes a repository for browsing: its branches and
+// tags, with whether each tag's signature is verified, default branch and
+// clone URLs.
+//
+//	GET /api/v1/repos/refs?owner=..&name=..
+func (s *Server) handleRepoRefs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+	if owner == "" || name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoRead(w, r, owner, name) {
+		return
+	}
+
+	meta, err := s.storage.GetMetadata(owner, name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	refs, err := s.storage.ListRefs(owner, name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list refs failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	type ref struct {
+		Name string `json:"name"`
+		SHA  string `json:"sha"`
+		// Verification is only given for tags; a lightweight tag is
+		// unsigned.
+		Verification *signing.Verification `json:"verification,omitempty"`
+	}
+	branches, tags := []ref{}, []ref{}
+	for full, sha := range refs {
+		if b, ok := strings.CutPrefix(full, "refs/heads/"); ok {
+			branches = append(branches, ref{Name: b, SHA: sha})
+		} else if t, ok := strings.CutPrefix(full, "refs/tags/"); ok {
+			tags = append(tags, ref{Name: t, SHA: sha})
+		}
+	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Name < branches[j].Name })
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+	shas := make([]string, len(tags))
+	for i, t := range tags {
+		shas[i] = t.SHA
+	}
+	verifications, err := s.verifySignatures(owner, name, shas)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("verify signatures failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for i := range tags {
+		tags[i].Verification = &verifications[i]
+	}
+
+	resp := map[string]interface{}{
+		"success":        true,
+		"description":    meta.Description,
+		"private":        meta.Private,
+		"default_branch": meta.DefaultBranch,
+		"branches":       branches,
+		"tags":           tags,
+		"clone_url":      fmt.Sprintf("%s/%s/%s.git", s.baseURL(r), owner, name),
+	}
+	if u := s.sshCloneURL(r, owner, name); u != "" {
+		resp["ssh_url"] = u
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleTree lists a directory of a repository at a ref, the default
+// branch unless one is given.
+//
+//	GET /api/v1/repos/tree?owner=..&name=..[&ref=..][&path=..]
+func (s *Server) handleTree(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, name, commit, path, ok := s.browseParams(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := s.storage.ListTree(owner, name, commit, path)
+	if errors.Is(err, storage.ErrPathNotFound) {
+		s.jsonError(w, fmt.Sprintf("directory not found: %s", path), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list tree failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"commit":  commit,
+		"path":    path,
+		"entries": entries,
+	})
+}
+
+// handleBlob returns a file of a repository at a ref. Text is returned as
+// is, highlighted if it is source code in a language the highlighter knows
+// and rendered if it is Markdown; anything else is base64-encoded. Files
+// over maxBlobSize are described without their contents. With raw, the
+// file itself is served instead, as an image or as plain text or bytes.
+//
+//	GET /api/v1/repos/blob?owner=..&name=..&path=..[&ref=..][&raw=true]
+func (s *Server) handleBlob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, name, commit, path, ok := s.browseParams(w, r)
+	if !ok {
+		return
+	}
+	if path == "" {
+		s.jsonError(w, "path required", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := s.storage.StatFile(owner, name, commit, path)
+	if errors.Is(err, storage.ErrPathNotFound) {
+		s.jsonError(w, fmt.Sprintf("file not found: %s", path), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("stat file failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if entry.Type != "blob" {
+		s.jsonError(w, fmt.Sprintf("not a file: %s", path), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("raw") == "true" {
+		s.serveRaw(w, owner, name, commit, entry)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"success": true,
+		"commit":  commit,
+		"entry":   entry,
+	}
+	if entry.Size > maxBlobSize {
+		resp["too_large"] = true
+	} else {
+		data, err := s.storage.ReadFile(owner, name, commit, path)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("read file failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if isText(data) {
+			resp["encoding"] = "utf-8"
+			resp["content"] = string(data)
+			if lang := markup.Language(path); lang != "" {
+				resp["language"] = lang
+				resp["highlighted"] = markup.Highlight(lang, string(data))
+			}
+			if markup.IsMarkdown(path) {
+				resp["rendered"] = markup.Markdown(data, s.markdownLinks(r, owner, name, commit, pathpkg.Dir(path)))
+			}
+		} else {
+			resp["encoding"] = "base64"
+			resp["content"] = base64.StdEncoding.EncodeToString(data)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// rawImageTypes are the content types raw files are served with by
+// extension. Anything else is served as plain text or bytes, so a file
+// can't be served as a page of this site.
+var rawImageTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".ico":  "image/x-icon",
+}
+
+func (s *Server) serveRaw(w http.ResponseWriter, owner, name, commit string, entry storage.TreeEntry) {
+	ctype, ok := rawImageTypes[strings.ToLower(pathpkg.Ext(entry.Name))]
+	if !ok {
+		ctype = "application/octet-stream"
+		if entry.Size <= maxBlobSize {
+			if data, err := s.storage.ReadFile(owner, name, commit, entry.Path); err == nil && isText(data) {
+				ctype = "text/plain; charset=utf-8"
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if ctype == "application/octet-stream" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": entry.Name}))
+	}
+	if err := s.storage.CopyFile(owner, name, commit, entry.Path, w); err != nil {
+		log.Printf("serve %s/%s:%s: %v", owner, name, entry.Path, err)
+	}
+}
+
+// handleReadme renders the README of a directory, by default the root, at
+// a ref: Markdown as HTML, and anything else as preformatted text.
+//
+//	GET /api/v1/repos/readme?owner=..&name=..[&ref=..][&path=..]
+func (s *Server) handleReadme(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, name, commit, dir, ok := s.browseParams(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := s.storage.ListTree(owner, name, commit, dir)
+	if errors.Is(err, storage.ErrPathNotFound) {
+		s.jsonError(w, fmt.Sprintf("directory not found: %s", dir), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list tree failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Prefer a Markdown README to any other.
+	var readme *storage.TreeEntry
+	for i, e := range entries {
+		if e.Type != "blob" || !strings.HasPrefix(strings.ToLower(e.Name), "readme") {
+			continue
+		}
+		if readme == nil || markup.IsMarkdown(e.Name) && !markup.IsMarkdown(readme.Name) {
+			readme = &entries[i]
+		}
+	}
+	if readme == nil {
+		s.jsonError(w, "no readme", http.StatusNotFound)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"success": true,
+		"commit":  commit,
+		"entry":   readme,
+	}
+	if readme.Size > maxBlobSize {
+		resp["too_large"] = true
+	} else {
+		data, err := s.storage.ReadFile(owner, name, commit, readme.Path)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("read file failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if markup.IsMarkdown(readme.Name) {
+			resp["html"] = markup.Markdown(data, s.markdownLinks(r, owner, name, commit, dir))
+		} else {
+			resp["html"] = "<pre>" + html.EscapeString(string(data)) + "</pre>"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// markdownLinks points the relative links of a Markdown file in dir at the
+// web interface's views of the files they name, keeping to the ref being
+// browsed, and its images at their raw contents in commit.
+func (s *Server) markdownLinks(r *http.Request, owner, name, commit, dir string) markup.LinkFunc {
+	ref := r.URL.Query().Get("ref")
+	return func(dest string, image bool) (string, bool) {
+		p, _, _ := strings.Cut(dest, "#")
+		p, _, _ = strings.Cut(p, "?")
+		if p == "" {
+			return "", false
+		}
+		if u, err := url.PathUnescape(p); err == nil {
+			p = u
+		}
+		if strings.HasPrefix(p, "/") {
+			p = pathpkg.Clean(p)[1:]
+		} else {
+			p = pathpkg.Join(dir, p)
+		}
+		if p == ".." || strings.HasPrefix(p, "../") {
+			return "", false
+		}
+		if p == "." {
+			p = ""
+		}
+
+		q := url.Values{}
+		if image {
+			q.Set("owner", owner)
+			q.Set("name", name)
+			q.Set("ref", commit)
+			q.Set("path", p)
+			q.Set("raw", "true")
+			return apiV1 + "/repos/blob?" + q.Encode(), true
+		}
+		if ref != "" {
+			q.Set("ref", ref)
+		}
+		view := "tree"
+		if p != "" {
+			view = "blob"
+			q.Set("path", p)
+		}
+		return "/ui/#/" + url.PathEscape(owner) + "/" + url.PathEscape(name) + "/" + view + "?" + q.Encode(), true
+	}
+}
+
+// isText reports whether data looks like text rather than a binary file.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// handleCommits lists the history of a ref, newest first, optionally only
+// the commits that touched a path. skip and limit page through it. Each
+// commit says whether its signature is verified against a user's signing
+// keys.
+//
+//	GET /api/v1/repos/commits?owner=..&name=..[&ref=..][&path=..][&skip=N][&limit=N]
+func (s *Server) handleCommits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	skip := 0
+	if v := q.Get("skip"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.jsonError(w, "skip must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		skip = n
+	}
+	limit := defaultLogLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLogLimit {
+			s.jsonError(w, fmt.Sprintf("limit must be between 1 and %d", maxLogLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	owner, name, commit, path, ok := s.browseParams(w, r)
+	if !ok {
+		return
+	}
+
+	// Ask for one more than the page so the client knows whether there is
+	// another.
+	commits, err := s.storage.Log(owner, name, commit, path, skip, limit+1)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("log failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	more := len(commits) > limit
+	if more {
+		commits = commits[:limit]
+	}
+
+	shas := make([]string, len(commits))
+	for i, c := range commits {
+		shas[i] = c.SHA
+	}
+	verifications, err := s.verifySignatures(owner, name, shas)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("verify signatures failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	type verifiedCommit struct {
+		storage.Commit
+		Verification signing.Verification `json:"verification"`
+	}
+	result := make([]verifiedCommit, len(commits))
+	for i, c := range commits {
+		result[i] = verifiedCommit{c, verifications[i]}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"commit":  commit,
+		"commits": result,
+		"more":    more,
+	})
+}
+
+// browseParams reads the owner, name, ref and path of a browsing request,
+// checks the user can read the repository, and resolves the ref, by
+// default the repository's default branch, to a commit.
+func (s *Server) browseParams(w http.ResponseWriter, r *http.Request) (owner, name, commit, path string, ok bool) {
+	q := r.URL.Query()
+	owner, name = q.Get("owner"), q.Get("name")
+	if owner == "" || name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	path, valid := cleanTreePath(q.Get("path"))
+	if !valid {
+		s.jsonError(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoRead(w, r, owner, name) {
+		return
+	}
+
+	ref := q.Get("ref")
+	if ref == "" {
+		meta, err := s.storage.GetMetadata(owner, name)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ref = "HEAD"
+		if meta.DefaultBranch != "" {
+			ref = "refs/heads/" + meta.DefaultBranch
+		}
+	}
+	if strings.HasPrefix(ref, "-") {
+		s.jsonError(w, "invalid ref", http.StatusBadRequest)
+		return
+	}
+
+	commit, err := s.storage.ResolveCommit(owner, name, ref)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("unknown ref: %s", ref), http.StatusNotFound)
+		return
+	}
+	return owner, name, commit, path, true
+}
+
+// cleanTreePath trims the slashes around a path within a repository and
+// reports whether it is valid: relative, without empty, "." or ".."
+// components.
+func cleanTreePath(p string) (string, bool) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return "", true
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", false
+		}
+	}
+	return p, true
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/uploads"
+)
+
+// BundleUploads keeps replication bundles an origin sends in chunks, so an
+// upload a dropped connection cut short can carry on where it stopped.
+type BundleUploads interface {
+	CreateBundle(owner, repo, instanceID string, size int64, sha string) (*uploads.Session, error)
+	Get(id string) (*uploads.Session, error)
+	Append(id string, offset int64, r io.Reader) (*uploads.Session, error)
+	Complete(id, dest string) (*uploads.Session, error)
+	Abort(id string) error
+}
+
+// SetBundleUploads lets origins upload large bundles in chunks before
+// replicating them with upload_id.
+func (s *Server) SetBundleUploads(u BundleUploads) {
+	s.bundleUploads = u
+}
+
+// handleCreateBundleUpload starts a resumable upload of a bundle the origin
+// will replicate.
+//
+//	POST /api/v1/repos/replicate/uploads
+//	{"owner", "repo", "instance_id", "size", "sha256"}
+func (s *Server) handleCreateBundleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkClientCert(w, r) {
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	if s.bundleUploads == nil {
+		s.jsonError(w, "bundle uploads not enabled", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Owner      string `json:"owner"`
+		Repo       string `json:"repo"`
+		InstanceID string `json:"instance_id"`
+		Size       int64  `json:"size"`
+		SHA256     string `json:"sha256"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Repo == "" || req.InstanceID == "" || req.SHA256 == "" {
+		s.jsonError(w, "owner, repo, instance_id, size and sha256 required", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidName(req.Owner) || !isValidName(req.Repo) {
+		s.jsonError(w, "invalid owner or repo name", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkReplicationUser(w, username, req.Owner, req.Repo, req.InstanceID) {
+		return
+	}
+
+	sess, err := s.bundleUploads.CreateBundle(req.Owner, req.Repo, req.InstanceID, req.Size, req.SHA256)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, uploads.ErrTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		s.jsonError(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"upload":  sess,
+	})
+}
+
+// handleBundleUpload serves a single bundle upload session. The bundle is
+// applied by replicating with its upload_id.
+//
+//	GET    /api/v1/repos/replicate/uploads/{id}  current offset
+//	PATCH  /api/v1/repos/replicate/uploads/{id}  append chunk (Upload-Offset header)
+//	DELETE /api/v1/repos/replicate/uploads/{id}  abort
+func (s *Server) handleBundleUpload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, apiV1+"/repos/replicate/uploads/")
+
+	if !s.checkClientCert(w, r) {
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	if s.bundleUploads == nil {
+		s.jsonError(w, "bundle uploads not enabled", http.StatusNotFound)
+		return
+	}
+
+	sess, err := s.bundleUploads.Get(id)
+	if err != nil {
+		s.uploadError(w, err)
+		return
+	}
+	if sess.Instance == "" || username != ReplicationUsername(sess.Owner, sess.Repo, sess.Instance) {
+		s.jsonError(w, "upload not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		s.writeUpload(w, sess)
+
+	case "PATCH":
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			s.jsonError(w, "Upload-Offset header required", http.StatusBadRequest)
+			return
+		}
+		sess, err := s.bundleUploads.Append(id, offset, r.Body)
+		if err != nil {
+			if sess != nil {
+				w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+			}
+			s.uploadError(w, err)
+			return
+		}
+		s.writeUpload(w, sess)
+
+	case "DELETE":
+		if err := s.bundleUploads.Abort(id); err != nil {
+			s.uploadError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
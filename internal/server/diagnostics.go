@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// DiagnosticsSource reports the state of one part of the server, such as a
+// queue, for the diagnostics endpoint.
+type DiagnosticsSource func() (interface{}, error)
+
+// AddDiagnostics includes what fn reports, under name, in the diagnostics
+// endpoint's response.
+func (s *Server) AddDiagnostics(name string, fn DiagnosticsSource) {
+	s.diagnostics[name] = fn
+}
+
+// handleDiagnostics reports the runtime's goroutines and memory alongside
+// the state of the queues and git processes registered with AddDiagnostics,
+// for working out why a server has stalled.
+//
+//	GET /api/v1/admin/diagnostics
+func (s *Server) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	resp := map[string]interface{}{
+		"success":        true,
+		"started":        s.started,
+		"uptime_seconds": int64(time.Since(s.started).Seconds()),
+		"go_version":     runtime.Version(),
+		"cpus":           runtime.NumCPU(),
+		"goroutines":     runtime.NumGoroutine(),
+		"memory": map[string]interface{}{
+			"heap_alloc_bytes": mem.HeapAlloc,
+			"heap_sys_bytes":   mem.HeapSys,
+			"sys_bytes":        mem.Sys,
+			"gc_runs":          mem.NumGC,
+			"gc_pause_total":   time.Duration(mem.PauseTotalNs).String(),
+		},
+	}
+	for name, fn := range s.diagnostics {
+		v, err := fn()
+		if err != nil {
+			v = map[string]string{"error": err.Error()}
+		}
+		resp[name] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DebugHandler serves the runtime profiles of net/http/pprof under
+// /debug/pprof/ to admins.
+func (s *Server) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.checkAdmin(w, r) {
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
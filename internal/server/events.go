@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/events"
+)
+
+type EventBus interface {
+	Publish(e events.Event)
+	Subscribe(f events.Filter, after uint64) ([]events.Event, <-chan events.Event, func())
+}
+
+// SetEventBus publishes repository creation and new issues to bus, serves
+// its events at /api/v1/events and counts its pushes for the stats.
+func (s *Server) SetEventBus(bus EventBus) {
+	s.bus = bus
+	s.pushes = newPushCounter(bus)
+}
+
+func (s *Server) publish(e events.Event) {
+	if s.bus != nil {
+		s.bus.Publish(e)
+	}
+}
+
+// handleEvents streams instance events as server-sent events, each a JSON
+// events.Event with its sequence number as the event ID. Admins see every
+// event; other users only those about their own repositories and account.
+// A client reconnecting with Last-Event-ID (or "since") first gets the
+// retained events it missed.
+//
+//	GET /api/v1/events[?kind=push&kind=...][&repo=owner/name][&since=N]
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	if s.bus == nil {
+		s.jsonError(w, "event stream is not enabled", http.StatusNotFound)
+		return
+	}
+
+	f := events.Filter{Repo: r.URL.Query().Get("repo")}
+	for _, kind := range r.URL.Query()["kind"] {
+		if !events.Known(kind) {
+			s.jsonError(w, fmt.Sprintf("unknown event kind %q (have: %s)", kind, strings.Join(events.Kinds, ", ")), http.StatusBadRequest)
+			return
+		}
+		f.Kinds = append(f.Kinds, kind)
+	}
+	if !s.admins[username] {
+		f.Owner = username
+	}
+
+	var after uint64
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.URL.Query().Get("since")
+	}
+	if since != "" {
+		n, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			s.jsonError(w, "since must be an event ID", http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.jsonError(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	backlog, stream, cancel := s.bus.Subscribe(f, after)
+	defer cancel()
+
+	// Without a resume point there is nothing the client missed.
+	if since == "" {
+		backlog = nil
+	}
+
+	// A stream outlives any --http-write-timeout; it ends when the client
+	// goes away or the server shuts down.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e events.Event) bool {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return false
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Kind, data)
+		return err == nil
+	}
+
+	for _, e := range backlog {
+		if !send(e) {
+			return
+		}
+	}
+	// Comment line so clients see the stream is open before any event.
+	if _, err := fmt.Fprint(w, ": connected\n\n"); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(logHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case e, ok := <-stream:
+			if !ok || !send(e) {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
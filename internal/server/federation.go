@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// FederatedRepo is a public repository as advertised to other instances.
+type FederatedRepo struct {
+	Owner       string `json:"owner"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CloneURL    string `json:"clone_url"`
+	// OriginInstance is the instance the repository is pushed to: this one,
+	// or for a replica, its origin.
+	OriginInstance string `json:"origin_instance"`
+	Replica        bool   `json:"replica,omitempty"`
+}
+
+// SetInstance gives the server this instance's identity, so it can answer
+// federation handshakes.
+func (s *Server) SetInstance(inst *instance.Instance) {
+	s.instance = inst
+}
+
+// handleHandshake exchanges identities with another instance. The caller's
+// signed hello is checked and recorded in the peer registry, pinning its key
+// on first contact, and this instance's own signed hello is returned so the
+// caller can do the same.
+//
+//	POST /api/v1/federation/handshake
+func (s *Server) handleHandshake(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkClientCert(w, r) {
+		return
+	}
+
+	if s.instance == nil {
+		s.jsonError(w, "federation is not enabled on this instance", http.StatusServiceUnavailable)
+		return
+	}
+
+	var hello instance.Hello
+	if err := json.NewDecoder(r.Body).Decode(&hello); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := hello.Check(signatureMaxSkew); err != nil {
+		s.jsonError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if hello.InstanceID == s.instance.ID {
+		s.jsonError(w, "cannot handshake with this instance itself", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.peers.Record(hello.Peer()); err != nil {
+		s.jsonError(w, fmt.Sprintf("record peer failed: %v", err), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"hello":   s.instance.Hello(s.externalURL),
+	})
+}
+
+// handleFederationRepos lists this instance's public repositories for peers
+// discovering what the federation hosts. q filters on name and description,
+// case-insensitively.
+//
+//	GET /api/v1/federation/repos[?q=term]
+func (s *Server) handleFederationRepos(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkClientCert(w, r) {
+		return
+	}
+
+	repos, err := s.storage.ListRepos()
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	self := ""
+	if s.instance != nil {
+		self = s.instance.ID
+	}
+	query := strings.ToLower(r.URL.Query().Get("q"))
+
+	result := []FederatedRepo{}
+	for _, repo := range repos {
+		meta, err := s.storage.GetMetadata(repo.Owner, repo.Name)
+		if err != nil || meta.Private {
+			continue
+		}
+
+		full := repo.Owner + "/" + repo.Name
+		if query != "" && !strings.Contains(strings.ToLower(full), query) && !strings.Contains(strings.ToLower(meta.Description), query) {
+			continue
+		}
+
+		fr := FederatedRepo{
+			Owner:          repo.Owner,
+			Name:           repo.Name,
+			Description:    meta.Description,
+			CloneURL:       fmt.Sprintf("%s/%s.git", s.externalURL, full),
+			OriginInstance: self,
+		}
+		if meta.ReplicaOf != nil {
+			fr.OriginInstance = meta.ReplicaOf.InstanceID
+			fr.Replica = true
+		}
+		result = append(result, fr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"instance": self,
+		"repos":    result,
+	})
+}
+
+// RepoMirror is one place a repository can be cloned from.
+type RepoMirror struct {
+	URL        string     `json:"url"`
+	CloneURL   string     `json:"clone_url"`
+	InstanceID string     `json:"instance_id,omitempty"`
+	Origin     bool       `json:"origin,omitempty"`
+	LastSynced *time.Time `json:"last_synced,omitempty"`
+	Healthy    bool       `json:"healthy"`
+}
+
+// handleRepoMirrors lists where a public repository can be cloned from:
+// its origin first, then its mirrors across the federation, healthy and
+// most recently synced first. Replicas answer too, from the list their
+// upstream last sent them, so a client can still find the other copies
+// while the origin is down.
+//
+//	GET /api/v1/repos/mirrors?owner=..&name=..
+func (s *Server) handleRepoMirrors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+	if owner == "" || name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	var meta storage.Metadata
+	found := s.storage.RepoExists(owner, name)
+	if found {
+		var err error
+		meta, err = s.storage.GetMetadata(owner, name)
+		found = err == nil && !meta.Private
+	}
+	if !found {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	cloneURL := func(base string) string {
+		return fmt.Sprintf("%s/%s/%s.git", strings.TrimSuffix(base, "/"), owner, name)
+	}
+
+	origin := RepoMirror{Origin: true, Healthy: true}
+	if meta.ReplicaOf == nil {
+		origin.URL = s.externalURL
+		if s.instance != nil {
+			origin.InstanceID = s.instance.ID
+		}
+	} else {
+		// The origin's address is only known from its handshake.
+		origin.InstanceID = meta.ReplicaOf.InstanceID
+		if peer, ok, err := s.peers.Get(origin.InstanceID); err == nil && ok {
+			origin.URL = peer.URL
+		}
+	}
+
+	result := []RepoMirror{}
+	if origin.URL != "" {
+		origin.CloneURL = cloneURL(origin.URL)
+		result = append(result, origin)
+	}
+
+	var mirrors []RepoMirror
+	for _, m := range meta.KnownMirrors() {
+		if m.URL == origin.URL {
+			continue
+		}
+		lastSynced := m.LastSynced
+		mirrors = append(mirrors, RepoMirror{
+			URL:        m.URL,
+			CloneURL:   cloneURL(m.URL),
+			InstanceID: m.InstanceID,
+			LastSynced: &lastSynced,
+			Healthy:    m.Healthy,
+		})
+	}
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		if mirrors[i].Healthy != mirrors[j].Healthy {
+			return mirrors[i].Healthy
+		}
+		return mirrors[i].LastSynced.After(*mirrors[j].LastSynced)
+	})
+	result = append(result, mirrors...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"repository":      owner + "/" + name,
+		"origin_instance": origin.InstanceID,
+		"mirrors":         result,
+	})
+}
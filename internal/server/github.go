@@ -0,0 +1,355 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/policy"
+	"github.com/jeremytregunna/openhub/internal/quota"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// githubAPI is where the GitHub-compatible subset is served, at the same
+// path as GitHub Enterprise's REST API so clients only need the host.
+const githubAPI = "/api/v3"
+
+type githubUser struct {
+	Login   string `json:"login"`
+	ID      int64  `json:"id"`
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	HTMLURL string `json:"html_url"`
+}
+
+type githubPermissions struct {
+	Admin bool `json:"admin"`
+	Push  bool `json:"push"`
+	Pull  bool `json:"pull"`
+}
+
+type githubRepo struct {
+	ID            int64              `json:"id"`
+	Name          string             `json:"name"`
+	FullName      string             `json:"full_name"`
+	Owner         githubUser         `json:"owner"`
+	Private       bool               `json:"private"`
+	Visibility    string             `json:"visibility"`
+	Description   string             `json:"description"`
+	Fork          bool               `json:"fork"`
+	Archived      bool               `json:"archived"`
+	Disabled      bool               `json:"disabled"`
+	DefaultBranch string             `json:"default_branch"`
+	URL           string             `json:"url"`
+	HTMLURL       string             `json:"html_url"`
+	CloneURL      string             `json:"clone_url"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+	Permissions   *githubPermissions `json:"permissions,omitempty"`
+}
+
+type githubBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+	Protected bool `json:"protected"`
+}
+
+// githubID derives a stable numeric ID from a name, as GitHub clients
+// expect one on users and repositories. It fits in a JavaScript number.
+func githubID(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64() >> 11)
+}
+
+func githubError(w http.ResponseWriter, msg string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": msg})
+}
+
+func githubJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// githubRequestUser authenticates a request the way GitHub clients send
+// tokens, as "token <t>" or "Bearer <t>". It returns "" for an anonymous
+// request, and writes an error for a bad token.
+func (s *Server) githubRequestUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", true
+	}
+
+	scheme, token, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "token") && !strings.EqualFold(scheme, "bearer") {
+		githubError(w, "Bad credentials", http.StatusUnauthorized)
+		return "", false
+	}
+
+	username, err := s.authStore.ValidateAPIToken(token, clientip.FromRequest(r))
+	if err != nil {
+		s.audit(r, audit.Entry{Action: "auth.failed", Target: r.URL.Path, Detail: tokenFailure(err)})
+		githubError(w, "Bad credentials", http.StatusUnauthorized)
+		return "", false
+	}
+	return username, true
+}
+
+func (s *Server) githubUser(base, login string) githubUser {
+	return githubUser{
+		Login:   login,
+		ID:      githubID(login),
+		Type:    "User",
+		URL:     base + githubAPI + "/users/" + login,
+		HTMLURL: base + "/" + login,
+	}
+}
+
+func (s *Server) githubRepo(base, owner, name, username string, meta storage.Metadata) githubRepo {
+	full := owner + "/" + name
+	repo := githubRepo{
+		ID:            githubID(full),
+		Name:          name,
+		FullName:      full,
+		Owner:         s.githubUser(base, owner),
+		Private:       meta.Private,
+		Visibility:    "public",
+		Description:   meta.Description,
+		Fork:          meta.ForkOf != "",
+		DefaultBranch: meta.DefaultBranch,
+		URL:           base + githubAPI + "/repos/" + full,
+		HTMLURL:       base + "/" + full,
+		CloneURL:      fmt.Sprintf("%s/%s.git", base, full),
+		CreatedAt:     meta.CreatedAt,
+		UpdatedAt:     meta.CreatedAt,
+	}
+	if meta.Private {
+		repo.Visibility = "private"
+	}
+	if username != "" {
+		owns := username == owner && meta.ReplicaOf == nil
+		repo.Permissions = &githubPermissions{Admin: owns, Push: owns, Pull: true}
+	}
+	return repo
+}
+
+// handleGitHub serves a subset of GitHub's REST API, enough for tooling
+// written against GitHub to find repositories and branches and create
+// repositories. Tokens are openhub API tokens; private repositories are
+// visible only to their owner, as everywhere else.
+//
+//	GET  /api/v3/user
+//	GET  /api/v3/user/repos
+//	POST /api/v3/user/repos {"name", "description", "private"}
+//	GET  /api/v3/users/{user}/repos
+//	GET  /api/v3/repos/{owner}/{repo}
+//	GET  /api/v3/repos/{owner}/{repo}/branches
+//	GET  /api/v3/repos/{owner}/{repo}/branches/{branch}
+func (s *Server) handleGitHub(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.githubRequestUser(w, r)
+	if !ok {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, githubAPI), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "user" && r.Method == "GET":
+		if username == "" {
+			githubError(w, "Requires authentication", http.StatusUnauthorized)
+			return
+		}
+		githubJSON(w, http.StatusOK, s.githubUser(s.baseURL(r), username))
+
+	case len(parts) == 2 && parts[0] == "user" && parts[1] == "repos" && r.Method == "GET":
+		if username == "" {
+			githubError(w, "Requires authentication", http.StatusUnauthorized)
+			return
+		}
+		s.githubListRepos(w, r, username, username)
+
+	case len(parts) == 2 && parts[0] == "user" && parts[1] == "repos" && r.Method == "POST":
+		if username == "" {
+			githubError(w, "Requires authentication", http.StatusUnauthorized)
+			return
+		}
+		s.githubCreateRepo(w, r, username)
+
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "repos" && r.Method == "GET":
+		s.githubListRepos(w, r, parts[1], username)
+
+	case len(parts) >= 3 && parts[0] == "repos" && r.Method == "GET":
+		owner, name := parts[1], parts[2]
+		meta, ok := s.githubRepoMeta(w, owner, name, username)
+		if !ok {
+			return
+		}
+
+		switch {
+		case len(parts) == 3:
+			githubJSON(w, http.StatusOK, s.githubRepo(s.baseURL(r), owner, name, username, meta))
+		case len(parts) == 4 && parts[3] == "branches":
+			s.githubBranches(w, owner, name, meta, "")
+		case len(parts) > 4 && parts[3] == "branches":
+			s.githubBranches(w, owner, name, meta, strings.Join(parts[4:], "/"))
+		default:
+			githubError(w, "Not Found", http.StatusNotFound)
+		}
+
+	default:
+		githubError(w, "Not Found", http.StatusNotFound)
+	}
+}
+
+// githubRepoMeta returns a repository's metadata if username may see it.
+// Like GitHub, it answers 404 rather than 403 for a private repository.
+func (s *Server) githubRepoMeta(w http.ResponseWriter, owner, name, username string) (storage.Metadata, bool) {
+	if !isValidName(owner) || !isValidName(name) || !s.storage.RepoExists(owner, name) {
+		githubError(w, "Not Found", http.StatusNotFound)
+		return storage.Metadata{}, false
+	}
+
+	meta, err := s.storage.GetMetadata(owner, name)
+	if err != nil {
+		githubError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return meta, false
+	}
+
+	if meta.Private && username != owner {
+		githubError(w, "Not Found", http.StatusNotFound)
+		return meta, false
+	}
+	return meta, true
+}
+
+func (s *Server) githubListRepos(w http.ResponseWriter, r *http.Request, owner, username string) {
+	if !isValidName(owner) {
+		githubError(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	repos, err := s.storage.ListReposByOwner(owner)
+	if err != nil {
+		githubError(w, fmt.Sprintf("list failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	result := []githubRepo{}
+	for _, repo := range repos {
+		meta, err := s.storage.GetMetadata(repo.Owner, repo.Name)
+		if err != nil || (meta.Private && username != owner) {
+			continue
+		}
+		result = append(result, s.githubRepo(s.baseURL(r), repo.Owner, repo.Name, username, meta))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	githubJSON(w, http.StatusOK, result)
+}
+
+// githubBranches lists a repository's branches, or shows one when branch is
+// set. Protected means only the merge queue may move it.
+func (s *Server) githubBranches(w http.ResponseWriter, owner, name string, meta storage.Metadata, branch string) {
+	refs, err := s.storage.ListRefs(owner, name)
+	if err != nil {
+		githubError(w, fmt.Sprintf("list refs failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var p storage.Policy
+	if meta.Policy != nil {
+		p = *meta.Policy
+	}
+
+	var branches []githubBranch
+	for ref, sha := range refs {
+		short, ok := strings.CutPrefix(ref, "refs/heads/")
+		if !ok || (branch != "" && short != branch) {
+			continue
+		}
+		b := githubBranch{Name: short, Protected: policy.IsProtected(p, ref)}
+		b.Commit.SHA = sha
+		branches = append(branches, b)
+	}
+
+	if branch != "" {
+		if len(branches) == 0 {
+			githubError(w, "Branch not found", http.StatusNotFound)
+			return
+		}
+		githubJSON(w, http.StatusOK, branches[0])
+		return
+	}
+
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Name < branches[j].Name })
+	if branches == nil {
+		branches = []githubBranch{}
+	}
+	githubJSON(w, http.StatusOK, branches)
+}
+
+func (s *Server) githubCreateRepo(w http.ResponseWriter, r *http.Request, username string) {
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Private     bool   `json:"private"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		githubError(w, "Problems parsing JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidName(req.Name) {
+		githubError(w, "Repository creation failed: invalid name", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if s.storage.RepoExists(username, req.Name) {
+		githubError(w, "Repository creation failed: name already exists on this account", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if s.quotas != nil {
+		st, err := s.quotas.Status(username)
+		if err != nil {
+			githubError(w, fmt.Sprintf("check quota failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if st.Level == quota.LevelEnforced {
+			githubError(w, st.Message, http.StatusInsufficientStorage)
+			return
+		}
+	}
+
+	if err := s.storage.CreateRepo(username, req.Name); err != nil {
+		githubError(w, fmt.Sprintf("create failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var meta storage.Metadata
+	err := s.storage.UpdateMetadata(username, req.Name, func(m *storage.Metadata) error {
+		m.Description = req.Description
+		m.Private = req.Private
+		meta = *m
+		return nil
+	})
+	if err != nil {
+		githubError(w, fmt.Sprintf("set metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.repoCreated(requestActor(r, username), username, req.Name)
+
+	githubJSON(w, http.StatusCreated, s.githubRepo(s.baseURL(r), username, req.Name, username, meta))
+}
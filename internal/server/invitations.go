@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// handleAcceptedReplication enables the pending replica entry of the
+// instance that sent the notice, signed with the key it gave in its
+// handshake, once its admin has accepted the repository, and pushes to it
+// straight away. A replica paused by this instance's admin stays paused.
+//
+//	POST /api/v1/repos/accept-replication {"owner", "repo", "instance_id"}
+func (s *Server) handleAcceptedReplication(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkClientCert(w, r) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var req originNotice
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Repo == "" || req.InstanceID == "" {
+		s.jsonError(w, "owner, repo and instance_id required", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidName(req.Owner) || !isValidName(req.Repo) {
+		s.jsonError(w, "invalid owner or repo name", http.StatusBadRequest)
+		return
+	}
+
+	if !s.verifySignature(w, req.InstanceID, "accept-replication", r.Header.Get("X-OpenHub-Timestamp"),
+		r.Header.Get("X-OpenHub-Signature"), instance.Digest(body)) {
+		return
+	}
+
+	if !s.storage.RepoExists(req.Owner, req.Repo) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	found := false
+	var enabled []string
+	err = s.storage.UpdateMetadata(req.Owner, req.Repo, func(meta *storage.Metadata) error {
+		for i := range meta.Replicas {
+			if meta.Replicas[i].PeerID != req.InstanceID {
+				continue
+			}
+			found = true
+			if meta.Replicas[i].Pending {
+				meta.Replicas[i].Pending = false
+				meta.Replicas[i].Enabled = true
+				meta.Replicas[i].LastError = ""
+				enabled = append(enabled, meta.Replicas[i].URL)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("update metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		s.jsonError(w, fmt.Sprintf("instance %s is not a replica of this repository", req.InstanceID), http.StatusNotFound)
+		return
+	}
+
+	if len(enabled) > 0 {
+		log.Printf("replica instance %s accepted %s/%s", req.InstanceID, req.Owner, req.Repo)
+		for _, url := range enabled {
+			s.audit(r, audit.Entry{
+				Action: "replica.accepted",
+				Target: req.Owner + "/" + req.Repo,
+				Detail: fmt.Sprintf("instance %s at %s", req.InstanceID, url),
+			})
+		}
+		if s.replQueue != nil {
+			s.replQueue.Queue(req.Owner, req.Repo)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// notifyAccepted tells the origin of an approved replication request to
+// start pushing. It reports false, with no error, for an origin too old to
+// be told; that one retries its pushes on its own.
+func (s *Server) notifyAccepted(req instance.ReplicationRequest) (bool, error) {
+	if s.instance == nil {
+		return false, fmt.Errorf("federation is not enabled on this instance")
+	}
+	origin, ok, err := s.peers.Get(req.OriginInstanceID)
+	if err != nil {
+		return false, fmt.Errorf("lookup origin: %w", err)
+	}
+	if !ok || origin.URL == "" {
+		return false, fmt.Errorf("the URL of origin instance %s is unknown", req.OriginInstanceID)
+	}
+	if !origin.Supports("invitations") {
+		return false, nil
+	}
+
+	notice := originNotice{Owner: req.Owner, Repo: req.Repo, InstanceID: s.instance.ID}
+	if err := s.notifyOrigin(origin.URL+"/api/repos/accept-replication", "accept-replication", notice); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/events"
+	"github.com/jeremytregunna/openhub/internal/issues"
+)
+
+// maxIssuesMessageSize bounds a replicate-issues message, which carries all of
+// a repository's issues.
+const maxIssuesMessageSize = 64 << 20
+
+type IssueStore interface {
+	Create(owner, repo string, issue issues.Issue) (issues.Issue, error)
+	Get(owner, repo string, number int) (issues.Issue, error)
+	List(owner, repo, state string) ([]issues.Issue, error)
+	Update(owner, repo string, number int, fn func(*issues.Issue) error) (issues.Issue, error)
+	AddComment(owner, repo string, number int, comment issues.Comment) (issues.Comment, error)
+	Replace(owner, repo string, list []issues.Issue) error
+}
+
+// SetIssues enables the issue tracker.
+func (s *Server) SetIssues(store IssueStore) {
+	s.issues = store
+}
+
+// checkIssueWrite verifies that issues are enabled and the repo can be read
+// and is its own origin. Replicas receive their issues from the origin, so
+// changes made on them would be overwritten.
+func (s *Server) checkIssueWrite(w http.ResponseWriter, r *http.Request, owner, name string) bool {
+	if s.issues == nil {
+		s.jsonError(w, "issues are not enabled on this instance", http.StatusNotFound)
+		return false
+	}
+
+	if !s.checkRepoRead(w, r, owner, name) {
+		return false
+	}
+
+	meta, err := s.storage.GetMetadata(owner, name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return false
+	}
+
+	if meta.ReplicaOf != nil {
+		s.jsonError(w, "cannot change issues on a read-only replica", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) issuesChanged(owner, name string) {
+	if s.replQueue != nil {
+		s.replQueue.QueueIssues(owner, name)
+	}
+}
+
+func (s *Server) issueError(w http.ResponseWriter, err error) {
+	if errors.Is(err, issues.ErrNotFound) {
+		s.jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.jsonError(w, err.Error(), http.StatusInternalServerError)
+}
+
+// handleIssues lists, shows and opens issues. Any user who can read the
+// repository may open one.
+//
+//	GET  /api/v1/repos/issues?owner=..&name=..[&state=open|closed][&number=N]
+//	POST /api/v1/repos/issues {"owner", "name", "title", "body"}
+func (s *Server) handleIssues(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		if owner == "" || name == "" {
+			s.jsonError(w, "owner and name required", http.StatusBadRequest)
+			return
+		}
+
+		if s.issues == nil {
+			s.jsonError(w, "issues are not enabled on this instance", http.StatusNotFound)
+			return
+		}
+
+		if !s.checkRepoRead(w, r, owner, name) {
+			return
+		}
+
+		if n := r.URL.Query().Get("number"); n != "" {
+			number, err := strconv.Atoi(n)
+			if err != nil {
+				s.jsonError(w, "invalid number", http.StatusBadRequest)
+				return
+			}
+
+			issue, err := s.issues.Get(owner, name, number)
+			if err != nil {
+				s.issueError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true,
+				"issue":   issue,
+			})
+			return
+		}
+
+		list, err := s.issues.List(owner, name, r.URL.Query().Get("state"))
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("list issues failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []issues.Issue{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"issues":  list,
+		})
+
+	case "POST":
+		username, ok := s.bearerUser(w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Owner string `json:"owner"`
+			Name  string `json:"name"`
+			Title string `json:"title"`
+			Body  string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		req.Title = strings.TrimSpace(req.Title)
+		if req.Owner == "" || req.Name == "" || req.Title == "" {
+			s.jsonError(w, "owner, name and title required", http.StatusBadRequest)
+			return
+		}
+
+		if len(req.Title) > issues.MaxTitleLength || len(req.Body) > issues.MaxBodyLength {
+			s.jsonError(w, fmt.Sprintf("title is limited to %d bytes and body to %d", issues.MaxTitleLength, issues.MaxBodyLength), http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkIssueWrite(w, r, req.Owner, req.Name) {
+			return
+		}
+
+		issue, err := s.issues.Create(req.Owner, req.Name, issues.Issue{
+			Title:  req.Title,
+			Body:   req.Body,
+			Author: username,
+		})
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("create issue failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.issuesChanged(req.Owner, req.Name)
+		s.publish(events.Event{Kind: events.KindIssueOpened, Owner: req.Owner, Repo: req.Name, User: username, Number: issue.Number, Title: issue.Title})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"issue":   issue,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleIssueComments comments on an issue. Any user who can read the
+// repository may comment, on open and closed issues alike.
+//
+//	POST /api/v1/repos/issues/comments {"owner", "name", "number", "body"}
+func (s *Server) handleIssueComments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner  string `json:"owner"`
+		Name   string `json:"name"`
+		Number int    `json:"number"`
+		Body   string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" || req.Number == 0 || strings.TrimSpace(req.Body) == "" {
+		s.jsonError(w, "owner, name, number and body required", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Body) > issues.MaxBodyLength {
+		s.jsonError(w, fmt.Sprintf("body is limited to %d bytes", issues.MaxBodyLength), http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkIssueWrite(w, r, req.Owner, req.Name) {
+		return
+	}
+
+	comment, err := s.issues.AddComment(req.Owner, req.Name, req.Number, issues.Comment{
+		Author: username,
+		Body:   req.Body,
+	})
+	if err != nil {
+		s.issueError(w, err)
+		return
+	}
+	s.issuesChanged(req.Owner, req.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"comment": comment,
+	})
+}
+
+// handleIssueState closes or reopens an issue. The author and the
+// repository owner may change it.
+//
+//	POST /api/v1/repos/issues/state {"owner", "name", "number", "state"}
+func (s *Server) handleIssueState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner  string `json:"owner"`
+		Name   string `json:"name"`
+		Number int    `json:"number"`
+		State  string `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" || req.Number == 0 {
+		s.jsonError(w, "owner, name and number required", http.StatusBadRequest)
+		return
+	}
+
+	if req.State != issues.StateOpen && req.State != issues.StateClosed {
+		s.jsonError(w, "state must be open or closed", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkIssueWrite(w, r, req.Owner, req.Name) {
+		return
+	}
+
+	errForbidden := errors.New("only the author or owner can close or reopen an issue")
+	issue, err := s.issues.Update(req.Owner, req.Name, req.Number, func(issue *issues.Issue) error {
+		if username != issue.Author && username != req.Owner {
+			return errForbidden
+		}
+		if issue.State == req.State {
+			return nil
+		}
+		issue.State = req.State
+		if req.State == issues.StateClosed {
+			now := time.Now()
+			issue.ClosedAt = &now
+			issue.ClosedBy = username
+		} else {
+			issue.ClosedAt = nil
+			issue.ClosedBy = ""
+		}
+		return nil
+	})
+	if errors.Is(err, errForbidden) {
+		s.jsonError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.issueError(w, err)
+		return
+	}
+	s.issuesChanged(req.Owner, req.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"issue":   issue,
+	})
+}
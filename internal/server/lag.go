@@ -0,0 +1,60 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// lagTTL is how long a replica's lag behind its origin is reused for, so
+// polling replication status doesn't run git for every replica every time.
+// A sync to the replica makes its lag be counted again sooner.
+const lagTTL = 30 * time.Second
+
+// maxLagCounts bounds how many lags one replication status request counts
+// afresh; the rest show as unknown until a later request gets to them.
+const maxLagCounts = 50
+
+type lagEntry struct {
+	lag     int
+	synced  time.Time // the replica's last sync when lag was counted
+	counted time.Time
+}
+
+// lagCache holds the commits each replica was behind by, keyed by
+// "owner/name instance-id".
+type lagCache struct {
+	mu      sync.Mutex
+	entries map[string]lagEntry
+}
+
+// get returns the lag counted for key within lagTTL, if the replica hasn't
+// synced since.
+func (c *lagCache) get(key string, synced time.Time) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !e.synced.Equal(synced) || time.Since(e.counted) >= lagTTL {
+		return 0, false
+	}
+	return e.lag, true
+}
+
+func (c *lagCache) put(key string, synced time.Time, lag int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]lagEntry)
+	}
+	c.entries[key] = lagEntry{lag: lag, synced: synced, counted: time.Now()}
+}
+
+// prune drops the lags too old to be used.
+func (c *lagCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if time.Since(e.counted) >= lagTTL {
+			delete(c.entries, key)
+		}
+	}
+}
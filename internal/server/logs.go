@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/logstream"
+)
+
+// logHeartbeat keeps idle log streams from being closed by proxies.
+const logHeartbeat = 30 * time.Second
+
+type LogSource interface {
+	Subscribe(f logstream.Filter) ([]logstream.Entry, <-chan logstream.Entry, func())
+}
+
+func (s *Server) SetLogs(logs LogSource) {
+	s.logs = logs
+}
+
+// SetAdmins lists the users allowed to use admin endpoints that require a
+// token, such as the log stream.
+func (s *Server) SetAdmins(usernames []string) {
+	s.admins = make(map[string]bool, len(usernames))
+	for _, u := range usernames {
+		s.admins[u] = true
+	}
+}
+
+func (s *Server) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return false
+	}
+	if !s.admins[username] {
+		s.audit(r, audit.Entry{Actor: username, Action: "auth.denied", Target: r.URL.Path, Detail: "admin access required"})
+		s.jsonError(w, "admin access required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// handleLogs streams server log lines as server-sent events, each a JSON
+// logstream.Entry. It sends the most recent lines (all retained ones unless
+// "lines" says otherwise) and, with follow, keeps streaming new ones.
+// Filters are "repo=owner/name", "peer=<url|instance-id>" or "text=<substring>".
+//
+//	GET /api/v1/admin/logs[?follow=1][&lines=N][&filter=key=value...]
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+
+	if s.logs == nil {
+		s.jsonError(w, "log streaming is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var f logstream.Filter
+	for _, filter := range r.URL.Query()["filter"] {
+		key, value, _ := strings.Cut(filter, "=")
+		switch key {
+		case "repo":
+			f.Repo = value
+		case "peer":
+			f.Peer = value
+		case "text":
+			f.Text = value
+		default:
+			s.jsonError(w, fmt.Sprintf("unknown filter %q; use repo, peer or text", key), http.StatusBadRequest)
+			return
+		}
+	}
+
+	lines := -1
+	if l := r.URL.Query().Get("lines"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			s.jsonError(w, "lines must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		lines = n
+	}
+	follow := r.URL.Query().Get("follow") == "1" || r.URL.Query().Get("follow") == "true"
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.jsonError(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	backlog, entries, cancel := s.logs.Subscribe(f)
+	defer cancel()
+
+	if lines >= 0 && len(backlog) > lines {
+		backlog = backlog[len(backlog)-lines:]
+	}
+
+	// A stream outlives any --http-write-timeout; it ends when the client
+	// goes away or the server shuts down.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e logstream.Entry) bool {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return false
+		}
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.Seq, data)
+		return err == nil
+	}
+
+	for _, e := range backlog {
+		if !send(e) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	if !follow {
+		return
+	}
+
+	heartbeat := time.NewTicker(logHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case e, ok := <-entries:
+			if !ok || !send(e) {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
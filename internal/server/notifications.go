@@ -0,0 +1,317 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/notifications"
+)
+
+type NotificationStore interface {
+	Social(owner, repo string) (notifications.Social, error)
+	Star(owner, repo, user string, on bool) (notifications.Social, error)
+	Watch(owner, repo, user string, on bool) (notifications.Social, error)
+	Starred(user string) ([]string, error)
+	List(user string, unreadOnly bool) ([]notifications.Notification, error)
+	MarkRead(user string, ids []int) (int, error)
+}
+
+// SetNotifications enables stars, watching and notification inboxes.
+func (s *Server) SetNotifications(store NotificationStore) {
+	s.notifications = store
+}
+
+func (s *Server) checkNotifications(w http.ResponseWriter) bool {
+	if s.notifications == nil {
+		s.jsonError(w, "notifications are not enabled on this instance", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// handleStars and handleWatchers show who starred or watches a repository
+// and let any user who can read it star or watch it. Watchers, along with
+// the owner, are notified of pushes and new issues.
+//
+//	GET  /api/v1/repos/stars?owner=..&name=..
+//	POST /api/v1/repos/stars {"owner", "name", "star"}
+//	GET  /api/v1/repos/watchers?owner=..&name=..
+//	POST /api/v1/repos/watchers {"owner", "name", "watch"}
+func (s *Server) handleStars(w http.ResponseWriter, r *http.Request) {
+	s.handleSocial(w, r, false)
+}
+
+func (s *Server) handleWatchers(w http.ResponseWriter, r *http.Request) {
+	s.handleSocial(w, r, true)
+}
+
+func (s *Server) handleSocial(w http.ResponseWriter, r *http.Request, watch bool) {
+	list, field := "stars", "star"
+	if watch {
+		list, field = "watchers", "watch"
+	}
+
+	switch r.Method {
+	case "GET":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		if owner == "" || name == "" {
+			s.jsonError(w, "owner and name required", http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkNotifications(w) || !s.checkRepoRead(w, r, owner, name) {
+			return
+		}
+
+		soc, err := s.notifications.Social(owner, name)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("get %s failed: %v", list, err), http.StatusInternalServerError)
+			return
+		}
+
+		users := soc.Stars
+		if watch {
+			users = soc.Watchers
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"count":   len(users),
+			list:      users,
+		})
+
+	case "POST":
+		username, ok := s.bearerUser(w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Owner string `json:"owner"`
+			Name  string `json:"name"`
+			Star  *bool  `json:"star"`
+			Watch *bool  `json:"watch"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		on := req.Star
+		if watch {
+			on = req.Watch
+		}
+		if req.Owner == "" || req.Name == "" || on == nil {
+			s.jsonError(w, fmt.Sprintf("owner, name and %s required", field), http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkNotifications(w) || !s.checkRepoRead(w, r, req.Owner, req.Name) {
+			return
+		}
+
+		var soc notifications.Social
+		var err error
+		if watch {
+			soc, err = s.notifications.Watch(req.Owner, req.Name, username, *on)
+		} else {
+			soc, err = s.notifications.Star(req.Owner, req.Name, username, *on)
+		}
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("update %s failed: %v", list, err), http.StatusInternalServerError)
+			return
+		}
+
+		users := soc.Stars
+		if watch {
+			users = soc.Watchers
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"count":   len(users),
+			field:     *on,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleStarred lists the repositories the user has starred that they can
+// still read.
+//
+//	GET /api/v1/users/starred
+func (s *Server) handleStarred(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.checkNotifications(w) {
+		return
+	}
+
+	starred, err := s.notifications.Starred(username)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list starred failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	repos := []string{}
+	for _, full := range starred {
+		owner, name, _ := strings.Cut(full, "/")
+		meta, err := s.storage.GetMetadata(owner, name)
+		if err != nil || (meta.Private && owner != username) {
+			continue
+		}
+		repos = append(repos, full)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"repos":   repos,
+	})
+}
+
+// handleUserEmail shows and sets the address the user's notifications are
+// emailed to, and lets them opt out of the emails. An empty email removes
+// the address.
+//
+//	GET  /api/v1/users/email
+//	POST /api/v1/users/email {"email", "notify"}
+func (s *Server) handleUserEmail(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req struct {
+			Email  *string `json:"email"`
+			Notify *bool   `json:"notify"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Email == nil && req.Notify == nil {
+			s.jsonError(w, "email or notify required", http.StatusBadRequest)
+			return
+		}
+
+		if req.Email != nil {
+			if err := s.authStore.SetEmail(username, *req.Email); err != nil {
+				s.jsonError(w, fmt.Sprintf("set email failed: %v", err), http.StatusBadRequest)
+				return
+			}
+			s.audit(r, audit.Entry{Actor: username, Action: "user.email", Target: username, Detail: *req.Email})
+		}
+		if req.Notify != nil {
+			if err := s.authStore.SetEmailOptOut(username, !*req.Notify); err != nil {
+				s.jsonError(w, fmt.Sprintf("set email notifications failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := s.authStore.GetUser(username)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get user failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"email":   user.Email,
+		"notify":  !user.EmailOptOut,
+	})
+}
+
+// handleNotifications lists the user's notifications, newest first, and
+// marks them read: those listed in ids, or all of them when ids is empty.
+//
+//	GET  /api/v1/users/notifications[?unread=1]
+//	POST /api/v1/users/notifications/read {"ids"}
+func (s *Server) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.checkNotifications(w) {
+		return
+	}
+
+	unread := r.URL.Query().Get("unread")
+	list, err := s.notifications.List(username, unread == "1" || unread == "true")
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list notifications failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"notifications": list,
+	})
+}
+
+func (s *Server) handleMarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkNotifications(w) {
+		return
+	}
+
+	marked, err := s.notifications.MarkRead(username, req.IDs)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("mark read failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"marked":  marked,
+	})
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// openAPI builds an OpenAPI 3 document describing every route under apiV1.
+// Responses are JSON objects with a "success" flag; errors add an "error"
+// message.
+func (s *Server) openAPI(base string) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, rt := range s.routes() {
+		for _, o := range rt.ops {
+			path := rt.path
+			if o.path != "" {
+				path = o.path
+			}
+			item, ok := paths[path].(map[string]interface{})
+			if !ok {
+				item = map[string]interface{}{}
+				paths[path] = item
+			}
+			item[strings.ToLower(o.method)] = o.document(path)
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "OpenHub API",
+			"version": "1",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": base + apiV1},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A user's API token, from 'openhub user generate-token'.",
+				},
+				"instance": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Instance-to-instance calls: the replication token issued at registration, a client certificate when --tls-client-ca is set, and signed payloads.",
+				},
+			},
+			"schemas": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"success": map[string]interface{}{"type": "boolean"},
+						"error":   map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (o op) document(path string) map[string]interface{} {
+	doc := map[string]interface{}{
+		"summary":     o.summary,
+		"operationId": operationID(o.method, path),
+		"tags":        []string{strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]},
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "Success",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"type": "object"},
+					},
+				},
+			},
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+					},
+				},
+			},
+		},
+	}
+
+	switch o.auth {
+	case authOptional:
+		doc["security"] = []interface{}{map[string]interface{}{}, map[string]interface{}{"token": []string{}}}
+	case authToken:
+		doc["security"] = []interface{}{map[string]interface{}{"token": []string{}}}
+	case authAdmin:
+		doc["security"] = []interface{}{map[string]interface{}{"token": []string{}}}
+		doc["description"] = "Requires a user named with --admin-users."
+	case authInstance:
+		doc["security"] = []interface{}{map[string]interface{}{"instance": []string{}}}
+	default:
+		doc["security"] = []interface{}{}
+	}
+
+	var params []interface{}
+	for _, part := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(part, "{"); ok {
+			params = append(params, map[string]interface{}{
+				"name": strings.TrimSuffix(name, "}"), "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	for _, p := range parseParams(o.query) {
+		params = append(params, map[string]interface{}{
+			"name": p.name, "in": "query", "required": p.required, "schema": p.schema,
+		})
+	}
+	if params != nil {
+		doc["parameters"] = params
+	}
+
+	var body map[string]interface{}
+	switch {
+	case o.bodyType != nil:
+		body = schemaOf(reflect.TypeOf(o.bodyType), 0)
+	case o.body != "":
+		body = objectSchema(parseParams(o.body))
+	}
+	if body != nil {
+		contentType := "application/json"
+		if o.multipart {
+			contentType = "multipart/form-data"
+		}
+		doc["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				contentType: map[string]interface{}{"schema": body},
+			},
+		}
+	}
+
+	return doc
+}
+
+// operationID names an operation after its method and path, e.g.
+// POST /repos/pulls/merge becomes postReposPullsMerge.
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '-' || r == '.' }) {
+		part = strings.Trim(part, "{}")
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+type param struct {
+	name     string
+	required bool
+	schema   map[string]interface{}
+}
+
+func parseParams(spec string) []param {
+	var params []param
+	for _, field := range strings.Fields(spec) {
+		name, typ, _ := strings.Cut(field, ":")
+		p := param{name: strings.TrimSuffix(name, "?"), required: !strings.HasSuffix(name, "?")}
+
+		switch typ {
+		case "int":
+			p.schema = map[string]interface{}{"type": "integer"}
+		case "bool":
+			p.schema = map[string]interface{}{"type": "boolean"}
+		case "binary":
+			p.schema = map[string]interface{}{"type": "string", "format": "binary"}
+		case "[]int":
+			p.schema = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer"}}
+		case "[]string":
+			p.schema = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+		case "[]object":
+			p.schema = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}}
+		default:
+			p.schema = map[string]interface{}{"type": "string"}
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+func objectSchema(params []param) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	for _, p := range params {
+		props[p.name] = p.schema
+		if p.required {
+			required = append(required, p.name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf describes a Go type as encoding/json would marshal it. Nesting is
+// cut off at a fixed depth, past which values are left as plain objects.
+func schemaOf(t reflect.Type, depth int) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), depth+1)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), depth+1)}
+	case reflect.Struct:
+		if depth > 6 {
+			return map[string]interface{}{"type": "object"}
+		}
+		props := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if f.Anonymous && name == "" {
+				if embedded := schemaOf(f.Type, depth); embedded["properties"] != nil {
+					for k, v := range embedded["properties"].(map[string]interface{}) {
+						props[k] = v
+					}
+					continue
+				}
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaOf(f.Type, depth+1)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
+
+// handleOpenAPI serves the OpenAPI document for this version of the API.
+//
+//	GET /api/v1/openapi.json
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.openAPI(s.baseURL(r)))
+}
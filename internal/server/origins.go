@@ -0,0 +1,300 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// Origin policies: which instances may register repositories to replicate
+// here. Any can by default; with an allowlist only trusted origins can, and
+// with approval the others wait for an admin.
+const (
+	OriginsOpen      = "open"
+	OriginsAllowlist = "allowlist"
+	OriginsApproval  = "approval"
+)
+
+// ReplicationRequests holds registrations from untrusted origins until an
+// admin approves them.
+type ReplicationRequests interface {
+	Add(req instance.ReplicationRequest) (instance.ReplicationRequest, error)
+	List() ([]instance.ReplicationRequest, error)
+	Get(id string) (instance.ReplicationRequest, error)
+	Remove(id string) error
+}
+
+// SetOriginPolicy sets which origins may register repositories to
+// replicate here: OriginsOpen, the default, OriginsAllowlist or
+// OriginsApproval. trusted lists the instance IDs or public keys of the
+// origins let through under the other two.
+func (s *Server) SetOriginPolicy(policy string, trusted []string) {
+	s.originPolicy = policy
+	s.trustedOrigins = trusted
+}
+
+// SetReplicationRequests keeps registrations waiting for approval under
+// OriginsApproval.
+func (s *Server) SetReplicationRequests(requests ReplicationRequests) {
+	s.replRequests = requests
+}
+
+// trustedOrigin reports whether the origin instanceID, with publicKey, may
+// register repositories here without an admin.
+func (s *Server) trustedOrigin(instanceID, publicKey string) bool {
+	if s.originPolicy != OriginsAllowlist && s.originPolicy != OriginsApproval {
+		return true
+	}
+	for _, t := range s.trustedOrigins {
+		if t == instanceID || t == publicKey {
+			return true
+		}
+	}
+	return false
+}
+
+// queueReplicationRequest holds a registration from an untrusted origin, or
+// an invitation, for an admin to approve or accept. The origin is told it is
+// pending, and its pushes are refused until then.
+func (s *Server) queueReplicationRequest(w http.ResponseWriter, r *http.Request, pending instance.ReplicationRequest) {
+	if s.replRequests == nil {
+		if pending.InvitationKeyHash != "" {
+			s.jsonError(w, "replication invitations not enabled", http.StatusNotFound)
+		} else {
+			s.jsonError(w, "this instance only replicates from trusted origins", http.StatusForbidden)
+		}
+		return
+	}
+
+	pending.Client = clientip.FromRequest(r)
+	req, err := s.replRequests.Add(pending)
+	if errors.Is(err, instance.ErrTooManyRequests) {
+		s.jsonError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("queue replication request failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	target := req.Owner + "/" + req.Repo
+	if req.InvitationKeyHash != "" {
+		log.Printf("replication of %s from origin instance %s waiting to be accepted as request %s", target, req.OriginInstanceID, req.ID)
+		s.audit(r, audit.Entry{
+			Action: "replica.invite",
+			Target: target,
+			Detail: fmt.Sprintf("origin instance %s, request %s", req.OriginInstanceID, req.ID),
+		})
+	} else {
+		log.Printf("replication of %s from origin instance %s waiting for approval as request %s", target, req.OriginInstanceID, req.ID)
+		s.audit(r, audit.Entry{
+			Action: "replica.request",
+			Target: target,
+			Detail: fmt.Sprintf("origin instance %s, request %s", req.OriginInstanceID, req.ID),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"pending":    true,
+		"request_id": req.ID,
+	})
+}
+
+// handleAdminReplicationRequests lists the registrations waiting for
+// approval, and approves or rejects one. Approving registers the origin's
+// key and replication token as if it had been trusted all along, and tells
+// the origin to start pushing. An invitation is only accepted with its
+// invitation key, and creates the repository as an empty replica for the
+// origin's first push to fill.
+//
+//	GET  /api/v1/admin/replication-requests
+//	POST /api/v1/admin/replication-requests {"id", "approve", "invitation_key"}
+func (s *Server) handleAdminReplicationRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	admin := s.requestUser(r)
+
+	if s.replRequests == nil {
+		s.jsonError(w, "replication requests not enabled", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "GET" {
+		requests, err := s.replRequests.List()
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("list replication requests failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range requests {
+			requests[i].TokenHash = ""
+		}
+		if requests == nil {
+			requests = []instance.ReplicationRequest{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"policy":   s.originPolicy,
+			"requests": requests,
+		})
+		return
+	}
+
+	var body struct {
+		ID            string `json:"id"`
+		Approve       bool   `json:"approve"`
+		InvitationKey string `json:"invitation_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.ID == "" {
+		s.jsonError(w, "id required", http.StatusBadRequest)
+		return
+	}
+
+	req, err := s.replRequests.Get(body.ID)
+	if errors.Is(err, instance.ErrUnknownRequest) {
+		s.jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get replication request failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	target := req.Owner + "/" + req.Repo
+	invited := req.InvitationKeyHash != ""
+
+	if body.Approve {
+		if invited && !auth.SecretMatches(req.InvitationKeyHash, body.InvitationKey) {
+			s.jsonError(w, "invalid invitation key; an invitation is accepted with the key its origin's admin passed on", http.StatusForbidden)
+			return
+		}
+		exists := s.storage.RepoExists(req.Owner, req.Repo)
+		if !exists && !invited {
+			s.jsonError(w, "repository not found", http.StatusNotFound)
+			return
+		}
+		if exists {
+			meta, err := s.storage.GetMetadata(req.Owner, req.Repo)
+			if err != nil {
+				s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if invited && (meta.ReplicaOf == nil || meta.ReplicaOf.InstanceID != req.OriginInstanceID) {
+				s.jsonError(w, "a repository with this name already exists here", http.StatusConflict)
+				return
+			}
+			if !invited && meta.ReplicaOf != nil {
+				s.jsonError(w, "cannot add replica: this is a replica itself", http.StatusConflict)
+				return
+			}
+		}
+		if err := s.peers.Pin(req.OriginInstanceID, req.OriginPublicKey); err != nil {
+			s.jsonError(w, fmt.Sprintf("register origin key failed: %v", err), http.StatusConflict)
+			return
+		}
+		now := time.Now()
+		err = s.authStore.PutUser(&auth.User{
+			Username:  ReplicationUsername(req.Owner, req.Repo, req.OriginInstanceID),
+			SSHKeys:   []auth.SSHKey{},
+			APITokens: []auth.APIToken{{Name: "replication", Hash: req.TokenHash, CreatedAt: now}},
+			CreatedAt: now,
+		})
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("create user failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if invited {
+			if err := s.hostInvitedRepo(req, exists); err != nil {
+				s.jsonError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	if err := s.replRequests.Remove(req.ID); err != nil && !errors.Is(err, instance.ErrUnknownRequest) {
+		s.jsonError(w, fmt.Sprintf("remove replication request failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !body.Approve {
+		s.audit(r, audit.Entry{Actor: admin, Action: "replica.reject", Target: target,
+			Detail: fmt.Sprintf("origin instance %s, request %s", req.OriginInstanceID, req.ID)})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+		return
+	}
+
+	verb := "approved"
+	if invited {
+		verb = "accepted"
+	}
+	s.audit(r, audit.Entry{Actor: admin, Action: "replica.register", Target: target,
+		Detail: fmt.Sprintf("replicating from origin instance %s, request %s %s", req.OriginInstanceID, req.ID, verb)})
+
+	// The request stands approved even if the origin can't be told; its
+	// admin can resume the replica by hand.
+	result := map[string]interface{}{
+		"success": true,
+	}
+	notified, err := s.notifyAccepted(req)
+	if err != nil {
+		log.Printf("tell origin instance %s that %s was %s: %v", req.OriginInstanceID, target, verb, err)
+		result["notify_error"] = err.Error()
+	}
+	result["origin_notified"] = notified
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// hostInvitedRepo makes the repository of an accepted invitation an empty
+// replica of its origin, bound to the invitation key, for the origin's first
+// push to fill. exists says the repository is already a replica of it.
+func (s *Server) hostInvitedRepo(req instance.ReplicationRequest, exists bool) error {
+	if !exists {
+		if err := s.storage.CreateRepo(req.Owner, req.Repo); err != nil {
+			return fmt.Errorf("create repo failed: %w", err)
+		}
+	}
+	err := s.storage.UpdateMetadata(req.Owner, req.Repo, func(meta *storage.Metadata) error {
+		if !exists {
+			meta.Description = req.Description
+		}
+		meta.ReplicaOf = &storage.ReplicaSource{
+			InstanceID:        req.OriginInstanceID,
+			InvitationKeyHash: req.InvitationKeyHash,
+			Refs:              req.Refs,
+			AllowChain:        req.AllowChain,
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("update metadata failed: %w", err)
+	}
+	return nil
+}
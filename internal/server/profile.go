@@ -0,0 +1,226 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// profile is the public part of an account.
+type profile struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Bio         string    `json:"bio,omitempty"`
+	AvatarURL   string    `json:"avatar_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (s *Server) profileOf(base string, user *auth.User) profile {
+	p := profile{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		CreatedAt:   user.CreatedAt,
+	}
+	if user.Avatar == auth.AvatarUpload || (user.Avatar == auth.AvatarGravatar && user.Email != "") {
+		p.AvatarURL = base + apiV1 + "/users/" + user.Username + "/avatar"
+	}
+	return p
+}
+
+// gravatarURL is where Gravatar serves the image for an email address,
+// falling back to a generated one.
+func gravatarURL(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) + "?d=identicon&s=200"
+}
+
+// handleUserProfile shows a user's public profile and the repositories the
+// caller can see, and serves their avatar: the uploaded image, or a
+// redirect to Gravatar.
+//
+//	GET /api/v1/users/{username}
+//	GET /api/v1/users/{username}/avatar
+func (s *Server) handleUserProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, apiV1+"/users/")
+	username, sub, _ := strings.Cut(rest, "/")
+	if !isValidName(username) || (sub != "" && sub != "avatar") {
+		s.jsonError(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	user, err := s.authStore.GetUser(username)
+	if err != nil {
+		s.jsonError(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if sub == "avatar" {
+		s.serveAvatar(w, r, user)
+		return
+	}
+
+	repos, err := s.storage.ListReposByOwner(username)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list repos failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	caller := s.requestUser(r)
+	names := []string{}
+	for _, repo := range repos {
+		meta, err := s.storage.GetMetadata(repo.Owner, repo.Name)
+		if err != nil || (meta.Private && caller != username) {
+			continue
+		}
+		names = append(names, repo.Name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"profile": s.profileOf(s.baseURL(r), user),
+		"repos":   names,
+	})
+}
+
+func (s *Server) serveAvatar(w http.ResponseWriter, r *http.Request, user *auth.User) {
+	switch {
+	case user.Avatar == auth.AvatarUpload:
+		data, err := s.authStore.AvatarImage(user.Username)
+		if err != nil {
+			s.jsonError(w, "avatar not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", http.DetectContentType(data))
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(data)
+	case user.Avatar == auth.AvatarGravatar && user.Email != "":
+		http.Redirect(w, r, gravatarURL(user.Email), http.StatusFound)
+	default:
+		s.jsonError(w, "avatar not found", http.StatusNotFound)
+	}
+}
+
+// handleProfile shows and edits the caller's own profile. Setting avatar to
+// "gravatar" uses the Gravatar for their email address; setting it to ""
+// removes any avatar.
+//
+//	GET  /api/v1/users/profile
+//	POST /api/v1/users/profile {"display_name", "bio", "avatar"}
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req struct {
+			DisplayName *string `json:"display_name"`
+			Bio         *string `json:"bio"`
+			Avatar      *string `json:"avatar"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := s.authStore.UpdateProfile(username, req.DisplayName, req.Bio, req.Avatar); err != nil {
+			s.jsonError(w, fmt.Sprintf("update profile failed: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.writeOwnProfile(w, r, username)
+}
+
+// handleAvatar uploads the caller's avatar as the raw image body, or
+// removes it.
+//
+//	PUT    /api/v1/users/avatar
+//	DELETE /api/v1/users/avatar
+func (s *Server) handleAvatar(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		data, err := io.ReadAll(io.LimitReader(r.Body, auth.MaxAvatarBytes+1))
+		if err != nil {
+			s.jsonError(w, "read body failed", http.StatusBadRequest)
+			return
+		}
+		if len(data) > auth.MaxAvatarBytes {
+			s.jsonError(w, fmt.Sprintf("avatar is larger than %d bytes", auth.MaxAvatarBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := s.authStore.SetAvatarImage(username, data); err != nil {
+			s.jsonError(w, fmt.Sprintf("set avatar failed: %v", err), http.StatusBadRequest)
+			return
+		}
+	case "DELETE":
+		none := ""
+		if err := s.authStore.UpdateProfile(username, nil, nil, &none); err != nil {
+			s.jsonError(w, fmt.Sprintf("remove avatar failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.writeOwnProfile(w, r, username)
+}
+
+func (s *Server) writeOwnProfile(w http.ResponseWriter, r *http.Request, username string) {
+	user, err := s.authStore.GetUser(username)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get user failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"profile": s.profileOf(s.baseURL(r), user),
+		"email":   user.Email,
+	})
+}
+
+// ownerProfiles looks up the profiles of the owners of repos, for listings.
+// Owners without an account here, such as those of replicated
+// repositories, are left out.
+func (s *Server) ownerProfiles(base string, repos []storage.Repo) map[string]profile {
+	profiles := map[string]profile{}
+	for _, repo := range repos {
+		if _, ok := profiles[repo.Owner]; ok {
+			continue
+		}
+		if user, err := s.authStore.GetUser(repo.Owner); err == nil {
+			profiles[repo.Owner] = s.profileOf(base, user)
+		}
+	}
+	return profiles
+}
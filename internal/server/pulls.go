@@ -0,0 +1,970 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/activity"
+	"github.com/jeremytregunna/openhub/internal/activitypub"
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/events"
+	"github.com/jeremytregunna/openhub/internal/pulls"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+type PullStore interface {
+	Create(owner, repo string, pr pulls.PullRequest) (pulls.PullRequest, error)
+	Get(owner, repo string, number int) (pulls.PullRequest, error)
+	List(owner, repo, state string) ([]pulls.PullRequest, error)
+	Update(owner, repo string, number int, fn func(*pulls.PullRequest) error) (pulls.PullRequest, error)
+	StackedOn(owner, repo, branch string) (int, error)
+	Head(owner, repo string, pr pulls.PullRequest) (string, error)
+	Conflicts(owner, repo string, pr pulls.PullRequest) ([]string, error)
+	AddReview(owner, repo string, number int, review pulls.Review) (pulls.Review, error)
+	ApplySuggestion(owner, repo string, number, reviewID, commentIndex int, user, message string) (string, error)
+}
+
+type MergeQueue interface {
+	Enqueue(owner, repo string, number int, user string) (int, error)
+	Dequeue(owner, repo string, number int) error
+	Merge(owner, repo string, number int, user string) (pulls.PullRequest, error)
+	Close(owner, repo string, number int, user string) (pulls.PullRequest, error)
+	Position(owner, repo, target string, number int) int
+	Entries(owner, repo, target string) []int
+}
+
+type pullResponse struct {
+	pulls.PullRequest
+	QueuePosition int `json:"queue_position,omitempty"`
+	// Mergeable and Conflicts are only computed when showing a single open
+	// pull request.
+	Mergeable *bool    `json:"mergeable,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+func (s *Server) withQueuePosition(owner, repo string, pr pulls.PullRequest) pullResponse {
+	resp := pullResponse{PullRequest: pr}
+	if pr.QueuedAt != nil {
+		resp.QueuePosition = s.mergeQueue.Position(owner, repo, pr.Target, pr.Number)
+	}
+	return resp
+}
+
+// checkRepoRead verifies the repo exists, can be reached from the client's
+// address and, if it is private, that the request is authenticated as its
+// owner.
+func (s *Server) checkRepoRead(w http.ResponseWriter, r *http.Request, owner, name string) bool {
+	if !s.storage.RepoExists(owner, name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return false
+	}
+
+	meta, err := s.storage.GetMetadata(owner, name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	if !clientip.Allowed(meta.AllowedIPs, clientip.FromRequest(r)) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return false
+	}
+
+	if meta.Private {
+		username, ok := s.bearerUser(w, r)
+		if !ok {
+			return false
+		}
+		if username != owner {
+			s.jsonError(w, "repository not found", http.StatusNotFound)
+			return false
+		}
+	}
+
+	return true
+}
+
+// handleForkRepo copies a repository the user can read into their own
+// namespace, keeping the name unless another is given. Pull requests can
+// then be opened from the fork's branches against the original.
+//
+//	POST /api/v1/repos/fork {"owner", "name", "fork_name"}
+func (s *Server) handleForkRepo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner    string `json:"owner"`
+		Name     string `json:"name"`
+		ForkName string `json:"fork_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	if req.ForkName == "" {
+		req.ForkName = req.Name
+	}
+	if !isValidName(req.ForkName) {
+		s.jsonError(w, "invalid fork name", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoRead(w, r, req.Owner, req.Name) {
+		return
+	}
+
+	if s.storage.RepoExists(username, req.ForkName) {
+		s.jsonError(w, "repository already exists", http.StatusConflict)
+		return
+	}
+
+	if !s.checkQuota(w, username) {
+		return
+	}
+
+	if err := s.storage.Fork(req.Owner, req.Name, username, req.ForkName); err != nil {
+		s.jsonError(w, fmt.Sprintf("fork failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if s.events != nil {
+		if err := s.events.Publish(activitypub.Event{Kind: activitypub.EventCreate, Owner: username, Repo: req.ForkName}); err != nil {
+			log.Printf("publish create of %s/%s failed: %v", username, req.ForkName, err)
+		}
+	}
+	s.publish(events.Event{Kind: events.KindRepoCreated, Owner: username, Repo: req.ForkName})
+	s.audit(r, audit.Entry{Actor: username, Action: "repo.fork", Target: username + "/" + req.ForkName, Detail: "forked from " + req.Owner + "/" + req.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateRepoResponse{
+		Success:  true,
+		RepoPath: fmt.Sprintf("%s/%s.git", username, req.ForkName),
+		CloneURL: fmt.Sprintf("%s/%s/%s.git", s.baseURL(r), username, req.ForkName),
+	})
+}
+
+// handlePulls lists, shows and opens pull requests. Showing a single open
+// pull request also reports whether it merges cleanly into its target.
+//
+//	GET  /api/v1/repos/pulls?owner=..&name=..[&state=open|closed|merged][&number=N]
+//	POST /api/v1/repos/pulls {"owner", "name", "title", "body", "source", "target", "draft", "source_repo"}
+//
+// A pull request whose target is the source branch of another open pull
+// request is stacked on it, and is retargeted when that one merges. With
+// source_repo, source is a branch of that fork of the repository instead.
+func (s *Server) handlePulls(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		s.handleListPulls(w, r)
+	case "POST":
+		s.handleCreatePull(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleListPulls(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+
+	if owner == "" || name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoRead(w, r, owner, name) {
+		return
+	}
+
+	if n := r.URL.Query().Get("number"); n != "" {
+		number, err := strconv.Atoi(n)
+		if err != nil {
+			s.jsonError(w, "invalid number", http.StatusBadRequest)
+			return
+		}
+
+		pr, err := s.pulls.Get(owner, name, number)
+		if err != nil {
+			s.pullError(w, err)
+			return
+		}
+
+		resp := s.withQueuePosition(owner, name, pr)
+		if pr.State == pulls.StateOpen {
+			// A missing branch leaves mergeability unknown rather than
+			// failing the request.
+			if conflicts, err := s.pulls.Conflicts(owner, name, pr); err == nil {
+				mergeable := len(conflicts) == 0
+				resp.Mergeable = &mergeable
+				resp.Conflicts = conflicts
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"pull":    resp,
+		})
+		return
+	}
+
+	prs, err := s.pulls.List(owner, name, r.URL.Query().Get("state"))
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list pull requests failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]pullResponse, 0, len(prs))
+	for _, pr := range prs {
+		result = append(result, s.withQueuePosition(owner, name, pr))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"pulls":   result,
+	})
+}
+
+func (s *Server) handleCreatePull(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner  string `json:"owner"`
+		Name   string `json:"name"`
+		Title  string `json:"title"`
+		Body   string `json:"body"`
+		Source string `json:"source"`
+		Target string `json:"target"`
+		Draft  bool   `json:"draft"`
+
+		SourceRepo string `json:"source_repo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" || req.Title == "" || req.Source == "" || req.Target == "" {
+		s.jsonError(w, "owner, name, title, source and target required", http.StatusBadRequest)
+		return
+	}
+
+	if req.SourceRepo == req.Owner+"/"+req.Name {
+		req.SourceRepo = ""
+	}
+
+	if req.Source == req.Target && req.SourceRepo == "" {
+		s.jsonError(w, "source and target must differ", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoRead(w, r, req.Owner, req.Name) {
+		return
+	}
+
+	meta, err := s.storage.GetMetadata(req.Owner, req.Name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if meta.ReplicaOf != nil {
+		s.jsonError(w, "cannot open pull requests on a read-only replica", http.StatusForbidden)
+		return
+	}
+
+	srcOwner, srcName := req.Owner, req.Name
+	if req.SourceRepo != "" {
+		var ok bool
+		srcOwner, srcName, ok = strings.Cut(req.SourceRepo, "/")
+		if !ok {
+			s.jsonError(w, "source_repo must be owner/name", http.StatusBadRequest)
+			return
+		}
+		if !s.checkRepoRead(w, r, srcOwner, srcName) {
+			return
+		}
+		forkMeta, err := s.storage.GetMetadata(srcOwner, srcName)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if forkMeta.ForkOf != req.Owner+"/"+req.Name {
+			s.jsonError(w, fmt.Sprintf("%s is not a fork of %s/%s", req.SourceRepo, req.Owner, req.Name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := s.storage.ResolveCommit(srcOwner, srcName, "refs/heads/"+req.Source); err != nil {
+		s.jsonError(w, fmt.Sprintf("branch not found: %s", req.Source), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.storage.ResolveCommit(req.Owner, req.Name, "refs/heads/"+req.Target); err != nil {
+		s.jsonError(w, fmt.Sprintf("branch not found: %s", req.Target), http.StatusBadRequest)
+		return
+	}
+
+	basePull, err := s.pulls.StackedOn(req.Owner, req.Name, req.Target)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list pull requests failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	pr, err := s.pulls.Create(req.Owner, req.Name, pulls.PullRequest{
+		Title:      req.Title,
+		Body:       req.Body,
+		Author:     username,
+		Source:     req.Source,
+		Target:     req.Target,
+		SourceRepo: req.SourceRepo,
+		Draft:      req.Draft,
+		BasePull:   basePull,
+	})
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("create pull request failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if pr.SourceRepo != "" {
+		if _, err := s.pulls.Head(req.Owner, req.Name, pr); err != nil {
+			log.Printf("fetch head of %s/%s #%d: %v", req.Owner, req.Name, pr.Number, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"pull":    pr,
+	})
+}
+
+// handlePullDraft marks a pull request as a draft or as ready for merging.
+// The author and the repository owner may change it; a queued pull request
+// must be dequeued first.
+//
+//	POST /api/v1/repos/pulls/draft {"owner", "name", "number", "draft"}
+func (s *Server) handlePullDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner  string `json:"owner"`
+		Name   string `json:"name"`
+		Number int    `json:"number"`
+		Draft  bool   `json:"draft"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" || req.Number == 0 {
+		s.jsonError(w, "owner, name and number required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoRead(w, r, req.Owner, req.Name) {
+		return
+	}
+
+	errForbidden := errors.New("only the author or owner can change draft status")
+	pr, err := s.pulls.Update(req.Owner, req.Name, req.Number, func(pr *pulls.PullRequest) error {
+		if username != pr.Author && username != req.Owner {
+			return errForbidden
+		}
+		if pr.State != pulls.StateOpen {
+			return pulls.ErrNotOpen
+		}
+		if pr.QueuedAt != nil {
+			return pulls.ErrAlreadyQueued
+		}
+		pr.Draft = req.Draft
+		return nil
+	})
+	if errors.Is(err, errForbidden) {
+		s.jsonError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.pullError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"pull":    pr,
+	})
+}
+
+// handlePullState closes or reopens a pull request. The author and the
+// repository owner may change it. Closing takes it out of the merge queue
+// and moves pull requests stacked on it onto its target; a merged pull
+// request can't be reopened.
+//
+//	POST /api/v1/repos/pulls/state {"owner", "name", "number", "state"}
+func (s *Server) handlePullState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner  string `json:"owner"`
+		Name   string `json:"name"`
+		Number int    `json:"number"`
+		State  string `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" || req.Number == 0 {
+		s.jsonError(w, "owner, name and number required", http.StatusBadRequest)
+		return
+	}
+
+	if req.State != pulls.StateOpen && req.State != pulls.StateClosed {
+		s.jsonError(w, "state must be open or closed", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoRead(w, r, req.Owner, req.Name) {
+		return
+	}
+
+	pr, err := s.pulls.Get(req.Owner, req.Name, req.Number)
+	if err != nil {
+		s.pullError(w, err)
+		return
+	}
+
+	if username != pr.Author && username != req.Owner {
+		s.jsonError(w, "only the author or owner can close or reopen a pull request", http.StatusForbidden)
+		return
+	}
+
+	if req.State == pulls.StateClosed {
+		pr, err = s.mergeQueue.Close(req.Owner, req.Name, req.Number, username)
+	} else {
+		basePull := 0
+		if pr.SourceRepo == "" {
+			basePull, err = s.pulls.StackedOn(req.Owner, req.Name, pr.Target)
+			if err != nil {
+				s.jsonError(w, fmt.Sprintf("list pull requests failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		pr, err = s.pulls.Update(req.Owner, req.Name, req.Number, func(pr *pulls.PullRequest) error {
+			if pr.State != pulls.StateClosed {
+				return pulls.ErrNotClosed
+			}
+			pr.State = pulls.StateOpen
+			pr.ClosedAt = nil
+			pr.ClosedBy = ""
+			pr.BasePull = basePull
+			return nil
+		})
+	}
+	if err != nil {
+		s.pullError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"pull":    pr,
+	})
+}
+
+// handleMergePull merges a pull request into its target straight away,
+// bypassing the merge queue. Only the repository owner may merge, and the
+// same rules apply as for queueing: the pull request must be open, not a
+// draft, not stacked, not already queued and approved as the repository's
+// review rules require.
+//
+//	POST /api/v1/repos/pulls/merge {"owner", "name", "number"}
+func (s *Server) handleMergePull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner  string `json:"owner"`
+		Name   string `json:"name"`
+		Number int    `json:"number"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" || req.Number == 0 {
+		s.jsonError(w, "owner, name and number required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.storage.RepoExists(req.Owner, req.Name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	if username != req.Owner {
+		s.jsonError(w, "only the owner can merge", http.StatusForbidden)
+		return
+	}
+
+	meta, err := s.storage.GetMetadata(req.Owner, req.Name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if meta.ReplicaOf != nil {
+		s.jsonError(w, "cannot merge on a read-only replica", http.StatusForbidden)
+		return
+	}
+
+	pr, err := s.mergeQueue.Merge(req.Owner, req.Name, req.Number, username)
+	if err != nil {
+		s.pullError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"pull":    pr,
+	})
+}
+
+// handlePullReviews lists and submits reviews. Any user who can read the
+// repository may review; inline comments must point at a line of the pull
+// request's diff.
+//
+//	GET  /api/v1/repos/pulls/reviews?owner=..&name=..&number=N
+//	POST /api/v1/repos/pulls/reviews {"owner", "name", "number", "state", "body",
+//	                               "comments": [{"path", "position", "body"}]}
+func (s *Server) handlePullReviews(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		number, err := strconv.Atoi(r.URL.Query().Get("number"))
+		if owner == "" || name == "" || err != nil {
+			s.jsonError(w, "owner, name and number required", http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkRepoRead(w, r, owner, name) {
+			return
+		}
+
+		pr, err := s.pulls.Get(owner, name, number)
+		if err != nil {
+			s.pullError(w, err)
+			return
+		}
+
+		reviews := pr.Reviews
+		if reviews == nil {
+			reviews = []pulls.Review{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"reviews": reviews,
+			"states":  pulls.ReviewStates(pr),
+		})
+
+	case "POST":
+		username, ok := s.bearerUser(w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Owner    string                `json:"owner"`
+			Name     string                `json:"name"`
+			Number   int                   `json:"number"`
+			State    string                `json:"state"`
+			Body     string                `json:"body"`
+			Comments []pulls.ReviewComment `json:"comments"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Owner == "" || req.Name == "" || req.Number == 0 {
+			s.jsonError(w, "owner, name and number required", http.StatusBadRequest)
+			return
+		}
+
+		if !pulls.ValidReviewState(req.State) {
+			s.jsonError(w, "state must be approved, changes_requested or commented", http.StatusBadRequest)
+			return
+		}
+
+		if req.State == pulls.ReviewCommented && req.Body == "" && len(req.Comments) == 0 {
+			s.jsonError(w, "a comment review needs a body or comments", http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkRepoRead(w, r, req.Owner, req.Name) {
+			return
+		}
+
+		pr, err := s.pulls.Get(req.Owner, req.Name, req.Number)
+		if err != nil {
+			s.pullError(w, err)
+			return
+		}
+
+		head, err := s.pulls.Head(req.Owner, req.Name, pr)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("branch not found: %s", pr.Source), http.StatusConflict)
+			return
+		}
+
+		diffs := make(map[string]string)
+		for i := range req.Comments {
+			c := &req.Comments[i]
+			if c.Path == "" || (c.Body == "" && c.Suggestion == nil) {
+				s.jsonError(w, "comments need a path and a body or suggestion", http.StatusBadRequest)
+				return
+			}
+			diff, ok := diffs[c.Path]
+			if !ok {
+				diff, err = s.storage.Diff(req.Owner, req.Name, "refs/heads/"+pr.Target, head, c.Path)
+				if err != nil {
+					s.jsonError(w, fmt.Sprintf("diff failed: %v", err), http.StatusInternalServerError)
+					return
+				}
+				diffs[c.Path] = diff
+			}
+			if c.Position < 1 || c.Position > pulls.DiffPositions(diff) {
+				s.jsonError(w, fmt.Sprintf("%s has no diff position %d", c.Path, c.Position), http.StatusBadRequest)
+				return
+			}
+
+			c.Line, c.Original, c.AppliedCommit = 0, "", ""
+			if c.Suggestion != nil {
+				line, ok := pulls.DiffLine(diff, c.Position)
+				if !ok {
+					s.jsonError(w, fmt.Sprintf("suggestions must be on an added or unchanged line (%s position %d)", c.Path, c.Position), http.StatusBadRequest)
+					return
+				}
+				content, err := s.storage.ReadFile(req.Owner, req.Name, head, c.Path)
+				if err != nil {
+					s.jsonError(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				lines := strings.Split(string(content), "\n")
+				if line > len(lines) {
+					s.jsonError(w, fmt.Sprintf("%s has no line %d", c.Path, line), http.StatusBadRequest)
+					return
+				}
+				c.Line = line
+				c.Original = strings.TrimRight(lines[line-1], "\r")
+			}
+		}
+
+		review, err := s.pulls.AddReview(req.Owner, req.Name, req.Number, pulls.Review{
+			Author:   username,
+			State:    req.State,
+			Body:     req.Body,
+			Commit:   head,
+			Comments: req.Comments,
+		})
+		if err != nil {
+			s.pullError(w, err)
+			return
+		}
+
+		if err := s.activity.Record(req.Owner, req.Name, activity.Entry{Kind: activity.KindReview, User: username}); err != nil {
+			log.Printf("record review activity for %s/%s: %v", req.Owner, req.Name, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"review":  review,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleApplySuggestion commits a review comment's suggested change to the
+// pull request's source branch. The pull request's author and the repository
+// owner may apply suggestions; the comment is identified by its review ID and
+// its index within that review.
+//
+//	POST /api/v1/repos/pulls/suggestions {"owner", "name", "number", "review_id", "comment", "message"}
+func (s *Server) handleApplySuggestion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner    string `json:"owner"`
+		Name     string `json:"name"`
+		Number   int    `json:"number"`
+		ReviewID int    `json:"review_id"`
+		Comment  int    `json:"comment"`
+		Message  string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" || req.Number == 0 || req.ReviewID == 0 {
+		s.jsonError(w, "owner, name, number and review_id required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoRead(w, r, req.Owner, req.Name) {
+		return
+	}
+
+	pr, err := s.pulls.Get(req.Owner, req.Name, req.Number)
+	if err != nil {
+		s.pullError(w, err)
+		return
+	}
+
+	// A fork's branch belongs to the fork's owner, not this repository's.
+	srcOwner, srcName := pr.SourceLocation(req.Owner, req.Name)
+	if username != pr.Author && username != srcOwner {
+		s.jsonError(w, "only the author or owner can apply suggestions", http.StatusForbidden)
+		return
+	}
+
+	commit, err := s.pulls.ApplySuggestion(req.Owner, req.Name, req.Number, req.ReviewID, req.Comment, username, req.Message)
+	if err != nil {
+		s.pullError(w, err)
+		return
+	}
+
+	err = s.activity.Record(srcOwner, srcName, activity.Entry{
+		Kind:    activity.KindPush,
+		User:    username,
+		Ref:     "refs/heads/" + pr.Source,
+		New:     commit,
+		Commits: 1,
+	})
+	if err != nil {
+		log.Printf("record activity for %s/%s: %v", srcOwner, srcName, err)
+	}
+
+	if s.replQueue != nil {
+		s.replQueue.Queue(srcOwner, srcName)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"commit":  commit,
+	})
+}
+
+// handleMergeQueue shows and edits a target branch's merge queue. Only the
+// repository owner may queue or dequeue.
+//
+//	GET    /api/v1/repos/merge-queue?owner=..&name=..&target=..
+//	POST   /api/v1/repos/merge-queue {"owner", "name", "number"}
+//	DELETE /api/v1/repos/merge-queue?owner=..&name=..&number=N
+func (s *Server) handleMergeQueue(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		target := r.URL.Query().Get("target")
+
+		if owner == "" || name == "" || target == "" {
+			s.jsonError(w, "owner, name and target required", http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkRepoRead(w, r, owner, name) {
+			return
+		}
+
+		entries := []map[string]interface{}{}
+		for i, number := range s.mergeQueue.Entries(owner, name, target) {
+			entry := map[string]interface{}{"position": i + 1, "number": number}
+			if pr, err := s.pulls.Get(owner, name, number); err == nil {
+				entry["title"] = pr.Title
+				entry["source"] = pr.Source
+				entry["queued_by"] = pr.QueuedBy
+				entry["queued_at"] = pr.QueuedAt
+			}
+			entries = append(entries, entry)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"target":  target,
+			"entries": entries,
+		})
+
+	case "POST":
+		username, ok := s.bearerUser(w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Owner  string `json:"owner"`
+			Name   string `json:"name"`
+			Number int    `json:"number"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Owner == "" || req.Name == "" || req.Number == 0 {
+			s.jsonError(w, "owner, name and number required", http.StatusBadRequest)
+			return
+		}
+
+		if !s.storage.RepoExists(req.Owner, req.Name) {
+			s.jsonError(w, "repository not found", http.StatusNotFound)
+			return
+		}
+
+		if username != req.Owner {
+			s.jsonError(w, "only the owner can merge", http.StatusForbidden)
+			return
+		}
+
+		position, err := s.mergeQueue.Enqueue(req.Owner, req.Name, req.Number, username)
+		if err != nil {
+			s.pullError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"position": position,
+		})
+
+	case "DELETE":
+		username, ok := s.bearerUser(w, r)
+		if !ok {
+			return
+		}
+
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		number, err := strconv.Atoi(r.URL.Query().Get("number"))
+		if owner == "" || name == "" || err != nil {
+			s.jsonError(w, "owner, name and number required", http.StatusBadRequest)
+			return
+		}
+
+		if !s.storage.RepoExists(owner, name) {
+			s.jsonError(w, "repository not found", http.StatusNotFound)
+			return
+		}
+
+		if username != owner {
+			s.jsonError(w, "only the owner can edit the merge queue", http.StatusForbidden)
+			return
+		}
+
+		if err := s.mergeQueue.Dequeue(owner, name, number); err != nil {
+			s.pullError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) pullError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pulls.ErrNotFound), errors.Is(err, pulls.ErrCommentNotFound):
+		s.jsonError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, pulls.ErrNoSuggestion):
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, pulls.ErrPolicyViolation):
+		s.jsonError(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, pulls.ErrOwnReview):
+		s.jsonError(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, storage.ErrMergeConflict):
+		s.jsonError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, pulls.ErrNotOpen), errors.Is(err, pulls.ErrNotClosed), errors.Is(err, pulls.ErrAlreadyQueued), errors.Is(err, pulls.ErrNotQueued),
+		errors.Is(err, pulls.ErrDraft), errors.Is(err, pulls.ErrStacked), errors.Is(err, pulls.ErrReviewRequired),
+		errors.Is(err, pulls.ErrSuggestionApplied), errors.Is(err, pulls.ErrSuggestionOutdated):
+		s.jsonError(w, err.Error(), http.StatusConflict)
+	default:
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jeremytregunna/openhub/internal/quota"
+)
+
+type QuotaChecker interface {
+	Status(owner string) (quota.Status, error)
+}
+
+// SetQuotas enables disk quota reporting and stops owners whose grace period
+// has ended from creating repositories. Pushes are checked by the hooks.
+func (s *Server) SetQuotas(q QuotaChecker) {
+	s.quotas = q
+}
+
+// checkQuota rejects the request if owner is over quota past their grace
+// period.
+func (s *Server) checkQuota(w http.ResponseWriter, owner string) bool {
+	if err := s.quotaAllows(owner); err != nil {
+		s.opFailed(w, err)
+		return false
+	}
+	return true
+}
+
+// quotaAllows returns an error if owner is over their enforced quota.
+func (s *Server) quotaAllows(owner string) error {
+	if s.quotas == nil {
+		return nil
+	}
+	st, err := s.quotas.Status(owner)
+	if err != nil {
+		return fmt.Errorf("check quota failed: %w", err)
+	}
+	if st.Level == quota.LevelEnforced {
+		return opErrorf(http.StatusInsufficientStorage, "%s", st.Message)
+	}
+	return nil
+}
+
+// handleUserQuota reports the requesting user's disk usage against their
+// quota. When they are close to or over it, "message" holds a notice to show
+// them.
+//
+//	GET /api/v1/users/quota
+func (s *Server) handleUserQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	if s.quotas == nil {
+		s.jsonError(w, "quotas are not enabled", http.StatusNotFound)
+		return
+	}
+
+	st, err := s.quotas.Status(username)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("check quota failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"quota":   st,
+	})
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// recoveryRequest identifies a replicated repo by the credentials stored in
+// its origin's recovery bundle. Unlike normal replication traffic it is not
+// signed: an origin restoring from a recovery bundle has usually lost its
+// instance key along with its data.
+type recoveryRequest struct {
+	Owner            string `json:"owner"`
+	Repo             string `json:"repo"`
+	InstanceID       string `json:"instance_id"`
+	InvitationKey    string `json:"invitation_key"`
+	OriginInstanceID string `json:"origin_instance_id,omitempty"`
+	OriginPublicKey  string `json:"origin_public_key,omitempty"`
+}
+
+// readRecoveryRequest authenticates a recovery request by the replication
+// token and invitation key the origin registered this replica with.
+func (s *Server) readRecoveryRequest(w http.ResponseWriter, r *http.Request) (*recoveryRequest, storage.Metadata, bool) {
+	var meta storage.Metadata
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, meta, false
+	}
+
+	if !s.checkClientCert(w, r) {
+		return nil, meta, false
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return nil, meta, false
+	}
+
+	var req recoveryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return nil, meta, false
+	}
+
+	if req.Owner == "" || req.Repo == "" || req.InstanceID == "" || req.InvitationKey == "" {
+		s.jsonError(w, "owner, repo, instance_id, and invitation_key required", http.StatusBadRequest)
+		return nil, meta, false
+	}
+
+	if !isValidName(req.Owner) || !isValidName(req.Repo) {
+		s.jsonError(w, "invalid owner or repo name", http.StatusBadRequest)
+		return nil, meta, false
+	}
+
+	if !s.checkReplicationUser(w, username, req.Owner, req.Repo, req.InstanceID) {
+		return nil, meta, false
+	}
+
+	if !s.storage.RepoExists(req.Owner, req.Repo) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return nil, meta, false
+	}
+
+	meta, err := s.storage.GetMetadata(req.Owner, req.Repo)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return nil, meta, false
+	}
+
+	if meta.ReplicaOf == nil || meta.ReplicaOf.InstanceID != req.InstanceID {
+		s.jsonError(w, "repository is not a replica of this origin", http.StatusForbidden)
+		return nil, meta, false
+	}
+
+	if !invitationKeyMatches(meta.ReplicaOf, req.InvitationKey) {
+		s.jsonError(w, "invalid invitation key", http.StatusForbidden)
+		return nil, meta, false
+	}
+
+	return &req, meta, true
+}
+
+// handleReregisterReplication moves a replica over to a restored origin. The
+// origin proves it owns the replica with the token and invitation key from its
+// recovery bundle, and may come back with a new instance ID and key.
+//
+//	POST /api/v1/repos/reregister-replication
+//	{"owner", "repo", "instance_id", "invitation_key", "origin_instance_id", "origin_public_key"}
+func (s *Server) handleReregisterReplication(w http.ResponseWriter, r *http.Request) {
+	req, _, ok := s.readRecoveryRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if req.OriginInstanceID == "" || !instance.ValidPublicKey(req.OriginPublicKey) {
+		s.jsonError(w, "origin_instance_id and a valid origin_public_key required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.peers.Replace(req.OriginInstanceID, req.OriginPublicKey); err != nil {
+		s.jsonError(w, fmt.Sprintf("register origin key failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if req.OriginInstanceID != req.InstanceID {
+		// The same token moves to the new replication user, and the old
+		// user goes first so the token never resolves to both.
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if err := s.authStore.DeleteUser(ReplicationUsername(req.Owner, req.Repo, req.InstanceID)); err != nil {
+			s.jsonError(w, fmt.Sprintf("delete user failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := s.authStore.CreateUserWithToken(ReplicationUsername(req.Owner, req.Repo, req.OriginInstanceID), "replication", token); err != nil {
+			s.jsonError(w, fmt.Sprintf("create user failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err := s.storage.UpdateMetadata(req.Owner, req.Repo, func(meta *storage.Metadata) error {
+		if meta.ReplicaOf != nil {
+			meta.ReplicaOf.InstanceID = req.OriginInstanceID
+		}
+		return nil
+	})
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("set metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("re-registered %s/%s to origin instance %s (was %s)", req.Owner, req.Repo, req.OriginInstanceID, req.InstanceID)
+	s.audit(r, audit.Entry{
+		Action: "replica.reregister",
+		Target: req.Owner + "/" + req.Repo,
+		Detail: fmt.Sprintf("origin instance %s (was %s)", req.OriginInstanceID, req.InstanceID),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleRecoveryExport sends a replica's copy of a repo back to its origin as
+// a multipart response: a "metadata" part holding the origin metadata last
+// replicated here, then a "bundle" part with every ref. The bundle part is
+// omitted when the replica has no refs.
+//
+//	POST /api/v1/repos/recovery-export {"owner", "repo", "instance_id", "invitation_key"}
+func (s *Server) handleRecoveryExport(w http.ResponseWriter, r *http.Request) {
+	req, meta, ok := s.readRecoveryRequest(w, r)
+	if !ok {
+		return
+	}
+
+	refs, err := s.storage.ListRefs(req.Owner, req.Repo)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list refs failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	meta.ReplicaOf = nil
+	meta.Replicas = nil
+	meta.Mirrors = nil
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("marshal metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", mw.FormDataContentType())
+
+	if err := mw.WriteField("metadata", string(metaBytes)); err != nil {
+		return
+	}
+
+	if len(refs) > 0 {
+		part, err := mw.CreateFormFile("bundle", "repo.bundle")
+		if err != nil {
+			return
+		}
+		// Headers are already sent, so a failure here can only cut the
+		// response short; the origin then fails to read the bundle.
+		if err := s.storage.WriteBundle(req.Owner, req.Repo, part); err != nil {
+			log.Printf("recovery export %s/%s: %v", req.Owner, req.Repo, err)
+			return
+		}
+	}
+
+	mw.Close()
+}
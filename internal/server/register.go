@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/events"
+	"golang.org/x/crypto/ssh"
+)
+
+// SetRegistration sets who may create their own account: auth.RegistrationOpen,
+// auth.RegistrationInvite or auth.RegistrationClosed, the default.
+func (s *Server) SetRegistration(mode string) {
+	s.registration = mode
+}
+
+// validUsername reports whether name can be given to a new account. Names
+// of per-repo replication accounts and of the fixed paths under
+// /api/v1/users/, which would hide the user's profile, are reserved.
+func (s *Server) validUsername(name string) bool {
+	if !isValidName(name) || strings.HasPrefix(name, "replication-") {
+		return false
+	}
+	for _, rt := range s.routes() {
+		sub, ok := strings.CutPrefix(rt.path, "/users/")
+		if ok && strings.EqualFold(strings.SplitN(sub, "/", 2)[0], name) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleRegister lets people create their own account when registration is
+// open, or with an invite code from an admin when it is by invite. It
+// returns an API token and recovery codes, and registers ssh_key if given.
+//
+//	GET  /api/v1/users/register
+//	POST /api/v1/users/register {"username", "invite", "ssh_key", "token_name"}
+//
+// GET reports the registration mode.
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	mode := s.registration
+	if mode == "" {
+		mode = auth.RegistrationClosed
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      true,
+			"registration": mode,
+		})
+		return
+	case "POST":
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if mode == auth.RegistrationClosed {
+		s.jsonError(w, "registration is closed on this instance", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Username  string `json:"username"`
+		Invite    string `json:"invite"`
+		SSHKey    string `json:"ssh_key"`
+		TokenName string `json:"token_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !s.validUsername(req.Username) {
+		s.jsonError(w, "invalid or reserved username", http.StatusBadRequest)
+		return
+	}
+	if mode == auth.RegistrationInvite && req.Invite == "" {
+		s.jsonError(w, "invite required", http.StatusBadRequest)
+		return
+	}
+	req.SSHKey = strings.TrimSpace(req.SSHKey)
+	if req.SSHKey != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.SSHKey)); err != nil {
+			s.jsonError(w, "invalid ssh_key", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.TokenName == "" {
+		req.TokenName = "default"
+	}
+
+	invite, err := s.authStore.Register(req.Username, req.Invite, mode == auth.RegistrationInvite)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInviteDenied):
+			s.audit(r, audit.Entry{Action: "user.register-failed", Target: req.Username, Detail: "bad invite"})
+			s.jsonError(w, err.Error(), http.StatusForbidden)
+		case strings.Contains(err.Error(), "already exists"):
+			s.jsonError(w, "username is taken", http.StatusConflict)
+		default:
+			s.jsonError(w, fmt.Sprintf("register failed: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	detail := "open registration"
+	if invite != "" {
+		detail = "invite " + invite
+	}
+	s.audit(r, audit.Entry{Actor: req.Username, Action: "user.register", Target: req.Username, Detail: detail})
+	s.publish(events.Event{Kind: events.KindUserCreated, Owner: req.Username, User: req.Username})
+
+	if req.SSHKey != "" {
+		if err := s.authStore.AddSSHKey(req.Username, "default", req.SSHKey); err != nil {
+			s.jsonError(w, fmt.Sprintf("add ssh key failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	token, err := s.authStore.GenerateAPIToken(req.Username, req.TokenName)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("generate token failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := s.authStore.GenerateRecoveryCodes(req.Username)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("generate recovery codes failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"username":       req.Username,
+		"token":          token,
+		"recovery_codes": codes,
+	})
+}
+
+// handleAdminInvites lists, creates and revokes registration invite codes.
+// A new code is shown once; expires is a duration and defaults to a week.
+//
+//	GET    /api/v1/admin/invites
+//	POST   /api/v1/admin/invites {"note", "expires"}
+//	DELETE /api/v1/admin/invites?id=..
+func (s *Server) handleAdminInvites(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	admin := s.requestUser(r)
+
+	switch r.Method {
+	case "GET":
+		invites, err := s.authStore.ListInviteCodes()
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("list invites failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range invites {
+			invites[i].Hash = ""
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"invites": invites,
+		})
+
+	case "POST":
+		var req struct {
+			Note    string `json:"note"`
+			Expires string `json:"expires"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		ttl := 7 * 24 * time.Hour
+		if req.Expires != "" {
+			d, err := time.ParseDuration(req.Expires)
+			if err != nil || d <= 0 {
+				s.jsonError(w, "expires must be a positive duration", http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+
+		invite, code, err := s.authStore.CreateInviteCode(admin, req.Note, ttl)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("create invite failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: admin, Action: "invite.create", Target: invite.ID, Detail: req.Note})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"id":         invite.ID,
+			"code":       code,
+			"expires_at": invite.ExpiresAt,
+		})
+
+	case "DELETE":
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			s.jsonError(w, "id required", http.StatusBadRequest)
+			return
+		}
+		if err := s.authStore.RevokeInviteCode(id); err != nil {
+			s.jsonError(w, fmt.Sprintf("revoke invite failed: %v", err), http.StatusNotFound)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: admin, Action: "invite.revoke", Target: id})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+	}
+}
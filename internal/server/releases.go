@@ -0,0 +1,302 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/releases"
+)
+
+type ReleaseStore interface {
+	Create(owner, repo string, release releases.Release) (releases.Release, error)
+	Get(owner, repo, tag string) (releases.Release, error)
+	List(owner, repo string) ([]releases.Release, error)
+	Delete(owner, repo, tag string) error
+	DeleteAsset(owner, repo, tag, asset string) error
+}
+
+// SetReleases enables releases. Asset uploads then require the release to
+// exist first.
+func (s *Server) SetReleases(store ReleaseStore) {
+	s.releases = store
+}
+
+type releaseAsset struct {
+	releases.Asset
+	DownloadURL string `json:"download_url"`
+}
+
+type releaseResponse struct {
+	releases.Release
+	Assets []releaseAsset `json:"assets"`
+}
+
+// withDownloadURLs adds each asset's stable download URL, served by the git
+// HTTP server under the same visibility rules as the repository.
+func (s *Server) withDownloadURLs(base, owner, name string, release releases.Release) releaseResponse {
+	resp := releaseResponse{Release: release, Assets: []releaseAsset{}}
+	for _, a := range release.Assets {
+		resp.Assets = append(resp.Assets, releaseAsset{
+			Asset:       a,
+			DownloadURL: fmt.Sprintf("%s/%s/%s/releases/download/%s/%s", base, owner, name, release.Tag, a.Name),
+		})
+	}
+	return resp
+}
+
+func (s *Server) releaseError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, releases.ErrNotFound), errors.Is(err, releases.ErrAssetNotFound):
+		s.jsonError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, releases.ErrAlreadyExists):
+		s.jsonError(w, err.Error(), http.StatusConflict)
+	default:
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// checkReleaseWrite verifies that releases are enabled, the repo exists and
+// is its own origin, and that user owns it.
+func (s *Server) checkReleaseWrite(w http.ResponseWriter, username, owner, name string) bool {
+	if s.releases == nil {
+		s.jsonError(w, "releases are not enabled on this instance", http.StatusNotFound)
+		return false
+	}
+
+	if !s.storage.RepoExists(owner, name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return false
+	}
+
+	if username != owner {
+		s.jsonError(w, "only the owner can manage releases", http.StatusForbidden)
+		return false
+	}
+
+	meta, err := s.storage.GetMetadata(owner, name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return false
+	}
+
+	if meta.ReplicaOf != nil {
+		s.jsonError(w, "cannot change releases on a read-only replica", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// handleReleases lists, shows, creates and deletes releases. Anyone who can
+// read the repository can see its releases; only the owner may create or
+// delete them. Deleting a release removes its assets but not its tag.
+//
+//	GET    /api/v1/repos/releases?owner=..&name=..[&tag=..]
+//	POST   /api/v1/repos/releases {"owner", "name", "tag", "title", "body", "prerelease"}
+//	DELETE /api/v1/repos/releases?owner=..&name=..&tag=..
+func (s *Server) handleReleases(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		if owner == "" || name == "" {
+			s.jsonError(w, "owner and name required", http.StatusBadRequest)
+			return
+		}
+
+		if s.releases == nil {
+			s.jsonError(w, "releases are not enabled on this instance", http.StatusNotFound)
+			return
+		}
+
+		if !s.checkRepoRead(w, r, owner, name) {
+			return
+		}
+
+		if tag := r.URL.Query().Get("tag"); tag != "" {
+			release, err := s.releases.Get(owner, name, tag)
+			if err != nil {
+				s.releaseError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true,
+				"release": s.withDownloadURLs(s.baseURL(r), owner, name, release),
+			})
+			return
+		}
+
+		list, err := s.releases.List(owner, name)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("list releases failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		result := make([]releaseResponse, 0, len(list))
+		for _, release := range list {
+			result = append(result, s.withDownloadURLs(s.baseURL(r), owner, name, release))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"releases": result,
+		})
+
+	case "POST":
+		username, ok := s.bearerUser(w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Owner      string `json:"owner"`
+			Name       string `json:"name"`
+			Tag        string `json:"tag"`
+			Title      string `json:"title"`
+			Body       string `json:"body"`
+			Prerelease bool   `json:"prerelease"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Owner == "" || req.Name == "" || req.Tag == "" {
+			s.jsonError(w, "owner, name and tag required", http.StatusBadRequest)
+			return
+		}
+
+		if !isValidName(req.Tag) {
+			s.jsonError(w, "invalid tag", http.StatusBadRequest)
+			return
+		}
+
+		req.Title = strings.TrimSpace(req.Title)
+		if req.Title == "" {
+			req.Title = req.Tag
+		}
+		if len(req.Title) > releases.MaxTitleLength || len(req.Body) > releases.MaxBodyLength {
+			s.jsonError(w, fmt.Sprintf("title is limited to %d bytes and body to %d", releases.MaxTitleLength, releases.MaxBodyLength), http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkReleaseWrite(w, username, req.Owner, req.Name) {
+			return
+		}
+
+		if _, err := s.storage.ResolveCommit(req.Owner, req.Name, "refs/tags/"+req.Tag); err != nil {
+			s.jsonError(w, fmt.Sprintf("tag not found: %s", req.Tag), http.StatusBadRequest)
+			return
+		}
+
+		release, err := s.releases.Create(req.Owner, req.Name, releases.Release{
+			Tag:        req.Tag,
+			Title:      req.Title,
+			Body:       req.Body,
+			Author:     username,
+			Prerelease: req.Prerelease,
+		})
+		if err != nil {
+			s.releaseError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"release": s.withDownloadURLs(s.baseURL(r), req.Owner, req.Name, release),
+		})
+
+	case "DELETE":
+		username, ok := s.bearerUser(w, r)
+		if !ok {
+			return
+		}
+
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		tag := r.URL.Query().Get("tag")
+		if owner == "" || name == "" || tag == "" {
+			s.jsonError(w, "owner, name and tag required", http.StatusBadRequest)
+			return
+		}
+
+		if !isValidName(tag) {
+			s.jsonError(w, "invalid tag", http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkReleaseWrite(w, username, owner, name) {
+			return
+		}
+
+		if err := s.releases.Delete(owner, name, tag); err != nil {
+			s.releaseError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleReleaseAssets deletes a single release asset. Only the owner may
+// delete; uploading a replacement needs the old asset deleted first.
+//
+//	DELETE /api/v1/repos/releases/assets?owner=..&name=..&tag=..&asset=..
+func (s *Server) handleReleaseAssets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+	tag := r.URL.Query().Get("tag")
+	asset := r.URL.Query().Get("asset")
+	if owner == "" || name == "" || tag == "" || asset == "" {
+		s.jsonError(w, "owner, name, tag and asset required", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidName(tag) || !isValidName(asset) {
+		s.jsonError(w, "invalid tag or asset", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkReleaseWrite(w, username, owner, name) {
+		return
+	}
+
+	if _, err := s.releases.Get(owner, name, tag); err != nil {
+		s.releaseError(w, err)
+		return
+	}
+
+	if err := s.releases.DeleteAsset(owner, name, tag, asset); err != nil {
+		s.releaseError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,490 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/discovery"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// federationTimeout bounds each request the API makes to another instance
+// on an administrator's behalf.
+const federationTimeout = 30 * time.Second
+
+// SetFederationTLS sets the TLS configuration for the API's own requests to
+// other instances, such as the handshake made when a replica is added.
+func (s *Server) SetFederationTLS(cfg *tls.Config) {
+	s.federationTLS = cfg
+}
+
+func (s *Server) federationClient() *http.Client {
+	client := &http.Client{Timeout: federationTimeout}
+	if s.federationTLS != nil {
+		client.Transport = &http.Transport{TLSClientConfig: s.federationTLS}
+	}
+	return client
+}
+
+type addReplicaRequest struct {
+	Owner string   `json:"owner"`
+	Name  string   `json:"name"`
+	URL   string   `json:"url"`
+	Refs  []string `json:"refs"`
+	// AllowChain lets the replica replicate the repo on to instances of its
+	// own.
+	AllowChain bool `json:"allow_chain"`
+	// Invite holds the repository at the replica until its admin accepts
+	// it with the invitation key, having seen what they will host.
+	Invite bool `json:"invite"`
+}
+
+// handleAdminReplicas lists, adds and removes a repository's replicas. The
+// list carries each replica's token and invitation key, so it doubles as
+// the recovery bundle restore-from-recovery rebuilds a lost origin from.
+// Adding one handshakes with the replica, registers the repository there
+// and returns the invitation key to pass on to its administrator. A url can
+// be a bare domain, resolved as for the handshake.
+//
+//	GET    /api/v1/admin/replicas?owner=..&name=..
+//	POST   /api/v1/admin/replicas {"owner", "name", "url", "refs", "allow_chain", "invite"}
+//	DELETE /api/v1/admin/replicas?owner=..&name=..&instance_id=..
+func (s *Server) handleAdminReplicas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	admin := s.requestUser(r)
+
+	switch r.Method {
+	case "GET":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		if owner == "" || name == "" {
+			s.jsonError(w, "owner and name required", http.StatusBadRequest)
+			return
+		}
+		if !s.storage.RepoExists(owner, name) {
+			s.jsonError(w, "repository not found", http.StatusNotFound)
+			return
+		}
+
+		meta, err := s.storage.GetMetadata(owner, name)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		replicas := meta.Replicas
+		if replicas == nil {
+			replicas = []storage.Replica{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"repo":     owner + "/" + name,
+			"replicas": replicas,
+		})
+
+	case "POST":
+		var req addReplicaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		replica, pending, err := s.addReplica(requestActor(r, admin), req)
+		if err != nil {
+			s.opFailed(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"replica": replica,
+			"pending": pending,
+		})
+
+	case "DELETE":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		instanceID := r.URL.Query().Get("instance_id")
+		if err := s.removeReplica(requestActor(r, admin), owner, name, instanceID); err != nil {
+			s.opFailed(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+	}
+}
+
+// addReplica handshakes with the instance at req.URL for a and makes it a
+// replica of the repository, returning the replica and whether its admin
+// has yet to accept it.
+func (s *Server) addReplica(a actor, req addReplicaRequest) (storage.Replica, bool, error) {
+	if req.Owner == "" || req.Name == "" || req.URL == "" {
+		return storage.Replica{}, false, opErrorf(http.StatusBadRequest, "owner, name and url required")
+	}
+	for _, ref := range req.Refs {
+		if !storage.ValidRefPattern(ref) {
+			return storage.Replica{}, false, opErrorf(http.StatusBadRequest, "invalid ref pattern: %s", ref)
+		}
+	}
+	if s.instance == nil {
+		return storage.Replica{}, false, opErrorf(http.StatusServiceUnavailable, "federation is not enabled on this instance")
+	}
+	if !s.storage.RepoExists(req.Owner, req.Name) {
+		return storage.Replica{}, false, opErrorf(http.StatusNotFound, "repository not found")
+	}
+
+	url, err := discovery.ResolveURL(req.URL)
+	if err != nil {
+		return storage.Replica{}, false, opErrorf(http.StatusBadRequest, "resolve replica: %v", err)
+	}
+
+	meta, err := s.storage.GetMetadata(req.Owner, req.Name)
+	if err != nil {
+		return storage.Replica{}, false, fmt.Errorf("get metadata failed: %w", err)
+	}
+	if !meta.ChainAllowed() {
+		return storage.Replica{}, false, opErrorf(http.StatusConflict, "this repository is a replica and its origin has not allowed it to chain")
+	}
+
+	client := s.federationClient()
+	hello, err := s.instance.Handshake(client, s.externalURL, url, signatureMaxSkew)
+	if err != nil {
+		return storage.Replica{}, false, opErrorf(http.StatusBadGateway, "handshake with %s failed: %v", url, err)
+	}
+	if err := s.peers.Record(hello.Peer()); err != nil {
+		return storage.Replica{}, false, opErrorf(http.StatusConflict, "record peer failed: %v", err)
+	}
+	if !hello.Supports("replicate") {
+		return storage.Replica{}, false, opErrorf(http.StatusConflict, "the replica does not accept replication")
+	}
+	if req.AllowChain && !hello.Supports("chain") {
+		return storage.Replica{}, false, opErrorf(http.StatusConflict, "the replica does not support chained replication")
+	}
+	if req.Invite && !hello.Supports("invitations") {
+		return storage.Replica{}, false, opErrorf(http.StatusConflict, "the replica does not support invitations")
+	}
+
+	token, err := randomHex()
+	if err != nil {
+		return storage.Replica{}, false, fmt.Errorf("generate token failed: %w", err)
+	}
+	invitationKey, err := randomHex()
+	if err != nil {
+		return storage.Replica{}, false, fmt.Errorf("generate invitation key failed: %w", err)
+	}
+
+	replica := storage.Replica{
+		InstanceID:    s.instance.ID,
+		URL:           url,
+		Token:         token,
+		InvitationKey: invitationKey,
+		Enabled:       true,
+		Refs:          req.Refs,
+		AllowChain:    req.AllowChain,
+		PeerID:        hello.InstanceID,
+	}
+	pending, err := s.registerReplica(client, req.Owner, req.Name, meta, replica, req.Invite)
+	if err != nil {
+		return storage.Replica{}, false, opErrorf(http.StatusBadGateway, "replica registration failed: %v", err)
+	}
+
+	// A replica that supports invitations tells us when its admin accepts;
+	// until then there's no point pushing. Older ones are retried as usual.
+	if pending && hello.Supports("invitations") {
+		replica.Enabled = false
+		replica.Pending = true
+	}
+	err = s.storage.UpdateMetadata(req.Owner, req.Name, func(meta *storage.Metadata) error {
+		meta.Replicas = append(meta.Replicas, replica)
+		return nil
+	})
+	if err != nil {
+		return storage.Replica{}, false, fmt.Errorf("update metadata failed: %w", err)
+	}
+	s.auditBy(a, audit.Entry{Action: "replica.add", Target: req.Owner + "/" + req.Name,
+		Detail: fmt.Sprintf("%s, instance %s", url, hello.InstanceID)})
+	return replica, pending, nil
+}
+
+// removeReplica stops replicating owner/name to the replica instanceID for
+// a.
+func (s *Server) removeReplica(a actor, owner, name, instanceID string) error {
+	if owner == "" || name == "" || instanceID == "" {
+		return opErrorf(http.StatusBadRequest, "owner, name and instance_id required")
+	}
+	if !s.storage.RepoExists(owner, name) {
+		return opErrorf(http.StatusNotFound, "repository not found")
+	}
+
+	found := false
+	err := s.storage.UpdateMetadata(owner, name, func(meta *storage.Metadata) error {
+		var kept []storage.Replica
+		for _, rep := range meta.Replicas {
+			if rep.InstanceID == instanceID {
+				found = true
+				continue
+			}
+			kept = append(kept, rep)
+		}
+		meta.Replicas = kept
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("update metadata failed: %w", err)
+	}
+	if !found {
+		return opErrorf(http.StatusNotFound, "no replica with instance ID %s", instanceID)
+	}
+	s.auditBy(a, audit.Entry{Action: "replica.remove", Target: owner + "/" + name, Detail: "instance " + instanceID})
+	return nil
+}
+
+// registerReplica asks the instance at replica.URL to become a replica of
+// the repository, accepting pushes that carry the replica's token, and
+// describes what it will receive. With invite, the replica holds the
+// repository until its admin accepts it with the invitation key. It reports
+// whether the replica's admin has yet to approve or accept, refusing pushes
+// until they do.
+func (s *Server) registerReplica(client *http.Client, owner, name string, meta storage.Metadata, replica storage.Replica, invite bool) (bool, error) {
+	size, err := s.storage.RepoSize(owner, name)
+	if err != nil {
+		return false, err
+	}
+	body := map[string]interface{}{
+		"owner":              owner,
+		"repo":               name,
+		"replica_url":        replica.URL,
+		"token":              replica.Token,
+		"origin_instance_id": s.instance.ID,
+		"origin_public_key":  s.instance.PublicKey,
+		"origin_url":         s.externalURL,
+		"refs":               replica.Refs,
+		"allow_chain":        replica.AllowChain,
+		"description":        meta.Description,
+		"size":               size,
+	}
+	if invite {
+		body["invitation_key_hash"] = auth.HashSecret(replica.InvitationKey)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := client.Post(replica.URL+"/api/repos/register-replication", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("contact replica: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool   `json:"success"`
+		Pending bool   `json:"pending"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("decode response (status %d): %w", resp.StatusCode, err)
+	}
+	if !result.Success {
+		if result.Error == "" {
+			result.Error = resp.Status
+		}
+		return false, fmt.Errorf("%s", result.Error)
+	}
+	return result.Pending, nil
+}
+
+func randomHex() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handleAdminReplicaChain grants or withdraws a replica's consent to
+// replicate the repository on to instances of its own. The replica learns of
+// the change with the next sync.
+//
+//	POST /api/v1/admin/replicas/chain {"owner", "name", "url", "allow"}
+func (s *Server) handleAdminReplicaChain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	admin := s.requestUser(r)
+
+	var req struct {
+		Owner string `json:"owner"`
+		Name  string `json:"name"`
+		URL   string `json:"url"`
+		Allow bool   `json:"allow"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Owner == "" || req.Name == "" || req.URL == "" {
+		s.jsonError(w, "owner, name and url required", http.StatusBadRequest)
+		return
+	}
+	if !s.storage.RepoExists(req.Owner, req.Name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	url := strings.TrimSuffix(req.URL, "/")
+	found := false
+	err := s.storage.UpdateMetadata(req.Owner, req.Name, func(meta *storage.Metadata) error {
+		for i := range meta.Replicas {
+			if meta.Replicas[i].URL == url {
+				meta.Replicas[i].AllowChain = req.Allow
+				found = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("update metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		s.jsonError(w, fmt.Sprintf("no replica at %s", url), http.StatusNotFound)
+		return
+	}
+	s.audit(r, audit.Entry{Actor: admin, Action: "replica.chain", Target: req.Owner + "/" + req.Name,
+		Detail: fmt.Sprintf("%s, allow %t", url, req.Allow)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleAdminReplicaPause pauses or resumes pushes to the replica at url,
+// to all of a repository's replicas without one, or all replication on the
+// instance without a repository, as for a maintenance window. A resumed
+// replica is synced straight away.
+//
+//	POST /api/v1/admin/replicas/pause {"owner", "name", "url", "paused"}
+func (s *Server) handleAdminReplicaPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	admin := s.requestUser(r)
+
+	var req struct {
+		Owner  string `json:"owner"`
+		Name   string `json:"name"`
+		URL    string `json:"url"`
+		Paused bool   `json:"paused"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	action := "replica.resume"
+	if req.Paused {
+		action = "replica.pause"
+	}
+
+	if req.Owner == "" && req.Name == "" && req.URL == "" {
+		if s.replQueue == nil {
+			s.jsonError(w, "replication is not enabled on this instance", http.StatusServiceUnavailable)
+			return
+		}
+		var err error
+		if req.Paused {
+			err = s.replQueue.Pause()
+		} else {
+			err = s.replQueue.Resume()
+		}
+		if err != nil {
+			s.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: admin, Action: action, Target: "instance"})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+	if !s.storage.RepoExists(req.Owner, req.Name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	url := strings.TrimSuffix(req.URL, "/")
+	var changed []string
+	err := s.storage.UpdateMetadata(req.Owner, req.Name, func(meta *storage.Metadata) error {
+		for i := range meta.Replicas {
+			if url == "" || meta.Replicas[i].URL == url {
+				meta.Replicas[i].Enabled = !req.Paused
+				changed = append(changed, meta.Replicas[i].URL)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("update metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(changed) == 0 {
+		if url == "" {
+			s.jsonError(w, "repository has no replicas", http.StatusNotFound)
+		} else {
+			s.jsonError(w, fmt.Sprintf("no replica at %s", url), http.StatusNotFound)
+		}
+		return
+	}
+	s.audit(r, audit.Entry{Actor: admin, Action: action, Target: req.Owner + "/" + req.Name,
+		Detail: strings.Join(changed, ", ")})
+
+	if !req.Paused && s.replQueue != nil {
+		s.replQueue.Queue(req.Owner, req.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"replicas": changed,
+	})
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/issues"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// signatureMaxSkew bounds how old (or far in the future) a signed replication
+// request may be, which limits the window for replaying a captured request.
+const signatureMaxSkew = 10 * time.Minute
+
+type replicationMessage struct {
+	Owner         string           `json:"owner"`
+	Repo          string           `json:"repo"`
+	InstanceID    string           `json:"instance_id"`
+	InvitationKey string           `json:"invitation_key"`
+	Metadata      storage.Metadata `json:"metadata"`
+	Issues        []issues.Issue   `json:"issues"`
+}
+
+func (s *Server) bearerUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		s.jsonError(w, "missing authorization", http.StatusUnauthorized)
+		return "", false
+	}
+
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		s.jsonError(w, "invalid authorization header", http.StatusUnauthorized)
+		return "", false
+	}
+
+	username, err := s.authStore.ValidateAPIToken(parts[1], clientip.FromRequest(r))
+	if errors.Is(err, auth.ErrAddressNotAllowed) {
+		s.audit(r, audit.Entry{Action: "auth.failed", Target: r.URL.Path, Detail: tokenFailure(err)})
+		s.jsonError(w, "token not allowed from this address", http.StatusForbidden)
+		return "", false
+	}
+	if err != nil {
+		s.audit(r, audit.Entry{Action: "auth.failed", Target: r.URL.Path, Detail: tokenFailure(err)})
+		s.jsonError(w, "invalid token", http.StatusUnauthorized)
+		return "", false
+	}
+
+	return username, true
+}
+
+// tokenFailure describes why a token was refused, for the audit log.
+func tokenFailure(err error) string {
+	if errors.Is(err, auth.ErrAddressNotAllowed) {
+		return "API token used from an address it isn't allowed from"
+	}
+	return "invalid API token"
+}
+
+func (s *Server) checkReplicationUser(w http.ResponseWriter, username, owner, repo, instanceID string) bool {
+	if !strings.HasPrefix(username, "replication-") {
+		s.jsonError(w, "unauthorized: not a replication user", http.StatusForbidden)
+		return false
+	}
+
+	if username != ReplicationUsername(owner, repo, instanceID) {
+		s.jsonError(w, "unauthorized: token mismatch", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// verifySignature checks a request signature against the key pinned for the
+// origin instance when replication was registered.
+func (s *Server) verifySignature(w http.ResponseWriter, instanceID, kind, timestamp, signature string, parts ...string) bool {
+	if timestamp == "" || signature == "" {
+		s.jsonError(w, "missing request signature", http.StatusUnauthorized)
+		return false
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		s.jsonError(w, "invalid signature timestamp", http.StatusBadRequest)
+		return false
+	}
+
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		s.jsonError(w, "signature timestamp out of range", http.StatusUnauthorized)
+		return false
+	}
+
+	peer, ok, err := s.peers.Get(instanceID)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("lookup origin key failed: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		s.jsonError(w, "no key registered for origin instance; re-run add-replica", http.StatusForbidden)
+		return false
+	}
+
+	if !instance.Verify(peer.PublicKey, instance.SignatureMessage(kind, ts, parts...), signature) {
+		s.jsonError(w, "invalid request signature", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// ReplicationUsername is the account the instance instanceID pushes the
+// repository owner/repo to this replica with.
+func ReplicationUsername(owner, repo, instanceID string) string {
+	return fmt.Sprintf("replication-%s-%s-%s", owner, repo, instanceID)
+}
+
+// invitationKeyMatches reports whether key is the invitation key the origin
+// registered the replica src describes with.
+func invitationKeyMatches(src *storage.ReplicaSource, key string) bool {
+	if src.InvitationKeyHash != "" {
+		return auth.SecretMatches(src.InvitationKeyHash, key)
+	}
+	return auth.EqualSecrets(src.InvitationKey, key)
+}
+
+// readReplicationMessage authenticates a signed JSON replication message of at
+// most limit bytes for a repo that must already exist on this instance as a
+// replica of the sender.
+func (s *Server) readReplicationMessage(w http.ResponseWriter, r *http.Request, kind string, limit int64) (*replicationMessage, storage.Metadata, bool) {
+	var existing storage.Metadata
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, existing, false
+	}
+
+	if !s.checkClientCert(w, r) {
+		return nil, existing, false
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return nil, existing, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit))
+	if err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return nil, existing, false
+	}
+
+	var req replicationMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return nil, existing, false
+	}
+
+	if req.Owner == "" || req.Repo == "" || req.InstanceID == "" || req.InvitationKey == "" {
+		s.jsonError(w, "owner, repo, instance_id, and invitation_key required", http.StatusBadRequest)
+		return nil, existing, false
+	}
+
+	if !isValidName(req.Owner) || !isValidName(req.Repo) {
+		s.jsonError(w, "invalid owner or repo name", http.StatusBadRequest)
+		return nil, existing, false
+	}
+
+	if !s.checkReplicationUser(w, username, req.Owner, req.Repo, req.InstanceID) {
+		return nil, existing, false
+	}
+
+	if !s.verifySignature(w, req.InstanceID, kind, r.Header.Get("X-OpenHub-Timestamp"),
+		r.Header.Get("X-OpenHub-Signature"), instance.Digest(body)) {
+		return nil, existing, false
+	}
+
+	if !s.storage.RepoExists(req.Owner, req.Repo) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return nil, existing, false
+	}
+
+	existing, err = s.storage.GetMetadata(req.Owner, req.Repo)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return nil, existing, false
+	}
+
+	if existing.ReplicaOf == nil {
+		s.jsonError(w, "repository is not a replica", http.StatusConflict)
+		return nil, existing, false
+	}
+
+	if !invitationKeyMatches(existing.ReplicaOf, req.InvitationKey) {
+		s.jsonError(w, "invalid invitation key", http.StatusForbidden)
+		return nil, existing, false
+	}
+
+	return &req, existing, true
+}
+
+func (s *Server) handleReplicateMetadata(w http.ResponseWriter, r *http.Request) {
+	req, existing, ok := s.readReplicationMessage(w, r, "replicate-metadata", maxFormFieldSize)
+	if !ok {
+		return
+	}
+
+	meta := req.Metadata
+	source := *existing.ReplicaOf
+	source.AllowChain = meta.ReplicaOf != nil && meta.ReplicaOf.AllowChain
+	meta.ReplicaOf = &source
+	meta.Replicas = existing.Replicas
+	meta.Webhooks = existing.Webhooks
+	meta.Upstream = existing.Upstream
+
+	if err := s.storage.SetMetadata(req.Owner, req.Repo, meta); err != nil {
+		s.jsonError(w, fmt.Sprintf("set metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if len(meta.Replicas) > 0 && s.replQueue != nil {
+		s.replQueue.QueueMetadata(req.Owner, req.Repo)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleReplicateIssues replaces a replica's issues with the origin's, and
+// passes them on to the replica's own replicas when it may chain.
+//
+//	POST /api/v1/repos/replicate-issues
+func (s *Server) handleReplicateIssues(w http.ResponseWriter, r *http.Request) {
+	req, existing, ok := s.readReplicationMessage(w, r, "replicate-issues", maxIssuesMessageSize)
+	if !ok {
+		return
+	}
+
+	if s.issues == nil {
+		s.jsonError(w, "issues are not enabled on this instance", http.StatusNotFound)
+		return
+	}
+
+	if err := s.issues.Replace(req.Owner, req.Repo, req.Issues); err != nil {
+		s.jsonError(w, fmt.Sprintf("replace issues failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if len(existing.Replicas) > 0 && existing.ChainAllowed() && s.replQueue != nil {
+		s.replQueue.QueueIssues(req.Owner, req.Repo)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+func (s *Server) handleReplicateDelete(w http.ResponseWriter, r *http.Request) {
+	req, existing, ok := s.readReplicationMessage(w, r, "replicate-delete", maxFormFieldSize)
+	if !ok {
+		return
+	}
+
+	if len(existing.Replicas) > 0 && existing.ChainAllowed() && s.replQueue != nil {
+		s.replQueue.QueueDelete(req.Owner, req.Repo, existing.Replicas)
+	}
+
+	if err := s.storage.DeleteRepo(req.Owner, req.Repo); err != nil {
+		s.jsonError(w, fmt.Sprintf("delete failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The scoped replication account is useless once the repo is gone, and a
+	// stale one would block re-registration with a fresh token.
+	if err := s.authStore.DeleteUser(ReplicationUsername(req.Owner, req.Repo, req.InstanceID)); err != nil {
+		s.jsonError(w, fmt.Sprintf("delete replication user failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// apiV1 is where the current API is served. Requests to the unversioned
+// /api paths it replaced are rewritten onto it.
+const apiV1 = "/api/v1"
+
+// Who may call an operation.
+const (
+	authNone     = ""         // anyone
+	authOptional = "optional" // anyone, but a token unlocks private repos
+	authToken    = "token"    // a user's API token
+	authAdmin    = "admin"    // the token of a user named with --admin-users
+	authInstance = "instance" // another instance, with a replication token or client certificate
+)
+
+// route is an API endpoint, registered under apiV1 and described in the
+// OpenAPI document from the same entry, so neither can drift from the other.
+type route struct {
+	path      string // relative to apiV1; a trailing slash matches a subtree
+	handler   func(http.ResponseWriter, *http.Request)
+	ops       []op
+	largeBody bool // the handler limits its own, possibly large, request body
+}
+
+// op describes one method of a route. query and body list parameter names
+// separated by spaces: a trailing "?" marks one optional, and a ":int",
+// ":bool", ":[]int", ":[]string" or ":[]object" suffix gives its type (default string).
+// bodyType, when set, describes the JSON body by reflection instead.
+type op struct {
+	method    string
+	path      string // overrides the route's path in the document
+	summary   string
+	auth      string
+	query     string
+	body      string
+	bodyType  interface{}
+	multipart bool
+}
+
+func (s *Server) routes() []route {
+	return []route{
+		{path: "/repos/create", handler: s.handleCreateRepo, ops: []op{
+			{method: "POST", summary: "Create a repository", body: "owner name"},
+		}},
+		{path: "/repos/delete", handler: s.handleDeleteRepo, ops: []op{
+			{method: "POST", summary: "Delete a repository and tell its replicas to do the same", auth: authToken, body: "owner name"},
+		}},
+		{path: "/repos/list", handler: s.handleListRepos, ops: []op{
+			{method: "GET", summary: "List repositories", query: "owner?"},
+		}},
+		{path: "/repos/batch", handler: s.handleBatch, ops: []op{
+			{method: "POST", summary: "Create repositories, set their visibility and add topics in one all-or-nothing batch", auth: authAdmin, body: "operations:[]object"},
+		}},
+		{path: "/repos/metadata", handler: s.handleMetadata, ops: []op{
+			{method: "GET", summary: "Get repository metadata, without webhook secrets or replica tokens", query: "owner name"},
+			{method: "POST", summary: "Set the repository metadata fields present in the body, except webhooks, replicas, visibility and allowed addresses", auth: authToken, query: "owner name", bodyType: storage.Metadata{}},
+		}},
+		{path: "/repos/visibility", handler: s.handleVisibility, ops: []op{
+			{method: "POST", summary: "Make a repository public or private", auth: authToken, body: "owner name private:bool confirm?"},
+		}},
+		{path: "/repos/allowed-ips", handler: s.handleAllowedIPs, ops: []op{
+			{method: "POST", summary: "Set the IPs and CIDR ranges a repository can be reached from; none lifts the limit", auth: authToken, body: "owner name allowed_ips:[]string"},
+		}},
+		{path: "/repos/upstream/sync", handler: s.handleUpstreamSync, ops: []op{
+			{method: "POST", summary: "Fetch a mirrored repository from its upstream now", auth: authToken, body: "owner name"},
+		}},
+		{path: "/repos/policy", handler: s.handlePolicy, ops: []op{
+			{method: "GET", summary: "Get the repository push policy", query: "owner name"},
+			{method: "POST", summary: "Set the repository push policy", auth: authToken, query: "owner name", bodyType: storage.Policy{}},
+		}},
+		{path: "/repos/webhooks", handler: s.handleWebhooks, ops: []op{
+			{method: "GET", summary: "List webhooks", auth: authToken, query: "owner name"},
+			{method: "POST", summary: "Add a webhook", auth: authToken, body: "owner name url secret? events?:[]string"},
+			{method: "DELETE", summary: "Remove a webhook", auth: authToken, query: "owner name id"},
+		}},
+		{path: "/repos/webhooks/deliveries", handler: s.handleWebhookDeliveries, ops: []op{
+			{method: "GET", summary: "List recent webhook delivery attempts", auth: authToken, query: "owner name hook? delivery?"},
+		}},
+		{path: "/repos/webhooks/redeliver", handler: s.handleWebhookRedeliver, ops: []op{
+			{method: "POST", summary: "Send a logged webhook delivery again", auth: authToken, body: "owner name delivery"},
+		}},
+		{path: "/repos/mirrors", handler: s.handleRepoMirrors, ops: []op{
+			{method: "GET", summary: "List where a repository can be cloned from", auth: authOptional, query: "owner name"},
+		}},
+		{path: "/repos/replicate", handler: s.handleReplicate, largeBody: true, ops: []op{
+			{method: "POST", summary: "Apply a signed bundle pushed by the origin", auth: authInstance, multipart: true,
+				body: "owner repo instance_id invitation_key metadata refs force? timestamp bundle_sha256 signature content_encoding? upload_id? bundle?:binary"},
+		}},
+		{path: "/repos/replicate/uploads", handler: s.handleCreateBundleUpload, ops: []op{
+			{method: "POST", summary: "Start a resumable bundle upload", auth: authInstance, body: "owner repo instance_id size:int sha256"},
+		}},
+		{path: "/repos/replicate/uploads/", handler: s.handleBundleUpload, largeBody: true, ops: []op{
+			{method: "GET", path: "/repos/replicate/uploads/{id}", summary: "Get a bundle upload's current offset", auth: authInstance},
+			{method: "PATCH", path: "/repos/replicate/uploads/{id}", summary: "Append a chunk at the Upload-Offset header", auth: authInstance},
+			{method: "DELETE", path: "/repos/replicate/uploads/{id}", summary: "Abort a bundle upload", auth: authInstance},
+		}},
+		{path: "/repos/replicate-metadata", handler: s.handleReplicateMetadata, ops: []op{
+			{method: "POST", summary: "Replace a replica's metadata with the origin's", auth: authInstance, bodyType: replicationMessage{}},
+		}},
+		{path: "/repos/replicate-delete", handler: s.handleReplicateDelete, ops: []op{
+			{method: "POST", summary: "Delete a replica whose origin was deleted", auth: authInstance, bodyType: replicationMessage{}},
+		}},
+		{path: "/repos/replicate-issues", handler: s.handleReplicateIssues, ops: []op{
+			{method: "POST", summary: "Replace a replica's issues with the origin's", auth: authInstance, bodyType: replicationMessage{}},
+		}},
+		{path: "/repos/register-replication", handler: s.handleRegisterReplication, ops: []op{
+			{method: "POST", summary: "Become a replica of another instance's repository", auth: authInstance,
+				body: "owner repo replica_url token origin_instance_id origin_public_key origin_url? invitation_key_hash? refs?:[]string allow_chain?:bool description? size?:int"},
+		}},
+		{path: "/repos/unsubscribe-replication", handler: s.handleUnsubscribeReplication, ops: []op{
+			{method: "POST", summary: "Stop pushing a repository to the replica that signed the request", auth: authInstance, bodyType: originNotice{}},
+		}},
+		{path: "/repos/accept-replication", handler: s.handleAcceptedReplication, ops: []op{
+			{method: "POST", summary: "Start pushing a repository to the replica whose admin accepted it", auth: authInstance, bodyType: originNotice{}},
+		}},
+		{path: "/repos/reregister-replication", handler: s.handleReregisterReplication, ops: []op{
+			{method: "POST", summary: "Point a replica at a restored origin", auth: authInstance, bodyType: recoveryRequest{}},
+		}},
+		{path: "/repos/recovery-export", handler: s.handleRecoveryExport, ops: []op{
+			{method: "POST", summary: "Export a replica as a bundle to rebuild its origin", auth: authInstance, bodyType: recoveryRequest{}},
+		}},
+		{path: "/repos/replication-status", handler: s.handleReplicationStatus, ops: []op{
+			{method: "GET", summary: "Show per-replica sync state and lag of your repositories, or every repository's as an admin", auth: authToken, query: "owner? name?"},
+		}},
+		{path: "/repos/replication-log", handler: s.handleReplicationLog, ops: []op{
+			{method: "GET", summary: "List recent pushes to a repository's replicas", auth: authOptional, query: "owner name replica?"},
+		}},
+		{path: "/repos/force-sync", handler: s.handleForceSync, ops: []op{
+			{method: "POST", summary: "Overwrite diverged refs on a repository's replicas", auth: authToken, body: "owner name"},
+		}},
+		{path: "/repos/releases", handler: s.handleReleases, ops: []op{
+			{method: "GET", summary: "List releases, or show one", auth: authOptional, query: "owner name tag?"},
+			{method: "POST", summary: "Create a release for a tag", auth: authToken, body: "owner name tag title? body? prerelease?:bool"},
+			{method: "DELETE", summary: "Delete a release and its assets", auth: authToken, query: "owner name tag"},
+		}},
+		{path: "/repos/releases/assets", handler: s.handleReleaseAssets, ops: []op{
+			{method: "DELETE", summary: "Delete a release asset", auth: authToken, query: "owner name tag asset"},
+		}},
+		{path: "/repos/releases/uploads", handler: s.handleCreateUpload, ops: []op{
+			{method: "POST", summary: "Start a resumable asset upload", auth: authToken, body: "owner name tag asset size:int sha256"},
+		}},
+		{path: "/repos/releases/uploads/", handler: s.handleUpload, largeBody: true, ops: []op{
+			{method: "GET", path: "/repos/releases/uploads/{id}", summary: "Get an upload's current offset", auth: authToken},
+			{method: "PATCH", path: "/repos/releases/uploads/{id}", summary: "Append a chunk at the Upload-Offset header", auth: authToken},
+			{method: "POST", path: "/repos/releases/uploads/{id}/complete", summary: "Verify an upload's checksum and publish it", auth: authToken},
+			{method: "DELETE", path: "/repos/releases/uploads/{id}", summary: "Abort an upload", auth: authToken},
+		}},
+		{path: "/repos/fork", handler: s.handleForkRepo, ops: []op{
+			{method: "POST", summary: "Fork a repository into your account", auth: authToken, body: "owner name fork_name?"},
+		}},
+		{path: "/repos/pulls", handler: s.handlePulls, ops: []op{
+			{method: "GET", summary: "List pull requests, or show one", auth: authOptional, query: "owner name state? number?:int"},
+			{method: "POST", summary: "Open a pull request", auth: authToken, body: "owner name title body? source target draft?:bool source_repo?"},
+		}},
+		{path: "/repos/pulls/draft", handler: s.handlePullDraft, ops: []op{
+			{method: "POST", summary: "Mark a pull request draft or ready", auth: authToken, body: "owner name number:int draft:bool"},
+		}},
+		{path: "/repos/pulls/state", handler: s.handlePullState, ops: []op{
+			{method: "POST", summary: "Close or reopen a pull request", auth: authToken, body: "owner name number:int state"},
+		}},
+		{path: "/repos/pulls/merge", handler: s.handleMergePull, ops: []op{
+			{method: "POST", summary: "Merge a pull request now", auth: authToken, body: "owner name number:int"},
+		}},
+		{path: "/repos/pulls/reviews", handler: s.handlePullReviews, ops: []op{
+			{method: "GET", summary: "List a pull request's reviews", auth: authOptional, query: "owner name number:int"},
+			{method: "POST", summary: "Review a pull request", auth: authToken, body: "owner name number:int state body? comments?:[]object"},
+		}},
+		{path: "/repos/pulls/suggestions", handler: s.handleApplySuggestion, ops: []op{
+			{method: "POST", summary: "Commit a review comment's suggestion", auth: authToken, body: "owner name number:int review_id:int comment:int message?"},
+		}},
+		{path: "/repos/statuses", handler: s.handleStatuses, ops: []op{
+			{method: "GET", summary: "Get a commit's statuses and combined state", auth: authOptional, query: "owner name ref"},
+			{method: "POST", summary: "Report a check's status on a commit", auth: authToken, body: "owner name sha state context? target_url? description?"},
+		}},
+		{path: "/repos/issues", handler: s.handleIssues, ops: []op{
+			{method: "GET", summary: "List issues, or show one", auth: authOptional, query: "owner name state? number?:int"},
+			{method: "POST", summary: "Open an issue", auth: authToken, body: "owner name title body?"},
+		}},
+		{path: "/repos/issues/comments", handler: s.handleIssueComments, ops: []op{
+			{method: "POST", summary: "Comment on an issue", auth: authToken, body: "owner name number:int body"},
+		}},
+		{path: "/repos/issues/state", handler: s.handleIssueState, ops: []op{
+			{method: "POST", summary: "Close or reopen an issue", auth: authToken, body: "owner name number:int state"},
+		}},
+		{path: "/repos/merge-queue", handler: s.handleMergeQueue, ops: []op{
+			{method: "GET", summary: "List a target branch's merge queue", auth: authOptional, query: "owner name target"},
+			{method: "POST", summary: "Queue a pull request for merging", auth: authToken, body: "owner name number:int"},
+			{method: "DELETE", summary: "Remove a pull request from the merge queue", auth: authToken, query: "owner name number:int"},
+		}},
+		{path: "/repos/stars", handler: s.handleStars, ops: []op{
+			{method: "GET", summary: "List who has starred a repository", auth: authOptional, query: "owner name"},
+			{method: "POST", summary: "Star or unstar a repository", auth: authToken, body: "owner name star:bool"},
+		}},
+		{path: "/repos/watchers", handler: s.handleWatchers, ops: []op{
+			{method: "GET", summary: "List who watches a repository", auth: authOptional, query: "owner name"},
+			{method: "POST", summary: "Watch or unwatch a repository's pushes and issues", auth: authToken, body: "owner name watch:bool"},
+		}},
+		{path: "/repos/refs", handler: s.handleRepoRefs, ops: []op{
+			{method: "GET", summary: "List a repository's branches and tags, with its clone URLs", auth: authOptional, query: "owner name"},
+		}},
+		{path: "/repos/tree", handler: s.handleTree, ops: []op{
+			{method: "GET", summary: "List a directory at a ref, by default the default branch", auth: authOptional, query: "owner name ref? path?"},
+		}},
+		{path: "/repos/blob", handler: s.handleBlob, ops: []op{
+			{method: "GET", summary: "Get a file at a ref: text highlighted or rendered, or base64-encoded; or with raw, the file itself", auth: authOptional, query: "owner name path ref? raw?:bool"},
+		}},
+		{path: "/repos/readme", handler: s.handleReadme, ops: []op{
+			{method: "GET", summary: "Render a directory's README at a ref", auth: authOptional, query: "owner name ref? path?"},
+		}},
+		{path: "/repos/commits", handler: s.handleCommits, ops: []op{
+			{method: "GET", summary: "List a ref's commits, newest first", auth: authOptional, query: "owner name ref? path? skip?:int limit?:int"},
+		}},
+		{path: "/repos/activity", handler: s.handleRepoActivity, ops: []op{
+			{method: "GET", summary: "Daily commit and review counts for a repository", auth: authOptional, query: "owner name days?:int"},
+		}},
+		{path: "/users/activity", handler: s.handleUserActivity, ops: []op{
+			{method: "GET", summary: "Daily commit and review counts for a user", auth: authToken, query: "username days?:int"},
+		}},
+		{path: "/users/quota", handler: s.handleUserQuota, ops: []op{
+			{method: "GET", summary: "Show your disk usage against your quota", auth: authToken},
+		}},
+		{path: "/users/", handler: s.handleUserProfile, ops: []op{
+			{method: "GET", path: "/users/{username}", summary: "Show a user's profile and the repositories you can see", auth: authOptional},
+			{method: "GET", path: "/users/{username}/avatar", summary: "Get a user's avatar image, or a redirect to their Gravatar", auth: authNone},
+		}},
+		{path: "/users/profile", handler: s.handleProfile, ops: []op{
+			{method: "GET", summary: "Show your profile", auth: authToken},
+			{method: "POST", summary: "Set your display name, bio or avatar", auth: authToken, body: "display_name? bio? avatar?"},
+		}},
+		{path: "/users/avatar", handler: s.handleAvatar, ops: []op{
+			{method: "PUT", summary: "Upload your avatar as a PNG, JPEG, GIF or WebP image body", auth: authToken},
+			{method: "DELETE", summary: "Remove your avatar", auth: authToken},
+		}},
+		{path: "/users/register", handler: s.handleRegister, ops: []op{
+			{method: "GET", summary: "Show whether registration is open, by invite or closed", auth: authNone},
+			{method: "POST", summary: "Create an account, returning an API token and recovery codes", auth: authNone, body: "username invite? ssh_key? token_name?"},
+		}},
+		{path: "/users/keys", handler: s.handleUserKeys, ops: []op{
+			{method: "GET", summary: "List your SSH keys, or a user's as an admin", auth: authToken, query: "username?"},
+			{method: "POST", summary: "Add an SSH key, optionally usable only from some IPs and CIDR ranges", auth: authToken, body: "username? name key allowed_ips?:[]string"},
+			{method: "PATCH", summary: "Set the IPs and CIDR ranges an SSH key may be used from; none lifts the limit", auth: authToken, body: "username? name allowed_ips:[]string"},
+			{method: "DELETE", summary: "Remove an SSH key", auth: authToken, query: "name username?"},
+		}},
+		{path: "/users/signing-keys", handler: s.handleUserSigningKeys, ops: []op{
+			{method: "GET", summary: "List your commit signing keys, or a user's as an admin", auth: authToken, query: "username?"},
+			{method: "POST", summary: "Add an SSH or armored OpenPGP key that verifies your signed commits and tags", auth: authToken, body: "username? name key"},
+			{method: "DELETE", summary: "Remove a signing key", auth: authToken, query: "name username?"},
+		}},
+		{path: "/users/tokens", handler: s.handleUserTokens, ops: []op{
+			{method: "GET", summary: "List your API tokens by name, or a user's as an admin", auth: authToken, query: "username?"},
+			{method: "POST", summary: "Generate an API token, shown once, optionally usable only from some IPs and CIDR ranges", auth: authToken, body: "username? name allowed_ips?:[]string"},
+			{method: "PATCH", summary: "Set the IPs and CIDR ranges an API token may be used from; none lifts the limit", auth: authToken, body: "username? name allowed_ips:[]string"},
+			{method: "DELETE", summary: "Revoke an API token", auth: authToken, query: "name username?"},
+		}},
+		{path: "/users/email", handler: s.handleUserEmail, ops: []op{
+			{method: "GET", summary: "Show your email address and whether notifications are emailed", auth: authToken},
+			{method: "POST", summary: "Set your email address or opt in or out of email notifications", auth: authToken, body: "email? notify?:bool"},
+		}},
+		{path: "/users/starred", handler: s.handleStarred, ops: []op{
+			{method: "GET", summary: "List the repositories you have starred", auth: authToken},
+		}},
+		{path: "/users/notifications", handler: s.handleNotifications, ops: []op{
+			{method: "GET", summary: "List your notifications, newest first", auth: authToken, query: "unread?:bool"},
+		}},
+		{path: "/users/notifications/read", handler: s.handleMarkNotificationsRead, ops: []op{
+			{method: "POST", summary: "Mark notifications read, or all of them without ids", auth: authToken, body: "ids?:[]int"},
+		}},
+		{path: "/users/recover", handler: s.handleRecoverAccount, ops: []op{
+			{method: "GET", summary: "Explain how to redeem a recovery code or invite", query: "user code"},
+			{method: "POST", summary: "Redeem a recovery code or invite for a new token", body: "username code ssh_key? token_name? revoke?:bool"},
+		}},
+		{path: "/admin/users", handler: s.handleAdminUsers, ops: []op{
+			{method: "GET", summary: "List user accounts", auth: authAdmin},
+			{method: "POST", summary: "Create a user, returning their recovery codes", auth: authAdmin, body: "username ssh_key?"},
+			{method: "DELETE", summary: "Delete a user, blocking on, transferring or deleting their repositories", auth: authAdmin, query: "username repos? to?"},
+		}},
+		{path: "/admin/invites", handler: s.handleAdminInvites, ops: []op{
+			{method: "GET", summary: "List unused registration invites", auth: authAdmin},
+			{method: "POST", summary: "Create a registration invite code, shown once", auth: authAdmin, body: "note? expires?"},
+			{method: "DELETE", summary: "Revoke a registration invite", auth: authAdmin, query: "id"},
+		}},
+		{path: "/admin/replicas", handler: s.handleAdminReplicas, ops: []op{
+			{method: "GET", summary: "List a repository's replicas with their tokens and invitation keys", auth: authAdmin, query: "owner name"},
+			{method: "POST", summary: "Handshake with an instance and make it a replica", auth: authAdmin, body: "owner name url refs?:[]string allow_chain?:bool invite?:bool"},
+			{method: "DELETE", summary: "Stop replicating a repository to a replica", auth: authAdmin, query: "owner name instance_id"},
+		}},
+		{path: "/admin/replicas/chain", handler: s.handleAdminReplicaChain, ops: []op{
+			{method: "POST", summary: "Let a replica replicate on to its own replicas, or stop it", auth: authAdmin, body: "owner name url allow:bool"},
+		}},
+		{path: "/admin/replicas/pause", handler: s.handleAdminReplicaPause, ops: []op{
+			{method: "POST", summary: "Pause or resume a replica, a repository's replicas or all replication", auth: authAdmin, body: "owner? name? url? paused:bool"},
+		}},
+		{path: "/admin/replicas/unsubscribe", handler: s.handleAdminUnsubscribe, ops: []op{
+			{method: "POST", summary: "Ask the origin of a repository replicated here to stop pushing it", auth: authAdmin, body: "owner name"},
+		}},
+		{path: "/admin/replication-requests", handler: s.handleAdminReplicationRequests, ops: []op{
+			{method: "GET", summary: "List origins' registrations and invitations waiting for approval", auth: authAdmin},
+			{method: "POST", summary: "Approve, accept or reject an origin's registration", auth: authAdmin, body: "id approve:bool invitation_key?"},
+		}},
+		{path: "/admin/logs", handler: s.handleLogs, ops: []op{
+			{method: "GET", summary: "Show or follow the server log", auth: authAdmin, query: "follow?:bool lines?:int filter?:[]string"},
+		}},
+		{path: "/admin/audit", handler: s.handleAuditLog, ops: []op{
+			{method: "GET", summary: "Query the audit log", auth: authAdmin, query: "actor? action? target? since? until? limit?:int"},
+		}},
+		{path: "/admin/diagnostics", handler: s.handleDiagnostics, ops: []op{
+			{method: "GET", summary: "Report goroutines, memory, queue depths and running git processes", auth: authAdmin},
+		}},
+		{path: "/admin/stats", handler: s.handleStats, ops: []op{
+			{method: "GET", summary: "Summarize repositories, users, disk use, replication backlog and recent pushes", auth: authAdmin},
+		}},
+		{path: "/admin/verify", handler: s.handleVerify, ops: []op{
+			{method: "GET", summary: "Run git fsck on repositories and report corruption", auth: authAdmin, query: "owner? name? workers?:int"},
+		}},
+		{path: "/events", handler: s.handleEvents, ops: []op{
+			{method: "GET", summary: "Stream instance events as server-sent events", auth: authToken, query: "kind?:[]string repo? since?:int"},
+		}},
+		{path: "/admin/topology", handler: s.handleTopology, ops: []op{
+			{method: "GET", summary: "Describe this instance's place in the federation", auth: authAdmin},
+		}},
+		{path: "/instance/users", handler: s.handleSyncUsers, largeBody: true, ops: []op{
+			{method: "POST", summary: "Mirror a primary instance's users onto this standby", auth: authInstance, body: "instance_id users:[]object"},
+		}},
+		{path: "/federation/handshake", handler: s.handleHandshake, ops: []op{
+			{method: "POST", summary: "Exchange signed identities with another instance", auth: authInstance, bodyType: instance.Hello{}},
+		}},
+		{path: "/federation/repos", handler: s.handleFederationRepos, ops: []op{
+			{method: "GET", summary: "List this instance's public repositories", auth: authInstance, query: "q?"},
+		}},
+		{path: "/openapi.json", handler: s.handleOpenAPI, ops: []op{
+			{method: "GET", summary: "This document"},
+		}},
+	}
+}
+
+// serveLegacy rewrites a request for an unversioned /api path onto apiV1,
+// flagging the response as deprecated and pointing at its replacement.
+func (s *Server) serveLegacy(w http.ResponseWriter, r *http.Request) {
+	path := apiV1 + strings.TrimPrefix(r.URL.Path, "/api")
+
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", "<"+path+`>; rel="successor-version"`)
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = path
+	r2.URL.RawPath = ""
+	s.mux.ServeHTTP(w, r2)
+}
@@ -1,61 +1,288 @@
 package server
 
 import (
-	"encoding/base64"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/activitypub"
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/events"
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/policy"
+	"github.com/jeremytregunna/openhub/internal/replication"
 	"github.com/jeremytregunna/openhub/internal/storage"
+	"github.com/jeremytregunna/openhub/internal/webhooks"
+	"github.com/jeremytregunna/openhub/internal/zstd"
+)
+
+const maxFormFieldSize = 1 << 20
+
+// Default request body limits, until SetBodyLimits changes them.
+const (
+	defaultMaxBodySize   = 10 << 20
+	defaultMaxBundleSize = 10 << 30
 )
 
 type Storage interface {
 	CreateRepo(owner, name string) error
 	DeleteRepo(owner, name string) error
+	MoveRepo(owner, name, newOwner, newName string) error
+	Fork(owner, name, newOwner, newName string) error
 	RepoExists(owner, name string) bool
 	RepoPath(owner, name string) string
 	ListRepos() ([]storage.Repo, error)
 	ListReposByOwner(owner string) ([]storage.Repo, error)
 	GetMetadata(owner, name string) (storage.Metadata, error)
 	SetMetadata(owner, name string, meta storage.Metadata) error
+	UpdateMetadata(owner, name string, fn func(*storage.Metadata) error) error
+	ListRefs(owner, name string) (map[string]string, error)
+	ResolveCommit(owner, name, rev string) (string, error)
+	ApplyReplicaBundle(owner, name, bundlePath string, patterns []string, force bool) (storage.BundleResult, error)
+	WriteBundle(owner, name string, w io.Writer) error
+	Diff(owner, name, base, head string, paths ...string) (string, error)
+	ReadFile(owner, name, rev, path string) ([]byte, error)
+	ListTree(owner, name, rev, path string) ([]storage.TreeEntry, error)
+	StatFile(owner, name, rev, path string) (storage.TreeEntry, error)
+	CopyFile(owner, name, rev, path string, w io.Writer) error
+	Log(owner, name, rev, path string, skip, limit int) ([]storage.Commit, error)
+	CountCommitsSince(owner, name string, patterns []string, since map[string]string) (int, error)
+	ReleaseAssetPath(owner, name, tag, asset string) string
+	ReleaseSize(owner, name, tag string) (int64, error)
+	RepoSize(owner, name string) (int64, error)
+	FsckRepos(repos []storage.Repo, workers int, each func(storage.FsckResult)) []storage.FsckResult
+	Size() (int64, error)
+	FetchUpstream(owner, name string, u storage.Upstream) error
 }
 
 type AuthStore interface {
 	TokenValidator
 	CreateUserWithToken(username, tokenName, token string) error
+	DeleteUser(username string) error
+	ListUsers() ([]auth.User, error)
+	PutUser(user *auth.User) error
+	AddSSHKey(username, name, key string) error
+	GenerateAPIToken(username, name string) (string, error)
+	GenerateRecoveryCodes(username string) ([]string, error)
+	CheckInvite(username, code string) (time.Time, error)
+	Recover(username, code string, revoke bool) (string, error)
+	GetUser(username string) (*auth.User, error)
+	SetEmail(username, email string) error
+	SetEmailOptOut(username string, optOut bool) error
+	UpdateProfile(username string, displayName, bio, avatar *string) error
+	SetAvatarImage(username string, data []byte) error
+	AvatarImage(username string) ([]byte, error)
+	CreateUser(username string) error
+	RemoveSSHKey(username, name string) error
+	AddSigningKey(username string, key auth.SigningKey) error
+	RemoveSigningKey(username, name string) error
+	RevokeAPIToken(username, name string) error
+	SetTokenAllowedIPs(username, name string, allowed []string) error
+	SetKeyAllowedIPs(username, name string, allowed []string) error
+	Register(username, invite string, requireInvite bool) (string, error)
+	CreateInviteCode(createdBy, note string, ttl time.Duration) (auth.InviteCode, string, error)
+	ListInviteCodes() ([]auth.InviteCode, error)
+	RevokeInviteCode(id string) error
+}
+
+type ReplicationQueue interface {
+	Queue(owner, repo string)
+	QueueForce(owner, repo string)
+	QueueMetadata(owner, repo string)
+	QueueIssues(owner, repo string)
+	QueueDelete(owner, repo string, replicas []storage.Replica)
+	Backlog() (queued, running int)
+	Log(owner, repo, replicaURL string) ([]replication.LogEntry, error)
+	Pause() error
+	Resume() error
+	Paused() time.Time
+}
+
+type PeerKeys interface {
+	Get(instanceID string) (instance.Peer, bool, error)
+	Pin(instanceID, publicKey string) error
+	Replace(instanceID, publicKey string) error
+	Record(peer instance.Peer) error
+	List() ([]instance.Peer, error)
 }
 
 type Server struct {
-	storage   Storage
-	authStore AuthStore
-	mux       *http.ServeMux
+	storage       Storage
+	authStore     AuthStore
+	replQueue     ReplicationQueue
+	uploads       UploadManager
+	bundleUploads BundleUploads
+	peers         PeerKeys
+	instance      *instance.Instance
+	pulls         PullStore
+	issues        IssueStore
+	releases      ReleaseStore
+	statuses      StatusStore
+	notifications NotificationStore
+	mergeQueue    MergeQueue
+	activity      ActivityTracker
+	events        EventPublisher
+	webhooks      WebhookPublisher
+	bus           EventBus
+	pushes        *pushCounter
+	auditLog      AuditLog
+	quotas        QuotaChecker
+	logs          LogSource
+	admins        map[string]bool
+	mux           *http.ServeMux
+
+	externalURL       string
+	inferURL          bool
+	sshPort           int
+	federationTLS     *tls.Config
+	requireClientCert bool
+	standbyOf         []string
+	registration      string
+	originPolicy      string
+	trustedOrigins    []string
+	replRequests      ReplicationRequests
+	maxBodySize       int64
+	maxBundleSize     int64
+	diagnostics       map[string]DiagnosticsSource
+	started           time.Time
+
+	// syncing holds the "owner/name" of mirrors being fetched from their
+	// upstream, so a sync requested by hand doesn't overlap a scheduled one.
+	syncingMu sync.Mutex
+	syncing   map[string]bool
+
+	// lags caches how far behind replicas are for replication status.
+	lags lagCache
+
+	// closing is closed by CloseStreams to end event and log streams.
+	closing   chan struct{}
+	closeOnce sync.Once
 }
 
-func New(storage Storage, authStore AuthStore) *Server {
+func New(storage Storage, authStore AuthStore, replQueue ReplicationQueue, uploads UploadManager, peers PeerKeys, pulls PullStore, mergeQueue MergeQueue, activity ActivityTracker) *Server {
 	s := &Server{
-		storage:   storage,
-		authStore: authStore,
-		mux:       http.NewServeMux(),
+		storage:       storage,
+		authStore:     authStore,
+		replQueue:     replQueue,
+		uploads:       uploads,
+		peers:         peers,
+		pulls:         pulls,
+		mergeQueue:    mergeQueue,
+		activity:      activity,
+		mux:           http.NewServeMux(),
+		externalURL:   "http://localhost:3000",
+		maxBodySize:   defaultMaxBodySize,
+		maxBundleSize: defaultMaxBundleSize,
+		diagnostics:   map[string]DiagnosticsSource{},
+		started:       time.Now(),
+		syncing:       map[string]bool{},
+		closing:       make(chan struct{}),
 	}
 
-	s.mux.HandleFunc("/api/repos/create", s.handleCreateRepo)
-	s.mux.HandleFunc("/api/repos/delete", s.handleDeleteRepo)
-	s.mux.HandleFunc("/api/repos/list", s.handleListRepos)
-	s.mux.HandleFunc("/api/repos/metadata", s.handleMetadata)
-	s.mux.HandleFunc("/api/repos/replicate", s.handleReplicate)
-	s.mux.HandleFunc("/api/repos/register-replication", s.handleRegisterReplication)
+	for _, rt := range s.routes() {
+		if rt.largeBody {
+			s.mux.HandleFunc(apiV1+rt.path, rt.handler)
+		} else {
+			s.mux.HandleFunc(apiV1+rt.path, s.limitBody(rt.handler))
+		}
+	}
+	s.mux.HandleFunc(githubAPI+"/", s.limitBody(s.handleGitHub))
 
 	return s
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, apiV1+"/") && !strings.HasPrefix(r.URL.Path, githubAPI+"/") {
+		s.serveLegacy(w, r)
+		return
+	}
 	s.mux.ServeHTTP(w, r)
 }
 
+// SetBodyLimits sets the largest request body, in bytes, an API request may
+// send, and the largest bundle a replica accepts from its origin. Zero
+// removes a limit.
+func (s *Server) SetBodyLimits(body, bundle int64) {
+	s.maxBodySize = body
+	s.maxBundleSize = bundle
+}
+
+// limitBody refuses a request whose body is larger than the API allows,
+// up front when it declares its length and otherwise once the handler has
+// read that much of it.
+func (s *Server) limitBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.maxBodySize > 0 {
+			if r.ContentLength > s.maxBodySize {
+				s.jsonError(w, fmt.Sprintf("request body larger than %d bytes", s.maxBodySize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+		}
+		next(w, r)
+	}
+}
+
+// CloseStreams ends the event and log streams clients are following, so
+// they don't hold up a graceful shutdown.
+func (s *Server) CloseStreams() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
+// SetExternalURL sets the canonical base URL used in generated clone URLs.
+func (s *Server) SetExternalURL(u string) {
+	s.externalURL = strings.TrimSuffix(u, "/")
+}
+
+// InferExternalURL builds the links in API responses from the host and
+// scheme each request was sent to, for when no external URL is configured.
+// Other instances are still given the external URL.
+func (s *Server) InferExternalURL() {
+	s.inferURL = true
+}
+
+// baseURL is the URL links in the response to r start with.
+func (s *Server) baseURL(r *http.Request) string {
+	if !s.inferURL || r.Host == "" {
+		return s.externalURL
+	}
+	return clientip.Scheme(r) + "://" + r.Host
+}
+
+// RequireClientCert restricts the instance-to-instance endpoints to requests
+// carrying a client certificate verified against the configured CA.
+func (s *Server) RequireClientCert() {
+	s.requireClientCert = true
+}
+
+func (s *Server) checkClientCert(w http.ResponseWriter, r *http.Request) bool {
+	if !s.requireClientCert {
+		return true
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		s.jsonError(w, "client certificate required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 type CreateRepoRequest struct {
 	Owner string `json:"owner"`
 	Name  string `json:"name"`
@@ -80,34 +307,58 @@ func (s *Server) handleCreateRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Owner == "" || req.Name == "" {
-		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+	if err := s.createRepo(requestActor(r, s.requestUser(r)), req.Owner, req.Name); err != nil {
+		s.opFailed(w, err)
 		return
 	}
 
-	if !isValidName(req.Owner) || !isValidName(req.Name) {
-		s.jsonError(w, "invalid owner or name", http.StatusBadRequest)
-		return
+	resp := CreateRepoResponse{
+		Success:  true,
+		RepoPath: fmt.Sprintf("%s/%s.git", req.Owner, req.Name),
+		CloneURL: fmt.Sprintf("%s/%s/%s.git", s.baseURL(r), req.Owner, req.Name),
 	}
 
-	if s.storage.RepoExists(req.Owner, req.Name) {
-		s.jsonError(w, "repository already exists", http.StatusConflict)
-		return
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// createRepo creates the empty repository owner/name for a, within the
+// owner's quota.
+func (s *Server) createRepo(a actor, owner, name string) error {
+	if owner == "" || name == "" {
+		return opErrorf(http.StatusBadRequest, "owner and name required")
 	}
 
-	if err := s.storage.CreateRepo(req.Owner, req.Name); err != nil {
-		s.jsonError(w, fmt.Sprintf("create failed: %v", err), http.StatusInternalServerError)
-		return
+	if !isValidName(owner) || !isValidName(name) {
+		return opErrorf(http.StatusBadRequest, "invalid owner or name")
 	}
 
-	resp := CreateRepoResponse{
-		Success:  true,
-		RepoPath: fmt.Sprintf("%s/%s.git", req.Owner, req.Name),
-		CloneURL: fmt.Sprintf("http://localhost:3000/%s/%s.git", req.Owner, req.Name),
+	if s.storage.RepoExists(owner, name) {
+		return opErrorf(http.StatusConflict, "repository already exists")
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	if err := s.quotaAllows(owner); err != nil {
+		return err
+	}
+
+	if err := s.storage.CreateRepo(owner, name); err != nil {
+		return fmt.Errorf("create failed: %w", err)
+	}
+
+	s.repoCreated(a, owner, name)
+	return nil
+}
+
+// repoCreated announces a new repository to followers and event
+// subscribers, and records that a created it.
+func (s *Server) repoCreated(a actor, owner, name string) {
+	if s.events != nil {
+		if err := s.events.Publish(activitypub.Event{Kind: activitypub.EventCreate, Owner: owner, Repo: name}); err != nil {
+			log.Printf("publish create of %s/%s failed: %v", owner, name, err)
+		}
+	}
+	s.publish(events.Event{Kind: events.KindRepoCreated, Owner: owner, Repo: name})
+	s.auditBy(a, audit.Entry{Action: "repo.create", Target: owner + "/" + name})
 }
 
 func (s *Server) jsonError(w http.ResponseWriter, msg string, status int) {
@@ -119,6 +370,32 @@ func (s *Server) jsonError(w http.ResponseWriter, msg string, status int) {
 	})
 }
 
+// opError is why an operation refused, with the HTTP status the API answers
+// with. The gRPC admin service maps the status to a code of its own.
+type opError struct {
+	status int
+	msg    string
+}
+
+func (e *opError) Error() string {
+	return e.msg
+}
+
+func opErrorf(status int, format string, args ...interface{}) error {
+	return &opError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// opFailed answers a request whose operation failed with err: with its
+// status for an opError, and as an internal error otherwise.
+func (s *Server) opFailed(w http.ResponseWriter, err error) {
+	var e *opError
+	if errors.As(err, &e) {
+		s.jsonError(w, e.msg, e.status)
+		return
+	}
+	s.jsonError(w, err.Error(), http.StatusInternalServerError)
+}
+
 func (s *Server) handleDeleteRepo(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -140,13 +417,13 @@ func (s *Server) handleDeleteRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.storage.RepoExists(req.Owner, req.Name) {
-		s.jsonError(w, "repository not found", http.StatusNotFound)
+	username, ok := s.checkRepoManager(w, r, req.Owner, req.Name, "delete a repository")
+	if !ok {
 		return
 	}
 
-	if err := s.storage.DeleteRepo(req.Owner, req.Name); err != nil {
-		s.jsonError(w, fmt.Sprintf("delete failed: %v", err), http.StatusInternalServerError)
+	if err := s.deleteRepo(requestActor(r, username), req.Owner, req.Name); err != nil {
+		s.opFailed(w, err)
 		return
 	}
 
@@ -156,6 +433,28 @@ func (s *Server) handleDeleteRepo(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// deleteRepo deletes owner/name for a and tells its replicas to do the same.
+func (s *Server) deleteRepo(a actor, owner, name string) error {
+	if !s.storage.RepoExists(owner, name) {
+		return opErrorf(http.StatusNotFound, "repository not found")
+	}
+
+	meta, err := s.storage.GetMetadata(owner, name)
+	if err != nil {
+		return fmt.Errorf("get metadata failed: %w", err)
+	}
+
+	if err := s.storage.DeleteRepo(owner, name); err != nil {
+		return fmt.Errorf("delete failed: %w", err)
+	}
+	s.auditBy(a, audit.Entry{Action: "repo.delete", Target: owner + "/" + name})
+
+	if len(meta.Replicas) > 0 && s.replQueue != nil {
+		s.replQueue.QueueDelete(owner, name, meta.Replicas)
+	}
+	return nil
+}
+
 func (s *Server) handleListRepos(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -182,6 +481,7 @@ func (s *Server) handleListRepos(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"repos":   repos,
+		"owners":  s.ownerProfiles(s.baseURL(r), repos),
 	})
 }
 
@@ -206,6 +506,30 @@ func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 			s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
 			return
 		}
+		if !clientip.Allowed(meta.AllowedIPs, clientip.FromRequest(r)) {
+			s.jsonError(w, "repository not found", http.StatusNotFound)
+			return
+		}
+		meta.Webhooks = webhooks.Redact(meta.Webhooks)
+		if meta.Upstream != nil {
+			upstream := *meta.Upstream
+			upstream.Token = ""
+			meta.Upstream = &upstream
+		}
+		// The tokens and invitation keys replicas are pushed with are
+		// only shown to admins, at /api/v1/admin/replicas.
+		replicas := make([]storage.Replica, len(meta.Replicas))
+		for i, rep := range meta.Replicas {
+			rep.Token = ""
+			rep.InvitationKey = ""
+			replicas[i] = rep
+		}
+		meta.Replicas = replicas
+		if meta.ReplicaOf != nil {
+			source := *meta.ReplicaOf
+			source.InvitationKey = ""
+			meta.ReplicaOf = &source
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -214,20 +538,182 @@ func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 		})
 
 	case "POST":
-		var meta storage.Metadata
-		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		username, ok := s.checkRepoManager(w, r, owner, name, "change a repository's metadata")
+		if !ok {
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.jsonError(w, "read body failed", http.StatusBadRequest)
+			return
+		}
+		if err := s.setMetadata(requestActor(r, username), owner, name, body); err != nil {
+			s.opFailed(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// setMetadata sets the fields of owner/name's metadata present in body, a
+// JSON storage.Metadata, for a. Only the fields present change, so a client
+// that leaves out replicas or the replica source can't drop them.
+func (s *Server) setMetadata(a actor, owner, name string, body []byte) error {
+	if !s.storage.RepoExists(owner, name) {
+		return opErrorf(http.StatusNotFound, "repository not found")
+	}
+
+	var fields map[string]json.RawMessage
+	var check storage.Metadata
+	if json.Unmarshal(body, &fields) != nil || json.Unmarshal(body, &check) != nil {
+		return opErrorf(http.StatusBadRequest, "invalid request body")
+	}
+
+	// Visibility and the allowed addresses have endpoints of their own,
+	// so a client writing back what it read can send them but not
+	// change them.
+	errVisibility := errors.New("change a repository's visibility with /api/v1/repos/visibility")
+	errAllowedIPs := errors.New("change a repository's allowed addresses with /api/v1/repos/allowed-ips")
+	var meta storage.Metadata
+	err := s.storage.UpdateMetadata(owner, name, func(m *storage.Metadata) error {
+		// Decode the body over a deep copy of the record: over m's own
+		// slices and pointers it would change m before the checks
+		// below compare the two.
+		current, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		meta = storage.Metadata{}
+		if err := json.Unmarshal(current, &meta); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(body, &meta); err != nil {
+			return err
+		}
+		if meta.Private != m.Private {
+			return errVisibility
+		}
+		if !slices.Equal(meta.AllowedIPs, m.AllowedIPs) {
+			return errAllowedIPs
+		}
+		// Webhooks are managed through /api/v1/repos/webhooks, and
+		// replicas through /api/v1/admin/replicas, and both carry
+		// secrets this endpoint never returns, so a read-modify-write
+		// here must not replace them. The upstream's token is never
+		// returned either, and its status is the sync's to record; the
+		// replica source is replication's.
+		meta.Webhooks = m.Webhooks
+		meta.Replicas = m.Replicas
+		meta.ReplicaOf = m.ReplicaOf
+		meta.Upstream = m.Upstream
+		*m = meta
+		return nil
+	})
+	if errors.Is(err, errVisibility) || errors.Is(err, errAllowedIPs) {
+		return opErrorf(http.StatusBadRequest, "%v", err)
+	}
+	if err != nil {
+		return fmt.Errorf("set metadata failed: %w", err)
+	}
+
+	changed := make([]string, 0, len(fields))
+	for field := range fields {
+		changed = append(changed, field)
+	}
+	sort.Strings(changed)
+	s.auditBy(a, audit.Entry{
+		Action: "repo.metadata",
+		Target: owner + "/" + name,
+		Detail: fmt.Sprintf("set %s; private=%t default_branch=%s replicas=%d", strings.Join(changed, ", "), meta.Private, meta.DefaultBranch, len(meta.Replicas)),
+	})
+
+	if len(meta.Replicas) > 0 && s.replQueue != nil {
+		s.replQueue.QueueMetadata(owner, name)
+	}
+	return nil
+}
+
+// handlePolicy returns a repository's push policy to anyone, and sets it
+// for the owner or an admin. An empty policy removes it.
+//
+//	GET  /api/v1/repos/policy?owner=..&name=..
+//	POST /api/v1/repos/policy?owner=..&name=..
+func (s *Server) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+
+	if owner == "" || name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.storage.RepoExists(owner, name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	meta, err := s.storage.GetMetadata(owner, name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"policy":  meta.Policy,
+		})
+
+	case "POST":
+		username, ok := s.checkRepoManager(w, r, owner, name, "change a repository's push policy")
+		if !ok {
+			return
+		}
+
+		var p storage.Policy
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
 			s.jsonError(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		if err := s.storage.SetMetadata(owner, name, meta); err != nil {
+		if err := policy.Validate(p); err != nil {
+			s.jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := s.storage.UpdateMetadata(owner, name, func(m *storage.Metadata) error {
+			if policy.IsEmpty(p) {
+				m.Policy = nil
+			} else {
+				m.Policy = &p
+			}
+			meta = *m
+			return nil
+		})
+		if err != nil {
 			s.jsonError(w, fmt.Sprintf("set metadata failed: %v", err), http.StatusInternalServerError)
 			return
 		}
+		s.audit(r, audit.Entry{Actor: username, Action: "repo.policy", Target: owner + "/" + name})
+
+		if len(meta.Replicas) > 0 && s.replQueue != nil {
+			s.replQueue.QueueMetadata(owner, name)
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": true,
+			"policy":  meta.Policy,
 		})
 
 	default:
@@ -241,36 +727,96 @@ func (s *Server) handleReplicate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		s.jsonError(w, "missing authorization", http.StatusUnauthorized)
+	if !s.checkClientCert(w, r) {
 		return
 	}
 
-	parts := strings.SplitN(authHeader, " ", 2)
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		s.jsonError(w, "invalid authorization header", http.StatusUnauthorized)
+	username, ok := s.bearerUser(w, r)
+	if !ok {
 		return
 	}
 
-	username, err := s.authStore.ValidateAPIToken(parts[1])
+	mr, err := r.MultipartReader()
 	if err != nil {
-		s.jsonError(w, "invalid token", http.StatusUnauthorized)
+		s.jsonError(w, "expected multipart bundle upload", http.StatusBadRequest)
 		return
 	}
 
 	var req struct {
-		Owner         string          `json:"owner"`
-		Repo          string          `json:"repo"`
-		InstanceID    string          `json:"instance_id"`
-		InvitationKey string          `json:"invitation_key"`
-		Bundle        string          `json:"bundle"`
-		Metadata      storage.Metadata `json:"metadata"`
+		Owner         string
+		Repo          string
+		InstanceID    string
+		InvitationKey string
+		Metadata      storage.Metadata
+		Refs          []string
+		Force         bool
+		Timestamp     string
+		BundleSHA256  string
+		Signature     string
 	}
+	var metaRaw, refsRaw []byte
+	// encoding is how the bundle is compressed, if at all. uploadID names
+	// a bundle uploaded in chunks beforehand, sent instead of a bundle part.
+	var encoding, uploadID string
+
+	// Form fields precede the bundle part, so everything can be validated
+	// before the (potentially very large) bundle is written to disk.
+	var bundlePart *multipart.Part
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.jsonError(w, "invalid request body", http.StatusBadRequest)
-		return
+		if part.FormName() == "bundle" {
+			bundlePart = part
+			break
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+		if err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		switch part.FormName() {
+		case "owner":
+			req.Owner = string(value)
+		case "repo":
+			req.Repo = string(value)
+		case "instance_id":
+			req.InstanceID = string(value)
+		case "invitation_key":
+			req.InvitationKey = string(value)
+		case "metadata":
+			metaRaw = value
+			if err := json.Unmarshal(value, &req.Metadata); err != nil {
+				s.jsonError(w, "invalid metadata", http.StatusBadRequest)
+				return
+			}
+		case "refs":
+			refsRaw = value
+			if err := json.Unmarshal(value, &req.Refs); err != nil {
+				s.jsonError(w, "invalid refs", http.StatusBadRequest)
+				return
+			}
+		case "force":
+			req.Force = string(value) == "true"
+		case "timestamp":
+			req.Timestamp = string(value)
+		case "bundle_sha256":
+			req.BundleSHA256 = string(value)
+		case "signature":
+			req.Signature = string(value)
+		case "content_encoding":
+			encoding = string(value)
+		case "upload_id":
+			uploadID = string(value)
+		}
 	}
 
 	if req.Owner == "" || req.Repo == "" || req.InstanceID == "" || req.InvitationKey == "" {
@@ -283,30 +829,54 @@ func (s *Server) handleReplicate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Bundle == "" {
+	if bundlePart == nil && uploadID == "" {
 		s.jsonError(w, "missing bundle data", http.StatusBadRequest)
 		return
 	}
 
-	if !strings.HasPrefix(username, "replication-") {
-		s.jsonError(w, "unauthorized: not a replication user", http.StatusForbidden)
+	if encoding != "" && (encoding != "zstd" || !zstd.Available()) {
+		s.jsonError(w, fmt.Sprintf("unsupported content_encoding: %s", encoding), http.StatusUnsupportedMediaType)
 		return
 	}
 
-	expectedUser := fmt.Sprintf("replication-%s-%s-%s", req.Owner, req.Repo, req.InstanceID)
-	if username != expectedUser {
-		s.jsonError(w, "unauthorized: token mismatch", http.StatusForbidden)
+	for _, ref := range req.Refs {
+		if !storage.ValidRefPattern(ref) {
+			s.jsonError(w, fmt.Sprintf("invalid ref pattern: %s", ref), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !s.checkReplicationUser(w, username, req.Owner, req.Repo, req.InstanceID) {
 		return
 	}
 
-	bundleData, err := base64.StdEncoding.DecodeString(req.Bundle)
-	if err != nil {
-		s.jsonError(w, fmt.Sprintf("decode bundle: %v", err), http.StatusBadRequest)
+	if !s.verifySignature(w, req.InstanceID, "replicate", req.Timestamp, req.Signature,
+		req.Owner, req.Repo, req.InstanceID, req.InvitationKey,
+		instance.Digest(metaRaw), instance.Digest(refsRaw), req.BundleSHA256, strconv.FormatBool(req.Force)) {
 		return
 	}
 
+	if uploadID != "" {
+		if s.bundleUploads == nil {
+			s.jsonError(w, "bundle uploads not enabled", http.StatusNotFound)
+			return
+		}
+		sess, err := s.bundleUploads.Get(uploadID)
+		if err != nil {
+			s.uploadError(w, err)
+			return
+		}
+		if sess.Owner != req.Owner || sess.Repo != req.Repo || sess.Instance != req.InstanceID {
+			s.jsonError(w, "upload not found", http.StatusNotFound)
+			return
+		}
+	}
+
 	repoExists := s.storage.RepoExists(req.Owner, req.Repo)
 
+	var downstream []storage.Replica
+	var hooks []storage.Webhook
+	var upstream *storage.Upstream
 	if repoExists {
 		existingMeta, err := s.storage.GetMetadata(req.Owner, req.Repo)
 		if err != nil {
@@ -319,10 +889,20 @@ func (s *Server) handleReplicate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if existingMeta.ReplicaOf.InvitationKey != req.InvitationKey {
+		// A replica only takes bundles from its own origin, whatever other
+		// instance was granted replication of a repo with the same name.
+		if existingMeta.ReplicaOf.InstanceID != req.InstanceID {
+			s.jsonError(w, "repository is not a replica of this origin", http.StatusForbidden)
+			return
+		}
+
+		if !invitationKeyMatches(existingMeta.ReplicaOf, req.InvitationKey) {
 			s.jsonError(w, "invalid invitation key", http.StatusForbidden)
 			return
 		}
+		downstream = existingMeta.Replicas
+		hooks = existingMeta.Webhooks
+		upstream = existingMeta.Upstream
 	} else {
 		if err := s.storage.CreateRepo(req.Owner, req.Repo); err != nil {
 			s.jsonError(w, fmt.Sprintf("create repo failed: %v", err), http.StatusInternalServerError)
@@ -330,35 +910,108 @@ func (s *Server) handleReplicate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	repoPath := s.storage.RepoPath(req.Owner, req.Repo)
-	bundlePath := filepath.Join(os.TempDir(), fmt.Sprintf("bundle-%s-%s.bundle", req.Owner, req.Repo))
+	var bundleData io.Reader = bundlePart
+	if uploadID != "" {
+		// Completing the upload checks the chunks add up to what the origin
+		// declared; the bundle itself is checked against the signed hash
+		// below, like one sent whole.
+		uploadPath := filepath.Join(s.storage.RepoPath(req.Owner, req.Repo), "replicate-"+uploadID+".bundle")
+		if _, err := s.bundleUploads.Complete(uploadID, uploadPath); err != nil {
+			s.uploadError(w, err)
+			return
+		}
+		defer os.Remove(uploadPath)
+
+		f, err := os.Open(uploadPath)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("open uploaded bundle: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		bundleData = f
+	}
+	if encoding == "zstd" {
+		// The size limit applies to the bundle once unpacked.
+		unpacked, err := zstd.Decompress(bundleData)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("decompress bundle: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer unpacked.Close()
+		bundleData = unpacked
+	}
 
-	if err := os.WriteFile(bundlePath, bundleData, 0600); err != nil {
+	bundlePath, bundleSHA, err := receiveBundle(bundleData, s.maxBundleSize)
+	if errors.Is(err, errBundleTooLarge) {
+		s.jsonError(w, fmt.Sprintf("bundle larger than %d bytes", s.maxBundleSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
 		s.jsonError(w, fmt.Sprintf("write bundle: %v", err), http.StatusInternalServerError)
 		return
 	}
 	defer os.Remove(bundlePath)
 
-	cmd := exec.Command("git", "fetch", bundlePath, "refs/*:refs/*")
-	cmd.Dir = repoPath
-	if output, err := cmd.CombinedOutput(); err != nil {
-		s.jsonError(w, fmt.Sprintf("git fetch failed: %v: %s", err, output), http.StatusInternalServerError)
+	if bundleSHA != req.BundleSHA256 {
+		s.jsonError(w, "bundle does not match signed hash", http.StatusForbidden)
+		return
+	}
+
+	result, err := s.storage.ApplyReplicaBundle(req.Owner, req.Repo, bundlePath, req.Refs, req.Force)
+	if errors.Is(err, storage.ErrInvalidBundle) {
+		s.jsonError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("apply bundle failed: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	for _, c := range result.Forced {
+		log.Printf("force-updated %s on %s/%s: %s -> %s", c.Ref, req.Owner, req.Repo, c.Old, c.New)
+	}
+
+	// The origin's consent to chaining arrives in the signed metadata; the
+	// replicas this instance chains to, its webhooks and any upstream it
+	// was mirroring before it became a replica are its own and are kept.
 	req.Metadata.ReplicaOf = &storage.ReplicaSource{
-		InstanceID:    req.InstanceID,
-		InvitationKey: req.InvitationKey,
+		InstanceID:        req.InstanceID,
+		InvitationKeyHash: auth.HashSecret(req.InvitationKey),
+		Refs:              req.Refs,
+		AllowChain:        req.Metadata.ReplicaOf != nil && req.Metadata.ReplicaOf.AllowChain,
 	}
+	req.Metadata.Replicas = downstream
+	req.Metadata.Webhooks = hooks
+	req.Metadata.Upstream = upstream
 
 	if err := s.storage.SetMetadata(req.Owner, req.Repo, req.Metadata); err != nil {
 		s.jsonError(w, fmt.Sprintf("set metadata failed: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	if len(downstream) > 0 && s.replQueue != nil {
+		s.replQueue.Queue(req.Owner, req.Repo)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+
+	// Fast-forwards are applied even when some refs diverged, so the replica
+	// stays as close to the origin as it safely can.
+	if len(result.Diverged) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  false,
+			"error":    "replica refs have diverged from origin",
+			"updated":  result.Updated,
+			"diverged": result.Diverged,
+		})
+		return
+	}
+
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
+		"updated": result.Updated,
+		"forced":  result.Forced,
 	})
 }
 
@@ -368,12 +1021,25 @@ func (s *Server) handleRegisterReplication(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !s.checkClientCert(w, r) {
+		return
+	}
+
 	var req struct {
 		Owner            string `json:"owner"`
 		Repo             string `json:"repo"`
 		ReplicaURL       string `json:"replica_url"`
 		Token            string `json:"token"`
 		OriginInstanceID string `json:"origin_instance_id"`
+		OriginPublicKey  string `json:"origin_public_key"`
+		// The rest describe what the origin will push, for an admin who
+		// has to approve or accept it.
+		OriginURL         string   `json:"origin_url"`
+		InvitationKeyHash string   `json:"invitation_key_hash"`
+		Refs              []string `json:"refs"`
+		AllowChain        bool     `json:"allow_chain"`
+		Description       string   `json:"description"`
+		Size              int64    `json:"size"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -381,8 +1047,13 @@ func (s *Server) handleRegisterReplication(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if req.Owner == "" || req.Repo == "" || req.Token == "" || req.OriginInstanceID == "" || req.ReplicaURL == "" {
-		s.jsonError(w, "owner, repo, token, origin_instance_id, and replica_url required", http.StatusBadRequest)
+	if req.Owner == "" || req.Repo == "" || req.Token == "" || req.OriginInstanceID == "" || req.ReplicaURL == "" || req.OriginPublicKey == "" {
+		s.jsonError(w, "owner, repo, token, origin_instance_id, origin_public_key, and replica_url required", http.StatusBadRequest)
+		return
+	}
+
+	if !instance.ValidPublicKey(req.OriginPublicKey) {
+		s.jsonError(w, "invalid origin_public_key", http.StatusBadRequest)
 		return
 	}
 
@@ -391,6 +1062,55 @@ func (s *Server) handleRegisterReplication(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	for _, ref := range req.Refs {
+		if !storage.ValidRefPattern(ref) {
+			s.jsonError(w, fmt.Sprintf("invalid ref pattern: %s", ref), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// An origin already replicating the repo here can register it again
+	// whatever the policy; its token stays as it was.
+	replicationUser := ReplicationUsername(req.Owner, req.Repo, req.OriginInstanceID)
+	_, err := s.authStore.GetUser(replicationUser)
+	trusted := err == nil || s.trustedOrigin(req.OriginInstanceID, req.OriginPublicKey)
+	if !trusted && s.originPolicy == OriginsAllowlist {
+		s.jsonError(w, "this instance only replicates from trusted origins", http.StatusForbidden)
+		return
+	}
+
+	pending := instance.ReplicationRequest{
+		Owner:             req.Owner,
+		Repo:              req.Repo,
+		OriginInstanceID:  req.OriginInstanceID,
+		OriginPublicKey:   req.OriginPublicKey,
+		OriginURL:         req.OriginURL,
+		TokenHash:         auth.HashSecret(req.Token),
+		InvitationKeyHash: req.InvitationKeyHash,
+		Refs:              req.Refs,
+		AllowChain:        req.AllowChain,
+		Description:       req.Description,
+		Size:              req.Size,
+	}
+
+	// An invited repository needn't exist here yet: accepting it creates
+	// it. It can already be a replica of the same origin, invited again.
+	if req.InvitationKeyHash != "" {
+		if s.storage.RepoExists(req.Owner, req.Repo) {
+			meta, err := s.storage.GetMetadata(req.Owner, req.Repo)
+			if err != nil {
+				s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if meta.ReplicaOf == nil || meta.ReplicaOf.InstanceID != req.OriginInstanceID {
+				s.jsonError(w, "a repository with this name already exists here", http.StatusConflict)
+				return
+			}
+		}
+		s.queueReplicationRequest(w, r, pending)
+		return
+	}
+
 	if !s.storage.RepoExists(req.Owner, req.Repo) {
 		s.jsonError(w, "repository not found", http.StatusNotFound)
 		return
@@ -407,7 +1127,15 @@ func (s *Server) handleRegisterReplication(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	replicationUser := fmt.Sprintf("replication-%s-%s-%s", req.Owner, req.Repo, req.OriginInstanceID)
+	if !trusted {
+		s.queueReplicationRequest(w, r, pending)
+		return
+	}
+
+	if err := s.peers.Pin(req.OriginInstanceID, req.OriginPublicKey); err != nil {
+		s.jsonError(w, fmt.Sprintf("register origin key failed: %v", err), http.StatusConflict)
+		return
+	}
 
 	if err := s.authStore.CreateUserWithToken(replicationUser, "replication", req.Token); err != nil {
 		if !strings.Contains(err.Error(), "already exists") {
@@ -415,10 +1143,270 @@ func (s *Server) handleRegisterReplication(w http.ResponseWriter, r *http.Reques
 			return
 		}
 	}
+	s.audit(r, audit.Entry{
+		Action: "replica.register",
+		Target: req.Owner + "/" + req.Repo,
+		Detail: "replicating from origin instance " + req.OriginInstanceID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleForceSync queues a sync that overwrites refs which have diverged on
+// the repo's replicas. Only the owner or an admin can.
+//
+//	POST /api/v1/repos/force-sync {"owner", "name"}
+func (s *Server) handleForceSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Owner string `json:"owner"`
+		Name  string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := s.checkRepoManager(w, r, req.Owner, req.Name, "force-sync a repository's replicas")
+	if !ok {
+		return
+	}
+
+	if err := s.forceSync(requestActor(r, username), req.Owner, req.Name); err != nil {
+		s.opFailed(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// forceSync queues a sync of owner/name for a that overwrites refs which
+// have diverged on its replicas.
+func (s *Server) forceSync(a actor, owner, name string) error {
+	if !s.storage.RepoExists(owner, name) {
+		return opErrorf(http.StatusNotFound, "repository not found")
+	}
+
+	meta, err := s.storage.GetMetadata(owner, name)
+	if err != nil {
+		return fmt.Errorf("get metadata failed: %w", err)
+	}
+
+	if meta.ReplicaOf != nil {
+		return opErrorf(http.StatusConflict, "cannot force-sync from a replica")
+	}
+
+	if len(meta.Replicas) == 0 || s.replQueue == nil {
+		return opErrorf(http.StatusConflict, "repository has no replicas")
+	}
+
+	s.replQueue.QueueForce(owner, name)
+	s.auditBy(a, audit.Entry{Action: "replica.force_sync", Target: owner + "/" + name})
+	return nil
+}
+
+var errBundleTooLarge = errors.New("bundle too large")
+
+// receiveBundle writes a replicated bundle to a temporary file, returning
+// its path and SHA-256. A bundle over limit bytes is discarded unless limit
+// is zero.
+func receiveBundle(part io.Reader, limit int64) (string, string, error) {
+	f, err := os.CreateTemp("", "bundle-*.bundle")
+	if err != nil {
+		return "", "", err
+	}
+
+	if limit > 0 {
+		part = io.LimitReader(part, limit+1)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), part)
+	if err == nil && limit > 0 && n > limit {
+		err = errBundleTooLarge
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", "", err
+	}
+
+	return f.Name(), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type ReplicaStatus struct {
+	InstanceID  string    `json:"instance_id"`
+	URL         string    `json:"url"`
+	Enabled     bool      `json:"enabled"`
+	Pending     bool      `json:"pending,omitempty"`
+	LastSynced  time.Time `json:"last_synced"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	LagCommits  *int      `json:"lag_commits"`
+}
+
+type RepoReplicationStatus struct {
+	Owner    string          `json:"owner"`
+	Name     string          `json:"name"`
+	Replicas []ReplicaStatus `json:"replicas"`
+}
+
+// handleReplicationStatus reports the sync state of the replicas of the
+// caller's repositories, or of every repository for an admin, and how many
+// commits each replica is behind. Lags are cached briefly; see lagTTL.
+//
+//	GET /api/v1/repos/replication-status[?owner=..[&name=..]]
+func (s *Server) handleReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+	admin := s.admins[username]
+
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+
+	var repos []storage.Repo
+	switch {
+	case owner != "" && name != "":
+		if !s.storage.RepoExists(owner, name) || (!admin && owner != username) {
+			s.jsonError(w, "repository not found", http.StatusNotFound)
+			return
+		}
+		repos = []storage.Repo{{Owner: owner, Name: name}}
+	case owner != "":
+		list, err := s.storage.ListReposByOwner(owner)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("list failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		repos = list
+	default:
+		list, err := s.storage.ListRepos()
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("list failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		repos = list
+	}
+
+	s.lags.prune()
+	counts := 0
+	statuses := []RepoReplicationStatus{}
+	for _, repo := range repos {
+		if !admin && repo.Owner != username {
+			continue
+		}
+		meta, err := s.storage.GetMetadata(repo.Owner, repo.Name)
+		if err != nil || len(meta.Replicas) == 0 {
+			continue
+		}
+		if !admin && !clientip.Allowed(meta.AllowedIPs, clientip.FromRequest(r)) {
+			continue
+		}
+
+		status := RepoReplicationStatus{Owner: repo.Owner, Name: repo.Name}
+		for _, replica := range meta.Replicas {
+			rs := ReplicaStatus{
+				InstanceID:  replica.InstanceID,
+				URL:         replica.URL,
+				Enabled:     replica.Enabled,
+				Pending:     replica.Pending,
+				LastSynced:  replica.LastSynced,
+				LastAttempt: replica.LastAttempt,
+				LastError:   replica.LastError,
+			}
+			if !replica.LastSynced.IsZero() {
+				key := repo.Owner + "/" + repo.Name + " " + replica.InstanceID
+				if lag, ok := s.lags.get(key, replica.LastSynced); ok {
+					rs.LagCommits = &lag
+				} else if counts < maxLagCounts {
+					counts++
+					if lag, err := s.storage.CountCommitsSince(repo.Owner, repo.Name, replica.Refs, replica.SyncedRefs); err == nil {
+						s.lags.put(key, replica.LastSynced, lag)
+						rs.LagCommits = &lag
+					}
+				}
+			}
+			status.Replicas = append(status.Replicas, rs)
+		}
+		statuses = append(statuses, status)
+	}
+
+	resp := map[string]interface{}{
+		"success": true,
+		"repos":   statuses,
+	}
+	if s.replQueue != nil {
+		if since := s.replQueue.Paused(); !since.IsZero() {
+			resp["paused_since"] = since
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleReplicationLog lists a repository's recent pushes to its replicas,
+// newest first, optionally only those to one replica.
+//
+//	GET /api/v1/repos/replication-log?owner=..&name=..[&replica=<url>]
+func (s *Server) handleReplicationLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+	if owner == "" || name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoRead(w, r, owner, name) {
+		return
+	}
+
+	entries := []replication.LogEntry{}
+	if s.replQueue != nil {
+		var err error
+		entries, err = s.replQueue.Log(owner, name, strings.TrimSuffix(r.URL.Query().Get("replica"), "/"))
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("read replication log failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
+		"entries": entries,
 	})
 }
 
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/adminpb"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testQueue records the deletions queued for replicas. Nothing else the
+// tests do reaches the rest of the queue.
+type testQueue struct {
+	ReplicationQueue
+	deleted []string
+}
+
+func (q *testQueue) QueueDelete(owner, repo string, replicas []storage.Replica) {
+	q.deleted = append(q.deleted, owner+"/"+repo)
+}
+
+type testServer struct {
+	*Server
+	store  *storage.Storage
+	queue  *testQueue
+	tokens map[string]string
+}
+
+// newTestServer serves alice's repository alice/proj, which has a replica,
+// to alice, bob and carol, who is an admin.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	store, err := storage.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authStore, err := auth.NewAuthStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testServer{store: store, queue: &testQueue{}, tokens: map[string]string{}}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := authStore.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+		token, err := authStore.GenerateAPIToken(user, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.tokens[user] = token
+	}
+
+	if err := store.CreateRepo("alice", "proj"); err != nil {
+		t.Fatal(err)
+	}
+	err = store.UpdateMetadata("alice", "proj", func(m *storage.Metadata) error {
+		m.Replicas = []storage.Replica{{InstanceID: "replica", URL: "http://replica.example.com", Enabled: true}}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.Server = New(store, authStore, ts.queue, nil, nil, nil, nil, nil)
+	ts.SetAdmins([]string{"carol"})
+	return ts
+}
+
+// post sends body to path as user, or anonymously if user is empty.
+func (ts *testServer) post(path, user, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		r.Header.Set("Authorization", "Bearer "+ts.tokens[user])
+	}
+	w := httptest.NewRecorder()
+	ts.ServeHTTP(w, r)
+	return w
+}
+
+func TestDeleteRepoAuthorize(t *testing.T) {
+	tests := []struct {
+		user string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"bob", http.StatusForbidden},
+		{"alice", http.StatusOK},
+		{"carol", http.StatusOK},
+	}
+	for _, tt := range tests {
+		ts := newTestServer(t)
+
+		w := ts.post("/api/v1/repos/delete", tt.user, `{"owner":"alice","name":"proj"}`)
+		if w.Code != tt.want {
+			t.Errorf("delete as %q: got %d, want %d: %s", tt.user, w.Code, tt.want, w.Body)
+		}
+
+		deleted := tt.want == http.StatusOK
+		if exists := ts.store.RepoExists("alice", "proj"); exists == deleted {
+			t.Errorf("delete as %q: repository exists %v, want %v", tt.user, exists, !deleted)
+		}
+		if queued := len(ts.queue.deleted) > 0; queued != deleted {
+			t.Errorf("delete as %q: queued deletions %v, want queued %v", tt.user, ts.queue.deleted, deleted)
+		}
+	}
+}
+
+// The unversioned path every release serves goes through the same check.
+func TestDeleteRepoLegacyPath(t *testing.T) {
+	ts := newTestServer(t)
+
+	if w := ts.post("/api/repos/delete", "", `{"owner":"alice","name":"proj"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous delete: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if !ts.store.RepoExists("alice", "proj") || len(ts.queue.deleted) > 0 {
+		t.Errorf("anonymous delete went ahead: exists %v, queued %v", ts.store.RepoExists("alice", "proj"), ts.queue.deleted)
+	}
+}
+
+// The gRPC admin service deletes through the same operation as the API.
+func TestAdminRPCDeleteRepo(t *testing.T) {
+	ts := newTestServer(t)
+	rpc := &adminService{s: ts.Server}
+	ctx := context.WithValue(context.Background(), rpcActorKey{}, actor{user: "carol", client: "127.0.0.1"})
+
+	if _, err := rpc.DeleteRepo(ctx, &adminpb.RepoRef{Owner: "alice", Name: "proj"}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if ts.store.RepoExists("alice", "proj") || len(ts.queue.deleted) != 1 {
+		t.Errorf("after delete: exists %v, queued %v", ts.store.RepoExists("alice", "proj"), ts.queue.deleted)
+	}
+
+	_, err := rpc.DeleteRepo(ctx, &adminpb.RepoRef{Owner: "alice", Name: "proj"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("delete again: got %v, want %v", err, codes.NotFound)
+	}
+}
+
+// A replica takes bundles only from its own origin, even from another
+// instance that signs properly, holds a replication token for the repo and
+// knows its invitation key.
+func TestReplicateOtherOrigin(t *testing.T) {
+	ts := newTestServer(t)
+	const key = "invitation-key"
+	if err := ts.store.CreateRepo("alice", "mirror"); err != nil {
+		t.Fatal(err)
+	}
+	err := ts.store.UpdateMetadata("alice", "mirror", func(m *storage.Metadata) error {
+		m.ReplicaOf = &storage.ReplicaSource{InstanceID: "origin", InvitationKeyHash: auth.HashSecret(key)}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	other, err := instance.LoadOrCreate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := instance.NewPeerStore(dir)
+	if err := peers.Pin(other.ID, other.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	ts.peers = peers
+	replicationUser := ReplicationUsername("alice", "mirror", other.ID)
+	if err := ts.authStore.CreateUser(replicationUser); err != nil {
+		t.Fatal(err)
+	}
+	token, err := ts.authStore.GenerateAPIToken(replicationUser, "replication")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := []byte("not really a bundle")
+	metaRaw, refsRaw := []byte("{}"), []byte("[]")
+	now := time.Now().Unix()
+	signature := other.Sign(instance.SignatureMessage("replicate", now,
+		"alice", "mirror", other.ID, key, instance.Digest(metaRaw), instance.Digest(refsRaw), instance.Digest(bundle), "false"))
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, f := range [][2]string{
+		{"owner", "alice"}, {"repo", "mirror"}, {"instance_id", other.ID}, {"invitation_key", key},
+		{"metadata", string(metaRaw)}, {"refs", string(refsRaw)}, {"force", "false"},
+		{"timestamp", strconv.FormatInt(now, 10)}, {"bundle_sha256", instance.Digest(bundle)}, {"signature", signature},
+	} {
+		mw.WriteField(f[0], f[1])
+	}
+	part, _ := mw.CreateFormFile("bundle", "bundle")
+	part.Write(bundle)
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/api/v1/repos/replicate", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	ts.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("replicate from another origin: got %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+
+	meta, err := ts.store.GetMetadata("alice", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ReplicaOf == nil || meta.ReplicaOf.InstanceID != "origin" {
+		t.Errorf("replica source changed to %+v", meta.ReplicaOf)
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/signing"
+)
+
+// verifySignatures checks the signatures of the commits and tags named by
+// shas in owner/name against every user's signing keys.
+func (s *Server) verifySignatures(owner, name string, shas []string) ([]signing.Verification, error) {
+	if len(shas) == 0 {
+		return nil, nil
+	}
+	users, err := s.authStore.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	return signing.NewVerifier(users).VerifyObjects(s.storage.RepoPath(owner, name), shas)
+}
+
+// handleUserSigningKeys lists, adds and removes the keys the caller signs
+// commits and tags with. A key can only belong to one user, so nobody can
+// claim signatures made with someone else's. Admins can manage another
+// user's keys by naming them.
+//
+//	GET    /api/v1/users/signing-keys[?username=..]
+//	POST   /api/v1/users/signing-keys {"username", "name", "key"}
+//	DELETE /api/v1/users/signing-keys?name=..[&username=..]
+func (s *Server) handleUserSigningKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		_, username, ok := s.accountUser(w, r, r.URL.Query().Get("username"))
+		if !ok {
+			return
+		}
+
+		user, err := s.authStore.GetUser(username)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("get user failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		keys := user.SigningKeys
+		if keys == nil {
+			keys = []auth.SigningKey{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"keys":    keys,
+		})
+
+	case "POST":
+		var req struct {
+			Username string `json:"username"`
+			Name     string `json:"name"`
+			Key      string `json:"key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		caller, username, ok := s.accountUser(w, r, req.Username)
+		if !ok {
+			return
+		}
+
+		req.Key = strings.TrimSpace(req.Key)
+		if req.Name == "" || req.Key == "" {
+			s.jsonError(w, "name and key required", http.StatusBadRequest)
+			return
+		}
+		kind, fingerprint, err := signing.ParseKey(req.Key)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("invalid key: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		users, err := s.authStore.ListUsers()
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("list users failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, u := range users {
+			for _, k := range u.SigningKeys {
+				switch {
+				case u.Username == username && k.Name == req.Name:
+					s.jsonError(w, "a signing key with that name already exists", http.StatusConflict)
+					return
+				case k.Fingerprint == fingerprint:
+					s.jsonError(w, "that signing key is already registered", http.StatusConflict)
+					return
+				}
+			}
+		}
+
+		key := auth.SigningKey{Name: req.Name, Kind: kind, Key: req.Key, Fingerprint: fingerprint}
+		if err := s.authStore.AddSigningKey(username, key); err != nil {
+			s.jsonError(w, fmt.Sprintf("add signing key failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: caller, Action: "signing-key.add", Target: username, Detail: req.Name + " " + fingerprint})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"kind":        kind,
+			"fingerprint": fingerprint,
+		})
+
+	case "DELETE":
+		caller, username, ok := s.accountUser(w, r, r.URL.Query().Get("username"))
+		if !ok {
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			s.jsonError(w, "name required", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.authStore.RemoveSigningKey(username, name); err != nil {
+			s.jsonError(w, fmt.Sprintf("remove signing key failed: %v", err), http.StatusNotFound)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: caller, Action: "signing-key.remove", Target: username, Detail: name})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
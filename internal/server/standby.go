@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"github.com/jeremytregunna/openhub/internal/instance"
+)
+
+const maxUserSnapshotSize = 64 << 20
+
+// SetStandbyOf allows the listed instances to replace this instance's users
+// with their own, making it a hot standby for them.
+func (s *Server) SetStandbyOf(instanceIDs []string) {
+	s.standbyOf = instanceIDs
+}
+
+// handleSyncUsers mirrors the account snapshot sent by a primary instance.
+// Local users missing from the snapshot are removed; per-repo replication
+// accounts are never touched.
+func (s *Server) handleSyncUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkClientCert(w, r) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxUserSnapshotSize))
+	if err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		InstanceID string      `json:"instance_id"`
+		Users      []auth.User `json:"users"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	accepted := false
+	for _, id := range s.standbyOf {
+		if id == req.InstanceID {
+			accepted = true
+			break
+		}
+	}
+	if !accepted {
+		s.jsonError(w, "this instance is not a standby for the sender", http.StatusForbidden)
+		return
+	}
+
+	if !s.verifySignature(w, req.InstanceID, "sync-users", r.Header.Get("X-OpenHub-Timestamp"),
+		r.Header.Get("X-OpenHub-Signature"), instance.Digest(body)) {
+		return
+	}
+
+	incoming := make(map[string]bool, len(req.Users))
+	for i := range req.Users {
+		u := &req.Users[i]
+		if !isValidName(u.Username) || strings.HasPrefix(u.Username, "replication-") {
+			s.jsonError(w, fmt.Sprintf("invalid username in snapshot: %s", u.Username), http.StatusBadRequest)
+			return
+		}
+		incoming[u.Username] = true
+	}
+
+	for i := range req.Users {
+		if err := s.authStore.PutUser(&req.Users[i]); err != nil {
+			s.jsonError(w, fmt.Sprintf("store user failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	existing, err := s.authStore.ListUsers()
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list users failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	removed := 0
+	for _, u := range existing {
+		if incoming[u.Username] || strings.HasPrefix(u.Username, "replication-") {
+			continue
+		}
+		if err := s.authStore.DeleteUser(u.Username); err != nil {
+			s.jsonError(w, fmt.Sprintf("delete user failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		removed++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"synced":  len(req.Users),
+		"removed": removed,
+	})
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/events"
+)
+
+// pushWindow is how far back the push counter remembers.
+const pushWindow = 24 * time.Hour
+
+// pushCounter counts the refs pushed, as seen on the event bus, in
+// one-minute buckets over the last pushWindow.
+type pushCounter struct {
+	mu      sync.Mutex
+	since   time.Time
+	minutes [int(pushWindow / time.Minute)]int64 // the minute each bucket counts
+	counts  [int(pushWindow / time.Minute)]int
+}
+
+func newPushCounter(bus EventBus) *pushCounter {
+	c := &pushCounter{since: time.Now()}
+	backlog, ch, _ := bus.Subscribe(events.Filter{Kinds: []string{events.KindPush}}, 0)
+	for _, e := range backlog {
+		c.add(e.Time)
+	}
+	go func() {
+		for e := range ch {
+			c.add(e.Time)
+		}
+	}()
+	return c
+}
+
+func (c *pushCounter) add(t time.Time) {
+	minute := t.Unix() / 60
+	i := minute % int64(len(c.counts))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.Before(c.since) {
+		c.since = t
+	}
+	if c.minutes[i] != minute {
+		c.minutes[i] = minute
+		c.counts[i] = 0
+	}
+	c.counts[i]++
+}
+
+// count returns the refs pushed in the last d.
+func (c *pushCounter) count(d time.Duration) int {
+	now := time.Now().Unix() / 60
+	oldest := now - int64(d/time.Minute)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0
+	for i, minute := range c.minutes {
+		if minute > oldest && minute <= now {
+			total += c.counts[i]
+		}
+	}
+	return total
+}
+
+// handleStats summarizes the instance for operators: repositories, users,
+// disk use, replication backlog and recent pushes. Pushes are counted from
+// the event bus, so they only go back to when the server started, which
+// "pushes.since" says, and at most a day.
+//
+//	GET /api/v1/admin/stats
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+
+	repos, err := s.storage.ListRepos()
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list repos failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var private, replicasHere, replicated, replicas, failing, neverSynced int
+	for _, repo := range repos {
+		meta, err := s.storage.GetMetadata(repo.Owner, repo.Name)
+		if err != nil {
+			continue
+		}
+		if meta.Private {
+			private++
+		}
+		if meta.ReplicaOf != nil {
+			replicasHere++
+		}
+		if len(meta.Replicas) > 0 {
+			replicated++
+		}
+		for _, rep := range meta.Replicas {
+			if !rep.Enabled {
+				continue
+			}
+			replicas++
+			switch {
+			case rep.LastError != "":
+				failing++
+			case rep.LastSynced.IsZero():
+				neverSynced++
+			}
+		}
+	}
+
+	users, err := s.authStore.ListUsers()
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list users failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	accounts := 0
+	for _, u := range users {
+		if !strings.HasPrefix(u.Username, "replication-") {
+			accounts++
+		}
+	}
+
+	size, err := s.storage.Size()
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	replication := map[string]interface{}{
+		"replicated_repos": replicated,
+		"replicas":         replicas,
+		"failing":          failing,
+		"never_synced":     neverSynced,
+	}
+	if s.replQueue != nil {
+		queued, running := s.replQueue.Backlog()
+		replication["queued"] = queued
+		replication["running"] = running
+	}
+
+	resp := map[string]interface{}{
+		"success": true,
+		"repos": map[string]int{
+			"total":    len(repos),
+			"private":  private,
+			"replicas": replicasHere,
+		},
+		"users":          accounts,
+		"disk_bytes":     size,
+		"replication":    replication,
+		"uptime_seconds": int64(time.Since(s.started).Seconds()),
+	}
+	if s.pushes != nil {
+		s.pushes.mu.Lock()
+		since := s.pushes.since
+		s.pushes.mu.Unlock()
+		if dayAgo := time.Now().Add(-pushWindow); since.Before(dayAgo) {
+			since = dayAgo
+		}
+		resp["pushes"] = map[string]interface{}{
+			"last_hour": s.pushes.count(time.Hour),
+			"last_day":  s.pushes.count(pushWindow),
+			"since":     since,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
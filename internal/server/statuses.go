@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/jeremytregunna/openhub/internal/statuses"
+)
+
+type StatusStore interface {
+	Set(owner, repo, commit string, status statuses.Status) (statuses.Status, error)
+	List(owner, repo, commit string) ([]statuses.Status, error)
+}
+
+// SetStatuses enables the commit status API.
+func (s *Server) SetStatuses(store StatusStore) {
+	s.statuses = store
+}
+
+// handleStatuses records and reports commit statuses. CI systems post with
+// the repository owner's token; anyone who can read the repository can query
+// the statuses of a commit, named by SHA or by a branch or tag. The response
+// also combines them into one state: failure if any failed, pending if any
+// is pending, otherwise success.
+//
+//	GET  /api/v1/repos/statuses?owner=..&name=..&ref=..
+//	POST /api/v1/repos/statuses {"owner", "name", "sha", "state", "context", "target_url", "description"}
+func (s *Server) handleStatuses(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		ref := r.URL.Query().Get("ref")
+		if owner == "" || name == "" || ref == "" {
+			s.jsonError(w, "owner, name and ref required", http.StatusBadRequest)
+			return
+		}
+
+		if s.statuses == nil {
+			s.jsonError(w, "commit statuses are not enabled on this instance", http.StatusNotFound)
+			return
+		}
+
+		if !s.checkRepoRead(w, r, owner, name) {
+			return
+		}
+
+		commit, err := s.storage.ResolveCommit(owner, name, ref)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("unknown ref: %s", ref), http.StatusNotFound)
+			return
+		}
+
+		list, err := s.statuses.List(owner, name, commit)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("list statuses failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []statuses.Status{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"sha":      commit,
+			"state":    statuses.Combined(list),
+			"statuses": list,
+		})
+
+	case "POST":
+		username, ok := s.bearerUser(w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Owner       string `json:"owner"`
+			Name        string `json:"name"`
+			SHA         string `json:"sha"`
+			State       string `json:"state"`
+			Context     string `json:"context"`
+			TargetURL   string `json:"target_url"`
+			Description string `json:"description"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Owner == "" || req.Name == "" || req.SHA == "" {
+			s.jsonError(w, "owner, name and sha required", http.StatusBadRequest)
+			return
+		}
+
+		if !statuses.ValidState(req.State) {
+			s.jsonError(w, "state must be pending, success or failure", http.StatusBadRequest)
+			return
+		}
+
+		if req.Context == "" {
+			req.Context = statuses.DefaultContext
+		}
+		if len(req.Context) > statuses.MaxContextLength || len(req.Description) > statuses.MaxDescriptionLength {
+			s.jsonError(w, fmt.Sprintf("context is limited to %d bytes and description to %d", statuses.MaxContextLength, statuses.MaxDescriptionLength), http.StatusBadRequest)
+			return
+		}
+
+		if req.TargetURL != "" {
+			u, err := url.Parse(req.TargetURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				s.jsonError(w, "target_url must be an http or https URL", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if s.statuses == nil {
+			s.jsonError(w, "commit statuses are not enabled on this instance", http.StatusNotFound)
+			return
+		}
+
+		if !s.storage.RepoExists(req.Owner, req.Name) {
+			s.jsonError(w, "repository not found", http.StatusNotFound)
+			return
+		}
+
+		if username != req.Owner {
+			s.jsonError(w, "only the owner can post statuses", http.StatusForbidden)
+			return
+		}
+
+		meta, err := s.storage.GetMetadata(req.Owner, req.Name)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if meta.ReplicaOf != nil {
+			s.jsonError(w, "cannot post statuses to a read-only replica", http.StatusForbidden)
+			return
+		}
+
+		commit, err := s.storage.ResolveCommit(req.Owner, req.Name, req.SHA)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("unknown commit: %s", req.SHA), http.StatusUnprocessableEntity)
+			return
+		}
+
+		status, err := s.statuses.Set(req.Owner, req.Name, commit, statuses.Status{
+			Context:     req.Context,
+			State:       req.State,
+			TargetURL:   req.TargetURL,
+			Description: req.Description,
+			Creator:     username,
+		})
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("set status failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"sha":     commit,
+			"status":  status,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
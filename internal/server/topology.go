@@ -0,0 +1,308 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// topologyStaleAfter is how long a replica can go without a successful sync
+// before the topology reports it stale. It comfortably exceeds the default
+// periodic sync interval.
+const topologyStaleAfter = time.Hour
+
+// Link health, from best to worst.
+const (
+	healthOK       = "ok"
+	healthPending  = "pending"
+	healthStale    = "stale"
+	healthFailing  = "failing"
+	healthDisabled = "disabled"
+	healthUnknown  = "unknown"
+)
+
+var healthRank = map[string]int{
+	healthOK:       0,
+	healthUnknown:  1,
+	healthPending:  2,
+	healthStale:    3,
+	healthFailing:  4,
+	healthDisabled: 5,
+}
+
+// TopologyNode is an instance in the federation graph. ID is the instance ID
+// when known, otherwise "url:" followed by the replica URL.
+type TopologyNode struct {
+	ID            string     `json:"id"`
+	URL           string     `json:"url,omitempty"`
+	Roles         []string   `json:"roles"`
+	Trusted       bool       `json:"trusted"`
+	Capabilities  []string   `json:"capabilities,omitempty"`
+	LastHandshake *time.Time `json:"last_handshake,omitempty"`
+	// Health is the worst health of the edges touching the node.
+	Health string `json:"health"`
+}
+
+// TopologyEdge is a flow of data between two nodes: "replicates" for repos
+// pushed from From to To, "standby" for accounts mirrored from a primary.
+type TopologyEdge struct {
+	From       string     `json:"from"`
+	To         string     `json:"to"`
+	Kind       string     `json:"kind"`
+	Repos      []string   `json:"repos,omitempty"`
+	Health     string     `json:"health"`
+	LastSynced *time.Time `json:"last_synced,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// TopologyRisk flags a repository that depends on a single instance.
+type TopologyRisk struct {
+	Repo    string `json:"repo"`
+	Kind    string `json:"kind"`
+	Node    string `json:"node,omitempty"`
+	Message string `json:"message"`
+}
+
+type Topology struct {
+	Self  string          `json:"self"`
+	Nodes []*TopologyNode `json:"nodes"`
+	Edges []*TopologyEdge `json:"edges"`
+	Risks []TopologyRisk  `json:"risks"`
+}
+
+// replicaHealth judges one replica from the origin's sync bookkeeping.
+func replicaHealth(r storage.Replica, now time.Time) string {
+	switch {
+	case !r.Enabled:
+		return healthDisabled
+	case r.LastError != "" && r.LastAttempt.After(r.LastSynced):
+		return healthFailing
+	case r.LastSynced.IsZero():
+		return healthPending
+	case now.Sub(r.LastSynced) > topologyStaleAfter:
+		return healthStale
+	}
+	return healthOK
+}
+
+func worseHealth(a, b string) string {
+	if healthRank[b] > healthRank[a] {
+		return b
+	}
+	return a
+}
+
+type topologyBuilder struct {
+	t     Topology
+	nodes map[string]*TopologyNode
+	edges map[[3]string]*TopologyEdge
+	peers []instance.Peer
+}
+
+func (b *topologyBuilder) node(id, url, role string) *TopologyNode {
+	n, ok := b.nodes[id]
+	if !ok {
+		n = &TopologyNode{ID: id, URL: url, Health: healthOK}
+		b.nodes[id] = n
+		b.t.Nodes = append(b.t.Nodes, n)
+	}
+	if n.URL == "" {
+		n.URL = url
+	}
+	for _, r := range n.Roles {
+		if r == role {
+			return n
+		}
+	}
+	n.Roles = append(n.Roles, role)
+	return n
+}
+
+func (b *topologyBuilder) edge(from, to, kind string) *TopologyEdge {
+	key := [3]string{from, to, kind}
+	e, ok := b.edges[key]
+	if !ok {
+		e = &TopologyEdge{From: from, To: to, Kind: kind, Health: healthOK}
+		b.edges[key] = e
+		b.t.Edges = append(b.t.Edges, e)
+	}
+	return e
+}
+
+// replicaNode names a replica by its instance ID, falling back to a peer with
+// the same URL and then to the URL itself.
+func (b *topologyBuilder) replicaNode(r storage.Replica) string {
+	if r.PeerID != "" {
+		return r.PeerID
+	}
+	for _, p := range b.peers {
+		if p.URL != "" && p.URL == r.URL {
+			return p.InstanceID
+		}
+	}
+	return "url:" + r.URL
+}
+
+func (s *Server) buildTopology(now time.Time) (*Topology, error) {
+	repos, err := s.storage.ListRepos()
+	if err != nil {
+		return nil, fmt.Errorf("list repos: %w", err)
+	}
+	peers, err := s.peers.List()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &topologyBuilder{
+		nodes: make(map[string]*TopologyNode),
+		edges: make(map[[3]string]*TopologyEdge),
+		peers: peers,
+	}
+
+	b.t.Self = "self"
+	if s.instance != nil {
+		b.t.Self = s.instance.ID
+	}
+	b.node(b.t.Self, s.externalURL, "self").Trusted = true
+
+	for _, repo := range repos {
+		meta, err := s.storage.GetMetadata(repo.Owner, repo.Name)
+		if err != nil {
+			continue
+		}
+		full := repo.Owner + "/" + repo.Name
+
+		if meta.ReplicaOf != nil {
+			b.node(meta.ReplicaOf.InstanceID, "", "origin")
+			// A replica doesn't track when its origin last synced.
+			e := b.edge(meta.ReplicaOf.InstanceID, b.t.Self, "replicates")
+			e.Repos = append(e.Repos, full)
+			e.Health = worseHealth(e.Health, healthUnknown)
+			if len(meta.Replicas) > 0 {
+				b.t.Risks = append(b.t.Risks, TopologyRisk{
+					Repo:    full,
+					Kind:    "chain-hop",
+					Node:    b.t.Self,
+					Message: fmt.Sprintf("%d replica(s) of %s receive it only through this instance", len(meta.Replicas), full),
+				})
+			}
+		}
+
+		var healthy []string
+		for _, r := range meta.Replicas {
+			id := b.replicaNode(r)
+			b.node(id, r.URL, "replica")
+
+			health := replicaHealth(r, now)
+			if health == healthOK {
+				healthy = append(healthy, id)
+			}
+
+			e := b.edge(b.t.Self, id, "replicates")
+			e.Repos = append(e.Repos, full)
+			e.Health = worseHealth(e.Health, health)
+			if !r.LastSynced.IsZero() && (e.LastSynced == nil || r.LastSynced.Before(*e.LastSynced)) {
+				synced := r.LastSynced
+				e.LastSynced = &synced
+			}
+			if health == healthFailing && e.LastError == "" {
+				e.LastError = r.LastError
+			}
+		}
+
+		switch {
+		case len(meta.Replicas) == 0:
+		case len(healthy) == 0:
+			b.t.Risks = append(b.t.Risks, TopologyRisk{
+				Repo:    full,
+				Kind:    "no-healthy-replica",
+				Message: fmt.Sprintf("none of the %d replica(s) of %s is in sync", len(meta.Replicas), full),
+			})
+		case len(healthy) == 1:
+			b.t.Risks = append(b.t.Risks, TopologyRisk{
+				Repo:    full,
+				Kind:    "single-replica",
+				Node:    healthy[0],
+				Message: fmt.Sprintf("%s has one healthy replica", full),
+			})
+		}
+	}
+
+	for _, id := range s.standbyOf {
+		b.node(id, "", "primary")
+		e := b.edge(id, b.t.Self, "standby")
+		e.Health = worseHealth(e.Health, healthUnknown)
+	}
+
+	for _, p := range peers {
+		n := b.node(p.InstanceID, p.URL, "peer")
+		n.Trusted = true
+		n.Capabilities = p.Capabilities
+		if !p.LastHandshake.IsZero() {
+			at := p.LastHandshake
+			n.LastHandshake = &at
+		}
+	}
+
+	for _, e := range b.t.Edges {
+		sort.Strings(e.Repos)
+		for _, id := range []string{e.From, e.To} {
+			if id != b.t.Self {
+				b.nodes[id].Health = worseHealth(b.nodes[id].Health, e.Health)
+			}
+		}
+	}
+
+	sort.Slice(b.t.Nodes, func(i, j int) bool { return b.t.Nodes[i].ID < b.t.Nodes[j].ID })
+	sort.Slice(b.t.Edges, func(i, j int) bool {
+		ei, ej := b.t.Edges[i], b.t.Edges[j]
+		if ei.From != ej.From {
+			return ei.From < ej.From
+		}
+		if ei.To != ej.To {
+			return ei.To < ej.To
+		}
+		return ei.Kind < ej.Kind
+	})
+	if b.t.Edges == nil {
+		b.t.Edges = []*TopologyEdge{}
+	}
+	if b.t.Risks == nil {
+		b.t.Risks = []TopologyRisk{}
+	}
+	return &b.t, nil
+}
+
+// handleTopology describes this instance's place in the federation as a
+// graph: the origins it replicates from, the replicas and standbys it feeds,
+// every trusted peer, the health of each link, and repositories that depend
+// on a single instance.
+//
+//	GET /api/v1/admin/topology
+func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+
+	topology, err := s.buildTopology(time.Now())
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("build topology failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"topology": topology,
+	})
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/instance"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// originNotice tells an origin something about a repository it pushes to
+// the replica instance that signed it: that it unsubscribed, or that its
+// admin accepted the repository.
+type originNotice struct {
+	Owner      string `json:"owner"`
+	Repo       string `json:"repo"`
+	InstanceID string `json:"instance_id"`
+}
+
+// handleUnsubscribeReplication disables the replica entry of the instance
+// that sent the request, signed with the key it gave in its handshake when
+// it was added. The origin's admin can resume it once the replica's admin
+// agrees.
+//
+//	POST /api/v1/repos/unsubscribe-replication {"owner", "repo", "instance_id"}
+func (s *Server) handleUnsubscribeReplication(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkClientCert(w, r) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var req originNotice
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Repo == "" || req.InstanceID == "" {
+		s.jsonError(w, "owner, repo and instance_id required", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidName(req.Owner) || !isValidName(req.Repo) {
+		s.jsonError(w, "invalid owner or repo name", http.StatusBadRequest)
+		return
+	}
+
+	if !s.verifySignature(w, req.InstanceID, "unsubscribe-replication", r.Header.Get("X-OpenHub-Timestamp"),
+		r.Header.Get("X-OpenHub-Signature"), instance.Digest(body)) {
+		return
+	}
+
+	if !s.storage.RepoExists(req.Owner, req.Repo) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	var urls []string
+	err = s.storage.UpdateMetadata(req.Owner, req.Repo, func(meta *storage.Metadata) error {
+		for i := range meta.Replicas {
+			if meta.Replicas[i].PeerID == req.InstanceID {
+				meta.Replicas[i].Enabled = false
+				meta.Replicas[i].LastError = "replica unsubscribed; check with its admin before resuming"
+				urls = append(urls, meta.Replicas[i].URL)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("update metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(urls) == 0 {
+		s.jsonError(w, fmt.Sprintf("instance %s is not a replica of this repository", req.InstanceID), http.StatusNotFound)
+		return
+	}
+
+	log.Printf("replica instance %s unsubscribed from %s/%s", req.InstanceID, req.Owner, req.Repo)
+	s.audit(r, audit.Entry{
+		Action: "replica.unsubscribed",
+		Target: req.Owner + "/" + req.Repo,
+		Detail: fmt.Sprintf("instance %s at %s", req.InstanceID, strings.Join(urls, ", ")),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleAdminUnsubscribe asks the origin of a repository replicated here to
+// stop pushing it, for a replica admin who no longer wants it. The
+// repository and its replication token stay, so the origin can resume.
+//
+//	POST /api/v1/admin/replicas/unsubscribe {"owner", "name"}
+func (s *Server) handleAdminUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	admin := s.requestUser(r)
+
+	var req struct {
+		Owner string `json:"owner"`
+		Name  string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Owner == "" || req.Name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+	if s.instance == nil {
+		s.jsonError(w, "federation is not enabled on this instance", http.StatusServiceUnavailable)
+		return
+	}
+	if !s.storage.RepoExists(req.Owner, req.Name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	meta, err := s.storage.GetMetadata(req.Owner, req.Name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if meta.ReplicaOf == nil {
+		s.jsonError(w, "repository is not a replica", http.StatusConflict)
+		return
+	}
+
+	origin, ok, err := s.peers.Get(meta.ReplicaOf.InstanceID)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("lookup origin failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok || origin.URL == "" {
+		s.jsonError(w, fmt.Sprintf("the URL of origin instance %s is unknown; ask its admin to remove this replica", meta.ReplicaOf.InstanceID), http.StatusConflict)
+		return
+	}
+	if !origin.Supports("unsubscribe") {
+		s.jsonError(w, fmt.Sprintf("the origin at %s does not support unsubscribing; ask its admin to remove this replica", origin.URL), http.StatusConflict)
+		return
+	}
+
+	notice := originNotice{Owner: req.Owner, Repo: req.Name, InstanceID: s.instance.ID}
+	if err := s.notifyOrigin(origin.URL+"/api/repos/unsubscribe-replication", "unsubscribe-replication", notice); err != nil {
+		s.jsonError(w, fmt.Sprintf("unsubscribe from %s failed: %v", origin.URL, err), http.StatusBadGateway)
+		return
+	}
+	s.audit(r, audit.Entry{Actor: admin, Action: "replica.unsubscribe", Target: req.Owner + "/" + req.Name,
+		Detail: fmt.Sprintf("origin %s, instance %s", origin.URL, meta.ReplicaOf.InstanceID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"origin":  origin.URL,
+	})
+}
+
+// notifyOrigin posts notice to an origin's endpoint at url, signed as kind.
+func (s *Server) notifyOrigin(url, kind string, notice originNotice) error {
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-OpenHub-Timestamp", fmt.Sprint(timestamp))
+	req.Header.Set("X-OpenHub-Signature", s.instance.Sign(instance.SignatureMessage(kind, timestamp, instance.Digest(data))))
+
+	resp, err := s.federationClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("contact origin: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode response (status %d): %w", resp.StatusCode, err)
+	}
+	if !result.Success {
+		if result.Error == "" {
+			result.Error = resp.Status
+		}
+		return fmt.Errorf("%s", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/uploads"
+)
+
+type UploadManager interface {
+	Create(owner, repo, tag, asset string, size int64, sha string, releaseUsed int64) (*uploads.Session, error)
+	Get(id string) (*uploads.Session, error)
+	Append(id string, offset int64, r io.Reader) (*uploads.Session, error)
+	Complete(id, dest string) (*uploads.Session, error)
+	Abort(id string) error
+}
+
+// handleCreateUpload starts a resumable release asset upload.
+//
+//	POST /api/v1/repos/releases/uploads
+//	{"owner", "name", "tag", "asset", "size", "sha256"}
+func (s *Server) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner  string `json:"owner"`
+		Name   string `json:"name"`
+		Tag    string `json:"tag"`
+		Asset  string `json:"asset"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Owner == "" || req.Name == "" || req.Tag == "" || req.Asset == "" || req.SHA256 == "" {
+		s.jsonError(w, "owner, name, tag, asset, size and sha256 required", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidName(req.Owner) || !isValidName(req.Name) || !isValidName(req.Tag) || !isValidName(req.Asset) {
+		s.jsonError(w, "invalid owner, name, tag or asset", http.StatusBadRequest)
+		return
+	}
+
+	if !s.storage.RepoExists(req.Owner, req.Name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	if username != req.Owner {
+		s.jsonError(w, "only the owner can upload release assets", http.StatusForbidden)
+		return
+	}
+
+	if s.releases != nil {
+		if _, err := s.releases.Get(req.Owner, req.Name, req.Tag); err != nil {
+			s.releaseError(w, err)
+			return
+		}
+	}
+
+	used, err := s.storage.ReleaseSize(req.Owner, req.Name, req.Tag)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("release size: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sess, err := s.uploads.Create(req.Owner, req.Name, req.Tag, req.Asset, req.Size, req.SHA256, used)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, uploads.ErrTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		s.jsonError(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"upload":  sess,
+	})
+}
+
+// handleUpload serves a single upload session:
+//
+//	GET    /api/v1/repos/releases/uploads/{id}           current offset
+//	PATCH  /api/v1/repos/releases/uploads/{id}           append chunk (Upload-Offset header)
+//	POST   /api/v1/repos/releases/uploads/{id}/complete  verify checksum and publish
+//	DELETE /api/v1/repos/releases/uploads/{id}           abort
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, apiV1+"/repos/releases/uploads/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	sess, err := s.uploads.Get(id)
+	if err != nil {
+		s.uploadError(w, err)
+		return
+	}
+	if sess.Owner != username {
+		s.jsonError(w, "upload not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == "GET":
+		s.writeUpload(w, sess)
+
+	case action == "" && r.Method == "PATCH":
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			s.jsonError(w, "Upload-Offset header required", http.StatusBadRequest)
+			return
+		}
+		sess, err := s.uploads.Append(id, offset, r.Body)
+		if err != nil {
+			s.uploadError(w, err)
+			return
+		}
+		s.writeUpload(w, sess)
+
+	case action == "complete" && r.Method == "POST":
+		dest := s.storage.ReleaseAssetPath(sess.Owner, sess.Repo, sess.Tag, sess.Asset)
+		sess, err := s.uploads.Complete(id, dest)
+		if err != nil {
+			s.uploadError(w, err)
+			return
+		}
+		s.writeUpload(w, sess)
+
+	case action == "" && r.Method == "DELETE":
+		if err := s.uploads.Abort(id); err != nil {
+			s.uploadError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) writeUpload(w http.ResponseWriter, sess *uploads.Session) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"upload":  sess,
+	})
+}
+
+func (s *Server) uploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, uploads.ErrNotFound):
+		s.jsonError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, uploads.ErrOffset), errors.Is(err, uploads.ErrAlreadyExists):
+		s.jsonError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, uploads.ErrTooLarge):
+		s.jsonError(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, uploads.ErrIncomplete), errors.Is(err, uploads.ErrChecksum):
+		s.jsonError(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// errSyncRunning is returned when a mirror is already being synced.
+var errSyncRunning = errors.New("a sync of this repository is already running")
+
+// StartUpstreamSync fetches every repository mirrored from another forge
+// from its upstream every interval.
+func (s *Server) StartUpstreamSync(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			s.syncUpstreams()
+		}
+	}()
+}
+
+func (s *Server) syncUpstreams() {
+	repos, err := s.storage.ListRepos()
+	if err != nil {
+		log.Printf("upstream sync: list repos failed: %v", err)
+		return
+	}
+	for _, repo := range repos {
+		// A replica's refs come from its origin, even if it once mirrored
+		// an upstream itself.
+		meta, err := s.storage.GetMetadata(repo.Owner, repo.Name)
+		if err != nil || meta.Upstream == nil || meta.ReplicaOf != nil {
+			continue
+		}
+		if _, err := s.syncUpstream(repo.Owner, repo.Name); err != nil && !errors.Is(err, errSyncRunning) {
+			log.Printf("upstream sync: %s/%s: %v", repo.Owner, repo.Name, err)
+		}
+	}
+}
+
+// syncUpstream fetches a mirror from its upstream and records the outcome
+// in its metadata, queueing replication if any ref moved. It returns the
+// recorded status, with the token removed.
+func (s *Server) syncUpstream(owner, name string) (storage.Upstream, error) {
+	key := owner + "/" + name
+	s.syncingMu.Lock()
+	if s.syncing[key] {
+		s.syncingMu.Unlock()
+		return storage.Upstream{}, errSyncRunning
+	}
+	s.syncing[key] = true
+	s.syncingMu.Unlock()
+	defer func() {
+		s.syncingMu.Lock()
+		delete(s.syncing, key)
+		s.syncingMu.Unlock()
+	}()
+
+	meta, err := s.storage.GetMetadata(owner, name)
+	if err != nil {
+		return storage.Upstream{}, err
+	}
+	if meta.Upstream == nil {
+		return storage.Upstream{}, fmt.Errorf("%s is not a mirror", key)
+	}
+
+	before, _ := s.storage.ListRefs(owner, name)
+	fetchErr := s.storage.FetchUpstream(owner, name, *meta.Upstream)
+	after, _ := s.storage.ListRefs(owner, name)
+
+	var status storage.Upstream
+	err = s.storage.UpdateMetadata(owner, name, func(m *storage.Metadata) error {
+		if m.Upstream == nil {
+			return fmt.Errorf("%s stopped being a mirror during the sync", key)
+		}
+		m.Upstream.LastAttempt = time.Now()
+		m.Upstream.LastError = ""
+		if fetchErr != nil {
+			m.Upstream.LastError = fetchErr.Error()
+		} else {
+			m.Upstream.LastSynced = m.Upstream.LastAttempt
+		}
+		status = *m.Upstream
+		return nil
+	})
+	if err != nil {
+		return storage.Upstream{}, err
+	}
+	status.Token = ""
+
+	if fetchErr == nil && !maps.Equal(before, after) && len(meta.Replicas) > 0 && s.replQueue != nil {
+		s.replQueue.Queue(owner, name)
+	}
+	return status, fetchErr
+}
+
+// handleUpstreamSync fetches a mirror from its upstream now, rather than
+// waiting for the next scheduled sync. Only the owner or an admin can.
+//
+//	POST /api/v1/repos/upstream/sync {"owner", "name"}
+func (s *Server) handleUpstreamSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner string `json:"owner"`
+		Name  string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Owner == "" || req.Name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.storage.RepoExists(req.Owner, req.Name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if username != req.Owner && !s.admins[username] {
+		s.jsonError(w, "only the owner can sync a mirror", http.StatusForbidden)
+		return
+	}
+
+	meta, err := s.storage.GetMetadata(req.Owner, req.Name)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if meta.Upstream == nil {
+		s.jsonError(w, "repository is not a mirror", http.StatusBadRequest)
+		return
+	}
+	if meta.ReplicaOf != nil {
+		s.jsonError(w, "repository is a replica; it is synced from its origin", http.StatusConflict)
+		return
+	}
+
+	status, err := s.syncUpstream(req.Owner, req.Name)
+	switch {
+	case errors.Is(err, errSyncRunning):
+		s.jsonError(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil && status.URL == "":
+		s.jsonError(w, fmt.Sprintf("sync failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, audit.Entry{
+		Actor:  username,
+		Action: "repo.sync",
+		Target: req.Owner + "/" + req.Name,
+		Detail: status.URL,
+	})
+
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"upstream": status,
+	})
+}
@@ -0,0 +1,663 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/events"
+	"github.com/jeremytregunna/openhub/internal/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// accountUser returns the user a key or token request acts on: the caller,
+// or for an admin, whoever "username" names.
+func (s *Server) accountUser(w http.ResponseWriter, r *http.Request, username string) (string, string, bool) {
+	caller, ok := s.bearerUser(w, r)
+	if !ok {
+		return "", "", false
+	}
+	if username == "" || username == caller {
+		return caller, caller, true
+	}
+
+	if !s.admins[caller] {
+		s.audit(r, audit.Entry{Actor: caller, Action: "auth.denied", Target: r.URL.Path, Detail: "admin access required to manage " + username})
+		s.jsonError(w, "admin access required", http.StatusForbidden)
+		return "", "", false
+	}
+	if _, err := s.authStore.GetUser(username); err != nil {
+		s.jsonError(w, "user not found", http.StatusNotFound)
+		return "", "", false
+	}
+	return caller, username, true
+}
+
+// handleAdminUsers lists accounts, creates them, returning the new user's
+// recovery codes and, with ssh_key, registering their first key, and deletes
+// them (see deleteUser). Per-repo replication accounts are not listed.
+//
+//	GET    /api/v1/admin/users
+//	POST   /api/v1/admin/users {"username", "ssh_key"}
+//	DELETE /api/v1/admin/users?username=..[&repos=block|transfer|delete][&to=..]
+func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "DELETE" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+	admin := s.requestUser(r)
+
+	if r.Method == "DELETE" {
+		s.deleteUser(w, r, admin)
+		return
+	}
+
+	if r.Method == "GET" {
+		users, err := s.authStore.ListUsers()
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("list users failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		type account struct {
+			Username    string    `json:"username"`
+			DisplayName string    `json:"display_name,omitempty"`
+			SSHKeys     int       `json:"ssh_keys"`
+			APITokens   int       `json:"api_tokens"`
+			CreatedAt   time.Time `json:"created_at"`
+			Admin       bool      `json:"admin,omitempty"`
+		}
+		accounts := []account{}
+		for _, u := range users {
+			if strings.HasPrefix(u.Username, "replication-") {
+				continue
+			}
+			accounts = append(accounts, account{
+				Username:    u.Username,
+				DisplayName: u.DisplayName,
+				SSHKeys:     len(u.SSHKeys),
+				APITokens:   len(u.APITokens),
+				CreatedAt:   u.CreatedAt,
+				Admin:       s.admins[u.Username],
+			})
+		}
+		sort.Slice(accounts, func(i, j int) bool { return accounts[i].Username < accounts[j].Username })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"users":   accounts,
+		})
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		SSHKey   string `json:"ssh_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := s.createUser(requestActor(r, admin), req.Username, req.SSHKey)
+	if err != nil {
+		s.opFailed(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"username":       req.Username,
+		"recovery_codes": codes,
+	})
+}
+
+// createUser creates the account username for the admin a, registering
+// sshKey as its first key unless it is empty, and returns its recovery
+// codes.
+func (s *Server) createUser(a actor, username, sshKey string) ([]string, error) {
+	if !s.validUsername(username) {
+		return nil, opErrorf(http.StatusBadRequest, "invalid or reserved username")
+	}
+	sshKey = strings.TrimSpace(sshKey)
+	if sshKey != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(sshKey)); err != nil {
+			return nil, opErrorf(http.StatusBadRequest, "invalid ssh_key")
+		}
+	}
+
+	if err := s.authStore.CreateUser(username); err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			return nil, opErrorf(http.StatusConflict, "create user failed: %v", err)
+		}
+		return nil, fmt.Errorf("create user failed: %w", err)
+	}
+	s.auditBy(a, audit.Entry{Action: "user.create", Target: username})
+	s.publish(events.Event{Kind: events.KindUserCreated, Owner: username, User: a.user})
+
+	if sshKey != "" {
+		if err := s.authStore.AddSSHKey(username, "default", sshKey); err != nil {
+			return nil, fmt.Errorf("add ssh key failed: %w", err)
+		}
+		s.auditBy(a, audit.Entry{Action: "key.add", Target: username, Detail: "default"})
+	}
+
+	codes, err := s.authStore.GenerateRecoveryCodes(username)
+	if err != nil {
+		return nil, fmt.Errorf("generate recovery codes failed: %w", err)
+	}
+	return codes, nil
+}
+
+// deleteUser deletes an account with its SSH keys and tokens. What happens
+// to the repositories it owns is up to "repos": with "block", the default,
+// the account isn't deleted while it owns any; "transfer" moves them to the
+// user "to", which can't be done for replicated ones; and "delete" deletes
+// them as delete-repo would, replicas included. The replication accounts
+// other instances push the user's repositories here with go too.
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request, admin string) {
+	username := r.URL.Query().Get("username")
+	disposition := r.URL.Query().Get("repos")
+	to := r.URL.Query().Get("to")
+	if disposition == "" {
+		disposition = "block"
+	}
+
+	if username == "" || strings.HasPrefix(username, "replication-") {
+		s.jsonError(w, "username required", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.authStore.GetUser(username); err != nil {
+		s.jsonError(w, "user not found", http.StatusNotFound)
+		return
+	}
+	switch disposition {
+	case "block", "delete":
+		if to != "" {
+			s.jsonError(w, "to is only used with repos=transfer", http.StatusBadRequest)
+			return
+		}
+	case "transfer":
+		if to == "" || to == username {
+			s.jsonError(w, "transfer needs another user as to", http.StatusBadRequest)
+			return
+		}
+		if _, err := s.authStore.GetUser(to); err != nil || strings.HasPrefix(to, "replication-") {
+			s.jsonError(w, "user to transfer to not found", http.StatusNotFound)
+			return
+		}
+	default:
+		s.jsonError(w, "repos must be block, transfer or delete", http.StatusBadRequest)
+		return
+	}
+
+	repos, err := s.storage.ListReposByOwner(username)
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("list repos failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	metas := make([]storage.Metadata, len(repos))
+	var names []string
+	for i, repo := range repos {
+		if metas[i], err = s.storage.GetMetadata(repo.Owner, repo.Name); err != nil {
+			s.jsonError(w, fmt.Sprintf("get metadata of %s failed: %v", repo.Name, err), http.StatusInternalServerError)
+			return
+		}
+		names = append(names, repo.Owner+"/"+repo.Name)
+	}
+
+	// Check everything before changing anything, so a refusal leaves the
+	// account and all its repositories as they were.
+	if disposition == "block" && len(repos) > 0 {
+		s.jsonError(w, fmt.Sprintf("%s owns %s; transfer or delete them", username, strings.Join(names, ", ")), http.StatusConflict)
+		return
+	}
+	if disposition == "transfer" {
+		for i, repo := range repos {
+			switch {
+			case metas[i].ReplicaOf != nil || len(metas[i].Replicas) > 0:
+				s.jsonError(w, fmt.Sprintf("%s/%s is replicated; remove its replicas, or delete it, first", repo.Owner, repo.Name), http.StatusConflict)
+				return
+			case s.storage.RepoExists(to, repo.Name):
+				s.jsonError(w, fmt.Sprintf("%s/%s already exists", to, repo.Name), http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	accounts, err := s.replicationAccounts(username)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i, repo := range repos {
+		target := repo.Owner + "/" + repo.Name
+		if disposition == "transfer" {
+			if err := s.storage.MoveRepo(repo.Owner, repo.Name, to, repo.Name); err != nil {
+				s.jsonError(w, fmt.Sprintf("transfer failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			s.audit(r, audit.Entry{Actor: admin, Action: "repo.transfer", Target: target, Detail: "to " + to + "/" + repo.Name})
+			if err := s.repointForks(target, to+"/"+repo.Name); err != nil {
+				s.jsonError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			continue
+		}
+
+		if err := s.storage.DeleteRepo(repo.Owner, repo.Name); err != nil {
+			s.jsonError(w, fmt.Sprintf("delete failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: admin, Action: "repo.delete", Target: target})
+		if len(metas[i].Replicas) > 0 && s.replQueue != nil {
+			s.replQueue.QueueDelete(repo.Owner, repo.Name, metas[i].Replicas)
+		}
+	}
+
+	for _, account := range accounts {
+		if err := s.authStore.DeleteUser(account); err != nil {
+			s.jsonError(w, fmt.Sprintf("delete replication user failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.authStore.DeleteUser(username); err != nil {
+		s.jsonError(w, fmt.Sprintf("delete user failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var detail string
+	switch {
+	case len(repos) > 0 && disposition == "transfer":
+		detail = fmt.Sprintf("%d repos transferred to %s", len(repos), to)
+	case len(repos) > 0:
+		detail = fmt.Sprintf("%d repos deleted", len(repos))
+	}
+	s.audit(r, audit.Entry{Actor: admin, Action: "user.delete", Target: username, Detail: detail})
+
+	if names == nil {
+		names = []string{}
+	}
+	if accounts == nil {
+		accounts = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":              true,
+		"username":             username,
+		"repos":                names,
+		"disposition":          disposition,
+		"replication_accounts": accounts,
+	})
+}
+
+// replicationAccounts returns the accounts other instances push owner's
+// repositories here with. The name doesn't say where the owner ends, so an
+// account that could also belong to a longer owner name, such as
+// "replication-al-ice-..." for "al" when "al-ice" exists, is left alone.
+func (s *Server) replicationAccounts(owner string) ([]string, error) {
+	users, err := s.authStore.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("list users failed: %w", err)
+	}
+	repos, err := s.storage.ListRepos()
+	if err != nil {
+		return nil, fmt.Errorf("list repos failed: %w", err)
+	}
+	others := map[string]bool{}
+	for _, u := range users {
+		others[u.Username] = true
+	}
+	for _, repo := range repos {
+		others[repo.Owner] = true
+	}
+	delete(others, owner)
+
+	var accounts []string
+	prefix := "replication-" + owner + "-"
+	for _, u := range users {
+		if !strings.HasPrefix(u.Username, prefix) {
+			continue
+		}
+		ambiguous := false
+		for other := range others {
+			if strings.HasPrefix(other, owner+"-") && strings.HasPrefix(u.Username, "replication-"+other+"-") {
+				ambiguous = true
+				break
+			}
+		}
+		if !ambiguous {
+			accounts = append(accounts, u.Username)
+		}
+	}
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// repointForks updates the repositories forked from one that moved.
+func (s *Server) repointForks(from, to string) error {
+	repos, err := s.storage.ListRepos()
+	if err != nil {
+		return fmt.Errorf("list repos failed: %w", err)
+	}
+	for _, repo := range repos {
+		meta, err := s.storage.GetMetadata(repo.Owner, repo.Name)
+		if err != nil || meta.ForkOf != from {
+			continue
+		}
+		err = s.storage.UpdateMetadata(repo.Owner, repo.Name, func(meta *storage.Metadata) error {
+			meta.ForkOf = to
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("update fork %s/%s failed: %w", repo.Owner, repo.Name, err)
+		}
+	}
+	return nil
+}
+
+// handleUserKeys lists, adds and removes the caller's SSH keys, and sets
+// the addresses each may be used from. Admins can manage another user's
+// keys by naming them.
+//
+//	GET    /api/v1/users/keys[?username=..]
+//	POST   /api/v1/users/keys {"username", "name", "key", "allowed_ips"}
+//	PATCH  /api/v1/users/keys {"username", "name", "allowed_ips"}
+//	DELETE /api/v1/users/keys?name=..[&username=..]
+func (s *Server) handleUserKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		_, username, ok := s.accountUser(w, r, r.URL.Query().Get("username"))
+		if !ok {
+			return
+		}
+
+		user, err := s.authStore.GetUser(username)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("get user failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"keys":    user.SSHKeys,
+		})
+
+	case "POST":
+		var req struct {
+			Username   string   `json:"username"`
+			Name       string   `json:"name"`
+			Key        string   `json:"key"`
+			AllowedIPs []string `json:"allowed_ips"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		caller, username, ok := s.accountUser(w, r, req.Username)
+		if !ok {
+			return
+		}
+
+		if err := s.addSSHKey(requestActor(r, caller), username, req.Name, req.Key, req.AllowedIPs); err != nil {
+			s.opFailed(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	case "PATCH":
+		s.setAllowedIPs(w, r, "key", s.authStore.SetKeyAllowedIPs)
+
+	case "DELETE":
+		caller, username, ok := s.accountUser(w, r, r.URL.Query().Get("username"))
+		if !ok {
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			s.jsonError(w, "name required", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.authStore.RemoveSSHKey(username, name); err != nil {
+			s.jsonError(w, fmt.Sprintf("remove key failed: %v", err), http.StatusNotFound)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: caller, Action: "key.remove", Target: username, Detail: name})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUserTokens lists the caller's API tokens by name, generates new
+// ones, sets the addresses each may be used from and revokes them. A
+// token's value is only shown when it is made. Admins can manage another
+// user's tokens by naming them.
+//
+//	GET    /api/v1/users/tokens[?username=..]
+//	POST   /api/v1/users/tokens {"username", "name", "allowed_ips"}
+//	PATCH  /api/v1/users/tokens {"username", "name", "allowed_ips"}
+//	DELETE /api/v1/users/tokens?name=..[&username=..]
+func (s *Server) handleUserTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		_, username, ok := s.accountUser(w, r, r.URL.Query().Get("username"))
+		if !ok {
+			return
+		}
+
+		user, err := s.authStore.GetUser(username)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("get user failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		type token struct {
+			Name       string    `json:"name"`
+			CreatedAt  time.Time `json:"created_at"`
+			AllowedIPs []string  `json:"allowed_ips,omitempty"`
+		}
+		tokens := []token{}
+		for _, t := range user.APITokens {
+			tokens = append(tokens, token{Name: t.Name, CreatedAt: t.CreatedAt, AllowedIPs: t.AllowedIPs})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"tokens":  tokens,
+		})
+
+	case "POST":
+		var req struct {
+			Username   string   `json:"username"`
+			Name       string   `json:"name"`
+			AllowedIPs []string `json:"allowed_ips"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		caller, username, ok := s.accountUser(w, r, req.Username)
+		if !ok {
+			return
+		}
+
+		token, err := s.generateToken(requestActor(r, caller), username, req.Name, req.AllowedIPs)
+		if err != nil {
+			s.opFailed(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"token":   token,
+		})
+
+	case "DELETE":
+		caller, username, ok := s.accountUser(w, r, r.URL.Query().Get("username"))
+		if !ok {
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			s.jsonError(w, "name required", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.authStore.RevokeAPIToken(username, name); err != nil {
+			s.jsonError(w, fmt.Sprintf("revoke token failed: %v", err), http.StatusNotFound)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: caller, Action: "token.revoke", Target: username, Detail: name})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	case "PATCH":
+		s.setAllowedIPs(w, r, "token", s.authStore.SetTokenAllowedIPs)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// addSSHKey adds key to username's SSH keys as name for a, usable only from
+// allowedIPs unless that is empty.
+func (s *Server) addSSHKey(a actor, username, name, key string, allowedIPs []string) error {
+	key = strings.TrimSpace(key)
+	if name == "" || key == "" {
+		return opErrorf(http.StatusBadRequest, "name and key required")
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+		return opErrorf(http.StatusBadRequest, "invalid key")
+	}
+	if _, err := clientip.ParseAllowList(allowedIPs); err != nil {
+		return opErrorf(http.StatusBadRequest, "%v", err)
+	}
+
+	user, err := s.authStore.GetUser(username)
+	if err != nil {
+		return fmt.Errorf("get user failed: %w", err)
+	}
+	for _, k := range user.SSHKeys {
+		if k.Name == name {
+			return opErrorf(http.StatusConflict, "a key with that name already exists")
+		}
+	}
+
+	if err := s.authStore.AddSSHKey(username, name, key); err != nil {
+		return fmt.Errorf("add key failed: %w", err)
+	}
+	if len(allowedIPs) > 0 {
+		if err := s.authStore.SetKeyAllowedIPs(username, name, allowedIPs); err != nil {
+			s.authStore.RemoveSSHKey(username, name)
+			return fmt.Errorf("add key failed: %w", err)
+		}
+	}
+	s.auditBy(a, audit.Entry{Action: "key.add", Target: username, Detail: allowedDetail(name, allowedIPs)})
+	return nil
+}
+
+// generateToken generates an API token called name for username for a,
+// usable only from allowedIPs unless that is empty.
+func (s *Server) generateToken(a actor, username, name string, allowedIPs []string) (string, error) {
+	if name == "" {
+		return "", opErrorf(http.StatusBadRequest, "name required")
+	}
+	if _, err := clientip.ParseAllowList(allowedIPs); err != nil {
+		return "", opErrorf(http.StatusBadRequest, "%v", err)
+	}
+
+	token, err := s.authStore.GenerateAPIToken(username, name)
+	if err != nil {
+		return "", fmt.Errorf("generate token failed: %w", err)
+	}
+	if len(allowedIPs) > 0 {
+		if err := s.authStore.SetTokenAllowedIPs(username, name, allowedIPs); err != nil {
+			s.authStore.RevokeAPIToken(username, name)
+			return "", fmt.Errorf("generate token failed: %w", err)
+		}
+	}
+	s.auditBy(a, audit.Entry{Action: "token.create", Target: username, Detail: allowedDetail(name, allowedIPs)})
+	return token, nil
+}
+
+// setAllowedIPs sets the addresses the caller's, or for an admin the named
+// user's, token or key called name may be used from, with set.
+func (s *Server) setAllowedIPs(w http.ResponseWriter, r *http.Request, kind string, set func(username, name string, allowed []string) error) {
+	var req struct {
+		Username   string   `json:"username"`
+		Name       string   `json:"name"`
+		AllowedIPs []string `json:"allowed_ips"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	caller, username, ok := s.accountUser(w, r, req.Username)
+	if !ok {
+		return
+	}
+
+	if req.Name == "" {
+		s.jsonError(w, "name required", http.StatusBadRequest)
+		return
+	}
+	allowed, err := clientip.ParseAllowList(req.AllowedIPs)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := set(username, req.Name, allowed); err != nil {
+		s.jsonError(w, fmt.Sprintf("set allowed addresses failed: %v", err), http.StatusNotFound)
+		return
+	}
+	s.audit(r, audit.Entry{Actor: caller, Action: kind + ".allow", Target: username, Detail: allowedDetail(req.Name, allowed)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"allowed_ips": allowed,
+	})
+}
+
+// allowedDetail describes a token or key and where it may be used from, for
+// the audit log.
+func allowedDetail(name string, allowed []string) string {
+	if len(allowed) == 0 {
+		return name
+	}
+	return name + " from " + strings.Join(allowed, ",")
+}
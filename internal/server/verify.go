@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	// defaultVerifyWorkers is how many repositories are checked at once
+	// unless the request asks otherwise.
+	defaultVerifyWorkers = 2
+	// maxVerifyWorkers caps it, so a verify can't starve clones and
+	// pushes of CPU and disk.
+	maxVerifyWorkers = 8
+)
+
+// handleVerify runs git fsck on every repository, those of one owner, or a
+// single one, and reports what it found, so corruption can be caught before
+// replication spreads it. The response comes once every check is done.
+//
+//	GET /api/v1/admin/verify[?owner=alice[&name=project]][&workers=N]
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkAdmin(w, r) {
+		return
+	}
+
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+
+	workers := defaultVerifyWorkers
+	if v := r.URL.Query().Get("workers"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.jsonError(w, "workers must be a positive number", http.StatusBadRequest)
+			return
+		}
+		workers = min(n, maxVerifyWorkers)
+	}
+
+	var repos []storage.Repo
+	var err error
+	switch {
+	case name != "":
+		if owner == "" {
+			s.jsonError(w, "name requires owner", http.StatusBadRequest)
+			return
+		}
+		if !s.storage.RepoExists(owner, name) {
+			s.jsonError(w, "repository not found", http.StatusNotFound)
+			return
+		}
+		repos = []storage.Repo{{Owner: owner, Name: name}}
+	case owner != "":
+		repos, err = s.storage.ListReposByOwner(owner)
+	default:
+		repos, err = s.storage.ListRepos()
+	}
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := s.storage.FsckRepos(repos, workers, nil)
+	failed := []storage.FsckResult{}
+	for _, result := range results {
+		if !result.OK() {
+			failed = append(failed, result)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"checked": len(results),
+		"ok":      len(failed) == 0,
+		"failed":  failed,
+	})
+}
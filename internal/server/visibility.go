@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+// handleVisibility makes a repository public or private without touching
+// the rest of its metadata. Only the owner or an admin can, and not on a
+// replica, whose visibility follows its origin. Making a repository public
+// needs confirm set to its "owner/name", and a fork of a private repository
+// can't be made public while that repository is private.
+//
+//	POST /api/v1/repos/visibility {"owner", "name", "private", "confirm"}
+func (s *Server) handleVisibility(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Owner   string `json:"owner"`
+		Name    string `json:"name"`
+		Private *bool  `json:"private"`
+		Confirm string `json:"confirm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Owner == "" || req.Name == "" || req.Private == nil {
+		s.jsonError(w, "owner, name and private required", http.StatusBadRequest)
+		return
+	}
+
+	changed, err := s.setVisibility(requestActor(r, username), req.Owner, req.Name, *req.Private, req.Confirm)
+	if err != nil {
+		s.opFailed(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"private": *req.Private,
+		"changed": changed,
+	})
+}
+
+// setVisibility makes owner/name public or private for a, who must own it
+// or be an admin, reporting whether that changed it.
+func (s *Server) setVisibility(a actor, owner, name string, private bool, confirm string) (bool, error) {
+	if !s.storage.RepoExists(owner, name) {
+		return false, opErrorf(http.StatusNotFound, "repository not found")
+	}
+	if a.user != owner && !s.admins[a.user] {
+		return false, opErrorf(http.StatusForbidden, "only the owner can change a repository's visibility")
+	}
+
+	full := owner + "/" + name
+	if !private && confirm != full {
+		return false, opErrorf(http.StatusBadRequest, "making a repository public needs confirm set to %q", full)
+	}
+
+	errReplica := errors.New("repository is a replica; change its visibility on the origin")
+	errPrivateFork := errors.New("repository is a fork of a private repository")
+	var before storage.Metadata
+	var replicas int
+	err := s.storage.UpdateMetadata(owner, name, func(m *storage.Metadata) error {
+		if m.ReplicaOf != nil {
+			return errReplica
+		}
+		if !private && m.ForkOf != "" {
+			upOwner, upName, _ := strings.Cut(m.ForkOf, "/")
+			if up, err := s.storage.GetMetadata(upOwner, upName); err == nil && up.Private {
+				return errPrivateFork
+			}
+		}
+		before = *m
+		m.Private = private
+		replicas = len(m.Replicas)
+		return nil
+	})
+	switch {
+	case errors.Is(err, errReplica) || errors.Is(err, errPrivateFork):
+		return false, opErrorf(http.StatusConflict, "%v", err)
+	case err != nil:
+		return false, fmt.Errorf("set visibility failed: %w", err)
+	}
+
+	changed := before.Private != private
+	if changed {
+		s.auditBy(a, audit.Entry{Action: "repo.visibility", Target: full, Detail: fmt.Sprintf("private=%t", private)})
+		if replicas > 0 && s.replQueue != nil {
+			s.replQueue.QueueMetadata(owner, name)
+		}
+	}
+	return changed, nil
+}
+
+// handleAllowedIPs sets the IPs and CIDR ranges a repository can be reached
+// from; from anywhere else it looks like it doesn't exist. Only the owner or
+// an admin can, and an empty list lifts the limit.
+//
+//	POST /api/v1/repos/allowed-ips {"owner", "name", "allowed_ips"}
+func (s *Server) handleAllowedIPs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Owner      string   `json:"owner"`
+		Name       string   `json:"name"`
+		AllowedIPs []string `json:"allowed_ips"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Owner == "" || req.Name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := s.checkRepoManager(w, r, req.Owner, req.Name, "limit where a repository can be reached from")
+	if !ok {
+		return
+	}
+
+	allowed, err := clientip.ParseAllowList(req.AllowedIPs)
+	if err != nil {
+		s.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.storage.UpdateMetadata(req.Owner, req.Name, func(m *storage.Metadata) error {
+		m.AllowedIPs = allowed
+		return nil
+	})
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("set allowed addresses failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	detail := "any address"
+	if len(allowed) > 0 {
+		detail = strings.Join(allowed, ", ")
+	}
+	s.audit(r, audit.Entry{Actor: username, Action: "repo.allowed_ips", Target: req.Owner + "/" + req.Name, Detail: detail})
+
+	if allowed == nil {
+		allowed = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"allowed_ips": allowed,
+	})
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/audit"
+	"github.com/jeremytregunna/openhub/internal/storage"
+	"github.com/jeremytregunna/openhub/internal/webhooks"
+)
+
+// WebhookPublisher queues events for delivery to repositories' webhooks and
+// keeps the log of their delivery attempts.
+type WebhookPublisher interface {
+	Publish(ev webhooks.Event) error
+	Deliveries(owner, repo, hook string) ([]webhooks.LogEntry, error)
+	Redeliver(owner, repo, id string) (string, error)
+}
+
+// SetWebhooks lets the server ping webhooks when they're added and show and
+// redeliver their deliveries.
+func (s *Server) SetWebhooks(hooks WebhookPublisher) {
+	s.webhooks = hooks
+}
+
+// checkRepoOwner verifies the repo exists and the request is authenticated
+// as its owner.
+func (s *Server) checkRepoOwner(w http.ResponseWriter, r *http.Request, owner, name string) bool {
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return false
+	}
+
+	if !s.storage.RepoExists(owner, name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return false
+	}
+
+	if username != owner {
+		s.jsonError(w, "only the owner can manage webhooks", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// checkRepoManager verifies the repo exists and the request is authenticated
+// as its owner or an admin, and returns who. what finishes the error given
+// to anyone else: "only the owner can <what>".
+func (s *Server) checkRepoManager(w http.ResponseWriter, r *http.Request, owner, name, what string) (string, bool) {
+	username, ok := s.bearerUser(w, r)
+	if !ok {
+		return "", false
+	}
+
+	if !s.storage.RepoExists(owner, name) {
+		s.jsonError(w, "repository not found", http.StatusNotFound)
+		return "", false
+	}
+
+	if username != owner && !s.admins[username] {
+		s.audit(r, audit.Entry{Actor: username, Action: "auth.denied", Target: owner + "/" + name, Detail: "only the owner or an admin can " + what})
+		s.jsonError(w, "only the owner can "+what, http.StatusForbidden)
+		return "", false
+	}
+	return username, true
+}
+
+// handleWebhooks lists, adds and removes a repository's webhooks. Only the
+// owner may use it, and secrets are never returned.
+//
+//	GET    /api/v1/repos/webhooks?owner=..&name=..
+//	POST   /api/v1/repos/webhooks {"owner", "name", "url", "secret", "events"}
+//	DELETE /api/v1/repos/webhooks?owner=..&name=..&id=..
+//
+// A new webhook is sent a ping event.
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		if owner == "" || name == "" {
+			s.jsonError(w, "owner and name required", http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkRepoOwner(w, r, owner, name) {
+			return
+		}
+
+		meta, err := s.storage.GetMetadata(owner, name)
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("get metadata failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		hooks := webhooks.Redact(meta.Webhooks)
+		if hooks == nil {
+			hooks = []storage.Webhook{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"webhooks": hooks,
+		})
+
+	case "POST":
+		var req struct {
+			Owner  string   `json:"owner"`
+			Name   string   `json:"name"`
+			URL    string   `json:"url"`
+			Secret string   `json:"secret"`
+			Events []string `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Owner == "" || req.Name == "" {
+			s.jsonError(w, "owner and name required", http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkRepoOwner(w, r, req.Owner, req.Name) {
+			return
+		}
+
+		if len(req.Events) == 0 {
+			req.Events = []string{webhooks.EventPush}
+		}
+		hook := storage.Webhook{
+			ID:        webhooks.NewID(),
+			URL:       req.URL,
+			Secret:    req.Secret,
+			Events:    req.Events,
+			Active:    true,
+			CreatedAt: time.Now(),
+		}
+		if err := webhooks.Validate(hook); err != nil {
+			s.jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := s.storage.UpdateMetadata(req.Owner, req.Name, func(m *storage.Metadata) error {
+			m.Webhooks = append(m.Webhooks, hook)
+			return nil
+		})
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("set metadata failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: req.Owner, Action: "repo.webhook-add", Target: req.Owner + "/" + req.Name, Detail: hook.ID + " " + hook.URL})
+
+		if s.webhooks != nil {
+			if err := s.webhooks.Publish(webhooks.Event{Kind: webhooks.EventPing, Owner: req.Owner, Repo: req.Name, Hook: hook.ID}); err != nil {
+				log.Printf("ping webhook %s of %s/%s failed: %v", hook.ID, req.Owner, req.Name, err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"webhook": webhooks.Redact([]storage.Webhook{hook})[0],
+		})
+
+	case "DELETE":
+		owner := r.URL.Query().Get("owner")
+		name := r.URL.Query().Get("name")
+		id := r.URL.Query().Get("id")
+		if owner == "" || name == "" || id == "" {
+			s.jsonError(w, "owner, name and id required", http.StatusBadRequest)
+			return
+		}
+
+		if !s.checkRepoOwner(w, r, owner, name) {
+			return
+		}
+
+		found := false
+		err := s.storage.UpdateMetadata(owner, name, func(m *storage.Metadata) error {
+			kept := m.Webhooks[:0]
+			for _, h := range m.Webhooks {
+				if h.ID == id {
+					found = true
+					continue
+				}
+				kept = append(kept, h)
+			}
+			m.Webhooks = kept
+			return nil
+		})
+		if err != nil {
+			s.jsonError(w, fmt.Sprintf("set metadata failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			s.jsonError(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		s.audit(r, audit.Entry{Actor: owner, Action: "repo.webhook-remove", Target: owner + "/" + name, Detail: id})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWebhookDeliveries lists a repository's recent webhook delivery
+// attempts, newest first, with each receiver's status, latency and the start
+// of its response. Payloads are only included when asking for one delivery.
+//
+//	GET /api/v1/repos/webhooks/deliveries?owner=..&name=..[&hook=..][&delivery=..]
+func (s *Server) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+	if owner == "" || name == "" {
+		s.jsonError(w, "owner and name required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoOwner(w, r, owner, name) {
+		return
+	}
+	if s.webhooks == nil {
+		s.jsonError(w, "webhooks are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	entries, err := s.webhooks.Deliveries(owner, name, r.URL.Query().Get("hook"))
+	if err != nil {
+		s.jsonError(w, fmt.Sprintf("read deliveries failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	id := r.URL.Query().Get("delivery")
+	kept := entries[:0]
+	for _, e := range entries {
+		if id == "" {
+			e.Payload = nil
+		} else if e.Delivery != id {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if id != "" && len(kept) == 0 {
+		s.jsonError(w, "delivery not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"deliveries": kept,
+	})
+}
+
+// handleWebhookRedeliver sends a logged delivery's payload to its webhook
+// again, as a new delivery.
+//
+//	POST /api/v1/repos/webhooks/redeliver {"owner", "name", "delivery"}
+func (s *Server) handleWebhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Owner    string `json:"owner"`
+		Name     string `json:"name"`
+		Delivery string `json:"delivery"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Owner == "" || req.Name == "" || req.Delivery == "" {
+		s.jsonError(w, "owner, name and delivery required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkRepoOwner(w, r, req.Owner, req.Name) {
+		return
+	}
+	if s.webhooks == nil {
+		s.jsonError(w, "webhooks are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	id, err := s.webhooks.Redeliver(req.Owner, req.Name, req.Delivery)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, webhooks.ErrUnknownDelivery) {
+			status = http.StatusNotFound
+		}
+		s.jsonError(w, fmt.Sprintf("redeliver failed: %v", err), status)
+		return
+	}
+	s.audit(r, audit.Entry{Actor: req.Owner, Action: "repo.webhook-redeliver", Target: req.Owner + "/" + req.Name, Detail: req.Delivery + " as " + id})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"delivery": id,
+	})
+}
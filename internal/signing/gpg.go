@@ -0,0 +1,155 @@
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// dearmorKey decodes an ASCII-armored OpenPGP public key into its packets.
+func dearmorKey(key string) ([]byte, error) {
+	block, err := armor.Decode(strings.NewReader(key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenPGP key: %w", err)
+	}
+	if block.Type != "PGP PUBLIC KEY BLOCK" {
+		return nil, fmt.Errorf("invalid OpenPGP key: %s block", block.Type)
+	}
+	packets, err := io.ReadAll(block.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenPGP key: %w", err)
+	}
+	return packets, nil
+}
+
+// gpgFingerprint returns the fingerprint of the primary key, which must come
+// first, in OpenPGP packets. It's parsed by hand, rather than with
+// x/crypto/openpgp, because that doesn't know Ed25519 keys, which GnuPG now
+// makes by default; only the packet framing matters here.
+func gpgFingerprint(packets []byte) (string, error) {
+	if len(packets) < 2 || packets[0]&0x80 == 0 {
+		return "", fmt.Errorf("invalid OpenPGP key: not a packet")
+	}
+
+	var tag byte
+	var length, header int
+	if b := packets[0]; b&0x40 != 0 {
+		tag = b & 0x3f
+		switch l := int(packets[1]); {
+		case l < 192:
+			length, header = l, 2
+		case l < 224 && len(packets) >= 3:
+			length, header = (l-192)<<8+int(packets[2])+192, 3
+		case l == 255 && len(packets) >= 6:
+			length, header = int(binary.BigEndian.Uint32(packets[2:6])), 6
+		default:
+			return "", fmt.Errorf("invalid OpenPGP key: bad packet length")
+		}
+	} else {
+		tag = (b >> 2) & 0x0f
+		switch b & 0x03 {
+		case 0:
+			length, header = int(packets[1]), 2
+		case 1:
+			if len(packets) >= 3 {
+				length, header = int(binary.BigEndian.Uint16(packets[1:3])), 3
+			}
+		case 2:
+			if len(packets) >= 5 {
+				length, header = int(binary.BigEndian.Uint32(packets[1:5])), 5
+			}
+		}
+		if header == 0 {
+			return "", fmt.Errorf("invalid OpenPGP key: bad packet length")
+		}
+	}
+	if tag != 6 {
+		return "", fmt.Errorf("invalid OpenPGP key: does not start with a public key")
+	}
+	if length < 1 || len(packets) < header+length {
+		return "", fmt.Errorf("invalid OpenPGP key: truncated")
+	}
+
+	body := packets[header : header+length]
+	if body[0] != 4 {
+		return "", fmt.Errorf("unsupported OpenPGP key version %d", body[0])
+	}
+	h := sha1.New()
+	h.Write([]byte{0x99, byte(length >> 8), byte(length)})
+	h.Write(body)
+	return fmt.Sprintf("%X", h.Sum(nil)), nil
+}
+
+// verifyGPG checks an armored OpenPGP signature over payload with gpgv,
+// against the registered keys only, and returns the fingerprint of the
+// signer's primary key.
+func (v *Verifier) verifyGPG(payload, armored []byte) (fingerprint, reason string) {
+	if len(v.keyring) == 0 {
+		return "", ReasonUnknownKey
+	}
+	gpgv, err := exec.LookPath("gpgv")
+	if err != nil {
+		return "", ReasonUnsupported
+	}
+
+	dir, err := os.MkdirTemp("", "openhub-gpgv-")
+	if err != nil {
+		return "", ReasonUnsupported
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := filepath.Join(dir, "keyring.gpg")
+	sigFile := filepath.Join(dir, "signature.asc")
+	dataFile := filepath.Join(dir, "payload")
+	for path, data := range map[string][]byte{keyring: v.keyring, sigFile: armored, dataFile: payload} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return "", ReasonUnsupported
+		}
+	}
+
+	// gpgv exits non-zero for anything but a good signature; the status
+	// lines say why.
+	cmd := exec.Command(gpgv, "--homedir", dir, "--status-fd", "1", "--keyring", keyring, sigFile, dataFile)
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", ReasonUnsupported
+	}
+
+	good := false
+	reason = ReasonBadSignature
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimPrefix(scanner.Text(), "[GNUPG:] "))
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "GOODSIG":
+			good = true
+		case "VALIDSIG":
+			// The last field is the primary key's fingerprint; the first
+			// is the subkey that signed.
+			fingerprint = fields[len(fields)-1]
+		case "NO_PUBKEY":
+			reason = ReasonUnknownKey
+		}
+	}
+	if good && fingerprint != "" {
+		if _, ok := v.owners[fingerprint]; ok {
+			return fingerprint, ReasonValid
+		}
+		return fingerprint, ReasonUnknownKey
+	}
+	return fingerprint, reason
+}
@@ -0,0 +1,148 @@
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// splitSignature separates a commit or tag object into the payload that was
+// signed, the armored signature, and the committer's or tagger's email.
+// A commit carries its signature in a gpgsig header, continued on lines
+// starting with a space; a tag has it appended to its message.
+func splitSignature(objType string, object []byte) (payload, sig []byte, email string) {
+	var signed, signature bytes.Buffer
+	var cont *bytes.Buffer // where a header's continuation lines go, if anywhere
+	inHeader := false
+	rest := object
+	for len(rest) > 0 {
+		line, tail, found := bytes.Cut(rest, []byte("\n"))
+		full := rest[:len(line)]
+		if found {
+			full = rest[:len(line)+1]
+		}
+		rest = tail
+
+		if len(line) == 0 {
+			// The headers end here; the message follows.
+			signed.Write(full)
+			break
+		}
+		if inHeader && line[0] == ' ' {
+			if cont != nil {
+				cont.Write(line[1:])
+				cont.WriteByte('\n')
+			}
+			continue
+		}
+		inHeader = false
+
+		if objType == "commit" {
+			if v, ok := bytes.CutPrefix(line, []byte("gpgsig ")); ok {
+				inHeader, cont = true, &signature
+				signature.Write(v)
+				signature.WriteByte('\n')
+				continue
+			}
+			// A commit signed for both hash functions also has this header,
+			// which neither signature covers.
+			if bytes.HasPrefix(line, []byte("gpgsig-sha256 ")) {
+				inHeader, cont = true, nil
+				continue
+			}
+		}
+		if v, ok := bytes.CutPrefix(line, []byte("committer ")); ok && objType == "commit" {
+			email = identityEmail(string(v))
+		}
+		if v, ok := bytes.CutPrefix(line, []byte("tagger ")); ok && objType == "tag" {
+			email = identityEmail(string(v))
+		}
+		signed.Write(full)
+	}
+
+	if objType == "commit" {
+		signed.Write(rest)
+		return signed.Bytes(), signature.Bytes(), email
+	}
+
+	// A tag's signature starts on a line of its own at the end of the
+	// message.
+	message := rest
+	i := -1
+	for _, marker := range []string{"-----BEGIN PGP SIGNATURE-----", "-----BEGIN SSH SIGNATURE-----"} {
+		j := bytes.LastIndex(message, []byte(marker))
+		if j > i && (j == 0 || message[j-1] == '\n') {
+			i = j
+		}
+	}
+	if i < 0 {
+		signed.Write(message)
+		return signed.Bytes(), nil, email
+	}
+	signed.Write(message[:i])
+	return signed.Bytes(), message[i:], email
+}
+
+// identityEmail returns the email of a "Name <email> time zone" identity.
+func identityEmail(ident string) string {
+	start := strings.IndexByte(ident, '<')
+	end := strings.LastIndexByte(ident, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	return ident[start+1 : end]
+}
+
+// readObjects reads the objects named by shas from the repository at
+// repoPath with one git cat-file process, calling fn with each one's index,
+// type and contents. Missing objects have the type "missing".
+func readObjects(repoPath string, shas []string, fn func(i int, objType string, object []byte)) error {
+	if len(shas) == 0 {
+		return nil
+	}
+
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(strings.Join(shas, "\n") + "\n")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("git cat-file: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("git cat-file: %w", err)
+	}
+
+	r := bufio.NewReader(stdout)
+	for i := range shas {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			cmd.Wait()
+			return fmt.Errorf("git cat-file: %w", err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			fn(i, "missing", nil)
+			continue
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			cmd.Wait()
+			return fmt.Errorf("git cat-file: unexpected output %q", header)
+		}
+		object := make([]byte, size+1) // and the newline after it
+		if _, err := io.ReadFull(r, object); err != nil {
+			cmd.Wait()
+			return fmt.Errorf("git cat-file: %w", err)
+		}
+		fn(i, fields[1], object[:size])
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git cat-file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package signing verifies the signatures on commits and tags against the
+// signing keys users register: SSH signatures, as made with gpg.format=ssh,
+// are checked here, and OpenPGP ones with gpgv.
+package signing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jeremytregunna/openhub/internal/auth"
+	"golang.org/x/crypto/ssh"
+)
+
+// Kinds of signing key.
+const (
+	KindSSH = "ssh"
+	KindGPG = "gpg"
+)
+
+// Why a signature is or isn't verified, as Verification.Reason says.
+const (
+	ReasonValid         = "valid"
+	ReasonUnsigned      = "unsigned"
+	ReasonUnknownKey    = "unknown_key"
+	ReasonBadSignature  = "bad_signature"
+	ReasonEmailMismatch = "email_mismatch"
+	ReasonUnsupported   = "unsupported"
+)
+
+// Verification is what checking a commit's or tag's signature found. It is
+// only verified if the signature is good, made with a key a user
+// registered, and the committer or tagger email is that user's.
+type Verification struct {
+	Verified bool   `json:"verified"`
+	Reason   string `json:"reason"`
+	// Signer is the user whose key made the signature, and Key its
+	// fingerprint; they are set for a good signature even if the email
+	// doesn't match.
+	Signer string `json:"signer,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+// ParseKey checks that key is an SSH public key in authorized_keys format or
+// an ASCII-armored OpenPGP public key, and returns its kind and fingerprint.
+func ParseKey(key string) (kind, fingerprint string, err error) {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		packets, err := dearmorKey(key)
+		if err != nil {
+			return "", "", err
+		}
+		fingerprint, err := gpgFingerprint(packets)
+		if err != nil {
+			return "", "", err
+		}
+		return KindGPG, fingerprint, nil
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return "", "", fmt.Errorf("not an SSH or OpenPGP public key")
+	}
+	return KindSSH, ssh.FingerprintSHA256(pub), nil
+}
+
+// keyOwner is the user a registered signing key belongs to.
+type keyOwner struct {
+	username string
+	email    string
+}
+
+// Verifier checks signatures against the signing keys of a set of users.
+type Verifier struct {
+	owners map[string]keyOwner // by key fingerprint
+	// keyring holds the users' OpenPGP keys, dearmored, for gpgv.
+	keyring []byte
+}
+
+// NewVerifier returns a Verifier for the signing keys users registered.
+// Keys that no longer parse are ignored.
+func NewVerifier(users []auth.User) *Verifier {
+	v := &Verifier{owners: make(map[string]keyOwner)}
+	for _, u := range users {
+		for _, k := range u.SigningKeys {
+			fingerprint := k.Fingerprint
+			if k.Kind == KindGPG {
+				packets, err := dearmorKey(k.Key)
+				if err != nil {
+					continue
+				}
+				if fingerprint, err = gpgFingerprint(packets); err != nil {
+					continue
+				}
+				v.keyring = append(v.keyring, packets...)
+			}
+			v.owners[fingerprint] = keyOwner{username: u.Username, email: u.Email}
+		}
+	}
+	return v
+}
+
+// Verify checks the signature of a raw commit or tag object, of type
+// objType.
+func (v *Verifier) Verify(objType string, object []byte) Verification {
+	payload, sig, email := splitSignature(objType, object)
+
+	var fingerprint, reason string
+	switch {
+	case len(sig) == 0:
+		return Verification{Reason: ReasonUnsigned}
+	case strings.HasPrefix(string(sig), "-----BEGIN SSH SIGNATURE-----"):
+		fingerprint, reason = v.verifySSH(payload, sig)
+	case strings.HasPrefix(string(sig), "-----BEGIN PGP SIGNATURE-----"):
+		fingerprint, reason = v.verifyGPG(payload, sig)
+	default:
+		// X.509 signatures, for one, aren't checked.
+		return Verification{Reason: ReasonUnsupported}
+	}
+	if reason != ReasonValid {
+		return Verification{Reason: reason, Key: fingerprint}
+	}
+
+	owner := v.owners[fingerprint]
+	result := Verification{Reason: ReasonValid, Signer: owner.username, Key: fingerprint}
+	if owner.email == "" || !strings.EqualFold(owner.email, email) {
+		result.Reason = ReasonEmailMismatch
+		return result
+	}
+	result.Verified = true
+	return result
+}
+
+// VerifyObjects checks the signatures of the commits and tags named by shas
+// in the repository at repoPath, in order. Objects that don't exist or
+// aren't commits or tags come back unsigned.
+func (v *Verifier) VerifyObjects(repoPath string, shas []string) ([]Verification, error) {
+	results := make([]Verification, len(shas))
+	err := readObjects(repoPath, shas, func(i int, objType string, object []byte) {
+		if objType == "commit" || objType == "tag" {
+			results[i] = v.Verify(objType, object)
+		} else {
+			results[i] = Verification{Reason: ReasonUnsigned}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,90 @@
+package signing
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshSigMagic starts both an SSH signature and the data it signs; see
+// PROTOCOL.sshsig in OpenSSH.
+const sshSigMagic = "SSHSIG"
+
+// sshSignature is an SSH signature blob after its magic.
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is what an SSH signature signs, after the magic.
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// verifySSH checks an armored SSH signature over payload, made in git's
+// namespace, and returns the signing key's fingerprint.
+func (v *Verifier) verifySSH(payload, armored []byte) (fingerprint, reason string) {
+	var b64 strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(string(armored)), "\n") {
+		if !strings.HasPrefix(line, "-----") {
+			b64.WriteString(strings.TrimSpace(line))
+		}
+	}
+	blob, err := base64.StdEncoding.DecodeString(b64.String())
+	if err != nil || !strings.HasPrefix(string(blob), sshSigMagic) {
+		return "", ReasonBadSignature
+	}
+
+	var sig sshSignature
+	if err := ssh.Unmarshal(blob[len(sshSigMagic):], &sig); err != nil || sig.Version != 1 {
+		return "", ReasonBadSignature
+	}
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return "", ReasonBadSignature
+	}
+	fingerprint = ssh.FingerprintSHA256(pub)
+	if _, ok := v.owners[fingerprint]; !ok {
+		return fingerprint, ReasonUnknownKey
+	}
+	if sig.Namespace != "git" {
+		return fingerprint, ReasonBadSignature
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fingerprint, ReasonBadSignature
+	}
+	h.Write(payload)
+
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &signature); err != nil {
+		return fingerprint, ReasonBadSignature
+	}
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...)
+	if err := pub.Verify(signed, &signature); err != nil {
+		return fingerprint, ReasonBadSignature
+	}
+	return fingerprint, ReasonValid
+}
@@ -0,0 +1,156 @@
+package statuses
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	StatePending = "pending"
+	StateSuccess = "success"
+	StateFailure = "failure"
+)
+
+const (
+	DefaultContext       = "default"
+	MaxContextLength     = 255
+	MaxDescriptionLength = 1024
+)
+
+func ValidState(state string) bool {
+	return state == StatePending || state == StateSuccess || state == StateFailure
+}
+
+// Status is a check's verdict on a commit, as reported by a CI system. A
+// commit keeps the latest status for each context.
+type Status struct {
+	Context     string `json:"context"`
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Creator     string `json:"creator"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Combined reduces a commit's statuses to one state: failure if any check
+// failed, pending if any is still running, and success only if every check
+// passed. A commit without statuses has no combined state.
+func Combined(list []Status) string {
+	if len(list) == 0 {
+		return ""
+	}
+	state := StateSuccess
+	for _, st := range list {
+		switch st.State {
+		case StateFailure:
+			return StateFailure
+		case StatePending:
+			state = StatePending
+		}
+	}
+	return state
+}
+
+// Store keeps each commit's statuses as one JSON file under
+// <repo>.git/statuses.
+type Store struct {
+	storage *storage.Storage
+	mu      sync.Mutex
+}
+
+func NewStore(store *storage.Storage) *Store {
+	return &Store{storage: store}
+}
+
+func (s *Store) path(owner, repo, commit string) string {
+	return filepath.Join(s.storage.RepoPath(owner, repo), "statuses", commit+".json")
+}
+
+// Set records a status for a commit, replacing any earlier status with the
+// same context.
+func (s *Store) Set(owner, repo, commit string, status Status) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, err := s.read(owner, repo, commit)
+	if err != nil {
+		return status, err
+	}
+
+	now := time.Now()
+	status.CreatedAt = now
+	status.UpdatedAt = now
+
+	replaced := false
+	for i := range list {
+		if list[i].Context == status.Context {
+			status.CreatedAt = list[i].CreatedAt
+			list[i] = status
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		list = append(list, status)
+	}
+
+	if err := s.write(owner, repo, commit, list); err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+// List returns a commit's statuses ordered by context.
+func (s *Store) List(owner, repo, commit string) ([]Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(owner, repo, commit)
+}
+
+func (s *Store) read(owner, repo, commit string) ([]Status, error) {
+	data, err := os.ReadFile(s.path(owner, repo, commit))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read statuses: %w", err)
+	}
+
+	var list []Status
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unmarshal statuses: %w", err)
+	}
+	return list, nil
+}
+
+func (s *Store) write(owner, repo, commit string, list []Status) error {
+	sort.Slice(list, func(i, j int) bool { return list[i].Context < list[j].Context })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal statuses: %w", err)
+	}
+
+	path := s.path(owner, repo, commit)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create statuses dir: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write statuses: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write statuses: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPathNotFound is returned when a path doesn't exist at a revision.
+var ErrPathNotFound = errors.New("path not found")
+
+// TreeEntry is a file, directory or submodule in a repository's tree.
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Type is "blob" for a file, "tree" for a directory and "commit" for
+	// a submodule.
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+	SHA  string `json:"sha"`
+	// Size is a file's size in bytes; it is zero for the other types.
+	Size int64 `json:"size"`
+}
+
+// Commit is one commit in a repository's history.
+type Commit struct {
+	SHA         string    `json:"sha"`
+	Parents     []string  `json:"parents"`
+	Author      string    `json:"author"`
+	AuthorEmail string    `json:"author_email"`
+	Date        time.Time `json:"date"`
+	Subject     string    `json:"subject"`
+	Body        string    `json:"body,omitempty"`
+}
+
+// ListTree lists the directory at path in commit rev, directories first.
+// An empty path is the root of the tree.
+func (s *Storage) ListTree(owner, name, rev, path string) ([]TreeEntry, error) {
+	cmd := exec.Command("git", "ls-tree", "-z", "--long", rev+":"+path)
+	cmd.Dir = s.RepoPath(owner, name)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, ErrPathNotFound
+	}
+
+	entries, err := parseTree(out, path)
+	if err != nil {
+		return nil, err
+	}
+	dirsFirst := func(t string) int {
+		if t == "tree" {
+			return 0
+		}
+		return 1
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if dirsFirst(a.Type) != dirsFirst(b.Type) {
+			return dirsFirst(a.Type) < dirsFirst(b.Type)
+		}
+		return a.Name < b.Name
+	})
+	return entries, nil
+}
+
+// StatFile describes the entry at path in commit rev.
+func (s *Storage) StatFile(owner, name, rev, path string) (TreeEntry, error) {
+	dir, base := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		dir, base = path[:i], path[i+1:]
+	}
+
+	cmd := exec.Command("git", "ls-tree", "-z", "--long", rev+":"+dir, "--", base)
+	cmd.Dir = s.RepoPath(owner, name)
+	out, err := cmd.Output()
+	if err != nil {
+		return TreeEntry{}, ErrPathNotFound
+	}
+	entries, err := parseTree(out, dir)
+	if err != nil {
+		return TreeEntry{}, err
+	}
+	if len(entries) != 1 {
+		return TreeEntry{}, ErrPathNotFound
+	}
+	return entries[0], nil
+}
+
+// CopyFile writes the contents of the file at path in commit rev to w.
+func (s *Storage) CopyFile(owner, name, rev, path string, w io.Writer) error {
+	cmd := exec.Command("git", "cat-file", "blob", rev+":"+path)
+	cmd.Dir = s.RepoPath(owner, name)
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git cat-file: %w", err)
+	}
+	return nil
+}
+
+// parseTree parses the output of "git ls-tree -z --long" for the directory
+// dir.
+func parseTree(out []byte, dir string) ([]TreeEntry, error) {
+	entries := []TreeEntry{}
+	for _, rec := range bytes.Split(bytes.TrimSuffix(out, []byte{0}), []byte{0}) {
+		if len(rec) == 0 {
+			continue
+		}
+		info, name, ok := strings.Cut(string(rec), "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 4 {
+			return nil, fmt.Errorf("git ls-tree: unexpected output %q", rec)
+		}
+
+		e := TreeEntry{Name: name, Path: name, Mode: fields[0], Type: fields[1], SHA: fields[2]}
+		if dir != "" {
+			e.Path = dir + "/" + name
+		}
+		if e.Type == "blob" {
+			e.Size, _ = strconv.ParseInt(fields[3], 10, 64)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Log returns the commits reachable from rev, newest first, skipping the
+// first skip and returning at most limit. With a path, only commits that
+// changed it are included.
+func (s *Storage) Log(owner, name, rev, path string, skip, limit int) ([]Commit, error) {
+	args := []string{"log", "-z", "--format=%H%x1f%P%x1f%an%x1f%ae%x1f%aI%x1f%s%x1f%b",
+		"--skip=" + strconv.Itoa(skip), "--max-count=" + strconv.Itoa(limit), rev, "--"}
+	if path != "" {
+		args = append(args, path)
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.RepoPath(owner, name)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log: %w", err)
+	}
+
+	commits := []Commit{}
+	for _, rec := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if rec == "" {
+			continue
+		}
+		f := strings.SplitN(rec, "\x1f", 7)
+		if len(f) != 7 {
+			return nil, fmt.Errorf("git log: unexpected output %q", rec)
+		}
+		date, _ := time.Parse(time.RFC3339, f[4])
+		commits = append(commits, Commit{
+			SHA:         f[0],
+			Parents:     strings.Fields(f[1]),
+			Author:      f[2],
+			AuthorEmail: f[3],
+			Date:        date,
+			Subject:     f[5],
+			Body:        strings.TrimSpace(f[6]),
+		})
+	}
+	return commits, nil
+}
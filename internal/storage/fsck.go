@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// FsckResult is what git fsck found in one repository.
+type FsckResult struct {
+	Owner string `json:"owner"`
+	Name  string `json:"name"`
+	// Problems are the lines fsck reported; none means the repository is
+	// sound.
+	Problems []string `json:"problems,omitempty"`
+	// Error is set when fsck couldn't be run at all.
+	Error string `json:"error,omitempty"`
+}
+
+// OK reports whether fsck ran and found nothing wrong.
+func (r FsckResult) OK() bool {
+	return r.Error == "" && len(r.Problems) == 0
+}
+
+// Fsck checks the connectivity and validity of every object in a
+// repository, returning the problems found. Dangling objects, which are
+// routine after force pushes, aren't problems.
+func (s *Storage) Fsck(owner, name string) ([]string, error) {
+	if !s.RepoExists(owner, name) {
+		return nil, fmt.Errorf("repo does not exist: %s/%s", owner, name)
+	}
+
+	cmd := exec.Command("git", "fsck", "--full", "--strict", "--no-dangling", "--no-progress")
+	cmd.Dir = s.RepoPath(owner, name)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("git fsck: %w", err)
+	}
+
+	// fsck exits non-zero only when it finds something wrong. Notices,
+	// such as HEAD naming a branch that hasn't been pushed yet, aren't.
+	var problems []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" && !strings.HasPrefix(line, "notice:") {
+			problems = append(problems, line)
+		}
+	}
+	if len(problems) == 0 {
+		problems = append(problems, fmt.Sprintf("git fsck: %v", err))
+	}
+	return problems, nil
+}
+
+// FsckRepos runs Fsck on repos with at most workers at a time, calling
+// each, if set, as each one finishes. Results are in the order of repos.
+func (s *Storage) FsckRepos(repos []Repo, workers int, each func(FsckResult)) []FsckResult {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]FsckResult, len(repos))
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	sem := make(chan struct{}, workers)
+
+	for i, r := range repos {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, r Repo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := FsckResult{Owner: r.Owner, Name: r.Name}
+			problems, err := s.Fsck(r.Owner, r.Name)
+			if err != nil {
+				result.Error = err.Error()
+			}
+			result.Problems = problems
+			results[i] = result
+
+			if each != nil {
+				mu.Lock()
+				each(result)
+				mu.Unlock()
+			}
+		}(i, r)
+	}
+
+	wg.Wait()
+	return results
+}
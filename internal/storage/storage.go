@@ -1,17 +1,26 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Storage struct {
 	basePath string
+
+	// mu serializes metadata writes so UpdateMetadata's read-modify-write
+	// can't lose a concurrent change.
+	mu sync.Mutex
 }
 
 func New(basePath string) (*Storage, error) {
@@ -75,23 +84,107 @@ func (s *Storage) DeleteRepo(owner, name string) error {
 	return nil
 }
 
+// MoveRepo renames a repository, along with its issues, pull requests,
+// releases and everything else stored with it.
+func (s *Storage) MoveRepo(owner, name, newOwner, newName string) error {
+	if !s.RepoExists(owner, name) {
+		return fmt.Errorf("repo does not exist: %s/%s", owner, name)
+	}
+	if s.RepoExists(newOwner, newName) {
+		return fmt.Errorf("repo already exists: %s/%s", newOwner, newName)
+	}
+
+	path := s.RepoPath(newOwner, newName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create owner dir: %w", err)
+	}
+	if err := os.Rename(s.RepoPath(owner, name), path); err != nil {
+		return fmt.Errorf("move repo: %w", err)
+	}
+
+	return nil
+}
+
 type Repo struct {
 	Owner string `json:"owner"`
 	Name  string `json:"name"`
 }
 
 type Replica struct {
-	InstanceID    string    `json:"instance_id"`
-	URL           string    `json:"url"`
-	Token         string    `json:"token"`
-	InvitationKey string    `json:"invitation_key"`
-	Enabled       bool      `json:"enabled"`
-	LastSynced    time.Time `json:"last_synced,omitempty"`
+	InstanceID    string            `json:"instance_id"`
+	URL           string            `json:"url"`
+	Token         string            `json:"token"`
+	InvitationKey string            `json:"invitation_key"`
+	Enabled       bool              `json:"enabled"`
+	LastSynced    time.Time         `json:"last_synced,omitempty"`
+	LastAttempt   time.Time         `json:"last_attempt,omitempty"`
+	LastError     string            `json:"last_error,omitempty"`
+	SyncedRefs    map[string]string `json:"synced_refs,omitempty"`
+	Refs          []string          `json:"refs,omitempty"`
+	// AllowChain lets the replica replicate the repo on to instances of
+	// its own.
+	AllowChain bool `json:"allow_chain,omitempty"`
+	// PeerID is the replica instance's own ID, learned in the federation
+	// handshake. Replicas added before handshakes existed have none.
+	PeerID string `json:"peer_id,omitempty"`
+	// Pending is set while the replica's admin has yet to accept the
+	// repository; the replica stays disabled until they do.
+	Pending bool `json:"pending,omitempty"`
 }
 
 type ReplicaSource struct {
-	InstanceID    string `json:"instance_id"`
-	InvitationKey string `json:"invitation_key"`
+	InstanceID string `json:"instance_id"`
+	// InvitationKeyHash is the hash of the key the origin proves itself
+	// with. InvitationKey holds the key itself in metadata written before
+	// keys were hashed, until the origin next replicates.
+	InvitationKeyHash string   `json:"invitation_key_hash,omitempty"`
+	InvitationKey     string   `json:"invitation_key,omitempty"`
+	Refs              []string `json:"refs,omitempty"`
+	// AllowChain records that the origin consented to this replica having
+	// replicas of its own.
+	AllowChain bool `json:"allow_chain,omitempty"`
+}
+
+// ChainAllowed reports whether the repo may be replicated from this instance:
+// it is the origin, or a replica the origin allowed to chain.
+func (m Metadata) ChainAllowed() bool {
+	return m.ReplicaOf == nil || m.ReplicaOf.AllowChain
+}
+
+// Policy holds the push rules enforced by the pre-receive hook.
+type Policy struct {
+	BranchPattern       string   `json:"branch_pattern,omitempty"`
+	ConventionalCommits bool     `json:"conventional_commits,omitempty"`
+	MaxSubjectLength    int      `json:"max_subject_length,omitempty"`
+	ProtectedBranches   []string `json:"protected_branches,omitempty"`
+	// SignedBranches are refs, or globs, whose pushed commits must all
+	// carry a verified signature.
+	SignedBranches []string `json:"signed_branches,omitempty"`
+	// ScanSecrets and MaxBlobMB override the instance's push scanning:
+	// unset inherits it, and a negative MaxBlobMB allows files of any size.
+	ScanSecrets *bool `json:"scan_secrets,omitempty"`
+	MaxBlobMB   int   `json:"max_blob_mb,omitempty"`
+
+	ReviewRules []ReviewRule `json:"review_rules,omitempty"`
+}
+
+// Webhook posts the repository's events to an external service, signed
+// with Secret when one is set.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReviewRule requires reviews before a pull request into a branch matching
+// Branch can merge.
+type ReviewRule struct {
+	Branch            string   `json:"branch"`
+	RequiredApprovals int      `json:"required_approvals,omitempty"`
+	RequiredReviewers []string `json:"required_reviewers,omitempty"`
 }
 
 type Metadata struct {
@@ -101,6 +194,72 @@ type Metadata struct {
 	CreatedAt     time.Time      `json:"created_at"`
 	Replicas      []Replica      `json:"replicas,omitempty"`
 	ReplicaOf     *ReplicaSource `json:"replica_of,omitempty"`
+	Policy        *Policy        `json:"policy,omitempty"`
+	Webhooks      []Webhook      `json:"webhooks,omitempty"`
+	// Mirrors is set on replicas: the other full copies of the repository
+	// the upstream instance knew of at its last sync.
+	Mirrors []Mirror `json:"mirrors,omitempty"`
+	// ForkOf is the "owner/name" of the repository on this instance this
+	// one was forked from.
+	ForkOf string `json:"fork_of,omitempty"`
+	// Topics are short lowercase labels for finding related repositories.
+	Topics []string `json:"topics,omitempty"`
+	// Upstream is set on repositories mirrored from another forge, which
+	// are kept in sync by fetching from it rather than pushed to.
+	Upstream *Upstream `json:"upstream,omitempty"`
+	// AllowedIPs, if set, are the only addresses and CIDR ranges the
+	// repository can be read or pushed from.
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+}
+
+// Upstream is the repository on another forge a mirror fetches from, and
+// how its last sync went.
+type Upstream struct {
+	URL string `json:"url"`
+	// Token authenticates fetches of private repositories.
+	Token       string    `json:"token,omitempty"`
+	LastSynced  time.Time `json:"last_synced,omitempty"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Mirror is a full copy of a repository on another instance, published so
+// clients can fail over to it when the origin is down.
+type Mirror struct {
+	URL        string    `json:"url"`
+	InstanceID string    `json:"instance_id,omitempty"`
+	LastSynced time.Time `json:"last_synced"`
+	// Healthy is false when the mirror's most recent sync failed, so it may
+	// lag behind.
+	Healthy bool `json:"healthy"`
+}
+
+// KnownMirrors returns the mirrors this instance knows of: those it was
+// told about by its upstream, followed by its own replicas that hold every
+// ref and have synced at least once. Replicas filtered to some refs aren't
+// mirrors, since a client failing over to one would miss branches.
+func (m Metadata) KnownMirrors() []Mirror {
+	seen := make(map[string]bool)
+	var mirrors []Mirror
+	for _, mirror := range m.Mirrors {
+		if !seen[mirror.URL] {
+			seen[mirror.URL] = true
+			mirrors = append(mirrors, mirror)
+		}
+	}
+	for _, r := range m.Replicas {
+		if !r.Enabled || len(r.Refs) > 0 || r.LastSynced.IsZero() || seen[r.URL] {
+			continue
+		}
+		seen[r.URL] = true
+		mirrors = append(mirrors, Mirror{
+			URL:        r.URL,
+			InstanceID: r.PeerID,
+			LastSynced: r.LastSynced,
+			Healthy:    r.LastError == "",
+		})
+	}
+	return mirrors
 }
 
 func (s *Storage) ListRepos() ([]Repo, error) {
@@ -141,6 +300,58 @@ func (s *Storage) ListRepos() ([]Repo, error) {
 	return repos, nil
 }
 
+// OwnerSize returns the disk space used by an owner's repositories,
+// including their release assets.
+func (s *Storage) OwnerSize(owner string) (int64, error) {
+	total, err := dirSize(filepath.Join(s.basePath, owner))
+	if err != nil {
+		return 0, fmt.Errorf("measure %s: %w", owner, err)
+	}
+	return total, nil
+}
+
+// Size returns the disk space used by the whole storage directory:
+// repositories, release assets, users and everything else kept there.
+func (s *Storage) Size() (int64, error) {
+	total, err := dirSize(s.basePath)
+	if err != nil {
+		return 0, fmt.Errorf("measure storage: %w", err)
+	}
+	return total, nil
+}
+
+// RepoSize returns the disk space used by a repository.
+func (s *Storage) RepoSize(owner, name string) (int64, error) {
+	total, err := dirSize(s.RepoPath(owner, name))
+	if err != nil {
+		return 0, fmt.Errorf("measure %s/%s: %w", owner, name, err)
+	}
+	return total, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Objects can be pruned out from under the walk.
+			return nil
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
 func (s *Storage) ListReposByOwner(owner string) ([]Repo, error) {
 	var repos []Repo
 
@@ -204,6 +415,28 @@ func (s *Storage) GetMetadata(owner, name string) (Metadata, error) {
 }
 
 func (s *Storage) SetMetadata(owner, name string, meta Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeMetadata(owner, name, meta)
+}
+
+// UpdateMetadata atomically applies fn to the repo's current metadata. Use it
+// instead of GetMetadata+SetMetadata when other writers may be active.
+func (s *Storage) UpdateMetadata(owner, name string, fn func(*Metadata) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.GetMetadata(owner, name)
+	if err != nil {
+		return err
+	}
+	if err := fn(&meta); err != nil {
+		return err
+	}
+	return s.writeMetadata(owner, name, meta)
+}
+
+func (s *Storage) writeMetadata(owner, name string, meta Metadata) error {
 	if !s.RepoExists(owner, name) {
 		return fmt.Errorf("repo does not exist: %s/%s", owner, name)
 	}
@@ -220,3 +453,509 @@ func (s *Storage) SetMetadata(owner, name string, meta Metadata) error {
 
 	return nil
 }
+
+func (s *Storage) releaseDir(owner, name, tag string) string {
+	return filepath.Join(s.RepoPath(owner, name), "releases", tag)
+}
+
+// ReleaseAssetsDir is the directory a release's uploaded assets are
+// published into.
+func (s *Storage) ReleaseAssetsDir(owner, name, tag string) string {
+	return filepath.Join(s.releaseDir(owner, name, tag), "assets")
+}
+
+func (s *Storage) ReleaseAssetPath(owner, name, tag, asset string) string {
+	return filepath.Join(s.ReleaseAssetsDir(owner, name, tag), asset)
+}
+
+// ReleaseSize returns the total size of the assets stored for a release.
+func (s *Storage) ReleaseSize(owner, name, tag string) (int64, error) {
+	entries, err := os.ReadDir(s.ReleaseAssetsDir(owner, name, tag))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("read release assets: %w", err)
+	}
+
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+func (s *Storage) ListRefs(owner, name string) (map[string]string, error) {
+	if !s.RepoExists(owner, name) {
+		return nil, fmt.Errorf("repo does not exist: %s/%s", owner, name)
+	}
+
+	cmd := exec.Command("git", "for-each-ref", "--format=%(objectname) %(refname)")
+	cmd.Dir = s.RepoPath(owner, name)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref: %w", err)
+	}
+
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		sha, ref, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		refs[ref] = sha
+	}
+
+	return refs, nil
+}
+
+type RefChange struct {
+	Ref string `json:"ref"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new"`
+}
+
+type BundleResult struct {
+	Updated  []RefChange `json:"updated"`
+	Forced   []RefChange `json:"forced,omitempty"`
+	Diverged []RefChange `json:"diverged,omitempty"`
+}
+
+// ErrInvalidBundle is returned for a bundle from an origin that git doesn't
+// accept or that builds on commits the repository lacks.
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// ApplyBundle imports a bundle's objects and moves the repo's refs matching
+// patterns (all refs when empty) to the bundle's heads. A ref whose current
+// value is not an ancestor of the incoming one has diverged; it is left alone
+// and reported unless force is set. All updates are applied atomically.
+func (s *Storage) ApplyBundle(owner, name, bundlePath string, patterns []string, force bool) (BundleResult, error) {
+	return s.applyBundle(owner, name, bundlePath, patterns, force, false)
+}
+
+// ApplyReplicaBundle is ApplyBundle for a bundle a replica receives from its
+// origin. The bundle is untrusted, so git checks it first, along with its
+// prerequisites against the commits the repository's refs already reach,
+// and only branches and tags are taken from it.
+func (s *Storage) ApplyReplicaBundle(owner, name, bundlePath string, patterns []string, force bool) (BundleResult, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "bundle", "verify", bundlePath)
+	cmd.Dir = s.RepoPath(owner, name)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return BundleResult{}, fmt.Errorf("%w: %s", ErrInvalidBundle, strings.ReplaceAll(strings.TrimSpace(stderr.String()), "\n", " "))
+	}
+	return s.applyBundle(owner, name, bundlePath, patterns, force, true)
+}
+
+func (s *Storage) applyBundle(owner, name, bundlePath string, patterns []string, force, branchesAndTags bool) (BundleResult, error) {
+	var result BundleResult
+	repoPath := s.RepoPath(owner, name)
+
+	cmd := exec.Command("git", "bundle", "unbundle", bundlePath)
+	cmd.Dir = repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		return result, fmt.Errorf("git bundle unbundle: %w", err)
+	}
+
+	incoming := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		sha, ref, ok := strings.Cut(line, " ")
+		if !ok || !strings.HasPrefix(ref, "refs/") {
+			continue
+		}
+		if branchesAndTags && !strings.HasPrefix(ref, "refs/heads/") && !strings.HasPrefix(ref, "refs/tags/") {
+			continue
+		}
+		incoming[ref] = sha
+	}
+	incoming = FilterRefs(incoming, patterns)
+
+	local, err := s.ListRefs(owner, name)
+	if err != nil {
+		return result, err
+	}
+
+	names := make([]string, 0, len(incoming))
+	for ref := range incoming {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+
+	var updates strings.Builder
+	for _, ref := range names {
+		change := RefChange{Ref: ref, Old: local[ref], New: incoming[ref]}
+		if change.Old == change.New {
+			continue
+		}
+
+		fastForward := change.Old == "" || isAncestor(repoPath, change.Old, change.New)
+		switch {
+		case fastForward:
+			result.Updated = append(result.Updated, change)
+		case force:
+			result.Forced = append(result.Forced, change)
+		default:
+			result.Diverged = append(result.Diverged, change)
+			continue
+		}
+
+		old := change.Old
+		if old == "" {
+			old = strings.Repeat("0", len(change.New))
+		}
+		fmt.Fprintf(&updates, "update %s %s %s\n", ref, change.New, old)
+	}
+
+	if updates.Len() == 0 {
+		return result, nil
+	}
+
+	cmd = exec.Command("git", "update-ref", "--stdin")
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(updates.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return result, fmt.Errorf("git update-ref: %w: %s", err, output)
+	}
+
+	return result, nil
+}
+
+// Diff returns the unified diff of head against its merge base with base,
+// like "git diff base...head", optionally limited to paths.
+func (s *Storage) Diff(owner, name, base, head string, paths ...string) (string, error) {
+	args := []string{"diff", "--no-color", "--no-ext-diff", base + "..." + head, "--"}
+	args = append(args, paths...)
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.RepoPath(owner, name)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git diff: %w", err)
+	}
+	return string(out), nil
+}
+
+// WriteBundle writes a git bundle of every ref in the repo to w. The repo
+// must have at least one ref.
+func (s *Storage) WriteBundle(owner, name string, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "bundle", "create", "-", "--all")
+	cmd.Dir = s.RepoPath(owner, name)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git bundle create: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func isAncestor(repoPath, ancestor, descendant string) bool {
+	cmd := exec.Command("git", "merge-base", "--is-ancestor", ancestor, descendant)
+	cmd.Dir = repoPath
+	return cmd.Run() == nil
+}
+
+var ErrMergeConflict = errors.New("merge conflict")
+
+// ResolveCommit returns the commit a ref or revision points at.
+func (s *Storage) ResolveCommit(owner, name, rev string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	cmd.Dir = s.RepoPath(owner, name)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("unknown revision: %s", rev)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (s *Storage) IsAncestor(owner, name, ancestor, descendant string) bool {
+	return isAncestor(s.RepoPath(owner, name), ancestor, descendant)
+}
+
+// MergeCommit creates a merge commit of head into base without a worktree,
+// using git merge-tree. The commit is not referenced by any ref; publish it
+// with UpdateRef. Conflicts are reported as ErrMergeConflict.
+func (s *Storage) MergeCommit(owner, name, base, head, message, author string) (string, error) {
+	repoPath := s.RepoPath(owner, name)
+
+	tree, conflicts, err := mergeTree(repoPath, base, head)
+	if err != nil {
+		return "", err
+	}
+	if len(conflicts) > 0 {
+		return "", fmt.Errorf("%w in %s", ErrMergeConflict, strings.Join(conflicts, ", "))
+	}
+
+	return commitTree(repoPath, tree, message, author, base, head)
+}
+
+// MergeConflicts returns the files that conflict when merging head into
+// base, without writing anything a ref points at. A clean merge returns none.
+func (s *Storage) MergeConflicts(owner, name, base, head string) ([]string, error) {
+	_, conflicts, err := mergeTree(s.RepoPath(owner, name), base, head)
+	return conflicts, err
+}
+
+// mergeTree merges head into base with git merge-tree and returns the
+// resulting tree, or the conflicting files if the merge isn't clean.
+func mergeTree(repoPath, base, head string) (string, []string, error) {
+	cmd := exec.Command("git", "merge-tree", "--write-tree", "--name-only", "--no-messages", base, head)
+	cmd.Dir = repoPath
+	out, err := cmd.Output()
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return "", lines[1:], nil
+		}
+		return "", nil, fmt.Errorf("git merge-tree: %w", err)
+	}
+	return lines[0], nil, nil
+}
+
+// FetchRef copies ref src of repository fromOwner/fromName into this
+// repository as dst, overwriting it, and returns the commit it points at.
+func (s *Storage) FetchRef(owner, name, fromOwner, fromName, src, dst string) (string, error) {
+	cmd := exec.Command("git", "fetch", "--quiet", "--no-tags", s.RepoPath(fromOwner, fromName), "+"+src+":"+dst)
+	cmd.Dir = s.RepoPath(owner, name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("git fetch %s from %s/%s: %w: %s", src, fromOwner, fromName, err, output)
+	}
+	return s.ResolveCommit(owner, name, dst)
+}
+
+// Fork creates newOwner/newName as a copy of owner/name's branches and tags,
+// recording where it came from. Pull requests, issues, replicas and other
+// per-repository state are not copied.
+func (s *Storage) Fork(owner, name, newOwner, newName string) error {
+	meta, err := s.GetMetadata(owner, name)
+	if err != nil {
+		return fmt.Errorf("get metadata: %w", err)
+	}
+
+	if err := s.CreateRepo(newOwner, newName); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("git", "fetch", "--quiet", s.RepoPath(owner, name),
+		"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	cmd.Dir = s.RepoPath(newOwner, newName)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		s.DeleteRepo(newOwner, newName)
+		return fmt.Errorf("git fetch: %w: %s", err, output)
+	}
+
+	cmd = exec.Command("git", "symbolic-ref", "HEAD", "refs/heads/"+meta.DefaultBranch)
+	cmd.Dir = s.RepoPath(newOwner, newName)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		s.DeleteRepo(newOwner, newName)
+		return fmt.Errorf("git symbolic-ref: %w: %s", err, output)
+	}
+
+	err = s.UpdateMetadata(newOwner, newName, func(m *Metadata) error {
+		m.Description = meta.Description
+		m.Private = meta.Private
+		m.DefaultBranch = meta.DefaultBranch
+		m.ForkOf = owner + "/" + name
+		return nil
+	})
+	if err != nil {
+		s.DeleteRepo(newOwner, newName)
+		return fmt.Errorf("set metadata: %w", err)
+	}
+	return nil
+}
+
+// commitTree creates a commit of tree authored by an openhub user and
+// committed by openhub itself.
+func commitTree(repoPath, tree, message, author string, parents ...string) (string, error) {
+	args := []string{"commit-tree", tree, "-m", message}
+	for _, parent := range parents {
+		args = append(args, "-p", parent)
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+author,
+		"GIT_AUTHOR_EMAIL="+author+"@openhub",
+		"GIT_COMMITTER_NAME=openhub",
+		"GIT_COMMITTER_EMAIL=openhub@openhub",
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git commit-tree: %w", err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ReadFile returns the contents of path at rev.
+func (s *Storage) ReadFile(owner, name, rev, path string) ([]byte, error) {
+	cmd := exec.Command("git", "cat-file", "blob", rev+":"+path)
+	cmd.Dir = s.RepoPath(owner, name)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %s", path)
+	}
+	return out, nil
+}
+
+// CommitFiles creates a commit on top of parent that replaces the given
+// files' contents, without a worktree. Existing files keep their mode; new
+// files are created as regular files. Like MergeCommit, the commit is not
+// referenced by any ref until it is published with UpdateRef.
+func (s *Storage) CommitFiles(owner, name, parent string, files map[string][]byte, message, author string) (string, error) {
+	repoPath := s.RepoPath(owner, name)
+
+	index, err := os.CreateTemp("", "openhub-index-*")
+	if err != nil {
+		return "", fmt.Errorf("create index: %w", err)
+	}
+	index.Close()
+	defer os.Remove(index.Name())
+
+	git := func(stdin []byte, args ...string) (string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoPath
+		cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+index.Name())
+		if stdin != nil {
+			cmd.Stdin = bytes.NewReader(stdin)
+		}
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %w", args[0], err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	if _, err := git(nil, "read-tree", parent); err != nil {
+		return "", err
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		mode := "100644"
+		if entry, err := git(nil, "ls-tree", parent, "--", path); err == nil && entry != "" {
+			mode = strings.Fields(entry)[0]
+		}
+
+		blob, err := git(files[path], "hash-object", "-w", "--stdin")
+		if err != nil {
+			return "", err
+		}
+		if _, err := git(nil, "update-index", "--add", "--cacheinfo", mode+","+blob+","+path); err != nil {
+			return "", err
+		}
+	}
+
+	tree, err := git(nil, "write-tree")
+	if err != nil {
+		return "", err
+	}
+
+	return commitTree(repoPath, tree, message, author, parent)
+}
+
+// UpdateRef moves ref to newSHA only if it still points at oldSHA.
+func (s *Storage) UpdateRef(owner, name, ref, newSHA, oldSHA string) error {
+	cmd := exec.Command("git", "update-ref", ref, newSHA, oldSHA)
+	cmd.Dir = s.RepoPath(owner, name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git update-ref: %w: %s", err, output)
+	}
+	return nil
+}
+
+// CountCommitsSince returns how many commits reachable from the repo's refs
+// matching patterns (all refs when empty) are not reachable from the given ref
+// snapshot.
+func (s *Storage) CountCommitsSince(owner, name string, patterns []string, since map[string]string) (int, error) {
+	refs, err := s.ListRefs(owner, name)
+	if err != nil {
+		return 0, err
+	}
+
+	refs = FilterRefs(refs, patterns)
+	if len(refs) == 0 {
+		return 0, nil
+	}
+
+	args := []string{"rev-list", "--count", "--ignore-missing"}
+	for _, sha := range refs {
+		args = append(args, sha)
+	}
+	if len(since) > 0 {
+		args = append(args, "--not")
+		for _, sha := range since {
+			args = append(args, sha)
+		}
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.RepoPath(owner, name)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("git rev-list: %w", err)
+	}
+
+	var count int
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(out)), "%d", &count); err != nil {
+		return 0, fmt.Errorf("parse rev-list output: %w", err)
+	}
+
+	return count, nil
+}
+
+// ValidRefPattern accepts full ref names or refspec-style globs with a single
+// '*', e.g. refs/heads/main or refs/tags/*.
+func ValidRefPattern(pattern string) bool {
+	if !strings.HasPrefix(pattern, "refs/") || strings.Contains(pattern, "..") {
+		return false
+	}
+	if strings.Count(pattern, "*") > 1 {
+		return false
+	}
+	for _, c := range pattern {
+		if c <= ' ' || c == '~' || c == '^' || c == ':' || c == '?' || c == '[' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchRef matches like a git refspec glob: '*' may span path components.
+func MatchRef(pattern, ref string) bool {
+	prefix, suffix, glob := strings.Cut(pattern, "*")
+	if !glob {
+		return pattern == ref
+	}
+	return len(ref) >= len(prefix)+len(suffix) && strings.HasPrefix(ref, prefix) && strings.HasSuffix(ref, suffix)
+}
+
+func FilterRefs(refs map[string]string, patterns []string) map[string]string {
+	if len(patterns) == 0 {
+		return refs
+	}
+
+	filtered := make(map[string]string)
+	for ref, sha := range refs {
+		for _, p := range patterns {
+			if MatchRef(p, ref) {
+				filtered[ref] = sha
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// FetchUpstream makes a repository's branches and tags match u's, deleting
+// those u no longer has. The token, if any, is passed to git in its
+// environment rather than on the command line, where other users could
+// read it.
+func (s *Storage) FetchUpstream(owner, name string, u Upstream) error {
+	if !s.RepoExists(owner, name) {
+		return fmt.Errorf("repo does not exist: %s/%s", owner, name)
+	}
+
+	cmd := exec.Command("git", "fetch", "--quiet", "--prune", u.URL,
+		"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	cmd.Dir = s.RepoPath(owner, name)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if u.Token != "" {
+		// GitHub and Gitea both take a token as the basic auth password.
+		basic := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + u.Token))
+		cmd.Env = append(cmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+basic,
+		)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("fetch %s: %s", u.URL, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package tarpit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/clientip"
+	"github.com/jeremytregunna/openhub/internal/metrics"
+)
+
+var (
+	probesTotal   = metrics.NewCounter("openhub_tarpit_probes_total", "Requests classified as scanner probes.")
+	bannedTotal   = metrics.NewCounter("openhub_tarpit_banned_requests_total", "Requests from shadow-banned clients.")
+	bansTotal     = metrics.NewCounter("openhub_tarpit_bans_total", "Clients shadow-banned after repeated probes.")
+	heldGauge     = metrics.NewGauge("openhub_tarpit_held_connections", "Connections currently being slow-responded.")
+	bannedClients = metrics.NewGauge("openhub_tarpit_banned_clients", "Clients currently shadow-banned.")
+)
+
+// Paths commonly requested by vulnerability scanners. Dot-prefixed segments
+// can never be valid owner names, the rest are unlikely enough to be safe in
+// an opt-in mode.
+var probePrefixes = []string{
+	"/.env",
+	"/.git/",
+	"/.aws/",
+	"/.ssh/",
+	"/wp-admin",
+	"/wp-login",
+	"/phpmyadmin/",
+	"/cgi-bin/",
+	"/server-status",
+	"/vendor/phpunit/",
+}
+
+var probeSuffixes = []string{
+	".php",
+	".asp",
+	".aspx",
+	".jsp",
+	".cgi",
+	"/.env",
+	"/.git/config",
+}
+
+type Config struct {
+	Threshold   int
+	Window      time.Duration
+	BanDuration time.Duration
+	Delay       time.Duration
+	MaxHeld     int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Threshold:   10,
+		Window:      10 * time.Minute,
+		BanDuration: time.Hour,
+		Delay:       15 * time.Second,
+		MaxHeld:     64,
+	}
+}
+
+type client struct {
+	strikes     int
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+type Tarpit struct {
+	cfg       Config
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+	held      chan struct{}
+}
+
+func New(cfg Config) *Tarpit {
+	return &Tarpit{
+		cfg:     cfg,
+		clients: make(map[string]*client),
+		held:    make(chan struct{}, cfg.MaxHeld),
+	}
+}
+
+// missKey is the context key of the flag Miss sets.
+type missKey struct{}
+
+// Miss counts a request for something that doesn't exist as a strike, like
+// a 404, when the handler answers it some other way, such as asking for
+// credentials so as not to reveal which private repositories exist.
+func Miss(r *http.Request) {
+	if missed, ok := r.Context().Value(missKey{}).(*bool); ok {
+		*missed = true
+	}
+}
+
+// Middleware slow-responds to known exploit paths and counts 404s, and
+// requests handlers report with Miss, as strikes. Clients over the
+// threshold are shadow-banned: every request gets the same delayed 404
+// regardless of whether the repository exists.
+func (t *Tarpit) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientip.FromRequest(r)
+
+		if t.Banned(ip) {
+			bannedTotal.Inc()
+			t.stall(w, r)
+			return
+		}
+
+		if IsProbe(r.URL.Path) {
+			probesTotal.Inc()
+			t.Strike(ip)
+			t.stall(w, r)
+			return
+		}
+
+		var missed bool
+		r = r.WithContext(context.WithValue(r.Context(), missKey{}, &missed))
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == http.StatusNotFound || missed {
+			t.Strike(ip)
+		}
+	})
+}
+
+func IsProbe(path string) bool {
+	lower := strings.ToLower(path)
+	for _, p := range probePrefixes {
+		if strings.HasPrefix(lower, strings.ToLower(p)) {
+			return true
+		}
+	}
+	for _, s := range probeSuffixes {
+		if strings.HasSuffix(lower, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tarpit) Strike(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.sweep(now)
+
+	c, ok := t.clients[ip]
+	if !ok {
+		c = &client{windowStart: now}
+		t.clients[ip] = c
+	}
+	if now.Sub(c.windowStart) > t.cfg.Window {
+		c.strikes = 0
+		c.windowStart = now
+	}
+
+	c.strikes++
+	if c.strikes >= t.cfg.Threshold && now.After(c.bannedUntil) {
+		c.bannedUntil = now.Add(t.cfg.BanDuration)
+		bansTotal.Inc()
+		bannedClients.Inc()
+	}
+}
+
+func (t *Tarpit) Banned(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.clients[ip]
+	return ok && time.Now().Before(c.bannedUntil)
+}
+
+func (t *Tarpit) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+
+	banned := 0
+	for ip, c := range t.clients {
+		if now.Before(c.bannedUntil) {
+			banned++
+			continue
+		}
+		if now.Sub(c.windowStart) > t.cfg.Window {
+			delete(t.clients, ip)
+		}
+	}
+	bannedClients.Set(int64(banned))
+}
+
+// stall holds the connection for the configured delay before answering 404.
+// Once MaxHeld connections are parked, further ones are answered immediately
+// so the tarpit can't be turned against the server itself.
+func (t *Tarpit) stall(w http.ResponseWriter, r *http.Request) {
+	select {
+	case t.held <- struct{}{}:
+		heldGauge.Inc()
+		timer := time.NewTimer(t.cfg.Delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+		}
+		heldGauge.Dec()
+		<-t.held
+	default:
+	}
+
+	http.NotFound(w, r)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,360 @@
+package uploads
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound      = errors.New("upload not found")
+	ErrOffset        = errors.New("offset mismatch")
+	ErrTooLarge      = errors.New("upload exceeds size limit")
+	ErrIncomplete    = errors.New("upload incomplete")
+	ErrChecksum      = errors.New("checksum mismatch")
+	ErrAlreadyExists = errors.New("asset already exists")
+)
+
+type Session struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Repo      string    `json:"repo"`
+	Tag       string    `json:"tag"`
+	Asset     string    `json:"asset"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Instance is the origin sending a replication bundle; release asset
+	// uploads have none.
+	Instance string `json:"instance,omitempty"`
+}
+
+// Manager keeps resumable upload sessions on disk under dir/<id>/ so they
+// survive restarts. Each session has a JSON descriptor and a data file.
+type Manager struct {
+	dir            string
+	maxAssetSize   int64
+	maxReleaseSize int64
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewManager(dir string, maxAssetSize, maxReleaseSize int64) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create uploads dir: %w", err)
+	}
+	return &Manager{
+		dir:            dir,
+		maxAssetSize:   maxAssetSize,
+		maxReleaseSize: maxReleaseSize,
+		locks:          make(map[string]*sync.Mutex),
+	}, nil
+}
+
+func (m *Manager) lock(id string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		m.locks[id] = l
+	}
+	m.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func (m *Manager) sessionDir(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+// Create opens a session for an asset of the declared size and checksum.
+// releaseUsed is the space already taken by the release's other assets.
+func (m *Manager) Create(owner, repo, tag, asset string, size int64, sha string, releaseUsed int64) (*Session, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("size must be positive")
+	}
+	if m.maxAssetSize > 0 && size > m.maxAssetSize {
+		return nil, fmt.Errorf("%w: asset limit is %d bytes", ErrTooLarge, m.maxAssetSize)
+	}
+	if m.maxReleaseSize > 0 && releaseUsed+size+m.pendingFor(owner, repo, tag) > m.maxReleaseSize {
+		return nil, fmt.Errorf("%w: release limit is %d bytes", ErrTooLarge, m.maxReleaseSize)
+	}
+
+	return m.open(&Session{Owner: owner, Repo: repo, Tag: tag, Asset: asset, Size: size, SHA256: sha})
+}
+
+// CreateBundle opens a session for a replication bundle the origin
+// instanceID sends for owner/repo, of the declared size and checksum. The
+// asset size limit applies to it.
+func (m *Manager) CreateBundle(owner, repo, instanceID string, size int64, sha string) (*Session, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("size must be positive")
+	}
+	if m.maxAssetSize > 0 && size > m.maxAssetSize {
+		return nil, fmt.Errorf("%w: bundle limit is %d bytes", ErrTooLarge, m.maxAssetSize)
+	}
+	return m.open(&Session{Owner: owner, Repo: repo, Instance: instanceID, Size: size, SHA256: sha})
+}
+
+// open gives sess an ID and starts it with no data.
+func (m *Manager) open(sess *Session) (*Session, error) {
+	sess.SHA256 = strings.ToLower(sess.SHA256)
+	if decoded, err := hex.DecodeString(sess.SHA256); err != nil || len(decoded) != sha256.Size {
+		return nil, fmt.Errorf("sha256 must be 64 hex characters")
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("generate upload id: %w", err)
+	}
+
+	sess.ID = hex.EncodeToString(idBytes)
+	sess.CreatedAt = time.Now()
+	sess.UpdatedAt = sess.CreatedAt
+
+	if err := os.MkdirAll(m.sessionDir(sess.ID), 0700); err != nil {
+		return nil, fmt.Errorf("create session dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.sessionDir(sess.ID), "data"), nil, 0600); err != nil {
+		return nil, fmt.Errorf("create data file: %w", err)
+	}
+	if err := m.save(sess); err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// pendingFor sums declared sizes of in-flight uploads for the same release,
+// so parallel sessions can't jointly exceed the release limit.
+func (m *Manager) pendingFor(owner, repo, tag string) int64 {
+	sessions, _ := m.list()
+	var total int64
+	for _, s := range sessions {
+		if s.Owner == owner && s.Repo == repo && s.Tag == tag {
+			total += s.Size
+		}
+	}
+	return total
+}
+
+func (m *Manager) Get(id string) (*Session, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.sessionDir(id), "session.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("read session: %w", err)
+	}
+
+	var sess Session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, fmt.Errorf("unmarshal session: %w", err)
+	}
+	return &sess, nil
+}
+
+// Append writes a chunk that must start at the session's current offset. A
+// dropped connection leaves whatever arrived in place, so the client resumes
+// from the offset reported by Get.
+func (m *Manager) Append(id string, offset int64, r io.Reader) (*Session, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	defer m.lock(id)()
+
+	sess, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != sess.Offset {
+		return sess, ErrOffset
+	}
+
+	f, err := os.OpenFile(filepath.Join(m.sessionDir(id), "data"), os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open data file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+
+	remaining := sess.Size - offset
+	n, copyErr := io.Copy(f, io.LimitReader(r, remaining))
+	sess.Offset += n
+	sess.UpdatedAt = time.Now()
+
+	var extra [1]byte
+	overflow := false
+	if copyErr == nil && n == remaining {
+		if k, _ := r.Read(extra[:]); k > 0 {
+			overflow = true
+		}
+	}
+
+	if err := m.save(sess); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return sess, fmt.Errorf("write chunk: %w", copyErr)
+	}
+	if overflow {
+		return sess, fmt.Errorf("%w: chunk extends past declared size", ErrTooLarge)
+	}
+
+	return sess, nil
+}
+
+// Complete verifies the checksum and moves the data into dest.
+func (m *Manager) Complete(id, dest string) (*Session, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	defer m.lock(id)()
+
+	sess, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if sess.Offset != sess.Size {
+		return sess, ErrIncomplete
+	}
+
+	dataPath := filepath.Join(m.sessionDir(id), "data")
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("open data file: %w", err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("hash upload: %w", err)
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != sess.SHA256 {
+		m.remove(id)
+		return sess, ErrChecksum
+	}
+
+	if _, err := os.Stat(dest); err == nil {
+		return sess, ErrAlreadyExists
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("create asset dir: %w", err)
+	}
+	if err := os.Rename(dataPath, dest); err != nil {
+		return nil, fmt.Errorf("store asset: %w", err)
+	}
+
+	m.remove(id)
+	return sess, nil
+}
+
+func (m *Manager) Abort(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	defer m.lock(id)()
+
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	m.remove(id)
+	return nil
+}
+
+// GC removes sessions that have not received data for maxAge.
+func (m *Manager) GC(maxAge time.Duration) int {
+	sessions, err := m.list()
+	if err != nil {
+		log.Printf("upload gc: %v", err)
+		return 0
+	}
+
+	removed := 0
+	for _, sess := range sessions {
+		if time.Since(sess.UpdatedAt) > maxAge {
+			m.remove(sess.ID)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (m *Manager) StartGC(interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if n := m.GC(maxAge); n > 0 {
+				log.Printf("removed %d abandoned upload(s)", n)
+			}
+		}
+	}()
+}
+
+func (m *Manager) list() ([]*Session, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read uploads dir: %w", err)
+	}
+
+	var sessions []*Session
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		sess, err := m.Get(entry.Name())
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, nil
+}
+
+func (m *Manager) save(sess *Session) error {
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal session: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.sessionDir(sess.ID), "session.json"), data, 0600); err != nil {
+		return fmt.Errorf("write session: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) remove(id string) {
+	os.RemoveAll(m.sessionDir(id))
+	m.mu.Lock()
+	delete(m.locks, id)
+	m.mu.Unlock()
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
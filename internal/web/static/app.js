@@ -0,0 +1,339 @@
+// The openhub front end. Every view is rendered from the JSON API; the
+// location hash says which one:
+//
+//   #/                                    repositories
+//   #/login                               sign in with an API token
+//   #/{owner}/{name}[/tree]?ref=&path=    a directory
+//   #/{owner}/{name}/blob?ref=&path=      a file
+//   #/{owner}/{name}/commits?ref=&path=   history
+"use strict";
+
+const tokenKey = "openhub.token";
+const main = document.getElementById("main");
+let user = null;
+
+// el builds an element. Strings among the children become text nodes, so
+// nothing from the API is parsed as HTML except what markup() is given.
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (v === undefined || v === null || v === false) continue;
+    if (k.startsWith("on")) e.addEventListener(k.slice(2), v);
+    else e.setAttribute(k, v);
+  }
+  for (const c of children.flat()) {
+    if (c === undefined || c === null || c === false) continue;
+    e.append(c instanceof Node ? c : document.createTextNode(String(c)));
+  }
+  return e;
+}
+
+async function api(path, params) {
+  const qs = new URLSearchParams();
+  for (const [k, v] of Object.entries(params || {})) {
+    if (v !== undefined && v !== null && v !== "") qs.set(k, v);
+  }
+  const headers = {};
+  const token = localStorage.getItem(tokenKey);
+  if (token) headers["Authorization"] = "Bearer " + token;
+
+  const resp = await fetch("/api/v1" + path + (qs.toString() ? "?" + qs : ""), { headers });
+  let body;
+  try {
+    body = await resp.json();
+  } catch (e) {
+    throw new Error(resp.status + " " + resp.statusText);
+  }
+  if (!resp.ok || body.success === false) {
+    throw new Error(body.error || resp.status + " " + resp.statusText);
+  }
+  return body;
+}
+
+function link(parts, params) {
+  const qs = new URLSearchParams();
+  for (const [k, v] of Object.entries(params || {})) {
+    if (v !== undefined && v !== null && v !== "" && v !== 0) qs.set(k, v);
+  }
+  return "#/" + parts.map(encodeURIComponent).join("/") + (qs.toString() ? "?" + qs : "");
+}
+
+function humanSize(n) {
+  if (n < 1024) return n + " B";
+  if (n < 1024 * 1024) return (n / 1024).toFixed(1) + " KB";
+  return (n / 1024 / 1024).toFixed(1) + " MB";
+}
+
+// markup builds an element from HTML the server rendered, a README or
+// highlighted code, which it has already escaped.
+function markup(tag, attrs, html) {
+  const e = el(tag, attrs);
+  e.innerHTML = html;
+  return e;
+}
+
+function show(...children) {
+  main.replaceChildren(...children.flat());
+}
+
+function showError(err) {
+  show(el("p", { class: "error" }, err.message || String(err)));
+}
+
+// Session
+
+async function loadSession() {
+  user = null;
+  if (localStorage.getItem(tokenKey)) {
+    try {
+      user = (await api("/users/profile")).profile.username;
+    } catch (e) {
+      localStorage.removeItem(tokenKey);
+    }
+  }
+  renderSession();
+}
+
+function renderSession() {
+  const nav = document.getElementById("session");
+  if (user) {
+    nav.replaceChildren(
+      el("span", {}, user),
+      el("a", { href: "#/", onclick: () => { localStorage.removeItem(tokenKey); loadSession(); } }, "Sign out"),
+    );
+  } else {
+    nav.replaceChildren(el("a", { href: "#/login" }, "Sign in"));
+  }
+}
+
+function renderLogin() {
+  const input = el("input", { type: "password", placeholder: "API token", autocomplete: "off", required: true });
+  const error = el("p", { class: "error" });
+  const form = el("form", {
+    class: "login",
+    onsubmit: async (ev) => {
+      ev.preventDefault();
+      localStorage.setItem(tokenKey, input.value.trim());
+      await loadSession();
+      if (user) {
+        location.hash = "#/";
+      } else {
+        error.textContent = "That token wasn't accepted.";
+      }
+    },
+  },
+    el("p", {}, "Sign in with an API token, such as one from ",
+      el("code", {}, "openhub user generate-token"), ". It is kept in this browser until you sign out."),
+    input, error, el("button", { type: "submit" }, "Sign in"));
+  show(el("h1", {}, "Sign in"), el("div", { class: "box" }, form));
+  input.focus();
+}
+
+// Repositories
+
+async function renderRepos() {
+  const data = await api("/repos/list");
+  const repos = data.repos.slice().sort((a, b) =>
+    (a.owner + "/" + a.name).localeCompare(b.owner + "/" + b.name));
+
+  const list = repos.length
+    ? el("ul", {}, repos.map((r) => el("li", {},
+        el("a", { href: link([r.owner, r.name]) }, r.owner + "/" + r.name))))
+    : el("div", { class: "empty" }, "No repositories yet.");
+  show(el("h1", {}, "Repositories"), el("div", { class: "box" }, list));
+}
+
+// A repository
+
+async function renderRepo(owner, name, view, params) {
+  const info = await api("/repos/refs", { owner, name });
+  const ref = params.get("ref") || "";
+  const path = params.get("path") || "";
+
+  const header = [
+    el("h1", {}, el("a", { href: "#/" }, owner),
+      " / ", el("a", { href: link([owner, name]) }, name),
+      info.private && el("span", { class: "private" }, "private")),
+    info.description && el("p", { class: "description" }, info.description),
+    toolbar(owner, name, info, view, ref, path),
+  ];
+
+  let body;
+  switch (view) {
+    case "tree":
+      body = await treeView(owner, name, ref, path);
+      if (!path) body.push(cloneBox(info));
+      break;
+    case "blob":
+      body = await blobView(owner, name, ref, path, params.has("source"));
+      break;
+    case "commits":
+      body = await commitsView(owner, name, ref, path, Number(params.get("skip")) || 0);
+      break;
+    default:
+      throw new Error("unknown view: " + view);
+  }
+  show(header, body);
+}
+
+function toolbar(owner, name, info, view, ref, path) {
+  const select = el("select", {
+    onchange: () => { location.hash = link([owner, name, view === "commits" ? "commits" : "tree"], { ref: select.value }); },
+  });
+  const current = ref || info.default_branch;
+  const group = (label, refs) => refs.length && el("optgroup", { label },
+    refs.map((r) => el("option", { value: r.name, selected: r.name === current }, r.name)));
+  select.append(...[group("Branches", info.branches), group("Tags", info.tags)].filter(Boolean));
+
+  return el("div", { class: "toolbar" },
+    info.branches.length + info.tags.length > 0 && select,
+    el("span", { class: "tabs" },
+      el("a", { href: link([owner, name, "tree"], { ref, path: view === "commits" ? path : "" }), class: view !== "commits" ? "active" : null }, "Code"),
+      el("a", { href: link([owner, name, "commits"], { ref, path: view === "commits" ? path : "" }), class: view === "commits" ? "active" : null }, "Commits")),
+    el("span", { class: "crumbs" }, crumbs(owner, name, ref, path)));
+}
+
+function crumbs(owner, name, ref, path) {
+  if (!path) return [];
+  const parts = path.split("/");
+  const out = [el("a", { href: link([owner, name, "tree"], { ref }) }, name)];
+  parts.forEach((part, i) => {
+    out.push(" / ");
+    if (i === parts.length - 1) {
+      out.push(el("strong", {}, part));
+    } else {
+      out.push(el("a", { href: link([owner, name, "tree"], { ref, path: parts.slice(0, i + 1).join("/") }) }, part));
+    }
+  });
+  return out;
+}
+
+async function treeView(owner, name, ref, path) {
+  let data;
+  try {
+    data = await api("/repos/tree", { owner, name, ref, path });
+  } catch (e) {
+    if (!ref && !path) return [el("div", { class: "box" }, el("div", { class: "empty" }, "This repository is empty."))];
+    throw e;
+  }
+
+  const rows = data.entries.map((e) => {
+    const dir = e.type === "tree";
+    const target = e.type === "commit" ? null : link([owner, name, dir ? "tree" : "blob"], { ref, path: e.path });
+    return el("li", { class: "entry" },
+      el("span", {}, el("span", { class: "icon" }, dir ? "▸" : e.type === "commit" ? "@" : "·"),
+        target ? el("a", { href: target }, e.name) : e.name),
+      el("span", { class: "size" }, e.type === "blob" ? humanSize(e.size) : ""));
+  });
+
+  const [last] = (await api("/repos/commits", { owner, name, ref, path, limit: 1 })).commits;
+  let readme = null;
+  try {
+    readme = await api("/repos/readme", { owner, name, ref, path });
+  } catch (e) {
+    // No README here.
+  }
+
+  return [el("div", { class: "box" },
+    last && el("div", { class: "head commit" },
+      el("span", { class: "sha" }, last.sha.slice(0, 10)),
+      el("span", { class: "subject" }, last.subject), " ",
+      el("span", { class: "meta" }, last.author + ", " + new Date(last.date).toLocaleString())),
+    rows.length ? el("ul", {}, rows) : el("div", { class: "empty" }, "Empty directory.")),
+    readme && el("div", { class: "box" },
+      el("div", { class: "head" }, el("a", { href: link([owner, name, "blob"], { ref, path: readme.entry.path }) }, readme.entry.name)),
+      readme.too_large
+        ? el("div", { class: "empty" }, "This README is too large to show.")
+        : markup("div", { class: "markdown" }, readme.html))];
+}
+
+async function blobView(owner, name, ref, path, source) {
+  let data;
+  try {
+    data = await api("/repos/blob", { owner, name, ref, path });
+  } catch (e) {
+    // Relative links in READMEs don't say whether they name a directory.
+    if (e.message.startsWith("not a file")) {
+      location.replace(link([owner, name, "tree"], { ref, path }));
+      return [];
+    }
+    throw e;
+  }
+
+  const raw = "/api/v1/repos/blob?" + new URLSearchParams({ owner, name, ref: data.commit, path, raw: "true" });
+  const head = el("div", { class: "head" }, humanSize(data.entry.size), " · ",
+    el("a", { href: link([owner, name, "commits"], { ref, path }) }, "History"), " · ",
+    el("a", { href: raw }, "Raw"),
+    data.rendered !== undefined && [" · ", source
+      ? el("a", { href: link([owner, name, "blob"], { ref, path }) }, "Rendered")
+      : el("a", { href: link([owner, name, "blob"], { ref, path, source: 1 }) }, "Source")]);
+
+  let content;
+  if (data.too_large) {
+    content = el("div", { class: "empty" }, "This file is too large to show.");
+  } else if (data.encoding !== "utf-8") {
+    content = el("div", { class: "empty" }, "Binary file not shown.");
+  } else if (data.rendered !== undefined && !source) {
+    content = markup("div", { class: "markdown" }, data.rendered);
+  } else {
+    const lines = data.highlighted || data.content.split("\n");
+    if (lines.length > 1 && lines[lines.length - 1] === "") lines.pop();
+    content = el("pre", { class: "code" }, lines.map((l) =>
+      data.highlighted ? markup("span", {}, l) : el("span", {}, l)));
+  }
+  return [el("div", { class: "box" }, head, content)];
+}
+
+async function commitsView(owner, name, ref, path, skip) {
+  const limit = 30;
+  const data = await api("/repos/commits", { owner, name, ref, path, skip, limit });
+
+  const rows = data.commits.map((c) => el("li", { class: "commit" },
+    el("span", { class: "sha", title: c.sha }, c.sha.slice(0, 10)),
+    el("div", { class: "subject" }, c.subject),
+    el("div", { class: "meta" }, c.author + " committed " + new Date(c.date).toLocaleString())));
+
+  const page = (s, label) => el("a", { href: link([owner, name, "commits"], { ref, path, skip: s }) }, label);
+  return [
+    el("div", { class: "box" },
+      path && el("div", { class: "head" }, "History of ", el("code", {}, path)),
+      rows.length ? el("ul", {}, rows) : el("div", { class: "empty" }, "No commits.")),
+    el("div", { class: "pager" },
+      el("span", {}, skip > 0 && page(Math.max(0, skip - limit), "← Newer")),
+      el("span", {}, data.more && page(skip + limit, "Older →"))),
+  ];
+}
+
+function cloneBox(info) {
+  const field = (label, url) => [
+    el("div", {}, label),
+    el("input", { value: url, readonly: true, onfocus: (ev) => ev.target.select() }),
+  ];
+  return el("div", { class: "box" },
+    el("div", { class: "head" }, "Clone"),
+    el("div", { class: "clone" },
+      field("HTTP", info.clone_url),
+      info.ssh_url && field("SSH", info.ssh_url),
+      el("pre", {}, "git clone " + (info.ssh_url || info.clone_url))));
+}
+
+// Routing
+
+async function route() {
+  const [hashPath, query] = location.hash.replace(/^#\/?/, "").split("?");
+  const parts = hashPath.split("/").filter(Boolean).map(decodeURIComponent);
+  const params = new URLSearchParams(query || "");
+
+  try {
+    if (parts.length === 0 || parts.length === 1) {
+      if (parts[0] === "login") return renderLogin();
+      return await renderRepos();
+    }
+    await renderRepo(parts[0], parts[1], parts[2] || "tree", params);
+  } catch (e) {
+    showError(e);
+  }
+}
+
+window.addEventListener("hashchange", route);
+loadSession().then(route);
@@ -0,0 +1,558 @@
+package webhooks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	// maxDeliveryAttempts bounds retries to an unreachable receiver, backing
+	// off quadratically to roughly an hour and a half in total.
+	maxDeliveryAttempts = 6
+	// maxPayloadCommits caps the commits listed in a push payload.
+	maxPayloadCommits = 20
+	// maxLogEntries is how many delivery attempts are kept per repository.
+	maxLogEntries = 200
+	// maxLoggedResponse caps how much of a receiver's response is logged.
+	maxLoggedResponse = 1024
+	// defaultTimeout bounds each delivery until SetTimeout changes it.
+	defaultTimeout = 15 * time.Second
+
+	userAgent = "openhub-webhooks"
+)
+
+type Storage interface {
+	RepoExists(owner, name string) bool
+	GetMetadata(owner, name string) (storage.Metadata, error)
+	RepoPath(owner, name string) string
+}
+
+// Service delivers spooled repository events to webhooks, signing each
+// payload with the webhook's secret and retrying failed deliveries.
+type Service struct {
+	dir     string
+	baseURL string
+	store   Storage
+	client  *http.Client
+	mu      sync.Mutex
+
+	// timeout bounds each delivery, in nanoseconds.
+	timeout atomic.Int64
+}
+
+// delivery is an event payload waiting to be posted to one webhook. The
+// webhook's URL and secret are looked up when it's sent, so edits and
+// removals apply to retries too.
+type delivery struct {
+	ID          string          `json:"id"`
+	Owner       string          `json:"owner"`
+	Repo        string          `json:"repo"`
+	Hook        string          `json:"hook"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	// RedeliveryOf is the delivery this one resends, if any.
+	RedeliveryOf string `json:"redelivery_of,omitempty"`
+}
+
+// LogEntry records one delivery attempt, with the payload sent so it can be
+// redelivered and the start of the receiver's response.
+type LogEntry struct {
+	Time         time.Time       `json:"time"`
+	Delivery     string          `json:"delivery"`
+	RedeliveryOf string          `json:"redelivery_of,omitempty"`
+	Hook         string          `json:"hook"`
+	Event        string          `json:"event"`
+	Attempt      int             `json:"attempt"`
+	Status       int             `json:"status,omitempty"`
+	LatencyMS    int64           `json:"latency_ms"`
+	Response     string          `json:"response,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+}
+
+// ErrUnknownDelivery is returned when redelivering a delivery that isn't in
+// the repository's log, or whose webhook is gone.
+var ErrUnknownDelivery = errors.New("no such delivery")
+
+// New delivers webhooks for the repositories on storagePath, describing them
+// with URLs rooted at baseURL, the instance's public URL.
+func New(storagePath, baseURL string, store Storage) (*Service, error) {
+	dir := baseDir(storagePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create webhooks dir: %w", err)
+	}
+	s := &Service{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		store:   store,
+		client:  &http.Client{},
+	}
+	s.SetTimeout(defaultTimeout)
+	return s, nil
+}
+
+// SetTimeout changes how long a receiver gets to answer a delivery.
+func (s *Service) SetTimeout(d time.Duration) {
+	s.timeout.Store(int64(d))
+}
+
+// Publish queues an event from the server process.
+func (s *Service) Publish(ev Event) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if err := writeSpool(filepath.Join(s.dir, "events"), ev); err != nil {
+		return fmt.Errorf("spool webhook event: %w", err)
+	}
+	return nil
+}
+
+// QueueStatus is how much work is waiting in the webhook spool.
+type QueueStatus struct {
+	Events     int `json:"events"`
+	Deliveries int `json:"deliveries"`
+}
+
+// QueueStatus counts the events waiting to be turned into deliveries and
+// the deliveries waiting to be sent or retried.
+func (s *Service) QueueStatus() (QueueStatus, error) {
+	events, err := spooled(filepath.Join(s.dir, "events"))
+	if err != nil {
+		return QueueStatus{}, fmt.Errorf("list events: %w", err)
+	}
+	deliveries, err := spooled(filepath.Join(s.dir, "deliveries"))
+	if err != nil {
+		return QueueStatus{}, fmt.Errorf("list deliveries: %w", err)
+	}
+	return QueueStatus{Events: len(events), Deliveries: len(deliveries)}, nil
+}
+
+// Start processes spooled events and deliveries every interval.
+func (s *Service) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			s.processEvents()
+			s.processDeliveries()
+		}
+	}()
+}
+
+func (s *Service) processEvents() {
+	files, err := spooled(filepath.Join(s.dir, "events"))
+	if err != nil {
+		log.Printf("webhooks: list events failed: %v", err)
+		return
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("webhooks: read event failed: %v", err)
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			log.Printf("webhooks: dropping malformed event %s: %v", filepath.Base(file), err)
+		} else if err := s.handleEvent(ev); err != nil {
+			log.Printf("webhooks: %s event for %s/%s failed: %v", ev.Kind, ev.Owner, ev.Repo, err)
+		}
+		os.Remove(file)
+	}
+}
+
+// handleEvent builds the event's payload once and queues a delivery for
+// each webhook subscribed to it.
+func (s *Service) handleEvent(ev Event) error {
+	if !s.store.RepoExists(ev.Owner, ev.Repo) {
+		return nil
+	}
+	meta, err := s.store.GetMetadata(ev.Owner, ev.Repo)
+	if err != nil {
+		return err
+	}
+
+	var hooks []storage.Webhook
+	if ev.Kind == EventPing {
+		for _, h := range meta.Webhooks {
+			if h.ID == ev.Hook {
+				hooks = append(hooks, h)
+			}
+		}
+	} else {
+		hooks = Subscribed(meta.Webhooks, ev.Kind)
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(s.payload(ev, meta))
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		err := writeSpool(filepath.Join(s.dir, "deliveries"), delivery{
+			ID:      NewID(),
+			Owner:   ev.Owner,
+			Repo:    ev.Repo,
+			Hook:    h.ID,
+			Event:   ev.Kind,
+			Payload: payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type payloadRepo struct {
+	Owner    string `json:"owner"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Private  bool   `json:"private"`
+	CloneURL string `json:"clone_url"`
+}
+
+type payloadCommit struct {
+	ID        string `json:"id"`
+	Message   string `json:"message"`
+	Author    string `json:"author"`
+	Email     string `json:"email"`
+	Timestamp string `json:"timestamp"`
+}
+
+func (s *Service) payload(ev Event, meta storage.Metadata) map[string]interface{} {
+	full := ev.Owner + "/" + ev.Repo
+	p := map[string]interface{}{
+		"event": ev.Kind,
+		"time":  ev.Time.UTC().Format(time.RFC3339),
+		"repository": payloadRepo{
+			Owner:    ev.Owner,
+			Name:     ev.Repo,
+			FullName: full,
+			Private:  meta.Private,
+			CloneURL: s.baseURL + "/" + full + ".git",
+		},
+	}
+	if ev.Kind != EventPush {
+		return p
+	}
+
+	created := ev.Old == "" || strings.Trim(ev.Old, "0") == ""
+	deleted := ev.New == "" || strings.Trim(ev.New, "0") == ""
+	p["ref"] = ev.Ref
+	p["before"] = ev.Old
+	p["after"] = ev.New
+	p["created"] = created
+	p["deleted"] = deleted
+	p["pusher"] = ev.User
+	p["total_commits"] = ev.Commits
+	commits := []payloadCommit{}
+	if !deleted && ev.Commits > 0 {
+		commits = s.commits(ev, created)
+	}
+	p["commits"] = commits
+	return p
+}
+
+// commits lists the newest commits a push brought in, up to
+// maxPayloadCommits. Commits already in the repository through other refs
+// aren't counted in ev.Commits, so they're left out too.
+func (s *Service) commits(ev Event, created bool) []payloadCommit {
+	limit := ev.Commits
+	if limit > maxPayloadCommits {
+		limit = maxPayloadCommits
+	}
+	args := []string{"log", "--format=%H%x00%an%x00%ae%x00%aI%x00%B%x1e", fmt.Sprintf("-%d", limit), ev.New}
+	if !created {
+		args = append(args, "^"+ev.Old)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.store.RepoPath(ev.Owner, ev.Repo)
+	out, err := cmd.Output()
+	if err != nil {
+		return []payloadCommit{}
+	}
+
+	commits := []payloadCommit{}
+	for _, record := range strings.Split(string(out), "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x00", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		commits = append(commits, payloadCommit{
+			ID:        fields[0],
+			Author:    fields[1],
+			Email:     fields[2],
+			Timestamp: fields[3],
+			Message:   strings.TrimSpace(fields[4]),
+		})
+	}
+	return commits
+}
+
+func (s *Service) processDeliveries() {
+	files, err := spooled(filepath.Join(s.dir, "deliveries"))
+	if err != nil {
+		log.Printf("webhooks: list deliveries failed: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var d delivery
+		if err := json.Unmarshal(data, &d); err != nil {
+			os.Remove(file)
+			continue
+		}
+		if now.Before(d.NextAttempt) {
+			continue
+		}
+
+		hook, ok := s.hook(d.Owner, d.Repo, d.Hook)
+		if !ok {
+			os.Remove(file)
+			continue
+		}
+
+		d.Attempts++
+		start := time.Now()
+		status, response, err := s.send(hook, d)
+		entry := LogEntry{
+			Time:         time.Now(),
+			Delivery:     d.ID,
+			RedeliveryOf: d.RedeliveryOf,
+			Hook:         d.Hook,
+			Event:        d.Event,
+			Attempt:      d.Attempts,
+			Status:       status,
+			LatencyMS:    time.Since(start).Milliseconds(),
+			Response:     response,
+			Payload:      d.Payload,
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if logErr := s.appendLog(d.Owner, d.Repo, entry); logErr != nil {
+			log.Printf("webhooks: %v", logErr)
+		}
+
+		if err == nil {
+			os.Remove(file)
+			continue
+		}
+		if d.Attempts >= maxDeliveryAttempts {
+			log.Printf("webhooks: giving up delivering %s to %s after %d attempts: %v", d.Event, hook.URL, d.Attempts, err)
+			os.Remove(file)
+			continue
+		}
+		log.Printf("webhooks: delivery of %s to %s failed (attempt %d): %v", d.Event, hook.URL, d.Attempts, err)
+		d.NextAttempt = now.Add(time.Duration(d.Attempts*d.Attempts) * time.Minute)
+		if data, err := json.Marshal(d); err == nil {
+			os.WriteFile(file, data, 0644)
+		}
+	}
+}
+
+// hook returns the webhook a delivery is for, if it still exists and is
+// active.
+func (s *Service) hook(owner, repo, id string) (storage.Webhook, bool) {
+	if !s.store.RepoExists(owner, repo) {
+		return storage.Webhook{}, false
+	}
+	meta, err := s.store.GetMetadata(owner, repo)
+	if err != nil {
+		return storage.Webhook{}, false
+	}
+	for _, h := range meta.Webhooks {
+		if h.ID == id {
+			return h, h.Active
+		}
+	}
+	return storage.Webhook{}, false
+}
+
+// Sign returns the X-OpenHub-Signature-256 header value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send posts a delivery, returning the response status and the start of
+// its body. Anything but a 2xx is a failure.
+func (s *Service) send(hook storage.Webhook, d delivery) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.timeout.Load()))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-OpenHub-Event", d.Event)
+	req.Header.Set("X-OpenHub-Delivery", d.ID)
+	req.Header.Set("X-OpenHub-Hook-ID", hook.ID)
+	if hook.Secret != "" {
+		req.Header.Set("X-OpenHub-Signature-256", Sign(hook.Secret, d.Payload))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body := make([]byte, maxLoggedResponse)
+	n, _ := io.ReadFull(resp.Body, body)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	response := strings.ToValidUTF8(string(body[:n]), "")
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, response, fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return resp.StatusCode, response, nil
+}
+
+func (s *Service) logPath(owner, repo string) string {
+	return filepath.Join(s.dir, "log", owner, repo+".jsonl")
+}
+
+// appendLog records a delivery attempt, trimming the repository's log to
+// its most recent maxLogEntries once it grows to twice that.
+func (s *Service) appendLog(owner, repo string, entry LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.logPath(owner, repo)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create delivery log dir: %w", err)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("open delivery log: %w", err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("write delivery log: %w", err)
+	}
+
+	entries, err := s.readLog(path)
+	if err != nil || len(entries) < 2*maxLogEntries {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, e := range entries[len(entries)-maxLogEntries:] {
+		line, _ := json.Marshal(e)
+		buf.Write(append(line, '\n'))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("trim delivery log: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Service) readLog(path string) ([]LogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open delivery log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 4<<20)
+	for scanner.Scan() {
+		var e LogEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read delivery log: %w", err)
+	}
+	return entries, nil
+}
+
+// Deliveries returns a repository's logged delivery attempts, newest first,
+// optionally only those to one webhook.
+func (s *Service) Deliveries(owner, repo, hook string) ([]LogEntry, error) {
+	s.mu.Lock()
+	entries, err := s.readLog(s.logPath(owner, repo))
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []LogEntry{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if hook == "" || entries[i].Hook == hook {
+			out = append(out, entries[i])
+		}
+	}
+	return out, nil
+}
+
+// Redeliver queues the payload of a logged delivery to be sent to its
+// webhook again, as a new delivery, and returns the new delivery's ID.
+func (s *Service) Redeliver(owner, repo, id string) (string, error) {
+	entries, err := s.Deliveries(owner, repo, "")
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range entries {
+		if e.Delivery != id {
+			continue
+		}
+		if _, ok := s.hook(owner, repo, e.Hook); !ok || len(e.Payload) == 0 {
+			return "", ErrUnknownDelivery
+		}
+		d := delivery{
+			ID:           NewID(),
+			Owner:        owner,
+			Repo:         repo,
+			Hook:         e.Hook,
+			Event:        e.Event,
+			Payload:      e.Payload,
+			RedeliveryOf: id,
+		}
+		if err := writeSpool(filepath.Join(s.dir, "deliveries"), d); err != nil {
+			return "", fmt.Errorf("spool redelivery: %w", err)
+		}
+		return d.ID, nil
+	}
+	return "", ErrUnknownDelivery
+}
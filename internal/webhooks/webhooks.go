@@ -0,0 +1,164 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremytregunna/openhub/internal/storage"
+)
+
+const (
+	// EventPush is sent for every ref a push updates, creates or deletes.
+	EventPush = "push"
+	// EventPing is sent to a webhook when it's added, to check the receiver
+	// is reachable. Every webhook gets it regardless of its events.
+	EventPing = "ping"
+)
+
+// Events are the event types a webhook can subscribe to.
+var Events = []string{EventPush}
+
+// Event is something that happened to a repository, queued for the server
+// to deliver to the repository's webhooks. Events are files in a spool
+// directory so the push hook, which runs in its own process and doesn't know
+// the instance's public URL, can record pushes as well.
+type Event struct {
+	Kind    string    `json:"kind"`
+	Owner   string    `json:"owner"`
+	Repo    string    `json:"repo"`
+	User    string    `json:"user,omitempty"`
+	Ref     string    `json:"ref,omitempty"`
+	Old     string    `json:"old,omitempty"`
+	New     string    `json:"new,omitempty"`
+	Commits int       `json:"commits,omitempty"`
+	Time    time.Time `json:"time"`
+	// Hook limits the event to one webhook, for pings.
+	Hook string `json:"hook,omitempty"`
+}
+
+func baseDir(storagePath string) string {
+	return filepath.Join(storagePath, ".webhooks")
+}
+
+// NewID returns a random identifier for a webhook or delivery.
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Validate checks a webhook's URL and events before it is saved.
+func Validate(h storage.Webhook) error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url must be an absolute http or https URL")
+	}
+	if len(h.Events) == 0 {
+		return fmt.Errorf("webhook needs at least one event")
+	}
+	for _, e := range h.Events {
+		if !known(e) {
+			return fmt.Errorf("unknown webhook event %q (have: %s)", e, strings.Join(Events, ", "))
+		}
+	}
+	return nil
+}
+
+func known(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribed returns the active webhooks that receive kind.
+func Subscribed(hooks []storage.Webhook, kind string) []storage.Webhook {
+	var out []storage.Webhook
+	for _, h := range hooks {
+		if !h.Active {
+			continue
+		}
+		for _, e := range h.Events {
+			if e == kind {
+				out = append(out, h)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Redact returns hooks with their secrets removed, for showing over the API.
+func Redact(hooks []storage.Webhook) []storage.Webhook {
+	out := make([]storage.Webhook, len(hooks))
+	for i, h := range hooks {
+		if h.Secret != "" {
+			h.Secret = "********"
+		}
+		out[i] = h
+	}
+	return out
+}
+
+// writeSpool writes v as a new file in dir. The file only appears once
+// complete, so a reader never sees a partial one.
+func writeSpool(dir string, v interface{}) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), NewID())
+	tmp := filepath.Join(dir, "."+name)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+// spooled lists the complete files in a spool directory, oldest first.
+func spooled(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Enqueue records an event for the server to deliver. Events no webhook of
+// the repository subscribes to are dropped here, so pushes to repositories
+// without webhooks cost nothing.
+func Enqueue(storagePath string, meta storage.Metadata, ev Event) error {
+	if len(Subscribed(meta.Webhooks, ev.Kind)) == 0 {
+		return nil
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if err := writeSpool(filepath.Join(baseDir(storagePath), "events"), ev); err != nil {
+		return fmt.Errorf("spool webhook event: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Package zstd compresses and decompresses streams with the zstd command,
+// the way the rest of openhub drives git. Instances without zstd installed
+// send and accept bundles uncompressed.
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Available reports whether the zstd command is installed.
+func Available() bool {
+	_, err := exec.LookPath("zstd")
+	return err == nil
+}
+
+// Compress returns r compressed. Closing it stops zstd if it is still
+// running.
+func Compress(r io.Reader) (io.ReadCloser, error) {
+	return run(r, "-q", "-c")
+}
+
+// Decompress returns r decompressed. Reading it fails if r isn't valid
+// zstd data. Closing it stops zstd if it is still running.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	return run(r, "-q", "-d", "-c")
+}
+
+type stream struct {
+	cmd    *exec.Cmd
+	out    io.ReadCloser
+	stderr bytes.Buffer
+
+	once sync.Once
+	err  error
+}
+
+func run(r io.Reader, args ...string) (io.ReadCloser, error) {
+	s := &stream{cmd: exec.Command("zstd", args...)}
+	s.cmd.Stdin = r
+	s.cmd.Stderr = &s.stderr
+	// Once zstd exits, don't wait on a stalled r for the rest of its input.
+	s.cmd.WaitDelay = time.Second
+
+	out, err := s.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	if err := s.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start zstd: %w", err)
+	}
+	s.out = out
+	return s, nil
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	n, err := s.out.Read(p)
+	if err == io.EOF {
+		if werr := s.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (s *stream) Close() error {
+	s.cmd.Process.Kill()
+	s.wait()
+	return nil
+}
+
+func (s *stream) wait() error {
+	s.once.Do(func() {
+		if err := s.cmd.Wait(); err != nil {
+			if msg := strings.TrimSpace(s.stderr.String()); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+			s.err = fmt.Errorf("zstd: %w", err)
+		}
+	})
+	return s.err
+}